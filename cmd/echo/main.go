@@ -26,7 +26,8 @@ func main() {
 	tlsConfig := generateTLSConfig()
 
 	listener, err := quic.ListenAddr(*listenAddr, tlsConfig, &quic.Config{
-		MaxIdleTimeout: 60_000_000_000, // 60 seconds in nanoseconds
+		MaxIdleTimeout:  60_000_000_000, // 60 seconds in nanoseconds
+		EnableDatagrams: true,           // Echoed too, for quic-relay bench
 	})
 	if err != nil {
 		log.Fatalf("Failed to listen: %v", err)
@@ -70,6 +71,8 @@ func handleConnection(ctx context.Context, conn *quic.Conn) {
 		conn.RemoteAddr(),
 		conn.ConnectionState().TLS.ServerName)
 
+	go echoDatagrams(ctx, conn)
+
 	for {
 		stream, err := conn.AcceptStream(ctx)
 		if err != nil {
@@ -80,6 +83,17 @@ func handleConnection(ctx context.Context, conn *quic.Conn) {
 	}
 }
 
+// echoDatagrams sends every QUIC datagram back to the client.
+func echoDatagrams(ctx context.Context, conn *quic.Conn) {
+	for {
+		data, err := conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		conn.SendDatagram(data)
+	}
+}
+
 func handleStream(stream *quic.Stream) {
 	defer stream.Close()
 
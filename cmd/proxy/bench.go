@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	"quic-relay/internal/bench"
+)
+
+// runBench implements "quic-relay bench": it opens QUIC connections through a
+// relay to echo backends and reports handshake latency, RTT and loss. It
+// returns the process exit code.
+func runBench(args []string) int {
+	fs := flag.NewFlagSet("bench", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s bench [flags] <relay address>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	var cfg bench.Config
+	fs.IntVar(&cfg.Connections, "n", 10, "Connections to open")
+	fs.Float64Var(&cfg.OpenRate, "open-rate", 0, "Connections opened per second, 0 = all at once")
+	sniFlag := fs.String("sni", "echo.local", "Server names with optional weights, e.g. a.example.com=3,b.example.com")
+	fs.StringVar(&cfg.ALPN, "alpn", "quic-echo", "Application protocol offered to backends")
+	fs.IntVar(&cfg.Rate, "rate", 20, "Datagrams per second per connection, 0 = handshakes only")
+	fs.IntVar(&cfg.Size, "size", 200, fmt.Sprintf("Datagram size in bytes (max %d)", bench.MaxSize))
+	fs.DurationVar(&cfg.Duration, "duration", 10*time.Second, "How long each connection sends")
+	fs.DurationVar(&cfg.HandshakeTimeout, "timeout", 5*time.Second, "Handshake timeout")
+	jsonFlag := fs.Bool("json", false, "Print the report as JSON")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	cfg.Target = fs.Arg(0)
+	snis, err := bench.ParseSNIs(*sniFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid -sni: %v\n", err)
+		return 2
+	}
+	cfg.SNIs = snis
+
+	// Ctrl-C stops sending and reports what was measured so far
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	report, err := bench.Run(ctx, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Bench failed: %v\n", err)
+		return 1
+	}
+	if *jsonFlag {
+		json.NewEncoder(os.Stdout).Encode(report)
+	} else {
+		printBenchReport(report)
+	}
+	if report.Established == 0 {
+		return 1
+	}
+	return 0
+}
+
+func printBenchReport(r *bench.Report) {
+	fmt.Printf("connections:  %d established, %d failed (%v)\n", r.Established, r.Failed, r.Elapsed.Round(time.Millisecond))
+	for _, sni := range slices.Sorted(maps.Keys(r.SNIs)) {
+		st := r.SNIs[sni]
+		fmt.Printf("  %-30s %d connections, %d failed\n", sni, st.Connections, st.Failed)
+	}
+	printLatency("handshake:", r.Handshake)
+	if r.Sent > 0 {
+		printLatency("rtt:", r.RTT)
+		fmt.Printf("datagrams:    %d sent, %d echoed, %d duplicates, %.2f%% loss\n", r.Sent, r.Received, r.Duplicates, r.Loss*100)
+	}
+	printCounts("errors", r.Errors)
+}
+
+func printLatency(title string, l bench.Latency) {
+	if l.Count == 0 {
+		return
+	}
+	fmt.Printf("%-13s min %v  p50 %v  p90 %v  p99 %v  max %v\n", title,
+		round(l.Min), round(l.P50), round(l.P90), round(l.P99), round(l.Max))
+}
+
+// round shortens a latency for display.
+func round(d time.Duration) time.Duration {
+	return d.Round(10 * time.Microsecond)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"quic-relay/internal/doctor"
+	"quic-relay/internal/logging"
+	"quic-relay/internal/proxy"
+)
+
+// runDoctor implements "quic-relay doctor": it checks the host and, given a
+// config, its backends and certificates, and prints how to fix what it finds.
+// It returns 1 if any check failed.
+func runDoctor(args []string) int {
+	fs := flag.NewFlagSet("doctor", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s doctor [flags]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	configFlag := fs.String("config", "", "Config file path or JSON string; without it only the host is checked")
+	timeoutFlag := fs.Duration("timeout", 2*time.Second, "How long to wait for each backend to answer")
+	jsonFlag := fs.Bool("json", false, "Print the results as JSON")
+	fs.Parse(args)
+
+	// Handler logs would interleave with the results
+	if err := logging.Configure(&logging.Config{Level: "error"}); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %v\n", err)
+		return 1
+	}
+
+	opts := doctor.Options{ProbeTimeout: *timeoutFlag}
+	if *configFlag != "" {
+		cfg, _, err := loadConfig(*configFlag)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+			return 1
+		}
+		opts.Config = cfg
+		// Without forwarder and terminator no sockets or listeners are opened
+		chain, err := proxy.ReplayChain(cfg.Handlers)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to build handler chain: %v\n", err)
+			return 1
+		}
+		defer chain.Close()
+		opts.Chain = chain
+	}
+
+	results := doctor.Run(opts)
+	if *jsonFlag {
+		json.NewEncoder(os.Stdout).Encode(results)
+	}
+	failed := false
+	for _, r := range results {
+		if r.Status == doctor.Fail {
+			failed = true
+		}
+		if *jsonFlag {
+			continue
+		}
+		fmt.Printf("[%4s] %-28s %s\n", r.Status, r.Check, r.Detail)
+		if r.Hint != "" {
+			fmt.Printf("       %-28s hint: %s\n", "", r.Hint)
+		}
+	}
+	if failed {
+		return 1
+	}
+	return 0
+}
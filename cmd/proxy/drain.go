@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"quic-relay/internal/proxy"
+)
+
+// drainStatus mirrors the admin API response for a backend.
+type drainStatus struct {
+	Backend  string              `json:"backend"`
+	Draining bool                `json:"draining"`
+	Deadline time.Time           `json:"deadline"`
+	Sessions []proxy.SessionInfo `json:"sessions"`
+}
+
+// runDrainBackend implements "quic-relay drain-backend": it asks a running
+// relay's admin API to stop routing new connections to a backend and shows
+// the sessions still using it. It returns the process exit code.
+func runDrainBackend(args []string) int {
+	fs := flag.NewFlagSet("drain-backend", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s drain-backend [flags] <backend address>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	adminFlag := fs.String("admin", getEnv("QUIC_RELAY_ADMIN", "http://127.0.0.1:9090"), "Admin API URL (env QUIC_RELAY_ADMIN)")
+	tokenFlag := fs.String("token", os.Getenv("QUIC_RELAY_ADMIN_TOKEN"), "Admin API bearer token (env QUIC_RELAY_ADMIN_TOKEN)")
+	deadlineFlag := fs.Duration("deadline", 0, "Close remaining sessions after this long, 0 = never")
+	waitFlag := fs.Bool("wait", false, "Wait until no sessions remain")
+	undoFlag := fs.Bool("undo", false, "Return the backend to rotation")
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	c := adminClient{base: strings.TrimSuffix(*adminFlag, "/"), token: *tokenFlag}
+	path := "/backends/" + url.PathEscape(fs.Arg(0))
+
+	if *undoFlag {
+		if err := c.do(http.MethodDelete, path+"/drain", nil, nil); err != nil {
+			fmt.Fprintf(os.Stderr, "Undrain failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("backend %s back in rotation\n", fs.Arg(0))
+		return 0
+	}
+
+	body := map[string]int{"deadline": int(deadlineFlag.Seconds())}
+	var st drainStatus
+	if err := c.do(http.MethodPost, path+"/drain", body, &st); err != nil {
+		fmt.Fprintf(os.Stderr, "Drain failed: %v\n", err)
+		return 1
+	}
+	printDrainStatus(st)
+
+	for *waitFlag && len(st.Sessions) > 0 {
+		time.Sleep(2 * time.Second)
+		if err := c.do(http.MethodGet, path, nil, &st); err != nil {
+			fmt.Fprintf(os.Stderr, "Status failed: %v\n", err)
+			return 1
+		}
+		fmt.Printf("%d sessions remaining\n", len(st.Sessions))
+	}
+	return 0
+}
+
+func printDrainStatus(st drainStatus) {
+	deadline := "no deadline"
+	if !st.Deadline.IsZero() {
+		deadline = "sessions closed at " + st.Deadline.Local().Format(time.TimeOnly)
+	}
+	fmt.Printf("backend %s draining (%s), %d sessions remaining\n", st.Backend, deadline, len(st.Sessions))
+	for _, s := range st.Sessions {
+		fmt.Printf("  session %-8d %-24s %-30s idle %ds\n", s.ID, s.Client, s.SNI, s.IdleSecs)
+	}
+}
+
+// adminClient calls the admin API of a running relay.
+type adminClient struct {
+	base  string
+	token string
+}
+
+// do sends a request with an optional JSON body and decodes a JSON response into out.
+func (c adminClient) do(method, path string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+	client := http.Client{Timeout: 10 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		var e struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&e)
+		return fmt.Errorf("%s: %s", resp.Status, e.Error)
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
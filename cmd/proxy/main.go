@@ -1,26 +1,56 @@
 package main
 
 import (
+	"context"
+	"expvar"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
+	"quic-relay/internal/admin"
+	"quic-relay/internal/audit"
 	"quic-relay/internal/debug"
+	"quic-relay/internal/dns"
 	"quic-relay/internal/handler"
+	"quic-relay/internal/logging"
+	"quic-relay/internal/metrics"
+	"quic-relay/internal/privacy"
 	"quic-relay/internal/proxy"
+	"quic-relay/internal/systemd"
 )
 
 // Version is set via ldflags at build time
 var Version = "dev"
 
+var logger = logging.For("proxy")
+
 func main() {
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "replay":
+			os.Exit(runReplay(os.Args[2:]))
+		case "bench":
+			os.Exit(runBench(os.Args[2:]))
+		case "doctor":
+			os.Exit(runDoctor(os.Args[2:]))
+		case "drain-backend":
+			os.Exit(runDrainBackend(os.Args[2:]))
+		case "rollback":
+			os.Exit(runRollback(os.Args[2:]))
+		}
+	}
+
 	configFlag := flag.String("config", "", "Config file path or JSON string")
 	debugFlag := flag.Bool("d", false, "Enable debug logging")
 	versionFlag := flag.Bool("version", false, "Print version and exit")
+	cpuHintFlag := flag.Duration("cpu-profile-hint", 0, "Log the per-core packet distribution at this interval (0 = off)")
 	flag.Parse()
 
 	if *versionFlag {
@@ -41,18 +71,73 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	cfg.Log = logConfig(cfg, *debugFlag)
+	if err := logging.Configure(cfg.Log); err != nil {
+		log.Fatalf("Failed to configure logging: %v", err)
+	}
+	if err := privacy.Configure(cfg.Privacy); err != nil {
+		log.Fatalf("Invalid privacy config: %v", err)
+	}
+	if err := audit.Configure(cfg.Audit); err != nil {
+		log.Fatalf("Failed to open audit log: %v", err)
+	}
+	if err := metrics.ConfigureExporters(cfg.Exporters); err != nil {
+		log.Fatalf("Invalid exporters config: %v", err)
+	}
+	if err := handler.SetFeatureFlags(cfg.FeatureFlags); err != nil {
+		log.Fatalf("Invalid feature_flags config: %v", err)
+	}
+	audit.Record(audit.Entry{Actor: "startup", Action: "relay.start", Target: *configFlag, After: cfg})
+
 	// Environment variables as fallback (config takes precedence)
 	if cfg.Listen == "" {
 		cfg.Listen = getEnv("QUIC_RELAY_LISTEN", ":5520")
 	}
 
+	if cfg.BufferPool != nil {
+		handler.ConfigureBufferPool(*cfg.BufferPool)
+	}
+
 	chain, err := handler.BuildChain(cfg.Handlers)
 	if err != nil {
 		log.Fatalf("Failed to build handler chain: %v", err)
 	}
 
-	p := proxy.New(cfg.Listen, chain)
-	p.SetSessionTimeout(cfg.SessionTimeout)
+	p, err := proxy.NewFromConfig(cfg, chain)
+	if err != nil {
+		log.Fatalf("Failed to configure proxy: %v", err)
+	}
+
+	listeners, err := systemd.Listeners()
+	if err != nil {
+		log.Fatalf("Failed to inherit systemd sockets: %v", err)
+	}
+	p.SetListeners(listeners)
+
+	var adminSrv *admin.Server
+	if cfg.Admin != nil {
+		adminSrv, err = admin.NewServer(*cfg.Admin, p)
+		if err != nil {
+			log.Fatalf("Invalid admin config: %v", err)
+		}
+		if err := adminSrv.Start(); err != nil {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
+	}
+
+	if cfg.DebugServer != nil && cfg.DebugServer.Enabled {
+		startDebugServer(*cfg.DebugServer, p)
+	}
+
+	if cfg.DNS != nil {
+		if err := startDNS(*cfg.DNS, cfg.Listen, p); err != nil {
+			log.Fatalf("Failed to start DNS responder: %v", err)
+		}
+	}
+
+	// Cancelled on shutdown to stop the systemd watchdog
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
@@ -62,35 +147,143 @@ func main() {
 			switch sig {
 			case syscall.SIGHUP:
 				if !isFile {
-					log.Println("[proxy] SIGHUP ignored (config is inline JSON, not a file)")
+					logger.Warnf("SIGHUP ignored (config is inline JSON, not a file)")
 					continue
 				}
-				newCfg, _, err := loadConfig(*configFlag)
+				notify(systemd.StateReloading)
+				before := p.Config() // Includes changes made via the admin API since
+				newCfg, err := reload(p, *configFlag, *debugFlag)
+				notify(systemd.StateReady)
+				entry := audit.Entry{Actor: "SIGHUP", Action: "config.reload", Target: *configFlag}
 				if err != nil {
-					log.Printf("[proxy] reload failed: %v", err)
+					logger.Errorf("reload failed: %v", err)
+					entry.Error = err.Error()
+					audit.Record(entry)
 					continue
 				}
-				newChain, err := handler.BuildChain(newCfg.Handlers)
-				if err != nil {
-					log.Printf("[proxy] reload failed: %v", err)
-					continue
+				if diff, err := audit.Diff(before, newCfg); err == nil {
+					entry.Diff = diff
+				}
+				audit.Record(entry)
+				if adminSrv != nil {
+					adminSrv.SnapshotConfig("reload")
 				}
-				p.ReloadChain(newChain)
-				p.SetSessionTimeout(newCfg.SessionTimeout)
-				log.Printf("[proxy] config reloaded, handlers: %v, session_timeout: %ds", handlerNames(newChain), newCfg.SessionTimeout)
 			case syscall.SIGINT, syscall.SIGTERM:
-				log.Println("[proxy] shutting down...")
+				logger.Printf("shutting down...")
+				notify(systemd.StateStopping)
+				cancel()
+				audit.Record(audit.Entry{Actor: sig.String(), Action: "relay.stop"})
+				if *cpuHintFlag > 0 {
+					logger.Printf("%s", proxy.FormatCPUDistribution(p.CPUDistribution()))
+				}
 				p.Stop()
 				return
 			}
 		}
 	}()
 
+	go func() {
+		select {
+		case <-p.Ready():
+		case <-ctx.Done():
+			return
+		}
+		notify(systemd.StateReady)
+		systemd.RunWatchdog(ctx, p.Healthy, func(err error) {
+			logger.Warnf("watchdog ping skipped: %v", err)
+		})
+	}()
+
+	if *cpuHintFlag > 0 {
+		go logCPUDistribution(ctx, p, *cpuHintFlag)
+	}
+
 	if err := p.Run(); err != nil {
 		log.Fatalf("Proxy error: %v", err)
 	}
 }
 
+// reload loads the config again and applies everything that can change at runtime.
+// Nothing is applied unless the whole new config is valid.
+func reload(p *proxy.Proxy, configFlag string, debugEnabled bool) (*proxy.Config, error) {
+	newCfg, _, err := loadConfig(configFlag)
+	if err != nil {
+		return nil, err
+	}
+	newCfg.Log = logConfig(newCfg, debugEnabled)
+	if err := p.Reload(newCfg); err != nil {
+		return nil, err
+	}
+	logger.Printf("config reloaded, handlers: %v, session_timeout: %ds", handlerNames(p.Handlers()), newCfg.SessionTimeout)
+	return newCfg, nil
+}
+
+// logCPUDistribution logs the per-core packet distribution every interval
+// until ctx is cancelled.
+func logCPUDistribution(ctx context.Context, p *proxy.Proxy, interval time.Duration) {
+	select {
+	case <-p.Ready():
+	case <-ctx.Done():
+		return
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			logger.Printf("%s", proxy.FormatCPUDistribution(p.CPUDistribution()))
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// notify reports a state change to systemd. Failures are logged; outside
+// systemd this does nothing.
+func notify(state string) {
+	if _, err := systemd.Notify(state); err != nil {
+		logger.Warnf("sd_notify %s failed: %v", state, err)
+	}
+}
+
+// startDNS starts the DNS responder. Without configured names it answers for
+// the SNIs of the sni-routers in the active chain. Changes to its config
+// require a restart.
+func startDNS(cfg dns.Config, listen string, p *proxy.Proxy) error {
+	_, portStr, _ := net.SplitHostPort(listen)
+	port, _ := strconv.Atoi(portStr)
+	srv, err := dns.NewServer(cfg, port, func() []string {
+		var names []string
+		for _, h := range p.Handlers() {
+			if r, ok := handler.Unwrap(h).(interface{ SNIs() []string }); ok {
+				names = append(names, r.SNIs()...)
+			}
+		}
+		return names
+	})
+	if err != nil {
+		return err
+	}
+	return srv.Start()
+}
+
+// startDebugServer starts the debug listener with proxy-specific endpoints.
+// The debug server is not hot-reloadable; changes require a restart.
+func startDebugServer(cfg debug.ServerConfig, p *proxy.Proxy) {
+	srv := debug.NewServer(cfg)
+	srv.HandleJSON("/debug/sessions", func() any { return p.Sessions() })
+	srv.HandleJSON("/debug/proxy", func() any { return p.Stats() })
+	srv.HandleJSON("/debug/bufpool", func() any { return handler.GetBufferPoolStats() })
+	srv.HandleJSON("/debug/cpu", func() any { return p.CPUDistribution() })
+
+	expvar.Publish("sessions", expvar.Func(func() any { return p.SessionCount() }))
+	expvar.Publish("bufpool", expvar.Func(func() any { return handler.GetBufferPoolStats() }))
+
+	if err := srv.Start(); err != nil {
+		log.Fatalf("Failed to start debug server: %v", err)
+	}
+}
+
 // loadConfig loads config from a file path or parses inline JSON.
 // Returns the config, whether it was loaded from a file, and any error.
 func loadConfig(configFlag string) (*proxy.Config, bool, error) {
@@ -102,11 +295,23 @@ func loadConfig(configFlag string) (*proxy.Config, bool, error) {
 	return cfg, true, err
 }
 
-// handlerNames returns the names of handlers in a chain.
-func handlerNames(chain *handler.Chain) []string {
+// logConfig returns the logging config, forcing debug level when -d is set.
+func logConfig(cfg *proxy.Config, debugEnabled bool) *logging.Config {
+	logCfg := logging.Config{}
+	if cfg.Log != nil {
+		logCfg = *cfg.Log
+	}
+	if debugEnabled {
+		logCfg.Level = "debug"
+	}
+	return &logCfg
+}
+
+// handlerNames returns the names of handlers.
+func handlerNames(handlers []handler.Handler) []string {
 	var names []string
-	for _, h := range chain.Handlers() {
-		names = append(names, h.Name())
+	for _, h := range handlers {
+		names = append(names, handler.DisplayName(h))
 	}
 	return names
 }
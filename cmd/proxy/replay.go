@@ -0,0 +1,171 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"maps"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+
+	"quic-relay/internal/debug"
+	"quic-relay/internal/handler"
+	"quic-relay/internal/logging"
+	"quic-relay/internal/pcap"
+	"quic-relay/internal/proxy"
+)
+
+// runReplay implements "quic-relay replay": it pushes the client traffic of a
+// pcap through the configured handler chain offline and reports the chain's
+// decisions and throughput. It returns the process exit code.
+func runReplay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s replay -config <config> [flags] <capture.pcap>\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	configFlag := fs.String("config", "", "Config file path or JSON string")
+	portsFlag := fs.String("ports", "", "Comma-separated relay ports in the capture (default: listen and extra_listen ports)")
+	speedFlag := fs.Float64("speed", 0, "Replay speed relative to the capture timing, 0 = as fast as possible")
+	jsonFlag := fs.Bool("json", false, "Print decisions and the report as JSON lines")
+	verboseFlag := fs.Bool("v", false, "Print every connection decision and the relay's log")
+	debugFlag := fs.Bool("d", false, "Enable debug logging")
+	fs.Parse(args)
+
+	if *configFlag == "" || fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	if *debugFlag {
+		debug.Enable()
+	}
+
+	cfg, _, err := loadConfig(*configFlag)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to load config: %v\n", err)
+		return 1
+	}
+	// Handler logs drown the report unless asked for
+	logCfg := logConfig(cfg, *debugFlag)
+	logCfg.Output = ""
+	if !*verboseFlag && !*debugFlag {
+		logCfg.Level, logCfg.Levels = "error", nil
+	}
+	if err := logging.Configure(logCfg); err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to configure logging: %v\n", err)
+		return 1
+	}
+	if cfg.Listen == "" {
+		cfg.Listen = getEnv("QUIC_RELAY_LISTEN", ":5520")
+	}
+
+	opts := proxy.ReplayOptions{Speed: *speedFlag}
+	if *portsFlag != "" {
+		for _, s := range strings.Split(*portsFlag, ",") {
+			port, err := strconv.ParseUint(strings.TrimSpace(s), 10, 16)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Invalid port %q\n", s)
+				return 2
+			}
+			opts.Ports = append(opts.Ports, uint16(port))
+		}
+	}
+
+	chain, err := proxy.ReplayChain(cfg.Handlers)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to build handler chain: %v\n", err)
+		return 1
+	}
+	p := proxy.New(cfg.Listen, chain)
+	p.SetExtraListen(cfg.ExtraListen)
+	if err := p.SetProtocols(cfg.Protocols); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid protocol rules: %v\n", err)
+		return 1
+	}
+	if err := p.SetRelayConfig(cfg.Relay); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid relay config: %v\n", err)
+		return 1
+	}
+	if err := p.SetStatelessReset(cfg.StatelessReset); err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid stateless reset config: %v\n", err)
+		return 1
+	}
+
+	f, err := os.Open(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to open capture: %v\n", err)
+		return 1
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", fs.Arg(0), err)
+		return 1
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	switch {
+	case *jsonFlag:
+		opts.OnDecision = func(d proxy.ReplayDecision) { enc.Encode(d) }
+	case *verboseFlag:
+		opts.OnDecision = printDecision
+	}
+
+	report, err := p.Replay(r, opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Replay failed: %v\n", err)
+		return 1
+	}
+	if *jsonFlag {
+		enc.Encode(map[string]any{"report": report})
+	} else {
+		printReport(report, chain)
+	}
+	return 0
+}
+
+func printDecision(d proxy.ReplayDecision) {
+	what := d.SNI
+	if d.Protocol != "" {
+		what = d.Protocol
+	}
+	if !d.Accepted {
+		fmt.Printf("%s %s %q drop: %s\n", d.Time.Format("15:04:05.000"), d.Client, what, d.Reason)
+		return
+	}
+	tenant := ""
+	if d.Tenant != "" {
+		tenant = " tenant=" + d.Tenant
+	}
+	fmt.Printf("%s %s %q -> %s%s\n", d.Time.Format("15:04:05.000"), d.Client, what, d.Backend, tenant)
+}
+
+func printReport(r *proxy.ReplayReport, chain *handler.Chain) {
+	fmt.Printf("handlers:      %v\n", handlerNames(chain.Handlers()))
+	fmt.Printf("datagrams:     %d (%d bytes), %d to other ports, %d non-UDP records\n", r.Datagrams, r.Bytes, r.Ignored, r.Skipped)
+	fmt.Printf("connections:   %d accepted, %d dropped\n", r.Accepted, r.Dropped)
+	fmt.Printf("packet drops:  %d\n", r.PacketDrops)
+	fmt.Printf("responses:     %d\n", r.Responses)
+	fmt.Printf("throughput:    %.0f datagrams/s, %.1f Mbit/s (%v in the relay)\n", r.DatagramsPerSec, r.Mbps, r.Elapsed)
+	printCounts("backends", r.Backends)
+	printCounts("drop reasons", r.DropReasons)
+}
+
+// printCounts prints counters sorted by count, highest first.
+func printCounts(title string, counts map[string]int) {
+	if len(counts) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n", title)
+	keys := slices.SortedFunc(maps.Keys(counts), func(a, b string) int {
+		if counts[a] != counts[b] {
+			return counts[b] - counts[a]
+		}
+		return strings.Compare(a, b)
+	})
+	for _, k := range keys {
+		fmt.Printf("  %6d  %s\n", counts[k], k)
+	}
+}
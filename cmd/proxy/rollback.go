@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"quic-relay/internal/audit"
+)
+
+// snapshotSummary mirrors an entry of the admin API's snapshot list.
+type snapshotSummary struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	Actor    string    `json:"actor"`
+	Handlers int       `json:"handlers"`
+}
+
+// rollbackResult mirrors the admin API's rollback response.
+type rollbackResult struct {
+	Diff     map[string]audit.Change `json:"diff"`
+	Snapshot string                  `json:"snapshot"`
+}
+
+// runRollback implements "quic-relay rollback": without arguments it lists a
+// running relay's config snapshots, with one it applies that snapshot. It
+// returns the process exit code.
+func runRollback(args []string) int {
+	fs := flag.NewFlagSet("rollback", flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s rollback [flags] [snapshot]\n\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	adminFlag := fs.String("admin", getEnv("QUIC_RELAY_ADMIN", "http://127.0.0.1:9090"), "Admin API URL (env QUIC_RELAY_ADMIN)")
+	tokenFlag := fs.String("token", os.Getenv("QUIC_RELAY_ADMIN_TOKEN"), "Admin API bearer token (env QUIC_RELAY_ADMIN_TOKEN)")
+	dryRunFlag := fs.Bool("dry-run", false, "Show what would change without applying it")
+	fs.Parse(args)
+
+	c := adminClient{base: strings.TrimSuffix(*adminFlag, "/"), token: *tokenFlag}
+	switch fs.NArg() {
+	case 0:
+		var list []snapshotSummary
+		if err := c.do(http.MethodGet, "/config/snapshots", nil, &list); err != nil {
+			fmt.Fprintf(os.Stderr, "Listing snapshots failed: %v\n", err)
+			return 1
+		}
+		for _, s := range list {
+			fmt.Printf("%-20s %s  %-12s %2d handlers  %s\n", s.ID, s.Time.Local().Format(time.DateTime), s.Reason, s.Handlers, s.Actor)
+		}
+		return 0
+	case 1:
+	default:
+		fs.Usage()
+		return 2
+	}
+
+	path := "/config/snapshots/" + url.PathEscape(fs.Arg(0)) + "/rollback"
+	if *dryRunFlag {
+		path += "?dry_run=true"
+	}
+	var res rollbackResult
+	if err := c.do(http.MethodPost, path, nil, &res); err != nil {
+		fmt.Fprintf(os.Stderr, "Rollback failed: %v\n", err)
+		return 1
+	}
+	for _, field := range slices.Sorted(maps.Keys(res.Diff)) {
+		ch := res.Diff[field]
+		fmt.Printf("  %s: %v -> %v\n", field, ch.Before, ch.After)
+	}
+	switch {
+	case *dryRunFlag:
+		fmt.Printf("dry run: %d changes, nothing applied\n", len(res.Diff))
+	case len(res.Diff) == 0:
+		fmt.Printf("rolled back to %s, configuration unchanged\n", fs.Arg(0))
+	default:
+		fmt.Printf("rolled back to %s (%d changes), recorded as snapshot %s\n", fs.Arg(0), len(res.Diff), res.Snapshot)
+	}
+	return 0
+}
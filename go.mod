@@ -5,13 +5,15 @@ go 1.25.0
 require (
 	github.com/quic-go/quic-go v0.57.1
 	golang.org/x/crypto v0.46.0
+	golang.org/x/net v0.48.0
+	golang.org/x/sys v0.39.0
 	quic-terminator v0.0.0
 )
 
 require (
 	github.com/klauspost/compress v1.18.2 // indirect
-	golang.org/x/net v0.48.0 // indirect
-	golang.org/x/sys v0.39.0 // indirect
+	github.com/quic-go/qpack v0.6.0 // indirect
+	golang.org/x/text v0.32.0 // indirect
 	protohytale v0.0.0 // indirect
 )
 
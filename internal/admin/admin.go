@@ -0,0 +1,338 @@
+// Package admin implements the HTTP control API for a running proxy.
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+	"quic-relay/internal/logging"
+	"quic-relay/internal/metrics"
+	"quic-relay/internal/proxy"
+)
+
+var logger = logging.For("admin")
+
+// Server serves the admin API. Requests are authenticated by bearer token or
+// client certificate when configured, and checked against the caller's role.
+//
+//	GET    /stats                 proxy counters
+//	GET    /sessions              active sessions
+//	GET    /events                live session events (Server-Sent Events)
+//	GET    /metrics               Prometheus metrics
+//	GET    /sessions/{id}         export a session
+//	POST   /sessions              import an exported session
+//	DELETE /sessions/{id}         terminate a session (close reason admin_kill)
+//	POST   /sessions/{id}/pause   hold back client packets
+//	POST   /sessions/{id}/resume  send held packets and forward again
+//	PUT    /sessions/{id}/backend send client packets to a new backend
+//	POST   /sessions/{id}/migrate pause, call the migration hook, move and resume
+//	GET    /sessions/{id}/trace   recent packets and events of a session
+//	GET    /trace                 recent relay-wide failures
+//	GET    /backends              draining backends
+//	GET    /backends/{addr}       drain state and sessions of a backend
+//	POST   /backends/{addr}/drain stop routing new connections to a backend
+//	DELETE /backends/{addr}/drain return a backend to rotation
+//	GET    /routes                export the route tables of routing handlers
+//	PUT    /routes                replace route tables
+//	PATCH  /routes                add, replace or remove single routes
+//	GET    /config/snapshots      list config snapshots
+//	POST   /config/snapshots      take a config snapshot now
+//	GET    /config/snapshots/{id} a config snapshot
+//	POST   /config/snapshots/{id}/rollback apply a config snapshot
+//	GET    /features              feature flags and their rollout
+//	PUT    /features/{name}       override the share of a feature
+//	DELETE /features/{name}       return a feature to its configured share
+//	*      /handlers/{name}/...   dispatched to handlers implementing handler.AdminHandler
+type Server struct {
+	listen  string
+	proxy   *proxy.Proxy
+	mux     *http.ServeMux
+	handler http.Handler // mux behind authentication
+	tls     *proxy.AdminTLSConfig
+	srv     *http.Server
+
+	migrateHook string // URL called before a session is migrated
+
+	routesMu  sync.Mutex     // Serializes route imports and rollbacks
+	snapshots *snapshotStore // Nil without admin 'snapshots'
+}
+
+// NewServer creates an admin server for p.
+func NewServer(cfg proxy.AdminConfig, p *proxy.Proxy) (*Server, error) {
+	auth, err := newAuthenticator(cfg)
+	if err != nil {
+		return nil, err
+	}
+	s := &Server{
+		listen: cfg.Listen,
+		proxy:  p,
+		mux:    http.NewServeMux(),
+		tls:    cfg.TLS,
+
+		migrateHook: cfg.MigrateHook,
+	}
+	s.handler = auth.wrap(s.mux)
+	if cfg.Snapshots != nil {
+		if s.snapshots, err = newSnapshotStore(cfg.Snapshots, s.relayState); err != nil {
+			return nil, err
+		}
+	}
+	if !auth.enabled {
+		logger.Warnf("admin API has no authentication; bind it to a trusted interface only")
+	} else if cfg.TLS == nil {
+		logger.Warnf("admin API tokens are sent unencrypted; configure 'tls' unless bound to localhost")
+	}
+	s.mux.HandleFunc("GET /stats", s.handleStats)
+	s.mux.HandleFunc("GET /sessions", s.handleSessions)
+	s.mux.HandleFunc("GET /events", s.handleEvents)
+	s.mux.Handle("GET /metrics", metrics.Handler())
+	s.mux.HandleFunc("GET /sessions/{id}", s.handleExportSession)
+	s.mux.HandleFunc("POST /sessions", s.handleImportSession)
+	s.mux.HandleFunc("DELETE /sessions/{id}", s.handleKillSession)
+	s.mux.HandleFunc("POST /sessions/{id}/pause", s.handlePauseSession)
+	s.mux.HandleFunc("POST /sessions/{id}/resume", s.handleResumeSession)
+	s.mux.HandleFunc("PUT /sessions/{id}/backend", s.handleMoveSession)
+	s.mux.HandleFunc("POST /sessions/{id}/migrate", s.handleMigrateSession)
+	s.mux.HandleFunc("GET /sessions/{id}/trace", s.handleSessionTrace)
+	s.mux.HandleFunc("GET /trace", s.handleTrace)
+	s.mux.HandleFunc("GET /backends", s.handleBackends)
+	s.mux.HandleFunc("GET /backends/{addr}", s.handleBackend)
+	s.mux.HandleFunc("POST /backends/{addr}/drain", s.handleDrainBackend)
+	s.mux.HandleFunc("DELETE /backends/{addr}/drain", s.handleUndrainBackend)
+	s.mux.HandleFunc("GET /routes", s.handleExportRoutes)
+	s.mux.HandleFunc("PUT /routes", s.handleImportRoutes)
+	s.mux.HandleFunc("PATCH /routes", s.handleImportRoutes)
+	s.mux.HandleFunc("GET /config/snapshots", s.handleSnapshots)
+	s.mux.HandleFunc("POST /config/snapshots", s.handleTakeSnapshot)
+	s.mux.HandleFunc("GET /config/snapshots/{id}", s.handleSnapshot)
+	s.mux.HandleFunc("POST /config/snapshots/{id}/rollback", s.handleRollback)
+	s.mux.HandleFunc("GET /logging/routes", s.handleRouteLogging)
+	s.mux.HandleFunc("PUT /logging/routes/{route}", s.handleSetRouteLogging)
+	s.mux.HandleFunc("DELETE /logging/routes/{route}", s.handleClearRouteLogging)
+	s.mux.HandleFunc("GET /features", s.handleFeatures)
+	s.mux.HandleFunc("PUT /features/{name}", s.handleSetFeature)
+	s.mux.HandleFunc("DELETE /features/{name}", s.handleClearFeature)
+	s.mux.HandleFunc("/handlers/{name}", s.handleHandler)
+	s.mux.HandleFunc("/handlers/{name}/", s.handleHandler)
+	return s, nil
+}
+
+// Handle registers an additional endpoint on the admin API.
+func (s *Server) Handle(pattern string, h http.Handler) {
+	s.mux.Handle(pattern, h)
+}
+
+// Start begins listening in the background.
+func (s *Server) Start() error {
+	if s.listen == "" {
+		return errors.New("admin 'listen' is required")
+	}
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	if s.tls != nil {
+		tlsCfg, err := serverTLSConfig(s.tls)
+		if err != nil {
+			ln.Close()
+			return err
+		}
+		ln = tls.NewListener(ln, tlsCfg)
+	}
+	s.srv = &http.Server{
+		Handler:           s.handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	logger.Printf("admin API listening on %s", ln.Addr())
+	if s.snapshots != nil {
+		go s.snapshots.run()
+	}
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.Errorf("admin API stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// serverTLSConfig loads the admin certificate and, when configured, the CA
+// that client certificates must be signed by.
+func serverTLSConfig(cfg *proxy.AdminTLSConfig) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(cfg.Cert, cfg.Key)
+	if err != nil {
+		return nil, fmt.Errorf("admin tls: %w", err)
+	}
+	tlsCfg := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if cfg.ClientCA != "" {
+		pem, err := os.ReadFile(cfg.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("admin tls: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("admin tls: no certificates in %s", cfg.ClientCA)
+		}
+		// Bearer tokens remain usable without a client certificate
+		tlsCfg.ClientCAs = pool
+		tlsCfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsCfg, nil
+}
+
+// Shutdown stops the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+	if s.snapshots != nil {
+		s.snapshots.close()
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+func (s *Server) handleStats(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.proxy.Stats())
+}
+
+func (s *Server) handleSessions(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.proxy.Sessions())
+}
+
+func (s *Server) handleKillSession(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid session id")
+		return
+	}
+	entry := audit.Entry{Actor: actor(r), Action: "session.kill", Target: "session/" + r.PathValue("id")}
+	if audit.Enabled() {
+		for _, info := range s.proxy.Sessions() {
+			if info.ID == id {
+				entry.Before = info
+				break
+			}
+		}
+	}
+	if !s.proxy.KillSession(id) {
+		entry.Error = "session not found"
+		audit.Record(entry)
+		WriteError(w, http.StatusNotFound, "session not found")
+		return
+	}
+	audit.Record(entry)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleHandler dispatches to the named handler in the active chain.
+func (s *Server) handleHandler(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	for _, h := range s.proxy.Handlers() {
+		ah, ok := handler.Unwrap(h).(handler.AdminHandler)
+		if !ok || h.Name() != name {
+			continue
+		}
+		r2 := r.Clone(r.Context())
+		r2.URL.Path = strings.TrimPrefix(r.URL.Path, "/handlers/"+name)
+		if r2.URL.Path == "" {
+			r2.URL.Path = "/"
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead || !audit.Enabled() {
+			ah.ServeAdmin(w, r2)
+			return
+		}
+		s.serveAudited(w, r2, ah, actor(r), "handlers/"+name+r2.URL.Path)
+		return
+	}
+	WriteError(w, http.StatusNotFound, "no handler "+strconv.Quote(name)+" with admin support in active chain")
+}
+
+// serveAudited runs a state-changing handler request and records the handler's
+// state (its GET / response) before and after in the audit log.
+func (s *Server) serveAudited(w http.ResponseWriter, r *http.Request, ah handler.AdminHandler, who, target string) {
+	entry := audit.Entry{Actor: who, Action: "handler." + strings.ToLower(r.Method), Target: target}
+	entry.Before = handlerState(ah, r)
+
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	ah.ServeAdmin(rec, r)
+
+	entry.After = handlerState(ah, r)
+	if rec.status >= 400 {
+		entry.Error = http.StatusText(rec.status)
+	}
+	if diff, err := audit.Diff(entry.Before, entry.After); err == nil {
+		entry.Diff = diff
+	}
+	audit.Record(entry)
+}
+
+// handlerState returns the decoded GET / response of a handler, or nil.
+func handlerState(ah handler.AdminHandler, r *http.Request) any {
+	rec := httptest.NewRecorder()
+	get := httptest.NewRequestWithContext(r.Context(), http.MethodGet, "/", nil)
+	ah.ServeAdmin(rec, get)
+	if rec.Code != http.StatusOK {
+		return nil
+	}
+	var state any
+	if json.Unmarshal(rec.Body.Bytes(), &state) != nil {
+		return nil
+	}
+	return state
+}
+
+// statusRecorder captures the status code written by a handler.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// actor identifies who made an admin request: the client address, prefixed by
+// the authenticated caller, or by the X-Audit-User header when a fronting
+// proxy authenticates instead.
+func actor(r *http.Request) string {
+	if id, ok := callerIdentity(r); ok {
+		return id.name + "@" + r.RemoteAddr
+	}
+	if user := r.Header.Get("X-Audit-User"); user != "" {
+		return user + "@" + r.RemoteAddr
+	}
+	return r.RemoteAddr
+}
+
+// WriteJSON writes v as a JSON response.
+func WriteJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		logger.Warnf("encode response failed: %v", err)
+	}
+}
+
+// WriteError writes a JSON error response.
+func WriteError(w http.ResponseWriter, status int, msg string) {
+	WriteJSON(w, status, map[string]string{"error": msg})
+}
@@ -0,0 +1,228 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+func newTestServer(t *testing.T, handlers ...handler.Handler) *Server {
+	t.Helper()
+	p := proxy.New("127.0.0.1:0", handler.NewChain(handlers...))
+	s, err := NewServer(proxy.AdminConfig{Listen: "127.0.0.1:0"}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func serve(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestAdmin_Stats(t *testing.T) {
+	s := newTestServer(t)
+	rec := serve(s, http.MethodGet, "/stats", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"sessions": 0`) {
+		t.Errorf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+}
+
+func TestAdmin_KillUnknownSession(t *testing.T) {
+	s := newTestServer(t)
+	if rec := serve(s, http.MethodDelete, "/sessions/42", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404, got %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodDelete, "/sessions/abc", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_SessionOperations(t *testing.T) {
+	s := newTestServer(t)
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{http.MethodGet, "/sessions/42", "", http.StatusNotFound},
+		{http.MethodPost, "/sessions/42/pause", "", http.StatusNotFound},
+		{http.MethodPost, "/sessions/42/resume", "", http.StatusNotFound},
+		{http.MethodPost, "/sessions/abc/pause", "", http.StatusBadRequest},
+		{http.MethodPut, "/sessions/42/backend", `{"backend": "10.0.0.7:5520"}`, http.StatusNotFound},
+		{http.MethodPut, "/sessions/42/backend", `{}`, http.StatusBadRequest},
+		{http.MethodPost, "/sessions/42/migrate", `{"backend": "10.0.0.7:5520"}`, http.StatusNotFound},
+		{http.MethodPost, "/sessions/42/migrate", `not json`, http.StatusBadRequest},
+		{http.MethodPost, "/sessions", `{"id": 1}`, http.StatusBadRequest},
+		{http.MethodGet, "/sessions/42/trace", "", http.StatusNotFound},
+		{http.MethodGet, "/trace", "", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if rec := serve(s, tt.method, tt.path, tt.body); rec.Code != tt.want {
+			t.Errorf("%s %s: status %d, want %d (%s)", tt.method, tt.path, rec.Code, tt.want, rec.Body)
+		}
+	}
+}
+
+func TestAdmin_DrainBackend(t *testing.T) {
+	s := newTestServer(t)
+	defer handler.UndrainBackend("10.0.0.1:5520")
+
+	rec := serve(s, http.MethodPost, "/backends/10.0.0.1:5520/drain", `{"deadline": 600}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"draining": true`) {
+		t.Fatalf("drain: %d %s", rec.Code, rec.Body)
+	}
+	if !handler.Draining("10.0.0.1:5520") {
+		t.Error("backend not draining")
+	}
+	rec = serve(s, http.MethodGet, "/backends", "")
+	if !strings.Contains(rec.Body.String(), `"backend": "10.0.0.1:5520"`) || !strings.Contains(rec.Body.String(), `"sessions": 0`) {
+		t.Errorf("list: %s", rec.Body)
+	}
+	if rec := serve(s, http.MethodPost, "/backends/10.0.0.1:5520/drain", `{"deadline": -1}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("negative deadline: %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodDelete, "/backends/10.0.0.1:5520/drain", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("undrain: %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodDelete, "/backends/10.0.0.1:5520/drain", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("undrain twice: %d", rec.Code)
+	}
+}
+
+func TestAdmin_RouteLogging(t *testing.T) {
+	s := newTestServer(t)
+	defer handler.ClearRouteLogging("play.example.com")
+
+	rec := serve(s, http.MethodPut, "/logging/routes/play.example.com", `{"level": "debug", "sample": 0.1, "ttl": 600}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"level": "debug"`) {
+		t.Fatalf("set: %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(s, http.MethodGet, "/logging/routes", "")
+	if !strings.Contains(rec.Body.String(), `"route": "play.example.com"`) || !strings.Contains(rec.Body.String(), `"expires"`) {
+		t.Errorf("list: %s", rec.Body)
+	}
+	if rec := serve(s, http.MethodPut, "/logging/routes/play.example.com", `{"level": "loud"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad level: %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodDelete, "/logging/routes/play.example.com", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("clear: %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodDelete, "/logging/routes/play.example.com", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("clear twice: %d", rec.Code)
+	}
+}
+
+func TestAdmin_Features(t *testing.T) {
+	s := newTestServer(t)
+	defer handler.ClearFeatureOverride("new-parser")
+
+	rec := serve(s, http.MethodPut, "/features/new-parser", `{"percent": 5}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"percent": 5`) {
+		t.Fatalf("set: %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(s, http.MethodGet, "/features", "")
+	if !strings.Contains(rec.Body.String(), `"name": "new-parser"`) || !strings.Contains(rec.Body.String(), `"override": 5`) {
+		t.Errorf("list: %s", rec.Body)
+	}
+	if rec := serve(s, http.MethodPut, "/features/new-parser", `{"percent": 150}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("bad percent: %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodDelete, "/features/new-parser", ""); rec.Code != http.StatusNoContent {
+		t.Errorf("clear: %d", rec.Code)
+	}
+	if rec := serve(s, http.MethodDelete, "/features/new-parser", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("clear twice: %d", rec.Code)
+	}
+}
+
+func TestAdmin_HandlerDispatch(t *testing.T) {
+	m, err := handler.NewMaintenanceHandler(nil)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	s := newTestServer(t, m)
+
+	rec := serve(s, http.MethodGet, "/handlers/maintenance", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"enabled":false`) {
+		t.Errorf("unexpected response %d: %s", rec.Code, rec.Body)
+	}
+	if rec := serve(s, http.MethodGet, "/handlers/unknown", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("expected 404 for unknown handler, got %d", rec.Code)
+	}
+}
+
+func TestAdmin_Events(t *testing.T) {
+	s := newTestServer(t)
+	srv := httptest.NewServer(s.mux)
+	defer srv.Close()
+
+	if rec := serve(s, http.MethodGet, "/events?stats=-1", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("expected 400 for invalid interval, got %d", rec.Code)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/events?stats=0", nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "text/event-stream" {
+		t.Errorf("unexpected response %d %q", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+}
+
+func TestAdmin_Audit(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	if err := audit.Configure(&audit.Config{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { audit.Configure(nil) })
+
+	m, err := handler.NewMaintenanceHandler(nil)
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	s := newTestServer(t, m)
+	t.Cleanup(func() { serve(s, http.MethodDelete, "/handlers/maintenance", "") })
+
+	req := httptest.NewRequest(http.MethodPost, "/handlers/maintenance", strings.NewReader(`{"enabled": true}`))
+	req.Header.Set("X-Audit-User", "alice")
+	s.mux.ServeHTTP(httptest.NewRecorder(), req)
+	serve(s, http.MethodGet, "/handlers/maintenance", "") // Reads are not audited
+	serve(s, http.MethodDelete, "/sessions/42", "")
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("records = %d, want 2:\n%s", len(lines), data)
+	}
+	var entry audit.Entry
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Action != "handler.post" || entry.Target != "handlers/maintenance/" || !strings.HasPrefix(entry.Actor, "alice@") {
+		t.Errorf("entry = %+v", entry)
+	}
+	if c, ok := entry.Diff["enabled"]; !ok || c.Before != false || c.After != true {
+		t.Errorf("diff = %v, want enabled false -> true", entry.Diff)
+	}
+	if !strings.Contains(lines[1], `"session.kill"`) || !strings.Contains(lines[1], `"session not found"`) {
+		t.Errorf("kill record = %s", lines[1])
+	}
+}
@@ -0,0 +1,200 @@
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"quic-relay/internal/proxy"
+)
+
+// defaultRoles are the built-in roles. Entries are "METHOD /path"; a trailing *
+// in the path matches any suffix and a * method matches any method.
+var defaultRoles = map[string][]string{
+	"read": {
+		"GET /stats", "GET /sessions*", "GET /backends*", "GET /events", "GET /metrics", "GET /handlers/*",
+		"GET /logging/*", "GET /trace", "GET /features",
+	},
+	"operator": {
+		"GET /stats", "GET /sessions*", "GET /backends*", "GET /events", "GET /metrics", "GET /handlers/*",
+		"GET /logging/*", "GET /trace", "PUT /logging/*", "DELETE /logging/*",
+		"GET /features", "PUT /features/*", "DELETE /features/*",
+		"DELETE /sessions/*", "POST /sessions/*", "PUT /sessions/*", "POST /backends/*", "DELETE /backends/*",
+		"POST /handlers/*", "PUT /handlers/*", "DELETE /handlers/*",
+	},
+	"admin": {"* /*"},
+}
+
+// identity is an authenticated admin API caller.
+type identity struct {
+	name string
+	role string
+}
+
+type identityKey struct{}
+
+// callerIdentity returns the authenticated caller of r, if any.
+func callerIdentity(r *http.Request) (identity, bool) {
+	id, ok := r.Context().Value(identityKey{}).(identity)
+	return id, ok
+}
+
+// rule is one parsed allowlist entry.
+type rule struct {
+	method string // "*" matches any method
+	path   string
+	prefix bool // path ended in *
+}
+
+func parseRule(s string) (rule, error) {
+	method, path, ok := strings.Cut(s, " ")
+	if !ok || method == "" || !strings.HasPrefix(path, "/") {
+		return rule{}, fmt.Errorf("invalid rule %q, want \"METHOD /path\"", s)
+	}
+	r := rule{method: strings.ToUpper(method), path: path}
+	if strings.HasSuffix(path, "*") {
+		r.path, r.prefix = strings.TrimSuffix(path, "*"), true
+	}
+	return r, nil
+}
+
+func (r rule) matches(method, path string) bool {
+	if r.method != "*" && r.method != method {
+		return false
+	}
+	if r.prefix {
+		return strings.HasPrefix(path, r.path) || path+"/" == r.path
+	}
+	return path == r.path
+}
+
+// authenticator checks bearer tokens and client certificates and enforces
+// per-role endpoint allowlists.
+type authenticator struct {
+	enabled     bool
+	tokens      []tokenEntry
+	clientRoles map[string]string
+	roles       map[string][]rule
+}
+
+type tokenEntry struct {
+	hash [sha256.Size]byte
+	id   identity
+}
+
+// newAuthenticator validates the auth settings of cfg.
+func newAuthenticator(cfg proxy.AdminConfig) (*authenticator, error) {
+	a := &authenticator{
+		clientRoles: cfg.ClientRoles,
+		roles:       make(map[string][]rule),
+	}
+	roles := make(map[string][]string, len(defaultRoles)+len(cfg.Roles))
+	for name, entries := range defaultRoles {
+		roles[name] = entries
+	}
+	for name, entries := range cfg.Roles {
+		roles[name] = entries
+	}
+	for name, entries := range roles {
+		for _, e := range entries {
+			r, err := parseRule(e)
+			if err != nil {
+				return nil, fmt.Errorf("role %s: %w", name, err)
+			}
+			a.roles[name] = append(a.roles[name], r)
+		}
+	}
+
+	for i, t := range cfg.Tokens {
+		token := t.Token
+		if t.TokenFile != "" {
+			data, err := os.ReadFile(t.TokenFile)
+			if err != nil {
+				return nil, fmt.Errorf("token %d: %w", i, err)
+			}
+			token = strings.TrimSpace(string(data))
+		}
+		if token == "" {
+			return nil, fmt.Errorf("token %d: missing 'token' or 'token_file'", i)
+		}
+		if _, ok := a.roles[t.Role]; !ok {
+			return nil, fmt.Errorf("token %d: unknown role %q", i, t.Role)
+		}
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("token-%d", i)
+		}
+		a.tokens = append(a.tokens, tokenEntry{hash: sha256.Sum256([]byte(token)), id: identity{name: name, role: t.Role}})
+	}
+
+	hasCA := cfg.TLS != nil && cfg.TLS.ClientCA != ""
+	if len(cfg.ClientRoles) > 0 && !hasCA {
+		return nil, errors.New("'client_roles' requires 'tls.client_ca'")
+	}
+	for cn, role := range cfg.ClientRoles {
+		if _, ok := a.roles[role]; !ok {
+			return nil, fmt.Errorf("client %s: unknown role %q", cn, role)
+		}
+	}
+	a.enabled = len(a.tokens) > 0 || hasCA
+	return a, nil
+}
+
+// identify returns the caller of r from its bearer token or client certificate.
+func (a *authenticator) identify(r *http.Request) (identity, bool) {
+	if token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		hash := sha256.Sum256([]byte(strings.TrimSpace(token)))
+		for _, t := range a.tokens {
+			if subtle.ConstantTimeCompare(hash[:], t.hash[:]) == 1 {
+				return t.id, true
+			}
+		}
+		return identity{}, false
+	}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if role, ok := a.clientRoles[cn]; ok {
+			return identity{name: "cert:" + cn, role: role}, true
+		}
+	}
+	return identity{}, false
+}
+
+// allowed reports whether role may call method on path.
+func (a *authenticator) allowed(role, method, path string) bool {
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	for _, r := range a.roles[role] {
+		if r.matches(method, path) {
+			return true
+		}
+	}
+	return false
+}
+
+// wrap requires an authenticated caller whose role allows the request.
+func (a *authenticator) wrap(next http.Handler) http.Handler {
+	if !a.enabled {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, ok := a.identify(r)
+		if !ok {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="quic-relay"`)
+			WriteError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if !a.allowed(id.role, r.Method, r.URL.Path) {
+			logger.Warnf("%s (role %s) denied %s %s", id.name, id.role, r.Method, r.URL.Path)
+			WriteError(w, http.StatusForbidden, fmt.Sprintf("role %s may not %s %s", id.role, r.Method, r.URL.Path))
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), identityKey{}, id)))
+	})
+}
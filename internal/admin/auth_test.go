@@ -0,0 +1,132 @@
+package admin
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+func newAuthServer(t *testing.T, cfg proxy.AdminConfig) *Server {
+	t.Helper()
+	cfg.Listen = "127.0.0.1:0"
+	s, err := NewServer(cfg, proxy.New("127.0.0.1:0", handler.NewChain()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func serveAs(s *Server, method, path, token string) int {
+	req := httptest.NewRequest(method, path, nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	rec := httptest.NewRecorder()
+	s.handler.ServeHTTP(rec, req)
+	return rec.Code
+}
+
+func TestAuth_Roles(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("op-secret\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newAuthServer(t, proxy.AdminConfig{
+		Tokens: []proxy.AdminToken{
+			{Name: "grafana", Token: "read-secret", Role: "read"},
+			{Name: "oncall", TokenFile: tokenFile, Role: "operator"},
+			{Name: "root", Token: "admin-secret", Role: "admin"},
+			{Name: "kill-only", Token: "kill-secret", Role: "killer"},
+		},
+		Roles: map[string][]string{"killer": {"DELETE /sessions/*"}},
+	})
+
+	tests := []struct {
+		method, path, token string
+		want                int
+	}{
+		{"GET", "/stats", "", http.StatusUnauthorized},
+		{"GET", "/stats", "wrong", http.StatusUnauthorized},
+		{"GET", "/stats", "read-secret", http.StatusOK},
+		{"HEAD", "/sessions", "read-secret", http.StatusOK},
+		{"DELETE", "/sessions/1", "read-secret", http.StatusForbidden},
+		{"POST", "/handlers/maintenance", "read-secret", http.StatusForbidden},
+		{"DELETE", "/sessions/1", "op-secret", http.StatusNotFound}, // Allowed, no such session
+		{"GET", "/stats", "kill-secret", http.StatusForbidden},
+		{"DELETE", "/sessions/1", "kill-secret", http.StatusNotFound},
+		{"DELETE", "/sessions/1", "admin-secret", http.StatusNotFound},
+		{"GET", "/stats", "admin-secret", http.StatusOK},
+	}
+	for _, tt := range tests {
+		if got := serveAs(s, tt.method, tt.path, tt.token); got != tt.want {
+			t.Errorf("%s %s with %q = %d, want %d", tt.method, tt.path, tt.token, got, tt.want)
+		}
+	}
+}
+
+func TestAuth_ClientCertificate(t *testing.T) {
+	dir := t.TempDir()
+	ca := filepath.Join(dir, "ca.pem")
+	if err := os.WriteFile(ca, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	s := newAuthServer(t, proxy.AdminConfig{
+		TLS:         &proxy.AdminTLSConfig{Cert: "unused", Key: "unused", ClientCA: ca},
+		ClientRoles: map[string]string{"dashboard": "read"},
+	})
+
+	request := func(cn, method, path string) int {
+		req := httptest.NewRequest(method, path, nil)
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		req.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		rec := httptest.NewRecorder()
+		s.handler.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	if got := request("dashboard", "GET", "/stats"); got != http.StatusOK {
+		t.Errorf("dashboard GET /stats = %d, want 200", got)
+	}
+	if got := request("dashboard", "DELETE", "/sessions/1"); got != http.StatusForbidden {
+		t.Errorf("dashboard DELETE = %d, want 403", got)
+	}
+	if got := request("intruder", "GET", "/stats"); got != http.StatusUnauthorized {
+		t.Errorf("unknown cert = %d, want 401", got)
+	}
+}
+
+func TestAuth_ConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    proxy.AdminConfig
+		errMsg string
+	}{
+		{"unknown role", proxy.AdminConfig{Tokens: []proxy.AdminToken{{Token: "x", Role: "root"}}}, "unknown role"},
+		{"empty token", proxy.AdminConfig{Tokens: []proxy.AdminToken{{Role: "read"}}}, "missing 'token'"},
+		{"client roles without CA", proxy.AdminConfig{ClientRoles: map[string]string{"a": "read"}}, "requires 'tls.client_ca'"},
+		{"bad rule", proxy.AdminConfig{Roles: map[string][]string{"x": {"stats"}}}, "invalid rule"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewServer(tt.cfg, proxy.New("127.0.0.1:0", handler.NewChain()))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestAuth_NoAuthConfigured(t *testing.T) {
+	s := newAuthServer(t, proxy.AdminConfig{})
+	if got := serveAs(s, "DELETE", "/sessions/1", ""); got != http.StatusNotFound {
+		t.Errorf("DELETE without auth config = %d, want 404", got)
+	}
+}
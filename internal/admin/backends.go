@@ -0,0 +1,88 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+// backendStatus is the response of the backend endpoints.
+type backendStatus struct {
+	handler.DrainInfo
+	Draining bool                `json:"draining"`
+	Sessions []proxy.SessionInfo `json:"sessions"` // Sessions still routed to the backend
+}
+
+// drainSummary is one entry of GET /backends.
+type drainSummary struct {
+	handler.DrainInfo
+	Sessions int `json:"sessions"`
+}
+
+// drainRequest is the optional body of POST /backends/{addr}/drain.
+type drainRequest struct {
+	Deadline int `json:"deadline,omitempty"` // Seconds until remaining sessions are closed (0 = never)
+}
+
+func (s *Server) backendStatus(addr string) backendStatus {
+	st := backendStatus{Sessions: s.proxy.BackendSessions(addr)}
+	st.DrainInfo, st.Draining = handler.DrainStatus(addr)
+	st.Backend = addr
+	return st
+}
+
+// handleBackends lists the draining backends and how many sessions each still has.
+func (s *Server) handleBackends(w http.ResponseWriter, r *http.Request) {
+	list := []drainSummary{}
+	for _, info := range handler.DrainingBackends() {
+		list = append(list, drainSummary{DrainInfo: info, Sessions: len(s.proxy.BackendSessions(info.Backend))})
+	}
+	WriteJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleBackend(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, s.backendStatus(r.PathValue("addr")))
+}
+
+// handleDrainBackend takes a backend out of rotation. Routers stop sending new
+// connections to it; existing sessions continue until they end or the deadline.
+func (s *Server) handleDrainBackend(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("addr")
+	var req drainRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSessionBody)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		WriteError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	if req.Deadline < 0 {
+		WriteError(w, http.StatusBadRequest, "deadline must not be negative")
+		return
+	}
+	var deadline time.Time
+	if req.Deadline > 0 {
+		deadline = time.Now().Add(time.Duration(req.Deadline) * time.Second)
+	}
+	info := handler.DrainBackend(addr, deadline)
+	logger.Printf("backend %s draining (admin)", addr)
+	audit.Record(audit.Entry{Actor: actor(r), Action: "backend.drain", Target: "backend/" + addr, After: info})
+	WriteJSON(w, http.StatusOK, s.backendStatus(addr))
+}
+
+func (s *Server) handleUndrainBackend(w http.ResponseWriter, r *http.Request) {
+	addr := r.PathValue("addr")
+	entry := audit.Entry{Actor: actor(r), Action: "backend.undrain", Target: "backend/" + addr}
+	if !handler.UndrainBackend(addr) {
+		entry.Error = "backend not draining"
+		audit.Record(entry)
+		WriteError(w, http.StatusNotFound, "backend not draining")
+		return
+	}
+	logger.Printf("backend %s back in rotation (admin)", addr)
+	audit.Record(entry)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,94 @@
+package admin
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"quic-relay/internal/proxy"
+)
+
+// keepaliveInterval bounds the silence on an event stream so proxies and
+// clients don't time out the connection.
+const keepaliveInterval = 15 * time.Second
+
+// handleEvents streams session events as Server-Sent Events:
+// open and close events as they happen, plus counters of every active
+// session each "stats" seconds (default 5, 0 disables).
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	interval := 5 * time.Second
+	if v := r.URL.Query().Get("stats"); v != "" {
+		secs, err := strconv.Atoi(v)
+		if err != nil || secs < 0 {
+			WriteError(w, http.StatusBadRequest, "invalid stats interval")
+			return
+		}
+		interval = time.Duration(secs) * time.Second
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		WriteError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	sub := s.proxy.Subscribe()
+	defer sub.Close()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	var statsC <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		statsC = ticker.C
+	}
+	keepalive := time.NewTicker(keepaliveInterval)
+	defer keepalive.Stop()
+
+	var reported uint64
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-sub.C:
+			if writeEvent(w, ev.Type, ev) != nil {
+				return
+			}
+		case <-statsC:
+			now := time.Now()
+			for _, info := range s.proxy.Sessions() {
+				if writeEvent(w, proxy.EventStats, proxy.Event{Type: proxy.EventStats, Time: now, Session: info}) != nil {
+					return
+				}
+			}
+		case <-keepalive.C:
+			if _, err := fmt.Fprint(w, ": keepalive\n\n"); err != nil {
+				return
+			}
+		}
+
+		// Tell the subscriber when it fell behind and missed events
+		if dropped := sub.Dropped(); dropped != reported {
+			if writeEvent(w, "dropped", map[string]uint64{"dropped": dropped - reported}) != nil {
+				return
+			}
+			reported = dropped
+		}
+		flusher.Flush()
+	}
+}
+
+// writeEvent writes one Server-Sent Event with v as JSON data.
+func writeEvent(w http.ResponseWriter, typ string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", typ, data)
+	return err
+}
@@ -0,0 +1,53 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+)
+
+// handleFeatures lists the feature flags and their rollout.
+func (s *Server) handleFeatures(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, handler.FeatureFlags())
+}
+
+// handleSetFeature overrides the share of new connections a feature is on
+// for, so a rollout can be widened or rolled back without a reload.
+func (s *Server) handleSetFeature(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	entry := audit.Entry{Actor: actor(r), Action: "feature.set", Target: "feature/" + name}
+	var cfg handler.FeatureFlag
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSessionBody)).Decode(&cfg); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	st, err := handler.SetFeatureOverride(name, cfg)
+	if err != nil {
+		entry.Error = err.Error()
+		audit.Record(entry)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Printf("feature %s set to %g%% of new connections (admin)", st.Name, st.Percent)
+	entry.After = st
+	audit.Record(entry)
+	WriteJSON(w, http.StatusOK, st)
+}
+
+// handleClearFeature returns a feature to the share of the config file.
+func (s *Server) handleClearFeature(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("name")
+	entry := audit.Entry{Actor: actor(r), Action: "feature.clear", Target: "feature/" + name}
+	if !handler.ClearFeatureOverride(name) {
+		entry.Error = "no override for feature"
+		audit.Record(entry)
+		WriteError(w, http.StatusNotFound, "no override for feature")
+		return
+	}
+	logger.Printf("feature %s override removed (admin)", name)
+	audit.Record(entry)
+	w.WriteHeader(http.StatusNoContent)
+}
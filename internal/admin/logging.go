@@ -0,0 +1,52 @@
+package admin
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+)
+
+// handleRouteLogging lists the per-route logging overrides.
+func (s *Server) handleRouteLogging(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, handler.RouteLogging())
+}
+
+// handleSetRouteLogging turns on verbose logging or packet sampling for one
+// route, so a single tenant can be debugged without raising the global level.
+func (s *Server) handleSetRouteLogging(w http.ResponseWriter, r *http.Request) {
+	route := r.PathValue("route")
+	entry := audit.Entry{Actor: actor(r), Action: "logging.route", Target: "route/" + route}
+	var cfg handler.RouteLogConfig
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSessionBody)).Decode(&cfg); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+	st, err := handler.SetRouteLogging(route, cfg)
+	if err != nil {
+		entry.Error = err.Error()
+		audit.Record(entry)
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	logger.Printf("logging override for %s: level=%q sample=%g (admin)", st.Route, st.Level, st.Sample)
+	entry.After = st
+	audit.Record(entry)
+	WriteJSON(w, http.StatusOK, st)
+}
+
+func (s *Server) handleClearRouteLogging(w http.ResponseWriter, r *http.Request) {
+	route := r.PathValue("route")
+	entry := audit.Entry{Actor: actor(r), Action: "logging.route.clear", Target: "route/" + route}
+	if !handler.ClearRouteLogging(route) {
+		entry.Error = "no override for route"
+		audit.Record(entry)
+		WriteError(w, http.StatusNotFound, "no override for route")
+		return
+	}
+	logger.Printf("logging override for %s removed (admin)", route)
+	audit.Record(entry)
+	w.WriteHeader(http.StatusNoContent)
+}
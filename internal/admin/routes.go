@@ -0,0 +1,294 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+)
+
+// maxRoutesBody limits route imports, large enough for thousands of routes.
+const maxRoutesBody = 16 << 20
+
+// routeHandlerTypes are the handler types whose config holds a "routes" table.
+var routeHandlerTypes = []string{"sni-router", "protocol-router"}
+
+// routeTables maps a routing handler of the active chain to its routes, in
+// config form. Handlers are keyed by type, with "#2", "#3", ... appended for
+// further handlers of the same type.
+type routeTables map[string]map[string]json.RawMessage
+
+// routeChanges lists the routes an import added, changed and removed.
+type routeChanges struct {
+	Added   []string `json:"added,omitempty"`
+	Changed []string `json:"changed,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// routeHandlerKeys returns the routeTables key of each routing handler config,
+// by index in configs.
+func routeHandlerKeys(configs []handler.HandlerConfig) map[int]string {
+	keys := make(map[int]string)
+	seen := make(map[string]int)
+	for i, cfg := range configs {
+		if !slices.Contains(routeHandlerTypes, cfg.Type) {
+			continue
+		}
+		seen[cfg.Type]++
+		key := cfg.Type
+		if n := seen[cfg.Type]; n > 1 {
+			key += "#" + strconv.Itoa(n)
+		}
+		keys[i] = key
+	}
+	return keys
+}
+
+// exportRoutes returns the route tables of configs.
+func exportRoutes(configs []handler.HandlerConfig) (routeTables, error) {
+	tables := make(routeTables)
+	for i, key := range routeHandlerKeys(configs) {
+		var cfg struct {
+			Routes map[string]json.RawMessage `json:"routes"`
+		}
+		if err := json.Unmarshal(configs[i].Config, &cfg); err != nil {
+			return nil, fmt.Errorf("%s: %w", key, err)
+		}
+		tables[key] = cfg.Routes
+	}
+	return tables, nil
+}
+
+// applyRoutes returns configs with the route tables of update applied. With
+// merge, routes are added or replaced one by one and null values remove
+// them; otherwise each table in update replaces the handler's routes.
+func applyRoutes(configs []handler.HandlerConfig, update routeTables, merge bool) ([]handler.HandlerConfig, map[string]*routeChanges, error) {
+	keys := routeHandlerKeys(configs)
+	known := slices.Collect(maps.Values(keys))
+	for key := range update {
+		if !slices.Contains(known, key) {
+			return nil, nil, fmt.Errorf("no routing handler %q in active chain", key)
+		}
+	}
+
+	out := slices.Clone(configs)
+	changes := make(map[string]*routeChanges)
+	for i, key := range keys {
+		table, ok := update[key]
+		if !ok {
+			continue
+		}
+		var cfg map[string]json.RawMessage
+		if err := json.Unmarshal(out[i].Config, &cfg); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", key, err)
+		}
+		var old map[string]json.RawMessage
+		if err := json.Unmarshal(cfg["routes"], &old); err != nil {
+			return nil, nil, fmt.Errorf("%s: %w", key, err)
+		}
+
+		routes := maps.Clone(old)
+		if !merge {
+			routes = make(map[string]json.RawMessage, len(table))
+		}
+		for name, raw := range table {
+			if merge && bytes.Equal(bytes.TrimSpace(raw), []byte("null")) {
+				delete(routes, name)
+				continue
+			}
+			routes[name] = raw
+		}
+
+		ch := &routeChanges{}
+		for _, name := range slices.Sorted(maps.Keys(routes)) {
+			prev, existed := old[name]
+			switch {
+			case !existed:
+				ch.Added = append(ch.Added, name)
+			case !jsonEqual(prev, routes[name]):
+				ch.Changed = append(ch.Changed, name)
+			}
+		}
+		for _, name := range slices.Sorted(maps.Keys(old)) {
+			if _, ok := routes[name]; !ok {
+				ch.Removed = append(ch.Removed, name)
+			}
+		}
+		changes[key] = ch
+
+		raw, err := json.Marshal(routes)
+		if err != nil {
+			return nil, nil, err
+		}
+		cfg["routes"] = raw
+		if out[i].Config, err = json.Marshal(cfg); err != nil {
+			return nil, nil, err
+		}
+	}
+	return out, changes, nil
+}
+
+// jsonEqual reports whether a and b hold the same JSON value.
+func jsonEqual(a, b json.RawMessage) bool {
+	var va, vb any
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return false
+	}
+	ca, _ := json.Marshal(va)
+	cb, _ := json.Marshal(vb)
+	return bytes.Equal(ca, cb)
+}
+
+// handleExportRoutes returns the route tables of the active chain as JSON,
+// or as YAML with ?format=yaml.
+func (s *Server) handleExportRoutes(w http.ResponseWriter, r *http.Request) {
+	configs := s.proxy.HandlerConfigs()
+	if configs == nil {
+		WriteError(w, http.StatusConflict, "active chain was not built from config")
+		return
+	}
+	tables, err := exportRoutes(configs)
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	switch r.URL.Query().Get("format") {
+	case "", "json":
+		WriteJSON(w, http.StatusOK, tables)
+	case "yaml":
+		raw, _ := json.Marshal(tables)
+		dec := json.NewDecoder(bytes.NewReader(raw))
+		dec.UseNumber()
+		var v any
+		if err := dec.Decode(&v); err != nil {
+			WriteError(w, http.StatusInternalServerError, err.Error())
+			return
+		}
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write(appendYAML(nil, v, ""))
+	default:
+		WriteError(w, http.StatusBadRequest, "format must be json or yaml")
+	}
+}
+
+// handleImportRoutes replaces (PUT) or patches (PATCH) route tables. The new
+// handler chain is built before anything is applied, so an invalid route
+// rejects the whole import. With ?dry_run=true it is only validated.
+func (s *Server) handleImportRoutes(w http.ResponseWriter, r *http.Request) {
+	merge := r.Method == http.MethodPatch
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	entry := audit.Entry{Actor: actor(r), Action: "routes." + strings.ToLower(r.Method), Target: "routes"}
+
+	var update routeTables
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRoutesBody)).Decode(&update); err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid body: "+err.Error())
+		return
+	}
+
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	configs := s.proxy.HandlerConfigs()
+	if configs == nil {
+		WriteError(w, http.StatusConflict, "active chain was not built from config")
+		return
+	}
+	newConfigs, changes, err := applyRoutes(configs, update, merge)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	chain, err := handler.BuildChain(newConfigs)
+	if err != nil {
+		if !dryRun {
+			entry.Error = err.Error()
+			audit.Record(entry)
+		}
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if dryRun {
+		chain.Close()
+		WriteJSON(w, http.StatusOK, map[string]any{"dry_run": true, "changes": changes})
+		return
+	}
+	s.proxy.ReloadChain(chain)
+
+	if audit.Enabled() {
+		entry.Before, _ = exportRoutes(configs)
+		entry.After, _ = exportRoutes(newConfigs)
+		if diff, err := audit.Diff(entry.Before, entry.After); err == nil {
+			entry.Diff = diff
+		}
+	}
+	audit.Record(entry)
+	if s.snapshots != nil {
+		if _, err := s.snapshots.take(entry.Action, entry.Actor, true); err != nil {
+			logger.Warnf("config snapshot failed: %v", err)
+		}
+	}
+	for _, key := range slices.Sorted(maps.Keys(changes)) {
+		ch := changes[key]
+		logger.Printf("%s routes updated: %d added, %d changed, %d removed (admin)", key, len(ch.Added), len(ch.Changed), len(ch.Removed))
+	}
+	WriteJSON(w, http.StatusOK, map[string]any{"dry_run": false, "changes": changes})
+}
+
+// appendYAML renders a JSON value decoded with UseNumber as block-style YAML,
+// each line prefixed by indent. Strings and keys are written in JSON's double
+// quoted form, which YAML reads the same way.
+func appendYAML(b []byte, v any, indent string) []byte {
+	switch v := v.(type) {
+	case map[string]any:
+		for _, k := range slices.Sorted(maps.Keys(v)) {
+			b = append(b, indent...)
+			b = appendYAMLString(b, k)
+			b = append(b, ':')
+			b = appendYAMLValue(b, v[k], indent)
+		}
+	case []any:
+		for _, e := range v {
+			b = append(b, indent...)
+			b = append(b, '-')
+			b = appendYAMLValue(b, e, indent)
+		}
+	}
+	return b
+}
+
+// appendYAMLValue writes the value of a mapping entry or sequence item:
+// scalars and empty collections on the same line, others as a nested block.
+func appendYAMLValue(b []byte, v any, indent string) []byte {
+	switch v := v.(type) {
+	case map[string]any:
+		if len(v) == 0 {
+			return append(b, " {}\n"...)
+		}
+		return appendYAML(append(b, '\n'), v, indent+"  ")
+	case []any:
+		if len(v) == 0 {
+			return append(b, " []\n"...)
+		}
+		return appendYAML(append(b, '\n'), v, indent+"  ")
+	case string:
+		return append(appendYAMLString(append(b, ' '), v), '\n')
+	case json.Number:
+		return append(append(append(b, ' '), v...), '\n')
+	case bool:
+		return append(strconv.AppendBool(append(b, ' '), v), '\n')
+	default:
+		return append(b, " null\n"...)
+	}
+}
+
+func appendYAMLString(b []byte, s string) []byte {
+	raw, _ := json.Marshal(s)
+	return append(b, raw...)
+}
@@ -0,0 +1,107 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+func newRoutesTestServer(t *testing.T) (*Server, *proxy.Proxy) {
+	t.Helper()
+	chain, err := handler.BuildChain([]handler.HandlerConfig{
+		{Type: "sni-router", Config: json.RawMessage(`{"routes": {"a.example.com": "10.0.0.1:5520", "b.example.com": ["10.0.0.2:5520"]}}`)},
+		{Type: "forwarder"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := proxy.New("127.0.0.1:0", chain)
+	s, err := NewServer(proxy.AdminConfig{Listen: "127.0.0.1:0"}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, p
+}
+
+func TestAdmin_ExportRoutes(t *testing.T) {
+	s, _ := newRoutesTestServer(t)
+
+	rec := serve(s, http.MethodGet, "/routes", "")
+	var tables map[string]map[string]any
+	if err := json.Unmarshal(rec.Body.Bytes(), &tables); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("export: %d %s", rec.Code, rec.Body)
+	}
+	if got := tables["sni-router"]["a.example.com"]; got != "10.0.0.1:5520" {
+		t.Errorf("a.example.com = %v", got)
+	}
+
+	rec = serve(s, http.MethodGet, "/routes?format=yaml", "")
+	want := "\"sni-router\":\n  \"a.example.com\": \"10.0.0.1:5520\"\n  \"b.example.com\":\n    - \"10.0.0.2:5520\"\n"
+	if rec.Body.String() != want {
+		t.Errorf("yaml export = %q, want %q", rec.Body, want)
+	}
+}
+
+func TestAdmin_ImportRoutes(t *testing.T) {
+	s, p := newRoutesTestServer(t)
+	route := func(sni string) string {
+		t.Helper()
+		ctx := &handler.Context{Hello: &handler.ClientHello{SNI: sni}}
+		p.Handlers()[0].OnConnect(ctx)
+		return ctx.GetString("backend")
+	}
+
+	// An invalid route rejects the whole patch
+	rec := serve(s, http.MethodPatch, "/routes", `{"sni-router": {"c.example.com": "10.0.0.3:5520", "d.example.com": {"backends": []}}}`)
+	if rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid patch: %d %s", rec.Code, rec.Body)
+	}
+	if got := route("c.example.com"); got != "" {
+		t.Errorf("c.example.com routed to %q after rejected patch", got)
+	}
+
+	rec = serve(s, http.MethodPatch, "/routes?dry_run=true", `{"sni-router": {"c.example.com": "10.0.0.3:5520"}}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"dry_run": true`) || route("c.example.com") != "" {
+		t.Fatalf("dry run: %d %s", rec.Code, rec.Body)
+	}
+
+	rec = serve(s, http.MethodPatch, "/routes", `{"sni-router": {"c.example.com": "10.0.0.3:5520", "a.example.com": "10.0.0.9:5520", "b.example.com": null}}`)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("patch: %d %s", rec.Code, rec.Body)
+	}
+	var resp struct {
+		Changes map[string]routeChanges `json:"changes"`
+	}
+	json.Unmarshal(rec.Body.Bytes(), &resp)
+	ch := resp.Changes["sni-router"]
+	if strings.Join(ch.Added, ",") != "c.example.com" || strings.Join(ch.Changed, ",") != "a.example.com" || strings.Join(ch.Removed, ",") != "b.example.com" {
+		t.Errorf("changes = %+v", ch)
+	}
+	if got := route("c.example.com"); got != "10.0.0.3:5520" {
+		t.Errorf("c.example.com = %q", got)
+	}
+	if got := route("b.example.com"); got != "" {
+		t.Errorf("removed b.example.com routed to %q", got)
+	}
+
+	// PUT replaces the table
+	rec = serve(s, http.MethodPut, "/routes", `{"sni-router": {"z.example.com": "10.0.0.26:5520"}}`)
+	if rec.Code != http.StatusOK || route("z.example.com") != "10.0.0.26:5520" || route("a.example.com") != "" {
+		t.Errorf("put: %d %s", rec.Code, rec.Body)
+	}
+
+	if rec := serve(s, http.MethodPut, "/routes", `{"protocol-router": {}}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown handler: %d", rec.Code)
+	}
+}
+
+func TestAdmin_RoutesWithoutConfig(t *testing.T) {
+	s := newTestServer(t)
+	if rec := serve(s, http.MethodGet, "/routes", ""); rec.Code != http.StatusConflict {
+		t.Errorf("export from NewChain: %d", rec.Code)
+	}
+}
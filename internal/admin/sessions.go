@@ -0,0 +1,234 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/debug"
+	"quic-relay/internal/proxy"
+)
+
+// hookTimeout bounds a call to the migration hook.
+const hookTimeout = 10 * time.Second
+
+// maxSessionBody limits request bodies of the session endpoints.
+const maxSessionBody = 64 << 10
+
+// sessionID parses the {id} path value, writing an error response if invalid.
+func sessionID(w http.ResponseWriter, r *http.Request) (uint64, bool) {
+	id, err := strconv.ParseUint(r.PathValue("id"), 10, 64)
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, "invalid session id")
+		return 0, false
+	}
+	return id, true
+}
+
+// sessionError writes the response for a failed session operation.
+func sessionError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, proxy.ErrSessionNotFound):
+		WriteError(w, http.StatusNotFound, err.Error())
+	default:
+		WriteError(w, http.StatusConflict, err.Error())
+	}
+}
+
+// record writes an audit entry for a session operation and returns err.
+func record(r *http.Request, action string, id uint64, after any, err error) error {
+	entry := audit.Entry{Actor: actor(r), Action: action, Target: fmt.Sprintf("session/%d", id), After: after}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+	audit.Record(entry)
+	return err
+}
+
+func (s *Server) handleExportSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionID(w, r)
+	if !ok {
+		return
+	}
+	data, err := s.proxy.ExportSession(id)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, json.RawMessage(data))
+}
+
+func (s *Server) handleSessionTrace(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionID(w, r)
+	if !ok {
+		return
+	}
+	events, err := s.proxy.SessionTrace(id)
+	if err != nil {
+		sessionError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, events)
+}
+
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	WriteJSON(w, http.StatusOK, debug.RelayEvents())
+}
+
+func (s *Server) handleImportSession(w http.ResponseWriter, r *http.Request) {
+	data, err := io.ReadAll(io.LimitReader(r.Body, maxSessionBody))
+	if err != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	id, err := s.proxy.ImportSession(data)
+	if record(r, "session.import", id, json.RawMessage(data), err) != nil {
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusCreated, map[string]uint64{"id": id})
+}
+
+func (s *Server) handlePauseSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionID(w, r)
+	if !ok {
+		return
+	}
+	if err := record(r, "session.pause", id, nil, s.proxy.PauseSession(id)); err != nil {
+		sessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (s *Server) handleResumeSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionID(w, r)
+	if !ok {
+		return
+	}
+	held, err := s.proxy.ResumeSession(id)
+	if record(r, "session.resume", id, nil, err) != nil {
+		sessionError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]int{"held": held})
+}
+
+// backendRequest is the body of PUT /sessions/{id}/backend and POST /sessions/{id}/migrate.
+type backendRequest struct {
+	Backend string `json:"backend"`
+}
+
+func readBackend(w http.ResponseWriter, r *http.Request) (string, bool) {
+	var req backendRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxSessionBody)).Decode(&req); err != nil || req.Backend == "" {
+		WriteError(w, http.StatusBadRequest, `body must be {"backend": "host:port"}`)
+		return "", false
+	}
+	return req.Backend, true
+}
+
+func (s *Server) handleMoveSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionID(w, r)
+	if !ok {
+		return
+	}
+	backend, ok := readBackend(w, r)
+	if !ok {
+		return
+	}
+	_, err := s.proxy.MoveSession(id, backend)
+	if record(r, "session.move", id, backendRequest{Backend: backend}, err) != nil {
+		sessionError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// hookRequest is sent to the migration hook before a session is moved.
+type hookRequest struct {
+	Session json.RawMessage `json:"session"` // Exported session
+	From    string          `json:"from"`    // Backend it is moved from
+	Backend string          `json:"backend"` // Where it is moved to
+}
+
+// handleMigrateSession moves a session to a new backend in one step: it
+// pauses the session, switches the backend, asks the migration hook to move
+// the game server's state and resumes. If the hook fails the session resumes
+// on its old backend.
+func (s *Server) handleMigrateSession(w http.ResponseWriter, r *http.Request) {
+	id, ok := sessionID(w, r)
+	if !ok {
+		return
+	}
+	backend, ok := readBackend(w, r)
+	if !ok {
+		return
+	}
+	after := backendRequest{Backend: backend}
+	if err := s.proxy.PauseSession(id); err != nil {
+		record(r, "session.migrate", id, after, err)
+		sessionError(w, err)
+		return
+	}
+	status := http.StatusConflict
+	old, err := s.proxy.MoveSession(id, backend)
+	if err == nil {
+		if err = s.callHook(r.Context(), id, old, backend); err != nil {
+			status = http.StatusBadGateway
+			s.proxy.MoveSession(id, old)
+		}
+	}
+	held, resumeErr := s.proxy.ResumeSession(id)
+	if err == nil {
+		err = resumeErr
+	}
+	if record(r, "session.migrate", id, after, err) != nil {
+		if errors.Is(err, proxy.ErrSessionNotFound) {
+			status = http.StatusNotFound
+		}
+		WriteError(w, status, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusOK, map[string]any{"backend": backend, "held": held})
+}
+
+// callHook posts the exported session with its old backend to the migration
+// hook, if configured, and waits for a 2xx response.
+func (s *Server) callHook(ctx context.Context, id uint64, old, backend string) error {
+	if s.migrateHook == "" {
+		return nil
+	}
+	session, err := s.proxy.ExportSession(id)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(hookRequest{Session: session, From: old, Backend: backend})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, hookTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.migrateHook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("migration hook: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("migration hook: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,414 @@
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+const (
+	defaultSnapshotInterval = 5 * time.Minute
+	defaultSnapshotKeep     = 100
+	snapshotIDFormat        = "20060102T150405Z"
+)
+
+// ConfigSnapshot is the configuration in effect at one time, with the
+// runtime state set via the admin API.
+type ConfigSnapshot struct {
+	ID          string                    `json:"id"`
+	Time        time.Time                 `json:"time"`
+	Reason      string                    `json:"reason"`                // startup, scheduled, reload, manual, routes.put, routes.patch or rollback
+	Actor       string                    `json:"actor,omitempty"`       // Admin API caller, for changes made through it
+	Config      *proxy.Config             `json:"config,omitempty"`      // Including routes imported via the admin API
+	Maintenance *handler.MaintenanceState `json:"maintenance,omitempty"` // Maintenance mode override
+	Drains      []handler.DrainInfo       `json:"drains,omitempty"`      // Draining backends
+	Handlers    []handler.HandlerConfig   `json:"handlers,omitempty"`    // Only in snapshots of earlier versions, which hold the handler chain alone
+}
+
+// relayState is what a snapshot records and a rollback restores. The config
+// is embedded so audit diffs list its settings one by one.
+type relayState struct {
+	*proxy.Config
+	Maintenance *handler.MaintenanceState `json:"maintenance,omitempty"`
+	Drains      []handler.DrainInfo       `json:"drains,omitempty"`
+}
+
+// recorded returns the state snap recorded.
+func (snap ConfigSnapshot) recorded() relayState {
+	return relayState{Config: snap.Config, Maintenance: snap.Maintenance, Drains: snap.Drains}
+}
+
+// target returns the state rolling back to snap restores. Snapshots of
+// earlier versions only hold the handler chain; everything else stays as in
+// current.
+func (snap ConfigSnapshot) target(current relayState) relayState {
+	if snap.Config != nil {
+		return snap.recorded()
+	}
+	cfg := *current.Config
+	cfg.Handlers = snap.Handlers
+	current.Config = &cfg
+	return current
+}
+
+// handlerCount returns the number of handlers in snap's chain.
+func (snap ConfigSnapshot) handlerCount() int {
+	if snap.Config != nil {
+		return len(snap.Config.Handlers)
+	}
+	return len(snap.Handlers)
+}
+
+// snapshotSummary is one entry of GET /config/snapshots.
+type snapshotSummary struct {
+	ID       string    `json:"id"`
+	Time     time.Time `json:"time"`
+	Reason   string    `json:"reason"`
+	Actor    string    `json:"actor,omitempty"`
+	Handlers int       `json:"handlers"`
+}
+
+// snapshotStore keeps config snapshots as one JSON file each in a directory.
+type snapshotStore struct {
+	dir      string
+	keep     int
+	interval time.Duration
+	state    func() (relayState, error)
+	done     chan struct{}
+
+	mu   sync.Mutex
+	last []byte // State recorded by the newest snapshot, as JSON
+}
+
+func newSnapshotStore(cfg *proxy.AdminSnapshotsConfig, state func() (relayState, error)) (*snapshotStore, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("admin snapshots: 'dir' is required")
+	}
+	if cfg.Interval < 0 || cfg.Keep < 0 {
+		return nil, errors.New("admin snapshots: 'interval' and 'keep' must be >= 0")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("admin snapshots: %w", err)
+	}
+	st := &snapshotStore{
+		dir:      cfg.Dir,
+		keep:     cfg.Keep,
+		interval: time.Duration(cfg.Interval) * time.Second,
+		state:    state,
+		done:     make(chan struct{}),
+	}
+	if st.keep == 0 {
+		st.keep = defaultSnapshotKeep
+	}
+	if st.interval == 0 {
+		st.interval = defaultSnapshotInterval
+	}
+	// Continue from the newest snapshot of a previous run
+	if ids, err := st.ids(); err == nil && len(ids) > 0 {
+		if snap, err := st.load(ids[len(ids)-1]); err == nil && snap.Config != nil {
+			st.last, _ = json.Marshal(snap.recorded())
+		}
+	}
+	return st, nil
+}
+
+// run takes a snapshot at startup and then every interval, if the
+// configuration changed, until close.
+func (st *snapshotStore) run() {
+	st.takeIfChanged("startup")
+	ticker := time.NewTicker(st.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			st.takeIfChanged("scheduled")
+		case <-st.done:
+			return
+		}
+	}
+}
+
+func (st *snapshotStore) close() {
+	close(st.done)
+}
+
+// SnapshotConfig takes a config snapshot if snapshots are enabled and the
+// configuration or runtime state changed since the newest one.
+func (s *Server) SnapshotConfig(reason string) {
+	if s.snapshots != nil {
+		s.snapshots.takeIfChanged(reason)
+	}
+}
+
+func (st *snapshotStore) takeIfChanged(reason string) {
+	if _, err := st.take(reason, "", false); err != nil {
+		logger.Warnf("config snapshot failed: %v", err)
+	}
+}
+
+// take writes a snapshot of the active configuration and runtime state.
+// Unless always is set, nothing is written if it equals the newest snapshot;
+// the returned snapshot then has no ID.
+func (st *snapshotStore) take(reason, who string, always bool) (ConfigSnapshot, error) {
+	state, err := st.state()
+	if err != nil {
+		return ConfigSnapshot{}, err
+	}
+	recorded, err := json.Marshal(state)
+	if err != nil {
+		return ConfigSnapshot{}, err
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if !always && bytes.Equal(recorded, st.last) {
+		return ConfigSnapshot{}, nil
+	}
+	now := time.Now().UTC()
+	snap := ConfigSnapshot{
+		ID:          now.Format(snapshotIDFormat),
+		Time:        now,
+		Reason:      reason,
+		Actor:       who,
+		Config:      state.Config,
+		Maintenance: state.Maintenance,
+		Drains:      state.Drains,
+	}
+	for n := 2; fileExists(st.path(snap.ID)); n++ {
+		snap.ID = now.Format(snapshotIDFormat) + "-" + strconv.Itoa(n)
+	}
+	data, err := json.MarshalIndent(snap, "", "  ")
+	if err != nil {
+		return ConfigSnapshot{}, err
+	}
+	tmp := st.path(snap.ID) + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return ConfigSnapshot{}, err
+	}
+	if err := os.Rename(tmp, st.path(snap.ID)); err != nil {
+		return ConfigSnapshot{}, err
+	}
+	st.last = recorded
+	st.prune()
+	logger.Debugf("config snapshot %s (%s)", snap.ID, reason)
+	return snap, nil
+}
+
+// prune removes the oldest snapshots beyond keep.
+func (st *snapshotStore) prune() {
+	ids, err := st.ids()
+	if err != nil {
+		return
+	}
+	for _, id := range ids[:max(len(ids)-st.keep, 0)] {
+		os.Remove(st.path(id))
+	}
+}
+
+// ids returns the snapshot IDs, oldest first.
+func (st *snapshotStore) ids() ([]string, error) {
+	entries, err := os.ReadDir(st.dir)
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, e := range entries {
+		if id, ok := strings.CutSuffix(e.Name(), ".json"); ok && validSnapshotID(id) {
+			ids = append(ids, id)
+		}
+	}
+	// IDs sort by time; "-n" suffixes of the same second sort after the first
+	slices.SortFunc(ids, func(a, b string) int {
+		if len(a) != len(b) && a[:len(snapshotIDFormat)] == b[:len(snapshotIDFormat)] {
+			return len(a) - len(b)
+		}
+		return strings.Compare(a, b)
+	})
+	return ids, nil
+}
+
+// load reads a snapshot. It returns an error wrapping os.ErrNotExist for
+// unknown or malformed IDs.
+func (st *snapshotStore) load(id string) (ConfigSnapshot, error) {
+	if !validSnapshotID(id) {
+		return ConfigSnapshot{}, fmt.Errorf("snapshot %q: %w", id, os.ErrNotExist)
+	}
+	data, err := os.ReadFile(st.path(id))
+	if err != nil {
+		return ConfigSnapshot{}, err
+	}
+	var snap ConfigSnapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return ConfigSnapshot{}, fmt.Errorf("snapshot %s: %w", id, err)
+	}
+	return snap, nil
+}
+
+func (st *snapshotStore) path(id string) string {
+	return filepath.Join(st.dir, id+".json")
+}
+
+// validSnapshotID reports whether id has the form snapshots are written
+// with, so it is safe to use as a file name.
+func validSnapshotID(id string) bool {
+	stamp, n, _ := strings.Cut(id, "-")
+	if _, err := time.Parse(snapshotIDFormat, stamp); err != nil || len(stamp) != len(snapshotIDFormat) {
+		return false
+	}
+	if n == "" {
+		return !strings.Contains(id, "-")
+	}
+	k, err := strconv.Atoi(n)
+	return err == nil && k >= 2 && strconv.Itoa(k) == n
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// handleSnapshots lists the config snapshots, newest first.
+func (s *Server) handleSnapshots(w http.ResponseWriter, r *http.Request) {
+	if !s.requireSnapshots(w) {
+		return
+	}
+	ids, err := s.snapshots.ids()
+	if err != nil {
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	list := []snapshotSummary{}
+	for _, id := range slices.Backward(ids) {
+		snap, err := s.snapshots.load(id)
+		if err != nil {
+			continue
+		}
+		list = append(list, snapshotSummary{ID: snap.ID, Time: snap.Time, Reason: snap.Reason, Actor: snap.Actor, Handlers: snap.handlerCount()})
+	}
+	WriteJSON(w, http.StatusOK, list)
+}
+
+// handleSnapshot returns one config snapshot.
+func (s *Server) handleSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.requireSnapshots(w) {
+		return
+	}
+	snap, ok := s.loadSnapshot(w, r.PathValue("id"))
+	if ok {
+		WriteJSON(w, http.StatusOK, snap)
+	}
+}
+
+// handleTakeSnapshot takes a snapshot of the active configuration now.
+func (s *Server) handleTakeSnapshot(w http.ResponseWriter, r *http.Request) {
+	if !s.requireSnapshots(w) {
+		return
+	}
+	snap, err := s.snapshots.take("manual", actor(r), true)
+	if err != nil {
+		WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
+	WriteJSON(w, http.StatusCreated, snap)
+}
+
+// handleRollback restores the configuration and runtime state of a
+// snapshot. The config is applied like a SIGHUP reload: everything is built
+// before anything is applied, so a snapshot that no longer builds (e.g. a
+// removed certificate file) changes nothing. With ?dry_run=true it is only
+// validated.
+func (s *Server) handleRollback(w http.ResponseWriter, r *http.Request) {
+	if !s.requireSnapshots(w) {
+		return
+	}
+	id := r.PathValue("id")
+	snap, ok := s.loadSnapshot(w, id)
+	if !ok {
+		return
+	}
+	dryRun, _ := strconv.ParseBool(r.URL.Query().Get("dry_run"))
+	entry := audit.Entry{Actor: actor(r), Action: "config.rollback", Target: "config/snapshots/" + id}
+
+	s.routesMu.Lock()
+	defer s.routesMu.Unlock()
+	current, err := s.relayState()
+	if err != nil {
+		WriteError(w, http.StatusConflict, err.Error())
+		return
+	}
+	target := snap.target(current)
+	if dryRun {
+		err = s.proxy.CheckConfig(target.Config)
+	} else {
+		err = s.proxy.Reload(target.Config)
+	}
+	if err != nil {
+		if !dryRun {
+			entry.Error = err.Error()
+			audit.Record(entry)
+		}
+		WriteError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	diff, _ := audit.Diff(current, target)
+	if dryRun {
+		WriteJSON(w, http.StatusOK, map[string]any{"dry_run": true, "diff": diff})
+		return
+	}
+	handler.SetMaintenanceOverride(target.Maintenance)
+	handler.SetDrainingBackends(target.Drains)
+
+	entry.Before, entry.After, entry.Diff = current, target, diff
+	audit.Record(entry)
+	logger.Printf("configuration rolled back to snapshot %s (admin)", id)
+	taken, err := s.snapshots.take("rollback", actor(r), true)
+	if err != nil {
+		logger.Warnf("config snapshot failed: %v", err)
+	}
+	WriteJSON(w, http.StatusOK, map[string]any{"dry_run": false, "diff": diff, "snapshot": taken.ID})
+}
+
+// relayState returns the active configuration and runtime state.
+func (s *Server) relayState() (relayState, error) {
+	cfg := s.proxy.Config()
+	if cfg == nil {
+		return relayState{}, errors.New("active configuration was not built from config")
+	}
+	return relayState{Config: cfg, Maintenance: handler.MaintenanceOverride(), Drains: handler.DrainingBackends()}, nil
+}
+
+// requireSnapshots answers 404 if snapshots are not configured.
+func (s *Server) requireSnapshots(w http.ResponseWriter) bool {
+	if s.snapshots == nil {
+		WriteError(w, http.StatusNotFound, "config snapshots are not enabled (admin 'snapshots')")
+		return false
+	}
+	return true
+}
+
+// loadSnapshot loads snapshot id, answering 404 or 500 if it cannot.
+func (s *Server) loadSnapshot(w http.ResponseWriter, id string) (ConfigSnapshot, bool) {
+	snap, err := s.snapshots.load(id)
+	switch {
+	case errors.Is(err, os.ErrNotExist):
+		WriteError(w, http.StatusNotFound, "snapshot not found")
+		return snap, false
+	case err != nil:
+		WriteError(w, http.StatusInternalServerError, err.Error())
+		return snap, false
+	}
+	return snap, true
+}
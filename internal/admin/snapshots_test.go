@@ -0,0 +1,133 @@
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+func TestAdmin_ConfigSnapshots(t *testing.T) {
+	cfg := &proxy.Config{
+		Listen: "127.0.0.1:0",
+		Handlers: []handler.HandlerConfig{
+			{Type: "sni-router", Config: json.RawMessage(`{"routes": {"a.example.com": "10.0.0.1:5520"}}`)},
+			{Type: "forwarder"},
+		},
+		SessionTimeout: 300,
+	}
+	chain, err := handler.BuildChain(cfg.Handlers)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := proxy.NewFromConfig(cfg, chain)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		handler.SetMaintenanceOverride(nil)
+		handler.SetDrainingBackends(nil)
+	})
+	dir := t.TempDir()
+	s, err := NewServer(proxy.AdminConfig{Listen: "127.0.0.1:0", Snapshots: &proxy.AdminSnapshotsConfig{Dir: dir, Keep: 3}}, p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	route := func(sni string) string {
+		t.Helper()
+		ctx := &handler.Context{Hello: &handler.ClientHello{SNI: sni}}
+		p.Handlers()[0].OnConnect(ctx)
+		return ctx.GetString("backend")
+	}
+	list := func() []snapshotSummary {
+		t.Helper()
+		var l []snapshotSummary
+		rec := serve(s, http.MethodGet, "/config/snapshots", "")
+		if err := json.Unmarshal(rec.Body.Bytes(), &l); err != nil {
+			t.Fatalf("list: %d %s", rec.Code, rec.Body)
+		}
+		return l
+	}
+
+	s.snapshots.takeIfChanged("startup")
+	s.snapshots.takeIfChanged("scheduled") // Unchanged, not written
+	if l := list(); len(l) != 1 || l[0].Reason != "startup" || l[0].Handlers != 2 {
+		t.Fatalf("snapshots = %+v", l)
+	}
+	good := list()[0].ID
+
+	// A route import is recorded, and rolled back along with other settings
+	// and the runtime state
+	if rec := serve(s, http.MethodPut, "/routes", `{"sni-router": {"z.example.com": "10.0.0.26:5520"}}`); rec.Code != http.StatusOK {
+		t.Fatalf("put: %d %s", rec.Code, rec.Body)
+	}
+	if l := list(); len(l) != 2 || l[0].Reason != "routes.put" {
+		t.Fatalf("snapshots = %+v", l)
+	}
+	reloaded := *p.Config()
+	reloaded.SessionTimeout = 30
+	if err := p.Reload(&reloaded); err != nil {
+		t.Fatal(err)
+	}
+	handler.SetMaintenanceOverride(&handler.MaintenanceState{Enabled: true})
+	handler.DrainBackend("10.0.0.1:5520", time.Time{})
+	rec := serve(s, http.MethodPost, "/config/snapshots/"+good+"/rollback?dry_run=true", "")
+	if rec.Code != http.StatusOK || route("a.example.com") != "" {
+		t.Fatalf("dry run: %d %s", rec.Code, rec.Body)
+	}
+	rec = serve(s, http.MethodPost, "/config/snapshots/"+good+"/rollback", "")
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"diff"`) {
+		t.Fatalf("rollback: %d %s", rec.Code, rec.Body)
+	}
+	if route("a.example.com") != "10.0.0.1:5520" || route("z.example.com") != "" {
+		t.Error("routes not rolled back")
+	}
+	if p.Config().SessionTimeout != 300 || handler.MaintenanceOverride() != nil || handler.Draining("10.0.0.1:5520") {
+		t.Error("settings and runtime state not rolled back")
+	}
+	if l := list(); len(l) != 3 || l[0].Reason != "rollback" {
+		t.Errorf("snapshots = %+v", l)
+	}
+
+	// Only keep snapshots remain
+	serve(s, http.MethodPost, "/config/snapshots", "")
+	if l := list(); len(l) != 3 || l[0].Reason != "manual" {
+		t.Errorf("snapshots = %+v", l)
+	}
+	if _, err := os.Stat(filepath.Join(dir, good+".json")); !os.IsNotExist(err) {
+		t.Errorf("oldest snapshot kept: %v", err)
+	}
+
+	for _, id := range []string{good, "..%2Fconfig", "20261016T091200Z-1"} {
+		if rec := serve(s, http.MethodPost, "/config/snapshots/"+id+"/rollback", ""); rec.Code != http.StatusNotFound {
+			t.Errorf("rollback to %s: %d", id, rec.Code)
+		}
+	}
+}
+
+func TestAdmin_ConfigSnapshotsDisabled(t *testing.T) {
+	s := newTestServer(t)
+	if rec := serve(s, http.MethodGet, "/config/snapshots", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("list: %d", rec.Code)
+	}
+}
+
+func TestSnapshotIDs(t *testing.T) {
+	st := &snapshotStore{dir: t.TempDir()}
+	for _, id := range []string{"20261016T091200Z-10", "20261016T091200Z", "20261016T091200Z-2", "20261015T235959Z", "notes"} {
+		os.WriteFile(filepath.Join(st.dir, id+".json"), nil, 0o600)
+	}
+	ids, err := st.ids()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(ids, " "); got != "20261015T235959Z 20261016T091200Z 20261016T091200Z-2 20261016T091200Z-10" {
+		t.Errorf("ids = %s", got)
+	}
+}
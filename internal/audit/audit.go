@@ -0,0 +1,239 @@
+// Package audit writes an append-only record of operator actions: admin API
+// calls, config reloads and runtime limit changes, with who made them, when,
+// and the state before and after.
+//
+// Records are JSON lines synced to disk one by one. The log is swapped
+// atomically by Configure, like the logging sinks, so it survives reloads.
+package audit
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+)
+
+var logger = logging.For("audit")
+
+// Config is the audit log configuration.
+type Config struct {
+	Path string `json:"path"` // Audit log file, opened for appending
+}
+
+// Entry is one audit record.
+type Entry struct {
+	Time   time.Time         `json:"time"`
+	Actor  string            `json:"actor"`            // Who: admin API client or signal
+	Action string            `json:"action"`           // What, e.g. "config.reload" or "session.kill"
+	Target string            `json:"target,omitempty"` // What it was applied to
+	Before any               `json:"before,omitempty"`
+	After  any               `json:"after,omitempty"`
+	Diff   map[string]Change `json:"diff,omitempty"`  // Changed top-level fields
+	Error  string            `json:"error,omitempty"` // Set when the action failed
+}
+
+// Change is the before and after value of a changed field.
+type Change struct {
+	Before any `json:"before"`
+	After  any `json:"after"`
+}
+
+// auditLog is an open audit file.
+type auditLog struct {
+	path string
+	mu   sync.Mutex
+	f    *os.File
+}
+
+var (
+	current     atomic.Pointer[auditLog]
+	configureMu sync.Mutex
+)
+
+// Configure opens the audit log at cfg.Path, replacing the previous one.
+// A nil cfg or empty path disables auditing. Reconfiguring the same path
+// keeps the open file.
+func Configure(cfg *Config) error {
+	apply, _, err := Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare opens the audit log of cfg without replacing the active one. apply
+// then does what Configure does; discard closes the file if it is not
+// applied.
+func Prepare(cfg *Config) (apply, discard func(), err error) {
+	if cfg == nil || cfg.Path == "" {
+		return func() { replace(nil) }, func() {}, nil
+	}
+	if old := current.Load(); old != nil && old.path == cfg.Path {
+		return func() {}, func() {}, nil
+	}
+	f, err := os.OpenFile(cfg.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("audit: %w", err)
+	}
+	l := &auditLog{path: cfg.Path, f: f}
+	return func() { replace(l) }, func() { f.Close() }, nil
+}
+
+// replace makes l the active audit log and closes the previous one.
+func replace(l *auditLog) {
+	configureMu.Lock()
+	defer configureMu.Unlock()
+	closeLog(current.Swap(l))
+}
+
+func closeLog(l *auditLog) {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.f.Close()
+	l.f = nil
+}
+
+// Enabled reports whether an audit log is configured.
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// Record appends e to the audit log. It is a no-op when auditing is disabled.
+// Secrets in Before and After are redacted.
+func Record(e Entry) {
+	l := current.Load()
+	if l == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	e.Before = Redact(e.Before)
+	e.After = Redact(e.After)
+	line, err := json.Marshal(e)
+	if err != nil {
+		logger.Errorf("encode record failed: %v", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.f == nil {
+		return // Replaced concurrently
+	}
+	if _, err := l.f.Write(line); err != nil {
+		logger.Errorf("write failed: %v", err)
+		return
+	}
+	if err := l.f.Sync(); err != nil {
+		logger.Errorf("sync failed: %v", err)
+	}
+}
+
+// secretFields are JSON field names whose values never reach the audit log.
+var secretFields = []string{"key", "secret", "password", "token", "authorization"}
+
+// Redact returns v as generic JSON with secret fields replaced.
+func Redact(v any) any {
+	if v == nil {
+		return nil
+	}
+	generic, err := toGeneric(v)
+	if err != nil {
+		return v
+	}
+	return redact(generic)
+}
+
+func redact(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k, val := range t {
+			if isSecret(k) {
+				if val != nil && val != "" {
+					t[k] = "[redacted]"
+				}
+				continue
+			}
+			t[k] = redact(val)
+		}
+	case []any:
+		for i := range t {
+			t[i] = redact(t[i])
+		}
+	}
+	return v
+}
+
+func isSecret(field string) bool {
+	field = strings.ToLower(field)
+	for _, s := range secretFields {
+		if field == s || strings.HasSuffix(field, "_"+s) {
+			return true
+		}
+	}
+	return false
+}
+
+// toGeneric round-trips v through JSON.
+func toGeneric(v any) (any, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var out any
+	err = json.Unmarshal(data, &out)
+	return out, err
+}
+
+// Diff compares the top-level JSON fields of before and after and returns
+// the changed ones, with secrets redacted.
+func Diff(before, after any) (map[string]Change, error) {
+	b, err := toGeneric(before)
+	if err != nil {
+		return nil, err
+	}
+	a, err := toGeneric(after)
+	if err != nil {
+		return nil, err
+	}
+	bm, ok1 := b.(map[string]any)
+	am, ok2 := a.(map[string]any)
+	if !ok1 || !ok2 {
+		return nil, errors.New("audit: diff needs JSON objects")
+	}
+
+	keys := make(map[string]struct{}, len(bm)+len(am))
+	for k := range bm {
+		keys[k] = struct{}{}
+	}
+	for k := range am {
+		keys[k] = struct{}{}
+	}
+	diff := make(map[string]Change)
+	for k := range keys {
+		if !reflect.DeepEqual(bm[k], am[k]) {
+			diff[k] = Change{Before: redactField(k, bm[k]), After: redactField(k, am[k])}
+		}
+	}
+	return diff, nil
+}
+
+func redactField(k string, v any) any {
+	if isSecret(k) && v != nil && v != "" {
+		return "[redacted]"
+	}
+	return redact(v)
+}
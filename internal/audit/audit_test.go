@@ -0,0 +1,113 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readEntries(t *testing.T, path string) []map[string]any {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var entries []map[string]any
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		var e map[string]any
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			t.Fatalf("invalid record %q: %v", sc.Text(), err)
+		}
+		entries = append(entries, e)
+	}
+	return entries
+}
+
+func TestRecord(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	Record(Entry{Action: "ignored"}) // Disabled: no-op
+
+	if err := Configure(&Config{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Configure(nil) })
+	Record(Entry{Actor: "127.0.0.1:5000", Action: "session.kill", Target: "session/3"})
+
+	// Reopening appends instead of truncating
+	Configure(nil)
+	if err := Configure(&Config{Path: path}); err != nil {
+		t.Fatal(err)
+	}
+	Record(Entry{Actor: "SIGHUP", Action: "config.reload", Error: "bad config"})
+
+	entries := readEntries(t, path)
+	if len(entries) != 2 {
+		t.Fatalf("entries = %d, want 2", len(entries))
+	}
+	if entries[0]["action"] != "session.kill" || entries[0]["time"] == nil {
+		t.Errorf("first entry = %v", entries[0])
+	}
+	if entries[1]["error"] != "bad config" {
+		t.Errorf("second entry = %v", entries[1])
+	}
+	if info, _ := os.Stat(path); info.Mode().Perm() != 0o600 {
+		t.Errorf("mode = %v, want 0600", info.Mode().Perm())
+	}
+}
+
+func TestDiff(t *testing.T) {
+	type limits struct {
+		Rate int `json:"rate"`
+	}
+	type config struct {
+		Timeout int               `json:"timeout"`
+		Limits  limits            `json:"limits"`
+		Reset   map[string]string `json:"reset"`
+		Listen  string            `json:"listen"`
+	}
+	before := config{Timeout: 600, Limits: limits{10}, Reset: map[string]string{"key": "aa"}, Listen: ":5520"}
+	after := config{Timeout: 300, Limits: limits{20}, Reset: map[string]string{"key": "bb"}, Listen: ":5520"}
+
+	diff, err := Diff(before, after)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(diff) != 3 {
+		t.Fatalf("diff = %v, want timeout, limits and reset", diff)
+	}
+	if c := diff["timeout"]; c.Before != 600.0 || c.After != 300.0 {
+		t.Errorf("timeout change = %v", c)
+	}
+	if c := diff["reset"]; c.Before.(map[string]any)["key"] != "[redacted]" || c.After.(map[string]any)["key"] != "[redacted]" {
+		t.Errorf("secret not redacted: %v", c)
+	}
+}
+
+func TestRedact(t *testing.T) {
+	in := map[string]any{
+		"stateless_reset": map[string]any{"key": "00ff", "key_file": "/etc/key", "enabled": true},
+		"handlers":        []any{map[string]any{"config": map[string]any{"api_token": "x", "ttl": 5}}},
+		"password":        "",
+		"exporters":       []any{map[string]any{"headers": map[string]any{"Authorization": "Bearer x"}}},
+	}
+	out := Redact(in).(map[string]any)
+	reset := out["stateless_reset"].(map[string]any)
+	if reset["key"] != "[redacted]" || reset["key_file"] != "/etc/key" {
+		t.Errorf("stateless_reset = %v", reset)
+	}
+	cfg := out["handlers"].([]any)[0].(map[string]any)["config"].(map[string]any)
+	if cfg["api_token"] != "[redacted]" || cfg["ttl"] != 5.0 {
+		t.Errorf("handler config = %v", cfg)
+	}
+	if out["password"] != "" {
+		t.Errorf("empty secret = %v, want kept empty", out["password"])
+	}
+	headers := out["exporters"].([]any)[0].(map[string]any)["headers"].(map[string]any)
+	if headers["Authorization"] != "[redacted]" {
+		t.Errorf("exporter headers = %v", headers)
+	}
+}
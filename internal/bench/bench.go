@@ -0,0 +1,344 @@
+// Package bench load-tests a relay: it opens QUIC connections through it,
+// sends datagrams at a fixed rate and measures handshake latency, round-trip
+// time and loss. The backends must echo QUIC datagrams, as cmd/echo does.
+package bench
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// headerLen is the size of the sequence number and send time in each datagram.
+const headerLen = 16
+
+// MaxSize is the largest datagram payload that fits a QUIC packet on any path.
+const MaxSize = 1150
+
+// Config describes a benchmark run.
+type Config struct {
+	Target           string        // Relay address
+	Connections      int           // Connections to open
+	OpenRate         float64       // Connections opened per second (0 = all at once)
+	SNIs             []WeightedSNI // Server names, spread by weight
+	ALPN             string        // Application protocol (default: quic-echo)
+	Rate             int           // Datagrams per second per connection (0 = handshake only)
+	Size             int           // Datagram size in bytes (default: 200)
+	Duration         time.Duration // How long each connection sends
+	HandshakeTimeout time.Duration // Default: 5s
+	Drain            time.Duration // Wait for late echoes after sending (default: 1s)
+}
+
+// WeightedSNI is a server name and its share of the connections.
+type WeightedSNI struct {
+	Name   string
+	Weight int
+}
+
+// ParseSNIs parses a list like "a.example.com=3,b.example.com", where names
+// without a weight count once.
+func ParseSNIs(s string) ([]WeightedSNI, error) {
+	var snis []WeightedSNI
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		name, weight, found := strings.Cut(item, "=")
+		w := 1
+		if found {
+			var err error
+			if w, err = strconv.Atoi(weight); err != nil || w < 1 {
+				return nil, fmt.Errorf("invalid weight for %s: %q", name, weight)
+			}
+		}
+		snis = append(snis, WeightedSNI{Name: name, Weight: w})
+	}
+	if len(snis) == 0 {
+		return nil, errors.New("no SNI given")
+	}
+	return snis, nil
+}
+
+func (c *Config) validate() error {
+	if c.Target == "" {
+		return errors.New("target required")
+	}
+	if c.Connections < 1 {
+		return errors.New("at least one connection required")
+	}
+	if len(c.SNIs) == 0 {
+		return errors.New("no SNI given")
+	}
+	if c.ALPN == "" {
+		c.ALPN = "quic-echo"
+	}
+	if c.Size == 0 {
+		c.Size = 200
+	}
+	if c.Size < headerLen || c.Size > MaxSize {
+		return fmt.Errorf("size must be between %d and %d bytes", headerLen, MaxSize)
+	}
+	if c.Rate < 0 || c.OpenRate < 0 {
+		return errors.New("rates must not be negative")
+	}
+	if c.Duration == 0 {
+		c.Duration = 10 * time.Second
+	}
+	if c.HandshakeTimeout == 0 {
+		c.HandshakeTimeout = 5 * time.Second
+	}
+	if c.Drain == 0 {
+		c.Drain = time.Second
+	}
+	return nil
+}
+
+// sniFor returns the server name of the i-th connection, following the weights.
+func (c *Config) sniFor(i int) string {
+	total := 0
+	for _, s := range c.SNIs {
+		total += s.Weight
+	}
+	n := i % total
+	for _, s := range c.SNIs {
+		if n < s.Weight {
+			return s.Name
+		}
+		n -= s.Weight
+	}
+	return c.SNIs[0].Name
+}
+
+// Latency summarises a set of samples.
+type Latency struct {
+	Count int           `json:"count"`
+	Min   time.Duration `json:"min"`
+	P50   time.Duration `json:"p50"`
+	P90   time.Duration `json:"p90"`
+	P99   time.Duration `json:"p99"`
+	Max   time.Duration `json:"max"`
+}
+
+func summarize(samples []time.Duration) Latency {
+	if len(samples) == 0 {
+		return Latency{}
+	}
+	slices.Sort(samples)
+	at := func(q float64) time.Duration {
+		return samples[int(q*float64(len(samples)-1))]
+	}
+	return Latency{
+		Count: len(samples),
+		Min:   samples[0],
+		P50:   at(0.50),
+		P90:   at(0.90),
+		P99:   at(0.99),
+		Max:   samples[len(samples)-1],
+	}
+}
+
+// SNIStats counts the connections of one server name.
+type SNIStats struct {
+	Connections int `json:"connections"`
+	Failed      int `json:"failed"`
+}
+
+// Report is the result of a run.
+type Report struct {
+	Connections int                 `json:"connections"`
+	Established int                 `json:"established"`
+	Failed      int                 `json:"failed"`
+	Errors      map[string]int      `json:"errors,omitempty"` // Failure reasons
+	SNIs        map[string]SNIStats `json:"snis"`
+	Handshake   Latency             `json:"handshake"`
+	RTT         Latency             `json:"rtt"`
+	Sent        uint64              `json:"sent"`
+	Received    uint64              `json:"received"`
+	Duplicates  uint64              `json:"duplicates"`
+	Loss        float64             `json:"loss"` // Fraction of datagrams without echo
+	Elapsed     time.Duration       `json:"elapsed"`
+}
+
+// runner collects the results of all connections.
+type runner struct {
+	cfg  Config
+	tls  *tls.Config
+	base time.Time // Send times are encoded relative to this
+
+	mu         sync.Mutex
+	report     Report
+	handshakes []time.Duration
+	rtts       []time.Duration
+}
+
+// Run opens the connections, sends datagrams on each and waits for all of
+// them to finish. Cancelling ctx stops the run early; the report covers what
+// was measured until then.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if err := cfg.validate(); err != nil {
+		return nil, err
+	}
+	r := &runner{
+		cfg:  cfg,
+		tls:  &tls.Config{InsecureSkipVerify: true, NextProtos: []string{cfg.ALPN}},
+		base: time.Now(),
+		report: Report{
+			Errors: make(map[string]int),
+			SNIs:   make(map[string]SNIStats),
+		},
+	}
+
+	var wg sync.WaitGroup
+	for i := range cfg.Connections {
+		if cfg.OpenRate > 0 && i > 0 {
+			select {
+			case <-time.After(time.Duration(float64(time.Second) / cfg.OpenRate)):
+			case <-ctx.Done():
+			}
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		wg.Go(func() { r.connection(ctx, cfg.sniFor(i)) })
+	}
+	wg.Wait()
+
+	r.report.Elapsed = time.Since(r.base)
+	r.report.Handshake = summarize(r.handshakes)
+	r.report.RTT = summarize(r.rtts)
+	if r.report.Sent > 0 {
+		r.report.Loss = 1 - float64(r.report.Received)/float64(r.report.Sent)
+	}
+	return &r.report, nil
+}
+
+// connection runs one benchmark connection.
+func (r *runner) connection(ctx context.Context, sni string) {
+	tlsConf := r.tls.Clone()
+	tlsConf.ServerName = sni
+
+	start := time.Now()
+	hctx, cancel := context.WithTimeout(ctx, r.cfg.HandshakeTimeout)
+	conn, err := quic.DialAddr(hctx, r.cfg.Target, tlsConf, &quic.Config{
+		EnableDatagrams: true,
+		MaxIdleTimeout:  r.cfg.HandshakeTimeout + r.cfg.Drain + 5*time.Second,
+	})
+	cancel()
+	if err != nil {
+		r.failed(sni, err)
+		return
+	}
+	handshake := time.Since(start)
+	defer conn.CloseWithError(0, "bench done")
+
+	if r.cfg.Rate > 0 && !conn.ConnectionState().SupportsDatagrams {
+		r.failed(sni, errors.New("backend does not support QUIC datagrams"))
+		return
+	}
+	r.mu.Lock()
+	r.report.Connections++
+	r.report.Established++
+	st := r.report.SNIs[sni]
+	st.Connections++
+	r.report.SNIs[sni] = st
+	r.handshakes = append(r.handshakes, handshake)
+	r.mu.Unlock()
+
+	if r.cfg.Rate == 0 {
+		return
+	}
+
+	rctx, stopReceiving := context.WithCancel(ctx)
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		r.receive(rctx, conn)
+	}()
+
+	sent := r.send(ctx, conn)
+	select {
+	case <-time.After(r.cfg.Drain):
+	case <-ctx.Done():
+	}
+	stopReceiving()
+	<-received
+
+	r.mu.Lock()
+	r.report.Sent += sent
+	r.mu.Unlock()
+}
+
+// send sends datagrams at the configured rate and returns how many were sent.
+func (r *runner) send(ctx context.Context, conn *quic.Conn) uint64 {
+	ticker := time.NewTicker(time.Second / time.Duration(r.cfg.Rate))
+	defer ticker.Stop()
+	stop := time.After(r.cfg.Duration)
+
+	payload := make([]byte, r.cfg.Size)
+	var seq uint64
+	for {
+		select {
+		case <-ctx.Done():
+			return seq
+		case <-stop:
+			return seq
+		case <-ticker.C:
+		}
+		binary.BigEndian.PutUint64(payload[0:8], seq)
+		binary.BigEndian.PutUint64(payload[8:16], uint64(time.Since(r.base)))
+		if err := conn.SendDatagram(payload); err != nil {
+			return seq
+		}
+		seq++
+	}
+}
+
+// receive records the round-trip time of echoed datagrams until ctx is done.
+// Echoes of a datagram seen before are counted as duplicates.
+func (r *runner) receive(ctx context.Context, conn *quic.Conn) {
+	seen := make(map[uint64]struct{})
+	for {
+		d, err := conn.ReceiveDatagram(ctx)
+		if err != nil {
+			return
+		}
+		if len(d) < headerLen {
+			continue
+		}
+		seq := binary.BigEndian.Uint64(d[0:8])
+		rtt := time.Since(r.base) - time.Duration(binary.BigEndian.Uint64(d[8:16]))
+		_, dup := seen[seq]
+		seen[seq] = struct{}{}
+
+		r.mu.Lock()
+		if dup {
+			r.report.Duplicates++
+		} else {
+			r.report.Received++
+			r.rtts = append(r.rtts, rtt)
+		}
+		r.mu.Unlock()
+	}
+}
+
+func (r *runner) failed(sni string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.report.Connections++
+	r.report.Failed++
+	r.report.Errors[err.Error()]++
+	st := r.report.SNIs[sni]
+	st.Connections++
+	st.Failed++
+	r.report.SNIs[sni] = st
+}
@@ -0,0 +1,168 @@
+package bench
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// startEcho starts a QUIC server echoing datagrams and returns its address.
+func startEcho(t *testing.T, datagrams bool) string {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), DNSNames: []string{"localhost"}}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}},
+		NextProtos:   []string{"quic-echo"},
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsConf, &quic.Config{EnableDatagrams: datagrams})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go func() {
+				for {
+					d, err := conn.ReceiveDatagram(context.Background())
+					if err != nil {
+						return
+					}
+					conn.SendDatagram(d)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String()
+}
+
+func TestParseSNIs(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []WeightedSNI
+		wantErr bool
+	}{
+		{"a.example.com", []WeightedSNI{{"a.example.com", 1}}, false},
+		{"a=3, b", []WeightedSNI{{"a", 3}, {"b", 1}}, false},
+		{"a=0", nil, true},
+		{"a=x", nil, true},
+		{" , ", nil, true},
+	}
+	for _, tt := range tests {
+		got, err := ParseSNIs(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("ParseSNIs(%q) err = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("ParseSNIs(%q) = %v, want %v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSNIFor(t *testing.T) {
+	cfg := Config{SNIs: []WeightedSNI{{"a", 3}, {"b", 1}}}
+	counts := map[string]int{}
+	for i := range 8 {
+		counts[cfg.sniFor(i)]++
+	}
+	if counts["a"] != 6 || counts["b"] != 2 {
+		t.Errorf("distribution = %v, want a:6 b:2", counts)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	l := summarize(samples)
+	if l.Count != 100 || l.Min != time.Millisecond || l.Max != 100*time.Millisecond {
+		t.Errorf("summary = %+v", l)
+	}
+	if l.P50 != 50*time.Millisecond || l.P99 != 99*time.Millisecond {
+		t.Errorf("p50 = %v, p99 = %v", l.P50, l.P99)
+	}
+	if (summarize(nil) != Latency{}) {
+		t.Error("empty samples should give a zero summary")
+	}
+}
+
+func TestRun(t *testing.T) {
+	addr := startEcho(t, true)
+	report, err := Run(context.Background(), Config{
+		Target:      addr,
+		Connections: 3,
+		SNIs:        []WeightedSNI{{"a.example.com", 2}, {"b.example.com", 1}},
+		Rate:        100,
+		Duration:    200 * time.Millisecond,
+		Drain:       200 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Established != 3 || report.Failed != 0 {
+		t.Fatalf("established = %d, failed = %d (%v)", report.Established, report.Failed, report.Errors)
+	}
+	if report.SNIs["a.example.com"].Connections != 2 || report.SNIs["b.example.com"].Connections != 1 {
+		t.Errorf("snis = %v", report.SNIs)
+	}
+	if report.Handshake.Count != 3 {
+		t.Errorf("handshake samples = %d, want 3", report.Handshake.Count)
+	}
+	if report.Sent == 0 || report.Received == 0 || report.Received > report.Sent {
+		t.Errorf("sent = %d, received = %d", report.Sent, report.Received)
+	}
+	if report.RTT.Count != int(report.Received) {
+		t.Errorf("rtt samples = %d, received = %d", report.RTT.Count, report.Received)
+	}
+}
+
+func TestRun_NoDatagrams(t *testing.T) {
+	addr := startEcho(t, false)
+	report, err := Run(context.Background(), Config{
+		Target:      addr,
+		Connections: 1,
+		SNIs:        []WeightedSNI{{"localhost", 1}},
+		Rate:        10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Failed != 1 || report.Errors["backend does not support QUIC datagrams"] != 1 {
+		t.Errorf("failed = %d, errors = %v", report.Failed, report.Errors)
+	}
+}
+
+func TestRun_InvalidConfig(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Target: "127.0.0.1:1", Connections: 1},
+		{Target: "127.0.0.1:1", Connections: 1, SNIs: []WeightedSNI{{"a", 1}}, Size: 8},
+		{Target: "127.0.0.1:1", Connections: 1, SNIs: []WeightedSNI{{"a", 1}}, Rate: -1},
+	} {
+		if _, err := Run(context.Background(), cfg); err == nil {
+			t.Errorf("expected error for %+v", cfg)
+		}
+	}
+}
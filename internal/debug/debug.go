@@ -1,11 +1,15 @@
 package debug
 
 import (
-	"log"
 	"sync/atomic"
+
+	"quic-relay/internal/logging"
 )
 
-var enabled atomic.Bool
+var (
+	enabled atomic.Bool
+	logger  = logging.For("debug")
+)
 
 // Enable turns on debug logging.
 func Enable() {
@@ -23,8 +27,9 @@ func IsEnabled() bool {
 }
 
 // Printf logs a debug message if debug mode is enabled.
+// Messages are written at debug level under the "debug" component.
 func Printf(format string, v ...any) {
 	if enabled.Load() {
-		log.Printf("[DEBUG] "+format, v...)
+		logger.Debugf(format, v...)
 	}
 }
@@ -0,0 +1,134 @@
+package debug
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Event is one entry of a flight recorder.
+type Event struct {
+	At    time.Time `json:"time"`
+	Kind  string    `json:"event"`
+	Size  int       `json:"bytes,omitempty"`
+	First byte      `json:"-"` // First byte of the packet, when Size > 0
+	Note  string    `json:"note,omitempty"`
+}
+
+// String formats e for log lines.
+func (e Event) String() string {
+	var b strings.Builder
+	b.WriteString(e.At.Format("15:04:05.000000"))
+	b.WriteByte(' ')
+	b.WriteString(e.Kind)
+	if e.Size > 0 {
+		fmt.Fprintf(&b, " %dB 0x%02x", e.Size, e.First)
+	}
+	if e.Note != "" {
+		b.WriteByte(' ')
+		b.WriteString(e.Note)
+	}
+	return b.String()
+}
+
+// Recorder keeps the most recent events in a fixed ring. It is always on:
+// adding an event does not format or allocate, so packet paths can record
+// every packet and the lead-up to a failure is available without -d.
+// Create recorders with NewRecorder; a nil *Recorder records nothing.
+type Recorder struct {
+	mu     sync.Mutex
+	events []record
+	next   int
+	full   bool
+}
+
+// record is the compact form of an Event kept in the ring (48 bytes).
+type record struct {
+	at    int64 // Unix nanoseconds
+	kind  string
+	note  string
+	size  int32
+	first byte
+}
+
+func (e record) event() Event {
+	return Event{At: time.Unix(0, e.at), Kind: e.kind, Size: int(e.size), First: e.first, Note: e.note}
+}
+
+// NewRecorder creates a recorder that keeps the last n events.
+func NewRecorder(n int) *Recorder {
+	return &Recorder{events: make([]record, n)}
+}
+
+// Add records a packet event.
+func (r *Recorder) Add(kind string, size int, first byte) {
+	r.add(record{at: time.Now().UnixNano(), kind: kind, size: int32(size), first: first})
+}
+
+// Note records an event with a message, for rare events such as errors.
+func (r *Recorder) Note(kind, note string) {
+	r.add(record{at: time.Now().UnixNano(), kind: kind, note: note})
+}
+
+func (r *Recorder) add(e record) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	r.events[r.next] = e
+	r.next++
+	if r.next == len(r.events) {
+		r.next = 0
+		r.full = true
+	}
+	r.mu.Unlock()
+}
+
+// Events returns the recorded events, oldest first.
+func (r *Recorder) Events() []Event {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ordered := r.events[:r.next]
+	if r.full {
+		ordered = append(r.events[r.next:len(r.events):len(r.events)], ordered...)
+	}
+	out := make([]Event, len(ordered))
+	for i, e := range ordered {
+		out[i] = e.event()
+	}
+	return out
+}
+
+// Dump formats the recorded events on one line, for attaching to error logs.
+func (r *Recorder) Dump() string {
+	events := r.Events()
+	parts := make([]string, len(events))
+	for i, e := range events {
+		parts[i] = e.String()
+	}
+	return strings.Join(parts, "; ")
+}
+
+// relayEvents records failures outside sessions, such as unparseable
+// Initials or failed drop responses, reported through Notef.
+var relayEvents = NewRecorder(256)
+
+// Notef records a relay-wide event and, in debug mode, logs it like Printf.
+// Use it instead of Printf on failure paths; the message is formatted even
+// when debug mode is off, so keep it off per-packet success paths.
+func Notef(format string, v ...any) {
+	msg := fmt.Sprintf(format, v...)
+	relayEvents.Note("relay", strings.TrimSpace(msg))
+	if enabled.Load() {
+		logger.Debugf("%s", msg)
+	}
+}
+
+// RelayEvents returns the recent relay-wide events, oldest first.
+func RelayEvents() []Event {
+	return relayEvents.Events()
+}
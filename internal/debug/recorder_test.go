@@ -0,0 +1,57 @@
+package debug
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRecorder(t *testing.T) {
+	r := NewRecorder(3)
+	if got := r.Events(); len(got) != 0 {
+		t.Fatalf("new recorder has %d events", len(got))
+	}
+
+	r.Add("in", 1200, 0xc3)
+	r.Add("out", 40, 0x41)
+	if got := r.Events(); len(got) != 2 || got[0].Kind != "in" || got[1].Kind != "out" {
+		t.Fatalf("events = %+v", got)
+	}
+
+	// Wrapping keeps the newest events, oldest first
+	r.Note("pause", "")
+	r.Note("close", "backend_error")
+	got := r.Events()
+	var kinds []string
+	for _, e := range got {
+		kinds = append(kinds, e.Kind)
+	}
+	if strings.Join(kinds, ",") != "out,pause,close" {
+		t.Errorf("kinds = %v, want out,pause,close", kinds)
+	}
+	if got[0].Size != 40 || got[0].First != 0x41 || got[2].Note != "backend_error" {
+		t.Errorf("events = %+v", got)
+	}
+
+	dump := r.Dump()
+	if !strings.Contains(dump, " out 40B 0x41; ") || !strings.HasSuffix(dump, " close backend_error") {
+		t.Errorf("Dump() = %q", dump)
+	}
+}
+
+func TestRecorder_Nil(t *testing.T) {
+	var r *Recorder
+	r.Add("in", 1, 0)
+	r.Note("close", "")
+	if r.Events() != nil || r.Dump() != "" {
+		t.Error("nil recorder returned events")
+	}
+}
+
+func TestNotef(t *testing.T) {
+	Notef("icmp to %s failed: %v\n", "192.0.2.1:4433", "refused")
+	events := RelayEvents()
+	last := events[len(events)-1]
+	if last.Kind != "relay" || last.Note != "icmp to 192.0.2.1:4433 failed: refused" {
+		t.Errorf("last event = %+v", last)
+	}
+}
@@ -0,0 +1,143 @@
+package debug
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"net"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/metrics"
+)
+
+// ServerConfig configures the optional debug HTTP listener.
+type ServerConfig struct {
+	Enabled bool   `json:"enabled"`
+	Listen  string `json:"listen,omitempty"` // Bind address (default: 127.0.0.1:6060)
+}
+
+const defaultServerListen = "127.0.0.1:6060"
+
+var serverLog = logging.For("debug")
+
+// Server exposes pprof, expvar, runtime stats and registered JSON dumps over HTTP.
+// It must only be bound to trusted interfaces - it has no authentication.
+type Server struct {
+	listen    string
+	mux       *http.ServeMux
+	srv       *http.Server
+	startedAt time.Time
+}
+
+// NewServer creates a debug server with the standard endpoints registered:
+//
+//	/debug/pprof/     net/http/pprof profiles (goroutine?debug=2 dumps all stacks)
+//	/debug/vars       expvar
+//	/debug/runtime    goroutine count, memory and GC stats
+//	/debug/events     recent relay-wide failures recorded with Notef
+//	/metrics          Prometheus metrics
+func NewServer(cfg ServerConfig) *Server {
+	listen := cfg.Listen
+	if listen == "" {
+		listen = defaultServerListen
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	mux.Handle("/debug/vars", expvar.Handler())
+	mux.Handle("/metrics", metrics.Handler())
+
+	s := &Server{
+		listen:    listen,
+		mux:       mux,
+		startedAt: time.Now(),
+	}
+	s.HandleJSON("/debug/runtime", s.runtimeStats)
+	s.HandleJSON("/debug/events", func() any { return RelayEvents() })
+	return s
+}
+
+// HandleJSON registers an endpoint that serves the result of fn as JSON.
+// fn is called on every request and must be safe for concurrent use.
+func (s *Server) HandleJSON(path string, fn func() any) {
+	s.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(fn()); err != nil {
+			serverLog.Warnf("encode %s failed: %v", path, err)
+		}
+	})
+}
+
+// Start begins listening in the background.
+// Returns an error if the bind address is unavailable.
+func (s *Server) Start() error {
+	ln, err := net.Listen("tcp", s.listen)
+	if err != nil {
+		return err
+	}
+	s.srv = &http.Server{
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	serverLog.Printf("debug server listening on %s", ln.Addr())
+	go func() {
+		if err := s.srv.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			serverLog.Errorf("debug server stopped: %v", err)
+		}
+	}()
+	return nil
+}
+
+// Shutdown stops the server.
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.srv == nil {
+		return nil
+	}
+	return s.srv.Shutdown(ctx)
+}
+
+// RuntimeStats is a summary of Go runtime state.
+type RuntimeStats struct {
+	Uptime       string `json:"uptime"`
+	Goroutines   int    `json:"goroutines"`
+	GOMAXPROCS   int    `json:"gomaxprocs"`
+	NumCPU       int    `json:"num_cpu"`
+	HeapAlloc    uint64 `json:"heap_alloc_bytes"`
+	HeapInuse    uint64 `json:"heap_inuse_bytes"`
+	HeapObjects  uint64 `json:"heap_objects"`
+	TotalAlloc   uint64 `json:"total_alloc_bytes"`
+	Sys          uint64 `json:"sys_bytes"`
+	NumGC        uint32 `json:"num_gc"`
+	PauseTotalNs uint64 `json:"gc_pause_total_ns"`
+	LastPauseNs  uint64 `json:"gc_last_pause_ns"`
+}
+
+func (s *Server) runtimeStats() any {
+	var m runtime.MemStats
+	runtime.ReadMemStats(&m)
+	return RuntimeStats{
+		Uptime:       time.Since(s.startedAt).Round(time.Second).String(),
+		Goroutines:   runtime.NumGoroutine(),
+		GOMAXPROCS:   runtime.GOMAXPROCS(0),
+		NumCPU:       runtime.NumCPU(),
+		HeapAlloc:    m.HeapAlloc,
+		HeapInuse:    m.HeapInuse,
+		HeapObjects:  m.HeapObjects,
+		TotalAlloc:   m.TotalAlloc,
+		Sys:          m.Sys,
+		NumGC:        m.NumGC,
+		PauseTotalNs: m.PauseTotalNs,
+		LastPauseNs:  m.PauseNs[(m.NumGC+255)%256],
+	}
+}
@@ -0,0 +1,48 @@
+package debug
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestServer_HandleJSON(t *testing.T) {
+	s := NewServer(ServerConfig{Enabled: true})
+	s.HandleJSON("/debug/test", func() any {
+		return map[string]int{"sessions": 3}
+	})
+
+	rec := httptest.NewRecorder()
+	s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug/test", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d", rec.Code)
+	}
+	var got map[string]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+		t.Fatalf("invalid JSON: %v", err)
+	}
+	if got["sessions"] != 3 {
+		t.Errorf("expected sessions=3, got %v", got)
+	}
+}
+
+func TestServer_StandardEndpoints(t *testing.T) {
+	s := NewServer(ServerConfig{Enabled: true})
+
+	for _, path := range []string{"/debug/runtime", "/debug/events", "/debug/vars", "/debug/pprof/"} {
+		rec := httptest.NewRecorder()
+		s.mux.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != http.StatusOK {
+			t.Errorf("%s: expected 200, got %d", path, rec.Code)
+		}
+	}
+}
+
+func TestNewServer_DefaultListen(t *testing.T) {
+	s := NewServer(ServerConfig{Enabled: true})
+	if s.listen != defaultServerListen {
+		t.Errorf("expected default listen %q, got %q", defaultServerListen, s.listen)
+	}
+}
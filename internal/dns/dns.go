@@ -0,0 +1,324 @@
+// Package dns implements a small authoritative DNS responder that points the
+// relay's route hostnames at the relay, and publishes the same records to a
+// zone file fragment or a primary name server.
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+
+	"quic-relay/internal/logging"
+)
+
+var logger = logging.For("dns")
+
+const (
+	defaultTTL          = 300
+	defaultSyncInterval = 30 * time.Second
+	// maxUDPSize is the answer size clients without EDNS accept.
+	maxUDPSize = 512
+)
+
+// Config configures the DNS responder.
+type Config struct {
+	Listen string   `json:"listen,omitempty"` // UDP bind address, e.g. ":53"
+	Names  []string `json:"names,omitempty"`  // Names answered; "*.example.com" matches subdomains (default: SNIs of sni-router routes)
+	IPv4   []string `json:"ipv4,omitempty"`   // Public IPv4 addresses of the relay
+	IPv6   []string `json:"ipv6,omitempty"`   // Public IPv6 addresses of the relay
+	Port   int      `json:"port,omitempty"`   // Port in HTTPS records (default: port of the relay's listen address)
+	ALPN   []string `json:"alpn,omitempty"`   // Protocols in HTTPS records (default: ["h3"])
+	TTL    int      `json:"ttl,omitempty"`    // Seconds (default: 300)
+	ECH    string   `json:"ech,omitempty"`    // Base64 ECHConfigList of the TLS servers, published in HTTPS records
+
+	ZoneFile     string        `json:"zone_file,omitempty"`     // Zone file fragment kept up to date with the records
+	Update       *UpdateConfig `json:"update,omitempty"`        // Primary name server to send the records to (RFC 2136)
+	SyncInterval int           `json:"sync_interval,omitempty"` // Seconds between checks for changed records (default: 30)
+}
+
+// Server answers A, AAAA, HTTPS and SVCB queries for route hostnames with the
+// relay's addresses.
+type Server struct {
+	listen string
+	names  func() []string // Names answered, called per query
+	ipv4   []netip.Addr
+	ipv6   []netip.Addr
+	port   int
+	alpn   []string
+	ttl    uint32
+	ech    []byte // ECHConfigList, nil if none
+
+	zoneFile     string
+	updater      *updater
+	syncInterval time.Duration
+
+	conn net.PacketConn
+	done chan struct{}
+
+	published      string   // Zone text last written and sent
+	publishedNames []string // Names last sent to the primary
+}
+
+// NewServer validates cfg. port is the relay's port, used unless cfg sets
+// one. routeNames lists the names to answer when cfg has none; it is called
+// for every query, so answers follow config reloads.
+func NewServer(cfg Config, port int, routeNames func() []string) (*Server, error) {
+	if cfg.Listen == "" && cfg.ZoneFile == "" && cfg.Update == nil {
+		return nil, errors.New("dns requires 'listen', 'zone_file' or 'update'")
+	}
+	if len(cfg.IPv4) == 0 && len(cfg.IPv6) == 0 {
+		return nil, errors.New("dns requires 'ipv4' or 'ipv6' addresses")
+	}
+	if cfg.TTL < 0 || cfg.SyncInterval < 0 {
+		return nil, errors.New("dns 'ttl' and 'sync_interval' must be >= 0")
+	}
+	if cfg.Port < 0 || cfg.Port > 65535 {
+		return nil, fmt.Errorf("dns 'port' out of range: %d", cfg.Port)
+	}
+	s := &Server{
+		listen: cfg.Listen,
+		names:  routeNames,
+		port:   port,
+		alpn:   cfg.ALPN,
+		ttl:    uint32(cfg.TTL),
+
+		zoneFile:     cfg.ZoneFile,
+		syncInterval: time.Duration(cfg.SyncInterval) * time.Second,
+		done:         make(chan struct{}),
+	}
+	if s.syncInterval == 0 {
+		s.syncInterval = defaultSyncInterval
+	}
+	if cfg.Port > 0 {
+		s.port = cfg.Port
+	}
+	if len(s.alpn) == 0 {
+		s.alpn = []string{"h3"}
+	}
+	for _, proto := range s.alpn {
+		if proto == "" || len(proto) > 255 {
+			return nil, fmt.Errorf("dns 'alpn': invalid protocol %q", proto)
+		}
+	}
+	if s.ttl == 0 {
+		s.ttl = defaultTTL
+	}
+	for _, a := range cfg.IPv4 {
+		ip, err := netip.ParseAddr(a)
+		if err != nil || !ip.Is4() {
+			return nil, fmt.Errorf("dns 'ipv4': invalid address %q", a)
+		}
+		s.ipv4 = append(s.ipv4, ip)
+	}
+	for _, a := range cfg.IPv6 {
+		ip, err := netip.ParseAddr(a)
+		if err != nil || !ip.Is6() || ip.Is4In6() {
+			return nil, fmt.Errorf("dns 'ipv6': invalid address %q", a)
+		}
+		s.ipv6 = append(s.ipv6, ip)
+	}
+	if cfg.ECH != "" {
+		ech, err := base64.StdEncoding.DecodeString(cfg.ECH)
+		if err != nil || len(ech) < 2 || int(binary.BigEndian.Uint16(ech)) != len(ech)-2 {
+			return nil, errors.New("dns 'ech' must be a base64 ECHConfigList")
+		}
+		s.ech = ech
+	}
+	if cfg.Update != nil {
+		var err error
+		if s.updater, err = newUpdater(*cfg.Update); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Names) > 0 {
+		for _, name := range cfg.Names {
+			if _, err := dnsmessage.NewName(canonical(name)); err != nil || strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+				return nil, fmt.Errorf("dns 'names': invalid name %q", name)
+			}
+		}
+		names := slices.Clone(cfg.Names)
+		s.names = func() []string { return names }
+	}
+	return s, nil
+}
+
+// canonical returns name in lower case with a trailing dot.
+func canonical(name string) string {
+	name = strings.ToLower(name)
+	if !strings.HasSuffix(name, ".") {
+		name += "."
+	}
+	return name
+}
+
+// serves reports whether qname (canonical) is one of the configured names.
+func (s *Server) serves(qname string) bool {
+	for _, name := range s.names() {
+		name = canonical(name)
+		if name == qname {
+			return true
+		}
+		if suffix, ok := strings.CutPrefix(name, "*"); ok && strings.HasSuffix(qname, suffix) && len(qname) > len(suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// Start begins answering queries and publishing records in the background.
+func (s *Server) Start() error {
+	if s.listen != "" {
+		conn, err := net.ListenPacket("udp", s.listen)
+		if err != nil {
+			return err
+		}
+		s.conn = conn
+		logger.Printf("DNS responder listening on %s", conn.LocalAddr())
+		go s.serve()
+	}
+	if s.zoneFile != "" || s.updater != nil {
+		go s.sync()
+	}
+	return nil
+}
+
+// Close stops the server.
+func (s *Server) Close() error {
+	close(s.done)
+	if s.conn == nil {
+		return nil
+	}
+	return s.conn.Close()
+}
+
+func (s *Server) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, addr, err := s.conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("DNS responder stopped: %v", err)
+			}
+			return
+		}
+		resp, err := s.answer(buf[:n])
+		if err != nil {
+			logger.Debugf("query from %s: %v", addr, err)
+			continue
+		}
+		s.conn.WriteTo(resp, addr)
+	}
+}
+
+// answer builds the response to a query. Names that are not configured are
+// refused, so the responder never acts as a resolver.
+func (s *Server) answer(query []byte) ([]byte, error) {
+	var p dnsmessage.Parser
+	hdr, err := p.Start(query)
+	if err != nil {
+		return nil, err
+	}
+	if hdr.Response {
+		return nil, errors.New("not a query")
+	}
+	q, err := p.Question()
+	if err != nil {
+		return nil, err
+	}
+
+	resp := dnsmessage.Header{ID: hdr.ID, Response: true, OpCode: hdr.OpCode, RecursionDesired: hdr.RecursionDesired}
+	var answers []dnsmessage.Resource
+	switch {
+	case hdr.OpCode != 0:
+		resp.RCode = dnsmessage.RCodeNotImplemented
+	case q.Class != dnsmessage.ClassINET || !s.serves(canonical(q.Name.String())):
+		resp.RCode = dnsmessage.RCodeRefused
+	default:
+		resp.Authoritative = true
+		answers = s.records(q)
+	}
+	logger.Debugf("%s %s: %s, %d answers", q.Type, q.Name, resp.RCode, len(answers))
+
+	msg, err := build(resp, q, answers)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg) > maxUDPSize {
+		resp.Truncated = true
+		return build(resp, q, nil)
+	}
+	return msg, nil
+}
+
+// records returns the answers for a configured name.
+func (s *Server) records(q dnsmessage.Question) []dnsmessage.Resource {
+	rh := dnsmessage.ResourceHeader{Name: q.Name, Class: dnsmessage.ClassINET, TTL: s.ttl}
+	var answers []dnsmessage.Resource
+	switch q.Type {
+	case dnsmessage.TypeA:
+		rh.Type = dnsmessage.TypeA
+		for _, ip := range s.ipv4 {
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AResource{A: ip.As4()}})
+		}
+	case dnsmessage.TypeAAAA:
+		rh.Type = dnsmessage.TypeAAAA
+		for _, ip := range s.ipv6 {
+			answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.AAAAResource{AAAA: ip.As16()}})
+		}
+	case dnsmessage.TypeHTTPS:
+		rh.Type = dnsmessage.TypeHTTPS
+		answers = append(answers, dnsmessage.Resource{Header: rh, Body: &dnsmessage.HTTPSResource{SVCBResource: s.service()}})
+	case dnsmessage.TypeSVCB:
+		rh.Type = dnsmessage.TypeSVCB
+		svcb := s.service()
+		answers = append(answers, dnsmessage.Resource{Header: rh, Body: &svcb})
+	}
+	return answers
+}
+
+// service returns the ServiceMode record pointing clients at the relay:
+// priority 1, the owner name as target, and alpn, port, address hints and
+// the ECH configs.
+func (s *Server) service() dnsmessage.SVCBResource {
+	r := dnsmessage.SVCBResource{Priority: 1, Target: dnsmessage.MustNewName(".")}
+	var alpn []byte
+	for _, proto := range s.alpn {
+		alpn = append(append(alpn, byte(len(proto))), proto...)
+	}
+	r.SetParam(dnsmessage.SVCParamALPN, alpn)
+	if s.port != 0 && s.port != 443 {
+		r.SetParam(dnsmessage.SVCParamPort, []byte{byte(s.port >> 8), byte(s.port)})
+	}
+	if len(s.ipv4) > 0 {
+		var hint []byte
+		for _, ip := range s.ipv4 {
+			hint = append(hint, ip.AsSlice()...)
+		}
+		r.SetParam(dnsmessage.SVCParamIPv4Hint, hint)
+	}
+	if s.ech != nil {
+		r.SetParam(dnsmessage.SVCParamECH, s.ech)
+	}
+	if len(s.ipv6) > 0 {
+		var hint []byte
+		for _, ip := range s.ipv6 {
+			hint = append(hint, ip.AsSlice()...)
+		}
+		r.SetParam(dnsmessage.SVCParamIPv6Hint, hint)
+	}
+	return r
+}
+
+// build packs a response with the question echoed back.
+func build(hdr dnsmessage.Header, q dnsmessage.Question, answers []dnsmessage.Resource) ([]byte, error) {
+	msg := dnsmessage.Message{Header: hdr, Questions: []dnsmessage.Question{q}, Answers: answers}
+	return msg.Pack()
+}
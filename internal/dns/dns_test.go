@@ -0,0 +1,174 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func query(t *testing.T, name string, typ dnsmessage.Type) []byte {
+	t.Helper()
+	msg := dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: 7, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: dnsmessage.MustNewName(name), Type: typ, Class: dnsmessage.ClassINET}},
+	}
+	b, err := msg.Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func newTestServer(t *testing.T, cfg Config) *Server {
+	t.Helper()
+	if cfg.Listen == "" {
+		cfg.Listen = "127.0.0.1:0"
+	}
+	s, err := NewServer(cfg, 5520, func() []string { return []string{"play.example.com"} })
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func ask(t *testing.T, s *Server, name string, typ dnsmessage.Type) dnsmessage.Message {
+	t.Helper()
+	resp, err := s.answer(query(t, name, typ))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(resp); err != nil {
+		t.Fatal(err)
+	}
+	if msg.ID != 7 || !msg.Response {
+		t.Errorf("header = %+v", msg.Header)
+	}
+	return msg
+}
+
+func TestServer_AddressRecords(t *testing.T) {
+	s := newTestServer(t, Config{IPv4: []string{"203.0.113.10", "203.0.113.11"}, IPv6: []string{"2001:db8::10"}, TTL: 60})
+
+	msg := ask(t, s, "Play.Example.com.", dnsmessage.TypeA)
+	if msg.RCode != dnsmessage.RCodeSuccess || !msg.Authoritative || len(msg.Answers) != 2 {
+		t.Fatalf("A: rcode %v, aa %v, %d answers", msg.RCode, msg.Authoritative, len(msg.Answers))
+	}
+	if a := msg.Answers[0].Body.(*dnsmessage.AResource); a.A != [4]byte{203, 0, 113, 10} || msg.Answers[0].Header.TTL != 60 {
+		t.Errorf("A answer = %v ttl %d", a.A, msg.Answers[0].Header.TTL)
+	}
+
+	msg = ask(t, s, "play.example.com.", dnsmessage.TypeAAAA)
+	if len(msg.Answers) != 1 {
+		t.Fatalf("AAAA: %d answers", len(msg.Answers))
+	}
+
+	// Configured name, other type: no data
+	msg = ask(t, s, "play.example.com.", dnsmessage.TypeMX)
+	if msg.RCode != dnsmessage.RCodeSuccess || len(msg.Answers) != 0 {
+		t.Errorf("MX: rcode %v, %d answers", msg.RCode, len(msg.Answers))
+	}
+
+	// Unknown names are refused
+	msg = ask(t, s, "example.org.", dnsmessage.TypeA)
+	if msg.RCode != dnsmessage.RCodeRefused || len(msg.Answers) != 0 {
+		t.Errorf("unknown name: rcode %v, %d answers", msg.RCode, len(msg.Answers))
+	}
+}
+
+func TestServer_HTTPSRecord(t *testing.T) {
+	s := newTestServer(t, Config{IPv4: []string{"203.0.113.10"}, IPv6: []string{"2001:db8::10"}})
+
+	msg := ask(t, s, "play.example.com.", dnsmessage.TypeHTTPS)
+	if len(msg.Answers) != 1 {
+		t.Fatalf("HTTPS: %d answers", len(msg.Answers))
+	}
+	rr := msg.Answers[0].Body.(*dnsmessage.HTTPSResource)
+	if rr.Priority != 1 || rr.Target.String() != "." {
+		t.Errorf("priority %d target %s", rr.Priority, rr.Target)
+	}
+	checks := map[dnsmessage.SVCParamKey][]byte{
+		dnsmessage.SVCParamALPN:     {2, 'h', '3'},
+		dnsmessage.SVCParamPort:     {0x15, 0x90},
+		dnsmessage.SVCParamIPv4Hint: {203, 0, 113, 10},
+		dnsmessage.SVCParamIPv6Hint: net.ParseIP("2001:db8::10"),
+	}
+	for key, want := range checks {
+		if got, ok := rr.GetParam(key); !ok || string(got) != string(want) {
+			t.Errorf("%v = %v, want %v", key, got, want)
+		}
+	}
+
+	// Port 443 is implied
+	s = newTestServer(t, Config{IPv4: []string{"203.0.113.10"}, Port: 443})
+	rr = ask(t, s, "play.example.com.", dnsmessage.TypeHTTPS).Answers[0].Body.(*dnsmessage.HTTPSResource)
+	if _, ok := rr.GetParam(dnsmessage.SVCParamPort); ok {
+		t.Error("port param set for 443")
+	}
+}
+
+func TestServer_Names(t *testing.T) {
+	s := newTestServer(t, Config{IPv4: []string{"203.0.113.10"}, Names: []string{"*.games.example.com", "relay.example.com"}})
+	for name, want := range map[string]dnsmessage.RCode{
+		"a.games.example.com.":   dnsmessage.RCodeSuccess,
+		"a.b.games.example.com.": dnsmessage.RCodeSuccess,
+		"games.example.com.":     dnsmessage.RCodeRefused,
+		"relay.example.com.":     dnsmessage.RCodeSuccess,
+		"play.example.com.":      dnsmessage.RCodeRefused, // Configured names replace the route names
+	} {
+		if got := ask(t, s, name, dnsmessage.TypeA).RCode; got != want {
+			t.Errorf("%s: rcode %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestNewServer_Errors(t *testing.T) {
+	tests := []struct {
+		cfg     Config
+		wantErr string
+	}{
+		{Config{IPv4: []string{"203.0.113.10"}}, "requires 'listen', 'zone_file' or 'update'"},
+		{Config{Listen: ":53", IPv4: []string{"203.0.113.10"}, ECH: "AAAA"}, "base64 ECHConfigList"},
+		{Config{ZoneFile: "z", IPv4: []string{"203.0.113.10"}, Update: &UpdateConfig{Server: "ns:53"}}, "'zone' is required"},
+		{Config{ZoneFile: "z", IPv4: []string{"203.0.113.10"}, Update: &UpdateConfig{Server: "ns:53", Zone: "a", TSIGKey: "k", TSIGSecret: "!"}}, "must be base64"},
+		{Config{Listen: ":53"}, "'ipv4' or 'ipv6'"},
+		{Config{Listen: ":53", IPv4: []string{"2001:db8::1"}}, "invalid address"},
+		{Config{Listen: ":53", IPv6: []string{"203.0.113.10"}}, "invalid address"},
+		{Config{Listen: ":53", IPv4: []string{"203.0.113.10"}, Names: []string{"a.*.example.com"}}, "invalid name"},
+		{Config{Listen: ":53", IPv4: []string{"203.0.113.10"}, ALPN: []string{""}}, "invalid protocol"},
+	}
+	for _, tt := range tests {
+		_, err := NewServer(tt.cfg, 5520, nil)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%+v: error = %v, want %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
+
+func TestServer_UDP(t *testing.T) {
+	s := newTestServer(t, Config{IPv4: []string{"203.0.113.10"}})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	conn, err := net.Dial("udp", s.conn.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.Write(query(t, "play.example.com.", dnsmessage.TypeA))
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 512)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var msg dnsmessage.Message
+	if err := msg.Unpack(buf[:n]); err != nil || len(msg.Answers) != 1 {
+		t.Errorf("answer: %v, %+v", err, msg)
+	}
+}
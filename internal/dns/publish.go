@@ -0,0 +1,166 @@
+package dns
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// publishedTypes are the record types written to the zone file and the primary.
+var publishedTypes = []dnsmessage.Type{dnsmessage.TypeA, dnsmessage.TypeAAAA, dnsmessage.TypeHTTPS}
+
+// sync publishes the records now and whenever they change, until Close.
+func (s *Server) sync() {
+	ticker := time.NewTicker(s.syncInterval)
+	defer ticker.Stop()
+	for {
+		if err := s.publish(); err != nil {
+			logger.Warnf("publishing records failed, retrying in %v: %v", s.syncInterval, err)
+		}
+		select {
+		case <-ticker.C:
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// publish writes the zone file and updates the primary if the records
+// changed since the last successful run.
+func (s *Server) publish() error {
+	names := s.publishedNamesNow()
+	zone := s.zoneText(names)
+	if zone == s.published {
+		return nil
+	}
+	if s.zoneFile != "" {
+		if err := writeFileAtomic(s.zoneFile, []byte(zone)); err != nil {
+			return err
+		}
+	}
+	if s.updater != nil {
+		if err := s.updater.send(s.publishedNames, names, s.resources); err != nil {
+			return err
+		}
+	}
+	logger.Printf("published records for %d names", len(names))
+	s.published, s.publishedNames = zone, names
+	return nil
+}
+
+// publishedNamesNow returns the names to publish, canonical, sorted and
+// without duplicates.
+func (s *Server) publishedNamesNow() []string {
+	var names []string
+	for _, name := range s.names() {
+		names = append(names, canonical(name))
+	}
+	slices.Sort(names)
+	return slices.Compact(names)
+}
+
+// resources returns the published records of name.
+func (s *Server) resources(name string) []dnsmessage.Resource {
+	n, err := dnsmessage.NewName(name)
+	if err != nil {
+		return nil
+	}
+	var rrs []dnsmessage.Resource
+	for _, typ := range publishedTypes {
+		rrs = append(rrs, s.records(dnsmessage.Question{Name: n, Type: typ, Class: dnsmessage.ClassINET})...)
+	}
+	return rrs
+}
+
+// zoneText renders the records of names in zone file format.
+func (s *Server) zoneText(names []string) string {
+	var b strings.Builder
+	b.WriteString("; Generated by quic-relay from its routes, do not edit.\n")
+	for _, name := range names {
+		for _, rr := range s.resources(name) {
+			fmt.Fprintf(&b, "%s\t%d\tIN\t%s\t%s\n", rr.Header.Name, rr.Header.TTL, strings.TrimPrefix(rr.Header.Type.String(), "Type"), rdataText(rr.Body))
+		}
+	}
+	return b.String()
+}
+
+// rdataText renders a record body in presentation format.
+func rdataText(body dnsmessage.ResourceBody) string {
+	switch rr := body.(type) {
+	case *dnsmessage.AResource:
+		return netip.AddrFrom4(rr.A).String()
+	case *dnsmessage.AAAAResource:
+		return netip.AddrFrom16(rr.AAAA).String()
+	case *dnsmessage.HTTPSResource:
+		return svcbText(rr.SVCBResource)
+	case *dnsmessage.SVCBResource:
+		return svcbText(*rr)
+	}
+	return ""
+}
+
+// svcbText renders the SvcParams of the records this package builds
+// (RFC 9460, section 2.1).
+func svcbText(rr dnsmessage.SVCBResource) string {
+	parts := []string{strconv.Itoa(int(rr.Priority)), rr.Target.String()}
+	for _, p := range rr.Params {
+		var value string
+		switch p.Key {
+		case dnsmessage.SVCParamALPN:
+			var protos []string
+			for v := p.Value; len(v) > 0 && len(v) > int(v[0]); v = v[1+v[0]:] {
+				protos = append(protos, string(v[1:1+v[0]]))
+			}
+			value = strings.Join(protos, ",")
+		case dnsmessage.SVCParamPort:
+			value = strconv.Itoa(int(binary.BigEndian.Uint16(p.Value)))
+		case dnsmessage.SVCParamIPv4Hint, dnsmessage.SVCParamIPv6Hint:
+			size := 4
+			if p.Key == dnsmessage.SVCParamIPv6Hint {
+				size = 16
+			}
+			var addrs []string
+			for v := p.Value; len(v) >= size; v = v[size:] {
+				addr, _ := netip.AddrFromSlice(v[:size])
+				addrs = append(addrs, addr.String())
+			}
+			value = strings.Join(addrs, ",")
+		case dnsmessage.SVCParamECH:
+			value = base64.StdEncoding.EncodeToString(p.Value)
+		default:
+			continue
+		}
+		parts = append(parts, strings.ToLower(p.Key.String())+"="+value)
+	}
+	return strings.Join(parts, " ")
+}
+
+// writeFileAtomic replaces path with data, so readers never see a partial file.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
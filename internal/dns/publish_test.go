@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// testECH is an ECHConfigList with one made-up config.
+const testECH = "AAf+DQADAQID"
+
+func TestServer_ECH(t *testing.T) {
+	s := newTestServer(t, Config{IPv4: []string{"203.0.113.10"}, ECH: testECH})
+	rr := ask(t, s, "play.example.com.", dnsmessage.TypeHTTPS).Answers[0].Body.(*dnsmessage.HTTPSResource)
+	if got, ok := rr.GetParam(dnsmessage.SVCParamECH); !ok || len(got) != 9 || got[1] != 7 {
+		t.Errorf("ech = %v", got)
+	}
+}
+
+func TestServer_ZoneFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.zone")
+	names := []string{"play.example.com", "*.games.example.com", "Play.Example.com"}
+	s, err := NewServer(Config{
+		ZoneFile: path,
+		IPv4:     []string{"203.0.113.10"},
+		IPv6:     []string{"2001:db8::10"},
+		ECH:      testECH,
+		TTL:      60,
+	}, 5520, func() []string { return names })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.publish(); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `; Generated by quic-relay from its routes, do not edit.
+*.games.example.com.	60	IN	A	203.0.113.10
+*.games.example.com.	60	IN	AAAA	2001:db8::10
+*.games.example.com.	60	IN	HTTPS	1 . alpn=h3 port=5520 ipv4hint=203.0.113.10 ech=AAf+DQADAQID ipv6hint=2001:db8::10
+play.example.com.	60	IN	A	203.0.113.10
+play.example.com.	60	IN	AAAA	2001:db8::10
+play.example.com.	60	IN	HTTPS	1 . alpn=h3 port=5520 ipv4hint=203.0.113.10 ech=AAf+DQADAQID ipv6hint=2001:db8::10
+`
+	if string(data) != want {
+		t.Errorf("zone file:\n%s\nwant:\n%s", data, want)
+	}
+
+	// Unchanged records are not written again
+	os.Remove(path)
+	s.publish()
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("zone file rewritten without changes")
+	}
+	names = names[:1]
+	s.publish()
+	if data, _ := os.ReadFile(path); strings.Contains(string(data), "games") {
+		t.Errorf("removed name still in zone file:\n%s", data)
+	}
+}
@@ -0,0 +1,212 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"math/rand/v2"
+	"net"
+	"slices"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+const (
+	opcodeUpdate  = 5 // RFC 2136
+	typeTSIG      = dnsmessage.Type(250)
+	classANY      = dnsmessage.Class(255)
+	tsigFudge     = 300 // Seconds of clock skew the server accepts
+	updateTimeout = 5 * time.Second
+)
+
+// UpdateConfig sends the records to a primary name server with dynamic
+// updates (RFC 2136), signed with TSIG (RFC 8945) when a key is set.
+type UpdateConfig struct {
+	Server        string `json:"server"`                   // Primary name server host:port
+	Zone          string `json:"zone"`                     // Zone to update, e.g. "example.com"
+	TSIGKey       string `json:"tsig_key,omitempty"`       // TSIG key name
+	TSIGSecret    string `json:"tsig_secret,omitempty"`    // Base64 TSIG secret
+	TSIGAlgorithm string `json:"tsig_algorithm,omitempty"` // "hmac-sha256" (default) or "hmac-sha512"
+}
+
+// updater sends dynamic updates for one zone.
+type updater struct {
+	server  string
+	zone    string // Canonical
+	keyName string // Canonical, "" without TSIG
+	alg     string // Canonical algorithm name
+	newHash func() hash.Hash
+	secret  []byte
+}
+
+func newUpdater(cfg UpdateConfig) (*updater, error) {
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		return nil, fmt.Errorf("dns update 'server': %w", err)
+	}
+	if cfg.Zone == "" {
+		return nil, errors.New("dns update 'zone' is required")
+	}
+	u := &updater{server: cfg.Server, zone: canonical(cfg.Zone)}
+	if cfg.TSIGKey == "" {
+		if cfg.TSIGSecret != "" {
+			return nil, errors.New("dns update 'tsig_secret' requires 'tsig_key'")
+		}
+		return u, nil
+	}
+	u.keyName = canonical(cfg.TSIGKey)
+	switch cfg.TSIGAlgorithm {
+	case "", "hmac-sha256":
+		u.alg, u.newHash = "hmac-sha256.", sha256.New
+	case "hmac-sha512":
+		u.alg, u.newHash = "hmac-sha512.", sha512.New
+	default:
+		return nil, fmt.Errorf("dns update: unknown tsig_algorithm %q", cfg.TSIGAlgorithm)
+	}
+	var err error
+	if u.secret, err = base64.StdEncoding.DecodeString(cfg.TSIGSecret); err != nil || len(u.secret) == 0 {
+		return nil, errors.New("dns update 'tsig_secret' must be base64")
+	}
+	return u, nil
+}
+
+// inZone reports whether name (canonical) belongs to the zone.
+func (u *updater) inZone(name string) bool {
+	return name == u.zone || strings.HasSuffix(name, "."+u.zone)
+}
+
+// send replaces the published record sets of names, and deletes those of
+// names that were published before but are gone. Names outside the zone are
+// skipped.
+func (u *updater) send(old, names []string, resources func(name string) []dnsmessage.Resource) error {
+	var updates []dnsmessage.Resource
+	deleteSets := func(name string) {
+		n := dnsmessage.MustNewName(name)
+		for _, typ := range publishedTypes {
+			updates = append(updates, dnsmessage.Resource{
+				Header: dnsmessage.ResourceHeader{Name: n, Class: classANY},
+				Body:   &dnsmessage.UnknownResource{Type: typ},
+			})
+		}
+	}
+	for _, name := range old {
+		if u.inZone(name) && !slices.Contains(names, name) {
+			deleteSets(name)
+		}
+	}
+	for _, name := range names {
+		if !u.inZone(name) {
+			logger.Warnf("%s is outside zone %s, not sent to %s", name, u.zone, u.server)
+			continue
+		}
+		deleteSets(name)
+		updates = append(updates, resources(name)...)
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+
+	msg := dnsmessage.Message{
+		Header:      dnsmessage.Header{ID: uint16(rand.Uint32()), OpCode: opcodeUpdate},
+		Questions:   []dnsmessage.Question{{Name: dnsmessage.MustNewName(u.zone), Type: dnsmessage.TypeSOA, Class: dnsmessage.ClassINET}},
+		Authorities: updates,
+	}
+	packed, err := msg.Pack()
+	if err != nil {
+		return err
+	}
+	if u.keyName != "" {
+		packed = u.sign(packed, time.Now())
+	}
+	return u.exchange(packed)
+}
+
+// exchange sends an update over TCP and checks the response code.
+func (u *updater) exchange(msg []byte) error {
+	conn, err := net.DialTimeout("tcp", u.server, updateTimeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(updateTimeout))
+	if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(msg))), msg...)); err != nil {
+		return err
+	}
+	var size [2]byte
+	if _, err := io.ReadFull(conn, size[:]); err != nil {
+		return err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(size[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return err
+	}
+	var p dnsmessage.Parser
+	hdr, err := p.Start(resp)
+	if err != nil {
+		return err
+	}
+	if hdr.ID != binary.BigEndian.Uint16(msg) {
+		return errors.New("update response with wrong ID")
+	}
+	if hdr.RCode != dnsmessage.RCodeSuccess {
+		return fmt.Errorf("update of zone %s refused: %s", u.zone, hdr.RCode)
+	}
+	return nil
+}
+
+// sign appends a TSIG record to msg (RFC 8945, section 4.3).
+func (u *updater) sign(msg []byte, now time.Time) []byte {
+	var timeSigned [6]byte
+	secs := uint64(now.Unix())
+	binary.BigEndian.PutUint16(timeSigned[:2], uint16(secs>>32))
+	binary.BigEndian.PutUint32(timeSigned[2:], uint32(secs))
+
+	// TSIG variables: key name, class, TTL, algorithm, time, fudge, error, other
+	vars := appendWireName(nil, u.keyName)
+	vars = binary.BigEndian.AppendUint16(vars, uint16(classANY))
+	vars = binary.BigEndian.AppendUint32(vars, 0)
+	vars = appendWireName(vars, u.alg)
+	vars = append(vars, timeSigned[:]...)
+	vars = binary.BigEndian.AppendUint16(vars, tsigFudge)
+	vars = binary.BigEndian.AppendUint32(vars, 0) // Error, other length
+	mac := hmac.New(u.newHash, u.secret)
+	mac.Write(msg)
+	mac.Write(vars)
+	sum := mac.Sum(nil)
+
+	rdata := appendWireName(nil, u.alg)
+	rdata = append(rdata, timeSigned[:]...)
+	rdata = binary.BigEndian.AppendUint16(rdata, tsigFudge)
+	rdata = binary.BigEndian.AppendUint16(rdata, uint16(len(sum)))
+	rdata = append(rdata, sum...)
+	rdata = append(rdata, msg[0], msg[1]) // Original ID
+	rdata = binary.BigEndian.AppendUint32(rdata, 0)
+
+	out := appendWireName(msg, u.keyName)
+	out = binary.BigEndian.AppendUint16(out, uint16(typeTSIG))
+	out = binary.BigEndian.AppendUint16(out, uint16(classANY))
+	out = binary.BigEndian.AppendUint32(out, 0)
+	out = binary.BigEndian.AppendUint16(out, uint16(len(rdata)))
+	out = append(out, rdata...)
+	binary.BigEndian.PutUint16(out[10:], binary.BigEndian.Uint16(out[10:])+1) // ARCOUNT
+	return out
+}
+
+// appendWireName appends a canonical name in uncompressed wire format.
+func appendWireName(b []byte, name string) []byte {
+	for _, label := range strings.Split(strings.TrimSuffix(name, "."), ".") {
+		if label == "" {
+			continue
+		}
+		b = append(b, byte(len(label)))
+		b = append(b, label...)
+	}
+	return append(b, 0)
+}
@@ -0,0 +1,133 @@
+package dns
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// fakePrimary accepts one update over TCP, answers it with rcode and
+// returns the raw message.
+func fakePrimary(t *testing.T, rcode dnsmessage.RCode) (string, <-chan []byte) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	got := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var size [2]byte
+		io.ReadFull(conn, size[:])
+		msg := make([]byte, binary.BigEndian.Uint16(size[:]))
+		io.ReadFull(conn, msg)
+		got <- msg
+		resp, _ := (&dnsmessage.Message{Header: dnsmessage.Header{ID: binary.BigEndian.Uint16(msg), Response: true, OpCode: opcodeUpdate, RCode: rcode}}).Pack()
+		conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(resp))), resp...))
+	}()
+	return ln.Addr().String(), got
+}
+
+func TestUpdater_Send(t *testing.T) {
+	addr, got := fakePrimary(t, dnsmessage.RCodeSuccess)
+	u, err := newUpdater(UpdateConfig{Server: addr, Zone: "Example.com", TSIGKey: "relay", TSIGSecret: "c2VjcmV0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, Config{IPv4: []string{"203.0.113.10"}})
+	old := []string{"gone.example.com.", "play.example.com."}
+	names := []string{"other.example.org.", "play.example.com."}
+	if err := u.send(old, names, s.resources); err != nil {
+		t.Fatal(err)
+	}
+	raw := <-got
+
+	// Deletions have empty RDATA, which Message.Unpack cannot read as HTTPS
+	var p dnsmessage.Parser
+	hdr, err := p.Start(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q, err := p.Question()
+	if err != nil || hdr.OpCode != opcodeUpdate || q.Name.String() != "example.com." || q.Type != dnsmessage.TypeSOA {
+		t.Errorf("header %+v, zone %+v, %v", hdr, q, err)
+	}
+	p.SkipAllQuestions()
+	p.SkipAllAnswers()
+	// Deletes for gone and play (A, AAAA, HTTPS each), then play's A and HTTPS
+	var deletes, adds []string
+	for {
+		rh, err := p.AuthorityHeader()
+		if err != nil {
+			break
+		}
+		p.UnknownResource()
+		if rh.Class == classANY {
+			deletes = append(deletes, rh.Name.String()+" "+rh.Type.String())
+		} else {
+			adds = append(adds, rh.Name.String()+" "+rh.Type.String())
+		}
+	}
+	if len(deletes) != 6 || deletes[0] != "gone.example.com. TypeA" || deletes[3] != "play.example.com. TypeA" {
+		t.Errorf("deletes = %v", deletes)
+	}
+	if len(adds) != 2 || adds[0] != "play.example.com. TypeA" || adds[1] != "play.example.com. TypeHTTPS" {
+		t.Errorf("adds = %v", adds)
+	}
+
+	// The TSIG record is last; its MAC covers the message without it
+	rh, err := p.AdditionalHeader()
+	if err != nil || rh.Type != typeTSIG || rh.Name.String() != "relay." {
+		t.Fatalf("additional = %+v, %v", rh, err)
+	}
+	tsig, err := p.UnknownResource()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rdata := tsig.Data
+	alg := appendWireName(nil, "hmac-sha256.")
+	macSize := int(binary.BigEndian.Uint16(rdata[len(alg)+8:]))
+	mac := rdata[len(alg)+10 : len(alg)+10+macSize]
+
+	unsigned := append([]byte(nil), raw[:len(raw)-len(appendWireName(nil, "relay."))-10-len(rdata)]...)
+	binary.BigEndian.PutUint16(unsigned[10:], 0)
+	vars := appendWireName(nil, "relay.")
+	vars = binary.BigEndian.AppendUint16(vars, uint16(classANY))
+	vars = binary.BigEndian.AppendUint32(vars, 0)
+	vars = append(vars, alg...)
+	vars = append(vars, rdata[len(alg):len(alg)+8]...) // Time signed, fudge
+	vars = binary.BigEndian.AppendUint32(vars, 0)
+	h := hmac.New(sha256.New, []byte("secret"))
+	h.Write(unsigned)
+	h.Write(vars)
+	if !hmac.Equal(mac, h.Sum(nil)) {
+		t.Error("TSIG MAC does not verify")
+	}
+	signed := int64(binary.BigEndian.Uint16(rdata[len(alg):]))<<32 | int64(binary.BigEndian.Uint32(rdata[len(alg)+2:]))
+	if d := time.Since(time.Unix(signed, 0)); d < 0 || d > time.Minute {
+		t.Errorf("time signed off by %v", d)
+	}
+}
+
+func TestUpdater_Refused(t *testing.T) {
+	addr, _ := fakePrimary(t, dnsmessage.RCodeRefused)
+	u, err := newUpdater(UpdateConfig{Server: addr, Zone: "example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestServer(t, Config{IPv4: []string{"203.0.113.10"}})
+	if err := u.send(nil, []string{"play.example.com."}, s.resources); err == nil {
+		t.Error("refused update reported as success")
+	}
+}
@@ -0,0 +1,408 @@
+// Package doctor checks whether a host and configuration are fit to run the
+// relay: kernel socket limits, file descriptors, UDP offloads, backend
+// reachability, certificates and the clock. Each finding comes with a hint on
+// how to fix it.
+package doctor
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+// Status is the outcome of a check.
+type Status int
+
+const (
+	OK Status = iota
+	Warn
+	Fail
+)
+
+func (s Status) String() string {
+	switch s {
+	case Warn:
+		return "warn"
+	case Fail:
+		return "FAIL"
+	}
+	return "ok"
+}
+
+// Result is the outcome of one check.
+type Result struct {
+	Check  string `json:"check"`
+	Status Status `json:"-"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"` // How to fix a warning or failure
+}
+
+// MarshalJSON encodes the status by name.
+func (r Result) MarshalJSON() ([]byte, error) {
+	type plain Result
+	return json.Marshal(struct {
+		plain
+		Status string `json:"status"`
+	}{plain(r), r.Status.String()})
+}
+
+// Options configures a run.
+type Options struct {
+	Config       *proxy.Config
+	Chain        *handler.Chain // Built from Config.Handlers; lists the backends
+	ProbeTimeout time.Duration  // Per backend (default: 2s)
+}
+
+// Thresholds for warnings.
+const (
+	minFileLimit   = 65536               // Each session holds a backend socket
+	certExpirySoon = 14 * 24 * time.Hour // Warn this long before a certificate expires
+)
+
+// now is the clock used for certificate checks (overridable in tests).
+var now = time.Now
+
+// Run performs all checks.
+func Run(opts Options) []Result {
+	if opts.ProbeTimeout == 0 {
+		opts.ProbeTimeout = 2 * time.Second
+	}
+	var results []Result
+	results = append(results, checkClock()...)
+	results = append(results, checkSocketBuffers(opts.Config)...)
+	results = append(results, checkFileLimit())
+	results = append(results, checkOffload()...)
+	results = append(results, checkCertificates(opts.Config)...)
+	if opts.Chain != nil {
+		results = append(results, checkBackends(opts.Chain, opts.ProbeTimeout)...)
+	}
+	return results
+}
+
+// checkClock catches clocks that are obviously wrong or not synchronized.
+// Certificate checks, schedules and token expiry depend on the time.
+func checkClock() []Result {
+	t := now()
+	if t.Year() < 2025 {
+		return []Result{{
+			Check:  "clock",
+			Status: Fail,
+			Detail: "system time is " + t.Format(time.RFC3339),
+			Hint:   "set the time and enable NTP (chrony, systemd-timesyncd or ntpd)",
+		}}
+	}
+	synced, known := clockSynchronized()
+	switch {
+	case !known:
+		return []Result{{Check: "clock", Status: OK, Detail: t.Format(time.RFC3339) + " (synchronization status unknown on this platform)"}}
+	case !synced:
+		return []Result{{
+			Check:  "clock",
+			Status: Warn,
+			Detail: "not synchronized by NTP",
+			Hint:   "enable time synchronization, e.g. timedatectl set-ntp true",
+		}}
+	}
+	return []Result{{Check: "clock", Status: OK, Detail: "synchronized by NTP"}}
+}
+
+// checkSocketBuffers opens a socket and requests the configured buffer sizes,
+// the way the relay does for listeners and backend sockets.
+func checkSocketBuffers(cfg *proxy.Config) []Result {
+	var sb proxy.SocketBuffersConfig
+	if cfg != nil && cfg.SocketBuffers != nil {
+		sb = *cfg.SocketBuffers
+	}
+	var results []Result
+	for _, c := range []struct {
+		name string
+		cfg  handler.SocketBufferConfig
+	}{
+		{"listener", sb.Listener.WithDefault(handler.DefaultListenerBuffer)},
+		{"backend", sb.Backend.WithDefault(handler.DefaultBackendBuffer)},
+	} {
+		check := "udp buffers (" + c.name + ")"
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			results = append(results, Result{Check: check, Status: Fail, Detail: err.Error()})
+			continue
+		}
+		res, err := handler.ApplySocketBuffers(conn, c.cfg)
+		conn.Close()
+		switch {
+		case err != nil:
+			results = append(results, Result{Check: check, Status: Warn, Detail: err.Error(), Hint: bufferHint(c.cfg)})
+		case res.ReceiveClamped() || res.SendClamped():
+			results = append(results, Result{
+				Check:  check,
+				Status: Warn,
+				Detail: fmt.Sprintf("kernel granted receive %s / send %s, wanted %s / %s", size(res.ActualReceive), size(res.ActualSend), size(res.Receive), size(res.Send)),
+				Hint:   bufferHint(c.cfg),
+			})
+		case res.ActualReceive == 0:
+			results = append(results, Result{Check: check, Status: OK, Detail: "sizes accepted (actual sizes not reported on this platform)"})
+		default:
+			results = append(results, Result{Check: check, Status: OK, Detail: fmt.Sprintf("receive %s, send %s", size(res.ActualReceive), size(res.ActualSend))})
+		}
+	}
+	return results
+}
+
+// checkFileLimit warns when the descriptor limit caps the number of sessions.
+func checkFileLimit() Result {
+	soft, hard, ok := fileLimit()
+	if !ok {
+		return Result{Check: "file descriptors", Status: OK, Detail: "not limited on this platform"}
+	}
+	detail := fmt.Sprintf("soft limit %d, hard limit %d", soft, hard)
+	if soft < minFileLimit {
+		return Result{
+			Check:  "file descriptors",
+			Status: Warn,
+			Detail: detail + fmt.Sprintf("; each session uses one, so at most about %d sessions", soft),
+			Hint:   fmt.Sprintf("raise the limit to at least %d: LimitNOFILE= in the systemd unit, or ulimit -n", minFileLimit),
+		}
+	}
+	return Result{Check: "file descriptors", Status: OK, Detail: detail}
+}
+
+// checkOffload reports UDP segmentation (GSO) and receive offload (GRO) support,
+// used by QUIC endpoints such as the terminator to cut per-packet overhead.
+func checkOffload() []Result {
+	gso, gro, supported := udpOffload()
+	if !supported {
+		return []Result{{Check: "udp offload", Status: OK, Detail: "GSO/GRO not available on this platform"}}
+	}
+	var missing []string
+	if !gso {
+		missing = append(missing, "GSO")
+	}
+	if !gro {
+		missing = append(missing, "GRO")
+	}
+	if len(missing) > 0 {
+		return []Result{{
+			Check:  "udp offload",
+			Status: Warn,
+			Detail: fmt.Sprintf("%v not supported by the kernel", missing),
+			Hint:   "UDP GSO needs Linux 4.18+ and GRO 5.0+; upgrade the kernel for lower CPU use under load",
+		}}
+	}
+	return []Result{{Check: "udp offload", Status: OK, Detail: "GSO and GRO supported"}}
+}
+
+// certFile is a certificate referenced by the configuration.
+type certFile struct {
+	name      string
+	cert, key string // key is empty for CA bundles
+}
+
+// configCertificates lists the certificates the configuration refers to.
+func configCertificates(cfg *proxy.Config) []certFile {
+	if cfg == nil {
+		return nil
+	}
+	var files []certFile
+	if cfg.Admin != nil && cfg.Admin.TLS != nil {
+		files = append(files, certFile{"admin", cfg.Admin.TLS.Cert, cfg.Admin.TLS.Key})
+		if cfg.Admin.TLS.ClientCA != "" {
+			files = append(files, certFile{"admin client CA", cfg.Admin.TLS.ClientCA, ""})
+		}
+	}
+	for _, hc := range cfg.Handlers {
+		if hc.Type != "terminator" {
+			continue
+		}
+		var tc handler.TerminatorHandlerConfig
+		if json.Unmarshal(hc.Config, &tc) != nil || tc.Certs == nil {
+			continue
+		}
+		if c := tc.Certs.Default; c != nil {
+			files = append(files, certFile{"terminator default", c.Cert, c.Key})
+		}
+		for _, target := range slices.Sorted(maps.Keys(tc.Certs.Targets)) {
+			if c := tc.Certs.Targets[target]; c != nil {
+				files = append(files, certFile{"terminator " + target, c.Cert, c.Key})
+			}
+		}
+	}
+	return files
+}
+
+// checkCertificates loads each certificate and checks its validity window.
+func checkCertificates(cfg *proxy.Config) []Result {
+	var results []Result
+	for _, f := range configCertificates(cfg) {
+		check := "certificate " + f.name
+		certs, err := loadCertificates(f)
+		if err != nil {
+			results = append(results, Result{Check: check, Status: Fail, Detail: err.Error(), Hint: "check the cert and key paths and that the key belongs to the certificate"})
+			continue
+		}
+		for _, c := range certs {
+			results = append(results, certValidity(check, c))
+		}
+	}
+	return results
+}
+
+func loadCertificates(f certFile) ([]*x509.Certificate, error) {
+	if f.key != "" {
+		pair, err := tls.LoadX509KeyPair(f.cert, f.key)
+		if err != nil {
+			return nil, err
+		}
+		leaf, err := x509.ParseCertificate(pair.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+		return []*x509.Certificate{leaf}, nil
+	}
+
+	data, err := os.ReadFile(f.cert)
+	if err != nil {
+		return nil, err
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, c)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("%s: no certificates found", f.cert)
+	}
+	return certs, nil
+}
+
+// certValidity checks that c is valid now and does not expire soon.
+func certValidity(check string, c *x509.Certificate) Result {
+	t := now()
+	subject := c.Subject.CommonName
+	if subject == "" && len(c.DNSNames) > 0 {
+		subject = c.DNSNames[0]
+	}
+	days := int(c.NotAfter.Sub(t).Hours() / 24)
+	switch {
+	case t.Before(c.NotBefore):
+		return Result{Check: check, Status: Fail, Detail: fmt.Sprintf("%s not valid before %s", subject, c.NotBefore.Format(time.RFC3339)), Hint: "check the system clock, or wait until the certificate becomes valid"}
+	case t.After(c.NotAfter):
+		return Result{Check: check, Status: Fail, Detail: fmt.Sprintf("%s expired %s", subject, c.NotAfter.Format(time.RFC3339)), Hint: "renew the certificate and reload"}
+	case c.NotAfter.Sub(t) < certExpirySoon:
+		return Result{Check: check, Status: Warn, Detail: fmt.Sprintf("%s expires in %d days (%s)", subject, days, c.NotAfter.Format(time.RFC3339)), Hint: "renew the certificate and reload"}
+	}
+	return Result{Check: check, Status: OK, Detail: fmt.Sprintf("%s valid until %s (%d days)", subject, c.NotAfter.Format("2006-01-02"), days)}
+}
+
+// nonQUICRouters route flows that are not QUIC, so their backends are not
+// expected to answer a QUIC probe.
+var nonQUICRouters = map[string]bool{"protocol-router": true, "wireguard": true, "raknet": true}
+
+// checkBackends probes all backends of the chain in parallel.
+func checkBackends(chain *handler.Chain, timeout time.Duration) []Result {
+	backends := chain.Backends()
+	if len(backends) == 0 {
+		return []Result{{Check: "backends", Status: Warn, Detail: "no backends found in the handler chain", Hint: "add a router (sni-router, simple-router, ...) before the forwarder"}}
+	}
+	addrs := slices.Sorted(maps.Keys(backends))
+	results := make([]Result, len(addrs))
+	var wg sync.WaitGroup
+	for i, addr := range addrs {
+		wg.Go(func() { results[i] = probeBackend(addr, !nonQUICRouters[backends[addr]], timeout) })
+	}
+	wg.Wait()
+	return results
+}
+
+// quicProbe returns a QUIC Initial-sized packet with a reserved version, which
+// QUIC servers answer with a Version Negotiation packet (RFC 9000 section 6).
+func quicProbe() []byte {
+	p := make([]byte, 1200)
+	p[0] = 0xc0
+	copy(p[1:5], []byte{0x1a, 0x2a, 0x3a, 0x4a}) // Reserved for forcing version negotiation
+	p[5] = 8
+	rand.Read(p[6:14]) // DCID
+	p[14] = 8
+	rand.Read(p[15:23]) // SCID
+	return p
+}
+
+// isVersionNegotiation reports whether b is a QUIC Version Negotiation packet.
+func isVersionNegotiation(b []byte) bool {
+	return len(b) >= 5 && b[0]&0x80 != 0 && b[1]|b[2]|b[3]|b[4] == 0
+}
+
+// probeBackend checks that addr resolves and that something listens there.
+// QUIC backends should answer the probe; other UDP services can only be
+// caught when the host reports the port closed.
+func probeBackend(addr string, isQUIC bool, timeout time.Duration) Result {
+	check := "backend " + addr
+	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return Result{Check: check, Status: Fail, Detail: err.Error(), Hint: "fix the address or its DNS record"}
+	}
+	conn, err := net.DialUDP("udp", nil, udpAddr)
+	if err != nil {
+		return Result{Check: check, Status: Fail, Detail: err.Error(), Hint: "check routing from this host to the backend"}
+	}
+	defer conn.Close()
+
+	probe := []byte{}
+	if isQUIC {
+		probe = quicProbe()
+	}
+	start := time.Now()
+	if _, err := conn.Write(probe); err != nil {
+		return Result{Check: check, Status: Fail, Detail: err.Error(), Hint: "check routing from this host to the backend"}
+	}
+	conn.SetReadDeadline(start.Add(timeout))
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	var netErr net.Error
+	switch {
+	case err == nil && isVersionNegotiation(buf[:n]):
+		return Result{Check: check, Status: OK, Detail: fmt.Sprintf("QUIC server answered in %v", time.Since(start).Round(10*time.Microsecond))}
+	case err == nil:
+		return Result{Check: check, Status: OK, Detail: fmt.Sprintf("answered in %v", time.Since(start).Round(10*time.Microsecond))}
+	case errors.As(err, &netErr) && netErr.Timeout():
+		if !isQUIC {
+			return Result{Check: check, Status: OK, Detail: "port not reported closed (UDP services other than QUIC cannot be verified further)"}
+		}
+		return Result{Check: check, Status: Warn, Detail: fmt.Sprintf("no answer to a QUIC probe within %v", timeout), Hint: "check that the backend runs and that firewalls between the relay and the backend allow UDP"}
+	}
+	return Result{Check: check, Status: Fail, Detail: "port unreachable: " + err.Error(), Hint: "start the backend or fix the port; the host reports nothing listening"}
+}
+
+// size formats a byte count.
+func size(n int) string {
+	switch {
+	case n >= 1<<20:
+		return fmt.Sprintf("%.1f MB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%d KB", n>>10)
+	}
+	return fmt.Sprintf("%d B", n)
+}
@@ -0,0 +1,202 @@
+package doctor
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/proxy"
+)
+
+// writeCert writes a self-signed certificate and its key to dir.
+func writeCert(t *testing.T, dir string, notBefore, notAfter time.Time) (certFile, keyFile string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "relay.example.com"},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, pub, priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDER}), 0o600)
+	return certFile, keyFile
+}
+
+func TestCheckCertificates(t *testing.T) {
+	day := 24 * time.Hour
+	current := time.Now()
+	tests := []struct {
+		name      string
+		notBefore time.Time
+		notAfter  time.Time
+		want      Status
+		detail    string
+	}{
+		{"valid", current.Add(-day), current.Add(90 * day), OK, "valid until"},
+		{"expires soon", current.Add(-day), current.Add(3 * day), Warn, "expires in"},
+		{"expired", current.Add(-90 * day), current.Add(-day), Fail, "expired"},
+		{"not yet valid", current.Add(day), current.Add(90 * day), Fail, "not valid before"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cert, key := writeCert(t, t.TempDir(), tt.notBefore, tt.notAfter)
+			cfg := &proxy.Config{Admin: &proxy.AdminConfig{TLS: &proxy.AdminTLSConfig{Cert: cert, Key: key, ClientCA: cert}}}
+
+			results := checkCertificates(cfg)
+			if len(results) != 2 {
+				t.Fatalf("got %d results, want 2 (cert and client CA)", len(results))
+			}
+			for _, r := range results {
+				if r.Status != tt.want || !strings.Contains(r.Detail, tt.detail) {
+					t.Errorf("%s: status = %v, detail = %q; want %v, %q", r.Check, r.Status, r.Detail, tt.want, tt.detail)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckCertificates_Unreadable(t *testing.T) {
+	dir := t.TempDir()
+	cert, _ := writeCert(t, dir, time.Now(), time.Now().Add(time.Hour))
+	cfg := &proxy.Config{Admin: &proxy.AdminConfig{TLS: &proxy.AdminTLSConfig{Cert: cert, Key: filepath.Join(dir, "missing.pem")}}}
+
+	results := checkCertificates(cfg)
+	if len(results) != 1 || results[0].Status != Fail || results[0].Hint == "" {
+		t.Errorf("results = %+v, want one failure with a hint", results)
+	}
+}
+
+func TestConfigCertificates_Terminator(t *testing.T) {
+	cfg := &proxy.Config{Handlers: []handler.HandlerConfig{
+		{Type: "sni-router"},
+		{Type: "terminator", Config: json.RawMessage(`{"certs": {
+			"default": {"cert": "d.pem", "key": "d.key"},
+			"targets": {"10.0.0.2:5520": {"cert": "b.pem", "key": "b.key"}, "10.0.0.1:5520": {"cert": "a.pem", "key": "a.key"}}
+		}}`)},
+	}}
+	var got []string
+	for _, f := range configCertificates(cfg) {
+		got = append(got, f.name+"="+f.cert)
+	}
+	want := "terminator default=d.pem terminator 10.0.0.1:5520=a.pem terminator 10.0.0.2:5520=b.pem"
+	if strings.Join(got, " ") != want {
+		t.Errorf("certificates = %v, want %s", got, want)
+	}
+}
+
+func TestProbeBackend(t *testing.T) {
+	// A QUIC server answers the probe with version negotiation
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			if n >= 1200 {
+				server.WriteToUDP([]byte{0x80, 0, 0, 0, 0}, addr)
+			}
+		}
+	}()
+
+	// A socket that never answers
+	silent, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+
+	// A port with nothing listening
+	closed, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.LocalAddr().String()
+	closed.Close()
+
+	tests := []struct {
+		name   string
+		addr   string
+		isQUIC bool
+		want   Status
+	}{
+		{"quic answers", server.LocalAddr().String(), true, OK},
+		{"quic silent", silent.LocalAddr().String(), true, Warn},
+		{"udp silent", silent.LocalAddr().String(), false, OK},
+		{"closed", closedAddr, true, Fail},
+		{"bad address", "127.0.0.1", true, Fail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := probeBackend(tt.addr, tt.isQUIC, 200*time.Millisecond)
+			// Not every platform reports closed UDP ports
+			if tt.name == "closed" && r.Status == Warn {
+				t.Skip("closed port not reported by this platform")
+			}
+			if r.Status != tt.want {
+				t.Errorf("status = %v (%s), want %v", r.Status, r.Detail, tt.want)
+			}
+		})
+	}
+}
+
+func TestQUICProbe(t *testing.T) {
+	p := quicProbe()
+	if len(p) != 1200 || p[0]&0xc0 != 0xc0 {
+		t.Errorf("probe is not a long header packet of 1200 bytes")
+	}
+	if isVersionNegotiation(p) {
+		t.Error("the probe itself must not look like version negotiation")
+	}
+	if !isVersionNegotiation([]byte{0x80, 0, 0, 0, 0, 8}) {
+		t.Error("version 0 long header should be version negotiation")
+	}
+}
+
+func TestCheckClock(t *testing.T) {
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Date(1970, 1, 1, 0, 0, 0, 0, time.UTC) }
+	if r := checkClock(); r[0].Status != Fail {
+		t.Errorf("clock in 1970: status = %v, want %v", r[0].Status, Fail)
+	}
+}
+
+func TestResult_MarshalJSON(t *testing.T) {
+	data, err := json.Marshal(Result{Check: "clock", Status: Warn, Detail: "x"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := `{"check":"clock","detail":"x","status":"warn"}`; string(data) != want {
+		t.Errorf("json = %s, want %s", data, want)
+	}
+}
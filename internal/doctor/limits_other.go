@@ -0,0 +1,8 @@
+//go:build windows || plan9
+
+package doctor
+
+// fileLimit reports no limit; these platforms have no RLIMIT_NOFILE.
+func fileLimit() (soft, hard uint64, ok bool) {
+	return 0, 0, false
+}
@@ -0,0 +1,14 @@
+//go:build !windows && !plan9
+
+package doctor
+
+import "syscall"
+
+// fileLimit returns the soft and hard limit on open file descriptors.
+func fileLimit() (soft, hard uint64, ok bool) {
+	var rl syscall.Rlimit
+	if err := syscall.Getrlimit(syscall.RLIMIT_NOFILE, &rl); err != nil {
+		return 0, 0, false
+	}
+	return uint64(rl.Cur), uint64(rl.Max), true
+}
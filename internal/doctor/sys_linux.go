@@ -0,0 +1,45 @@
+package doctor
+
+import (
+	"fmt"
+	"net"
+
+	"golang.org/x/sys/unix"
+
+	"quic-relay/internal/handler"
+)
+
+// clockSynchronized asks the kernel whether NTP considers the clock synchronized.
+func clockSynchronized() (synced, known bool) {
+	var tx unix.Timex
+	state, err := unix.Adjtimex(&tx)
+	if err != nil {
+		return false, false
+	}
+	return state != unix.TIME_ERROR && tx.Status&unix.STA_UNSYNC == 0, true
+}
+
+// udpOffload probes UDP_SEGMENT (GSO) and UDP_GRO on a fresh socket.
+func udpOffload() (gso, gro, supported bool) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		return false, false, false
+	}
+	defer conn.Close()
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return false, false, false
+	}
+	raw.Control(func(fd uintptr) {
+		_, err := unix.GetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_SEGMENT)
+		gso = err == nil
+		gro = unix.SetsockoptInt(int(fd), unix.IPPROTO_UDP, unix.UDP_GRO, 1) == nil
+	})
+	return gso, gro, true
+}
+
+// bufferHint tells how to raise the kernel's socket buffer ceiling.
+func bufferHint(cfg handler.SocketBufferConfig) string {
+	return fmt.Sprintf("sysctl -w net.core.rmem_max=%d net.core.wmem_max=%d, and persist it in /etc/sysctl.d/",
+		cfg.Receive, cfg.Send)
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+
+package doctor
+
+import (
+	"runtime"
+
+	"quic-relay/internal/handler"
+)
+
+// clockSynchronized reports the status as unknown outside Linux.
+func clockSynchronized() (synced, known bool) {
+	return false, false
+}
+
+// udpOffload reports no support; GSO and GRO are Linux-only.
+func udpOffload() (gso, gro, supported bool) {
+	return false, false, false
+}
+
+// bufferHint tells how to raise the kernel's socket buffer ceiling.
+func bufferHint(cfg handler.SocketBufferConfig) string {
+	if runtime.GOOS == "darwin" {
+		return "raise kern.ipc.maxsockbuf with sysctl, or lower socket_buffers in the config"
+	}
+	return "lower socket_buffers in the config to what the system allows"
+}
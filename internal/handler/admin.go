@@ -0,0 +1,27 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// writeAdminJSON writes v as a JSON admin API response.
+func writeAdminJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeAdminError writes a JSON error response.
+func writeAdminError(w http.ResponseWriter, status int, msg string) {
+	writeAdminJSON(w, status, map[string]string{"error": msg})
+}
+
+// readAdminJSON decodes the request body into v, writing a 400 response on failure.
+func readAdminJSON(w http.ResponseWriter, r *http.Request, v any) bool {
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1<<20)).Decode(v); err != nil {
+		writeAdminError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return false
+	}
+	return true
+}
@@ -0,0 +1,78 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+)
+
+// errBackendsFull is returned by routers when every candidate backend has
+// reached its max_sessions.
+var errBackendsFull = errors.New("all backends full")
+
+const backendSlotKey = "_backend_slot"
+
+// backendLoads counts the sessions of backends with max_sessions, by address.
+// It is process-wide so counts survive handler chain reloads.
+var backendLoads sync.Map // string -> *atomic.Int64
+
+// backendSlot is a session's place on a backend with max_sessions.
+type backendSlot struct {
+	count    *atomic.Int64
+	released atomic.Bool
+}
+
+// backendLoad returns the session counter of addr.
+func backendLoad(addr string) *atomic.Int64 {
+	if v, ok := backendLoads.Load(addr); ok {
+		return v.(*atomic.Int64)
+	}
+	v, _ := backendLoads.LoadOrStore(addr, new(atomic.Int64))
+	return v.(*atomic.Int64)
+}
+
+// reserveBackend takes a session slot on addr unless it already has max
+// sessions. max <= 0 takes the slot regardless, for restored sessions.
+func reserveBackend(addr string, max int) (*backendSlot, bool) {
+	count := backendLoad(addr)
+	for {
+		n := count.Load()
+		if max > 0 && n >= int64(max) {
+			return nil, false
+		}
+		if count.CompareAndSwap(n, n+1) {
+			return &backendSlot{count: count}, true
+		}
+	}
+}
+
+// release gives the slot back. It is safe to call more than once.
+func (s *backendSlot) release() {
+	if s.released.CompareAndSwap(false, true) {
+		s.count.Add(-1)
+	}
+}
+
+// backendSessionCount returns the sessions counted against the max_sessions
+// of addr. Backends without max_sessions report 0.
+func backendSessionCount(addr string) int {
+	if v, ok := backendLoads.Load(addr); ok {
+		return int(v.(*atomic.Int64).Load())
+	}
+	return 0
+}
+
+// holdBackendSlot records the slot of a routed connection on ctx.
+func holdBackendSlot(ctx *Context, slot *backendSlot) {
+	if slot != nil {
+		ctx.Set(backendSlotKey, slot)
+	}
+}
+
+// releaseBackendSlot gives back the backend slot of a connection, if any.
+// Routers call it when a session ends or a later handler refuses it.
+func releaseBackendSlot(ctx *Context) {
+	if slot, ok := GetValue[*backendSlot](ctx, backendSlotKey); ok {
+		slot.release()
+	}
+}
@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sync/atomic"
+)
+
+// backendEntry is a backend in a route or schedule.
+// Accepts either "host:port" or {"addr": "host:port", "weight": 5, "max_sessions": 100}.
+type backendEntry struct {
+	Addr        string `json:"addr"`
+	Weight      int    `json:"weight,omitempty"`
+	MaxSessions int    `json:"max_sessions,omitempty"` // Concurrent sessions the backend accepts (0 = no limit)
+}
+
+// UnmarshalJSON accepts the plain string form as well as the object form.
+func (b *backendEntry) UnmarshalJSON(data []byte) error {
+	var addr string
+	if err := json.Unmarshal(data, &addr); err == nil {
+		*b = backendEntry{Addr: addr}
+		return nil
+	}
+	type plain backendEntry
+	var p plain
+	if err := json.Unmarshal(data, &p); err != nil {
+		return errors.New("backend must be a string or {\"addr\", \"weight\", \"max_sessions\"} object")
+	}
+	*b = backendEntry(p)
+	return nil
+}
+
+// backendPool selects one of several backends.
+// Without weights it uses round-robin. With weights, the client IP is hashed
+// onto the weight range, so a client always lands on the same backend as long
+// as the weights don't change (stable canary cohorts). Backends at their
+// max_sessions are skipped like draining ones.
+type backendPool struct {
+	addrs      []string
+	cumulative []uint32 // Running weight totals, nil for round-robin
+	total      uint32
+	counter    atomic.Uint64
+	caps       []int // max_sessions by index, nil if no backend has one
+}
+
+// newBackendPool builds a pool from config entries.
+func newBackendPool(entries []backendEntry) (*backendPool, error) {
+	if len(entries) == 0 {
+		return nil, errors.New("empty backends")
+	}
+
+	p := &backendPool{addrs: make([]string, len(entries))}
+	weighted := false
+	for i, e := range entries {
+		if e.Addr == "" {
+			return nil, fmt.Errorf("backend %d: missing addr", i)
+		}
+		if e.Weight < 0 {
+			return nil, fmt.Errorf("backend %s: negative weight", e.Addr)
+		}
+		if e.Weight > 0 {
+			weighted = true
+		}
+		if e.MaxSessions < 0 {
+			return nil, fmt.Errorf("backend %s: negative max_sessions", e.Addr)
+		}
+		if e.MaxSessions > 0 && p.caps == nil {
+			p.caps = make([]int, len(entries))
+		}
+		if p.caps != nil {
+			p.caps[i] = e.MaxSessions
+		}
+		p.addrs[i] = e.Addr
+	}
+	if !weighted {
+		return p, nil
+	}
+
+	// In weighted mode, backends without a weight receive no traffic
+	p.cumulative = make([]uint32, len(entries))
+	for i, e := range entries {
+		p.total += uint32(e.Weight)
+		p.cumulative[i] = p.total
+	}
+	return p, nil
+}
+
+// poolFromStrings builds a round-robin pool from plain addresses.
+func poolFromStrings(addrs []string) *backendPool {
+	return &backendPool{addrs: addrs}
+}
+
+// pick returns a backend for the given client, for pools without max_sessions.
+// client may be nil, in which case weighted pools fall back to a weighted round-robin.
+// Draining backends are skipped unless every backend is draining.
+func (p *backendPool) pick(client *net.UDPAddr) string {
+	addr, _ := p.choose(client)
+	return addr
+}
+
+// pickFor returns a backend for ctx's client and holds a session slot on it
+// when it has max_sessions. Fails when every backend is full.
+func (p *backendPool) pickFor(ctx *Context) (string, error) {
+	addr, slot := p.choose(ctx.ClientAddr)
+	if addr == "" {
+		return "", errBackendsFull
+	}
+	holdBackendSlot(ctx, slot)
+	return addr, nil
+}
+
+// choose picks a backend and reserves its slot. Returns "" if all are full.
+func (p *backendPool) choose(client *net.UDPAddr) (string, *backendSlot) {
+	if p.cumulative == nil {
+		idx := p.counter.Add(1) - 1
+		return p.next(int(idx % uint64(len(p.addrs))))
+	}
+
+	var point uint32
+	if client != nil {
+		point = hashIP(client.IP) % p.total
+	} else {
+		point = uint32((p.counter.Add(1) - 1) % uint64(p.total))
+	}
+	for i, c := range p.cumulative {
+		if point < c {
+			return p.next(i)
+		}
+	}
+	return p.next(len(p.addrs) - 1)
+}
+
+// next returns the backend at index i or, if it is draining or full, the next
+// one that is not. Clients of other backends keep their cohort. Backends
+// without weight in a weighted pool are never chosen. When every backend is
+// draining, the first one with room is used.
+func (p *backendPool) next(i int) (string, *backendSlot) {
+	for _, draining := range []bool{false, true} {
+		for n := range len(p.addrs) {
+			j := (i + n) % len(p.addrs)
+			if p.cumulative != nil && p.weight(j) == 0 {
+				continue
+			}
+			if Draining(p.addrs[j]) != draining {
+				continue
+			}
+			if slot, ok := p.reserve(j); ok {
+				return p.addrs[j], slot
+			}
+		}
+	}
+	return "", nil
+}
+
+// reserve takes a session slot on the backend at index i. Backends without
+// max_sessions always have room and need no slot.
+func (p *backendPool) reserve(i int) (*backendSlot, bool) {
+	if p.caps == nil || p.caps[i] == 0 {
+		return nil, true
+	}
+	return reserveBackend(p.addrs[i], p.caps[i])
+}
+
+// reserveAddr takes a session slot on addr, a backend of the pool, for ctx.
+// Returns false if it is full.
+func (p *backendPool) reserveAddr(ctx *Context, addr string) bool {
+	i := slices.Index(p.addrs, addr)
+	if i < 0 {
+		return false
+	}
+	slot, ok := p.reserve(i)
+	if ok {
+		holdBackendSlot(ctx, slot)
+	}
+	return ok
+}
+
+// weight returns the weight of the backend at index i of a weighted pool.
+func (p *backendPool) weight(i int) uint32 {
+	if i == 0 {
+		return p.cumulative[0]
+	}
+	return p.cumulative[i] - p.cumulative[i-1]
+}
+
+// contains reports whether addr is one of the pool's backends.
+func (p *backendPool) contains(addr string) bool {
+	return slices.Contains(p.addrs, addr)
+}
+
+// hashIP computes FNV-1a over the IP (port excluded so reconnects keep their cohort).
+func hashIP(ip net.IP) uint32 {
+	if v4 := ip.To4(); v4 != nil {
+		ip = v4
+	}
+	h := uint32(2166136261)
+	for _, b := range ip {
+		h ^= uint32(b)
+		h *= 16777619
+	}
+	return h
+}
@@ -0,0 +1,171 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+)
+
+func TestBackendEntry_Unmarshal(t *testing.T) {
+	var entries []backendEntry
+	data := `["a:1", {"addr": "b:2", "weight": 5}]`
+	if err := json.Unmarshal([]byte(data), &entries); err != nil {
+		t.Fatalf("unmarshal: %v", err)
+	}
+	if entries[0] != (backendEntry{Addr: "a:1"}) || entries[1] != (backendEntry{Addr: "b:2", Weight: 5}) {
+		t.Errorf("unexpected entries: %+v", entries)
+	}
+
+	if err := json.Unmarshal([]byte(`[42]`), &entries); err == nil {
+		t.Error("expected error for number entry")
+	}
+}
+
+func TestNewBackendPool_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		entries []backendEntry
+		errMsg  string
+	}{
+		{"empty", nil, "empty backends"},
+		{"missing addr", []backendEntry{{Weight: 1}}, "missing addr"},
+		{"negative", []backendEntry{{Addr: "a:1", Weight: -1}}, "negative weight"},
+		{"negative max_sessions", []backendEntry{{Addr: "a:1", MaxSessions: -1}}, "negative max_sessions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newBackendPool(tt.entries)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestBackendPool_RoundRobin(t *testing.T) {
+	p, err := newBackendPool([]backendEntry{{Addr: "a:1"}, {Addr: "b:2"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 1000}
+	if first, second := p.pick(client), p.pick(client); first == second {
+		t.Errorf("round-robin returned %s twice", first)
+	}
+}
+
+func TestBackendPool_WeightedStable(t *testing.T) {
+	p, err := newBackendPool([]backendEntry{{Addr: "v1:443", Weight: 95}, {Addr: "v2:443", Weight: 5}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := map[string]int{}
+	for i := 0; i < 10000; i++ {
+		ip := net.IPv4(10, byte(i>>16), byte(i>>8), byte(i))
+		backend := p.pick(&net.UDPAddr{IP: ip, Port: 1000})
+		counts[backend]++
+
+		// Same IP on another port stays in its cohort
+		if again := p.pick(&net.UDPAddr{IP: ip, Port: 2000}); again != backend {
+			t.Fatalf("client %s moved from %s to %s", ip, backend, again)
+		}
+	}
+
+	share := float64(counts["v2:443"]) / 10000
+	if share < 0.03 || share > 0.07 {
+		t.Errorf("canary share = %.3f, want ~0.05 (counts %v)", share, counts)
+	}
+}
+
+func TestBackendPool_ZeroWeight(t *testing.T) {
+	p, err := newBackendPool([]backendEntry{{Addr: "v1:443", Weight: 1}, {Addr: "off:443"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		client := &net.UDPAddr{IP: net.ParseIP(fmt.Sprintf("2001:db8::%x", i)), Port: 1}
+		if got := p.pick(client); got != "v1:443" {
+			t.Fatalf("unexpected backend %s", got)
+		}
+	}
+	// Nil client falls back to weighted round-robin
+	if got := p.pick(nil); got != "v1:443" {
+		t.Errorf("unexpected backend %s for nil client", got)
+	}
+}
+
+func TestDynamicHandler_WeightedRoute(t *testing.T) {
+	config := `{"routes": {"play.com": {"backends": [{"addr": "v1:443", "weight": 50}, {"addr": "v2:443", "weight": 50}]}}}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 7), Port: 4000}
+	var first string
+	for i := 0; i < 10; i++ {
+		ctx := &Context{ClientAddr: client, Hello: &ClientHello{SNI: "play.com"}}
+		if result := h.OnConnect(ctx); result.Action != Continue {
+			t.Fatalf("expected Continue, got %v", result.Action)
+		}
+		backend := ctx.GetString("backend")
+		if first == "" {
+			first = backend
+		} else if backend != first {
+			t.Fatalf("backend changed from %s to %s", first, backend)
+		}
+	}
+}
+
+func TestDynamicHandler_MaxSessions(t *testing.T) {
+	config := `{"routes": {"cap.test": {"backends": [{"addr": "cap1:443", "max_sessions": 1}, {"addr": "cap2:443", "max_sessions": 2}]}}}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	connect := func() (*Context, Result) {
+		ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 8), Port: 4000}, Hello: &ClientHello{SNI: "cap.test"}}
+		return ctx, h.OnConnect(ctx)
+	}
+
+	// Full backends fail over, then connections are refused
+	counts := make(map[string]int)
+	var ctxs []*Context
+	for range 3 {
+		ctx, res := connect()
+		if res.Action != Continue {
+			t.Fatalf("OnConnect = %v: %v", res.Action, res.Error)
+		}
+		counts[ctx.GetString("backend")]++
+		ctxs = append(ctxs, ctx)
+	}
+	if counts["cap1:443"] != 1 || counts["cap2:443"] != 2 {
+		t.Errorf("sessions = %v, want 1 on cap1 and 2 on cap2", counts)
+	}
+	if _, res := connect(); res.Action != Drop || !errors.Is(res.Error, errBackendsFull) {
+		t.Fatalf("OnConnect with all backends full = %v: %v", res.Action, res.Error)
+	}
+
+	// Ended and refused sessions give their slot back
+	h.(*DynamicHandler).OnDisconnect(ctxs[0])
+	h.(*DynamicHandler).OnDisconnect(ctxs[0])
+	ctx, res := connect()
+	if res.Action != Continue {
+		t.Fatalf("OnConnect after a disconnect = %v", res.Action)
+	}
+	h.(*DynamicHandler).CancelConnect(ctx)
+	if n := backendSessionCount("cap1:443") + backendSessionCount("cap2:443"); n != 2 {
+		t.Errorf("counted sessions = %d, want 2", n)
+	}
+
+	// Restored sessions count without the limit
+	restored := &Context{Hello: &ClientHello{SNI: "cap.test"}}
+	restored.Set("backend", "cap2:443")
+	h.(*DynamicHandler).Restore(restored, 1)
+	if n := backendSessionCount("cap2:443"); n != 3 {
+		t.Errorf("cap2 sessions after restore = %d, want 3", n)
+	}
+}
@@ -1,27 +1,171 @@
 package handler
 
-import "sync"
-
-// packetPool provides reusable byte buffers for UDP packets.
-// Using sync.Pool eliminates per-packet allocations in hot paths.
-var packetPool = sync.Pool{
-	New: func() any {
-		// Max UDP packet size
-		buf := make([]byte, 65535)
+import (
+	"sync/atomic"
+)
+
+// Buffer size tiers. Most game packets fit in the small tier; the large tier
+// holds a full UDP datagram and is used for socket reads.
+const (
+	SmallBufferSize  = 2048
+	MediumBufferSize = 16384
+	LargeBufferSize  = 65535
+)
+
+// Default number of idle buffers kept per tier.
+const (
+	defaultMaxIdleSmall  = 4096
+	defaultMaxIdleMedium = 512
+	defaultMaxIdleLarge  = 256
+)
+
+// BufferPoolConfig configures how many idle buffers each tier retains.
+// Zero values use the defaults. Buffers returned beyond the limit are left to the GC.
+type BufferPoolConfig struct {
+	MaxIdleSmall  int `json:"max_idle_small,omitempty"`
+	MaxIdleMedium int `json:"max_idle_medium,omitempty"`
+	MaxIdleLarge  int `json:"max_idle_large,omitempty"`
+}
+
+var tierSizes = [...]int{SmallBufferSize, MediumBufferSize, LargeBufferSize}
+
+// tierCounters survive pool reconfiguration.
+type tierCounters struct {
+	hits     atomic.Uint64 // Get served from idle list
+	misses   atomic.Uint64 // Get had to allocate
+	puts     atomic.Uint64 // Buffer returned to idle list
+	discards atomic.Uint64 // Buffer dropped because idle list was full
+}
+
+var counters [len(tierSizes)]tierCounters
+
+// bufferPools holds one bounded idle list per tier.
+// Channels are used instead of sync.Pool so the idle count is bounded and observable.
+type bufferPools struct {
+	free [len(tierSizes)]chan *[]byte
+}
+
+var pools atomic.Pointer[bufferPools]
+
+func init() {
+	ConfigureBufferPool(BufferPoolConfig{})
+}
+
+// ConfigureBufferPool replaces the idle lists with the configured limits.
+// Buffers currently in use are accepted by the new lists when returned.
+func ConfigureBufferPool(cfg BufferPoolConfig) {
+	limits := [len(tierSizes)]int{cfg.MaxIdleSmall, cfg.MaxIdleMedium, cfg.MaxIdleLarge}
+	defaults := [len(tierSizes)]int{defaultMaxIdleSmall, defaultMaxIdleMedium, defaultMaxIdleLarge}
+
+	bp := &bufferPools{}
+	for i := range bp.free {
+		limit := limits[i]
+		if limit <= 0 {
+			limit = defaults[i]
+		}
+		bp.free[i] = make(chan *[]byte, limit)
+	}
+	pools.Store(bp)
+}
+
+// tierFor returns the index of the smallest tier that fits n bytes.
+func tierFor(n int) int {
+	for i, size := range tierSizes {
+		if n <= size {
+			return i
+		}
+	}
+	return len(tierSizes) - 1
+}
+
+// getTier returns a buffer from tier i, allocating on a miss.
+func getTier(i int) *[]byte {
+	select {
+	case buf := <-pools.Load().free[i]:
+		counters[i].hits.Add(1)
+		*buf = (*buf)[:tierSizes[i]]
+		return buf
+	default:
+		counters[i].misses.Add(1)
+		buf := make([]byte, tierSizes[i])
 		return &buf
-	},
+	}
 }
 
-// GetBuffer returns a buffer from the pool.
+// GetBuffer returns a buffer large enough for any UDP datagram.
 // Caller must return it via PutBuffer after use.
 func GetBuffer() *[]byte {
-	return packetPool.Get().(*[]byte)
+	return getTier(len(tierSizes) - 1)
 }
 
-// PutBuffer returns a buffer to the pool.
+// GetBufferSized returns a buffer of at least n bytes from the smallest fitting tier.
+// The returned slice has the tier's full length; reslice as needed.
+// Caller must return it via PutBuffer after use.
+func GetBufferSized(n int) *[]byte {
+	return getTier(tierFor(n))
+}
+
+// PutBuffer returns a buffer to its tier.
 // Buffer contents are NOT cleared for performance.
+// Buffers that don't match a tier size are ignored.
 func PutBuffer(buf *[]byte) {
-	if buf != nil {
-		packetPool.Put(buf)
+	if buf == nil {
+		return
+	}
+	c := cap(*buf)
+	for i, size := range tierSizes {
+		if c != size {
+			continue
+		}
+		select {
+		case pools.Load().free[i] <- buf:
+			counters[i].puts.Add(1)
+		default:
+			counters[i].discards.Add(1)
+		}
+		return
+	}
+}
+
+// BufferTierStats is a snapshot of one buffer tier.
+type BufferTierStats struct {
+	Size     int    `json:"size"`
+	Idle     int    `json:"idle"`
+	MaxIdle  int    `json:"max_idle"`
+	Hits     uint64 `json:"hits"`
+	Misses   uint64 `json:"misses"`
+	Puts     uint64 `json:"puts"`
+	Discards uint64 `json:"discards"`
+}
+
+// HitRate returns the fraction of gets served without allocating.
+func (s BufferTierStats) HitRate() float64 {
+	total := s.Hits + s.Misses
+	if total == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(total)
+}
+
+// BufferPoolStats is a snapshot of buffer pool usage per tier.
+type BufferPoolStats struct {
+	Tiers []BufferTierStats `json:"tiers"`
+}
+
+// GetBufferPoolStats returns current buffer pool counters.
+func GetBufferPoolStats() BufferPoolStats {
+	bp := pools.Load()
+	stats := BufferPoolStats{Tiers: make([]BufferTierStats, len(tierSizes))}
+	for i, size := range tierSizes {
+		stats.Tiers[i] = BufferTierStats{
+			Size:     size,
+			Idle:     len(bp.free[i]),
+			MaxIdle:  cap(bp.free[i]),
+			Hits:     counters[i].hits.Load(),
+			Misses:   counters[i].misses.Load(),
+			Puts:     counters[i].puts.Load(),
+			Discards: counters[i].discards.Load(),
+		}
 	}
+	return stats
 }
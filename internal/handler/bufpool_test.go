@@ -0,0 +1,77 @@
+package handler
+
+import "testing"
+
+func TestGetBufferSized_Tiers(t *testing.T) {
+	tests := []struct {
+		n    int
+		want int
+	}{
+		{0, SmallBufferSize},
+		{1200, SmallBufferSize},
+		{SmallBufferSize, SmallBufferSize},
+		{SmallBufferSize + 1, MediumBufferSize},
+		{MediumBufferSize + 1, LargeBufferSize},
+		{100000, LargeBufferSize},
+	}
+	for _, tt := range tests {
+		buf := GetBufferSized(tt.n)
+		if len(*buf) != tt.want || cap(*buf) != tt.want {
+			t.Errorf("GetBufferSized(%d): got len=%d cap=%d, want %d", tt.n, len(*buf), cap(*buf), tt.want)
+		}
+		PutBuffer(buf)
+	}
+}
+
+func TestBufferPool_HitAfterPut(t *testing.T) {
+	ConfigureBufferPool(BufferPoolConfig{})
+	defer ConfigureBufferPool(BufferPoolConfig{})
+
+	before := GetBufferPoolStats().Tiers[0]
+	buf := GetBufferSized(100)
+	*buf = (*buf)[:10] // Callers reslice; pool must restore full length
+	PutBuffer(buf)
+	again := GetBufferSized(100)
+	after := GetBufferPoolStats().Tiers[0]
+
+	if after.Hits != before.Hits+1 {
+		t.Errorf("expected one hit, got %d", after.Hits-before.Hits)
+	}
+	if len(*again) != SmallBufferSize {
+		t.Errorf("expected full-length buffer, got %d", len(*again))
+	}
+	PutBuffer(again)
+}
+
+func TestBufferPool_MaxIdle(t *testing.T) {
+	ConfigureBufferPool(BufferPoolConfig{MaxIdleMedium: 2})
+	defer ConfigureBufferPool(BufferPoolConfig{})
+
+	bufs := []*[]byte{GetBufferSized(4096), GetBufferSized(4096), GetBufferSized(4096)}
+	before := GetBufferPoolStats().Tiers[1]
+	for _, b := range bufs {
+		PutBuffer(b)
+	}
+	after := GetBufferPoolStats().Tiers[1]
+
+	if after.Idle != 2 || after.MaxIdle != 2 {
+		t.Errorf("expected 2/2 idle, got %d/%d", after.Idle, after.MaxIdle)
+	}
+	if after.Discards != before.Discards+1 {
+		t.Errorf("expected one discard, got %d", after.Discards-before.Discards)
+	}
+}
+
+func TestPutBuffer_IgnoresForeignBuffers(t *testing.T) {
+	before := GetBufferPoolStats()
+	foreign := make([]byte, 1000)
+	PutBuffer(&foreign)
+	PutBuffer(nil)
+	after := GetBufferPoolStats()
+
+	for i := range after.Tiers {
+		if after.Tiers[i].Puts != before.Tiers[i].Puts {
+			t.Errorf("tier %d: foreign buffer should not be pooled", i)
+		}
+	}
+}
@@ -0,0 +1,486 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/timerwheel"
+)
+
+var chaosLog = logging.ForHandler("chaos")
+
+func init() {
+	Register("chaos", NewChaosHandler)
+}
+
+// chaosKey is the context key holding a session's fault injector.
+// The forwarder applies it to every packet of the session.
+const chaosKey = "_chaos"
+
+// Directions the chaos faults apply to.
+const (
+	ChaosBoth    = "both"
+	ChaosClient  = "client"  // Client -> backend only
+	ChaosBackend = "backend" // Backend -> client only
+)
+
+// ChaosProfile describes the faults injected into a session's packets.
+// Probabilities are between 0 and 1 and apply to each packet.
+type ChaosProfile struct {
+	Loss          float64 `json:"loss,omitempty"`           // Drop the packet
+	Duplicate     float64 `json:"duplicate,omitempty"`      // Send the packet twice
+	Reorder       float64 `json:"reorder,omitempty"`        // Hold the packet back so later ones overtake it
+	ReorderMs     int     `json:"reorder_ms,omitempty"`     // How long reordered packets are held (default: 20)
+	DelayMs       int     `json:"delay_ms,omitempty"`       // Added to every packet
+	JitterMs      int     `json:"jitter_ms,omitempty"`      // Random extra delay up to this value
+	BandwidthKbps int     `json:"bandwidth_kbps,omitempty"` // Per session and direction (0 = unlimited)
+	QueueMs       int     `json:"queue_ms,omitempty"`       // Packets queued longer by the bandwidth cap are dropped (default: 500)
+	Direction     string  `json:"direction,omitempty"`      // both (default), client or backend
+}
+
+// ChaosConfig is the configuration for the chaos handler.
+type ChaosConfig struct {
+	Enabled *bool                   `json:"enabled,omitempty"` // Inject faults (default: true)
+	Default *ChaosProfile           `json:"default,omitempty"` // Applied to sessions without a more specific profile
+	SNIs    map[string]ChaosProfile `json:"snis,omitempty"`    // Per-SNI profiles, override default
+}
+
+func (p *ChaosProfile) validate() error {
+	for name, v := range map[string]float64{"loss": p.Loss, "duplicate": p.Duplicate, "reorder": p.Reorder} {
+		if v < 0 || v > 1 {
+			return fmt.Errorf("%s must be between 0 and 1", name)
+		}
+	}
+	if p.ReorderMs < 0 || p.DelayMs < 0 || p.JitterMs < 0 || p.BandwidthKbps < 0 || p.QueueMs < 0 {
+		return errors.New("durations and bandwidth must not be negative")
+	}
+	if p.ReorderMs == 0 {
+		p.ReorderMs = 20
+	}
+	if p.QueueMs == 0 {
+		p.QueueMs = 500
+	}
+	switch p.Direction {
+	case "":
+		p.Direction = ChaosBoth
+	case ChaosBoth, ChaosClient, ChaosBackend:
+	default:
+		return fmt.Errorf("unknown direction %q", p.Direction)
+	}
+	return nil
+}
+
+// applies reports whether the profile affects packets travelling in dir.
+func (p *ChaosProfile) applies(dir Direction) bool {
+	switch p.Direction {
+	case ChaosClient:
+		return dir == Inbound
+	case ChaosBackend:
+		return dir == Outbound
+	}
+	return true
+}
+
+// ChaosStats counts the faults injected since startup.
+type ChaosStats struct {
+	Dropped    uint64 `json:"dropped"`
+	Duplicated uint64 `json:"duplicated"`
+	Reordered  uint64 `json:"reordered"`
+	Delayed    uint64 `json:"delayed"`
+	Shaped     uint64 `json:"shaped"` // Dropped by the bandwidth cap
+}
+
+// ChaosHandler injects packet loss, duplication, reordering, delay and
+// bandwidth limits into sessions, for testing how clients cope with bad
+// networks. Place it before the forwarder. Client packets are dropped and
+// delayed by OnPacket and duplicated by the forwarder; the forwarder applies
+// all faults to backend packets, which do not pass the chain.
+// Profiles can be changed at runtime through the admin API and take effect
+// on live sessions immediately. Runtime changes last until the next reload.
+type ChaosHandler struct {
+	enabled atomic.Bool
+
+	mu       sync.RWMutex
+	def      *ChaosProfile
+	snis     map[string]*ChaosProfile
+	sessions map[*Context]*chaosFaults
+
+	rand func() float64 // Replaced by tests
+
+	dropped, duplicated, reordered, delayed, shaped atomic.Uint64
+}
+
+// NewChaosHandler creates a new chaos handler.
+func NewChaosHandler(raw json.RawMessage) (Handler, error) {
+	var cfg ChaosConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid chaos config: %w", err)
+		}
+	}
+	if cfg.Default == nil && len(cfg.SNIs) == 0 {
+		return nil, errors.New("chaos: default or snis required")
+	}
+
+	h := &ChaosHandler{
+		snis:     make(map[string]*ChaosProfile, len(cfg.SNIs)),
+		sessions: make(map[*Context]*chaosFaults),
+		rand:     rand.Float64,
+	}
+	h.enabled.Store(cfg.Enabled == nil || *cfg.Enabled)
+	if cfg.Default != nil {
+		if err := cfg.Default.validate(); err != nil {
+			return nil, fmt.Errorf("chaos: default: %w", err)
+		}
+		h.def = cfg.Default
+	}
+	for sni, p := range cfg.SNIs {
+		if err := p.validate(); err != nil {
+			return nil, fmt.Errorf("chaos: sni %s: %w", sni, err)
+		}
+		h.snis[strings.ToLower(sni)] = &p
+	}
+	if h.enabled.Load() {
+		chaosLog.Warnf("fault injection enabled, do not use in production")
+	}
+	return h, nil
+}
+
+// Name returns the handler name.
+func (h *ChaosHandler) Name() string { return "chaos" }
+
+// OnConnect attaches a fault injector to the session.
+func (h *ChaosHandler) OnConnect(ctx *Context) Result {
+	sni := ""
+	if ctx.Hello != nil {
+		sni = strings.ToLower(ctx.Hello.SNI)
+	}
+	f := &chaosFaults{h: h, sni: sni}
+	ctx.Set(chaosKey, f)
+	h.mu.Lock()
+	h.sessions[ctx] = f
+	h.mu.Unlock()
+	return Result{Action: Continue}
+}
+
+// CancelConnect forgets a session a later handler refused.
+func (h *ChaosHandler) CancelConnect(ctx *Context) {
+	h.OnDisconnect(ctx)
+}
+
+// OnPacket drops client packets lost by the session's profile and delays
+// them by its latency and bandwidth cap.
+func (h *ChaosHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	f, ok := GetValue[*chaosFaults](ctx, chaosKey)
+	if !ok || dir != Inbound {
+		return Result{Action: Continue}
+	}
+	p := f.profile(dir)
+	if p == nil {
+		return Result{Action: Continue}
+	}
+	delay, ok := f.latency(p, dir, len(packet))
+	if !ok {
+		return Result{Action: Drop}
+	}
+	if delay > 0 {
+		h.delayed.Add(1)
+		return Result{Action: Delay, Delay: delay}
+	}
+	return Result{Action: Continue}
+}
+
+// OnDisconnect forgets the session.
+func (h *ChaosHandler) OnDisconnect(ctx *Context) {
+	h.mu.Lock()
+	delete(h.sessions, ctx)
+	h.mu.Unlock()
+}
+
+// profileFor returns the profile for a session, or nil when none applies.
+func (h *ChaosHandler) profileFor(f *chaosFaults) *ChaosProfile {
+	if !h.enabled.Load() {
+		return nil
+	}
+	if p := f.override.Load(); p != nil {
+		return p
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	if p, ok := h.snis[f.sni]; ok {
+		return p
+	}
+	return h.def
+}
+
+// Stats returns the faults injected so far.
+func (h *ChaosHandler) Stats() ChaosStats {
+	return ChaosStats{
+		Dropped:    h.dropped.Load(),
+		Duplicated: h.duplicated.Load(),
+		Reordered:  h.reordered.Load(),
+		Delayed:    h.delayed.Load(),
+		Shaped:     h.shaped.Load(),
+	}
+}
+
+// chaosFaults injects faults into one session's packets.
+type chaosFaults struct {
+	h        *ChaosHandler
+	sni      string
+	override atomic.Pointer[ChaosProfile] // Set per session through the admin API
+
+	mu       sync.Mutex
+	nextSend [2]time.Time // Bandwidth cap: when each direction's link is free again
+}
+
+// profile returns the session's profile if it affects packets travelling in dir.
+func (f *chaosFaults) profile(dir Direction) *ChaosProfile {
+	p := f.h.profileFor(f)
+	if p == nil || !p.applies(dir) {
+		return nil
+	}
+	return p
+}
+
+// latency returns how long p holds a packet of size bytes travelling in dir,
+// or false if the packet is lost or exceeds the bandwidth cap's queue.
+func (f *chaosFaults) latency(p *ChaosProfile, dir Direction, size int) (time.Duration, bool) {
+	h := f.h
+	if p.Loss > 0 && h.rand() < p.Loss {
+		h.dropped.Add(1)
+		return 0, false
+	}
+
+	delay := time.Duration(p.DelayMs) * time.Millisecond
+	if p.JitterMs > 0 {
+		delay += time.Duration(h.rand() * float64(time.Duration(p.JitterMs)*time.Millisecond))
+	}
+	if p.Reorder > 0 && h.rand() < p.Reorder {
+		delay += time.Duration(p.ReorderMs) * time.Millisecond
+		h.reordered.Add(1)
+	}
+	if p.BandwidthKbps > 0 {
+		wait, ok := f.shape(dir, size, p)
+		if !ok {
+			h.shaped.Add(1)
+			return 0, false
+		}
+		delay += wait
+	}
+	return delay, true
+}
+
+// copies returns how many times p sends a packet.
+func (f *chaosFaults) copies(p *ChaosProfile) int {
+	if p.Duplicate > 0 && f.h.rand() < p.Duplicate {
+		f.h.duplicated.Add(1)
+		return 2
+	}
+	return 1
+}
+
+// inject applies the session's faults to packet and hands the result to send,
+// zero or more times, now or later. Delayed packets are copied, so packet can
+// be reused once inject returns. It returns false when there is no profile
+// for the session and the packet should be sent as usual. Backend packets
+// take this way; client packets are held by the chain instead.
+func (f *chaosFaults) inject(dir Direction, packet []byte, send func([]byte)) bool {
+	p := f.profile(dir)
+	if p == nil {
+		return false
+	}
+	h := f.h
+	delay, ok := f.latency(p, dir, len(packet))
+	if !ok {
+		return true
+	}
+	copies := f.copies(p)
+	if delay <= 0 {
+		for range copies {
+			send(packet)
+		}
+		return true
+	}
+
+	h.delayed.Add(1)
+	buf := append([]byte(nil), packet...)
+	timerwheel.AfterFunc(delay, func() {
+		for range copies {
+			send(buf)
+		}
+	})
+	return true
+}
+
+// shape returns how long the packet waits for the bandwidth cap, or false
+// when it would wait longer than the profile's queue allows.
+func (f *chaosFaults) shape(dir Direction, size int, p *ChaosProfile) (time.Duration, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	now := time.Now()
+	next := &f.nextSend[dir]
+	if next.Before(now) {
+		*next = now
+	}
+	wait := next.Sub(now)
+	if wait > time.Duration(p.QueueMs)*time.Millisecond {
+		return 0, false
+	}
+	*next = next.Add(time.Duration(size*8) * time.Second / time.Duration(p.BandwidthKbps*1000))
+	return wait, true
+}
+
+// chaosState is the admin API view of the handler.
+type chaosState struct {
+	Enabled  bool                     `json:"enabled"`
+	Default  *ChaosProfile            `json:"default,omitempty"`
+	SNIs     map[string]*ChaosProfile `json:"snis,omitempty"`
+	Sessions map[string]*ChaosProfile `json:"sessions,omitempty"` // Session ID -> per-session override
+	Stats    ChaosStats               `json:"stats"`
+}
+
+func (h *ChaosHandler) state() chaosState {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	st := chaosState{Enabled: h.enabled.Load(), Default: h.def, Stats: h.Stats()}
+	if len(h.snis) > 0 {
+		st.SNIs = make(map[string]*ChaosProfile, len(h.snis))
+		for sni, p := range h.snis {
+			st.SNIs[sni] = p
+		}
+	}
+	for ctx, f := range h.sessions {
+		p := f.override.Load()
+		if p == nil || ctx.Session == nil {
+			continue
+		}
+		if st.Sessions == nil {
+			st.Sessions = make(map[string]*ChaosProfile)
+		}
+		st.Sessions[strconv.FormatUint(ctx.Session.ID, 10)] = p
+	}
+	return st
+}
+
+// sessionFaults returns the fault injector of the live session with the given ID.
+func (h *ChaosHandler) sessionFaults(id uint64) *chaosFaults {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for ctx, f := range h.sessions {
+		if ctx.Session != nil && ctx.Session.ID == id {
+			return f
+		}
+	}
+	return nil
+}
+
+// ServeAdmin changes fault injection at runtime.
+//
+//	GET    /               profiles, session overrides and counters
+//	POST   /               {"enabled": false} - turn injection on or off
+//	PUT    /default        profile for sessions without a more specific one
+//	DELETE /default        no faults for those sessions
+//	PUT    /sni/<name>     profile for one SNI
+//	DELETE /sni/<name>     remove the SNI profile
+//	PUT    /session/<id>   profile for one live session
+//	DELETE /session/<id>   remove the session override
+func (h *ChaosHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			writeAdminJSON(w, http.StatusOK, h.state())
+		case http.MethodPost, http.MethodPut:
+			var req struct {
+				Enabled bool `json:"enabled"`
+			}
+			if !readAdminJSON(w, r, &req) {
+				return
+			}
+			h.enabled.Store(req.Enabled)
+			chaosLog.Printf("fault injection %s via admin API", onOff(req.Enabled))
+			writeAdminJSON(w, http.StatusOK, h.state())
+		default:
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		}
+		return
+	}
+
+	kind, name, _ := strings.Cut(path, "/")
+	var set func(*ChaosProfile) bool
+	switch {
+	case kind == "default" && name == "":
+		set = func(p *ChaosProfile) bool {
+			h.mu.Lock()
+			h.def = p
+			h.mu.Unlock()
+			return true
+		}
+	case kind == "sni" && name != "":
+		name = strings.ToLower(name)
+		set = func(p *ChaosProfile) bool {
+			h.mu.Lock()
+			if p == nil {
+				delete(h.snis, name)
+			} else {
+				h.snis[name] = p
+			}
+			h.mu.Unlock()
+			return true
+		}
+	case kind == "session" && name != "":
+		id, err := strconv.ParseUint(name, 10, 64)
+		if err != nil {
+			writeAdminError(w, http.StatusBadRequest, "invalid session ID")
+			return
+		}
+		set = func(p *ChaosProfile) bool {
+			f := h.sessionFaults(id)
+			if f == nil {
+				return false
+			}
+			f.override.Store(p)
+			return true
+		}
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	var p *ChaosProfile
+	switch r.Method {
+	case http.MethodPut, http.MethodPost:
+		p = &ChaosProfile{}
+		if !readAdminJSON(w, r, p) {
+			return
+		}
+		if err := p.validate(); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	case http.MethodDelete:
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	if !set(p) {
+		writeAdminError(w, http.StatusNotFound, "unknown session")
+		return
+	}
+	if p == nil {
+		chaosLog.Printf("%s profile removed via admin API", path)
+	} else {
+		chaosLog.Printf("%s profile set via admin API", path)
+	}
+	writeAdminJSON(w, http.StatusOK, h.state())
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestChaos(t *testing.T, cfg string, r float64) *ChaosHandler {
+	t.Helper()
+	h, err := NewChaosHandler(json.RawMessage(cfg))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	ch := h.(*ChaosHandler)
+	ch.rand = func() float64 { return r }
+	return ch
+}
+
+// chaosConnect runs OnConnect for a client with the given SNI and returns its faults.
+func chaosConnect(t *testing.T, h *ChaosHandler, sni string) (*Context, *chaosFaults) {
+	t.Helper()
+	ctx := &Context{Hello: &ClientHello{SNI: sni}}
+	if res := h.OnConnect(ctx); res.Action != Continue {
+		t.Fatalf("expected Continue, got %v", res.Action)
+	}
+	f, ok := GetValue[*chaosFaults](ctx, chaosKey)
+	if !ok {
+		t.Fatal("no faults attached to context")
+	}
+	return ctx, f
+}
+
+// sendCount injects a packet and returns how many copies were sent right away.
+func sendCount(f *chaosFaults, dir Direction) (int, bool) {
+	n := 0
+	injected := f.inject(dir, []byte{0x40}, func([]byte) { n++ })
+	return n, injected
+}
+
+func TestChaos_Config(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr bool
+	}{
+		{"default", `{"default": {"loss": 0.1}}`, false},
+		{"snis only", `{"snis": {"a.example.com": {"delay_ms": 50}}}`, false},
+		{"empty", `{}`, true},
+		{"loss above 1", `{"default": {"loss": 1.5}}`, true},
+		{"negative delay", `{"default": {"delay_ms": -1}}`, true},
+		{"unknown direction", `{"default": {"direction": "sideways"}}`, true},
+		{"bad sni profile", `{"snis": {"a.example.com": {"duplicate": -0.1}}}`, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewChaosHandler(json.RawMessage(tt.cfg))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("err = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestChaos_LossAndDuplicate(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		rand float64
+		want int
+	}{
+		{"lost", `{"default": {"loss": 0.5}}`, 0.1, 0},
+		{"not lost", `{"default": {"loss": 0.5}}`, 0.9, 1},
+		{"duplicated", `{"default": {"duplicate": 0.5}}`, 0.1, 2},
+		{"not duplicated", `{"default": {"duplicate": 0.5}}`, 0.9, 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestChaos(t, tt.cfg, tt.rand)
+			_, f := chaosConnect(t, h, "a.example.com")
+			n, injected := sendCount(f, Inbound)
+			if !injected {
+				t.Fatal("expected faults to apply")
+			}
+			if n != tt.want {
+				t.Errorf("sent %d copies, want %d", n, tt.want)
+			}
+		})
+	}
+}
+
+func TestChaos_Direction(t *testing.T) {
+	h := newTestChaos(t, `{"default": {"loss": 1, "direction": "backend"}}`, 0)
+	_, f := chaosConnect(t, h, "a.example.com")
+	if _, injected := sendCount(f, Inbound); injected {
+		t.Error("client packets should not be affected")
+	}
+	if n, injected := sendCount(f, Outbound); !injected || n != 0 {
+		t.Errorf("backend packet: injected=%v sent=%d, want dropped", injected, n)
+	}
+	if got := h.Stats().Dropped; got != 1 {
+		t.Errorf("dropped = %d, want 1", got)
+	}
+}
+
+func TestChaos_SNIProfile(t *testing.T) {
+	h := newTestChaos(t, `{"snis": {"Lossy.example.com": {"loss": 1}}}`, 0)
+	_, lossy := chaosConnect(t, h, "lossy.example.com")
+	_, other := chaosConnect(t, h, "other.example.com")
+	if n, _ := sendCount(lossy, Inbound); n != 0 {
+		t.Error("packet for lossy SNI should be dropped")
+	}
+	if _, injected := sendCount(other, Inbound); injected {
+		t.Error("other SNIs have no profile and should pass untouched")
+	}
+}
+
+func TestChaos_DelayCopiesPacket(t *testing.T) {
+	h := newTestChaos(t, `{"default": {"delay_ms": 10}}`, 0)
+	_, f := chaosConnect(t, h, "a.example.com")
+
+	got := make(chan []byte, 1)
+	packet := []byte{0x40, 0x01}
+	start := time.Now()
+	f.inject(Inbound, packet, func(p []byte) { got <- p })
+	packet[1] = 0xff // The caller reuses its buffer
+
+	select {
+	case p := <-got:
+		if time.Since(start) < 10*time.Millisecond {
+			t.Error("packet sent before the delay")
+		}
+		if p[1] != 0x01 {
+			t.Error("delayed packet should be a copy")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("delayed packet never sent")
+	}
+}
+
+func TestChaos_BandwidthCap(t *testing.T) {
+	// 8 kbps and a 1000 byte packet: each packet occupies the link for 1s
+	h := newTestChaos(t, `{"default": {"bandwidth_kbps": 8, "queue_ms": 500}}`, 0)
+	_, f := chaosConnect(t, h, "a.example.com")
+	packet := make([]byte, 1000)
+
+	sent := 0
+	f.inject(Outbound, packet, func([]byte) { sent++ })
+	if sent != 1 {
+		t.Fatal("first packet should be sent right away")
+	}
+	f.inject(Outbound, packet, func([]byte) { sent++ })
+	if got := h.Stats().Shaped; got != 1 {
+		t.Errorf("shaped = %d, want 1 (second packet exceeds the queue)", got)
+	}
+	// The other direction has its own budget
+	f.inject(Inbound, packet, func([]byte) { sent++ })
+	if sent != 2 {
+		t.Errorf("sent = %d, want 2", sent)
+	}
+}
+
+func TestChaos_OnPacket(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  string
+		dir  Direction
+		want Result
+	}{
+		{"lost", `{"default": {"loss": 1}}`, Inbound, Result{Action: Drop}},
+		{"delayed", `{"default": {"delay_ms": 30}}`, Inbound, Result{Action: Delay, Delay: 30 * time.Millisecond}},
+		{"no latency", `{"default": {"duplicate": 1}}`, Inbound, Result{Action: Continue}},
+		{"backend packet", `{"default": {"loss": 1}}`, Outbound, Result{Action: Continue}},
+		{"backend only", `{"default": {"loss": 1, "direction": "backend"}}`, Inbound, Result{Action: Continue}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestChaos(t, tt.cfg, 0)
+			ctx, _ := chaosConnect(t, h, "a.example.com")
+			if got := h.OnPacket(ctx, []byte{0x40}, tt.dir); got.Action != tt.want.Action || got.Delay != tt.want.Delay {
+				t.Errorf("OnPacket = %v %v, want %v %v", got.Action, got.Delay, tt.want.Action, tt.want.Delay)
+			}
+		})
+	}
+}
+
+func TestChaos_Admin(t *testing.T) {
+	h := newTestChaos(t, `{"default": {"loss": 1}}`, 0)
+	ctx, f := chaosConnect(t, h, "a.example.com")
+	ctx.Session = &Session{ID: 7}
+
+	do := func(method, path, body string) int {
+		rec := httptest.NewRecorder()
+		h.ServeAdmin(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+		return rec.Code
+	}
+
+	if code := do(http.MethodPost, "/", `{"enabled": false}`); code != http.StatusOK {
+		t.Fatalf("disable: status %d", code)
+	}
+	if _, injected := sendCount(f, Inbound); injected {
+		t.Error("disabled handler should not inject faults")
+	}
+	do(http.MethodPost, "/", `{"enabled": true}`)
+
+	if code := do(http.MethodPut, "/session/7", `{"duplicate": 1}`); code != http.StatusOK {
+		t.Fatalf("session override: status %d", code)
+	}
+	if n, _ := sendCount(f, Inbound); n != 2 {
+		t.Errorf("session override: sent %d copies, want 2", n)
+	}
+	if code := do(http.MethodPut, "/session/8", `{"loss": 1}`); code != http.StatusNotFound {
+		t.Errorf("unknown session: status %d, want 404", code)
+	}
+
+	do(http.MethodDelete, "/session/7", "")
+	do(http.MethodDelete, "/default", "")
+	if _, injected := sendCount(f, Inbound); injected {
+		t.Error("no profile left, packet should pass untouched")
+	}
+
+	if code := do(http.MethodPut, "/sni/a.example.com", `{"loss": 2}`); code != http.StatusBadRequest {
+		t.Errorf("invalid profile: status %d, want 400", code)
+	}
+	if code := do(http.MethodGet, "/nope", ""); code != http.StatusNotFound {
+		t.Errorf("unknown path: status %d, want 404", code)
+	}
+
+	h.OnDisconnect(ctx)
+	if h.sessionFaults(7) != nil {
+		t.Error("session should be forgotten on disconnect")
+	}
+}
@@ -2,10 +2,18 @@ package handler
 
 import (
 	"context"
+	"errors"
+	"io"
+	"maps"
 	"net"
+	"slices"
+	"strings"
 	"sync"
 	"sync/atomic"
 	"time"
+
+	"quic-relay/internal/debug"
+	"quic-relay/internal/timerwheel"
 )
 
 // coarseTime holds a Unix timestamp updated once per second.
@@ -46,11 +54,72 @@ type Session struct {
 	ID           uint64
 	DCID         []byte                      // Destination Connection ID from Initial packet (session key)
 	clientAddr   atomic.Pointer[net.UDPAddr] // Current client address (atomic for connection migration)
-	BackendAddr  *net.UDPAddr
+	backendAddr  atomic.Pointer[net.UDPAddr] // Current backend address (atomic for server migration)
 	BackendConn  *net.UDPConn
 	CreatedAt    time.Time
 	LastActivity atomic.Int64 // Unix timestamp - updated atomically on every packet
 	closed       atomic.Bool  // Set when session is being closed - prevents use-after-close
+
+	relayHop  *HopInfo     // Set when the backend is another quic-relay (datagrams get a hop header)
+	clientCID []byte       // Client's source connection ID, used to validate backend migrations
+	migrates  bool         // BackendConn is unconnected and follows the backend to new addresses
+	moveTo    *net.UDPAddr // New backend address not confirmed yet; only used by the backend reader
+	moveSeen  int          // Consecutive packets from moveTo
+	faults    *chaosFaults // Set when the chaos handler injects faults into this session
+	upstream  io.Closer    // Tunnel through an upstream proxy, closed with BackendConn
+
+	unconfirmed atomic.Bool  // Restored from a snapshot, client has not sent a packet yet
+	pause       sessionPause // Client packets held while an operator moves the session
+	delayed     atomic.Int32 // Client packets held by Delay results
+
+	trace *debug.Recorder // Recent packets and events, attached to error logs
+
+	limit     datagramLimit // max_datagram of the session's route or forwarder
+	oversized atomic.Bool   // An oversized datagram was logged as a warning
+
+	// Traffic counters, client -> backend (in) and backend -> client (out)
+	packetsIn, packetsOut atomic.Uint64
+	bytesIn, bytesOut     atomic.Uint64
+}
+
+// SessionCounters is a snapshot of a session's traffic counters.
+type SessionCounters struct {
+	PacketsIn  uint64 `json:"packets_in"`
+	PacketsOut uint64 `json:"packets_out"`
+	BytesIn    uint64 `json:"bytes_in"`
+	BytesOut   uint64 `json:"bytes_out"`
+}
+
+// CountIn records a packet forwarded from the client to the backend.
+func (s *Session) CountIn(n int) {
+	s.packetsIn.Add(1)
+	s.bytesIn.Add(uint64(n))
+}
+
+// CountOut records a packet received from the backend for the client.
+func (s *Session) CountOut(n int) {
+	s.packetsOut.Add(1)
+	s.bytesOut.Add(uint64(n))
+}
+
+// Counters returns the session's traffic counters.
+func (s *Session) Counters() SessionCounters {
+	return SessionCounters{
+		PacketsIn:  s.packetsIn.Load(),
+		PacketsOut: s.packetsOut.Load(),
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+	}
+}
+
+// Trace returns the session's recent packets and events, oldest first.
+func (s *Session) Trace() []debug.Event {
+	return s.trace.Events()
+}
+
+// Note records an event in the session's trace.
+func (s *Session) Note(kind, detail string) {
+	s.trace.Note(kind, detail)
 }
 
 // Touch updates the last activity timestamp atomically.
@@ -71,11 +140,32 @@ func (s *Session) Close() bool {
 	return s.closed.CompareAndSwap(false, true)
 }
 
+// closeBackend closes the backend socket and the upstream tunnel, if any.
+func (s *Session) closeBackend() {
+	s.BackendConn.Close()
+	if s.upstream != nil {
+		s.upstream.Close()
+	}
+}
+
 // IsClosed returns whether the session has been closed.
 func (s *Session) IsClosed() bool {
 	return s.closed.Load()
 }
 
+// Unconfirmed reports whether the session was restored from a snapshot and the
+// client has not yet proven it still uses it. Backend packets are not sent to
+// unconfirmed clients.
+func (s *Session) Unconfirmed() bool {
+	return s.unconfirmed.Load()
+}
+
+// Confirm marks a restored session as confirmed by a client packet.
+// Returns true if this call confirmed the session.
+func (s *Session) Confirm() bool {
+	return s.unconfirmed.CompareAndSwap(true, false)
+}
+
 // DCIDKey returns the DCID as a string key for map lookups.
 func (s *Session) DCIDKey() string {
 	return string(s.DCID)
@@ -93,6 +183,85 @@ func (s *Session) SetClientAddr(addr *net.UDPAddr) {
 	s.clientAddr.Store(addr)
 }
 
+// BackendAddr returns the current backend address (atomic read).
+func (s *Session) BackendAddr() *net.UDPAddr {
+	return s.backendAddr.Load()
+}
+
+// SetBackendAddr updates the backend address (atomic write).
+// Used when the backend migrates to a new address.
+func (s *Session) SetBackendAddr(addr *net.UDPAddr) {
+	s.backendAddr.Store(addr)
+}
+
+// CloseReason describes why a session ended.
+// Passed to handlers via Context.CloseReason() in OnDisconnect.
+type CloseReason int32
+
+const (
+	// CloseUnknown means no reason was recorded.
+	CloseUnknown CloseReason = iota
+	// CloseIdle means the session exceeded the idle timeout.
+	CloseIdle
+	// CloseBackendError means reading from or writing to the backend failed.
+	CloseBackendError
+	// CloseHandlerDrop means a handler terminated the session via Drop.
+	CloseHandlerDrop
+	// CloseAdminKill means an operator terminated the session.
+	CloseAdminKill
+	// CloseDrain means the proxy is shutting down or draining.
+	CloseDrain
+	// CloseEvicted means the session was evicted because the session table was full.
+	CloseEvicted
+	// CloseBackendReset means the backend sent a QUIC stateless reset.
+	CloseBackendReset
+	// CloseVersionNegotiation means the backend answered with a QUIC Version Negotiation packet.
+	CloseVersionNegotiation
+	// CloseViolation means the client broke the QUIC protocol (see the proxy's violations policy).
+	CloseViolation
+	// CloseClientError means writing to the client failed.
+	CloseClientError
+)
+
+// String returns a short name for the close reason, suitable for logs and metrics.
+func (r CloseReason) String() string {
+	switch r {
+	case CloseIdle:
+		return "idle"
+	case CloseBackendError:
+		return "backend_error"
+	case CloseHandlerDrop:
+		return "handler_drop"
+	case CloseAdminKill:
+		return "admin_kill"
+	case CloseDrain:
+		return "drain"
+	case CloseEvicted:
+		return "evicted"
+	case CloseBackendReset:
+		return "backend_reset"
+	case CloseVersionNegotiation:
+		return "version_negotiation"
+	case CloseViolation:
+		return "violation"
+	case CloseClientError:
+		return "client_error"
+	default:
+		return "unknown"
+	}
+}
+
+// Graceful reports whether the session ended without a failure.
+func (r CloseReason) Graceful() bool {
+	return r == CloseIdle || r == CloseDrain
+}
+
+// ClientConn sends datagrams back to clients. *net.UDPConn implements it.
+type ClientConn interface {
+	WriteToUDP(b []byte, addr *net.UDPAddr) (int, error)
+	LocalAddr() net.Addr
+}
+
 // Context carries request-scoped data through the handler chain.
 // All value access methods are thread-safe.
 type Context struct {
@@ -105,15 +274,26 @@ type Context struct {
 	// Hello is the parsed ClientHello (nil until parsed).
 	Hello *ClientHello
 
+	// Protocol is the name of the matched protocol rule for non-QUIC flows.
+	// Empty for QUIC connections. Non-QUIC flows have no ClientHello.
+	Protocol string
+
+	// Hop is set when the connection was relayed by an upstream quic-relay.
+	// ClientAddr is then the upstream relay; see OriginalClientAddr.
+	Hop *HopInfo
+
 	// Session is the UDP session state (created by forwarder handler).
 	Session *Session
 
-	// ProxyConn is a reference to the proxy's UDP connection for sending responses.
-	ProxyConn *net.UDPConn
+	// ProxyConn is the connection the client reached the proxy on, used for sending responses.
+	// A *net.UDPConn for UDP listeners; ingress adapters (SOCKS5, TCP tunnel) provide their own.
+	ProxyConn ClientConn
 
-	// OnServerPacket is called for every Long Header packet from backend.
+	// OnServerPacket is called for every Long Header packet from backend
+	// (every packet for non-QUIC flows).
 	// Used by proxy to learn server's SCID(s) for DCID-based routing.
-	// Set by proxy before passing context to handlers.
+	// Set by proxy before passing context to handlers; handlers that need to
+	// observe backend packets may wrap it.
 	OnServerPacket func(packet []byte)
 
 	// DropSession immediately removes the session from the proxy.
@@ -122,6 +302,45 @@ type Context struct {
 	DropSession       func()
 	dropSessionCalled atomic.Bool
 
+	// IdleTimer closes the session once it goes without client packets for
+	// its idle timeout. Set by proxy when it stores the session.
+	IdleTimer *timerwheel.Timer
+
+	// SendConnectionClose sends a QUIC CONNECTION_CLOSE to the client in a server
+	// Initial packet, refusing the connection with an error code and reason phrase.
+	// Set by proxy before OnConnect; only valid during OnConnect.
+	SendConnectionClose func(errorCode uint64, reason string) error
+	refused             atomic.Bool // A CONNECTION_CLOSE was sent via Refuse
+
+	// SendInitialAck acknowledges the client's first Initial in a server
+	// Initial packet, so the client waits for a handshake that never comes.
+	// Set by proxy before OnConnect for QUIC connections; the first call must
+	// happen during OnConnect, later calls may come from any goroutine.
+	SendInitialAck func() error
+
+	// SessionCount returns the live number of active sessions.
+	// Set by proxy before OnConnect. May be nil when used outside the proxy.
+	SessionCount func() int64
+
+	// TryAcquireSession reserves one of limit proxy-wide session slots for the
+	// connection and reports whether it got one. Unlike comparing SessionCount,
+	// it counts connections still in OnConnect, so concurrent connections
+	// cannot exceed the limit. A connection holds at most one slot; further
+	// calls only check limit. The session keeps the slot until it is deleted.
+	// Set by proxy before OnConnect. May be nil when used outside the proxy.
+	TryAcquireSession func(limit int64) bool
+
+	// ReleaseSession gives the connection's slot back early, e.g. when a
+	// limiter wakes waiting connections. Safe to call without a slot.
+	// Set together with TryAcquireSession.
+	ReleaseSession func()
+
+	// resetTokens holds the stateless reset tokens of the backend's connection IDs, when known.
+	resetTokens atomic.Pointer[[]string]
+
+	// closeReason records why the session ended (first reason set wins).
+	closeReason atomic.Int32
+
 	// values is a thread-safe key-value store for passing data between handlers.
 	values map[string]any
 	mu     sync.RWMutex
@@ -129,11 +348,46 @@ type Context struct {
 
 // NotifyServerPacket calls OnServerPacket callback for Long Header packets.
 // Used to learn new SCIDs from the server during handshake.
+// For non-QUIC flows all backend packets are passed.
 func (c *Context) NotifyServerPacket(packet []byte) {
-	if c.OnServerPacket != nil && len(packet) > 0 && (packet[0]&0x80) != 0 {
-		// Only call for Long Header packets (first bit = 1)
-		c.OnServerPacket(packet)
+	if c.OnServerPacket == nil || len(packet) == 0 {
+		return
+	}
+	// QUIC: only Long Header packets (first bit = 1) carry SCIDs
+	if c.Protocol == "" && packet[0]&0x80 == 0 {
+		return
 	}
+	c.OnServerPacket(packet)
+}
+
+// AddResetToken registers a stateless reset token of one of the backend's
+// connection IDs, so IsStatelessReset can recognize resets from the backend.
+func (c *Context) AddResetToken(token []byte) {
+	for {
+		old := c.resetTokens.Load()
+		var tokens []string
+		if old != nil {
+			if slices.Contains(*old, string(token)) {
+				return
+			}
+			tokens = append(tokens, *old...)
+		}
+		tokens = append(tokens, string(token))
+		if c.resetTokens.CompareAndSwap(old, &tokens) {
+			return
+		}
+	}
+}
+
+// IsStatelessReset reports whether a backend packet is a QUIC stateless reset
+// (RFC 9000 Section 10.3.1), i.e. a short header packet ending in a known token.
+func (c *Context) IsStatelessReset(packet []byte) bool {
+	tokens := c.resetTokens.Load()
+	if tokens == nil || len(packet) < 21 || packet[0]&0xc0 != 0x40 {
+		return false
+	}
+	tail := string(packet[len(packet)-16:])
+	return slices.Contains(*tokens, tail)
 }
 
 // Drop immediately removes the session from the proxy.
@@ -145,6 +399,44 @@ func (c *Context) Drop() {
 	}
 }
 
+// Refuse sends a CONNECTION_CLOSE to the client so it fails fast with a reason
+// instead of timing out. The caller should still return Drop from OnConnect.
+func (c *Context) Refuse(errorCode uint64, reason string) error {
+	if c.SendConnectionClose == nil {
+		return errors.New("connection close not supported")
+	}
+	if err := c.SendConnectionClose(errorCode, reason); err != nil {
+		return err
+	}
+	c.refused.Store(true)
+	return nil
+}
+
+// Refused reports whether a handler already refused the connection via Refuse.
+func (c *Context) Refused() bool {
+	return c.refused.Load()
+}
+
+// DropWithReason records the close reason and removes the session from the proxy.
+// The reason is only recorded if no other reason was set before.
+func (c *Context) DropWithReason(reason CloseReason) {
+	c.SetCloseReason(reason)
+	c.Drop()
+}
+
+// SetCloseReason records why the session ended.
+// Only the first reason is kept; returns false if a reason was already set.
+// Safe to call from any goroutine.
+func (c *Context) SetCloseReason(reason CloseReason) bool {
+	return c.closeReason.CompareAndSwap(int32(CloseUnknown), int32(reason))
+}
+
+// CloseReason returns why the session ended.
+// Valid inside OnDisconnect; returns CloseUnknown while the session is alive.
+func (c *Context) CloseReason() CloseReason {
+	return CloseReason(c.closeReason.Load())
+}
+
 // Set stores a value in the context (thread-safe).
 func (c *Context) Set(key string, value any) {
 	c.mu.Lock()
@@ -155,6 +447,30 @@ func (c *Context) Set(key string, value any) {
 	c.values[key] = value
 }
 
+// copyValues returns a copy of the stored values.
+func (c *Context) copyValues() map[string]any {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return maps.Clone(c.values)
+}
+
+// replaceValues replaces the stored values with values, keeping keys starting
+// with "_" (internal bookkeeping of handlers) from the current values.
+func (c *Context) replaceValues(values map[string]any) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	next := maps.Clone(values)
+	for k, v := range c.values {
+		if strings.HasPrefix(k, "_") {
+			if next == nil {
+				next = make(map[string]any)
+			}
+			next[k] = v
+		}
+	}
+	c.values = next
+}
+
 // Get retrieves a value from the context (thread-safe).
 func (c *Context) Get(key string) (any, bool) {
 	c.mu.RLock()
@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"syscall"
+	"time"
+)
+
+// MaxDatagramKey is the context key a router sets to the max_datagram of the
+// matched route. It overrides the forwarder's max_datagram.
+const MaxDatagramKey = "max_datagram"
+
+// Actions for datagrams above max_datagram.
+const (
+	OversizeDrop     = "drop"     // Discard the datagram
+	OversizeTruncate = "truncate" // Forward the first max_datagram bytes
+)
+
+// maxUDPPayload is the largest payload of a UDP datagram over IPv4.
+const maxUDPPayload = 65507
+
+// maxPacingDelay is how long the bandwidth cap holds a client datagram at
+// most; datagrams that would wait longer are dropped.
+const maxPacingDelay = 500 * time.Millisecond
+
+// minQUICDatagram is the smallest datagram QUIC endpoints must be able to
+// send (RFC 9000 Section 14); client Initials are padded to it.
+const minQUICDatagram = 1200
+
+// DatagramLimitConfig caps the size and rate of datagrams the forwarder
+// passes on.
+type DatagramLimitConfig struct {
+	MaxDatagram   int    `json:"max_datagram,omitempty"`   // Largest datagram forwarded in either direction (0 = no limit)
+	Oversize      string `json:"oversize,omitempty"`       // "drop" (default) or "truncate"
+	BandwidthKbps int    `json:"bandwidth_kbps,omitempty"` // Per session and direction (0 = unlimited)
+}
+
+func (c *DatagramLimitConfig) validate() error {
+	if err := validateMaxDatagram(c.MaxDatagram); err != nil {
+		return err
+	}
+	if c.BandwidthKbps < 0 {
+		return errors.New("bandwidth_kbps must be >= 0")
+	}
+	switch c.Oversize {
+	case "":
+		c.Oversize = OversizeDrop
+	case OversizeDrop, OversizeTruncate:
+	default:
+		return fmt.Errorf("unknown oversize action %q", c.Oversize)
+	}
+	return nil
+}
+
+// validateMaxDatagram checks a max_datagram value.
+func validateMaxDatagram(n int) error {
+	if n < 0 || n > maxUDPPayload {
+		return fmt.Errorf("max_datagram must be between 0 and %d", maxUDPPayload)
+	}
+	return nil
+}
+
+// datagramLimit is the max_datagram and bandwidth cap in force for one session.
+type datagramLimit struct {
+	max       int // 0 = no limit
+	truncate  bool
+	bandwidth *bandwidthCap // nil = unlimited
+}
+
+// limit returns the datagram limit for ctx: the route's settings where the
+// router set them, otherwise the forwarder's.
+func (c *DatagramLimitConfig) limit(ctx *Context) datagramLimit {
+	l := datagramLimit{max: c.MaxDatagram, truncate: c.Oversize == OversizeTruncate}
+	if n, ok := GetValue[int](ctx, MaxDatagramKey); ok && n > 0 {
+		l.max = n
+	}
+	o := Overrides(ctx)
+	if o.MaxDatagram > 0 {
+		l.max = o.MaxDatagram
+	}
+	if o.Oversize != "" {
+		l.truncate = o.Oversize == OversizeTruncate
+	}
+	if kbps := cmp.Or(o.BandwidthKbps, c.BandwidthKbps); kbps > 0 {
+		l.bandwidth = newBandwidthCap(kbps)
+	}
+	return l
+}
+
+// apply returns the part of packet to forward and how long to hold it for
+// the bandwidth cap, or false to discard it. Datagrams wait up to maxWait
+// for the cap; above it, they are discarded. The first oversized datagram of
+// a session is logged as a warning.
+func (l datagramLimit) apply(ctx *Context, session *Session, packet []byte, dir Direction, maxWait time.Duration) ([]byte, time.Duration, bool) {
+	if l.max == 0 || len(packet) <= l.max {
+		return l.withinBandwidth(packet, dir, maxWait)
+	}
+	overloadCounters.oversize.Add(1)
+	action, from := OversizeDrop, "client"
+	if l.truncate {
+		action = OversizeTruncate
+	}
+	if dir == Outbound {
+		from = "backend"
+	}
+	session.trace.Note("oversize", fmt.Sprintf("%s %dB %s", from, len(packet), action))
+	log := sessionLog(ctx, forwarderLog).Debugf
+	if session.oversized.CompareAndSwap(false, true) {
+		log = sessionLog(ctx, forwarderLog).Warnf
+	}
+	log("session=%d: %s datagram of %d bytes exceeds max_datagram %d, %s", session.ID, from, len(packet), l.max, action)
+	if !l.truncate {
+		return nil, 0, false
+	}
+	return l.withinBandwidth(packet[:l.max], dir, maxWait)
+}
+
+// withinBandwidth returns packet and how long it waits for the session's
+// bandwidth cap, or false to discard it when it would wait longer than maxWait.
+func (l datagramLimit) withinBandwidth(packet []byte, dir Direction, maxWait time.Duration) ([]byte, time.Duration, bool) {
+	if l.bandwidth == nil {
+		return packet, 0, true
+	}
+	wait, ok := l.bandwidth.reserve(dir, len(packet), time.Now(), maxWait)
+	if !ok {
+		overloadCounters.overBandwidth.Add(1)
+		return nil, 0, false
+	}
+	return packet, wait, true
+}
+
+// messageTooLong reports whether the kernel refused a datagram above the path
+// MTU. Listeners set the don't-fragment bit where the platform supports it.
+func messageTooLong(err error) bool {
+	return errors.Is(err, syscall.EMSGSIZE)
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/timerwheel"
+)
+
+var delayLog = logging.For("delay")
+
+// maxDelayedPackets bounds the packets of a session held by Delay results.
+// Later delayed packets are dropped; QUIC retransmits them.
+const maxDelayedPackets = 256
+
+// maxDelay caps Result.Delay; longer delays are shortened to it.
+const maxDelay = 10 * time.Second
+
+// delayPacket holds a copy of packet for result.Delay on the shared timing
+// wheel, then passes it to the handlers after the one that delayed it. A packet delayed again is held
+// again; one dropped is only logged, the client is no longer waiting on it.
+func delayPacket(ctx *Context, packet []byte, result Result) {
+	if result.resume == nil {
+		return
+	}
+	session := ctx.Session
+	if session != nil && session.delayed.Add(1) > maxDelayedPackets {
+		session.delayed.Add(-1)
+		overloadCounters.delayDropped.Add(1)
+		return
+	}
+	overloadCounters.delayed.Add(1)
+	buf := GetBufferSized(len(packet))
+	n := copy(*buf, packet)
+	timerwheel.AfterFunc(min(result.Delay, maxDelay), func() {
+		defer PutBuffer(buf)
+		if session != nil {
+			defer session.delayed.Add(-1)
+			if session.IsClosed() {
+				return
+			}
+		}
+		r := result.resume((*buf)[:n])
+		switch r.Action {
+		case Delay:
+			delayPacket(ctx, (*buf)[:n], r)
+		case Drop:
+			if r.Error != nil {
+				delayLog.Debugf("delayed packet dropped: %v", r.Error)
+			}
+		}
+	})
+}
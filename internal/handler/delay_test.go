@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// delayingHandler delays every packet it has not seen yet by d.
+type delayingHandler struct {
+	d    time.Duration
+	seen map[byte]bool
+}
+
+func (h *delayingHandler) Name() string                  { return "delaying" }
+func (h *delayingHandler) OnConnect(ctx *Context) Result { return Result{Action: Continue} }
+func (h *delayingHandler) OnDisconnect(ctx *Context)     {}
+
+func (h *delayingHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	if h.seen[packet[0]] {
+		return Result{Action: Continue}
+	}
+	h.seen[packet[0]] = true
+	return Result{Action: Delay, Delay: h.d}
+}
+
+// recordingHandler sends the packets it sees to ch.
+type recordingHandler struct {
+	ch     chan []byte
+	action Action
+}
+
+func (h *recordingHandler) Name() string                  { return "recording" }
+func (h *recordingHandler) OnConnect(ctx *Context) Result { return Result{Action: Continue} }
+func (h *recordingHandler) OnDisconnect(ctx *Context)     {}
+
+func (h *recordingHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	h.ch <- bytes.Clone(packet)
+	return Result{Action: h.action}
+}
+
+// subChainHandler runs a chain of its own, like tenants.
+type subChainHandler struct {
+	chain *Chain
+}
+
+func (h *subChainHandler) Name() string                  { return "sub" }
+func (h *subChainHandler) OnConnect(ctx *Context) Result { return Result{Action: Continue} }
+func (h *subChainHandler) OnDisconnect(ctx *Context)     {}
+
+func (h *subChainHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return h.chain.packet(ctx, packet, dir)
+}
+
+func receive(t *testing.T, ch chan []byte) []byte {
+	t.Helper()
+	select {
+	case p := <-ch:
+		return p
+	case <-time.After(2 * time.Second):
+		t.Fatal("packet not passed on")
+		return nil
+	}
+}
+
+func TestChain_OnPacket_Delay(t *testing.T) {
+	inner := &recordingHandler{ch: make(chan []byte, 4), action: Continue}
+	outer := &recordingHandler{ch: make(chan []byte, 4), action: Handled}
+	delaying := &delayingHandler{d: 20 * time.Millisecond, seen: map[byte]bool{}}
+	chain := NewChain(&subChainHandler{NewChain(delaying, inner)}, outer)
+	ctx := &Context{Session: &Session{}}
+
+	before := GetOverloadStats().Delayed
+	packet := []byte{1, 2, 3}
+	start := time.Now()
+	if r := chain.OnPacket(ctx, packet, Inbound); r.Action != Delay {
+		t.Fatalf("OnPacket = %v, want Delay", r.Action)
+	}
+	packet[1] = 0xff // The caller reuses its buffer
+
+	// The handlers after the delaying one see the packet, those of the
+	// enclosing chain too
+	if p := receive(t, inner.ch); !bytes.Equal(p, []byte{1, 2, 3}) {
+		t.Errorf("inner got %x", p)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("passed on after %s", elapsed)
+	}
+	if p := receive(t, outer.ch); !bytes.Equal(p, []byte{1, 2, 3}) {
+		t.Errorf("outer got %x", p)
+	}
+	if got := GetOverloadStats().Delayed - before; got != 1 {
+		t.Errorf("delayed = %d, want 1", got)
+	}
+
+	// A delay of 0 passes the packet on right away
+	delaying.d = 0
+	if r := chain.OnPacket(ctx, []byte{2}, Inbound); r.Action != Handled {
+		t.Errorf("OnPacket = %v, want Handled", r.Action)
+	}
+	receive(t, inner.ch)
+	receive(t, outer.ch)
+}
+
+func TestChain_OnPacket_DelayLimit(t *testing.T) {
+	rec := &recordingHandler{ch: make(chan []byte, 1), action: Handled}
+	chain := NewChain(&delayingHandler{d: time.Hour, seen: map[byte]bool{}}, rec)
+	session := &Session{}
+	session.delayed.Store(maxDelayedPackets)
+	ctx := &Context{Session: session}
+
+	before := GetOverloadStats().DelayDropped
+	chain.OnPacket(ctx, []byte{1}, Inbound)
+	if got := GetOverloadStats().DelayDropped - before; got != 1 {
+		t.Errorf("delay_dropped = %d, want 1", got)
+	}
+	if n := session.delayed.Load(); n != maxDelayedPackets {
+		t.Errorf("held = %d", n)
+	}
+
+	// Packets of a closed session are discarded
+	session.delayed.Store(0)
+	chain = NewChain(&delayingHandler{d: time.Millisecond, seen: map[byte]bool{}}, rec)
+	session.closed.Store(true)
+	chain.OnPacket(ctx, []byte{1}, Inbound)
+	deadline := time.Now().Add(2 * time.Second)
+	for session.delayed.Load() != 0 && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if len(rec.ch) != 0 || session.delayed.Load() != 0 {
+		t.Error("closed session's packet passed on")
+	}
+}
@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DrainInfo describes a backend taken out of rotation for maintenance.
+type DrainInfo struct {
+	Backend  string    `json:"backend"`
+	Since    time.Time `json:"since"`
+	Deadline time.Time `json:"deadline,omitzero"` // Remaining sessions are closed after this (zero: never)
+}
+
+// drains holds the draining backends. It is process-wide so draining
+// survives handler chain reloads.
+var drains struct {
+	mu       sync.RWMutex
+	backends map[string]DrainInfo
+	count    atomic.Int32 // Fast path for the common case of no draining backend
+}
+
+// DrainBackend stops routers from sending new connections to addr. A non-zero
+// deadline closes its remaining sessions at that time. Draining a backend again
+// updates the deadline.
+func DrainBackend(addr string, deadline time.Time) DrainInfo {
+	drains.mu.Lock()
+	defer drains.mu.Unlock()
+	if drains.backends == nil {
+		drains.backends = make(map[string]DrainInfo)
+	}
+	info, ok := drains.backends[addr]
+	if !ok {
+		info = DrainInfo{Backend: addr, Since: time.Now()}
+	}
+	info.Deadline = deadline
+	drains.backends[addr] = info
+	drains.count.Store(int32(len(drains.backends)))
+	return info
+}
+
+// UndrainBackend returns addr to rotation. Returns false if it was not draining.
+func UndrainBackend(addr string) bool {
+	drains.mu.Lock()
+	defer drains.mu.Unlock()
+	if _, ok := drains.backends[addr]; !ok {
+		return false
+	}
+	delete(drains.backends, addr)
+	drains.count.Store(int32(len(drains.backends)))
+	return true
+}
+
+// SetDrainingBackends replaces the draining backends with infos, as when a
+// config snapshot is rolled back.
+func SetDrainingBackends(infos []DrainInfo) {
+	drains.mu.Lock()
+	defer drains.mu.Unlock()
+	drains.backends = make(map[string]DrainInfo, len(infos))
+	for _, info := range infos {
+		drains.backends[info.Backend] = info
+	}
+	drains.count.Store(int32(len(drains.backends)))
+}
+
+// Draining reports whether addr is draining. relay:// backends match by address.
+func Draining(addr string) bool {
+	if drains.count.Load() == 0 {
+		return false
+	}
+	_, ok := DrainStatus(addr)
+	return ok
+}
+
+// DrainStatus returns the drain state of addr.
+func DrainStatus(addr string) (DrainInfo, bool) {
+	if drains.count.Load() == 0 {
+		return DrainInfo{}, false
+	}
+	drains.mu.RLock()
+	defer drains.mu.RUnlock()
+	info, ok := drains.backends[addr]
+	if !ok {
+		info, ok = drains.backends[strings.TrimPrefix(addr, RelayScheme)]
+	}
+	return info, ok
+}
+
+// DrainingBackends returns all draining backends, sorted by address.
+func DrainingBackends() []DrainInfo {
+	drains.mu.RLock()
+	defer drains.mu.RUnlock()
+	infos := make([]DrainInfo, 0, len(drains.backends))
+	for _, info := range drains.backends {
+		infos = append(infos, info)
+	}
+	slices.SortFunc(infos, func(a, b DrainInfo) int { return strings.Compare(a.Backend, b.Backend) })
+	return infos
+}
@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// drainForTest drains addr until the test ends.
+func drainForTest(t *testing.T, addr string) {
+	t.Helper()
+	DrainBackend(addr, time.Time{})
+	t.Cleanup(func() { UndrainBackend(addr) })
+}
+
+func TestDrainBackend(t *testing.T) {
+	deadline := time.Now().Add(time.Minute)
+	info := DrainBackend("10.0.0.1:5520", time.Time{})
+	t.Cleanup(func() { UndrainBackend("10.0.0.1:5520") })
+	if again := DrainBackend("10.0.0.1:5520", deadline); !again.Since.Equal(info.Since) || !again.Deadline.Equal(deadline) {
+		t.Errorf("draining again = %+v, want same start and new deadline", again)
+	}
+	if !Draining("10.0.0.1:5520") || !Draining("relay://10.0.0.1:5520") || Draining("10.0.0.2:5520") {
+		t.Error("Draining does not match the drained address")
+	}
+	if list := DrainingBackends(); len(list) != 1 || list[0].Backend != "10.0.0.1:5520" {
+		t.Errorf("DrainingBackends = %+v", list)
+	}
+	if !UndrainBackend("10.0.0.1:5520") || UndrainBackend("10.0.0.1:5520") || Draining("10.0.0.1:5520") {
+		t.Error("UndrainBackend should succeed once")
+	}
+}
+
+func TestBackendPool_SkipsDraining(t *testing.T) {
+	drainForTest(t, "b")
+
+	rr := poolFromStrings([]string{"a", "b", "c"})
+	counts := map[string]int{}
+	for range 6 {
+		counts[rr.pick(nil)]++
+	}
+	if counts["b"] != 0 || counts["a"]+counts["c"] != 6 {
+		t.Errorf("round-robin picks = %v, want none on b", counts)
+	}
+
+	weighted, err := newBackendPool([]backendEntry{{Addr: "a", Weight: 1}, {Addr: "b", Weight: 5}, {Addr: "z"}, {Addr: "c", Weight: 1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range 50 {
+		client := &net.UDPAddr{IP: net.IPv4(198, 51, 100, byte(i))}
+		if got := weighted.pick(client); got == "b" || got == "z" {
+			t.Fatalf("weighted pick = %s", got)
+		}
+	}
+
+	drainForTest(t, "a")
+	drainForTest(t, "c")
+	if got := rr.pick(nil); got == "" {
+		t.Error("all draining: pool should still pick a backend")
+	}
+}
+
+func TestResumedBackend_Draining(t *testing.T) {
+	ctx := &Context{}
+	ctx.Set(ResumeKey, "10.0.0.1:5520")
+	drainForTest(t, "10.0.0.1:5520")
+	if got := ctx.ResumedBackend(); got != "" {
+		t.Errorf("ResumedBackend = %q for a draining backend", got)
+	}
+}
@@ -0,0 +1,53 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Drop responses: what the client sees when a handler drops its connection or packet.
+const (
+	DropSilent   = "silent"   // Discard without a response (default); clients time out
+	DropICMP     = "icmp"     // ICMP port unreachable (needs CAP_NET_RAW)
+	DropReset    = "reset"    // QUIC stateless reset
+	DropClose    = "close"    // Initial CONNECTION_CLOSE (new QUIC connections only)
+	DropRedirect = "redirect" // Hand the new connection to a pipeline instead (see pipeline.go)
+)
+
+// DropPolicy maps a handler's Drop to a wire behavior. Configured per handler
+// as "on_drop", either as an action string or an object.
+type DropPolicy struct {
+	Action    string `json:"action"`
+	ErrorCode uint64 `json:"error_code,omitempty"` // close: QUIC transport error code (default: CONNECTION_REFUSED)
+	Reason    string `json:"reason,omitempty"`     // close: reason phrase (default: the drop error)
+	Pipeline  string `json:"pipeline,omitempty"`   // redirect: pipeline taking the connection
+}
+
+// UnmarshalJSON accepts "icmp" as well as {"action": "close", "reason": "..."}.
+func (p *DropPolicy) UnmarshalJSON(data []byte) error {
+	var action string
+	if err := json.Unmarshal(data, &action); err == nil {
+		*p = DropPolicy{Action: action}
+	} else {
+		type plain DropPolicy
+		if err := json.Unmarshal(data, (*plain)(p)); err != nil {
+			return err
+		}
+	}
+	switch p.Action {
+	case "":
+		p.Action = DropSilent
+	case DropSilent, DropICMP, DropReset:
+	case DropClose:
+		if p.ErrorCode == 0 {
+			p.ErrorCode = connectionRefused
+		}
+	case DropRedirect:
+		if p.Pipeline == "" {
+			return fmt.Errorf("on_drop redirect requires 'pipeline'")
+		}
+	default:
+		return fmt.Errorf("unknown on_drop action %q", p.Action)
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestDropPolicy_Unmarshal(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    DropPolicy
+		wantErr string
+	}{
+		{`"icmp"`, DropPolicy{Action: DropICMP}, ""},
+		{`"reset"`, DropPolicy{Action: DropReset}, ""},
+		{`{}`, DropPolicy{Action: DropSilent}, ""},
+		{`{"action": "close", "reason": "go away"}`, DropPolicy{Action: DropClose, ErrorCode: connectionRefused, Reason: "go away"}, ""},
+		{`{"action": "close", "error_code": 1}`, DropPolicy{Action: DropClose, ErrorCode: 1}, ""},
+		{`"rst"`, DropPolicy{}, "unknown on_drop action"},
+		{`42`, DropPolicy{}, "cannot unmarshal"},
+	}
+	for _, tt := range tests {
+		var got DropPolicy
+		err := json.Unmarshal([]byte(tt.in), &got)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestBuildChain_DropPolicy(t *testing.T) {
+	var configs []HandlerConfig
+	err := json.Unmarshal([]byte(`[
+		{"type": "logsni"},
+		{"type": "ratelimit-global", "config": {"max_parallel_connections": 1}, "on_drop": "icmp"}
+	]`), &configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := BuildChain(configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	res := chain.withPolicy(1, Result{Action: Drop})
+	if res.Policy == nil || res.Policy.Action != DropICMP {
+		t.Errorf("policy = %+v, want icmp", res.Policy)
+	}
+	if res := chain.withPolicy(0, Result{Action: Drop}); res.Policy != nil {
+		t.Errorf("policy = %+v, want nil", res.Policy)
+	}
+	if res := chain.withPolicy(1, Result{Action: Handled}); res.Policy != nil {
+		t.Error("policy attached to non-drop result")
+	}
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+)
+
+var failoverLog = logging.ForHandler("failover")
+
+const (
+	// TierKey holds the backend tier of a route with secondaries that served
+	// the connection: "primary", "secondary", or "trial" for a session sent
+	// to failed primaries to test whether they recovered.
+	TierKey = "tier"
+
+	failoverStateKey = "failover.state"
+)
+
+// failoverConfig tunes when a route leaves its primary backends and when it
+// returns to them.
+type failoverConfig struct {
+	FailThreshold int `json:"fail_threshold,omitempty"` // Consecutive failed sessions on the primaries before failing over (default: 3)
+	RetryInterval int `json:"retry_interval,omitempty"` // Seconds between trial sessions sent to the primaries while failed over (default: 10)
+	FailbackDelay int `json:"failback_delay,omitempty"` // Seconds the primaries must keep answering trials before failing back (default: 60)
+}
+
+// failover sends a route's connections to its primary backends, moves them
+// to the secondaries after consecutive failed sessions and moves them back
+// once trial sessions found the primaries answering for failbackDelay.
+type failover struct {
+	name          string // Route label for logs
+	primary       *backendPool
+	secondary     *backendPool
+	failThreshold int
+	retry         time.Duration
+	failbackDelay time.Duration
+	now           func() time.Time
+
+	mu         sync.Mutex
+	failedOver bool
+	failures   int       // Consecutive failed primary sessions
+	nextTrial  time.Time // When the next trial session may go to the primaries
+	recovering time.Time // First answered trial since the last failed one, zero if none
+}
+
+// failoverSession tracks whether a session of a failover route got an answer.
+type failoverSession struct {
+	f         *failover
+	tier      string
+	responded atomic.Bool
+}
+
+func newFailover(primary *backendPool, secondaries []backendEntry, cfg *failoverConfig) (*failover, error) {
+	secondary, err := newBackendPool(secondaries)
+	if err != nil {
+		return nil, err
+	}
+	if cfg == nil {
+		cfg = &failoverConfig{}
+	}
+	if cfg.FailThreshold < 0 || cfg.RetryInterval < 0 || cfg.FailbackDelay < 0 {
+		return nil, errors.New("failover 'fail_threshold', 'retry_interval' and 'failback_delay' must be >= 0")
+	}
+	f := &failover{
+		primary:       primary,
+		secondary:     secondary,
+		failThreshold: cfg.FailThreshold,
+		retry:         time.Duration(cfg.RetryInterval) * time.Second,
+		failbackDelay: time.Duration(cfg.FailbackDelay) * time.Second,
+		now:           time.Now,
+	}
+	if f.failThreshold == 0 {
+		f.failThreshold = 3
+	}
+	if f.retry == 0 {
+		f.retry = 10 * time.Second
+	}
+	if f.failbackDelay == 0 {
+		f.failbackDelay = 60 * time.Second
+	}
+	return f, nil
+}
+
+// tier returns the tier the next new connection goes to. While failed over,
+// one connection per retry interval is a trial of the primaries.
+func (f *failover) tier() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.failedOver {
+		return "primary"
+	}
+	if now := f.now(); !now.Before(f.nextTrial) {
+		f.nextTrial = now.Add(f.retry)
+		return "trial"
+	}
+	return "secondary"
+}
+
+// pick selects a backend of the current tier. Connections also go to the
+// secondaries while every primary is draining or full.
+func (f *failover) pick(ctx *Context) (string, error) {
+	tier := f.tier()
+	if tier != "secondary" && !allDraining(f.primary) {
+		if backend, err := f.primary.pickFor(ctx); err == nil {
+			f.begin(ctx, tier)
+			return backend, nil
+		}
+	}
+	backend, err := f.secondary.pickFor(ctx)
+	if err != nil {
+		return "", err
+	}
+	f.begin(ctx, "secondary")
+	return backend, nil
+}
+
+// resume keeps a resumed backend when it belongs to the current tier and is
+// not full. Trials are left to new connections.
+func (f *failover) resume(ctx *Context, backend string) (string, bool) {
+	f.mu.Lock()
+	failedOver := f.failedOver
+	f.mu.Unlock()
+
+	pool, tier := f.primary, "primary"
+	if failedOver {
+		pool, tier = f.secondary, "secondary"
+	}
+	if !pool.contains(backend) || !pool.reserveAddr(ctx, backend) {
+		return "", false
+	}
+	f.begin(ctx, tier)
+	return backend, true
+}
+
+// begin records the tier of a connection on ctx.
+func (f *failover) begin(ctx *Context, tier string) {
+	ctx.Set(TierKey, tier)
+	ctx.Set(failoverStateKey, &failoverSession{f: f, tier: tier})
+}
+
+// allDraining reports whether every backend of p is draining.
+func allDraining(p *backendPool) bool {
+	for _, addr := range p.addrs {
+		if !Draining(addr) {
+			return false
+		}
+	}
+	return true
+}
+
+// observeFailover watches backend responses of a failover route's session.
+// Called by routers in OnConnect after a backend was picked.
+func observeFailover(ctx *Context) {
+	state, ok := GetValue[*failoverSession](ctx, failoverStateKey)
+	if !ok {
+		return
+	}
+	next := ctx.OnServerPacket
+	ctx.OnServerPacket = func(packet []byte) {
+		if !state.responded.Swap(true) && state.tier == "trial" {
+			state.f.trialAnswered()
+		}
+		if next != nil {
+			next(packet)
+		}
+	}
+}
+
+// trialAnswered fails back once the primaries have answered every trial
+// for failbackDelay.
+func (f *failover) trialAnswered() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.failedOver {
+		return
+	}
+	now := f.now()
+	if f.recovering.IsZero() {
+		f.recovering = now
+	}
+	if now.Sub(f.recovering) >= f.failbackDelay {
+		f.failedOver = false
+		f.failures = 0
+		f.recovering = time.Time{}
+		failoverLog.Printf("%s: primaries answered for %v, failing back", f.name, f.failbackDelay)
+	}
+}
+
+// reportFailover updates the health of a route's primaries when one of its
+// sessions ends. Sessions that failed on the backend side or timed out
+// without hearing from the backend count as failures; sessions ended by
+// handlers or operators are ignored.
+func reportFailover(ctx *Context) {
+	state, ok := GetValue[*failoverSession](ctx, failoverStateKey)
+	if !ok || state.tier == "secondary" {
+		return
+	}
+	reason := ctx.CloseReason()
+	switch {
+	case reason == CloseBackendError:
+	case reason == CloseIdle && !state.responded.Load():
+	case state.responded.Load():
+		if state.tier == "primary" {
+			state.f.mu.Lock()
+			state.f.failures = 0
+			state.f.mu.Unlock()
+		}
+		return
+	default:
+		return
+	}
+	state.f.failed(state.tier)
+}
+
+// failed counts a failed session of tier.
+func (f *failover) failed(tier string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if tier == "trial" {
+		if f.failedOver && !f.recovering.IsZero() {
+			failoverLog.Printf("%s: trial session on primaries failed, staying on secondaries", f.name)
+		}
+		f.recovering = time.Time{}
+		return
+	}
+	if f.failedOver {
+		return
+	}
+	f.failures++
+	if f.failures >= f.failThreshold {
+		f.failedOver = true
+		f.failures = 0
+		f.recovering = time.Time{}
+		f.nextTrial = f.now().Add(f.retry)
+		failoverLog.Warnf("%s: failing over to secondaries after %d failed sessions", f.name, f.failThreshold)
+	}
+}
@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDynamicHandler_Failover(t *testing.T) {
+	config := `{"routes": {"play.com": {
+		"backends": ["primary:5520"],
+		"secondaries": ["secondary:5520"],
+		"failover": {"fail_threshold": 2, "retry_interval": 10, "failback_delay": 30}
+	}}}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	h.(*DynamicHandler).routes["play.com"].failover.now = func() time.Time { return now }
+
+	connect := func() *Context {
+		t.Helper()
+		ctx := &Context{
+			ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000},
+			Hello:      &ClientHello{SNI: "play.com"},
+		}
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("OnConnect: %v", res.Error)
+		}
+		return ctx
+	}
+	expect := func(ctx *Context, backend, tier string) {
+		t.Helper()
+		if got := ctx.GetString("backend"); got != backend {
+			t.Errorf("backend = %q, want %s", got, backend)
+		}
+		if got := ctx.GetString(TierKey); got != tier {
+			t.Errorf("tier = %q, want %s", got, tier)
+		}
+	}
+	fail := func(ctx *Context) {
+		ctx.SetCloseReason(CloseIdle)
+		h.OnDisconnect(ctx)
+	}
+
+	ctx := connect()
+	expect(ctx, "primary:5520", "primary")
+
+	// Two sessions without a backend answer fail over
+	fail(ctx)
+	fail(connect())
+	expect(connect(), "secondary:5520", "secondary")
+
+	// A trial goes to the primaries once per retry interval
+	now = now.Add(10 * time.Second)
+	trial := connect()
+	expect(trial, "primary:5520", "trial")
+	expect(connect(), "secondary:5520", "secondary")
+	trial.OnServerPacket([]byte{0xc0})
+
+	// A failed trial restarts the stabilization delay
+	now = now.Add(10 * time.Second)
+	fail(connect())
+	now = now.Add(10 * time.Second)
+	connect().OnServerPacket([]byte{0xc0})
+	now = now.Add(20 * time.Second)
+	connect().OnServerPacket([]byte{0xc0})
+	expect(connect(), "secondary:5520", "secondary")
+
+	// Fails back once trials were answered for failback_delay
+	now = now.Add(10 * time.Second)
+	connect().OnServerPacket([]byte{0xc0})
+	expect(connect(), "primary:5520", "primary")
+}
+
+func TestDynamicHandler_FailoverDraining(t *testing.T) {
+	config := `{"routes": {"play.com": {"backends": ["drain-primary:5520"], "secondaries": ["drain-secondary:5520"]}}}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	DrainBackend("drain-primary:5520", time.Time{})
+	defer UndrainBackend("drain-primary:5520")
+
+	ctx := &Context{Hello: &ClientHello{SNI: "play.com"}}
+	h.OnConnect(ctx)
+	if got := ctx.GetString("backend"); got != "drain-secondary:5520" {
+		t.Errorf("backend = %q, want drain-secondary:5520 while the primary drains", got)
+	}
+}
+
+func TestFailover_ConfigErrors(t *testing.T) {
+	tests := []struct {
+		route   string
+		wantErr string
+	}{
+		{`{"backends": ["a:1"], "failover": {}}`, "'failover' requires 'secondaries'"},
+		{`{"regions": {"eu": ["a:1"]}, "secondaries": ["b:1"]}`, "'secondaries' requires 'backends'"},
+		{`{"backends": ["a:1"], "secondaries": ["b:1"], "failover": {"failback_delay": -1}}`, "must be >= 0"},
+	}
+	for _, tt := range tests {
+		_, err := NewDynamicHandler(json.RawMessage(`{"routes": {"a.com": ` + tt.route + `}}`))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("route %s: error = %v, want %q", tt.route, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"maps"
+	"math"
+	"net"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// FeaturesKey is the context key holding the feature flags on for a
+// connection ([]string, sorted). Session listings and snapshots show it.
+const FeaturesKey = "features"
+
+// featureBuckets is the resolution of rollouts: 0.01 percent.
+const featureBuckets = 10000
+
+// FeatureFlag is the rollout of a feature.
+type FeatureFlag struct {
+	Percent float64 `json:"percent"` // Share of new connections the feature is on for, 0 to 100
+}
+
+// FeatureConfig holds the flags of the config file by feature name.
+type FeatureConfig map[string]FeatureFlag
+
+// FeatureFlagStatus is a feature flag as the admin API shows it.
+type FeatureFlagStatus struct {
+	Name       string   `json:"name"`
+	Percent    float64  `json:"percent"`              // Share in effect
+	Configured *float64 `json:"configured,omitempty"` // Share of the config file
+	Override   *float64 `json:"override,omitempty"`   // Share set via the admin API, wins over the config
+	On         uint64   `json:"connections_on"`
+	Off        uint64   `json:"connections_off"`
+}
+
+// featureFlag is the state of one flag. Counters survive config reloads and
+// overrides.
+type featureFlag struct {
+	name       string
+	configured *float64 // Guarded by featureFlagsMu
+	override   *float64 // Guarded by featureFlagsMu
+	buckets    atomic.Int64
+	on, off    atomic.Uint64
+}
+
+func (f *featureFlag) status() FeatureFlagStatus {
+	return FeatureFlagStatus{
+		Name:       f.name,
+		Percent:    float64(f.buckets.Load()) * 100 / featureBuckets,
+		Configured: f.configured,
+		Override:   f.override,
+		On:         f.on.Load(),
+		Off:        f.off.Load(),
+	}
+}
+
+// featureFlags is package-level so flags and their overrides survive handler
+// chain reloads. Copy-on-write: new connections only load the map.
+var (
+	featureFlags   atomic.Pointer[map[string]*featureFlag]
+	featureFlagsMu sync.Mutex
+)
+
+// SetFeatureFlags replaces the flags of the config file. Overrides set via
+// the admin API stay in effect.
+func SetFeatureFlags(cfg FeatureConfig) error {
+	apply, err := PrepareFeatureFlags(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareFeatureFlags validates cfg without changing the flags; apply then
+// does what SetFeatureFlags does.
+func PrepareFeatureFlags(cfg FeatureConfig) (apply func(), err error) {
+	for name, f := range cfg {
+		if err := checkFeature(name, f); err != nil {
+			return nil, err
+		}
+	}
+	return func() {
+		updateFeatureFlags(func(m map[string]*featureFlag) {
+			for name, f := range m {
+				f.configured = nil
+				if f.override == nil {
+					delete(m, name)
+				}
+			}
+			for name, c := range cfg {
+				f := featureFlagFor(m, name)
+				f.configured = &c.Percent
+			}
+		})
+	}, nil
+}
+
+// SetFeatureOverride sets the share of a feature until the override is
+// cleared, whatever the config file says. A share of 0 rolls it back.
+func SetFeatureOverride(name string, cfg FeatureFlag) (FeatureFlagStatus, error) {
+	if err := checkFeature(name, cfg); err != nil {
+		return FeatureFlagStatus{}, err
+	}
+	var st FeatureFlagStatus
+	updateFeatureFlags(func(m map[string]*featureFlag) {
+		f := featureFlagFor(m, name)
+		f.override = &cfg.Percent
+		f.apply()
+		st = f.status()
+	})
+	return st, nil
+}
+
+// ClearFeatureOverride returns a feature to its configured share, or removes
+// it if it has none. Returns false if it had no override.
+func ClearFeatureOverride(name string) bool {
+	found := false
+	updateFeatureFlags(func(m map[string]*featureFlag) {
+		f, ok := m[name]
+		if !ok || f.override == nil {
+			return
+		}
+		found = true
+		f.override = nil
+		if f.configured == nil {
+			delete(m, name)
+		}
+	})
+	return found
+}
+
+// FeatureFlags returns the flags, sorted by name.
+func FeatureFlags() []FeatureFlagStatus {
+	featureFlagsMu.Lock()
+	defer featureFlagsMu.Unlock()
+	list := []FeatureFlagStatus{}
+	if m := featureFlags.Load(); m != nil {
+		for _, name := range slices.Sorted(maps.Keys(*m)) {
+			list = append(list, (*m)[name].status())
+		}
+	}
+	return list
+}
+
+// checkFeature validates a flag name and share.
+func checkFeature(name string, f FeatureFlag) error {
+	if name == "" {
+		return errors.New("feature name is required")
+	}
+	if strings.IndexFunc(name, func(r rune) bool {
+		return !('a' <= r && r <= 'z' || '0' <= r && r <= '9' || r == '-' || r == '_' || r == '.')
+	}) >= 0 {
+		return fmt.Errorf("feature %q: names may only contain a-z, 0-9, '-', '_' and '.'", name)
+	}
+	if f.Percent < 0 || f.Percent > 100 {
+		return fmt.Errorf("feature %s: percent must be between 0 and 100", name)
+	}
+	return nil
+}
+
+// updateFeatureFlags applies fn to a copy of the flags, then recomputes the
+// share in effect of each.
+func updateFeatureFlags(fn func(map[string]*featureFlag)) {
+	featureFlagsMu.Lock()
+	defer featureFlagsMu.Unlock()
+	m := make(map[string]*featureFlag)
+	if old := featureFlags.Load(); old != nil {
+		maps.Copy(m, *old)
+	}
+	fn(m)
+	for _, f := range m {
+		f.apply()
+	}
+	featureFlags.Store(&m)
+}
+
+// featureFlagFor returns the flag name of m, adding it if needed.
+// featureFlagsMu must be held.
+func featureFlagFor(m map[string]*featureFlag, name string) *featureFlag {
+	f, ok := m[name]
+	if !ok {
+		f = &featureFlag{name: name}
+		m[name] = f
+	}
+	return f
+}
+
+// apply sets the share in effect. featureFlagsMu must be held.
+func (f *featureFlag) apply() {
+	percent := 0.0
+	switch {
+	case f.override != nil:
+		percent = *f.override
+	case f.configured != nil:
+		percent = *f.configured
+	}
+	f.buckets.Store(int64(math.Round(percent * featureBuckets / 100)))
+}
+
+// featureBucket places a client in one of featureBuckets buckets of a
+// feature. Clients are bucketed by IPv4 address or IPv6 /64, so they get the
+// same decision when they reconnect, and raising the share only adds clients.
+func featureBucket(name string, addr *net.UDPAddr) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	if addr != nil {
+		src := honeypotSource(addr.AddrPort().Addr())
+		h.Write([]byte(src.String()))
+	}
+	return int64(h.Sum64() % featureBuckets)
+}
+
+// AssignFeatures decides which features are on for a new connection and
+// stores them in ctx. The decision holds for the life of the session: rolling
+// a feature back only affects new connections.
+func AssignFeatures(ctx *Context) {
+	p := featureFlags.Load()
+	if p == nil || len(*p) == 0 {
+		return
+	}
+	addr := ctx.OriginalClientAddr()
+	var on []string
+	for name, f := range *p {
+		if featureBucket(name, addr) < f.buckets.Load() {
+			f.on.Add(1)
+			on = append(on, name)
+		} else {
+			f.off.Add(1)
+		}
+	}
+	if len(on) > 0 {
+		slices.Sort(on)
+		ctx.Set(FeaturesKey, on)
+	}
+}
+
+// Features returns the features on for ctx, sorted.
+func Features(ctx *Context) []string {
+	on, _ := GetValue[[]string](ctx, FeaturesKey)
+	return on
+}
+
+// FeatureEnabled reports whether feature name is on for ctx's connection.
+func FeatureEnabled(ctx *Context, name string) bool {
+	return slices.Contains(Features(ctx), name)
+}
+
+// featureHandler runs a handler configured with "feature" only for the
+// connections the feature is on for. The chain continues past it for others.
+type featureHandler struct {
+	Handler
+	feature string
+}
+
+// newFeatureHandler wraps h so it only sees connections with feature on.
+func newFeatureHandler(h Handler, feature string) *featureHandler {
+	return &featureHandler{Handler: h, feature: feature}
+}
+
+// OnConnect runs the handler if the feature is on for ctx.
+func (f *featureHandler) OnConnect(ctx *Context) Result {
+	if !FeatureEnabled(ctx, f.feature) {
+		return Result{Action: Continue}
+	}
+	return f.Handler.OnConnect(ctx)
+}
+
+// OnPacket runs the handler if the feature is on for ctx.
+func (f *featureHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	if !FeatureEnabled(ctx, f.feature) {
+		return Result{Action: Continue}
+	}
+	return f.Handler.OnPacket(ctx, packet, dir)
+}
+
+// OnDisconnect runs the handler if the feature is on for ctx.
+func (f *featureHandler) OnDisconnect(ctx *Context) {
+	if FeatureEnabled(ctx, f.feature) {
+		f.Handler.OnDisconnect(ctx)
+	}
+}
+
+// OnDatagram offers the datagram to the handler if the feature is on for the
+// client at the current share.
+func (f *featureHandler) OnDatagram(clientAddr *net.UDPAddr, packet []byte, reply func([]byte) error) bool {
+	dh, ok := f.Handler.(DatagramHandler)
+	if !ok {
+		return false
+	}
+	p := featureFlags.Load()
+	if p == nil {
+		return false
+	}
+	flag, ok := (*p)[f.feature]
+	if !ok || featureBucket(f.feature, clientAddr) >= flag.buckets.Load() {
+		return false
+	}
+	return dh.OnDatagram(clientAddr, packet, reply)
+}
+
+// Restore restores the session through the handler if the feature was on
+// for it.
+func (f *featureHandler) Restore(ctx *Context, id uint64) Result {
+	r, ok := f.Handler.(Restorer)
+	if !ok || !FeatureEnabled(ctx, f.feature) {
+		return Result{Action: Continue}
+	}
+	return r.Restore(ctx, id)
+}
+
+// CancelConnect forwards to the handler if the feature is on for ctx.
+func (f *featureHandler) CancelConnect(ctx *Context) {
+	if cc, ok := f.Handler.(ConnectCanceler); ok && FeatureEnabled(ctx, f.feature) {
+		cc.CancelConnect(ctx)
+	}
+}
+
+// Close forwards to the handler.
+func (f *featureHandler) Close() error {
+	if cl, ok := f.Handler.(Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package handler
+
+import (
+	"net"
+	"slices"
+	"testing"
+)
+
+func setFeatureFlags(t *testing.T, cfg FeatureConfig) {
+	t.Helper()
+	if err := SetFeatureFlags(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		for _, f := range FeatureFlags() {
+			ClearFeatureOverride(f.Name)
+		}
+		SetFeatureFlags(nil)
+	})
+}
+
+func featureCtx(i int) *Context {
+	return &Context{ClientAddr: &net.UDPAddr{IP: net.IPv4(10, byte(i>>16), byte(i>>8), byte(i)), Port: 40000}}
+}
+
+func TestAssignFeatures(t *testing.T) {
+	setFeatureFlags(t, FeatureConfig{"half": {Percent: 50}, "all": {Percent: 100}, "none": {}})
+
+	const n = 4000
+	half := 0
+	for i := range n {
+		ctx := featureCtx(i)
+		AssignFeatures(ctx)
+		if !FeatureEnabled(ctx, "all") || FeatureEnabled(ctx, "none") {
+			t.Fatalf("features = %v", Features(ctx))
+		}
+		if FeatureEnabled(ctx, "half") {
+			half++
+		}
+	}
+	if half < n*45/100 || half > n*55/100 {
+		t.Errorf("half on for %d of %d connections", half, n)
+	}
+
+	// A client gets the same decision on every connection
+	for i := range 50 {
+		a, b := featureCtx(i), featureCtx(i)
+		b.ClientAddr.Port = 50000
+		AssignFeatures(a)
+		AssignFeatures(b)
+		if !slices.Equal(Features(a), Features(b)) {
+			t.Fatalf("client %d: %v, then %v", i, Features(a), Features(b))
+		}
+	}
+
+	for _, f := range FeatureFlags() {
+		if f.Name == "half" && (f.On+f.Off != n+100 || f.Percent != 50) {
+			t.Errorf("status = %+v", f)
+		}
+	}
+}
+
+func TestFeatureOverride(t *testing.T) {
+	setFeatureFlags(t, FeatureConfig{"gso": {Percent: 100}})
+
+	st, err := SetFeatureOverride("gso", FeatureFlag{Percent: 0})
+	if err != nil || st.Percent != 0 || *st.Configured != 100 || *st.Override != 0 {
+		t.Fatalf("override: %+v, %v", st, err)
+	}
+	ctx := featureCtx(1)
+	AssignFeatures(ctx)
+	if FeatureEnabled(ctx, "gso") {
+		t.Error("rolled back feature on")
+	}
+
+	// The override survives a reload of the config
+	if err := SetFeatureFlags(FeatureConfig{"gso": {Percent: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	if f := FeatureFlags(); len(f) != 1 || f[0].Percent != 0 {
+		t.Errorf("after reload: %+v", f)
+	}
+	if !ClearFeatureOverride("gso") || ClearFeatureOverride("gso") {
+		t.Error("ClearFeatureOverride")
+	}
+	if f := FeatureFlags(); len(f) != 1 || f[0].Percent != 100 || f[0].Override != nil {
+		t.Errorf("after clear: %+v", f)
+	}
+
+	// Features only the admin API set go away with their override
+	if _, err := SetFeatureOverride("new-parser", FeatureFlag{Percent: 5}); err != nil {
+		t.Fatal(err)
+	}
+	ClearFeatureOverride("new-parser")
+	if f := FeatureFlags(); len(f) != 1 {
+		t.Errorf("flags = %+v", f)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		percent float64
+	}{{"", 1}, {"GSO", 1}, {"a b", 1}, {"gso", -1}, {"gso", 101}} {
+		if _, err := SetFeatureOverride(tt.name, FeatureFlag{Percent: tt.percent}); err == nil {
+			t.Errorf("%q at %g%% accepted", tt.name, tt.percent)
+		}
+	}
+}
+
+func TestFeatureHandler(t *testing.T) {
+	setFeatureFlags(t, FeatureConfig{"new-acl": {Percent: 30}})
+	chain := buildShadowChain(t, `[
+		{"type": "sni-router", "config": {"routes": {"a.example.com": "10.0.0.1:5520"}}},
+		{"type": "sni-router", "feature": "new-acl", "enforce": false, "config": {"routes": {"a.example.com": "10.0.0.3:5520"}}},
+		{"type": "sni-router", "feature": "new-acl", "config": {"routes": {"a.example.com": "10.0.0.2:5520"}}}
+	]`)
+	if got := DisplayName(chain.Handlers()[1]); got != "sni-router (shadow) (feature new-acl)" {
+		t.Errorf("DisplayName = %q", got)
+	}
+	if _, ok := Unwrap(chain.Handlers()[1]).(*DynamicHandler); !ok {
+		t.Errorf("Unwrap = %T", Unwrap(chain.Handlers()[1]))
+	}
+
+	backends := map[string]int{}
+	for i := range 200 {
+		ctx := featureCtx(i)
+		ctx.Hello = &ClientHello{SNI: "a.example.com"}
+		AssignFeatures(ctx)
+		for _, h := range chain.Handlers() {
+			if h.OnConnect(ctx).Action != Continue {
+				break
+			}
+		}
+		backend := ctx.GetString("backend")
+		if (backend == "10.0.0.2:5520") != FeatureEnabled(ctx, "new-acl") {
+			t.Fatalf("features %v routed to %s", Features(ctx), backend)
+		}
+		backends[backend]++
+	}
+	if backends["10.0.0.2:5520"] == 0 || backends["10.0.0.1:5520"] == 0 {
+		t.Errorf("backends = %v", backends)
+	}
+
+	if _, err := BuildChain([]HandlerConfig{{Type: "sni-router", Feature: "New ACL"}}); err == nil {
+		t.Error("invalid feature name accepted")
+	}
+}
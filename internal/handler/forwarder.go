@@ -1,28 +1,79 @@
 package handler
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
 	"net"
+	"net/netip"
+	"os"
 	"sync/atomic"
 	"time"
 
 	"quic-relay/internal/debug"
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+	"quic-relay/internal/timerwheel"
 )
 
+var forwarderLog = logging.For("forwarder")
+
+// errNoSession drops packets of connections the forwarder has no session for.
+// It is shared so the packet path does not allocate one per packet.
+var errNoSession = errors.New("no session")
+
 func init() {
 	Register("forwarder", NewForwarderHandler)
 }
 
+// ForwarderConfig is the configuration for the forwarder handler.
+type ForwarderConfig struct {
+	NodeID           string          `json:"node_id,omitempty"`           // Identifies this relay to relay:// backends (default: hostname)
+	Overload         OverloadConfig  `json:"overload,omitempty"`          // Client queue limits
+	BackendMigration *bool           `json:"backend_migration,omitempty"` // Follow QUIC backends that send from a new address (default: true)
+	KeepAlive        KeepAliveConfig `json:"keepalive,omitempty"`         // Keep-alives to clients of idle sessions
+
+	DatagramLimitConfig // max_datagram and oversize, overridden per route by routers
+}
+
 // ForwarderHandler handles UDP packet forwarding between clients and backends.
 type ForwarderHandler struct {
 	sessionCounter atomic.Uint64
+	nodeID         string
+	overload       OverloadConfig
+	migration      bool
+	keepalive      KeepAliveConfig
+	limits         DatagramLimitConfig
 }
 
 // NewForwarderHandler creates a new forwarder handler.
-func NewForwarderHandler(_ json.RawMessage) (Handler, error) {
-	return &ForwarderHandler{}, nil
+func NewForwarderHandler(raw json.RawMessage) (Handler, error) {
+	var cfg ForwarderConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid forwarder config: %w", err)
+		}
+	}
+	if cfg.NodeID == "" {
+		cfg.NodeID, _ = os.Hostname()
+	}
+	if err := cfg.Overload.validate(); err != nil {
+		return nil, fmt.Errorf("invalid forwarder config: %w", err)
+	}
+	if err := cfg.KeepAlive.validate(); err != nil {
+		return nil, fmt.Errorf("invalid forwarder config: %w", err)
+	}
+	if err := cfg.DatagramLimitConfig.validate(); err != nil {
+		return nil, fmt.Errorf("invalid forwarder config: %w", err)
+	}
+	return &ForwarderHandler{
+		nodeID:    cfg.NodeID,
+		overload:  cfg.Overload,
+		migration: cfg.BackendMigration == nil || *cfg.BackendMigration,
+		keepalive: cfg.KeepAlive,
+		limits:    cfg.DatagramLimitConfig,
+	}, nil
 }
 
 // Name returns the handler name.
@@ -32,65 +83,168 @@ func (h *ForwarderHandler) Name() string {
 
 // OnConnect establishes a UDP session to the backend.
 func (h *ForwarderHandler) OnConnect(ctx *Context) Result {
+	session, err := h.openSession(ctx, h.sessionCounter.Add(1), "")
+	if err != nil {
+		return Result{Action: Drop, Error: err}
+	}
+
+	// Forward the initial packet to backend
+	if len(ctx.InitialPacket) > 0 {
+		session.trace.Add("in", len(ctx.InitialPacket), ctx.InitialPacket[0])
+		initial, _, ok := session.limit.apply(ctx, session, ctx.InitialPacket, Inbound, 0)
+		if !ok {
+			session.closeBackend()
+			return Result{Action: Drop, Error: fmt.Errorf("initial datagram of %d bytes exceeds max_datagram", len(ctx.InitialPacket))}
+		}
+		err := h.sendBackend(ctx, session, initial)
+		if err != nil {
+			sessionLog(ctx, forwarderLog).Warnf("failed to forward initial packet: %v", err)
+			session.closeBackend()
+			return Result{Action: Drop, Error: err}
+		}
+		session.CountIn(len(initial))
+	}
+
+	countTaggedOpen(ctx)
+
+	// Clear InitialPacket to free memory (~1.4KB per session)
+	ctx.InitialPacket = nil
+
+	// Start goroutine to read from backend and send to client
+	go h.backendToClient(ctx, session)
+
+	return Result{Action: Handled}
+}
+
+// Restore re-establishes a session saved before a restart. The backend is dialed
+// from a new local port, which QUIC backends treat as a client address change.
+// Backend packets are held back until the client confirms the session.
+func (h *ForwarderHandler) Restore(ctx *Context, id uint64) Result {
+	// Keep new session IDs above restored ones
+	for {
+		cur := h.sessionCounter.Load()
+		if cur >= id || h.sessionCounter.CompareAndSwap(cur, id) {
+			break
+		}
+	}
+
+	session, err := h.openSession(ctx, id, " (restored)")
+	if err != nil {
+		return Result{Action: Drop, Error: err}
+	}
+	session.unconfirmed.Store(true)
+	go h.backendToClient(ctx, session)
+	return Result{Action: Handled}
+}
+
+// openSession dials the backend set by the router and attaches a new session to ctx.
+func (h *ForwarderHandler) openSession(ctx *Context, id uint64, note string) (*Session, error) {
 	// Get backend from context (set by router handler)
 	backend := ctx.GetString("backend")
 	if backend == "" {
-		return Result{Action: Drop, Error: errors.New("no backend address")}
+		return nil, errors.New("no backend address")
 	}
 
-	// Resolve backend address
-	backendAddr, err := net.ResolveUDPAddr("udp", backend)
-	if err != nil {
-		return Result{Action: Drop, Error: err}
+	// Resolve backend address. Behind an upstream proxy, the proxy resolves
+	// names and only literal addresses are known here.
+	backend, isRelay := ParseRelayBackend(backend)
+	up, _ := GetValue[*upstream](ctx, UpstreamKey)
+	var (
+		backendAddr *net.UDPAddr
+		tunnel      *upstreamTunnel
+		err         error
+	)
+	if up != nil {
+		if tunnel, err = up.open(backend); err != nil {
+			return nil, err
+		}
+		if ap, err := netip.ParseAddrPort(backend); err == nil {
+			backendAddr = net.UDPAddrFromAddrPort(ap)
+		}
+		note += " via " + up.typ + " " + up.addr
+	} else if backendAddr, err = net.ResolveUDPAddr("udp", backend); err != nil {
+		return nil, err
 	}
 
-	// Create UDP connection to backend
-	backendConn, err := net.DialUDP("udp", nil, backendAddr)
+	// Create UDP connection to backend. QUIC sessions use an unconnected
+	// socket so packets from a backend that moved to a new address still arrive.
+	migrates := h.migration && tunnel == nil && !isRelay && ctx.Protocol == "" && backendAddr.IP != nil
+	var backendConn *net.UDPConn
+	switch {
+	case migrates:
+		backendConn, err = net.ListenUDP(backendNetwork(backendAddr), nil)
+	case tunnel != nil:
+		backendConn, err = net.DialUDP("udp", nil, tunnel.Addr)
+	default:
+		backendConn, err = net.DialUDP("udp", nil, backendAddr)
+	}
 	if err != nil {
-		return Result{Action: Drop, Error: err}
+		if tunnel != nil {
+			tunnel.Close()
+		}
+		return nil, err
 	}
+	applyBackendBuffers(backendConn)
 
 	// Create session
 	now := time.Now()
 	session := &Session{
-		ID:          h.sessionCounter.Add(1),
-		BackendAddr: backendAddr,
+		ID:          id,
 		BackendConn: backendConn,
 		CreatedAt:   now,
+		migrates:    migrates,
+		trace:       debug.NewRecorder(sessionTraceEvents),
+		limit:       h.limits.limit(ctx),
+	}
+	if migrates {
+		session.clientCID = initialSCID(ctx.InitialPacket)
+	}
+	if tunnel != nil {
+		session.upstream = tunnel
+		tunnel.attach(backendConn.LocalAddr().(*net.UDPAddr))
 	}
+	session.SetBackendAddr(backendAddr)
 	session.SetClientAddr(ctx.ClientAddr)
 	session.LastActivity.Store(now.Unix())
-	ctx.Session = session
-
-	log.Printf("[forwarder] session=%d %s -> %s", session.ID, ctx.ClientAddr, backend)
-
-	// Forward the initial packet to backend
-	if len(ctx.InitialPacket) > 0 {
-		_, err := backendConn.Write(ctx.InitialPacket)
-		if err != nil {
-			log.Printf("[forwarder] failed to forward initial packet: %v", err)
-			backendConn.Close()
-			return Result{Action: Drop, Error: err}
+	if isRelay {
+		session.relayHop = h.hopInfo(ctx, session)
+	}
+	session.pause.flush = func(p []byte) {
+		session.trace.Add("released", len(p), p[0])
+		if err := h.sendBackend(ctx, session, p); err != nil {
+			session.trace.Note("write_failed", err.Error())
+			sessionLog(ctx, forwarderLog).Debugf("session=%d: write to backend failed: %v", session.ID, err)
+			return
 		}
+		session.CountIn(len(p))
 	}
+	if f, ok := GetValue[*chaosFaults](ctx, chaosKey); ok {
+		session.faults = f
+	}
+	ctx.Session = session
 
-	// Clear InitialPacket to free memory (~1.4KB per session)
-	ctx.InitialPacket = nil
-
-	// Start goroutine to read from backend and send to client
-	go h.backendToClient(ctx, session)
-
-	return Result{Action: Handled}
+	if tenant := ctx.GetString(TenantKey); tenant != "" {
+		note += " tenant=" + tenant
+	}
+	note += formatTags(RouteTags(ctx))
+	if isRelay {
+		sessionLog(ctx, forwarderLog).Printf("session=%d %s -> %s (relay)%s", session.ID, privacy.Addr(ctx.OriginalClientAddr()), backend, note)
+	} else {
+		sessionLog(ctx, forwarderLog).Printf("session=%d %s -> %s%s", session.ID, privacy.Addr(ctx.ClientAddr), backend, note)
+	}
+	return session, nil
 }
 
 // OnPacket forwards packets from client to backend.
 func (h *ForwarderHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
 	if ctx.Session == nil {
-		return Result{Action: Drop, Error: errors.New("no session")}
+		return Result{Action: Drop, Error: errNoSession}
 	}
 
-	// Check if session is being closed (prevents use-after-close race)
-	if ctx.Session.IsClosed() {
+	// Check if session is being closed (prevents use-after-close race).
+	// Sessions without a backend socket belong to another handler, such as a
+	// tarpit removed by a reload.
+	if ctx.Session.IsClosed() || ctx.Session.BackendConn == nil {
 		return Result{Action: Drop}
 	}
 
@@ -99,18 +253,101 @@ func (h *ForwarderHandler) OnPacket(ctx *Context, packet []byte, dir Direction)
 
 	if dir == Inbound {
 		// Client -> Backend
-		debug.Printf(" client->backend: %d bytes, first byte: 0x%02x", len(packet), packet[0])
-		_, err := ctx.Session.BackendConn.Write(packet)
-		if err != nil {
-			log.Printf("[forwarder] write to backend failed: %v", err)
-			return Result{Action: Drop, Error: err}
+		if packetDebugging(ctx) {
+			packetDebugf(ctx, " client->backend: %d bytes, first byte: 0x%02x", len(packet), packet[0])
+		}
+		packet, wait, ok := ctx.Session.limit.apply(ctx, ctx.Session, packet, Inbound, maxPacingDelay)
+		if !ok {
+			return Result{Action: Handled}
 		}
+		if wait > 0 {
+			// Paced: the chain holds the packet, then it is sent without
+			// taking from the bandwidth cap again
+			n := len(packet)
+			return Result{Action: Delay, Delay: wait, resume: func(packet []byte) Result {
+				return h.forwardClient(ctx, packet[:n])
+			}}
+		}
+		return h.forwardClient(ctx, packet)
 	}
 	// Outbound is handled by backendToClient goroutine
 
 	return Result{Action: Handled}
 }
 
+// forwardClient sends a client packet within the session's limits to the backend.
+func (h *ForwarderHandler) forwardClient(ctx *Context, packet []byte) Result {
+	if ctx.Session.hold(packet) {
+		ctx.Session.trace.Add("held", len(packet), packet[0])
+		return Result{Action: Handled}
+	}
+	ctx.Session.trace.Add("in", len(packet), packet[0])
+	err := h.sendBackend(ctx, ctx.Session, packet)
+	if err != nil {
+		ctx.Session.trace.Note("write_failed", err.Error())
+		sessionLog(ctx, forwarderLog).Warnf("write to backend failed: %v", err)
+		return Result{Action: Drop, Error: err}
+	}
+	ctx.Session.CountIn(len(packet))
+	return Result{Action: Handled}
+}
+
+// hopInfo builds the metadata passed to a relay:// backend.
+// Metadata received from an upstream relay is passed on unchanged.
+func (h *ForwarderHandler) hopInfo(ctx *Context, session *Session) *HopInfo {
+	info := &HopInfo{
+		NodeID:     h.nodeID,
+		SessionID:  session.ID,
+		ClientAddr: ctx.OriginalClientAddr(),
+	}
+	if ctx.Hello != nil {
+		info.SNI = ctx.Hello.SNI
+	} else if ctx.Hop != nil {
+		info.SNI = ctx.Hop.SNI
+	}
+	return info
+}
+
+// sendBackend sends a client packet to the backend, twice if the chaos
+// handler duplicates it. The chaos handler drops and delays client packets
+// before they get here.
+func (h *ForwarderHandler) sendBackend(ctx *Context, session *Session, packet []byte) error {
+	if session.faults != nil {
+		if p := session.faults.profile(Inbound); p != nil && session.faults.copies(p) > 1 {
+			if err := h.writeBackend(ctx, session, packet); err != nil {
+				return err
+			}
+		}
+	}
+	return h.writeBackend(ctx, session, packet)
+}
+
+// writeBackend sends a client packet to the backend, adding a hop header for relay:// backends.
+// Metadata is repeated on every QUIC long header packet (and every non-QUIC packet)
+// so the next relay sees it even if the first datagram is lost.
+func (h *ForwarderHandler) writeBackend(ctx *Context, session *Session, packet []byte) error {
+	hop := session.relayHop
+	if hop == nil {
+		if session.migrates {
+			_, err := session.BackendConn.WriteToUDP(packet, session.BackendAddr())
+			return err
+		}
+		_, err := session.BackendConn.Write(packet)
+		return err
+	}
+
+	info := hop
+	if ctx.Protocol == "" && len(packet) > 0 && packet[0]&0x80 == 0 {
+		info = &HopInfo{SessionID: hop.SessionID} // Short header: session ID only
+	}
+	buf := GetBufferSized(len(packet) + hopMaxLen)
+	out := AppendHopHeader((*buf)[:0], info)
+	out = append(out, packet...)
+	_, err := session.BackendConn.Write(out)
+	PutBuffer(buf)
+	return err
+}
+
 // OnDisconnect cleans up the session.
 func (h *ForwarderHandler) OnDisconnect(ctx *Context) {
 	if ctx.Session != nil {
@@ -118,15 +355,194 @@ func (h *ForwarderHandler) OnDisconnect(ctx *Context) {
 		if !ctx.Session.Close() {
 			return // Already closed by another goroutine
 		}
-		log.Printf("[forwarder] closing session=%d duration=%v",
-			ctx.Session.ID, time.Since(ctx.Session.CreatedAt))
-		ctx.Session.BackendConn.Close()
+		tenant := ""
+		if t := ctx.GetString(TenantKey); t != "" {
+			tenant = " tenant=" + t
+		}
+		reason := ctx.CloseReason()
+		ctx.Session.trace.Note("close", reason.String())
+		sessionLog(ctx, forwarderLog).Printf("closing session=%d duration=%v reason=%s%s%s",
+			ctx.Session.ID, time.Since(ctx.Session.CreatedAt), reason, tenant, formatTags(RouteTags(ctx)))
+		countTaggedClose(ctx)
+		switch reason {
+		case CloseBackendError, CloseBackendReset, CloseVersionNegotiation:
+			// Attach the lead-up to the failure, which is lost without -d
+			sessionLog(ctx, forwarderLog).Warnf("session=%d trace: %s", ctx.Session.ID, ctx.Session.trace.Dump())
+		}
+		ctx.Session.closeBackend()
+	}
+}
+
+// terminalPacket reports whether a backend packet ends the QUIC connection:
+// a Version Negotiation packet or a stateless reset with a known token.
+func terminalPacket(ctx *Context, packet []byte) (CloseReason, bool) {
+	if ctx.Protocol != "" || len(packet) == 0 {
+		return CloseUnknown, false
+	}
+	if packet[0]&0x80 != 0 {
+		if len(packet) >= 5 && packet[1]|packet[2]|packet[3]|packet[4] == 0 {
+			return CloseVersionNegotiation, true
+		}
+		return CloseUnknown, false
+	}
+	if ctx.IsStatelessReset(packet) {
+		return CloseBackendReset, true
 	}
+	return CloseUnknown, false
 }
 
-// backendToClient reads packets from backend and sends to client.
+// backendIdleTimeout is how long a session may go without backend packets.
+const backendIdleTimeout = 5 * time.Minute
+
+// sessionTraceEvents is how many recent packets and events a session keeps
+// for its trace (48 bytes each).
+const sessionTraceEvents = 32
+
+// backendNetwork returns the network for an unconnected socket to addr, so
+// hosts without dual-stack sockets can still reach IPv4 backends.
+func backendNetwork(addr *net.UDPAddr) string {
+	if addr.IP.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
+
+// initialSCID returns the source connection ID of a QUIC long header packet,
+// or nil if the packet is too short.
+func initialSCID(packet []byte) []byte {
+	if len(packet) < 6 || packet[0]&0x80 == 0 {
+		return nil
+	}
+	pos := 6 + int(packet[5])
+	if len(packet) <= pos {
+		return nil
+	}
+	end := pos + 1 + int(packet[pos])
+	if len(packet) < end {
+		return nil
+	}
+	return bytes.Clone(packet[pos+1 : end])
+}
+
+// hasDCID reports whether a backend packet is addressed to the client's connection ID.
+func hasDCID(packet, cid []byte) bool {
+	if len(packet) == 0 {
+		return false
+	}
+	if packet[0]&0x80 != 0 {
+		return len(packet) >= 6+len(cid) && int(packet[5]) == len(cid) && bytes.Equal(packet[6:6+len(cid)], cid)
+	}
+	return len(cid) > 0 && len(packet) > len(cid) && bytes.Equal(packet[1:1+len(cid)], cid)
+}
+
+// backendMoveConfirm is how many consecutive packets must come from a new
+// backend address before the session follows it, so a single spoofed packet
+// cannot redirect the client's traffic.
+const backendMoveConfirm = 3
+
+// followBackend reports whether a packet received from addr belongs to the
+// session's backend. A packet from a new address is accepted when it is
+// addressed to the client's connection ID or, if that is unknown (restored
+// sessions) or empty, when it comes from the backend's host on a new port.
+// The session moves there after backendMoveConfirm such packets in a row;
+// until then client packets still go to the current address.
+func followBackend(session *Session, from *net.UDPAddr, packet []byte) bool {
+	cur := session.BackendAddr()
+	if sameAddr(from, cur) {
+		session.moveTo = nil
+		return true
+	}
+	ok := from.IP.Equal(cur.IP)
+	if len(session.clientCID) > 0 {
+		ok = hasDCID(packet, session.clientCID)
+	}
+	if !ok {
+		session.trace.Note("foreign", from.String())
+		forwarderLog.Debugf("session=%d: dropped packet from %s, backend is %s", session.ID, from, cur)
+		return false
+	}
+	if session.moveTo == nil || !sameAddr(from, session.moveTo) {
+		session.moveTo, session.moveSeen = from, 0
+	}
+	if session.moveSeen++; session.moveSeen < backendMoveConfirm {
+		return true
+	}
+	session.moveTo = nil
+	session.trace.Note("backend_moved", from.String())
+	forwarderLog.Printf("session=%d: backend moved %s -> %s", session.ID, cur, from)
+	session.SetBackendAddr(from)
+	return true
+}
+
+func sameAddr(a, b *net.UDPAddr) bool {
+	return a.Port == b.Port && a.IP.Equal(b.IP)
+}
+
+// backendTimer is a session's timer on the shared timing wheel. It sends the
+// keep-alives due and ends the backend reader once the backend has been
+// silent for backendIdleTimeout.
+type backendTimer struct {
+	h         *ForwarderHandler
+	ctx       *Context
+	session   *Session
+	timer     *timerwheel.Timer
+	seen      atomic.Int64 // Last backend packet (Unix nanoseconds)
+	keptAlive time.Time    // Last keep-alive; only used by fire
+}
+
+func (h *ForwarderHandler) startBackendTimer(ctx *Context, session *Session) *backendTimer {
+	t := &backendTimer{h: h, ctx: ctx, session: session}
+	t.seen.Store(time.Now().UnixNano())
+	t.timer = timerwheel.NewTimer(t.fire)
+	t.timer.Reset(t.next(time.Now()))
+	return t
+}
+
+// next returns how long until the timer has something to do.
+func (t *backendTimer) next(now time.Time) time.Duration {
+	wait := time.Unix(0, t.seen.Load()).Add(backendIdleTimeout).Sub(now)
+	if t.h.keepalive.enabled() {
+		due := t.h.keepalive.due(t.session, t.keptAlive).Sub(now)
+		if due <= 0 {
+			// Not sent while the session is unconfirmed
+			due = time.Duration(t.h.keepalive.Interval) * time.Second
+		}
+		wait = min(wait, due)
+	}
+	return wait
+}
+
+// fire runs on the wheel's goroutine, so a keep-alive is sent from a
+// goroutine of its own: ProxyConn may be a tunnel that blocks.
+func (t *backendTimer) fire() {
+	if t.session.IsClosed() {
+		return
+	}
+	now := time.Now()
+	if now.Sub(time.Unix(0, t.seen.Load())) >= backendIdleTimeout {
+		// Wakes the reader, which closes the session as idle
+		t.session.BackendConn.SetReadDeadline(now)
+		return
+	}
+	if t.h.keepalive.enabled() && !t.session.Unconfirmed() && !now.Before(t.h.keepalive.due(t.session, t.keptAlive)) {
+		t.session.trace.Note("keepalive", "")
+		t.keptAlive = now
+		go t.h.keepalive.send(t.ctx, t.session)
+	}
+	t.timer.Reset(t.next(now))
+}
+
+// backendToClient reads packets from backend and queues them for the client.
 // Uses buffer pool to avoid per-session 64KB allocations.
 func (h *ForwarderHandler) backendToClient(ctx *Context, session *Session) {
+	queue := newClientQueue(h.overload, session)
+	go queue.run(ctx)
+	defer queue.close()
+
+	// Sessions whose backend stays silent this long are closed as idle
+	idle := h.startBackendTimer(ctx, session)
+	defer idle.timer.Stop()
+
 	for {
 		// Check if session is closed before reading
 		if session.IsClosed() {
@@ -136,43 +552,91 @@ func (h *ForwarderHandler) backendToClient(ctx *Context, session *Session) {
 		// Get buffer from pool for this read
 		buf := GetBuffer()
 
-		// Set read deadline to detect idle connections
-		session.BackendConn.SetReadDeadline(time.Now().Add(5 * time.Minute))
-
-		n, err := session.BackendConn.Read(*buf)
+		n, from, err := session.BackendConn.ReadFromUDP(*buf)
 		if err != nil {
-			// Connection closed or timed out
+			// Connection closed, or timed out by the idle timer
 			PutBuffer(buf)
+			if !session.IsClosed() {
+				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+					ctx.DropWithReason(CloseIdle)
+				} else {
+					session.trace.Note("read_failed", err.Error())
+					sessionLog(ctx, forwarderLog).Warnf("read from backend failed: session=%d: %v", session.ID, err)
+					ctx.DropWithReason(CloseBackendError)
+				}
+			}
 			return
 		}
 
+		idle.seen.Store(time.Now().UnixNano())
+		tapBackendPacket(session, from, (*buf)[:n])
+
 		// Check again after read (session may have closed during blocking read)
 		if session.IsClosed() {
 			PutBuffer(buf)
 			return
 		}
 
+		// Sessions with an unconnected socket see packets from any address
+		if session.migrates && !followBackend(session, from, (*buf)[:n]) {
+			PutBuffer(buf)
+			continue
+		}
+
+		// Restored sessions only send to clients that have confirmed their address
+		if session.Unconfirmed() {
+			PutBuffer(buf)
+			continue
+		}
+
 		// Update activity timestamp (bidirectional tracking)
 		session.Touch()
 
+		// Backend packets do not pass the chain, which holds client packets
+		// for the bandwidth cap: those above it are dropped
+		limited, _, ok := session.limit.apply(ctx, session, (*buf)[:n], Outbound, 0)
+		if !ok {
+			PutBuffer(buf)
+			continue
+		}
+		n = len(limited)
+
+		// The backend gave up on the connection: pass the news on and stop forwarding
+		if reason, ok := terminalPacket(ctx, (*buf)[:n]); ok {
+			session.trace.Add("out", n, (*buf)[0])
+			sessionLog(ctx, forwarderLog).Printf("session=%d: backend sent %s, closing", session.ID, reason)
+			queue.push(queuedPacket{buf: buf, n: n})
+			ctx.DropWithReason(reason)
+			return
+		}
+
 		// Notify proxy of server packets to learn server's SCID(s)
 		// This enables routing subsequent client packets that use server's CID as DCID
 		ctx.NotifyServerPacket((*buf)[:n])
 
-		debug.Printf(" backend->client: %d bytes, first byte: 0x%02x", n, (*buf)[0])
+		if packetDebugging(ctx) {
+			packetDebugf(ctx, " backend->client: %d bytes, first byte: 0x%02x", n, (*buf)[0])
+		}
+		session.trace.Add("out", n, (*buf)[0])
+
+		session.CountOut(n)
 
-		// Send to client via proxy's UDP connection
-		if ctx.ProxyConn != nil {
-			_, err = ctx.ProxyConn.WriteToUDP((*buf)[:n], session.ClientAddr())
-			if err != nil {
-				log.Printf("[forwarder] write to client failed: %v", err)
-				PutBuffer(buf)
+		// Faulty packets skip the queue: delayed ones are sent after it may be closed
+		if session.faults != nil && session.faults.inject(Outbound, (*buf)[:n], func(p []byte) {
+			if session.IsClosed() {
 				return
 			}
-			debug.Printf(" sent to client %s", session.ClientAddr())
+			if _, err := ctx.ProxyConn.WriteToUDP(p, session.ClientAddr()); err != nil {
+				if packetDebugging(ctx) {
+					packetDebugf(ctx, " chaos: write to client failed: %v", err)
+				}
+			}
+		}) {
+			PutBuffer(buf)
+			continue
 		}
 
-		// Return buffer to pool after use
-		PutBuffer(buf)
+		// Hand off to the client writer; the queue owns the buffer now
+		queue.push(queuedPacket{buf: buf, n: n})
 	}
 }
@@ -0,0 +1,484 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContext_IsStatelessReset(t *testing.T) {
+	ctx := &Context{}
+	token := bytes.Repeat([]byte{0xab}, 16)
+	reset := append([]byte{0x41, 1, 2, 3, 4, 5}, token...)
+
+	if ctx.IsStatelessReset(reset) {
+		t.Error("reset recognized without tokens")
+	}
+	ctx.AddResetToken(token)
+	ctx.AddResetToken(token)
+	if n := len(*ctx.resetTokens.Load()); n != 1 {
+		t.Errorf("tokens = %d, want 1", n)
+	}
+	if !ctx.IsStatelessReset(reset) {
+		t.Error("reset not recognized")
+	}
+	if ctx.IsStatelessReset(reset[1:]) {
+		t.Error("too short packet recognized")
+	}
+	long := append([]byte{0xc1}, reset[1:]...)
+	if ctx.IsStatelessReset(long) {
+		t.Error("long header recognized as reset")
+	}
+}
+
+func TestTerminalPacket(t *testing.T) {
+	token := bytes.Repeat([]byte{0xcd}, 16)
+	ctx := &Context{}
+	ctx.AddResetToken(token)
+
+	tests := []struct {
+		name     string
+		ctx      *Context
+		packet   []byte
+		want     CloseReason
+		terminal bool
+	}{
+		{"version negotiation", ctx, []byte{0x80, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1}, CloseVersionNegotiation, true},
+		{"initial", ctx, []byte{0xc0, 0, 0, 0, 1, 0}, CloseUnknown, false},
+		{"stateless reset", ctx, append([]byte{0x40, 9, 9, 9, 9, 9}, token...), CloseBackendReset, true},
+		{"short header", ctx, append([]byte{0x40, 9, 9, 9, 9, 9}, bytes.Repeat([]byte{1}, 16)...), CloseUnknown, false},
+		{"non-QUIC flow", &Context{Protocol: "wireguard"}, []byte{0x80, 0, 0, 0, 0}, CloseUnknown, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, ok := terminalPacket(tt.ctx, tt.packet)
+			if got != tt.want || ok != tt.terminal {
+				t.Errorf("terminalPacket = %v, %v; want %v, %v", got, ok, tt.want, tt.terminal)
+			}
+		})
+	}
+}
+
+func TestForwarder_BackendVersionNegotiation(t *testing.T) {
+	vn := []byte{0x80, 0, 0, 0, 0, 1, 7, 1, 9, 0, 0, 0, 1}
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		_, addr, err := backend.ReadFromUDP(buf)
+		if err == nil {
+			backend.WriteToUDP(vn, addr)
+		}
+	}()
+
+	proxyConn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer proxyConn.Close()
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	fwd, _ := NewForwarderHandler(nil)
+	ctx := &Context{
+		ClientAddr:    client.LocalAddr().(*net.UDPAddr),
+		InitialPacket: []byte{0xc0, 0xff, 0, 0, 0x1d, 0},
+		ProxyConn:     proxyConn,
+	}
+	dropped := make(chan CloseReason, 1)
+	ctx.DropSession = func() {
+		fwd.OnDisconnect(ctx)
+		dropped <- ctx.CloseReason()
+	}
+	ctx.Set("backend", backend.LocalAddr().String())
+	if res := fwd.OnConnect(ctx); res.Action != Handled {
+		t.Fatalf("OnConnect: %v", res.Error)
+	}
+
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("version negotiation not forwarded: %v", err)
+	}
+	if !bytes.Equal(buf[:n], vn) {
+		t.Errorf("client got %x, want %x", buf[:n], vn)
+	}
+	select {
+	case reason := <-dropped:
+		if reason != CloseVersionNegotiation {
+			t.Errorf("close reason = %v, want version_negotiation", reason)
+		}
+		var kinds []string
+		for _, e := range ctx.Session.Trace() {
+			kinds = append(kinds, e.Kind)
+		}
+		if got := strings.Join(kinds, ","); got != "in,out,close" {
+			t.Errorf("trace = %s, want in,out,close", got)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("session not closed")
+	}
+}
+
+func TestInitialSCID(t *testing.T) {
+	tests := []struct {
+		name   string
+		packet []byte
+		want   []byte
+	}{
+		{"initial", []byte{0xc0, 0, 0, 0, 1, 2, 0xd1, 0xd2, 3, 0xa, 0xb, 0xc, 0}, []byte{0xa, 0xb, 0xc}},
+		{"empty scid", []byte{0xc0, 0, 0, 0, 1, 1, 0xd1, 0, 0}, []byte{}},
+		{"truncated scid", []byte{0xc0, 0, 0, 0, 1, 1, 0xd1, 4, 0xa}, nil},
+		{"short header", []byte{0x40, 1, 2, 3}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := initialSCID(tt.packet); !bytes.Equal(got, tt.want) || (got == nil) != (tt.want == nil) {
+				t.Errorf("initialSCID = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFollowBackend(t *testing.T) {
+	backend := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5520}
+	newPort := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 6000}
+	newHost := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 2), Port: 5520}
+	cid := []byte{1, 2, 3, 4}
+	toClient := []byte{0x40, 1, 2, 3, 4, 0xff, 0xff}
+	toOther := []byte{0x40, 9, 9, 9, 9, 0xff, 0xff}
+	longToClient := []byte{0xc0, 0, 0, 0, 1, 4, 1, 2, 3, 4, 0}
+
+	tests := []struct {
+		name      string
+		clientCID []byte
+		from      *net.UDPAddr
+		packet    []byte
+		want      *net.UDPAddr // Backend address afterwards, nil if the packet is dropped
+	}{
+		{"current backend", cid, backend, toOther, backend},
+		{"new host, client cid", cid, newHost, toClient, newHost},
+		{"new host, long header", cid, newHost, longToClient, newHost},
+		{"new host, other cid", cid, newHost, toOther, nil},
+		{"new port, other cid", cid, newPort, toOther, nil},
+		{"new port, cid unknown", nil, newPort, toOther, newPort},
+		{"new host, cid unknown", nil, newHost, toClient, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			session := &Session{clientCID: tt.clientCID, migrates: true}
+			session.SetBackendAddr(backend)
+			for i := range backendMoveConfirm {
+				ok := followBackend(session, tt.from, tt.packet)
+				if ok != (tt.want != nil) {
+					t.Fatalf("followBackend = %v, want %v", ok, tt.want != nil)
+				}
+				// Only the last of the packets in a row moves the session
+				want := backend
+				if ok && i == backendMoveConfirm-1 {
+					want = tt.want
+				}
+				if got := session.BackendAddr(); got.String() != want.String() {
+					t.Fatalf("backend after %d packets = %s, want %s", i+1, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFollowBackend_Confirm(t *testing.T) {
+	backend := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5520}
+	spoofed := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 66), Port: 5520}
+	toClient := []byte{0x40, 1, 2, 3, 4, 0xff, 0xff}
+	session := &Session{clientCID: []byte{1, 2, 3, 4}, migrates: true}
+	session.SetBackendAddr(backend)
+
+	// A spoofed packet is passed on, but does not move the session, and
+	// packets from the backend in between start the count again
+	for range 2 * backendMoveConfirm {
+		if !followBackend(session, spoofed, toClient) {
+			t.Fatal("packet addressed to the client dropped")
+		}
+		followBackend(session, backend, toClient)
+	}
+	if got := session.BackendAddr(); got != backend {
+		t.Fatalf("backend = %s after packets from %s", got, spoofed)
+	}
+}
+
+func TestForwarder_BackendMigration(t *testing.T) {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	backend, moved, proxyConn, client := listen(), listen(), listen(), listen()
+
+	fwd, _ := NewForwarderHandler(nil)
+	ctx := &Context{
+		ClientAddr:    client.LocalAddr().(*net.UDPAddr),
+		InitialPacket: []byte{0xc0, 0, 0, 0, 1, 1, 0xd1, 4, 1, 2, 3, 4, 0},
+		ProxyConn:     proxyConn,
+		DropSession:   func() {},
+	}
+	ctx.Set("backend", backend.LocalAddr().String())
+	if res := fwd.OnConnect(ctx); res.Action != Handled {
+		t.Fatalf("OnConnect: %v", res.Error)
+	}
+	defer fwd.OnDisconnect(ctx)
+
+	buf := make([]byte, 1500)
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	_, relayAddr, err := backend.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatalf("initial packet not forwarded: %v", err)
+	}
+
+	// The backend continues from another address
+	reply := []byte{0x40, 1, 2, 3, 4, 0xaa}
+	var n int
+	for range backendMoveConfirm {
+		moved.WriteToUDP(reply, relayAddr)
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if n, err = client.Read(buf); err != nil {
+			t.Fatalf("packet from new backend address not forwarded: %v", err)
+		}
+		if !bytes.Equal(buf[:n], reply) {
+			t.Errorf("client got %x, want %x", buf[:n], reply)
+		}
+	}
+
+	// Client packets follow the backend
+	packet := []byte{0x40, 0xd1, 0xbb}
+	if res := fwd.OnPacket(ctx, packet, Inbound); res.Action != Handled {
+		t.Fatalf("OnPacket: %v", res.Error)
+	}
+	moved.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, err = moved.Read(buf)
+	if err != nil {
+		t.Fatalf("client packet not sent to new backend address: %v", err)
+	}
+	if !bytes.Equal(buf[:n], packet) {
+		t.Errorf("backend got %x, want %x", buf[:n], packet)
+	}
+
+	// Packets for another connection from a third address are ignored
+	stranger := listen()
+	stranger.WriteToUDP([]byte{0x40, 9, 9, 9, 9}, relayAddr)
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if _, err := client.Read(buf); err == nil {
+		t.Error("packet from unrelated address forwarded to client")
+	}
+	if got := ctx.Session.BackendAddr().String(); got != moved.LocalAddr().String() {
+		t.Errorf("backend = %s, want %s", got, moved.LocalAddr())
+	}
+}
+
+func TestKeepAliveConfig_Validate(t *testing.T) {
+	tests := []struct {
+		cfg     KeepAliveConfig
+		payload []byte
+		wantErr bool
+	}{
+		{KeepAliveConfig{}, []byte{0}, false},
+		{KeepAliveConfig{Interval: 25, Payload: "c0ffee"}, []byte{0xc0, 0xff, 0xee}, false},
+		{KeepAliveConfig{Interval: -1}, nil, true},
+		{KeepAliveConfig{Interval: 25, Payload: "xyz"}, nil, true},
+		{KeepAliveConfig{Interval: 25, Payload: strings.Repeat("00", 1201)}, nil, true},
+	}
+	for _, tt := range tests {
+		err := tt.cfg.validate()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("validate(%+v) err = %v, wantErr %v", tt.cfg, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && !bytes.Equal(tt.cfg.payload, tt.payload) {
+			t.Errorf("payload = %x, want %x", tt.cfg.payload, tt.payload)
+		}
+	}
+}
+
+func TestForwarder_KeepAlive(t *testing.T) {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	backend, proxyConn, client := listen(), listen(), listen()
+
+	fwd, err := NewForwarderHandler(json.RawMessage(`{"keepalive": {"interval": 1, "payload": "aa"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{
+		ClientAddr:  client.LocalAddr().(*net.UDPAddr),
+		ProxyConn:   proxyConn,
+		DropSession: func() {},
+	}
+	ctx.Set("backend", backend.LocalAddr().String())
+	before := KeepAlivesSent()
+	if res := fwd.OnConnect(ctx); res.Action != Handled {
+		t.Fatalf("OnConnect: %v", res.Error)
+	}
+	defer fwd.OnDisconnect(ctx)
+
+	buf := make([]byte, 1500)
+	for i := range 2 {
+		client.SetReadDeadline(time.Now().Add(3 * time.Second))
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("keep-alive %d not sent: %v", i+1, err)
+		}
+		if !bytes.Equal(buf[:n], []byte{0xaa}) {
+			t.Errorf("keep-alive = %x, want aa", buf[:n])
+		}
+	}
+	if got := KeepAlivesSent() - before; got < 2 {
+		t.Errorf("keep-alives counted = %d, want 2", got)
+	}
+	if ctx.Session.Counters().PacketsOut != 0 {
+		t.Error("keep-alives counted as backend traffic")
+	}
+}
+
+func TestForwarder_MaxDatagram(t *testing.T) {
+	tests := []struct {
+		cfg     string
+		wantErr string
+	}{
+		{`{"max_datagram": 1350, "oversize": "truncate"}`, ""},
+		{`{"max_datagram": -1}`, "between 0 and"},
+		{`{"max_datagram": 1350, "oversize": "split"}`, "unknown oversize action"},
+	}
+	for _, tt := range tests {
+		_, err := NewForwarderHandler(json.RawMessage(tt.cfg))
+		if (tt.wantErr == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tt.wantErr)) {
+			t.Errorf("%s: error = %v, want %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	backend, proxyConn, client := listen(), listen(), listen()
+
+	fwd, err := NewForwarderHandler(json.RawMessage(`{"max_datagram": 4000, "oversize": "truncate"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{
+		ClientAddr:    client.LocalAddr().(*net.UDPAddr),
+		ProxyConn:     proxyConn,
+		DropSession:   func() {},
+		InitialPacket: make([]byte, 1200),
+	}
+	ctx.Set("backend", backend.LocalAddr().String())
+	ctx.Set(MaxDatagramKey, 1300) // The route's limit wins
+	before := GetOverloadStats().Oversize
+	if res := fwd.OnConnect(ctx); res.Action != Handled {
+		t.Fatalf("OnConnect: %v", res.Error)
+	}
+	defer fwd.OnDisconnect(ctx)
+
+	buf := make([]byte, 2000)
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, from, err := backend.ReadFromUDP(buf)
+	if err != nil || n != 1200 {
+		t.Fatalf("initial: %d bytes, %v", n, err)
+	}
+
+	// Oversized datagrams are truncated both ways
+	fwd.OnPacket(ctx, bytes.Repeat([]byte{0x40}, 1400), Inbound)
+	if n, err := backend.Read(buf); err != nil || n != 1300 {
+		t.Errorf("client datagram: %d bytes, %v; want 1300", n, err)
+	}
+	backend.WriteToUDP(bytes.Repeat([]byte{0x40}, 1500), from)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := client.Read(buf); err != nil || n != 1300 {
+		t.Errorf("backend datagram: %d bytes, %v; want 1300", n, err)
+	}
+	if got := GetOverloadStats().Oversize - before; got != 2 {
+		t.Errorf("oversize = %d, want 2", got)
+	}
+
+	// Dropped with the default action
+	ctx.Session.limit.truncate = false
+	fwd.OnPacket(ctx, bytes.Repeat([]byte{0x40}, 1400), Inbound)
+	fwd.OnPacket(ctx, []byte{0x40, 1}, Inbound)
+	if n, err := backend.Read(buf); err != nil || n != 2 {
+		t.Errorf("after a dropped datagram: %d bytes, %v; want 2", n, err)
+	}
+}
+
+func TestForwarder_BandwidthPacing(t *testing.T) {
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	backend, proxyConn, client := listen(), listen(), listen()
+
+	fwd, err := NewForwarderHandler(json.RawMessage(`{"bandwidth_kbps": 800}`)) // 100000 bytes per second
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := NewChain(fwd)
+	ctx := &Context{
+		ClientAddr:  client.LocalAddr().(*net.UDPAddr),
+		ProxyConn:   proxyConn,
+		DropSession: func() {},
+	}
+	ctx.Set("backend", backend.LocalAddr().String())
+	if res := chain.OnConnect(ctx); res.Action != Handled {
+		t.Fatalf("OnConnect: %v", res.Error)
+	}
+	defer chain.OnDisconnect(ctx)
+	ctx.Session.limit.bandwidth.reserve(Inbound, 100000, time.Now(), 0) // Use up the burst
+
+	// A client datagram above the cap is held by the chain, then sent
+	start := time.Now()
+	if res := chain.OnPacket(ctx, bytes.Repeat([]byte{0x40}, 1000), Inbound); res.Action != Delay || res.Delay <= 0 {
+		t.Fatalf("OnPacket = %v %v, want Delay", res.Action, res.Delay)
+	}
+	buf := make([]byte, 2000)
+	backend.SetReadDeadline(time.Now().Add(2 * time.Second))
+	if n, err := backend.Read(buf); err != nil || n != 1000 {
+		t.Fatalf("paced datagram: %d bytes, %v", n, err)
+	}
+	if elapsed := time.Since(start); elapsed < 5*time.Millisecond {
+		t.Errorf("paced datagram sent after %v", elapsed)
+	}
+
+	// One that would wait longer than maxPacingDelay is dropped
+	before := GetOverloadStats().OverBandwidth
+	if res := chain.OnPacket(ctx, make([]byte, 60000), Inbound); res.Action != Handled {
+		t.Errorf("OnPacket = %v, want Handled (dropped)", res.Action)
+	}
+	if got := GetOverloadStats().OverBandwidth - before; got != 1 {
+		t.Errorf("over_bandwidth = %d, want 1", got)
+	}
+}
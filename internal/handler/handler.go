@@ -1,5 +1,11 @@
 package handler
 
+import (
+	"net"
+	"net/http"
+	"time"
+)
+
 // Action represents the result action from a handler.
 type Action int
 
@@ -10,12 +16,43 @@ const (
 	Handled
 	// Drop discards the connection/packet.
 	Drop
+	// Redirect hands a new connection to the pipeline named in Result.Target,
+	// whose handlers then take over the connection. Only valid from OnConnect.
+	Redirect
+	// Delay holds a packet for Result.Delay, then passes it to the handlers
+	// after the delaying one. Only valid from OnPacket.
+	Delay
+	// Hold parks a new connection until the handler decides on it, without
+	// blocking the caller: Result.Hold is called with the function the
+	// handler decides with. Only valid from OnConnect.
+	Hold
 )
 
 // Result is returned by handler methods.
 type Result struct {
 	Action Action
 	Error  error
+
+	// Target is the pipeline a Redirect result hands the connection to.
+	Target string
+
+	// Delay is how long a Delay result holds the packet.
+	Delay time.Duration
+
+	// Hold parks the connection of a Hold result. The handler calls decide
+	// once, from any goroutine, with Continue or Drop; the connection then
+	// goes on through the chain. decide may be called before Hold returns.
+	Hold func(decide func(Result))
+
+	// resume runs the handlers after the one that returned Delay; set by Chain.
+	// A Delay result that already has one, from a tenant sub-chain or the
+	// forwarder pacing a packet, runs it first and goes on if it continues.
+	resume func(packet []byte) Result
+
+	// Policy is the dropping handler's on_drop policy, set by Chain for Drop results
+	// and kept on the Redirect an on_drop "redirect" turns a Drop into.
+	// Nil means a silent drop.
+	Policy *DropPolicy
 }
 
 // Direction indicates the packet flow direction.
@@ -38,6 +75,7 @@ type Handler interface {
 	// - Log and collect metrics (return Continue)
 	// - Set routing info in ctx.Values (return Continue)
 	// - Rate limit (return Drop)
+	// - Wait for capacity without blocking (return Hold)
 	// - Start forwarding (return Handled)
 	OnConnect(ctx *Context) Result
 
@@ -45,6 +83,7 @@ type Handler interface {
 	// Handlers can:
 	// - Inspect or modify packets (return Continue)
 	// - Drop packets (return Drop)
+	// - Hold packets back, e.g. to pace them (return Delay)
 	// - Forward packets (return Handled)
 	OnPacket(ctx *Context, packet []byte, dir Direction) Result
 
@@ -52,48 +91,302 @@ type Handler interface {
 	OnDisconnect(ctx *Context)
 }
 
+// AdminHandler is implemented by handlers that expose runtime controls via the admin API.
+// Requests to /handlers/<name>/... are dispatched to the first handler in the
+// active chain with that name; r.URL.Path has the "/handlers/<name>" prefix removed.
+type AdminHandler interface {
+	Handler
+	ServeAdmin(w http.ResponseWriter, r *http.Request)
+}
+
+// DatagramHandler is implemented by handlers that answer connectionless datagrams,
+// such as server list pings, without creating a session.
+// The proxy offers every packet that belongs to no session and is not a QUIC
+// Initial, Handshake or 0-RTT packet.
+type DatagramHandler interface {
+	Handler
+	// OnDatagram returns true if the datagram was consumed.
+	// reply sends a datagram back to the client from the proxy's listen address.
+	OnDatagram(clientAddr *net.UDPAddr, packet []byte, reply func([]byte) error) bool
+}
+
+// Closer is implemented by handlers that own background resources such as probers.
+// The proxy closes the handlers of a chain once it has been replaced by a reload,
+// and of the active chain on shutdown.
+type Closer interface {
+	Close() error
+}
+
+// Restorer is implemented by handlers that can re-establish a session from a
+// snapshot after a relay restart. ctx carries the saved connection metadata and
+// the "backend" value; id is the session ID before the restart.
+type Restorer interface {
+	Restore(ctx *Context, id uint64) Result
+}
+
+// ConnectCanceler is implemented by handlers that reserve resources in OnConnect,
+// such as per-tenant connection slots. OnDisconnect only runs for established
+// sessions, so CancelConnect is called instead when a later handler refuses the
+// connection or no handler takes it.
+type ConnectCanceler interface {
+	CancelConnect(ctx *Context)
+}
+
+// BackendLister is implemented by routers to list the backends they may route
+// to, for checks outside the packet path such as quic-relay doctor.
+type BackendLister interface {
+	Backends() []string
+}
+
 // Chain executes handlers in sequence.
 type Chain struct {
 	handlers []Handler
+	policies []*DropPolicy     // Per-handler on_drop policies (may be shorter than handlers)
+	timings  []*handlerTimings // Per-handler OnConnect / OnPacket latency
+	configs  []HandlerConfig   // Configs the chain was built from, nil for NewChain
+
+	// pipelines looks up the chains Redirect results hand connections to,
+	// nil in pipelines themselves and tenant sub-chains. See pipeline.go.
+	pipelines func(name string) *Chain
 }
 
 // NewChain creates a new handler chain.
 func NewChain(handlers ...Handler) *Chain {
-	return &Chain{handlers: handlers}
+	c := &Chain{handlers: handlers, timings: make([]*handlerTimings, len(handlers))}
+	for i, h := range handlers {
+		c.timings[i] = timingsFor(h.Name())
+	}
+	return c
 }
 
 // OnConnect processes a new connection through the chain.
-// Stops at the first Handled or Drop result.
+// Stops at the first Handled or Drop result. If a handler holds the
+// connection, OnConnect returns a Hold result whose decision is the chain's.
 func (c *Chain) OnConnect(ctx *Context) Result {
-	for _, h := range c.handlers {
-		result := h.OnConnect(ctx)
+	return c.connect(ctx, 0, c.connected)
+}
+
+// connected finishes OnConnect with the result of handler i.
+func (c *Chain) connected(ctx *Context, result Result, i int) Result {
+	if result.Action == Redirect {
+		return c.redirect(ctx, result, i)
+	}
+	if result.Action == Continue {
+		// No handler handled the connection
+		result = Result{Action: Drop}
+	}
+	if result.Action == Drop {
+		c.cancelConnect(ctx, i)
+	}
+	return result
+}
+
+// connect runs OnConnect of the handlers from index from until one returns
+// something other than Continue, and returns done's result for that result
+// and the handler's index, or for Continue and len(handlers). A Hold result
+// is returned right away; once the handler decided, the connection goes on
+// from there and done's result is the decision.
+func (c *Chain) connect(ctx *Context, from int, done func(*Context, Result, int) Result) Result {
+	for i := from; i < len(c.handlers); i++ {
+		start := time.Now()
+		result := c.handlers[i].OnConnect(ctx)
+		c.timings[i].connect.Observe(time.Since(start))
+		if result.Action == Hold {
+			return holdThen(result, func(r Result) Result {
+				if r.Action == Continue {
+					return c.connect(ctx, i+1, done)
+				}
+				return done(ctx, c.connectResult(i, r), i)
+			})
+		}
 		if result.Action != Continue {
-			return result
+			return done(ctx, c.connectResult(i, result), i)
 		}
 	}
-	// No handler handled the connection
-	return Result{Action: Drop}
+	return done(ctx, Result{Action: Continue}, len(c.handlers))
 }
 
-// OnPacket processes a packet through the chain.
+// connectResult applies handler i's on_drop policy to its OnConnect result.
+func (c *Chain) connectResult(i int, result Result) Result {
+	result = c.withPolicy(i, result)
+	if result.Action == Drop && result.Policy != nil && result.Policy.Action == DropRedirect {
+		// on_drop "redirect": escalate instead of refusing
+		result = Result{Action: Redirect, Target: result.Policy.Pipeline, Error: result.Error, Policy: result.Policy}
+	}
+	return result
+}
+
+// holdThen returns a Hold result deciding with then's result for the
+// decision of held. If then holds the connection again, its decision is
+// awaited the same way.
+func holdThen(held Result, then func(Result) Result) Result {
+	hold := held.Hold
+	held.Hold = func(decide func(Result)) {
+		hold(func(r Result) {
+			if r = then(r); r.Action == Hold {
+				r.Hold(decide)
+				return
+			}
+			decide(r)
+		})
+	}
+	return held
+}
+
+// cancelConnect notifies the first n handlers that the connection was refused.
+func (c *Chain) cancelConnect(ctx *Context, n int) {
+	for _, h := range c.handlers[:n] {
+		if cc, ok := h.(ConnectCanceler); ok {
+			cc.CancelConnect(ctx)
+		}
+	}
+}
+
+// OnPacket processes a packet through the chain. A packet a handler delays
+// is held and passed on later; OnPacket returns the Delay result right away.
 func (c *Chain) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
-	for _, h := range c.handlers {
-		result := h.OnPacket(ctx, packet, dir)
+	if pl := c.pipelineOf(ctx); pl != nil {
+		return pl.OnPacket(ctx, packet, dir)
+	}
+	result := c.packet(ctx, packet, dir)
+	for result.Action == Delay && result.Delay <= 0 {
+		result = result.resume(packet)
+	}
+	switch result.Action {
+	case Continue:
+		return Result{Action: Drop}
+	case Delay:
+		delayPacket(ctx, packet, result)
+	}
+	return result
+}
+
+// packet runs OnPacket until a handler returns something other than Continue.
+func (c *Chain) packet(ctx *Context, packet []byte, dir Direction) Result {
+	return c.packetFrom(ctx, packet, dir, 0)
+}
+
+// packetFrom runs OnPacket of the handlers from index from on.
+func (c *Chain) packetFrom(ctx *Context, packet []byte, dir Direction, from int) Result {
+	for i := from; i < len(c.handlers); i++ {
+		start := time.Now()
+		result := c.handlers[i].OnPacket(ctx, packet, dir)
+		c.timings[i].packet.Observe(time.Since(start))
+		if result.Action == Delay {
+			return c.resumeAfter(ctx, dir, i, result)
+		}
 		if result.Action != Continue {
-			return result
+			return c.withPolicy(i, result)
 		}
 	}
-	return Result{Action: Drop}
+	return Result{Action: Continue}
 }
 
-// OnDisconnect notifies all handlers of disconnection.
+// resumeAfter makes a Delay result of handler i continue with the handlers
+// after it, once those of a sub-chain it came from (tenants) are done.
+func (c *Chain) resumeAfter(ctx *Context, dir Direction, i int, result Result) Result {
+	inner := result.resume
+	result.resume = func(packet []byte) Result {
+		if inner != nil {
+			r := inner(packet)
+			if r.Action == Delay {
+				return c.resumeAfter(ctx, dir, i, r)
+			}
+			if r.Action != Continue {
+				return c.withPolicy(i, r)
+			}
+		}
+		return c.packetFrom(ctx, packet, dir, i+1)
+	}
+	return result
+}
+
+// withPolicy attaches handler i's on_drop policy to a Drop result.
+func (c *Chain) withPolicy(i int, result Result) Result {
+	if result.Action == Drop && result.Policy == nil && i < len(c.policies) {
+		result.Policy = c.policies[i]
+	}
+	return result
+}
+
+// OnDisconnect notifies all handlers of disconnection, then those of the
+// pipeline the connection was redirected to.
 func (c *Chain) OnDisconnect(ctx *Context) {
 	for _, h := range c.handlers {
 		h.OnDisconnect(ctx)
 	}
+	if pl := c.pipelineOf(ctx); pl != nil {
+		pl.OnDisconnect(ctx)
+	}
+}
+
+// Restore re-establishes a saved session through the handlers implementing Restorer.
+// Routing already happened before the restart, so other handlers are skipped.
+func (c *Chain) Restore(ctx *Context, id uint64) Result {
+	if result, ok := c.restorePipeline(ctx, id); ok {
+		return result
+	}
+	for i, h := range c.handlers {
+		if r, ok := h.(Restorer); ok {
+			if result := r.Restore(ctx, id); result.Action != Continue {
+				if result.Action == Drop {
+					c.cancelConnect(ctx, i)
+				}
+				return result
+			}
+		}
+	}
+	c.cancelConnect(ctx, len(c.handlers))
+	return Result{Action: Drop}
+}
+
+// OnDatagram offers a session-less datagram to all DatagramHandlers in order.
+// Returns true if one of them consumed it.
+func (c *Chain) OnDatagram(clientAddr *net.UDPAddr, packet []byte, reply func([]byte) error) bool {
+	for _, h := range c.handlers {
+		if dh, ok := h.(DatagramHandler); ok && dh.OnDatagram(clientAddr, packet, reply) {
+			return true
+		}
+	}
+	return false
+}
+
+// Close closes all handlers that implement Closer.
+func (c *Chain) Close() {
+	for _, h := range c.handlers {
+		if cl, ok := h.(Closer); ok {
+			cl.Close()
+		}
+	}
 }
 
 // Handlers returns the list of handlers in the chain.
 func (c *Chain) Handlers() []Handler {
 	return c.handlers
 }
+
+// Configs returns the handler configs the chain was built from, or nil if it
+// was assembled with NewChain.
+func (c *Chain) Configs() []HandlerConfig {
+	return c.configs
+}
+
+// Backends returns the backends the handlers of the chain may route to, mapped
+// to the name of the first handler listing them. relay:// prefixes are removed.
+func (c *Chain) Backends() map[string]string {
+	backends := make(map[string]string)
+	for _, h := range c.handlers {
+		h = Unwrap(h)
+		bl, ok := h.(BackendLister)
+		if !ok {
+			continue
+		}
+		for _, b := range bl.Backends() {
+			b, _ = ParseRelayBackend(b)
+			if _, seen := backends[b]; !seen {
+				backends[b] = h.Name()
+			}
+		}
+	}
+	return backends
+}
@@ -1,7 +1,9 @@
 package handler
 
 import (
+	"encoding/json"
 	"net"
+	"reflect"
 	"testing"
 	"time"
 )
@@ -80,6 +82,37 @@ func TestChain_OnConnect_Drop(t *testing.T) {
 	}
 }
 
+func TestChain_OnConnect_Hold(t *testing.T) {
+	var decide func(Result)
+	holding := newMockHandler("holding", Hold, Continue)
+	holding.onConnectResult.Hold = func(d func(Result)) { decide = d }
+
+	for _, tt := range []struct {
+		decision Result
+		want     Action
+	}{
+		{Result{Action: Continue}, Handled},
+		{Result{Action: Drop}, Drop},
+	} {
+		before := &cancelingHandler{mockHandler: newMockHandler("before", Continue, Continue)}
+		after := newMockHandler("after", Handled, Continue)
+		result := NewChain(before, holding, after).OnConnect(&Context{})
+		if result.Action != Hold || after.connectCalled {
+			t.Fatalf("OnConnect = %v, after called: %v; want Hold before the decision", result.Action, after.connectCalled)
+		}
+
+		var got Result
+		result.Hold(func(r Result) { got = r })
+		decide(tt.decision)
+		if got.Action != tt.want {
+			t.Errorf("decision %v: chain decided %v, want %v", tt.decision.Action, got.Action, tt.want)
+		}
+		if after.connectCalled != (tt.decision.Action == Continue) || before.canceled != (tt.want == Drop) {
+			t.Errorf("decision %v: after called %v, before canceled %v", tt.decision.Action, after.connectCalled, before.canceled)
+		}
+	}
+}
+
 func TestChain_OnConnect_EmptyChain(t *testing.T) {
 	chain := NewChain()
 	ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}}
@@ -147,6 +180,27 @@ func TestChain_Handlers(t *testing.T) {
 	}
 }
 
+func TestChain_Backends(t *testing.T) {
+	chain, err := BuildChain([]HandlerConfig{
+		{Type: "sni-router", Config: json.RawMessage(`{"routes": {"a.example.com": ["10.0.0.1:5520", "10.0.0.2:5520"], "b.example.com": "relay://10.0.0.3:5520"}}`)},
+		{Type: "simple-router", Config: json.RawMessage(`{"backend": "10.0.0.1:5520"}`)},
+		{Type: "forwarder"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer chain.Close()
+
+	want := map[string]string{
+		"10.0.0.1:5520": "sni-router",
+		"10.0.0.2:5520": "sni-router",
+		"10.0.0.3:5520": "sni-router",
+	}
+	if got := chain.Backends(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Backends() = %v, want %v", got, want)
+	}
+}
+
 func TestContext_SetGet(t *testing.T) {
 	ctx := &Context{}
 
@@ -303,3 +357,61 @@ func TestContext_Concurrent(t *testing.T) {
 
 	// If we get here without race detector complaints, test passes
 }
+
+func TestContext_CloseReason_FirstWins(t *testing.T) {
+	ctx := &Context{}
+
+	if ctx.CloseReason() != CloseUnknown {
+		t.Errorf("expected CloseUnknown, got %v", ctx.CloseReason())
+	}
+	if !ctx.SetCloseReason(CloseBackendError) {
+		t.Error("first SetCloseReason should succeed")
+	}
+	if ctx.SetCloseReason(CloseIdle) {
+		t.Error("second SetCloseReason should not overwrite")
+	}
+	if ctx.CloseReason() != CloseBackendError {
+		t.Errorf("expected CloseBackendError, got %v", ctx.CloseReason())
+	}
+}
+
+func TestContext_DropWithReason(t *testing.T) {
+	ctx := &Context{}
+	var seen CloseReason
+	ctx.DropSession = func() {
+		// Mirrors the proxy: default reason is only applied if none was set
+		ctx.SetCloseReason(CloseHandlerDrop)
+		seen = ctx.CloseReason()
+	}
+
+	ctx.DropWithReason(CloseAdminKill)
+
+	if seen != CloseAdminKill {
+		t.Errorf("expected CloseAdminKill in DropSession, got %v", seen)
+	}
+}
+
+func TestCloseReason_String(t *testing.T) {
+	tests := map[CloseReason]string{
+		CloseUnknown:      "unknown",
+		CloseIdle:         "idle",
+		CloseBackendError: "backend_error",
+		CloseHandlerDrop:  "handler_drop",
+		CloseAdminKill:    "admin_kill",
+		CloseDrain:        "drain",
+		CloseEvicted:      "evicted",
+
+		CloseBackendReset:       "backend_reset",
+		CloseVersionNegotiation: "version_negotiation",
+		CloseViolation:          "violation",
+		CloseClientError:        "client_error",
+	}
+	for reason, want := range tests {
+		if got := reason.String(); got != want {
+			t.Errorf("CloseReason(%d).String() = %q, want %q", reason, got, want)
+		}
+	}
+	if !CloseIdle.Graceful() || CloseBackendError.Graceful() {
+		t.Error("unexpected Graceful() result")
+	}
+}
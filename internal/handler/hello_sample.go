@@ -0,0 +1,289 @@
+package handler
+
+import (
+	"cmp"
+	"crypto/tls"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/privacy"
+)
+
+func init() {
+	Register("hello-sample", NewHelloSampleHandler)
+}
+
+const (
+	defaultHelloSampleRate = 0.01
+	defaultHelloSampleMax  = 1000
+	helloSampleListLimit   = 100 // Samples GET / returns without ?limit
+)
+
+// HelloSampleConfig is the configuration for the hello-sample handler.
+type HelloSampleConfig struct {
+	Rate float64 `json:"rate,omitempty"` // Fraction of ClientHellos stored, 0 < rate <= 1 (default: 0.01)
+	Max  int     `json:"max,omitempty"`  // Samples kept; the oldest are evicted (default: 1000)
+}
+
+// HelloSample is one sampled ClientHello.
+type HelloSample struct {
+	Time     time.Time `json:"time"`
+	Client   string    `json:"client"`
+	Protocol string    `json:"protocol,omitempty"`
+	SNI      string    `json:"sni,omitempty"`
+	ALPN     []string  `json:"alpn,omitempty"`
+	Versions []string  `json:"versions,omitempty"` // Offered TLS versions, highest first
+	JA4      string    `json:"ja4,omitempty"`
+	Ciphers  []string  `json:"ciphers,omitempty"` // Offered cipher suites in client order, without GREASE
+}
+
+// HelloSampleStats are the sampler's counters.
+type HelloSampleStats struct {
+	Rate    float64 `json:"rate"`
+	Seen    uint64  `json:"seen"`    // ClientHellos the handler saw
+	Sampled uint64  `json:"sampled"` // ClientHellos stored so far
+	Stored  int     `json:"stored"`  // Samples currently kept
+}
+
+// helloCount is one entry of GET /top.
+type helloCount struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+// HelloSampleHandler stores a random fraction of ClientHellos in a bounded
+// history the admin API queries, to show which clients and TLS stacks
+// connect without capturing packets. It never changes the chain's decision.
+type HelloSampleHandler struct {
+	rate float64
+	max  int
+
+	seen    atomic.Uint64
+	sampled atomic.Uint64
+
+	mu      sync.Mutex
+	samples []HelloSample // Ring buffer; next is the oldest once full
+	next    int
+}
+
+// NewHelloSampleHandler creates a new hello-sample handler.
+func NewHelloSampleHandler(raw json.RawMessage) (Handler, error) {
+	var cfg HelloSampleConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid hello-sample config: %w", err)
+		}
+	}
+	if cfg.Rate < 0 || cfg.Rate > 1 {
+		return nil, errors.New("invalid hello-sample config: rate must be between 0 and 1")
+	}
+	if cfg.Max < 0 {
+		return nil, errors.New("invalid hello-sample config: max must be >= 0")
+	}
+	return &HelloSampleHandler{
+		rate: cmp.Or(cfg.Rate, defaultHelloSampleRate),
+		max:  cmp.Or(cfg.Max, defaultHelloSampleMax),
+	}, nil
+}
+
+// Name returns the handler name.
+func (h *HelloSampleHandler) Name() string { return "hello-sample" }
+
+// OnConnect samples the ClientHello of the connection.
+func (h *HelloSampleHandler) OnConnect(ctx *Context) Result {
+	if ctx.Hello == nil {
+		return Result{Action: Continue}
+	}
+	h.seen.Add(1)
+	if h.rate < 1 && rand.Float64() >= h.rate {
+		return Result{Action: Continue}
+	}
+	sample := HelloSample{
+		Time:     time.Now().UTC(),
+		Protocol: ctx.Protocol,
+		SNI:      ctx.Hello.SNI,
+		ALPN:     slices.Clone(ctx.Hello.ALPNProtocols),
+		JA4:      ja4(ctx.Hello.Raw),
+	}
+	if client := ctx.OriginalClientAddr(); client != nil {
+		sample.Client = privacy.Addr(client)
+	}
+	sample.Versions, sample.Ciphers = helloOffers(ctx.Hello.Raw)
+	h.record(sample)
+	return Result{Action: Continue}
+}
+
+// OnPacket does nothing.
+func (h *HelloSampleHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect does nothing.
+func (h *HelloSampleHandler) OnDisconnect(ctx *Context) {}
+
+func (h *HelloSampleHandler) record(sample HelloSample) {
+	h.sampled.Add(1)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.samples) < h.max {
+		h.samples = append(h.samples, sample)
+		return
+	}
+	h.samples[h.next] = sample
+	h.next = (h.next + 1) % h.max
+}
+
+// Samples returns the kept samples, newest first.
+func (h *HelloSampleHandler) Samples() []HelloSample {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	samples := make([]HelloSample, 0, len(h.samples))
+	samples = append(samples, h.samples[h.next:]...)
+	samples = append(samples, h.samples[:h.next]...)
+	slices.Reverse(samples)
+	return samples
+}
+
+// Stats returns the sampler's counters.
+func (h *HelloSampleHandler) Stats() HelloSampleStats {
+	h.mu.Lock()
+	stored := len(h.samples)
+	h.mu.Unlock()
+	return HelloSampleStats{Rate: h.rate, Seen: h.seen.Load(), Sampled: h.sampled.Load(), Stored: stored}
+}
+
+// helloSampleFields are the fields GET /top counts by.
+var helloSampleFields = map[string]func(HelloSample) []string{
+	"sni":     func(s HelloSample) []string { return []string{s.SNI} },
+	"alpn":    func(s HelloSample) []string { return s.ALPN },
+	"version": func(s HelloSample) []string { return s.Versions[:min(len(s.Versions), 1)] },
+	"ja4":     func(s HelloSample) []string { return []string{s.JA4} },
+	"cipher":  func(s HelloSample) []string { return s.Ciphers },
+	"client":  func(s HelloSample) []string { return []string{s.Client} },
+}
+
+// ServeAdmin serves the kept samples. GET / lists them, newest first,
+// filtered by ?sni, ?alpn, ?version, ?ja4 and ?cipher (exact matches) and
+// limited by ?limit. GET /top?by=<field> counts them by sni, alpn, version
+// (the highest offered), ja4, cipher or client, with the same filters.
+func (h *HelloSampleHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	q := r.URL.Query()
+	limit := helloSampleListLimit
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			writeAdminError(w, http.StatusBadRequest, "limit must be a positive number")
+			return
+		}
+		limit = n
+	}
+	samples := slices.DeleteFunc(h.Samples(), func(s HelloSample) bool { return !helloSampleMatches(s, q.Get) })
+
+	switch strings.TrimPrefix(r.URL.Path, "/") {
+	case "":
+		writeAdminJSON(w, http.StatusOK, map[string]any{
+			"stats":   h.Stats(),
+			"samples": samples[:min(len(samples), limit)],
+		})
+	case "top":
+		field, ok := helloSampleFields[q.Get("by")]
+		if !ok {
+			writeAdminError(w, http.StatusBadRequest, "by must be one of sni, alpn, version, ja4, cipher, client")
+			return
+		}
+		counts := make(map[string]int)
+		for _, s := range samples {
+			for _, v := range field(s) {
+				counts[v]++
+			}
+		}
+		top := make([]helloCount, 0, len(counts))
+		for v, n := range counts {
+			top = append(top, helloCount{Value: v, Count: n})
+		}
+		slices.SortFunc(top, func(a, b helloCount) int {
+			return cmp.Or(b.Count-a.Count, strings.Compare(a.Value, b.Value))
+		})
+		writeAdminJSON(w, http.StatusOK, map[string]any{
+			"samples": len(samples),
+			"top":     top[:min(len(top), limit)],
+		})
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// helloSampleMatches reports whether s matches the filters of get.
+func helloSampleMatches(s HelloSample, get func(string) string) bool {
+	if v := get("sni"); v != "" && !strings.EqualFold(v, s.SNI) {
+		return false
+	}
+	if v := get("ja4"); v != "" && v != s.JA4 {
+		return false
+	}
+	for _, f := range []struct {
+		key    string
+		values []string
+	}{{"alpn", s.ALPN}, {"version", s.Versions}, {"cipher", s.Ciphers}} {
+		if v := get(f.key); v != "" && !slices.Contains(f.values, v) {
+			return false
+		}
+	}
+	return true
+}
+
+// helloOffers returns the TLS versions, highest first, and the cipher suites
+// a ClientHello handshake message offers, without GREASE values.
+func helloOffers(raw []byte) (versions, ciphers []string) {
+	legacy, suites, exts, ok := helloVectors(raw)
+	if !ok {
+		return nil, nil
+	}
+	for i := 0; i+1 < len(suites); i += 2 {
+		if v := binary.BigEndian.Uint16(suites[i:]); !isGREASE(v) {
+			ciphers = append(ciphers, tls.CipherSuiteName(v))
+		}
+	}
+
+	var offered []uint16
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts)
+		n := int(binary.BigEndian.Uint16(exts[2:]))
+		if len(exts) < 4+n {
+			break
+		}
+		data := exts[4 : 4+n]
+		exts = exts[4+n:]
+		if typ != 0x002b || len(data) < 1 { // supported_versions
+			continue
+		}
+		for i := 1; i+1 < len(data) && i <= int(data[0]); i += 2 {
+			if v := binary.BigEndian.Uint16(data[i:]); !isGREASE(v) {
+				offered = append(offered, v)
+			}
+		}
+	}
+	if len(offered) == 0 {
+		offered = []uint16{legacy}
+	}
+	slices.Sort(offered)
+	slices.Reverse(offered)
+	for _, v := range slices.Compact(offered) {
+		versions = append(versions, tls.VersionName(v))
+	}
+	return versions, ciphers
+}
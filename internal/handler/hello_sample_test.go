@@ -0,0 +1,107 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestNewHelloSampleHandler_Config(t *testing.T) {
+	for _, cfg := range []string{`{"rate": 1.5}`, `{"rate": -0.1}`, `{"max": -1}`, `{"rate": "all"}`} {
+		if _, err := NewHelloSampleHandler(json.RawMessage(cfg)); err == nil {
+			t.Errorf("%s accepted", cfg)
+		}
+	}
+	h, err := NewHelloSampleHandler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := h.(*HelloSampleHandler); s.rate != defaultHelloSampleRate || s.max != defaultHelloSampleMax {
+		t.Errorf("defaults: rate %v, max %d", s.rate, s.max)
+	}
+}
+
+func TestHelloSample_Record(t *testing.T) {
+	raw, _ := NewHelloSampleHandler(json.RawMessage(`{"rate": 1, "max": 2}`))
+	h := raw.(*HelloSampleHandler)
+	for _, sni := range []string{"a.example", "b.example", "c.example"} {
+		ctx := &Context{
+			ClientAddr: &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234},
+			Hello:      &ClientHello{Raw: testClientHello(t, sni, "h3"), SNI: sni, ALPNProtocols: []string{"h3"}},
+		}
+		if result := h.OnConnect(ctx); result.Action != Continue {
+			t.Fatalf("OnConnect = %v", result.Action)
+		}
+	}
+	h.OnConnect(&Context{}) // No ClientHello: not counted
+
+	samples := h.Samples()
+	if len(samples) != 2 || samples[0].SNI != "c.example" || samples[1].SNI != "b.example" {
+		t.Fatalf("samples = %+v", samples)
+	}
+	s := samples[0]
+	if s.Client != "192.0.2.1:1234" || !strings.HasPrefix(s.JA4, "q13d") || !slices.Equal(s.Versions, []string{"TLS 1.3"}) {
+		t.Errorf("sample = %+v", s)
+	}
+	if !slices.Contains(s.Ciphers, "TLS_AES_128_GCM_SHA256") {
+		t.Errorf("ciphers = %v", s.Ciphers)
+	}
+	if st := h.Stats(); st.Seen != 3 || st.Sampled != 3 || st.Stored != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestHelloSample_Rate(t *testing.T) {
+	h := &HelloSampleHandler{rate: 0.25, max: 10000}
+	hello := &ClientHello{Raw: testClientHello(t, "play.example")}
+	for range 4000 {
+		h.OnConnect(&Context{Hello: hello})
+	}
+	if n := h.Stats().Sampled; n < 800 || n > 1200 {
+		t.Errorf("sampled %d of 4000 at rate 0.25", n)
+	}
+}
+
+func TestHelloSample_ServeAdmin(t *testing.T) {
+	h := &HelloSampleHandler{rate: 1, max: 10}
+	h.record(HelloSample{SNI: "a.example", ALPN: []string{"h3"}, Versions: []string{"TLS 1.3"}, JA4: "x"})
+	h.record(HelloSample{SNI: "a.example", ALPN: []string{"hytale"}, Versions: []string{"TLS 1.3"}, JA4: "y"})
+	h.record(HelloSample{SNI: "b.example", ALPN: []string{"h3"}, Versions: []string{"TLS 1.3", "TLS 1.2"}, JA4: "x"})
+
+	get := func(target string) (int, map[string]json.RawMessage) {
+		w := httptest.NewRecorder()
+		h.ServeAdmin(w, httptest.NewRequest(http.MethodGet, target, nil))
+		var body map[string]json.RawMessage
+		json.Unmarshal(w.Body.Bytes(), &body)
+		return w.Code, body
+	}
+
+	_, body := get("/?alpn=h3&limit=1")
+	var samples []HelloSample
+	json.Unmarshal(body["samples"], &samples)
+	if len(samples) != 1 || samples[0].SNI != "b.example" {
+		t.Errorf("filtered samples = %+v", samples)
+	}
+
+	_, body = get("/top?by=ja4")
+	var top []helloCount
+	json.Unmarshal(body["top"], &top)
+	if !slices.Equal(top, []helloCount{{"x", 2}, {"y", 1}}) {
+		t.Errorf("top ja4 = %+v", top)
+	}
+	_, body = get("/top?by=version&sni=A.example")
+	json.Unmarshal(body["top"], &top)
+	if !slices.Equal(top, []helloCount{{"TLS 1.3", 2}}) {
+		t.Errorf("top version = %+v", top)
+	}
+
+	for target, want := range map[string]int{"/top?by=route": 400, "/?limit=0": 400, "/other": 404} {
+		if code, _ := get(target); code != want {
+			t.Errorf("GET %s = %d, want %d", target, code, want)
+		}
+	}
+}
@@ -0,0 +1,683 @@
+package handler
+
+import (
+	"bufio"
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/pcap"
+	"quic-relay/internal/privacy"
+	"quic-relay/internal/retention"
+)
+
+var honeypotLog = logging.For("honeypot")
+
+func init() {
+	Register("honeypot", NewHoneypotHandler)
+}
+
+// HoneypotKey is the context key a router sets, to the matched route key, on
+// connections of a route with "type": "honeypot". The honeypot handler takes
+// those connections over instead of the forwarder.
+const HoneypotKey = "honeypot"
+
+// honeypotStateKey holds the *honeypotConn of a captured connection.
+const honeypotStateKey = "_honeypot"
+
+// honeypotRecent is how many connections the admin endpoint lists.
+const honeypotRecent = 100
+
+// honeypotMaxSources bounds the sources listed at once.
+const honeypotMaxSources = 100000
+
+// honeypotMaxPartitions bounds the tenant or SNI directories a honeypot
+// writes to; connections beyond go to the store's top directory.
+const honeypotMaxPartitions = 256
+
+// Honeypot split modes.
+const (
+	HoneypotSplitTenant = "tenant" // A directory per tenant
+	HoneypotSplitSNI    = "sni"    // A directory per SNI
+)
+
+// HoneypotConfig is the configuration for the honeypot handler.
+type HoneypotConfig struct {
+	Dir          string `json:"dir"`                      // Directory of the capture store
+	MaxPackets   int    `json:"max_packets,omitempty"`    // Client packets captured per connection (default: 32)
+	MaxCaptureMB int    `json:"max_capture_mb,omitempty"` // Size of a capture file before capturing stops (default: 64)
+	ListFor      int    `json:"list_for,omitempty"`       // Seconds a source stays listed for the reputation handler (default: 3600)
+	Split        string `json:"split,omitempty"`          // "tenant" or "sni": capture into a directory per tenant or SNI
+}
+
+// HoneypotStats are the honeypot's counters, served by its admin endpoint.
+type HoneypotStats struct {
+	Capture     string `json:"capture"` // Current capture file
+	Connections uint64 `json:"connections"`
+	Packets     uint64 `json:"packets_captured"`
+	Bytes       int64  `json:"bytes_captured"`
+	Truncated   uint64 `json:"packets_truncated"` // Not captured because of max_packets or max_capture_mb
+	Sources     int    `json:"sources_listed"`
+}
+
+// HoneypotHit is one connection a honeypot route received. Hits are appended
+// as JSON lines to hellos.jsonl in the capture store.
+type HoneypotHit struct {
+	Time     string   `json:"time"`
+	Source   string   `json:"source"`
+	Route    string   `json:"route"`
+	SNI      string   `json:"sni,omitempty"`
+	Protocol string   `json:"protocol,omitempty"`
+	ALPN     []string `json:"alpn,omitempty"`
+	JA4      string   `json:"ja4,omitempty"`
+	Hello    []byte   `json:"hello,omitempty"` // Raw ClientHello
+}
+
+// honeypotConn is the state of one captured connection.
+type honeypotConn struct {
+	store   *honeypotStore
+	packets atomic.Int64
+}
+
+// honeypotStore is a capture file and hellos.jsonl in one directory of the
+// capture store.
+type honeypotStore struct {
+	path    string
+	file    *os.File
+	buf     *bufio.Writer
+	capture *pcap.Writer
+	written int64
+	hellos  *os.File
+	release func() // Lets retention delete the files again
+}
+
+// honeypotSources lists the sources honeypot routes have seen, until the
+// entry expires. Package-level so the list survives chain reloads; the
+// reputation handler adds its honeypot points to listed sources.
+var honeypotSources = struct {
+	sync.Mutex
+	m map[netip.Prefix]time.Time
+}{m: make(map[netip.Prefix]time.Time)}
+
+// honeypotSource returns the list entry of addr: the IPv4 address, or the
+// IPv6 /64 since scanners rotate through their prefix.
+func honeypotSource(addr netip.Addr) netip.Prefix {
+	addr = addr.Unmap()
+	bits := 32
+	if addr.Is6() {
+		bits = 64
+	}
+	p, _ := addr.Prefix(bits)
+	return p
+}
+
+// listHoneypotSource lists addr until until.
+func listHoneypotSource(addr netip.Addr, until time.Time) {
+	src := honeypotSource(addr)
+	honeypotSources.Lock()
+	defer honeypotSources.Unlock()
+	if _, ok := honeypotSources.m[src]; !ok && len(honeypotSources.m) >= honeypotMaxSources {
+		now := time.Now()
+		for p, exp := range honeypotSources.m {
+			if now.After(exp) {
+				delete(honeypotSources.m, p)
+			}
+		}
+		if len(honeypotSources.m) >= honeypotMaxSources {
+			return
+		}
+	}
+	honeypotSources.m[src] = until
+}
+
+// honeypotListed reports whether a honeypot route saw addr recently.
+func honeypotListed(addr netip.Addr, now time.Time) bool {
+	src := honeypotSource(addr)
+	honeypotSources.Lock()
+	defer honeypotSources.Unlock()
+	exp, ok := honeypotSources.m[src]
+	if ok && now.After(exp) {
+		delete(honeypotSources.m, src)
+		return false
+	}
+	return ok
+}
+
+// honeypotListedCount returns the number of listed sources.
+func honeypotListedCount() int {
+	honeypotSources.Lock()
+	defer honeypotSources.Unlock()
+	return len(honeypotSources.m)
+}
+
+// HoneypotHandler takes over connections routed to a honeypot route. It
+// accepts them without a backend, writes their packets to a capture file and
+// their ClientHello with its JA4 fingerprint to hellos.jsonl, and lists their
+// sources for the reputation handler. Clients never get an answer.
+// Place it after the routers and before the forwarder.
+type HoneypotHandler struct {
+	dir         string
+	maxPackets  int64
+	maxBytes    int64
+	listFor     time.Duration
+	split       string
+	removeStore func() // Unregisters dir from retention
+
+	mu         sync.Mutex // Guards the stores and recent
+	root       *honeypotStore
+	partitions map[string]*honeypotStore // By directory name; nil when it could not be opened
+	recent     []HoneypotHit             // Newest last, at most honeypotRecent
+
+	sessionCounter atomic.Uint64
+	connections    atomic.Uint64
+	packets        atomic.Uint64
+	truncated      atomic.Uint64
+}
+
+// NewHoneypotHandler creates a new honeypot handler and its capture files.
+func NewHoneypotHandler(raw json.RawMessage) (Handler, error) {
+	h, err := newHoneypot(raw)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.open(); err != nil {
+		return nil, fmt.Errorf("invalid honeypot config: %w", err)
+	}
+	return h, nil
+}
+
+// newHoneypot parses the config without creating any files.
+func newHoneypot(raw json.RawMessage) (*HoneypotHandler, error) {
+	var cfg HoneypotConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid honeypot config: %w", err)
+		}
+	}
+	if cfg.Dir == "" {
+		return nil, errors.New("invalid honeypot config: 'dir' is required")
+	}
+	if cfg.MaxPackets < 0 || cfg.MaxCaptureMB < 0 || cfg.ListFor < 0 {
+		return nil, errors.New("invalid honeypot config: max_packets, max_capture_mb and list_for must be >= 0")
+	}
+	switch cfg.Split {
+	case "", HoneypotSplitTenant, HoneypotSplitSNI:
+	default:
+		return nil, fmt.Errorf("invalid honeypot config: unknown split %q", cfg.Split)
+	}
+	h := &HoneypotHandler{
+		dir:        cfg.Dir,
+		maxPackets: int64(cmp.Or(cfg.MaxPackets, 32)),
+		maxBytes:   int64(cmp.Or(cfg.MaxCaptureMB, 64)) << 20,
+		listFor:    time.Duration(cmp.Or(cfg.ListFor, 3600)) * time.Second,
+		split:      cfg.Split,
+	}
+	return h, nil
+}
+
+// open creates the capture store and registers it for retention.
+func (h *HoneypotHandler) open() error {
+	root, err := openHoneypotStore(h.dir)
+	if err != nil {
+		return err
+	}
+	h.root = root
+	h.removeStore = retention.AddStore(h.dir, "")
+	return nil
+}
+
+// openHoneypotStore opens a new capture file in dir, so reloads never append
+// to a file another handler still writes, and the shared hellos.jsonl.
+func openHoneypotStore(dir string) (*honeypotStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, err
+	}
+	hellosPath := filepath.Join(dir, "hellos.jsonl")
+	hellos, err := os.OpenFile(hellosPath, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+	if err != nil {
+		return nil, err
+	}
+	st := &honeypotStore{path: filepath.Join(dir, fmt.Sprintf("honeypot-%s.pcap", time.Now().UTC().Format("20060102T150405.000000000")))}
+	f, err := os.OpenFile(st.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		hellos.Close()
+		return nil, err
+	}
+	st.buf = bufio.NewWriter(f)
+	if st.capture, err = pcap.NewWriter(st.buf); err != nil {
+		f.Close()
+		hellos.Close()
+		return nil, err
+	}
+	st.file, st.hellos, st.written = f, hellos, 24
+	releaseCapture, releaseHellos := retention.Hold(st.path), retention.Hold(hellosPath)
+	st.release = func() { releaseCapture(); releaseHellos() }
+	return st, nil
+}
+
+// close flushes and closes the files of the store.
+func (st *honeypotStore) close() error {
+	if st.capture == nil {
+		return nil
+	}
+	err := errors.Join(st.buf.Flush(), st.file.Close(), st.hellos.Close())
+	st.capture, st.hellos = nil, nil
+	st.release()
+	return err
+}
+
+// storeFor returns the store of ctx's tenant or SNI when the captures are
+// split, or the top one. h.mu must be held.
+func (h *HoneypotHandler) storeFor(ctx *Context) *honeypotStore {
+	var name string
+	switch h.split {
+	case HoneypotSplitTenant:
+		if tenant := ctx.GetString(TenantKey); tenant != "" {
+			name = retention.PartitionDir(retention.TenantPrefix, tenant)
+		}
+	case HoneypotSplitSNI:
+		if ctx.Hello != nil && ctx.Hello.SNI != "" {
+			name = retention.PartitionDir(retention.SNIPrefix, strings.ToLower(ctx.Hello.SNI))
+		}
+	}
+	if name == "" || h.root == nil {
+		return h.root
+	}
+	st, ok := h.partitions[name]
+	if !ok {
+		if len(h.partitions) >= honeypotMaxPartitions {
+			return h.root
+		}
+		var err error
+		if st, err = openHoneypotStore(filepath.Join(h.dir, name)); err != nil {
+			honeypotLog.Warnf("opening %s: %v", name, err)
+		}
+		if h.partitions == nil {
+			h.partitions = make(map[string]*honeypotStore)
+		}
+		h.partitions[name] = st
+	}
+	if st == nil {
+		return h.root
+	}
+	return st
+}
+
+// Name returns the handler name.
+func (h *HoneypotHandler) Name() string {
+	return "honeypot"
+}
+
+// OnConnect takes over connections of honeypot routes and passes the others.
+func (h *HoneypotHandler) OnConnect(ctx *Context) Result {
+	route, ok := GetValue[string](ctx, HoneypotKey)
+	if !ok {
+		return Result{Action: Continue}
+	}
+	client := ctx.OriginalClientAddr()
+	if client == nil {
+		return Result{Action: Drop, Error: errors.New("honeypot: no client address")}
+	}
+	now := time.Now()
+	if addr, ok := netip.AddrFromSlice(client.IP); ok {
+		listHoneypotSource(addr, now.Add(h.listFor))
+	}
+	h.connections.Add(1)
+
+	hit := HoneypotHit{
+		Time:     now.UTC().Format(time.RFC3339Nano),
+		Source:   privacy.Addr(client),
+		Route:    route,
+		Protocol: ctx.Protocol,
+	}
+	if ctx.Hello != nil {
+		hit.SNI = ctx.Hello.SNI
+		hit.ALPN = ctx.Hello.ALPNProtocols
+		hit.JA4 = ja4(ctx.Hello.Raw)
+		hit.Hello = clientHelloBytes(ctx.Hello.Raw)
+	}
+	c := &honeypotConn{store: h.record(ctx, hit)}
+	honeypotLog.Debugf("%s: route=%s sni=%q ja4=%s", hit.Source, route, hit.SNI, hit.JA4)
+
+	ctx.Set(honeypotStateKey, c)
+	session := &Session{ID: h.sessionCounter.Add(1), CreatedAt: now}
+	session.SetClientAddr(ctx.ClientAddr)
+	session.LastActivity.Store(now.Unix())
+	if n := len(ctx.InitialPacket); n > 0 {
+		session.CountIn(n)
+		h.capturePacket(ctx, c, ctx.InitialPacket)
+	}
+	ctx.InitialPacket = nil
+	ctx.Session = session
+	return Result{Action: Handled}
+}
+
+// OnPacket captures client packets of honeypot connections and drops them.
+func (h *HoneypotHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	c, ok := GetValue[*honeypotConn](ctx, honeypotStateKey)
+	if !ok {
+		return Result{Action: Continue}
+	}
+	if ctx.Session != nil {
+		ctx.Session.Touch()
+		ctx.Session.CountIn(len(packet))
+	}
+	if dir == Inbound {
+		h.capturePacket(ctx, c, packet)
+	}
+	return Result{Action: Handled}
+}
+
+// OnDisconnect closes the session of honeypot connections.
+func (h *HoneypotHandler) OnDisconnect(ctx *Context) {
+	if _, ok := GetValue[*honeypotConn](ctx, honeypotStateKey); ok && ctx.Session != nil {
+		ctx.Session.Close()
+	}
+}
+
+// capturePacket appends a client packet to the capture file, unless the
+// connection reached max_packets or the file max_capture_mb.
+func (h *HoneypotHandler) capturePacket(ctx *Context, c *honeypotConn, packet []byte) {
+	if c.packets.Add(1) > h.maxPackets {
+		h.truncated.Add(1)
+		return
+	}
+	d := pcap.Datagram{Time: time.Now(), Src: ctx.OriginalClientAddr().AddrPort(), Payload: packet}
+	d.Src = netip.AddrPortFrom(d.Src.Addr().Unmap(), d.Src.Port())
+	d.Dst = captureDst(ctx, d.Src.Addr())
+	size := int64(16 + 8 + len(packet))
+	if d.Src.Addr().Is4() {
+		size += 20
+	} else {
+		size += 40
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	st := c.store
+	if st == nil || st.capture == nil || st.written+size > h.maxBytes {
+		h.truncated.Add(1)
+		return
+	}
+	if err := st.capture.Write(d); err != nil {
+		honeypotLog.Warnf("writing %s: %v", st.path, err)
+		h.truncated.Add(1)
+		return
+	}
+	// Flush per packet so the capture can be read while it grows
+	if err := st.buf.Flush(); err != nil {
+		honeypotLog.Warnf("writing %s: %v", st.path, err)
+	}
+	st.written += size
+	h.packets.Add(1)
+}
+
+// captureDst returns the address the client sent to, as far as the listener
+// tells, in the family of src so the capture holds consistent IP headers.
+func captureDst(ctx *Context, src netip.Addr) netip.AddrPort {
+	var dst netip.AddrPort
+	if ctx.ProxyConn != nil {
+		if ua, ok := ctx.ProxyConn.LocalAddr().(*net.UDPAddr); ok {
+			dst = ua.AddrPort()
+		}
+	}
+	addr := dst.Addr().Unmap()
+	if !addr.IsValid() || addr.IsUnspecified() || addr.Is4() != src.Is4() {
+		addr = netip.IPv6Unspecified()
+		if src.Is4() {
+			addr = netip.IPv4Unspecified()
+		}
+	}
+	return netip.AddrPortFrom(addr, dst.Port())
+}
+
+// record appends a hit to the recent list and the hellos.jsonl of ctx's
+// store, which it returns.
+func (h *HoneypotHandler) record(ctx *Context, hit HoneypotHit) *honeypotStore {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.recent) >= honeypotRecent {
+		h.recent = slices.Delete(h.recent, 0, len(h.recent)-honeypotRecent+1)
+	}
+	h.recent = append(h.recent, hit)
+	st := h.storeFor(ctx)
+	if st == nil || st.hellos == nil {
+		return st
+	}
+	line, err := json.Marshal(hit)
+	if err != nil {
+		return st
+	}
+	if _, err := st.hellos.Write(append(line, '\n')); err != nil {
+		honeypotLog.Warnf("writing %s: %v", filepath.Join(filepath.Dir(st.path), "hellos.jsonl"), err)
+	}
+	return st
+}
+
+// Stats returns the honeypot's counters.
+func (h *HoneypotHandler) Stats() HoneypotStats {
+	h.mu.Lock()
+	var capture string
+	var written int64
+	if h.root != nil {
+		capture, written = h.root.path, h.root.written
+	}
+	for _, st := range h.partitions {
+		if st != nil {
+			written += st.written
+		}
+	}
+	h.mu.Unlock()
+	return HoneypotStats{
+		Capture:     capture,
+		Connections: h.connections.Load(),
+		Packets:     h.packets.Load(),
+		Bytes:       written,
+		Truncated:   h.truncated.Load(),
+		Sources:     honeypotListedCount(),
+	}
+}
+
+// Recent returns the latest connections, newest first.
+func (h *HoneypotHandler) Recent() []HoneypotHit {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	recent := slices.Clone(h.recent)
+	slices.Reverse(recent)
+	return recent
+}
+
+// ServeAdmin serves the honeypot's stats and latest connections.
+func (h *HoneypotHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimPrefix(r.URL.Path, "/") != "" {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"stats":  h.Stats(),
+		"recent": h.Recent(),
+	})
+}
+
+// Close closes the capture store. Listed sources stay listed.
+func (h *HoneypotHandler) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.root == nil {
+		return nil
+	}
+	err := h.root.close()
+	for _, st := range h.partitions {
+		if st != nil {
+			err = errors.Join(err, st.close())
+		}
+	}
+	h.removeStore()
+	return err
+}
+
+// clientHelloBytes returns the ClientHello handshake message of raw, which
+// may be followed by more CRYPTO data.
+func clientHelloBytes(raw []byte) []byte {
+	if len(raw) < 4 {
+		return nil
+	}
+	n := 4 + (int(raw[1])<<16 | int(raw[2])<<8 | int(raw[3]))
+	return slices.Clone(raw[:min(n, len(raw))])
+}
+
+// isGREASE reports whether v is a reserved GREASE value (RFC 8701).
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// helloVectors splits a ClientHello handshake message into its legacy
+// version, cipher suites and extensions.
+func helloVectors(raw []byte) (version uint16, suites, exts []byte, ok bool) {
+	hello := clientHelloBytes(raw)
+	if len(hello) < 4+2+32+1 || hello[0] != 0x01 {
+		return 0, nil, nil, false
+	}
+	version = binary.BigEndian.Uint16(hello[4:6])
+	p := hello[38:]
+	next := func(n int) ([]byte, bool) {
+		if len(p) < n {
+			return nil, false
+		}
+		b := p[:n]
+		p = p[n:]
+		return b, true
+	}
+	vec := func(lenBytes int) ([]byte, bool) {
+		l, ok := next(lenBytes)
+		if !ok {
+			return nil, false
+		}
+		n := 0
+		for _, b := range l {
+			n = n<<8 | int(b)
+		}
+		return next(n)
+	}
+
+	if _, ok := vec(1); !ok { // Session ID
+		return 0, nil, nil, false
+	}
+	if suites, ok = vec(2); !ok {
+		return 0, nil, nil, false
+	}
+	if _, ok := vec(1); !ok { // Compression methods
+		return 0, nil, nil, false
+	}
+	exts, _ = vec(2)
+	return version, suites, exts, true
+}
+
+// ja4 returns the JA4 fingerprint of a QUIC ClientHello handshake message,
+// or "" when it cannot be parsed. See https://github.com/FoxIO-LLC/ja4.
+func ja4(raw []byte) string {
+	version, suites, exts, ok := helloVectors(raw)
+	if !ok {
+		return ""
+	}
+
+	var ciphers []string
+	for i := 0; i+1 < len(suites); i += 2 {
+		if v := binary.BigEndian.Uint16(suites[i:]); !isGREASE(v) {
+			ciphers = append(ciphers, fmt.Sprintf("%04x", v))
+		}
+	}
+
+	var extensions, sigAlgs []string
+	extCount := 0
+	sni := "i"
+	alpn := "00"
+	for len(exts) >= 4 {
+		typ := binary.BigEndian.Uint16(exts)
+		n := int(binary.BigEndian.Uint16(exts[2:]))
+		if len(exts) < 4+n {
+			break
+		}
+		data := exts[4 : 4+n]
+		exts = exts[4+n:]
+		if isGREASE(typ) {
+			continue
+		}
+		extCount++
+		switch typ {
+		case 0x0000: // server_name
+			sni = "d"
+			continue
+		case 0x0010: // application_layer_protocol_negotiation
+			if len(data) >= 3 && int(data[2]) > 0 && len(data) >= 3+int(data[2]) {
+				first := data[3 : 3+int(data[2])]
+				alpn = ja4ALPN(first)
+			}
+			continue
+		case 0x000d: // signature_algorithms
+			for i := 2; i+1 < len(data); i += 2 {
+				sigAlgs = append(sigAlgs, fmt.Sprintf("%04x", binary.BigEndian.Uint16(data[i:])))
+			}
+		case 0x002b: // supported_versions
+			for i := 1; i+1 < len(data); i += 2 {
+				if v := binary.BigEndian.Uint16(data[i:]); !isGREASE(v) && v > version {
+					version = v
+				}
+			}
+		}
+		extensions = append(extensions, fmt.Sprintf("%04x", typ))
+	}
+
+	versions := map[uint16]string{0x0304: "13", 0x0303: "12", 0x0302: "11", 0x0301: "10", 0x0300: "s3"}
+	a := fmt.Sprintf("q%s%s%02d%02d%s", cmp.Or(versions[version], "00"), sni, min(len(ciphers), 99), min(extCount, 99), alpn)
+
+	slices.Sort(ciphers)
+	slices.Sort(extensions)
+	c := strings.Join(extensions, ",")
+	if len(sigAlgs) > 0 {
+		c += "_" + strings.Join(sigAlgs, ",")
+	}
+	return a + "_" + ja4Hash(strings.Join(ciphers, ","), len(ciphers)) + "_" + ja4Hash(c, len(extensions))
+}
+
+// ja4ALPN returns the first and last character of an ALPN value, or of its
+// hex form when they are not alphanumeric.
+func ja4ALPN(v []byte) string {
+	alnum := func(b byte) bool {
+		return b >= '0' && b <= '9' || b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z'
+	}
+	first, last := v[0], v[len(v)-1]
+	if alnum(first) && alnum(last) {
+		return string([]byte{first, last})
+	}
+	h := hex.EncodeToString(v)
+	return h[:1] + h[len(h)-1:]
+}
+
+// ja4Hash returns the truncated SHA-256 of a JA4 list, or zeros when empty.
+func ja4Hash(s string, n int) string {
+	if n == 0 {
+		return "000000000000"
+	}
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:6])
+}
@@ -0,0 +1,216 @@
+package handler
+
+import (
+	"bufio"
+	"crypto/tls"
+	"encoding/json"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"quic-relay/internal/pcap"
+)
+
+// testClientHello returns the ClientHello handshake message crypto/tls sends.
+func testClientHello(t *testing.T, sni string, alpn ...string) []byte {
+	t.Helper()
+	c, s := net.Pipe()
+	defer s.Close()
+	go tls.Client(c, &tls.Config{ServerName: sni, NextProtos: alpn, MinVersion: tls.VersionTLS13}).Handshake()
+	var hdr [5]byte
+	if _, err := io.ReadFull(s, hdr[:]); err != nil {
+		t.Fatal(err)
+	}
+	msg := make([]byte, int(hdr[3])<<8|int(hdr[4]))
+	if _, err := io.ReadFull(s, msg); err != nil {
+		t.Fatal(err)
+	}
+	c.Close()
+	return msg
+}
+
+func TestNewHoneypotHandler_Config(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr string
+	}{
+		{"dir", `{"dir": "` + dir + `"}`, ""},
+		{"limits", `{"dir": "` + dir + `", "max_packets": 4, "max_capture_mb": 1, "list_for": 60}`, ""},
+		{"no dir", `{}`, "'dir' is required"},
+		{"negative", `{"dir": "` + dir + `", "max_packets": -1}`, "must be >= 0"},
+		{"split", `{"dir": "` + dir + `", "split": "route"}`, "unknown split"},
+		{"dir is a file", `{"dir": "` + filepath.Join(dir, "hellos.jsonl") + `"}`, "not a directory"},
+	}
+	for _, tt := range tests {
+		h, err := NewHoneypotHandler(json.RawMessage(tt.cfg))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			h.(*HoneypotHandler).Close()
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestHoneypot_Capture(t *testing.T) {
+	dir := t.TempDir()
+	raw, err := NewHoneypotHandler(json.RawMessage(`{"dir": "` + dir + `", "max_packets": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := raw.(*HoneypotHandler)
+
+	// Other connections pass
+	if res := h.OnConnect(&Context{}); res.Action != Continue {
+		t.Fatalf("OnConnect without honeypot route = %v, want Continue", res.Action)
+	}
+
+	hello := testClientHello(t, "admin.example", "h3")
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 77), Port: 40000}
+	ctx := &Context{ClientAddr: client, Hello: &ClientHello{Raw: hello, SNI: "admin.example", ALPNProtocols: []string{"h3"}}}
+	ctx.InitialPacket = []byte{0xc3, 1, 2, 3}
+	ctx.Set(HoneypotKey, "*")
+	if res := h.OnConnect(ctx); res.Action != Handled || ctx.Session == nil || ctx.InitialPacket != nil {
+		t.Fatalf("OnConnect = %v, session %v", res.Action, ctx.Session)
+	}
+	for range 2 {
+		if res := h.OnPacket(ctx, []byte{0x40, 9}, Inbound); res.Action != Handled {
+			t.Errorf("OnPacket = %v, want Handled", res.Action)
+		}
+	}
+	h.OnDisconnect(ctx)
+	st := h.Stats()
+	if !ctx.Session.IsClosed() || st.Connections != 1 || st.Packets != 2 || st.Truncated != 1 {
+		t.Errorf("stats = %+v, session closed = %v", st, ctx.Session.IsClosed())
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Packets are in the capture
+	f, err := os.Open(st.Capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []pcap.Datagram
+	for {
+		d, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		got = append(got, d)
+	}
+	if len(got) != 2 || got[0].Src.String() != "192.0.2.77:40000" || got[0].Payload[0] != 0xc3 || got[1].Payload[0] != 0x40 {
+		t.Errorf("captured %+v", got)
+	}
+
+	// The hit and its fingerprint are in hellos.jsonl
+	hf, err := os.Open(filepath.Join(dir, "hellos.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hf.Close()
+	sc := bufio.NewScanner(hf)
+	var hit HoneypotHit
+	if !sc.Scan() || json.Unmarshal(sc.Bytes(), &hit) != nil {
+		t.Fatal("no hit recorded")
+	}
+	if hit.Source != "192.0.2.77:40000" || hit.Route != "*" || hit.SNI != "admin.example" || hit.JA4 != ja4(hello) || len(hit.Hello) != len(hello) {
+		t.Errorf("hit = %+v", hit)
+	}
+	if recent := h.Recent(); len(recent) != 1 || recent[0].JA4 != hit.JA4 {
+		t.Errorf("recent = %+v", recent)
+	}
+
+	// The source is listed for the reputation handler
+	if !honeypotListed(netip.MustParseAddr("192.0.2.77"), time.Now()) {
+		t.Error("source not listed")
+	}
+	if honeypotListed(netip.MustParseAddr("192.0.2.77"), time.Now().Add(2*time.Hour)) {
+		t.Error("source listed after list_for")
+	}
+	rep := newTestReputation(t, `{}`)
+	listHoneypotSource(netip.MustParseAddr("192.0.2.78"), time.Now().Add(time.Minute))
+	if res := rep.OnConnect(reputationCtx("192.0.2.78")); res.Action != Drop {
+		t.Errorf("reputation of a honeypot source = %v, want Drop", res.Action)
+	}
+}
+
+func TestHoneypot_Split(t *testing.T) {
+	dir := t.TempDir()
+	raw, err := NewHoneypotHandler(json.RawMessage(`{"dir": "` + dir + `", "split": "tenant"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := raw.(*HoneypotHandler)
+	for i, tenant := range []string{"acme", "../globex", ""} {
+		ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.IPv4(198, 51, 100, byte(i)), Port: 40000}, InitialPacket: []byte{0xc3}}
+		ctx.Set(HoneypotKey, "*")
+		if tenant != "" {
+			ctx.Set(TenantKey, tenant)
+		}
+		h.OnConnect(ctx)
+	}
+	if st := h.Stats(); st.Packets != 3 || filepath.Dir(st.Capture) != dir {
+		t.Errorf("stats = %+v", st)
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// One connection in each partition and in the top directory
+	for _, sub := range []string{"tenant=acme", "tenant=_._globex", "."} {
+		hellos, err := os.ReadFile(filepath.Join(dir, sub, "hellos.jsonl"))
+		if err != nil || strings.Count(string(hellos), "\n") != 1 {
+			t.Errorf("%s: hellos.jsonl %q, %v", sub, hellos, err)
+		}
+		if captures, _ := filepath.Glob(filepath.Join(dir, sub, "honeypot-*.pcap")); len(captures) != 1 {
+			t.Errorf("%s: captures %v", sub, captures)
+		}
+	}
+}
+
+func TestJA4(t *testing.T) {
+	hello := testClientHello(t, "play.example", "hytale")
+	got := ja4(hello)
+	if !regexp.MustCompile(`^q13d\d{4}he_[0-9a-f]{12}_[0-9a-f]{12}$`).MatchString(got) {
+		t.Fatalf("ja4 = %q", got)
+	}
+	if ja4(append(hello, 0x16, 0x03)) != got {
+		t.Error("trailing CRYPTO data changed the fingerprint")
+	}
+	// The SNI value does not matter, its presence does
+	if other := ja4(testClientHello(t, "other.example", "hytale")); other != got {
+		t.Errorf("ja4 with another SNI = %q, want %q", other, got)
+	}
+	if ip := ja4(testClientHello(t, "192.0.2.1", "hytale")); !strings.HasPrefix(ip, "q13i") {
+		t.Errorf("ja4 without SNI = %q", ip)
+	}
+	if none := ja4(testClientHello(t, "play.example")); none[8:10] != "00" {
+		t.Errorf("ja4 without ALPN = %q", none)
+	}
+	if ja4([]byte{0x02, 0, 0, 1, 0}) != "" || ja4(nil) != "" {
+		t.Error("fingerprint of a non-ClientHello")
+	}
+}
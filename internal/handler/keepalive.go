@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// keepAlivesSent is process-wide so it survives handler chain reloads.
+var keepAlivesSent atomic.Uint64
+
+// KeepAlivesSent returns how many keep-alives were sent to clients.
+func KeepAlivesSent() uint64 {
+	return keepAlivesSent.Load()
+}
+
+// KeepAliveConfig configures keep-alive packets the forwarder sends to clients
+// of idle sessions, so NAT bindings in front of them do not expire.
+type KeepAliveConfig struct {
+	Interval int    `json:"interval,omitempty"` // Seconds without traffic before a keep-alive (0 = off)
+	Payload  string `json:"payload,omitempty"`  // Hex payload (default: "00", which QUIC clients discard)
+
+	payload []byte
+}
+
+func (c *KeepAliveConfig) validate() error {
+	if c.Interval < 0 {
+		return errors.New("keepalive interval must not be negative")
+	}
+	if c.Payload == "" {
+		c.Payload = "00"
+	}
+	p, err := hex.DecodeString(c.Payload)
+	if err != nil {
+		return fmt.Errorf("invalid keepalive payload: %w", err)
+	}
+	if len(p) == 0 || len(p) > 1200 {
+		return errors.New("keepalive payload must be 1 to 1200 bytes")
+	}
+	c.payload = p
+	return nil
+}
+
+// enabled reports whether keep-alives are sent.
+func (c *KeepAliveConfig) enabled() bool {
+	return c.Interval > 0
+}
+
+// due returns when the session needs its next keep-alive: one interval after
+// the last packet in either direction or the last keep-alive, whichever is later.
+func (c *KeepAliveConfig) due(session *Session, sent time.Time) time.Time {
+	last := time.Unix(session.LastActivity.Load(), 0)
+	if sent.After(last) {
+		last = sent
+	}
+	return last.Add(time.Duration(c.Interval) * time.Second)
+}
+
+// send writes a keep-alive to the session's client. Keep-alives do not count
+// as activity, so sessions still expire when both sides stay silent.
+func (c *KeepAliveConfig) send(ctx *Context, session *Session) {
+	if _, err := ctx.ProxyConn.WriteToUDP(c.payload, session.ClientAddr()); err != nil {
+		sessionLog(ctx, forwarderLog).Debugf("session=%d: keep-alive failed: %v", session.ID, err)
+		return
+	}
+	keepAlivesSent.Add(1)
+}
@@ -0,0 +1,409 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"quic-relay/internal/logging"
+)
+
+var latencyLog = logging.ForHandler("latency-router")
+
+func init() {
+	Register("latency-router", NewLatencyRouterHandler)
+}
+
+// Probe methods.
+const (
+	ProbeQUIC = "quic" // Version negotiation round trip (any QUIC server answers)
+	ProbeUDP  = "udp"  // Send a payload and wait for any reply (echo services)
+)
+
+// quicProbeVersion is a reserved version (RFC 9000 Section 15) that forces version negotiation.
+const quicProbeVersion = 0x1a2a3a4a
+
+// rttSmoothing is the EWMA weight of a new RTT sample.
+const rttSmoothing = 0.3
+
+// LatencyRouterConfig is the configuration for the latency-router handler.
+type LatencyRouterConfig struct {
+	Backends       []string `json:"backends"`
+	Probe          string   `json:"probe,omitempty"`           // "quic" (default) or "udp"
+	ProbePayload   string   `json:"probe_payload,omitempty"`   // Hex payload for udp probes (default: "ping")
+	Interval       int      `json:"interval,omitempty"`        // Seconds between probe rounds (default: 5)
+	TimeoutMs      int      `json:"timeout_ms,omitempty"`      // Probe timeout (default: 1000)
+	Hysteresis     int      `json:"hysteresis,omitempty"`      // Percent a backend must be faster to take over (default: 20)
+	UnhealthyAfter int      `json:"unhealthy_after,omitempty"` // Consecutive failures before a backend is skipped (default: 3)
+}
+
+// latencyBackend is the probe state of one candidate.
+type latencyBackend struct {
+	addr     string
+	rtt      time.Duration // Smoothed RTT
+	failures int
+	probed   bool // At least one successful probe
+}
+
+func (b *latencyBackend) healthy(threshold int) bool {
+	return b.probed && b.failures < threshold
+}
+
+// LatencyRouterHandler continuously probes candidate backends and routes new
+// connections to the one with the lowest smoothed RTT. The selected backend
+// only changes when another one is faster by the hysteresis margin or the
+// current one becomes unhealthy, to avoid flapping between similar backends.
+type LatencyRouterHandler struct {
+	backends       []*latencyBackend
+	probe          func(addr string) (time.Duration, error)
+	interval       time.Duration
+	timeout        time.Duration
+	payload        []byte
+	hysteresis     float64
+	unhealthyAfter int
+	fallback       *backendPool // Used until the first probe round completes
+
+	mu      sync.RWMutex
+	current *latencyBackend
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewLatencyRouterHandler creates a new latency router and starts probing.
+func NewLatencyRouterHandler(raw json.RawMessage) (Handler, error) {
+	h, err := newLatencyRouter(raw)
+	if err != nil {
+		return nil, err
+	}
+	go h.run()
+	return h, nil
+}
+
+// newLatencyRouter parses the config without starting the prober.
+func newLatencyRouter(raw json.RawMessage) (*LatencyRouterHandler, error) {
+	var cfg LatencyRouterConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid latency-router config: %w", err)
+		}
+	}
+	if len(cfg.Backends) == 0 {
+		return nil, errors.New("latency-router requires 'backends'")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5
+	}
+	if cfg.TimeoutMs <= 0 {
+		cfg.TimeoutMs = 1000
+	}
+	if cfg.Hysteresis == 0 {
+		cfg.Hysteresis = 20
+	}
+	if cfg.Hysteresis < 0 || cfg.Hysteresis >= 100 {
+		return nil, errors.New("latency-router: hysteresis must be between 0 and 99")
+	}
+	if cfg.UnhealthyAfter <= 0 {
+		cfg.UnhealthyAfter = 3
+	}
+
+	h := &LatencyRouterHandler{
+		interval:       time.Duration(cfg.Interval) * time.Second,
+		timeout:        time.Duration(cfg.TimeoutMs) * time.Millisecond,
+		hysteresis:     float64(cfg.Hysteresis) / 100,
+		unhealthyAfter: cfg.UnhealthyAfter,
+		fallback:       poolFromStrings(cfg.Backends),
+		stop:           make(chan struct{}),
+	}
+	for _, addr := range cfg.Backends {
+		h.backends = append(h.backends, &latencyBackend{addr: addr})
+	}
+
+	switch cfg.Probe {
+	case "", ProbeQUIC:
+		h.probe = h.probeQUIC
+	case ProbeUDP:
+		h.payload = []byte("ping")
+		if cfg.ProbePayload != "" {
+			p, err := hex.DecodeString(cfg.ProbePayload)
+			if err != nil {
+				return nil, fmt.Errorf("latency-router: invalid probe_payload: %w", err)
+			}
+			h.payload = p
+		}
+		h.probe = h.probeUDP
+	default:
+		return nil, fmt.Errorf("latency-router: unknown probe %q", cfg.Probe)
+	}
+	return h, nil
+}
+
+// Name returns the handler name.
+func (h *LatencyRouterHandler) Name() string {
+	return "latency-router"
+}
+
+// Backends lists the probed backends.
+func (h *LatencyRouterHandler) Backends() []string {
+	addrs := make([]string, len(h.backends))
+	for i, b := range h.backends {
+		addrs[i] = b.addr
+	}
+	return addrs
+}
+
+// OnConnect routes to the currently selected backend.
+// A resumed backend is kept while it is healthy, even if no longer the fastest.
+// While the selected backend is draining, the fastest other healthy one is used.
+func (h *LatencyRouterHandler) OnConnect(ctx *Context) Result {
+	resumed := ctx.ResumedBackend()
+	h.mu.RLock()
+	current := h.current
+	if current != nil && Draining(current.addr) {
+		var alt *latencyBackend
+		for _, b := range h.backends {
+			if b.healthy(h.unhealthyAfter) && !Draining(b.addr) && (alt == nil || b.rtt < alt.rtt) {
+				alt = b
+			}
+		}
+		if alt != nil {
+			current = alt
+		}
+	}
+	for _, b := range h.backends {
+		if b.addr == resumed && b.healthy(h.unhealthyAfter) {
+			current = b
+			break
+		}
+	}
+	h.mu.RUnlock()
+
+	if current != nil {
+		ctx.Set("backend", current.addr)
+		return Result{Action: Continue}
+	}
+	if h.probedAny() {
+		return Result{Action: Drop, Error: errors.New("latency-router: no healthy backend")}
+	}
+	ctx.Set("backend", h.fallback.pick(ctx.ClientAddr))
+	return Result{Action: Continue}
+}
+
+// OnPacket passes through.
+func (h *LatencyRouterHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect does nothing.
+func (h *LatencyRouterHandler) OnDisconnect(ctx *Context) {}
+
+// Close stops probing.
+func (h *LatencyRouterHandler) Close() error {
+	h.closeOnce.Do(func() { close(h.stop) })
+	return nil
+}
+
+// run probes all backends every interval until closed.
+func (h *LatencyRouterHandler) run() {
+	ticker := time.NewTicker(h.interval)
+	defer ticker.Stop()
+	for {
+		h.probeAll()
+		select {
+		case <-h.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probeAll probes every backend concurrently and updates the selection.
+func (h *LatencyRouterHandler) probeAll() {
+	type sample struct {
+		rtt time.Duration
+		err error
+	}
+	samples := make([]sample, len(h.backends))
+	var wg sync.WaitGroup
+	for i, b := range h.backends {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rtt, err := h.probe(b.addr)
+			samples[i] = sample{rtt, err}
+		}()
+	}
+	wg.Wait()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for i, b := range h.backends {
+		s := samples[i]
+		if s.err != nil {
+			b.failures++
+			if b.failures == h.unhealthyAfter {
+				latencyLog.Warnf("%s unhealthy: %v", b.addr, s.err)
+			}
+			continue
+		}
+		if b.failures >= h.unhealthyAfter {
+			latencyLog.Printf("%s healthy again (rtt %v)", b.addr, s.rtt)
+		}
+		b.failures = 0
+		if !b.probed {
+			b.rtt = s.rtt
+			b.probed = true
+		} else {
+			b.rtt = time.Duration(rttSmoothing*float64(s.rtt) + (1-rttSmoothing)*float64(b.rtt))
+		}
+	}
+	h.selectLocked()
+}
+
+// selectLocked picks the lowest-RTT healthy backend, applying hysteresis.
+func (h *LatencyRouterHandler) selectLocked() {
+	var best *latencyBackend
+	for _, b := range h.backends {
+		if b.healthy(h.unhealthyAfter) && (best == nil || b.rtt < best.rtt) {
+			best = b
+		}
+	}
+
+	cur := h.current
+	switch {
+	case best == nil:
+		h.current = nil
+	case cur == nil || !cur.healthy(h.unhealthyAfter):
+		h.current = best
+	case best != cur && float64(best.rtt) < float64(cur.rtt)*(1-h.hysteresis):
+		h.current = best
+	}
+	if h.current != cur && h.current != nil {
+		latencyLog.Printf("selected %s (rtt %v)", h.current.addr, h.current.rtt)
+	}
+}
+
+func (h *LatencyRouterHandler) probedAny() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for _, b := range h.backends {
+		if b.probed || b.failures > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// roundTrip sends pkt to addr and measures the time until a reply passes check.
+func (h *LatencyRouterHandler) roundTrip(addr string, pkt []byte, check func([]byte) bool) (time.Duration, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	deadline := time.Now().Add(h.timeout)
+	conn.SetDeadline(deadline)
+	start := time.Now()
+	if _, err := conn.Write(pkt); err != nil {
+		return 0, err
+	}
+	buf := make([]byte, 1500)
+	for {
+		n, err := conn.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		if check(buf[:n]) {
+			return time.Since(start), nil
+		}
+	}
+}
+
+// probeUDP measures the round trip of the configured payload.
+func (h *LatencyRouterHandler) probeUDP(addr string) (time.Duration, error) {
+	return h.roundTrip(addr, h.payload, func([]byte) bool { return true })
+}
+
+// probeQUIC sends an Initial with a reserved version; QUIC servers must reply
+// with a Version Negotiation packet without allocating connection state.
+func (h *LatencyRouterHandler) probeQUIC(addr string) (time.Duration, error) {
+	pkt, dcid := buildQUICVersionProbe()
+	return h.roundTrip(addr, pkt, func(resp []byte) bool {
+		// Version Negotiation: long header, version 0, our DCID echoed as SCID
+		if len(resp) < 7 || resp[0]&0x80 == 0 || binary.BigEndian.Uint32(resp[1:5]) != 0 {
+			return false
+		}
+		dcidLen := int(resp[5])
+		if len(resp) < 7+dcidLen {
+			return false
+		}
+		scidLen := int(resp[6+dcidLen])
+		scid := resp[7+dcidLen : min(len(resp), 7+dcidLen+scidLen)]
+		return string(scid) == string(dcid)
+	})
+}
+
+// buildQUICVersionProbe builds a padded long header packet with a reserved version.
+func buildQUICVersionProbe() ([]byte, []byte) {
+	dcid := make([]byte, 8)
+	scid := make([]byte, 8)
+	rand.Read(dcid)
+	rand.Read(scid)
+
+	pkt := make([]byte, 0, 1200)
+	pkt = append(pkt, 0xc0)
+	pkt = binary.BigEndian.AppendUint32(pkt, quicProbeVersion)
+	pkt = append(pkt, byte(len(dcid)))
+	pkt = append(pkt, dcid...)
+	pkt = append(pkt, byte(len(scid)))
+	pkt = append(pkt, scid...)
+	pkt = pkt[:1200] // Servers ignore Initials below 1200 bytes
+	return pkt, dcid
+}
+
+// LatencyBackendStatus is a snapshot of one candidate for the admin API.
+type LatencyBackendStatus struct {
+	Backend  string  `json:"backend"`
+	RTTMs    float64 `json:"rtt_ms"`
+	Healthy  bool    `json:"healthy"`
+	Failures int     `json:"failures"`
+	Selected bool    `json:"selected"`
+}
+
+// Status returns the probe state of all candidates.
+func (h *LatencyRouterHandler) Status() []LatencyBackendStatus {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	out := make([]LatencyBackendStatus, len(h.backends))
+	for i, b := range h.backends {
+		out[i] = LatencyBackendStatus{
+			Backend:  b.addr,
+			RTTMs:    float64(b.rtt.Microseconds()) / 1000,
+			Healthy:  b.healthy(h.unhealthyAfter),
+			Failures: b.failures,
+			Selected: b == h.current,
+		}
+	}
+	return out
+}
+
+// ServeAdmin lists the probe state of all candidates.
+func (h *LatencyRouterHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, h.Status())
+}
@@ -0,0 +1,182 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewLatencyRouter_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"bad json", `{`, "invalid latency-router config"},
+		{"no backends", `{}`, "requires 'backends'"},
+		{"bad probe", `{"backends": ["a:1"], "probe": "icmp"}`, "unknown probe"},
+		{"bad payload", `{"backends": ["a:1"], "probe": "udp", "probe_payload": "zz"}`, "invalid probe_payload"},
+		{"bad hysteresis", `{"backends": ["a:1"], "hysteresis": 100}`, "hysteresis"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := newLatencyRouter(json.RawMessage(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+// fakeProbe returns configurable RTTs per backend.
+type fakeProbe map[string]time.Duration
+
+func (f fakeProbe) probe(addr string) (time.Duration, error) {
+	rtt, ok := f[addr]
+	if !ok || rtt < 0 {
+		return 0, errors.New("timeout")
+	}
+	return rtt, nil
+}
+
+func selected(t *testing.T, h *LatencyRouterHandler) string {
+	t.Helper()
+	ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}}
+	res := h.OnConnect(ctx)
+	if res.Action != Continue {
+		return ""
+	}
+	return ctx.GetString("backend")
+}
+
+func TestLatencyRouter_Selection(t *testing.T) {
+	h, err := newLatencyRouter(json.RawMessage(`{"backends": ["a:1", "b:1"], "hysteresis": 20, "unhealthy_after": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rtts := fakeProbe{"a:1": 50 * time.Millisecond, "b:1": 40 * time.Millisecond}
+	h.probe = rtts.probe
+
+	// Before any probe round, fall back to round-robin
+	if got := selected(t, h); got != "a:1" && got != "b:1" {
+		t.Fatalf("fallback backend = %q", got)
+	}
+
+	h.probeAll()
+	if got := selected(t, h); got != "b:1" {
+		t.Fatalf("backend = %q, want b:1", got)
+	}
+
+	// a becomes slightly faster: within hysteresis, keep b
+	rtts["a:1"] = 10 * time.Millisecond
+	rtts["b:1"] = 40 * time.Millisecond
+	h.probeAll() // a smoothed: 38ms
+	if got := selected(t, h); got != "b:1" {
+		t.Errorf("backend = %q, want b:1 (hysteresis)", got)
+	}
+	for i := 0; i < 5; i++ {
+		h.probeAll()
+	}
+	if got := selected(t, h); got != "a:1" {
+		t.Errorf("backend = %q, want a:1 after sustained improvement", got)
+	}
+
+	// a fails: switch to b once a is unhealthy
+	rtts["a:1"] = -1
+	h.probeAll()
+	if got := selected(t, h); got != "a:1" {
+		t.Errorf("backend = %q, want a:1 after single failure", got)
+	}
+	h.probeAll()
+	if got := selected(t, h); got != "b:1" {
+		t.Errorf("backend = %q, want b:1 after a unhealthy", got)
+	}
+
+	// All unhealthy: drop
+	rtts["b:1"] = -1
+	h.probeAll()
+	h.probeAll()
+	if got := selected(t, h); got != "" {
+		t.Errorf("backend = %q, want drop", got)
+	}
+}
+
+func TestLatencyRouter_ProbeUDP(t *testing.T) {
+	echo, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := echo.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteToUDP(buf[:n], addr)
+		}
+	}()
+
+	h, err := newLatencyRouter(json.RawMessage(`{"backends": ["` + echo.LocalAddr().String() + `"], "probe": "udp", "timeout_ms": 500}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := h.probe(echo.LocalAddr().String()); err != nil {
+		t.Errorf("udp probe: %v", err)
+	}
+}
+
+func TestLatencyRouter_ProbeQUIC(t *testing.T) {
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	// Minimal version negotiation responder
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := server.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			pkt := buf[:n]
+			if n < 1200 || binary.BigEndian.Uint32(pkt[1:5]) != quicProbeVersion {
+				continue
+			}
+			dcid := pkt[6 : 6+pkt[5]]
+			scid := pkt[7+len(dcid) : 7+len(dcid)+int(pkt[6+len(dcid)])]
+			vn := []byte{0x80, 0, 0, 0, 0, byte(len(scid))}
+			vn = append(vn, scid...)
+			vn = append(vn, byte(len(dcid)))
+			vn = append(vn, dcid...)
+			vn = binary.BigEndian.AppendUint32(vn, 1)
+			server.WriteToUDP(vn, addr)
+		}
+	}()
+
+	h, err := newLatencyRouter(json.RawMessage(`{"backends": ["` + server.LocalAddr().String() + `"], "timeout_ms": 500}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.probeAll()
+	st := h.Status()
+	if !st[0].Healthy || !st[0].Selected {
+		t.Errorf("status = %+v, want healthy and selected", st[0])
+	}
+}
+
+func TestLatencyRouter_Close(t *testing.T) {
+	h, err := NewLatencyRouterHandler(json.RawMessage(`{"backends": ["127.0.0.1:1"], "timeout_ms": 10}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	NewChain(h).Close()
+	h.(*LatencyRouterHandler).Close() // idempotent
+}
@@ -2,9 +2,12 @@ package handler
 
 import (
 	"encoding/json"
-	"log"
+
+	"quic-relay/internal/logging"
 )
 
+var sniLog = logging.ForHandler("sni")
+
 func init() {
 	Register("logsni", NewLogSNIHandler)
 }
@@ -26,7 +29,7 @@ func (h *LogSNIHandler) OnConnect(ctx *Context) Result {
 	if ctx.Hello != nil {
 		sni = ctx.Hello.SNI
 	}
-	log.Printf("[sni] %s", sni)
+	sniLog.Printf("%s", sni)
 	return Result{Action: Continue}
 }
 
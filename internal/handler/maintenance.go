@@ -0,0 +1,194 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var maintenanceLog = logging.ForHandler("maintenance")
+
+func init() {
+	Register("maintenance", NewMaintenanceHandler)
+}
+
+// Refusal behaviors for new connections in maintenance mode.
+const (
+	MaintenanceRefuseClose = "close" // Send CONNECTION_CLOSE with the reason string (default)
+	MaintenanceRefuseDrop  = "drop"  // Drop silently; clients time out
+)
+
+// connectionRefused is the QUIC CONNECTION_REFUSED transport error code.
+const connectionRefused = 0x02
+
+// MaintenanceConfig is the configuration for the maintenance handler.
+type MaintenanceConfig struct {
+	Enabled bool   `json:"enabled"`          // Start in maintenance mode
+	Reason  string `json:"reason,omitempty"` // Reason phrase sent to clients
+	Refuse  string `json:"refuse,omitempty"` // "close" (default) or "drop"
+}
+
+// MaintenanceState is the runtime state of maintenance mode.
+type MaintenanceState struct {
+	Enabled bool   `json:"enabled"`
+	Reason  string `json:"reason,omitempty"`
+}
+
+// maintenanceOverride holds state set via the admin API.
+// It is package-level so it survives handler chain reloads.
+var maintenanceOverride atomic.Pointer[MaintenanceState]
+
+// MaintenanceOverride returns the state set via the admin API, or nil if
+// the config is in effect.
+func MaintenanceOverride() *MaintenanceState {
+	return maintenanceOverride.Load()
+}
+
+// SetMaintenanceOverride replaces the state set via the admin API, as when
+// a config snapshot is rolled back. A nil st returns to the config.
+func SetMaintenanceOverride(st *MaintenanceState) {
+	maintenanceOverride.Store(st)
+	if st != nil {
+		noteMaintenance(st.Enabled)
+	}
+}
+
+// maintenanceOn is whether maintenance mode was on when last seen, and
+// maintenanceEnded when it last went off (Unix nanoseconds, 0 = never).
+var (
+	maintenanceOn    atomic.Bool
+	maintenanceEnded atomic.Int64
+)
+
+// noteMaintenance records the current maintenance mode, and when it ends.
+func noteMaintenance(on bool) {
+	if maintenanceOn.Swap(on) && !on {
+		maintenanceEnded.Store(time.Now().UnixNano())
+	}
+}
+
+// MaintenanceEndedAt returns when maintenance mode last ended, or the zero
+// time if it never did. The end is noticed when it is switched off through
+// the admin API, or by the first connection after a reload turned it off.
+func MaintenanceEndedAt() time.Time {
+	if ns := maintenanceEnded.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+
+// MaintenanceHandler refuses new connections while maintenance mode is on.
+// Existing sessions are not affected and finish normally.
+type MaintenanceHandler struct {
+	configured MaintenanceState
+	refuse     string
+}
+
+// NewMaintenanceHandler creates a new maintenance handler.
+func NewMaintenanceHandler(raw json.RawMessage) (Handler, error) {
+	var cfg MaintenanceConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid maintenance config: %w", err)
+		}
+	}
+	switch cfg.Refuse {
+	case "":
+		cfg.Refuse = MaintenanceRefuseClose
+	case MaintenanceRefuseClose, MaintenanceRefuseDrop:
+	default:
+		return nil, fmt.Errorf("maintenance: unknown refuse mode %q", cfg.Refuse)
+	}
+	if cfg.Reason == "" {
+		cfg.Reason = "server under maintenance"
+	}
+	return &MaintenanceHandler{
+		configured: MaintenanceState{Enabled: cfg.Enabled, Reason: cfg.Reason},
+		refuse:     cfg.Refuse,
+	}, nil
+}
+
+// Name returns the handler name.
+func (h *MaintenanceHandler) Name() string {
+	return "maintenance"
+}
+
+// state returns the effective state: admin override if set, otherwise config.
+func (h *MaintenanceHandler) state() MaintenanceState {
+	if o := maintenanceOverride.Load(); o != nil {
+		st := *o
+		if st.Reason == "" {
+			st.Reason = h.configured.Reason
+		}
+		return st
+	}
+	return h.configured
+}
+
+// OnConnect refuses the connection if maintenance mode is on.
+func (h *MaintenanceHandler) OnConnect(ctx *Context) Result {
+	st := h.state()
+	noteMaintenance(st.Enabled)
+	if !st.Enabled {
+		return Result{Action: Continue}
+	}
+	if h.refuse == MaintenanceRefuseClose {
+		if err := ctx.Refuse(connectionRefused, st.Reason); err != nil {
+			maintenanceLog.Debugf("failed to send CONNECTION_CLOSE to %s: %v", privacy.Addr(ctx.ClientAddr), err)
+		}
+	}
+	return Result{Action: Drop, Error: errors.New("maintenance mode")}
+}
+
+// OnPacket passes through - existing sessions are allowed to finish.
+func (h *MaintenanceHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect does nothing.
+func (h *MaintenanceHandler) OnDisconnect(ctx *Context) {}
+
+// ServeAdmin toggles maintenance mode at runtime.
+//
+//	GET    /   current state
+//	POST   /   {"enabled": true, "reason": "..."} - override config
+//	DELETE /   clear override, revert to config
+func (h *MaintenanceHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		writeAdminJSON(w, http.StatusOK, h.state())
+	case http.MethodPost, http.MethodPut:
+		var st MaintenanceState
+		if !readAdminJSON(w, r, &st) {
+			return
+		}
+		maintenanceOverride.Store(&st)
+		noteMaintenance(st.Enabled)
+		maintenanceLog.Printf("maintenance mode %s via admin API", onOff(st.Enabled))
+		writeAdminJSON(w, http.StatusOK, h.state())
+	case http.MethodDelete:
+		maintenanceOverride.Store(nil)
+		noteMaintenance(h.configured.Enabled)
+		maintenanceLog.Printf("maintenance override cleared, mode %s", onOff(h.configured.Enabled))
+		writeAdminJSON(w, http.StatusOK, h.state())
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+func onOff(b bool) string {
+	if b {
+		return "on"
+	}
+	return "off"
+}
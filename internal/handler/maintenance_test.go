@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMaintenance_Disabled(t *testing.T) {
+	h, err := NewMaintenanceHandler(json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	if result := h.OnConnect(&Context{}); result.Action != Continue {
+		t.Errorf("expected Continue, got %v", result.Action)
+	}
+}
+
+func TestMaintenance_RefusesWithReason(t *testing.T) {
+	h, err := NewMaintenanceHandler(json.RawMessage(`{"enabled": true, "reason": "back at 18:00"}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	var gotCode uint64
+	var gotReason string
+	ctx := &Context{SendConnectionClose: func(code uint64, reason string) error {
+		gotCode, gotReason = code, reason
+		return nil
+	}}
+
+	result := h.OnConnect(ctx)
+	if result.Action != Drop {
+		t.Errorf("expected Drop, got %v", result.Action)
+	}
+	if gotCode != connectionRefused || gotReason != "back at 18:00" {
+		t.Errorf("unexpected CONNECTION_CLOSE: code=%d reason=%q", gotCode, gotReason)
+	}
+	if h.OnPacket(ctx, []byte{0x40}, Inbound).Action != Continue {
+		t.Error("existing sessions should not be affected")
+	}
+}
+
+func TestMaintenance_SilentDrop(t *testing.T) {
+	h, err := NewMaintenanceHandler(json.RawMessage(`{"enabled": true, "refuse": "drop"}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	ctx := &Context{SendConnectionClose: func(uint64, string) error {
+		t.Error("CONNECTION_CLOSE should not be sent in drop mode")
+		return nil
+	}}
+	if result := h.OnConnect(ctx); result.Action != Drop {
+		t.Errorf("expected Drop, got %v", result.Action)
+	}
+}
+
+func TestMaintenance_InvalidRefuse(t *testing.T) {
+	if _, err := NewMaintenanceHandler(json.RawMessage(`{"refuse": "page"}`)); err == nil {
+		t.Error("expected error for unknown refuse mode")
+	}
+}
+
+func TestMaintenance_AdminToggle(t *testing.T) {
+	defer maintenanceOverride.Store(nil)
+
+	h, err := NewMaintenanceHandler(json.RawMessage(`{}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	ah := h.(AdminHandler)
+
+	rec := httptest.NewRecorder()
+	ah.ServeAdmin(rec, httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"enabled": true}`)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected 200, got %d: %s", rec.Code, rec.Body)
+	}
+	if h.OnConnect(&Context{}).Action != Drop {
+		t.Error("expected Drop after enabling via admin API")
+	}
+
+	// Override survives a chain reload (new handler instance)
+	reloaded, _ := NewMaintenanceHandler(json.RawMessage(`{}`))
+	if reloaded.OnConnect(&Context{}).Action != Drop {
+		t.Error("expected admin override to survive reload")
+	}
+
+	rec = httptest.NewRecorder()
+	ah.ServeAdmin(rec, httptest.NewRequest(http.MethodDelete, "/", nil))
+	if h.OnConnect(&Context{}).Action != Continue {
+		t.Error("expected Continue after clearing override")
+	}
+}
+
+func TestMaintenance_EndedAt(t *testing.T) {
+	on, err := NewMaintenanceHandler(json.RawMessage(`{"enabled": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	off, _ := NewMaintenanceHandler(json.RawMessage(`{}`))
+	before := MaintenanceEndedAt()
+
+	// Still on: nothing ended
+	on.OnConnect(&Context{SendConnectionClose: func(uint64, string) error { return nil }})
+	if got := MaintenanceEndedAt(); !got.Equal(before) {
+		t.Errorf("ended at %v while on", got)
+	}
+
+	// A reload turned it off: the next connection notices
+	off.OnConnect(&Context{})
+	ended := MaintenanceEndedAt()
+	if !ended.After(before) {
+		t.Fatalf("ended at %v, want after %v", ended, before)
+	}
+	off.OnConnect(&Context{})
+	if got := MaintenanceEndedAt(); !got.Equal(ended) {
+		t.Error("end moved without maintenance mode in between")
+	}
+}
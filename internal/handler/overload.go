@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"syscall"
+	"time"
+)
+
+// Overload policies for backend -> client packets when a session's client
+// queue is full.
+const (
+	OverloadDropNewest = "drop_newest" // Discard the packet that does not fit
+	OverloadDropOldest = "drop_oldest" // Discard the oldest queued packet to make room
+	OverloadPause      = "pause"       // Stop reading from the backend until there is room
+)
+
+// OverloadConfig configures the per-session client queue of the forwarder.
+type OverloadConfig struct {
+	Policy string `json:"policy,omitempty"` // drop_newest (default), drop_oldest or pause
+	Queue  int    `json:"queue,omitempty"`  // Packets queued per session (default: 256)
+}
+
+func (c *OverloadConfig) validate() error {
+	switch c.Policy {
+	case "":
+		c.Policy = OverloadDropNewest
+	case OverloadDropNewest, OverloadDropOldest, OverloadPause:
+	default:
+		return fmt.Errorf("unknown overload policy %q", c.Policy)
+	}
+	if c.Queue <= 0 {
+		c.Queue = 256
+	}
+	return nil
+}
+
+// overloadCounters are process-wide so they survive handler chain reloads.
+var overloadCounters struct {
+	droppedNewest atomic.Uint64 // Packets discarded by drop_newest
+	droppedOldest atomic.Uint64 // Packets discarded by drop_oldest
+	pauses        atomic.Uint64 // Times a backend reader waited for room
+	socketFull    atomic.Uint64 // Packets the kernel refused (ENOBUFS/EAGAIN)
+	oversize      atomic.Uint64 // Packets above max_datagram, either direction
+	tooLarge      atomic.Uint64 // Packets above the path MTU to the client (EMSGSIZE)
+	overBandwidth atomic.Uint64 // Packets above a session's bandwidth_kbps, either direction
+	delayed       atomic.Uint64 // Packets held by a handler's Delay result
+	delayDropped  atomic.Uint64 // Delayed packets beyond a session's limit of held packets
+}
+
+// OverloadStats is a snapshot of overload counters.
+type OverloadStats struct {
+	DroppedNewest uint64 `json:"dropped_newest"`
+	DroppedOldest uint64 `json:"dropped_oldest"`
+	Pauses        uint64 `json:"pauses"`
+	SocketFull    uint64 `json:"socket_full"`
+	Oversize      uint64 `json:"oversize"`
+	TooLarge      uint64 `json:"too_large"`
+	OverBandwidth uint64 `json:"over_bandwidth"`
+	Delayed       uint64 `json:"delayed"`
+	DelayDropped  uint64 `json:"delay_dropped"`
+}
+
+// GetOverloadStats returns current overload counters.
+func GetOverloadStats() OverloadStats {
+	return OverloadStats{
+		DroppedNewest: overloadCounters.droppedNewest.Load(),
+		DroppedOldest: overloadCounters.droppedOldest.Load(),
+		Pauses:        overloadCounters.pauses.Load(),
+		SocketFull:    overloadCounters.socketFull.Load(),
+		Oversize:      overloadCounters.oversize.Load(),
+		TooLarge:      overloadCounters.tooLarge.Load(),
+		OverBandwidth: overloadCounters.overBandwidth.Load(),
+		Delayed:       overloadCounters.delayed.Load(),
+		DelayDropped:  overloadCounters.delayDropped.Load(),
+	}
+}
+
+// queuedPacket is a pooled buffer holding n bytes for the client.
+type queuedPacket struct {
+	buf *[]byte
+	n   int
+}
+
+// clientQueue decouples reading from the backend from writing to the client,
+// so a slow client socket never blocks a session without bound.
+// push is called only by the backend reader; a writer goroutine drains the queue.
+type clientQueue struct {
+	ch      chan queuedPacket
+	policy  string
+	session *Session
+}
+
+func newClientQueue(cfg OverloadConfig, session *Session) *clientQueue {
+	return &clientQueue{
+		ch:      make(chan queuedPacket, cfg.Queue),
+		policy:  cfg.Policy,
+		session: session,
+	}
+}
+
+// push queues a packet, taking ownership of its buffer.
+func (q *clientQueue) push(p queuedPacket) {
+	select {
+	case q.ch <- p:
+		return
+	default:
+	}
+
+	switch q.policy {
+	case OverloadDropOldest:
+		for {
+			select {
+			case old := <-q.ch:
+				PutBuffer(old.buf)
+				overloadCounters.droppedOldest.Add(1)
+			default:
+			}
+			select {
+			case q.ch <- p:
+				return
+			default:
+			}
+		}
+	case OverloadPause:
+		overloadCounters.pauses.Add(1)
+		ticker := time.NewTicker(100 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case q.ch <- p:
+				return
+			case <-ticker.C:
+				if q.session.IsClosed() {
+					PutBuffer(p.buf)
+					return
+				}
+			}
+		}
+	default:
+		PutBuffer(p.buf)
+		overloadCounters.droppedNewest.Add(1)
+	}
+}
+
+// close ends the writer once the queued packets are sent.
+func (q *clientQueue) close() {
+	close(q.ch)
+}
+
+// run writes queued packets to the client until the queue is closed.
+// A full socket buffer drops the packet; other write errors end the session.
+func (q *clientQueue) run(ctx *Context) {
+	failed := false
+	for p := range q.ch {
+		if !failed && ctx.ProxyConn != nil {
+			_, err := ctx.ProxyConn.WriteToUDP((*p.buf)[:p.n], q.session.ClientAddr())
+			switch {
+			case err == nil:
+			case socketFull(err):
+				overloadCounters.socketFull.Add(1)
+			case messageTooLong(err):
+				// Larger than the path MTU; the backend's PMTU discovery backs off
+				overloadCounters.tooLarge.Add(1)
+				q.session.trace.Note("too_large", strconv.Itoa(p.n))
+			default:
+				forwarderLog.Warnf("write to client failed: %v", err)
+				ctx.DropWithReason(CloseClientError)
+				failed = true
+			}
+		}
+		PutBuffer(p.buf)
+	}
+}
+
+// socketFull reports whether a write failed because the socket buffer was full.
+func socketFull(err error) bool {
+	return errors.Is(err, syscall.ENOBUFS) || errors.Is(err, syscall.EAGAIN)
+}
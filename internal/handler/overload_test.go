@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func queued(b byte) queuedPacket {
+	buf := GetBufferSized(1)
+	(*buf)[0] = b
+	return queuedPacket{buf: buf, n: 1}
+}
+
+func drain(q *clientQueue) []byte {
+	var got []byte
+	for {
+		select {
+		case p := <-q.ch:
+			got = append(got, (*p.buf)[0])
+			PutBuffer(p.buf)
+		default:
+			return got
+		}
+	}
+}
+
+func TestClientQueue_Policies(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{OverloadDropNewest, "\x01\x02"},
+		{OverloadDropOldest, "\x03\x04"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.policy, func(t *testing.T) {
+			q := newClientQueue(OverloadConfig{Policy: tt.policy, Queue: 2}, &Session{})
+			for b := byte(1); b <= 4; b++ {
+				q.push(queued(b))
+			}
+			if got := string(drain(q)); got != tt.want {
+				t.Errorf("queued = %x, want %x", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientQueue_Pause(t *testing.T) {
+	before := GetOverloadStats().Pauses
+	q := newClientQueue(OverloadConfig{Policy: OverloadPause, Queue: 1}, &Session{})
+	q.push(queued(1))
+
+	done := make(chan struct{})
+	go func() {
+		q.push(queued(2))
+		close(done)
+	}()
+	select {
+	case <-done:
+		t.Fatal("push did not wait for room")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if p := <-q.ch; (*p.buf)[0] != 1 {
+		t.Fatalf("queued = %x, want 01", (*p.buf)[0])
+	}
+	<-done
+	if got := drain(q); len(got) != 1 || got[0] != 2 {
+		t.Errorf("queued = %x, want 02", got)
+	}
+	if GetOverloadStats().Pauses == before {
+		t.Error("pause not counted")
+	}
+
+	// A closed session releases a waiting reader
+	q.push(queued(3))
+	q.session.Close()
+	finished := make(chan struct{})
+	go func() {
+		q.push(queued(4))
+		close(finished)
+	}()
+	select {
+	case <-finished:
+	case <-time.After(time.Second):
+		t.Fatal("push blocked on closed session")
+	}
+}
+
+// blockingConn blocks writes until released and records written packets.
+type blockingConn struct {
+	release chan struct{}
+	err     error
+
+	mu      sync.Mutex
+	written []byte
+}
+
+func (c *blockingConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	<-c.release
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.written = append(c.written, b[0])
+	return len(b), c.err
+}
+
+func (c *blockingConn) LocalAddr() net.Addr { return &net.UDPAddr{} }
+
+func TestClientQueue_SlowClient(t *testing.T) {
+	conn := &blockingConn{release: make(chan struct{})}
+	ctx := &Context{ProxyConn: conn}
+	session := &Session{}
+	session.SetClientAddr(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1})
+	q := newClientQueue(OverloadConfig{Policy: OverloadDropNewest, Queue: 2}, session)
+
+	finished := make(chan struct{})
+	go func() {
+		q.run(ctx)
+		close(finished)
+	}()
+
+	// The reader never blocks on the stuck writer
+	pushed := make(chan struct{})
+	go func() {
+		for b := byte(1); b <= 10; b++ {
+			q.push(queued(b))
+		}
+		close(pushed)
+	}()
+	select {
+	case <-pushed:
+	case <-time.After(time.Second):
+		t.Fatal("push blocked on slow client")
+	}
+
+	close(conn.release)
+	q.close()
+	<-finished
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if len(conn.written) < 1 || len(conn.written) > 3 || conn.written[0] != 1 {
+		t.Errorf("written = %x, want the first packets only", conn.written)
+	}
+}
+
+func TestClientQueue_SocketFull(t *testing.T) {
+	before := GetOverloadStats().SocketFull
+	conn := &blockingConn{release: make(chan struct{}), err: &net.OpError{Op: "write", Err: syscall.ENOBUFS}}
+	close(conn.release)
+	ctx := &Context{ProxyConn: conn}
+	q := newClientQueue(OverloadConfig{Policy: OverloadDropNewest, Queue: 4}, &Session{})
+	q.push(queued(1))
+	q.push(queued(2))
+	q.close()
+	q.run(ctx)
+
+	if got := GetOverloadStats().SocketFull - before; got != 2 {
+		t.Errorf("socket_full = %d, want 2", got)
+	}
+	if ctx.CloseReason() != CloseUnknown {
+		t.Errorf("close reason = %v, want session kept", ctx.CloseReason())
+	}
+}
+
+func TestClientQueue_WriteError(t *testing.T) {
+	conn := &blockingConn{release: make(chan struct{}), err: &net.OpError{Op: "write", Err: syscall.EHOSTUNREACH}}
+	close(conn.release)
+	ctx := &Context{ProxyConn: conn}
+	q := newClientQueue(OverloadConfig{Policy: OverloadDropNewest, Queue: 4}, &Session{})
+	q.push(queued(1))
+	q.push(queued(2))
+	q.close()
+	q.run(ctx)
+
+	if ctx.CloseReason() != CloseClientError {
+		t.Errorf("close reason = %v, want client_error", ctx.CloseReason())
+	}
+	conn.mu.Lock()
+	defer conn.mu.Unlock()
+	if len(conn.written) != 1 {
+		t.Errorf("written = %x, want writes to stop after the failure", conn.written)
+	}
+}
+
+func TestForwarder_OverloadConfig(t *testing.T) {
+	h, err := NewForwarderHandler(json.RawMessage(`{"overload": {"policy": "drop_oldest"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := h.(*ForwarderHandler).overload; got.Policy != OverloadDropOldest || got.Queue != 256 {
+		t.Errorf("overload = %+v", got)
+	}
+	_, err = NewForwarderHandler(json.RawMessage(`{"overload": {"policy": "drop_all"}}`))
+	if err == nil || !strings.Contains(err.Error(), "unknown overload policy") {
+		t.Errorf("error = %v, want unknown overload policy", err)
+	}
+}
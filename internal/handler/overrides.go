@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// OverridesKey is the context key holding the *RouteOverrides of the route a
+// router picked. Handlers read their parameters through Overrides, so one
+// handler instance applies different settings per route.
+const OverridesKey = "overrides"
+
+// RouteOverrides are per-route values of parameters that handlers further
+// down the chain and the relay itself otherwise take from their own config.
+// Zero fields keep that setting.
+type RouteOverrides struct {
+	IdleTimeout   int    `json:"idle_timeout,omitempty"`   // Seconds a session may be idle (relay's session_timeout)
+	MaxDatagram   int    `json:"max_datagram,omitempty"`   // forwarder's max_datagram
+	Oversize      string `json:"oversize,omitempty"`       // forwarder's oversize
+	BandwidthKbps int    `json:"bandwidth_kbps,omitempty"` // forwarder's bandwidth_kbps
+}
+
+func (o *RouteOverrides) validate() error {
+	if o.IdleTimeout < 0 || o.BandwidthKbps < 0 {
+		return errors.New("overrides: idle_timeout and bandwidth_kbps must be >= 0")
+	}
+	if err := validateMaxDatagram(o.MaxDatagram); err != nil {
+		return fmt.Errorf("overrides: %w", err)
+	}
+	switch o.Oversize {
+	case "", OversizeDrop, OversizeTruncate:
+	default:
+		return fmt.Errorf("overrides: unknown oversize action %q", o.Oversize)
+	}
+	return nil
+}
+
+// Overrides returns the overrides of ctx's route, or the zero value.
+func Overrides(ctx *Context) RouteOverrides {
+	if o, ok := GetValue[*RouteOverrides](ctx, OverridesKey); ok && o != nil {
+		return *o
+	}
+	return RouteOverrides{}
+}
+
+// IdleTimeout returns how long ctx's session may be idle: the route's
+// idle_timeout, or def.
+func IdleTimeout(ctx *Context, def time.Duration) time.Duration {
+	if o := Overrides(ctx); o.IdleTimeout > 0 {
+		return time.Duration(o.IdleTimeout) * time.Second
+	}
+	return def
+}
+
+// bandwidthCap limits one session's traffic per direction with a token
+// bucket holding up to one second's worth of bytes.
+type bandwidthCap struct {
+	rate float64 // Bytes per second
+
+	mu     sync.Mutex
+	tokens [2]float64 // By Direction
+	last   [2]time.Time
+}
+
+func newBandwidthCap(kbps int) *bandwidthCap {
+	c := &bandwidthCap{rate: float64(kbps) * 1000 / 8}
+	c.tokens = [2]float64{c.burst(), c.burst()}
+	return c
+}
+
+// burst is the bucket size. It holds at least one datagram of any size, so
+// a low cap slows traffic down instead of blocking large datagrams forever.
+func (c *bandwidthCap) burst() float64 {
+	return max(c.rate, maxUDPPayload)
+}
+
+// allow reports whether n more bytes fit in direction dir at now.
+func (c *bandwidthCap) allow(dir Direction, n int, now time.Time) bool {
+	_, ok := c.reserve(dir, n, now, 0)
+	return ok
+}
+
+// reserve takes n bytes in direction dir at now and returns how long they
+// have to wait for the cap, or false if that is longer than maxWait. Bytes
+// waiting count against the cap, so later datagrams queue up behind them.
+func (c *bandwidthCap) reserve(dir Direction, n int, now time.Time, maxWait time.Duration) (time.Duration, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !c.last[dir].IsZero() {
+		c.tokens[dir] = min(c.tokens[dir]+c.rate*now.Sub(c.last[dir]).Seconds(), c.burst())
+	}
+	c.last[dir] = now
+	var wait time.Duration
+	if missing := float64(n) - c.tokens[dir]; missing > 0 {
+		wait = time.Duration(missing / c.rate * float64(time.Second))
+		if wait > maxWait {
+			return 0, false
+		}
+	}
+	c.tokens[dir] -= float64(n)
+	return wait, true
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRouteOverrides_SetOnConnect(t *testing.T) {
+	h, err := NewDynamicHandler(json.RawMessage(`{"routes": {
+		"play.example.com": {"backends": ["10.0.0.1:5520"], "overrides": {"idle_timeout": 30, "max_datagram": 1300, "bandwidth_kbps": 512}},
+		"other.example.com": "10.0.0.2:5520"
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &Context{Hello: &ClientHello{SNI: "play.example.com"}}
+	if r := h.OnConnect(ctx); r.Action != Continue {
+		t.Fatalf("OnConnect = %v", r.Action)
+	}
+	if got := Overrides(ctx); got != (RouteOverrides{IdleTimeout: 30, MaxDatagram: 1300, BandwidthKbps: 512}) {
+		t.Errorf("overrides = %+v", got)
+	}
+	if got := IdleTimeout(ctx, time.Minute); got != 30*time.Second {
+		t.Errorf("idle timeout = %v", got)
+	}
+	// The forwarder's own settings give way to the route's
+	cfg := DatagramLimitConfig{MaxDatagram: 1400, Oversize: OversizeTruncate, BandwidthKbps: 100}
+	if l := cfg.limit(ctx); l.max != 1300 || !l.truncate || l.bandwidth == nil || l.bandwidth.rate != 64000 {
+		t.Errorf("limit = %+v", l)
+	}
+
+	ctx = &Context{Hello: &ClientHello{SNI: "other.example.com"}}
+	h.OnConnect(ctx)
+	if got := Overrides(ctx); got != (RouteOverrides{}) {
+		t.Errorf("overrides of a plain route = %+v", got)
+	}
+	if got := IdleTimeout(ctx, time.Minute); got != time.Minute {
+		t.Errorf("idle timeout = %v", got)
+	}
+	if l := cfg.limit(ctx); l.max != 1400 || l.bandwidth.rate != 12500 {
+		t.Errorf("limit = %+v", l)
+	}
+}
+
+func TestRouteOverrides_Invalid(t *testing.T) {
+	tests := []struct {
+		route   string
+		wantErr string
+	}{
+		{`{"backends": ["b:1"], "overrides": {"idle_timeout": -1}}`, "must be >= 0"},
+		{`{"backends": ["b:1"], "overrides": {"oversize": "split"}}`, "unknown oversize action"},
+		{`{"backends": ["b:1"], "overrides": {"max_datagram": 1000}}`, "at least 1200"},
+		{`{"backends": ["b:1"], "max_datagram": 1300, "overrides": {"max_datagram": 1300}}`, "either"},
+	}
+	for _, tt := range tests {
+		_, err := NewDynamicHandler(json.RawMessage(`{"routes": {"a.com": ` + tt.route + `}}`))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.route, err, tt.wantErr)
+		}
+	}
+}
+
+func TestBandwidthCap(t *testing.T) {
+	c := newBandwidthCap(800) // 100000 bytes per second
+	now := time.Now()
+	if !c.allow(Inbound, 100000, now) || c.allow(Inbound, 1000, now) {
+		t.Fatal("burst is not one second's worth")
+	}
+	if !c.allow(Outbound, 1000, now) {
+		t.Error("directions share the cap")
+	}
+	if !c.allow(Inbound, 1000, now.Add(20*time.Millisecond)) {
+		t.Error("cap did not refill")
+	}
+
+	// A low cap still passes datagrams of any size, just not often
+	low := newBandwidthCap(8)
+	if !low.allow(Inbound, maxUDPPayload, now) || low.allow(Inbound, maxUDPPayload, now.Add(time.Second)) {
+		t.Error("low cap")
+	}
+}
+
+func TestBandwidthCap_Reserve(t *testing.T) {
+	c := newBandwidthCap(800) // 100000 bytes per second
+	now := time.Now()
+	if wait, ok := c.reserve(Inbound, 100000, now, time.Second); !ok || wait != 0 {
+		t.Fatalf("burst: wait %v, %v", wait, ok)
+	}
+	// Datagrams above the cap queue up behind each other
+	for i, want := range []time.Duration{10 * time.Millisecond, 20 * time.Millisecond} {
+		if wait, ok := c.reserve(Inbound, 1000, now, 50*time.Millisecond); !ok || wait != want {
+			t.Errorf("datagram %d: wait %v, %v; want %v", i, wait, ok, want)
+		}
+	}
+	if _, ok := c.reserve(Inbound, 5000, now, 50*time.Millisecond); ok {
+		t.Error("datagram waiting longer than maxWait reserved")
+	}
+}
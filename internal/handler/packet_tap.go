@@ -0,0 +1,37 @@
+package handler
+
+import (
+	"net"
+	"net/netip"
+	"sync/atomic"
+)
+
+// PacketTap receives the datagrams the forwarder reads from backends, for
+// packet sampling. It runs on the packet path, so it must be fast and must
+// not keep packet.
+type PacketTap func(src, dst netip.AddrPort, packet []byte)
+
+var packetTap atomic.Pointer[PacketTap]
+
+// SetPacketTap sets the tap backend datagrams are passed to; nil removes it.
+func SetPacketTap(tap PacketTap) {
+	if tap == nil {
+		packetTap.Store(nil)
+		return
+	}
+	packetTap.Store(&tap)
+}
+
+// tapBackendPacket passes a datagram session read from the backend at from
+// to the packet tap, if one is set.
+func tapBackendPacket(session *Session, from *net.UDPAddr, packet []byte) {
+	tap := packetTap.Load()
+	if tap == nil || from == nil {
+		return
+	}
+	var local netip.AddrPort
+	if addr, ok := session.BackendConn.LocalAddr().(*net.UDPAddr); ok {
+		local = addr.AddrPort()
+	}
+	(*tap)(from.AddrPort(), local, packet)
+}
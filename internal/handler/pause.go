@@ -0,0 +1,90 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"sync"
+)
+
+// maxHeldPackets bounds the client packets kept while a session is paused.
+// Later packets are dropped; QUIC retransmits them after the session resumes.
+const maxHeldPackets = 64
+
+// ErrBackendPinned is returned when moving a session whose backend socket is
+// connected: relay:// backends, non-QUIC protocols and forwarders with
+// backend_migration disabled.
+var ErrBackendPinned = errors.New("session backend cannot be changed")
+
+// sessionPause holds client packets of a paused session until it resumes.
+type sessionPause struct {
+	mu     sync.Mutex
+	paused bool
+	held   [][]byte
+	flush  func([]byte) // Sends a held packet to the backend; set by the forwarder
+}
+
+// Pause stops forwarding client packets to the backend. Up to maxHeldPackets
+// are kept and sent on Resume. Returns false if the session was already paused.
+func (s *Session) Pause() bool {
+	s.pause.mu.Lock()
+	defer s.pause.mu.Unlock()
+	if s.pause.paused {
+		return false
+	}
+	s.pause.paused = true
+	s.trace.Note("pause", "")
+	return true
+}
+
+// Paused reports whether client packets are held back.
+func (s *Session) Paused() bool {
+	s.pause.mu.Lock()
+	defer s.pause.mu.Unlock()
+	return s.pause.paused
+}
+
+// Resume sends the held client packets to the current backend and forwards
+// again. Returns how many packets were sent, and false if the session was not paused.
+func (s *Session) Resume() (int, bool) {
+	s.pause.mu.Lock()
+	defer s.pause.mu.Unlock()
+	if !s.pause.paused {
+		return 0, false
+	}
+	s.pause.paused = false
+	s.trace.Note("resume", "")
+	held := s.pause.held
+	s.pause.held = nil
+	// Sent under the lock so new client packets queue up behind them
+	for _, p := range held {
+		if s.pause.flush != nil && !s.IsClosed() {
+			s.pause.flush(p)
+		}
+	}
+	return len(held), true
+}
+
+// hold keeps a copy of a client packet if the session is paused.
+// Returns false if the packet should be forwarded now.
+func (s *Session) hold(packet []byte) bool {
+	s.pause.mu.Lock()
+	defer s.pause.mu.Unlock()
+	if !s.pause.paused {
+		return false
+	}
+	if len(s.pause.held) < maxHeldPackets {
+		s.pause.held = append(s.pause.held, append([]byte(nil), packet...))
+	}
+	return true
+}
+
+// MoveBackend sends the session's client packets to addr from now on. Packets
+// from addr are accepted as the session's backend.
+func (s *Session) MoveBackend(addr *net.UDPAddr) error {
+	if !s.migrates {
+		return ErrBackendPinned
+	}
+	s.SetBackendAddr(addr)
+	s.trace.Note("backend_moved", addr.String())
+	return nil
+}
@@ -0,0 +1,51 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestSession_PauseResume(t *testing.T) {
+	var sent [][]byte
+	s := &Session{}
+	s.pause.flush = func(p []byte) { sent = append(sent, p) }
+
+	if s.hold([]byte{1}) {
+		t.Fatal("packet held while not paused")
+	}
+	if !s.Pause() || s.Pause() {
+		t.Fatal("Pause should succeed once")
+	}
+	packet := []byte{2}
+	for range maxHeldPackets + 5 {
+		if !s.hold(packet) {
+			t.Fatal("packet not held while paused")
+		}
+	}
+	packet[0] = 3 // Held packets are copies
+
+	n, ok := s.Resume()
+	if !ok || n != maxHeldPackets || len(sent) != maxHeldPackets {
+		t.Fatalf("Resume = %d, %v; sent %d, want %d", n, ok, len(sent), maxHeldPackets)
+	}
+	if !bytes.Equal(sent[0], []byte{2}) {
+		t.Errorf("held packet = %x, want 02", sent[0])
+	}
+	if _, ok := s.Resume(); ok || s.Paused() {
+		t.Error("session still paused after Resume")
+	}
+}
+
+func TestSession_MoveBackend(t *testing.T) {
+	addr := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 7), Port: 5520}
+	pinned := &Session{}
+	if err := pinned.MoveBackend(addr); !errors.Is(err, ErrBackendPinned) {
+		t.Errorf("MoveBackend on connected socket: err = %v, want ErrBackendPinned", err)
+	}
+	s := &Session{migrates: true}
+	if err := s.MoveBackend(addr); err != nil || s.BackendAddr() != addr {
+		t.Errorf("MoveBackend: err = %v, backend = %v", err, s.BackendAddr())
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"fmt"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var pipelineLog = logging.For("pipeline")
+
+// PipelineKey is the context key holding the name of the pipeline a
+// connection was redirected to. Session listings and snapshots show it.
+const PipelineKey = "pipeline"
+
+// pipelineChainKey holds the *Chain of the pipeline that owns a redirected
+// connection. OnPacket and OnDisconnect of the main chain hand over to it.
+const pipelineChainKey = "_pipeline"
+
+// SetPipelines sets how the chain finds the pipelines Redirect results name.
+// Without it, redirected connections are dropped. Call before the chain
+// handles connections.
+func (c *Chain) SetPipelines(lookup func(name string) *Chain) {
+	c.pipelines = lookup
+}
+
+// pipelineOf returns the pipeline ctx was redirected to, or nil.
+func (c *Chain) pipelineOf(ctx *Context) *Chain {
+	if c.pipelines == nil {
+		return nil
+	}
+	pl, _ := GetValue[*Chain](ctx, pipelineChainKey)
+	return pl
+}
+
+// redirect hands a connection that handler i redirected to the pipeline it
+// named. The handlers that passed the connection on keep it: they see its
+// disconnect, or CancelConnect if the pipeline refuses it.
+func (c *Chain) redirect(ctx *Context, result Result, i int) Result {
+	passed := i + 1
+	if result.Policy != nil {
+		passed = i // on_drop "redirect": handler i refused the connection itself
+	}
+	var pl *Chain
+	if c.pipelines != nil {
+		pl = c.pipelines(result.Target)
+	}
+	if pl == nil {
+		c.cancelConnect(ctx, passed)
+		err := fmt.Errorf("%s: redirect to unknown pipeline %q", c.handlers[i].Name(), result.Target)
+		if c.pipelines == nil {
+			err = fmt.Errorf("%s: redirect outside the main chain", c.handlers[i].Name())
+		}
+		return Result{Action: Drop, Error: err}
+	}
+	if result.Error != nil {
+		pipelineLog.Debugf("client=%s redirected to pipeline %s: %v", privacy.Addr(ctx.ClientAddr), result.Target, result.Error)
+	}
+
+	// Pipelines have no lookup of their own, so a Redirect from one is dropped
+	pipelineResult := pl.OnConnect(ctx)
+	if pipelineResult.Action == Hold {
+		return holdThen(pipelineResult, func(r Result) Result {
+			return c.redirected(ctx, pl, result.Target, passed, r)
+		})
+	}
+	return c.redirected(ctx, pl, result.Target, passed, pipelineResult)
+}
+
+// redirected finishes a redirect with the result of pipeline pl, named
+// target. The first passed handlers of c had let the connection through.
+func (c *Chain) redirected(ctx *Context, pl *Chain, target string, passed int, result Result) Result {
+	if result.Action == Drop {
+		c.cancelConnect(ctx, passed)
+		return result
+	}
+	ctx.Set(pipelineChainKey, pl)
+	ctx.Set(PipelineKey, target)
+	if ctx.Session != nil {
+		ctx.Session.Note("redirect", target)
+	}
+	return result
+}
+
+// restorePipeline restores a session saved while a pipeline owned it through
+// that pipeline. It reports false if ctx names no pipeline.
+func (c *Chain) restorePipeline(ctx *Context, id uint64) (Result, bool) {
+	name := ctx.GetString(PipelineKey)
+	if name == "" || c.pipelines == nil {
+		return Result{}, false
+	}
+	pl := c.pipelines(name)
+	if pl == nil {
+		return Result{Action: Drop, Error: fmt.Errorf("pipeline %q no longer exists", name)}, true
+	}
+	result := pl.Restore(ctx, id)
+	if result.Action == Handled {
+		ctx.Set(pipelineChainKey, pl)
+	}
+	return result, true
+}
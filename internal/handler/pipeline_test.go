@@ -0,0 +1,125 @@
+package handler
+
+import (
+	"net"
+	"strings"
+	"testing"
+)
+
+// cancelingHandler is a mockHandler that records CancelConnect calls.
+type cancelingHandler struct {
+	*mockHandler
+	canceled bool
+}
+
+func (h *cancelingHandler) CancelConnect(ctx *Context) { h.canceled = true }
+
+func pipelineLookup(pipelines map[string]*Chain) func(string) *Chain {
+	return func(name string) *Chain { return pipelines[name] }
+}
+
+func TestChain_Redirect(t *testing.T) {
+	before := &cancelingHandler{mockHandler: newMockHandler("before", Continue, Continue)}
+	redirector := newMockHandler("redirector", Redirect, Continue)
+	redirector.onConnectResult.Target = "inspect"
+	fastPath := newMockHandler("forwarder", Handled, Handled)
+	inspect := newMockHandler("terminator", Handled, Handled)
+
+	chain := NewChain(before, redirector, fastPath)
+	chain.SetPipelines(pipelineLookup(map[string]*Chain{"inspect": NewChain(inspect)}))
+	ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}}
+
+	if result := chain.OnConnect(ctx); result.Action != Handled {
+		t.Fatalf("OnConnect = %v, want Handled", result.Action)
+	}
+	if fastPath.connectCalled || !inspect.connectCalled || before.canceled {
+		t.Errorf("forwarder called %v, pipeline called %v, canceled %v", fastPath.connectCalled, inspect.connectCalled, before.canceled)
+	}
+	if got := ctx.GetString(PipelineKey); got != "inspect" {
+		t.Errorf("pipeline = %q", got)
+	}
+
+	// The pipeline owns the packets; the main chain still sees the disconnect
+	if result := chain.OnPacket(ctx, []byte{0x40}, Inbound); result.Action != Handled {
+		t.Errorf("OnPacket = %v", result.Action)
+	}
+	if fastPath.packetCalled || !inspect.packetCalled {
+		t.Errorf("forwarder packet %v, pipeline packet %v", fastPath.packetCalled, inspect.packetCalled)
+	}
+	chain.OnDisconnect(ctx)
+	if !before.disconnectCalled || !inspect.disconnectCalled {
+		t.Errorf("disconnect: main %v, pipeline %v", before.disconnectCalled, inspect.disconnectCalled)
+	}
+}
+
+func TestChain_RedirectRefused(t *testing.T) {
+	before := &cancelingHandler{mockHandler: newMockHandler("before", Continue, Continue)}
+	redirector := newMockHandler("redirector", Redirect, Continue)
+	redirector.onConnectResult.Target = "inspect"
+	chain := NewChain(before, redirector)
+	ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}}
+
+	// No pipelines configured
+	result := chain.OnConnect(ctx)
+	if result.Action != Drop || result.Error == nil || !strings.Contains(result.Error.Error(), "outside the main chain") {
+		t.Errorf("without pipelines: %+v", result)
+	}
+	if !before.canceled {
+		t.Error("handlers before the redirect not canceled")
+	}
+
+	// Unknown pipeline
+	chain.SetPipelines(pipelineLookup(nil))
+	if result := chain.OnConnect(ctx); result.Action != Drop || !strings.Contains(result.Error.Error(), `unknown pipeline "inspect"`) {
+		t.Errorf("unknown pipeline: %+v", result)
+	}
+
+	// A pipeline that redirects again, or takes nobody, refuses the connection
+	again := newMockHandler("again", Redirect, Continue)
+	again.onConnectResult.Target = "inspect"
+	for name, pl := range map[string]*Chain{"loop": NewChain(again), "empty": NewChain()} {
+		before.canceled = false
+		chain.SetPipelines(pipelineLookup(map[string]*Chain{"inspect": pl}))
+		if result := chain.OnConnect(ctx); result.Action != Drop {
+			t.Errorf("%s: OnConnect = %v, want Drop", name, result.Action)
+		}
+		if !before.canceled {
+			t.Errorf("%s: handlers before the redirect not canceled", name)
+		}
+		if ctx.GetString(PipelineKey) != "" {
+			t.Errorf("%s: pipeline set for a refused connection", name)
+		}
+	}
+}
+
+func TestChain_RedirectOnDrop(t *testing.T) {
+	limiter := &cancelingHandler{mockHandler: newMockHandler("ratelimit", Drop, Continue)}
+	forwarder := newMockHandler("forwarder", Handled, Handled)
+	inspect := newMockHandler("terminator", Handled, Handled)
+
+	chain := NewChain(limiter, forwarder)
+	chain.policies = []*DropPolicy{{Action: DropRedirect, Pipeline: "inspect"}}
+	chain.SetPipelines(pipelineLookup(map[string]*Chain{"inspect": NewChain(inspect)}))
+	ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}}
+
+	if result := chain.OnConnect(ctx); result.Action != Handled || !inspect.connectCalled || forwarder.connectCalled {
+		t.Fatalf("OnConnect = %+v", result)
+	}
+
+	// The limiter refused the connection itself, so it is not canceled when
+	// the pipeline refuses it too
+	inspect.onConnectResult = Result{Action: Drop}
+	if result := chain.OnConnect(ctx); result.Action != Drop || limiter.canceled {
+		t.Errorf("OnConnect = %+v, canceled %v", result, limiter.canceled)
+	}
+}
+
+func TestDropPolicy_Redirect(t *testing.T) {
+	var p DropPolicy
+	if err := p.UnmarshalJSON([]byte(`{"action": "redirect", "pipeline": "inspect"}`)); err != nil || p.Pipeline != "inspect" {
+		t.Errorf("policy = %+v, err = %v", p, err)
+	}
+	if err := p.UnmarshalJSON([]byte(`"redirect"`)); err == nil {
+		t.Error("redirect without pipeline accepted")
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+func init() {
+	Register("protocol-router", NewProtocolRouterHandler)
+}
+
+// ProtocolRouterHandler routes non-QUIC flows by the protocol name detected by
+// the proxy's protocol rules. QUIC connections pass through to the next handler.
+type ProtocolRouterHandler struct {
+	routes map[string]*route
+	now    func() time.Time
+}
+
+// NewProtocolRouterHandler creates a new protocol router.
+// Routes use the same formats as sni-router, keyed by protocol name.
+func NewProtocolRouterHandler(raw json.RawMessage) (Handler, error) {
+	var cfg struct {
+		Routes   map[string]any  `json:"routes"`
+		Steering *steeringConfig `json:"steering,omitempty"`
+	}
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid protocol-router config: %w", err)
+		}
+	}
+	if len(cfg.Routes) == 0 {
+		return nil, fmt.Errorf("protocol-router requires 'routes' config")
+	}
+
+	routes, err := parseRoutes(cfg.Routes, "protocol")
+	if err != nil {
+		return nil, err
+	}
+	if err := applySteering(routes, cfg.Steering, "protocol"); err != nil {
+		return nil, err
+	}
+	return &ProtocolRouterHandler{routes: routes, now: time.Now}, nil
+}
+
+// Name returns the handler name.
+func (h *ProtocolRouterHandler) Name() string {
+	return "protocol-router"
+}
+
+// Backends lists the backends of all routes.
+func (h *ProtocolRouterHandler) Backends() []string {
+	var addrs []string
+	for _, r := range h.routes {
+		addrs = append(addrs, r.backends()...)
+	}
+	return addrs
+}
+
+// OnConnect sets the backend for non-QUIC flows.
+func (h *ProtocolRouterHandler) OnConnect(ctx *Context) Result {
+	if ctx.Protocol == "" {
+		return Result{Action: Continue}
+	}
+
+	r, ok := h.routes[ctx.Protocol]
+	if !ok {
+		return Result{Action: Drop, Error: fmt.Errorf("no route for protocol %s", ctx.Protocol)}
+	}
+	if r.honeypot {
+		ctx.Set(HoneypotKey, ctx.Protocol)
+		return Result{Action: Continue}
+	}
+
+	backend, err := r.connect(ctx, h.now())
+	if err != nil {
+		return Result{Action: Drop, Error: fmt.Errorf("protocol %s: %w", ctx.Protocol, err)}
+	}
+	if r.steering != nil {
+		r.steering.observe(ctx)
+		logSteering(ctx, "protocol", ctx.Protocol, backend)
+	}
+	observeFailover(ctx)
+	if r.maxDatagram > 0 {
+		ctx.Set(MaxDatagramKey, r.maxDatagram)
+	}
+	if r.overrides != nil {
+		ctx.Set(OverridesKey, r.overrides)
+	}
+	if r.tags != nil {
+		ctx.Set(TagsKey, r.tags)
+	}
+	if r.upstream != nil {
+		ctx.Set(UpstreamKey, r.upstream)
+	}
+
+	ctx.Set("backend", backend)
+	return Result{Action: Continue}
+}
+
+// OnPacket passes through.
+func (h *ProtocolRouterHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect reports the outcome of steered and failover sessions and
+// releases the session's backend slot.
+func (h *ProtocolRouterHandler) OnDisconnect(ctx *Context) {
+	releaseBackendSlot(ctx)
+	reportFailover(ctx)
+	if r, ok := h.routes[ctx.Protocol]; ok && r.steering != nil {
+		r.steering.report(ctx)
+	}
+}
+
+// CancelConnect releases the backend slot of a connection refused later in the chain.
+func (h *ProtocolRouterHandler) CancelConnect(ctx *Context) {
+	releaseBackendSlot(ctx)
+}
+
+// Restore counts a restored flow against its backend's max_sessions.
+func (h *ProtocolRouterHandler) Restore(ctx *Context, id uint64) Result {
+	if r, ok := h.routes[ctx.Protocol]; ok && ctx.Protocol != "" {
+		r.restore(ctx)
+	}
+	return Result{Action: Continue}
+}
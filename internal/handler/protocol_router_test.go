@@ -0,0 +1,58 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewProtocolRouterHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"no routes", `{}`, "requires 'routes'"},
+		{"bad json", `{`, "invalid protocol-router config"},
+		{"empty backends", `{"routes": {"wireguard": []}}`, "empty backends for protocol wireguard"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewProtocolRouterHandler(json.RawMessage(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestProtocolRouterHandler_OnConnect(t *testing.T) {
+	h, err := NewProtocolRouterHandler(json.RawMessage(`{"routes": {"wireguard": "10.0.0.5:51820", "rtp": ["m1:5004", "m2:5004"]}}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	tests := []struct {
+		name     string
+		protocol string
+		action   Action
+		backend  string
+	}{
+		{"quic passes through", "", Continue, ""},
+		{"wireguard", "wireguard", Continue, "10.0.0.5:51820"},
+		{"rtp", "rtp", Continue, "m1:5004"},
+		{"unknown", "dns", Drop, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := &Context{Protocol: tt.protocol}
+			result := h.OnConnect(ctx)
+			if result.Action != tt.action {
+				t.Fatalf("action = %v, want %v", result.Action, tt.action)
+			}
+			if got := ctx.GetString("backend"); got != tt.backend {
+				t.Errorf("backend = %q, want %q", got, tt.backend)
+			}
+		})
+	}
+}
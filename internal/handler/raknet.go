@@ -0,0 +1,307 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var raknetLog = logging.ForHandler("raknet")
+
+func init() {
+	Register("raknet", NewRakNetHandler)
+}
+
+// RakNet offline message IDs.
+const (
+	raknetUnconnectedPing          = 0x01
+	raknetUnconnectedPingOpenConns = 0x02
+	raknetUnconnectedPong          = 0x1c
+)
+
+// raknetMagic is the offline message marker present in every unconnected ping/pong.
+var raknetMagic = []byte{
+	0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe,
+	0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78,
+}
+
+// Ping handling modes.
+const (
+	RakNetModeReply   = "reply"   // Answer with the configured MOTD (default)
+	RakNetModeForward = "forward" // Relay the backend's pong (cached)
+)
+
+// RakNetConfig is the configuration for the raknet handler.
+type RakNetConfig struct {
+	Mode string `json:"mode,omitempty"` // "reply" (default) or "forward"
+
+	// Reply mode: advertised server status
+	MOTD            string `json:"motd,omitempty"`
+	SubMOTD         string `json:"sub_motd,omitempty"`
+	ProtocolVersion int    `json:"protocol_version,omitempty"`
+	Version         string `json:"version,omitempty"`
+	PlayersOnline   int    `json:"players_online,omitempty"`
+	MaxPlayers      int    `json:"max_players,omitempty"`
+	GameMode        string `json:"game_mode,omitempty"`
+	PortV4          int    `json:"port_v4,omitempty"`
+	PortV6          int    `json:"port_v6,omitempty"`
+	ServerGUID      uint64 `json:"server_guid,omitempty"` // Default: random
+
+	// Forward mode
+	Backend  string `json:"backend,omitempty"`   // Bedrock server to ping
+	CacheTTL int    `json:"cache_ttl,omitempty"` // Seconds to reuse the backend pong (default: 5)
+	Timeout  int    `json:"timeout_ms,omitempty"`
+}
+
+// RakNetHandler answers Minecraft Bedrock (RakNet) unconnected pings without
+// creating sessions. Bedrock clients ping every server in their list
+// continuously; answering at the relay keeps that traffic off the backend.
+type RakNetHandler struct {
+	mode       string
+	serverGUID uint64
+	status     string // Reply mode: preformatted status string
+
+	backend  *net.UDPAddr
+	cacheTTL time.Duration
+	timeout  time.Duration
+
+	mu        sync.Mutex
+	cached    []byte // Backend status string (forward mode)
+	cachedAt  time.Time
+	refreshMu sync.Mutex // Serializes backend pings
+}
+
+// NewRakNetHandler creates a new raknet handler.
+func NewRakNetHandler(raw json.RawMessage) (Handler, error) {
+	var cfg RakNetConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid raknet config: %w", err)
+		}
+	}
+
+	h := &RakNetHandler{mode: cfg.Mode, serverGUID: cfg.ServerGUID}
+	if h.serverGUID == 0 {
+		h.serverGUID = uint64(time.Now().UnixNano())
+	}
+
+	switch cfg.Mode {
+	case "", RakNetModeReply:
+		h.mode = RakNetModeReply
+		h.status = formatBedrockStatus(cfg, h.serverGUID)
+	case RakNetModeForward:
+		if cfg.Backend == "" {
+			return nil, errors.New("raknet: forward mode requires 'backend'")
+		}
+		addr, err := net.ResolveUDPAddr("udp", cfg.Backend)
+		if err != nil {
+			return nil, fmt.Errorf("raknet: invalid backend: %w", err)
+		}
+		h.backend = addr
+		h.cacheTTL = time.Duration(cfg.CacheTTL) * time.Second
+		if cfg.CacheTTL <= 0 {
+			h.cacheTTL = 5 * time.Second
+		}
+		h.timeout = time.Duration(cfg.Timeout) * time.Millisecond
+		if cfg.Timeout <= 0 {
+			h.timeout = time.Second
+		}
+	default:
+		return nil, fmt.Errorf("raknet: unknown mode %q", cfg.Mode)
+	}
+	return h, nil
+}
+
+// formatBedrockStatus builds the semicolon-separated status string of a pong.
+func formatBedrockStatus(cfg RakNetConfig, guid uint64) string {
+	motd := cfg.MOTD
+	if motd == "" {
+		motd = "quic-relay"
+	}
+	gameMode := cfg.GameMode
+	if gameMode == "" {
+		gameMode = "Survival"
+	}
+	fields := []string{
+		"MCPE",
+		sanitizeStatusField(motd),
+		strconv.Itoa(cfg.ProtocolVersion),
+		sanitizeStatusField(cfg.Version),
+		strconv.Itoa(cfg.PlayersOnline),
+		strconv.Itoa(cfg.MaxPlayers),
+		strconv.FormatUint(guid, 10),
+		sanitizeStatusField(cfg.SubMOTD),
+		sanitizeStatusField(gameMode),
+		"1",
+		strconv.Itoa(cfg.PortV4),
+		strconv.Itoa(cfg.PortV6),
+	}
+	return strings.Join(fields, ";") + ";"
+}
+
+func sanitizeStatusField(s string) string {
+	return strings.ReplaceAll(s, ";", "")
+}
+
+// Name returns the handler name.
+func (h *RakNetHandler) Name() string {
+	return "raknet"
+}
+
+// Backends lists the server pinged in forward mode.
+func (h *RakNetHandler) Backends() []string {
+	if h.backend == nil {
+		return nil
+	}
+	return []string{h.backend.String()}
+}
+
+// OnConnect passes through; RakNet pings never reach the connection path.
+func (h *RakNetHandler) OnConnect(ctx *Context) Result {
+	return Result{Action: Continue}
+}
+
+// OnPacket passes through.
+func (h *RakNetHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect does nothing.
+func (h *RakNetHandler) OnDisconnect(ctx *Context) {}
+
+// OnDatagram answers RakNet unconnected pings.
+func (h *RakNetHandler) OnDatagram(clientAddr *net.UDPAddr, packet []byte, reply func([]byte) error) bool {
+	pingTime, ok := parseRakNetPing(packet)
+	if !ok {
+		return false
+	}
+
+	var status []byte
+	if h.mode == RakNetModeForward {
+		var err error
+		if status, err = h.backendStatus(); err != nil {
+			raknetLog.Debugf("ping from %s: %v", privacy.Addr(clientAddr), err)
+			return true // Consumed; the backend is down so there is nothing to report
+		}
+	} else {
+		status = []byte(h.status)
+	}
+
+	if err := reply(buildRakNetPong(pingTime, h.serverGUID, status)); err != nil {
+		raknetLog.Debugf("pong to %s failed: %v", privacy.Addr(clientAddr), err)
+	}
+	return true
+}
+
+// parseRakNetPing returns the ping timestamp if packet is an unconnected ping.
+// Layout: ID (1) | time (8) | magic (16) | client GUID (8)
+func parseRakNetPing(packet []byte) (uint64, bool) {
+	if len(packet) < 33 {
+		return 0, false
+	}
+	if packet[0] != raknetUnconnectedPing && packet[0] != raknetUnconnectedPingOpenConns {
+		return 0, false
+	}
+	if !bytes.Equal(packet[9:25], raknetMagic) {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(packet[1:9]), true
+}
+
+// buildRakNetPing builds an unconnected ping.
+func buildRakNetPing(pingTime, clientGUID uint64) []byte {
+	pkt := make([]byte, 0, 33)
+	pkt = append(pkt, raknetUnconnectedPing)
+	pkt = binary.BigEndian.AppendUint64(pkt, pingTime)
+	pkt = append(pkt, raknetMagic...)
+	pkt = binary.BigEndian.AppendUint64(pkt, clientGUID)
+	return pkt
+}
+
+// buildRakNetPong builds an unconnected pong.
+// Layout: ID (1) | time (8) | server GUID (8) | magic (16) | status length (2) | status
+func buildRakNetPong(pingTime, serverGUID uint64, status []byte) []byte {
+	pkt := make([]byte, 0, 35+len(status))
+	pkt = append(pkt, raknetUnconnectedPong)
+	pkt = binary.BigEndian.AppendUint64(pkt, pingTime)
+	pkt = binary.BigEndian.AppendUint64(pkt, serverGUID)
+	pkt = append(pkt, raknetMagic...)
+	pkt = binary.BigEndian.AppendUint16(pkt, uint16(len(status)))
+	return append(pkt, status...)
+}
+
+// parseRakNetPong extracts the status string from an unconnected pong.
+func parseRakNetPong(packet []byte) ([]byte, error) {
+	if len(packet) < 35 || packet[0] != raknetUnconnectedPong {
+		return nil, errors.New("not an unconnected pong")
+	}
+	if !bytes.Equal(packet[17:33], raknetMagic) {
+		return nil, errors.New("bad magic")
+	}
+	n := int(binary.BigEndian.Uint16(packet[33:35]))
+	if len(packet) < 35+n {
+		return nil, errors.New("truncated status")
+	}
+	return packet[35 : 35+n], nil
+}
+
+// backendStatus returns the backend's status string, pinging it when the cache is stale.
+func (h *RakNetHandler) backendStatus() ([]byte, error) {
+	if status := h.cachedStatus(); status != nil {
+		return status, nil
+	}
+
+	// One ping at a time; concurrent callers reuse its result
+	h.refreshMu.Lock()
+	defer h.refreshMu.Unlock()
+	if status := h.cachedStatus(); status != nil {
+		return status, nil
+	}
+
+	conn, err := net.DialUDP("udp", nil, h.backend)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write(buildRakNetPing(uint64(time.Now().UnixMilli()), h.serverGUID)); err != nil {
+		return nil, err
+	}
+	conn.SetReadDeadline(time.Now().Add(h.timeout))
+
+	buf := make([]byte, 1500)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, fmt.Errorf("backend ping: %w", err)
+	}
+	status, err := parseRakNetPong(buf[:n])
+	if err != nil {
+		return nil, fmt.Errorf("backend ping: %w", err)
+	}
+
+	status = bytes.Clone(status)
+	h.mu.Lock()
+	h.cached = status
+	h.cachedAt = time.Now()
+	h.mu.Unlock()
+	return status, nil
+}
+
+func (h *RakNetHandler) cachedStatus() []byte {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.cached != nil && time.Since(h.cachedAt) < h.cacheTTL {
+		return h.cached
+	}
+	return nil
+}
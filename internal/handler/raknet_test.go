@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestNewRakNetHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"bad json", `{`, "invalid raknet config"},
+		{"bad mode", `{"mode": "tunnel"}`, "unknown mode"},
+		{"forward without backend", `{"mode": "forward"}`, "requires 'backend'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewRakNetHandler(json.RawMessage(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestParseRakNetPing(t *testing.T) {
+	ping := buildRakNetPing(12345, 99)
+	if ts, ok := parseRakNetPing(ping); !ok || ts != 12345 {
+		t.Errorf("parseRakNetPing = %d, %v", ts, ok)
+	}
+
+	// QUIC short header with the same first byte
+	quic := make([]byte, 40)
+	quic[0] = 0x01
+	if _, ok := parseRakNetPing(quic); ok {
+		t.Error("QUIC packet detected as RakNet ping")
+	}
+	if _, ok := parseRakNetPing(ping[:20]); ok {
+		t.Error("truncated ping accepted")
+	}
+}
+
+func TestRakNetHandler_Reply(t *testing.T) {
+	config := `{"motd": "My;Server", "version": "1.21.0", "protocol_version": 712, "players_online": 3, "max_players": 100, "server_guid": 42, "port_v4": 19132}`
+	h, err := NewRakNetHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	dh := h.(DatagramHandler)
+
+	var sent []byte
+	reply := func(b []byte) error { sent = b; return nil }
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000}
+
+	if !dh.OnDatagram(client, buildRakNetPing(777, 1), reply) {
+		t.Fatal("ping not consumed")
+	}
+	if sent == nil || sent[0] != raknetUnconnectedPong {
+		t.Fatalf("expected pong, got %x", sent)
+	}
+	status, err := parseRakNetPong(sent)
+	if err != nil {
+		t.Fatalf("parseRakNetPong: %v", err)
+	}
+	want := "MCPE;MyServer;712;1.21.0;3;100;42;;Survival;1;19132;0;"
+	if string(status) != want {
+		t.Errorf("status = %q, want %q", status, want)
+	}
+
+	sent = nil
+	if dh.OnDatagram(client, []byte{0x40, 1, 2, 3}, reply) {
+		t.Error("non-RakNet datagram consumed")
+	}
+	if sent != nil {
+		t.Error("reply sent for non-RakNet datagram")
+	}
+}
+
+func TestRakNetHandler_Forward(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+
+	var pings atomic.Int32
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			ts, ok := parseRakNetPing(buf[:n])
+			if !ok {
+				continue
+			}
+			pings.Add(1)
+			backend.WriteToUDP(buildRakNetPong(ts, 7, []byte("MCPE;Backend;1;1;5;10;7;;Creative;1;1;1;")), addr)
+		}
+	}()
+
+	config := `{"mode": "forward", "backend": "` + backend.LocalAddr().String() + `", "server_guid": 42}`
+	h, err := NewRakNetHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	dh := h.(DatagramHandler)
+
+	for i := 0; i < 3; i++ {
+		var sent []byte
+		dh.OnDatagram(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}, buildRakNetPing(uint64(i), 1), func(b []byte) error {
+			sent = b
+			return nil
+		})
+		status, err := parseRakNetPong(sent)
+		if err != nil {
+			t.Fatalf("ping %d: %v", i, err)
+		}
+		if !strings.Contains(string(status), "Backend") {
+			t.Errorf("status = %q", status)
+		}
+	}
+	if n := pings.Load(); n != 1 {
+		t.Errorf("backend pinged %d times, want 1 (cached)", n)
+	}
+}
+
+func TestChain_OnDatagram(t *testing.T) {
+	h, _ := NewRakNetHandler(nil)
+	chain := NewChain(newMockHandler("h1", Continue, Continue), h)
+
+	consumed := chain.OnDatagram(&net.UDPAddr{}, buildRakNetPing(1, 1), func([]byte) error { return nil })
+	if !consumed {
+		t.Error("expected RakNet ping to be consumed")
+	}
+	if chain.OnDatagram(&net.UDPAddr{}, []byte{0x40}, func([]byte) error { return nil }) {
+		t.Error("unexpected consume")
+	}
+}
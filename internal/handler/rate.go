@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Connection rate algorithms.
+const (
+	RateFixedWindow   = "fixed-window"   // Count per clock-aligned window, reset at each boundary
+	RateSlidingWindow = "sliding-window" // Log of admission times within the last window
+	RateLeakyBucket   = "leaky-bucket"   // Level drained at limit per window, holding up to burst
+)
+
+// Connection rate keys.
+const (
+	RatePerGlobal = "global" // One limit shared by all connections
+	RatePerIP     = "ip"     // A limit per client IP (the original client behind relay hops)
+	RatePerSNI    = "sni"    // A limit per SNI; connections without one share a limit
+	RatePerTenant = "tenant" // A limit per tenant set by the tenants handler; others share a limit
+)
+
+const defaultRateWindowMs = 1000
+
+// RateConfig limits new connections to Limit per window. Handlers that limit
+// connection rates embed it in their config.
+type RateConfig struct {
+	Limit     int    `json:"limit"`               // Connections admitted per window
+	WindowMs  int    `json:"window_ms,omitempty"` // Window length (default: 1000)
+	Algorithm string `json:"algorithm,omitempty"` // "sliding-window" (default), "fixed-window" or "leaky-bucket"
+	Per       string `json:"per,omitempty"`       // "global" (default), "ip", "sni" or "tenant"
+	Burst     int    `json:"burst,omitempty"`     // Leaky bucket only: connections admitted at once (default: limit)
+}
+
+// rateLimit is a compiled RateConfig with the state of each key.
+type rateLimit struct {
+	limit     int
+	window    time.Duration
+	algorithm string
+	per       string
+	burst     float64
+	shared    *sharedRates // Nil when limited locally only
+
+	mu     sync.Mutex
+	states map[string]*rateState
+	swept  time.Time // Last removal of idle states
+}
+
+// rateState is the state of one key; each algorithm uses its own fields.
+type rateState struct {
+	start time.Time   // Fixed window: start of the current window
+	count int         // Fixed window: connections admitted in it
+	times []time.Time // Sliding window: admission times, oldest first
+	level float64     // Leaky bucket: connections not yet drained
+	at    time.Time   // Leaky bucket: when level was computed
+}
+
+// newRateLimit validates cfg and compiles it.
+func newRateLimit(cfg RateConfig) (*rateLimit, error) {
+	if cfg.Limit <= 0 {
+		return nil, fmt.Errorf("rate 'limit' must be > 0")
+	}
+	if cfg.WindowMs < 0 || cfg.Burst < 0 {
+		return nil, fmt.Errorf("rate 'window_ms' and 'burst' must be >= 0")
+	}
+	if cfg.WindowMs == 0 {
+		cfg.WindowMs = defaultRateWindowMs
+	}
+	r := &rateLimit{
+		limit:     cfg.Limit,
+		window:    time.Duration(cfg.WindowMs) * time.Millisecond,
+		algorithm: cfg.Algorithm,
+		per:       cfg.Per,
+		states:    make(map[string]*rateState),
+	}
+	switch r.algorithm {
+	case "":
+		r.algorithm = RateSlidingWindow
+	case RateSlidingWindow, RateFixedWindow:
+	case RateLeakyBucket:
+		r.burst = float64(cfg.Limit)
+		if cfg.Burst > 0 {
+			r.burst = float64(cfg.Burst)
+		}
+	default:
+		return nil, fmt.Errorf("unknown rate algorithm %q", cfg.Algorithm)
+	}
+	if r.algorithm != RateLeakyBucket && cfg.Burst > 0 {
+		return nil, fmt.Errorf("rate 'burst' only applies to %s", RateLeakyBucket)
+	}
+	switch r.per {
+	case "":
+		r.per = RatePerGlobal
+	case RatePerGlobal, RatePerIP, RatePerSNI, RatePerTenant:
+	default:
+		return nil, fmt.Errorf("unknown rate key %q", cfg.Per)
+	}
+	return r, nil
+}
+
+// String describes the limit for drop errors, e.g. "100 per 1m0s per ip".
+func (r *rateLimit) String() string {
+	s := fmt.Sprintf("%d per %v", r.limit, r.window)
+	if r.per != RatePerGlobal {
+		s += " per " + r.per
+	}
+	return s
+}
+
+// key returns the key ctx is limited under.
+func (r *rateLimit) key(ctx *Context) string {
+	switch r.per {
+	case RatePerIP:
+		if client := ctx.OriginalClientAddr(); client != nil {
+			if addr, ok := netip.AddrFromSlice(client.IP); ok {
+				return addr.Unmap().String()
+			}
+		}
+	case RatePerSNI:
+		if ctx.Hello != nil {
+			return strings.ToLower(ctx.Hello.SNI)
+		}
+	case RatePerTenant:
+		return ctx.GetString(TenantKey)
+	}
+	return ""
+}
+
+// allow counts a connection of ctx at now, unless its key is over the limit.
+// Shared limits are counted in Redis, or locally while Redis is unavailable.
+func (r *rateLimit) allow(ctx *Context, now time.Time) bool {
+	key := r.key(ctx)
+	if r.shared != nil {
+		if ok, err := r.shared.allow(r, key, now); err == nil {
+			return ok
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if now.Sub(r.swept) >= r.window {
+		for k, st := range r.states {
+			if r.idle(st, now) {
+				delete(r.states, k)
+			}
+		}
+		r.swept = now
+	}
+	st := r.states[key]
+	if st == nil {
+		st = &rateState{}
+		r.states[key] = st
+	}
+	return r.take(st, now)
+}
+
+// take admits one connection into st if the algorithm allows it.
+func (r *rateLimit) take(st *rateState, now time.Time) bool {
+	switch r.algorithm {
+	case RateFixedWindow:
+		if start := now.Truncate(r.window); !start.Equal(st.start) {
+			st.start, st.count = start, 0
+		}
+		if st.count >= r.limit {
+			return false
+		}
+		st.count++
+	case RateSlidingWindow:
+		st.times = st.times[r.expired(st, now):]
+		if len(st.times) >= r.limit {
+			return false
+		}
+		st.times = append(st.times, now)
+	case RateLeakyBucket:
+		st.level = r.drained(st, now)
+		st.at = now
+		if st.level+1 > r.burst {
+			return false
+		}
+		st.level++
+	}
+	return true
+}
+
+// idle reports whether st no longer limits anything at now.
+func (r *rateLimit) idle(st *rateState, now time.Time) bool {
+	switch r.algorithm {
+	case RateFixedWindow:
+		return now.Sub(st.start) >= r.window
+	case RateSlidingWindow:
+		return r.expired(st, now) == len(st.times)
+	default:
+		return r.drained(st, now) == 0
+	}
+}
+
+// expired returns how many of the logged times are outside the window.
+func (r *rateLimit) expired(st *rateState, now time.Time) int {
+	i := 0
+	for i < len(st.times) && now.Sub(st.times[i]) >= r.window {
+		i++
+	}
+	return i
+}
+
+// drained returns the bucket level at now.
+func (r *rateLimit) drained(st *rateState, now time.Time) float64 {
+	leaked := now.Sub(st.at).Seconds() * float64(r.limit) / r.window.Seconds()
+	return max(0, st.level-leaked)
+}
+
+// newRateLimits compiles the rate limits of a handler config. Limits are
+// shared through shared if it is not nil.
+func newRateLimits(cfgs []RateConfig, shared *sharedRates) ([]*rateLimit, error) {
+	limits := make([]*rateLimit, 0, len(cfgs))
+	for i, cfg := range cfgs {
+		r, err := newRateLimit(cfg)
+		if err != nil {
+			return nil, fmt.Errorf("rates[%d]: %w", i, err)
+		}
+		r.shared = shared
+		limits = append(limits, r)
+	}
+	return limits, nil
+}
@@ -0,0 +1,162 @@
+package handler
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/redis"
+)
+
+var rateLog = logging.ForHandler("ratelimit-global")
+
+const (
+	defaultRateRedisPrefix  = "quic-relay:rate:"
+	defaultRateRedisRetryMs = 5000
+)
+
+// RateRedisConfig shares rate limits between relays through Redis, so a
+// fleet enforces one combined limit instead of one limit per relay.
+type RateRedisConfig struct {
+	redis.Config
+	Prefix  string `json:"prefix,omitempty"`   // Key prefix (default: "quic-relay:rate:")
+	RetryMs int    `json:"retry_ms,omitempty"` // Time limits stay local after a Redis failure (default: 5000)
+}
+
+// Scripts run one algorithm atomically in Redis, mirroring rateLimit.take.
+// KEYS[1] is the key; ARGV is now and the window in ms, the limit, the
+// burst and a unique member for the sliding window log. They return 1 to
+// admit the connection and 0 to refuse it.
+var rateScripts = map[string]string{
+	RateFixedWindow: `
+local n = tonumber(redis.call('GET', KEYS[1]) or '0')
+if n >= tonumber(ARGV[3]) then return 0 end
+redis.call('INCR', KEYS[1])
+if n == 0 then redis.call('PEXPIRE', KEYS[1], ARGV[2]) end
+return 1`,
+	RateSlidingWindow: `
+local now = tonumber(ARGV[1])
+redis.call('ZREMRANGEBYSCORE', KEYS[1], '-inf', now - tonumber(ARGV[2]))
+if redis.call('ZCARD', KEYS[1]) >= tonumber(ARGV[3]) then return 0 end
+redis.call('ZADD', KEYS[1], now, ARGV[5])
+redis.call('PEXPIRE', KEYS[1], ARGV[2])
+return 1`,
+	RateLeakyBucket: `
+local now = tonumber(ARGV[1])
+local state = redis.call('HMGET', KEYS[1], 'level', 'at')
+local level = tonumber(state[1] or '0')
+local at = tonumber(state[2] or ARGV[1])
+level = math.max(0, level - (now - at) * tonumber(ARGV[3]) / tonumber(ARGV[2]))
+local admit = level + 1 <= tonumber(ARGV[4])
+if admit then level = level + 1 end
+redis.call('HSET', KEYS[1], 'level', tostring(level), 'at', ARGV[1])
+redis.call('PEXPIRE', KEYS[1], math.ceil(level * tonumber(ARGV[2]) / tonumber(ARGV[3])) + 1)
+if admit then return 1 end
+return 0`,
+}
+
+// sharedRates is the Redis connection of a handler's rate limits. After a
+// failure, limits are applied locally for retry_ms before Redis is tried
+// again.
+type sharedRates struct {
+	client *redis.Client
+	addr   string
+	prefix string
+	retry  time.Duration
+	member string        // Prefix of sliding window log entries, unique to this process
+	seq    atomic.Uint64 // Sequence of sliding window log entries
+
+	mu       sync.Mutex
+	failedAt time.Time // Zero while Redis is in use
+}
+
+func newSharedRates(cfg RateRedisConfig) (*sharedRates, error) {
+	client, err := redis.New(cfg.Config)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.RetryMs < 0 {
+		return nil, fmt.Errorf("redis 'retry_ms' must be >= 0")
+	}
+	if cfg.RetryMs == 0 {
+		cfg.RetryMs = defaultRateRedisRetryMs
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = defaultRateRedisPrefix
+	}
+	id := make([]byte, 8)
+	rand.Read(id)
+	return &sharedRates{
+		client: client,
+		addr:   cfg.Addr,
+		prefix: cfg.Prefix,
+		retry:  time.Duration(cfg.RetryMs) * time.Millisecond,
+		member: hex.EncodeToString(id),
+	}, nil
+}
+
+// errRatesLocal is returned while limits are applied locally.
+var errRatesLocal = errors.New("redis unavailable")
+
+// allow runs r's algorithm for key in Redis. It returns an error when Redis
+// fails or has failed within retry, and the caller limits locally instead.
+func (s *sharedRates) allow(r *rateLimit, key string, now time.Time) (bool, error) {
+	s.mu.Lock()
+	local := !s.failedAt.IsZero() && now.Sub(s.failedAt) < s.retry
+	s.mu.Unlock()
+	if local {
+		return false, errRatesLocal
+	}
+
+	ms := now.UnixMilli()
+	windowMs := r.window.Milliseconds()
+	redisKey := s.prefix + r.id() + ":" + key
+	if r.algorithm == RateFixedWindow {
+		redisKey += ":" + strconv.FormatInt(ms-ms%windowMs, 10)
+	}
+	reply, err := s.client.Do("EVAL", rateScripts[r.algorithm], "1", redisKey,
+		strconv.FormatInt(ms, 10),
+		strconv.FormatInt(windowMs, 10),
+		strconv.Itoa(r.limit),
+		strconv.FormatFloat(r.burst, 'f', -1, 64),
+		s.member+":"+strconv.FormatUint(s.seq.Add(1), 10))
+	admitted, ok := reply.(int64)
+	if err == nil && !ok {
+		err = fmt.Errorf("unexpected reply %v", reply)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		if s.failedAt.IsZero() {
+			rateLog.Warnf("redis %s failed, limiting rates locally: %v", s.addr, err)
+		}
+		s.failedAt = now
+		return false, err
+	}
+	if !s.failedAt.IsZero() {
+		rateLog.Printf("redis %s available again, sharing rate limits", s.addr)
+		s.failedAt = time.Time{}
+	}
+	return admitted == 1, nil
+}
+
+func (s *sharedRates) Close() error {
+	return s.client.Close()
+}
+
+// id identifies r's definition in Redis keys, so relays configured alike
+// share state and a changed limit starts afresh.
+func (r *rateLimit) id() string {
+	id := fmt.Sprintf("%s:%s:%d:%d", r.algorithm, r.per, r.limit, r.window.Milliseconds())
+	if r.algorithm == RateLeakyBucket && r.burst != float64(r.limit) {
+		id += ":" + strconv.FormatFloat(r.burst, 'f', -1, 64)
+	}
+	return id
+}
@@ -0,0 +1,175 @@
+package handler
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"quic-relay/internal/redis/redistest"
+)
+
+// fakeRateRedis answers rate scripts by counting admissions per key, and
+// fails every command while down is set.
+type fakeRateRedis struct {
+	*redistest.Server
+	down atomic.Bool
+
+	mu     sync.Mutex
+	counts map[string]int
+	keys   []string
+}
+
+func newFakeRateRedis(t *testing.T) *fakeRateRedis {
+	f := &fakeRateRedis{counts: make(map[string]int)}
+	f.Server = redistest.NewServer(t, func(args []string) any {
+		if f.down.Load() {
+			return redistest.Error("LOADING Redis is loading the dataset in memory")
+		}
+		if args[0] != "EVAL" || len(args) != 9 || args[2] != "1" {
+			return redistest.Error("ERR unexpected command")
+		}
+		key := args[3]
+		limit, _ := strconv.Atoi(args[6])
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		f.keys = append(f.keys, key)
+		if f.counts[key] >= limit {
+			return 0
+		}
+		f.counts[key]++
+		return 1
+	})
+	return f
+}
+
+func (f *fakeRateRedis) lastKey() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.keys) == 0 {
+		return ""
+	}
+	return f.keys[len(f.keys)-1]
+}
+
+func newSharedRateLimiter(t *testing.T, addr string, rates string) *RateLimitGlobalHandler {
+	t.Helper()
+	h, err := NewRateLimitGlobalHandler(json.RawMessage(`{"rates": ` + rates + `,
+		"redis": {"addr": "` + addr + `", "timeout_ms": 500, "retry_ms": 50}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.(Closer).Close() })
+	return h.(*RateLimitGlobalHandler)
+}
+
+func TestSharedRates_CombinedAcrossRelays(t *testing.T) {
+	srv := newFakeRateRedis(t)
+	rates := `[{"limit": 3, "window_ms": 60000}]`
+	relays := []*RateLimitGlobalHandler{
+		newSharedRateLimiter(t, srv.Addr, rates),
+		newSharedRateLimiter(t, srv.Addr, rates),
+	}
+
+	admitted := 0
+	for i := range 6 {
+		if relays[i%2].OnConnect(&Context{}).Action == Continue {
+			admitted++
+		}
+	}
+	if admitted != 3 {
+		t.Errorf("%d connections admitted by two relays, want the combined limit of 3", admitted)
+	}
+}
+
+func TestSharedRates_Keys(t *testing.T) {
+	srv := newFakeRateRedis(t)
+	tests := []struct {
+		rates string
+		ctx   *Context
+		want  string
+	}{
+		{`[{"limit": 5}]`, &Context{}, "quic-relay:rate:sliding-window:global:5:1000:"},
+		{`[{"limit": 5, "window_ms": 60000, "per": "tenant"}]`, ofTenant("acme"), "quic-relay:rate:sliding-window:tenant:5:60000:acme"},
+		{`[{"limit": 5, "algorithm": "leaky-bucket", "burst": 2, "per": "sni"}]`, &Context{Hello: &ClientHello{SNI: "Play.Example.com"}}, "quic-relay:rate:leaky-bucket:sni:5:1000:2:play.example.com"},
+	}
+	for _, tt := range tests {
+		h := newSharedRateLimiter(t, srv.Addr, tt.rates)
+		h.OnConnect(tt.ctx)
+		if got := srv.lastKey(); got != tt.want {
+			t.Errorf("%s: key = %q, want %q", tt.rates, got, tt.want)
+		}
+	}
+
+	h := newSharedRateLimiter(t, srv.Addr, `[{"limit": 5, "window_ms": 60000, "algorithm": "fixed-window"}]`)
+	h.OnConnect(&Context{})
+	key := srv.lastKey()
+	start, err := strconv.ParseInt(key[strings.LastIndex(key, ":")+1:], 10, 64)
+	if !strings.HasPrefix(key, "quic-relay:rate:fixed-window:global:5:60000::") || err != nil || start%60000 != 0 {
+		t.Errorf("fixed window key = %q, want the window start appended", key)
+	}
+}
+
+func ofTenant(name string) *Context {
+	ctx := &Context{}
+	ctx.Set(TenantKey, name)
+	return ctx
+}
+
+func TestSharedRates_LocalFallback(t *testing.T) {
+	srv := newFakeRateRedis(t)
+	h := newSharedRateLimiter(t, srv.Addr, `[{"limit": 2, "window_ms": 60000}]`)
+
+	srv.down.Store(true)
+	for i, want := range []Action{Continue, Continue, Drop} {
+		if got := h.OnConnect(&Context{}).Action; got != want {
+			t.Fatalf("connection %d with Redis down: %v, want %v", i, got, want)
+		}
+	}
+	before := len(srv.Commands())
+	h.OnConnect(&Context{})
+	if n := len(srv.Commands()) - before; n != 0 {
+		t.Errorf("%d commands sent within retry_ms of a failure, want 0", n)
+	}
+
+	// After retry_ms, Redis is used again
+	srv.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	for i, want := range []Action{Continue, Continue, Drop} {
+		if got := h.OnConnect(&Context{}).Action; got != want {
+			t.Fatalf("connection %d with Redis back: %v, want %v", i, got, want)
+		}
+	}
+	if n := len(srv.Commands()) - before; n != 3 {
+		t.Errorf("%d commands after recovery, want 3", n)
+	}
+}
+
+func TestSharedRates_Unreachable(t *testing.T) {
+	srv := newFakeRateRedis(t)
+	addr := srv.Addr
+	srv.Close()
+
+	h := newSharedRateLimiter(t, addr, `[{"limit": 1, "window_ms": 60000}]`)
+	if got := h.OnConnect(&Context{}).Action; got != Continue {
+		t.Errorf("first connection with Redis unreachable: %v, want Continue", got)
+	}
+	if got := h.OnConnect(&Context{}).Action; got != Drop {
+		t.Errorf("second connection with Redis unreachable: %v, want Drop from the local limit", got)
+	}
+}
+
+func TestSharedRates_Invalid(t *testing.T) {
+	for _, raw := range []string{
+		`{"max_parallel_connections": 10, "redis": {"addr": "127.0.0.1:6379"}}`,
+		`{"rates": [{"limit": 10}], "redis": {}}`,
+		`{"rates": [{"limit": 10}], "redis": {"addr": "127.0.0.1:6379", "retry_ms": -1}}`,
+	} {
+		if _, err := NewRateLimitGlobalHandler(json.RawMessage(raw)); err == nil {
+			t.Errorf("config %s accepted, want error", raw)
+		}
+	}
+}
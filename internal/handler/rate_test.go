@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestRateLimit_Algorithms(t *testing.T) {
+	base := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	at := func(ms int) time.Time { return base.Add(time.Duration(ms) * time.Millisecond) }
+
+	tests := []struct {
+		name  string
+		cfg   RateConfig
+		times []int  // Connection times in ms
+		want  []bool // Admitted
+	}{
+		{
+			name:  "fixed window resets at the boundary",
+			cfg:   RateConfig{Limit: 2, WindowMs: 1000, Algorithm: RateFixedWindow},
+			times: []int{900, 950, 990, 1000, 1010, 1020},
+			want:  []bool{true, true, false, true, true, false},
+		},
+		{
+			name:  "sliding window counts the last window",
+			cfg:   RateConfig{Limit: 2, WindowMs: 1000},
+			times: []int{900, 950, 1000, 1899, 1900, 1949, 1950},
+			want:  []bool{true, true, false, false, true, false, true},
+		},
+		{
+			name:  "leaky bucket drains at the rate",
+			cfg:   RateConfig{Limit: 2, WindowMs: 1000, Algorithm: RateLeakyBucket},
+			times: []int{0, 0, 0, 499, 1100, 1100, 1100, 1600, 1600},
+			want:  []bool{true, true, false, false, true, true, false, true, false},
+		},
+		{
+			name:  "leaky bucket burst",
+			cfg:   RateConfig{Limit: 10, WindowMs: 1000, Algorithm: RateLeakyBucket, Burst: 1},
+			times: []int{0, 50, 150, 200},
+			want:  []bool{true, false, true, false},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := newRateLimit(tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, ms := range tt.times {
+				if got := r.allow(&Context{}, at(ms)); got != tt.want[i] {
+					t.Errorf("connection %d at %dms: allowed = %v, want %v", i, ms, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimit_Keys(t *testing.T) {
+	conn := func(ip, sni string) *Context {
+		return &Context{
+			ClientAddr: &net.UDPAddr{IP: net.ParseIP(ip), Port: 40000},
+			Hello:      &ClientHello{SNI: sni},
+		}
+	}
+	now := time.Now()
+
+	tests := []struct {
+		per   string
+		conns []*Context
+		want  []bool
+	}{
+		{RatePerGlobal, []*Context{conn("192.0.2.1", "a"), conn("192.0.2.2", "b")}, []bool{true, false}},
+		{RatePerIP, []*Context{conn("192.0.2.1", "a"), conn("192.0.2.2", "a"), conn("::ffff:192.0.2.1", "b")}, []bool{true, true, false}},
+		{RatePerSNI, []*Context{conn("192.0.2.1", "a"), conn("192.0.2.1", "b"), conn("192.0.2.2", "A")}, []bool{true, true, false}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.per, func(t *testing.T) {
+			r, err := newRateLimit(RateConfig{Limit: 1, WindowMs: 60000, Per: tt.per})
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, ctx := range tt.conns {
+				if got := r.allow(ctx, now); got != tt.want[i] {
+					t.Errorf("connection %d: allowed = %v, want %v", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestRateLimit_SweepsIdleKeys(t *testing.T) {
+	for _, algorithm := range []string{RateFixedWindow, RateSlidingWindow, RateLeakyBucket} {
+		t.Run(algorithm, func(t *testing.T) {
+			r, err := newRateLimit(RateConfig{Limit: 1, WindowMs: 1000, Algorithm: algorithm, Per: RatePerIP})
+			if err != nil {
+				t.Fatal(err)
+			}
+			now := time.Now()
+			for i := 1; i <= 100; i++ {
+				r.allow(&Context{ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, byte(i))}}, now)
+			}
+			r.allow(&Context{}, now.Add(2*time.Second))
+			if n := len(r.states); n != 1 {
+				t.Errorf("%d keys after a quiet window, want 1", n)
+			}
+		})
+	}
+}
+
+func TestRateLimit_Invalid(t *testing.T) {
+	for _, cfg := range []RateConfig{
+		{},
+		{Limit: -1},
+		{Limit: 1, WindowMs: -1},
+		{Limit: 1, Algorithm: "token-bucket"},
+		{Limit: 1, Per: "port"},
+		{Limit: 1, Burst: 5},
+	} {
+		if _, err := newRateLimit(cfg); err == nil {
+			t.Errorf("newRateLimit(%+v) succeeded, want error", cfg)
+		}
+	}
+}
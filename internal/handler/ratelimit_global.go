@@ -1,23 +1,84 @@
 package handler
 
 import (
+	"container/list"
 	"encoding/json"
 	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/timerwheel"
 )
 
 func init() {
 	Register("ratelimit-global", NewRateLimitGlobalHandler)
 }
 
+// Rate limit modes.
+const (
+	RateLimitModeDrop  = "drop"  // Drop new connections immediately when the limit is reached
+	RateLimitModeQueue = "queue" // Hold new connections until capacity frees up or the wait times out
+)
+
+const (
+	defaultQueueTimeoutMs = 1000
+	defaultMaxQueued      = 1000
+)
+
 // RateLimitGlobalConfig is the configuration for the global rate limiter.
 type RateLimitGlobalConfig struct {
-	MaxParallelConnections int64 `json:"max_parallel_connections"`
+	MaxParallelConnections int64  `json:"max_parallel_connections"`
+	Mode                   string `json:"mode,omitempty"`             // "drop" (default) or "queue"
+	QueueTimeoutMs         int    `json:"queue_timeout_ms,omitempty"` // Max wait in queue mode (default: 1000)
+	MaxQueued              int    `json:"max_queued,omitempty"`       // Max held connections in queue mode (default: 1000)
+
+	Rates []RateConfig     `json:"rates,omitempty"` // New connection rate limits, all of which must admit a connection
+	Redis *RateRedisConfig `json:"redis,omitempty"` // Shares rates with other relays
 }
 
-// RateLimitGlobalHandler limits the total number of concurrent connections.
-// It uses the proxy's session count which is set in the context before OnConnect.
+// RateLimitGlobalHandler limits the total number of concurrent connections
+// and the rate of new connections, globally or per client IP or SNI.
+// It counts the connections it admits itself, so it works in any chain.
+// Within the proxy, each admitted connection also reserves a proxy-wide
+// session slot, so the limit covers sessions admitted by the handler's
+// predecessor before a reload and connections other chains are admitting.
 type RateLimitGlobalHandler struct {
-	maxParallelConnections int64
+	maxParallelConnections int64        // 0 = no concurrency limit
+	active                 atomic.Int64 // Connections admitted and not yet ended
+	rates                  []*rateLimit
+	shared                 *sharedRates // Nil unless rates are shared through Redis
+
+	// Queue mode
+	queue        bool
+	queueTimeout time.Duration
+	maxQueued    int
+	queueMu      sync.Mutex
+	waiting      list.List // *queuedConn in arrival order
+}
+
+// queuedConn is a connection held in queue mode until a slot frees up.
+type queuedConn struct {
+	ctx    *Context
+	decide func(Result)
+	timer  *timerwheel.Timer
+	elem   *list.Element // Nil once decided
+}
+
+const rateLimitSlotKey = "_ratelimit_slot"
+
+// rateLimitSlot is a connection's place in the count of the handler that
+// admitted it.
+type rateLimitSlot struct {
+	count    *atomic.Int64
+	released atomic.Bool
+}
+
+// release gives the slot back. It is safe to call more than once.
+func (s *rateLimitSlot) release() {
+	if s.released.CompareAndSwap(false, true) {
+		s.count.Add(-1)
+	}
 }
 
 // NewRateLimitGlobalHandler creates a new global rate limiter handler.
@@ -28,10 +89,53 @@ func NewRateLimitGlobalHandler(raw json.RawMessage) (Handler, error) {
 			return nil, fmt.Errorf("invalid ratelimit-global config: %w", err)
 		}
 	}
-	if cfg.MaxParallelConnections <= 0 {
-		return nil, fmt.Errorf("ratelimit-global requires 'max_parallel_connections' > 0")
+	if cfg.MaxParallelConnections < 0 || cfg.MaxParallelConnections == 0 && len(cfg.Rates) == 0 {
+		return nil, fmt.Errorf("ratelimit-global requires 'max_parallel_connections' > 0 or 'rates'")
+	}
+	var shared *sharedRates
+	if cfg.Redis != nil {
+		if len(cfg.Rates) == 0 {
+			return nil, fmt.Errorf("ratelimit-global 'redis' requires 'rates'")
+		}
+		var err error
+		if shared, err = newSharedRates(*cfg.Redis); err != nil {
+			return nil, fmt.Errorf("invalid ratelimit-global config: %w", err)
+		}
+	}
+	rates, err := newRateLimits(cfg.Rates, shared)
+	if err != nil {
+		return nil, fmt.Errorf("invalid ratelimit-global config: %w", err)
+	}
+
+	h := &RateLimitGlobalHandler{
+		maxParallelConnections: cfg.MaxParallelConnections,
+		rates:                  rates,
+		shared:                 shared,
+	}
+
+	switch cfg.Mode {
+	case "", RateLimitModeDrop:
+	case RateLimitModeQueue:
+		if cfg.MaxParallelConnections == 0 {
+			return nil, fmt.Errorf("ratelimit-global 'queue' mode requires 'max_parallel_connections'")
+		}
+		if cfg.QueueTimeoutMs < 0 || cfg.MaxQueued < 0 {
+			return nil, fmt.Errorf("ratelimit-global 'queue_timeout_ms' and 'max_queued' must be >= 0")
+		}
+		if cfg.QueueTimeoutMs == 0 {
+			cfg.QueueTimeoutMs = defaultQueueTimeoutMs
+		}
+		if cfg.MaxQueued == 0 {
+			cfg.MaxQueued = defaultMaxQueued
+		}
+		h.queue = true
+		h.queueTimeout = time.Duration(cfg.QueueTimeoutMs) * time.Millisecond
+		h.maxQueued = cfg.MaxQueued
+	default:
+		return nil, fmt.Errorf("ratelimit-global: unknown mode %q", cfg.Mode)
 	}
-	return &RateLimitGlobalHandler{maxParallelConnections: cfg.MaxParallelConnections}, nil
+
+	return h, nil
 }
 
 // Name returns the handler name.
@@ -39,13 +143,114 @@ func (h *RateLimitGlobalHandler) Name() string {
 	return "ratelimit-global"
 }
 
-// OnConnect checks if the connection limit has been reached.
+// OnConnect admits the connection while it is within every rate limit and
+// the concurrency limit. Rate limits drop at once. At the concurrency limit, queue
+// mode holds the connection up to queue_timeout_ms for a session to end before
+// dropping it; connections deprioritized by the reputation handler are dropped
+// without waiting.
 func (h *RateLimitGlobalHandler) OnConnect(ctx *Context) Result {
-	currentCount := ctx.GetInt64("_session_count")
-	if currentCount >= h.maxParallelConnections {
-		return Result{Action: Drop, Error: fmt.Errorf("max connections exceeded (%d/%d)", currentCount, h.maxParallelConnections)}
+	now := time.Now()
+	for _, r := range h.rates {
+		if !r.allow(ctx, now) {
+			return Result{Action: Drop, Error: fmt.Errorf("connection rate exceeded (%v)", r)}
+		}
+	}
+	if h.maxParallelConnections == 0 {
+		return Result{Action: Continue}
+	}
+
+	count, ok := h.admit(ctx)
+	if ok {
+		return Result{Action: Continue}
+	}
+	if !h.queue || Deprioritized(ctx) {
+		return Result{Action: Drop, Error: fmt.Errorf("max connections exceeded (%d/%d)", count, h.maxParallelConnections)}
+	}
+	return Result{Action: Hold, Hold: func(decide func(Result)) { h.enqueue(ctx, decide) }}
+}
+
+// admit takes a slot for ctx unless the limit is reached, returning the
+// connection count it checked.
+func (h *RateLimitGlobalHandler) admit(ctx *Context) (int64, bool) {
+	for {
+		n := h.active.Load()
+		count := n
+		if ctx.SessionCount != nil {
+			count = max(count, ctx.SessionCount())
+		}
+		if count >= h.maxParallelConnections {
+			return count, false
+		}
+		if !h.active.CompareAndSwap(n, n+1) {
+			continue
+		}
+		// The proxy-wide reservation is what makes concurrent admissions
+		// safe; the session count read above may be stale by now
+		if ctx.TryAcquireSession != nil && !ctx.TryAcquireSession(h.maxParallelConnections) {
+			h.active.Add(-1)
+			return h.maxParallelConnections, false
+		}
+		ctx.Set(rateLimitSlotKey, &rateLimitSlot{count: &h.active})
+		return count, true
+	}
+}
+
+// enqueue holds a connection until a slot frees up or queue_timeout_ms
+// passes. Decisions are made on goroutines of their own: the rest of the
+// chain runs for an admitted connection, and neither the timing wheel nor
+// the disconnect that freed the slot should wait for it.
+func (h *RateLimitGlobalHandler) enqueue(ctx *Context, decide func(Result)) {
+	h.queueMu.Lock()
+	defer h.queueMu.Unlock()
+	if h.waiting.Len() >= h.maxQueued {
+		go decide(Result{Action: Drop, Error: fmt.Errorf("max connections exceeded, wait queue full (%d)", h.maxQueued)})
+		return
+	}
+	q := &queuedConn{ctx: ctx, decide: decide}
+	q.elem = h.waiting.PushBack(q)
+	q.timer = timerwheel.AfterFunc(h.queueTimeout, func() { h.expire(q) })
+	// A slot may have freed up since OnConnect
+	h.admitQueuedLocked()
+}
+
+// expire drops a connection held for queue_timeout_ms.
+func (h *RateLimitGlobalHandler) expire(q *queuedConn) {
+	h.queueMu.Lock()
+	defer h.queueMu.Unlock()
+	if q.elem == nil {
+		return
+	}
+	h.waiting.Remove(q.elem)
+	q.elem = nil
+	go q.decide(Result{Action: Drop, Error: fmt.Errorf("max connections exceeded (%d), queue wait timed out", h.maxParallelConnections)})
+}
+
+// admitQueued admits held connections in arrival order while there are slots.
+func (h *RateLimitGlobalHandler) admitQueued() {
+	h.queueMu.Lock()
+	defer h.queueMu.Unlock()
+	h.admitQueuedLocked()
+}
+
+// admitQueuedLocked is admitQueued with queueMu held.
+func (h *RateLimitGlobalHandler) admitQueuedLocked() {
+	for e := h.waiting.Front(); e != nil; e = h.waiting.Front() {
+		q := e.Value.(*queuedConn)
+		if _, ok := h.admit(q.ctx); !ok {
+			return
+		}
+		h.waiting.Remove(e)
+		q.elem = nil
+		q.timer.Stop()
+		go q.decide(Result{Action: Continue})
 	}
-	return Result{Action: Continue}
+}
+
+// Queued returns the number of connections currently held for capacity.
+func (h *RateLimitGlobalHandler) Queued() int64 {
+	h.queueMu.Lock()
+	defer h.queueMu.Unlock()
+	return int64(h.waiting.Len())
 }
 
 // OnPacket passes through.
@@ -53,5 +258,49 @@ func (h *RateLimitGlobalHandler) OnPacket(ctx *Context, packet []byte, dir Direc
 	return Result{Action: Continue}
 }
 
-// OnDisconnect does nothing - proxy manages the session count.
-func (h *RateLimitGlobalHandler) OnDisconnect(ctx *Context) {}
+// Active returns the number of connections the handler admitted that have
+// not ended.
+func (h *RateLimitGlobalHandler) Active() int64 {
+	return h.active.Load()
+}
+
+// OnDisconnect releases the session's slot and admits held connections.
+func (h *RateLimitGlobalHandler) OnDisconnect(ctx *Context) {
+	h.release(ctx)
+}
+
+// CancelConnect releases the slot of a connection refused later in the chain.
+func (h *RateLimitGlobalHandler) CancelConnect(ctx *Context) {
+	h.release(ctx)
+}
+
+// Close closes the Redis connections of shared rates.
+func (h *RateLimitGlobalHandler) Close() error {
+	if h.shared == nil {
+		return nil
+	}
+	return h.shared.Close()
+}
+
+// Restore counts a restored session without applying the limit.
+func (h *RateLimitGlobalHandler) Restore(ctx *Context, id uint64) Result {
+	h.active.Add(1)
+	ctx.Set(rateLimitSlotKey, &rateLimitSlot{count: &h.active})
+	return Result{Action: Continue}
+}
+
+// release gives back the slot of ctx, which may belong to the handler of a
+// previous chain, and admits held connections.
+func (h *RateLimitGlobalHandler) release(ctx *Context) {
+	if slot, ok := GetValue[*rateLimitSlot](ctx, rateLimitSlotKey); ok {
+		slot.release()
+	}
+	// Free the proxy-wide slot before admitting: the proxy deletes the
+	// session only after OnDisconnect
+	if ctx.ReleaseSession != nil {
+		ctx.ReleaseSession()
+	}
+	if h.queue {
+		h.admitQueued()
+	}
+}
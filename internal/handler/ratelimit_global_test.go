@@ -2,7 +2,12 @@ package handler
 
 import (
 	"encoding/json"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestRateLimitGlobal_RequiresConfig(t *testing.T) {
@@ -28,10 +33,9 @@ func TestRateLimitGlobal_AllowsUnderLimit(t *testing.T) {
 		t.Fatalf("failed to create handler: %v", err)
 	}
 
-	ctx := &Context{}
-	ctx.Set("_session_count", int64(5)) // 5 active, limit is 10
+	admitN(t, h, 5) // 5 active, limit is 10
 
-	result := h.OnConnect(ctx)
+	result := h.OnConnect(&Context{})
 	if result.Action != Continue {
 		t.Errorf("expected Continue, got %v (error: %v)", result.Action, result.Error)
 	}
@@ -43,10 +47,9 @@ func TestRateLimitGlobal_AllowsAtLimit(t *testing.T) {
 		t.Fatalf("failed to create handler: %v", err)
 	}
 
-	ctx := &Context{}
-	ctx.Set("_session_count", int64(9)) // 9 active, limit is 10 -> this would be the 10th
+	admitN(t, h, 9) // 9 active, limit is 10 -> this would be the 10th
 
-	result := h.OnConnect(ctx)
+	result := h.OnConnect(&Context{})
 	if result.Action != Continue {
 		t.Errorf("expected Continue at limit-1, got %v (error: %v)", result.Action, result.Error)
 	}
@@ -58,10 +61,9 @@ func TestRateLimitGlobal_DropsOverLimit(t *testing.T) {
 		t.Fatalf("failed to create handler: %v", err)
 	}
 
-	ctx := &Context{}
-	ctx.Set("_session_count", int64(10)) // 10 active, limit is 10 -> reject new
+	admitN(t, h, 10) // 10 active, limit is 10 -> reject new
 
-	result := h.OnConnect(ctx)
+	result := h.OnConnect(&Context{})
 	if result.Action != Drop {
 		t.Errorf("expected Drop when at limit, got %v", result.Action)
 	}
@@ -76,8 +78,8 @@ func TestRateLimitGlobal_DropsWellOverLimit(t *testing.T) {
 		t.Fatalf("failed to create handler: %v", err)
 	}
 
-	ctx := &Context{}
-	ctx.Set("_session_count", int64(100)) // way over limit
+	// The proxy's session count is a floor, e.g. for sessions from before a reload
+	ctx := &Context{SessionCount: func() int64 { return 100 }}
 
 	result := h.OnConnect(ctx)
 	if result.Action != Drop {
@@ -108,3 +110,312 @@ func TestRateLimitGlobal_Name(t *testing.T) {
 		t.Errorf("expected name 'ratelimit-global', got '%s'", h.Name())
 	}
 }
+
+func TestRateLimitGlobal_InvalidMode(t *testing.T) {
+	_, err := NewRateLimitGlobalHandler(json.RawMessage(`{"max_parallel_connections": 10, "mode": "bounce"}`))
+	if err == nil {
+		t.Error("expected error for unknown mode")
+	}
+}
+
+// hold parks a held connection and returns the channel its decision arrives on.
+func hold(t *testing.T, result Result) <-chan Result {
+	t.Helper()
+	if result.Action != Hold {
+		t.Fatalf("expected Hold, got %v (%v)", result.Action, result.Error)
+	}
+	decided := make(chan Result, 1)
+	result.Hold(func(r Result) { decided <- r })
+	return decided
+}
+
+// decision waits for the decision on a held connection.
+func decision(t *testing.T, decided <-chan Result) Result {
+	t.Helper()
+	select {
+	case r := <-decided:
+		return r
+	case <-time.After(2 * time.Second):
+		t.Fatal("held connection was not decided")
+		return Result{}
+	}
+}
+
+func TestRateLimitGlobal_QueueAdmitsAfterDisconnect(t *testing.T) {
+	h, err := NewRateLimitGlobalHandler(json.RawMessage(`{"max_parallel_connections": 1, "mode": "queue", "queue_timeout_ms": 5000}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	first := admitN(t, h, 1)[0]
+	// OnConnect returns at once; the connection waits without blocking the caller
+	decided := hold(t, h.OnConnect(&Context{}))
+	if n := h.(*RateLimitGlobalHandler).Queued(); n != 1 {
+		t.Fatalf("Queued = %d, want 1", n)
+	}
+	h.OnDisconnect(first)
+
+	if result := decision(t, decided); result.Action != Continue {
+		t.Errorf("expected Continue after slot freed, got %v (error: %v)", result.Action, result.Error)
+	}
+	if n := h.(*RateLimitGlobalHandler).Queued(); n != 0 {
+		t.Errorf("Queued = %d after admission", n)
+	}
+}
+
+func TestRateLimitGlobal_QueueTimeout(t *testing.T) {
+	h, err := NewRateLimitGlobalHandler(json.RawMessage(`{"max_parallel_connections": 1, "mode": "queue", "queue_timeout_ms": 20}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	ctx := &Context{SessionCount: func() int64 { return 1 }}
+
+	start := time.Now()
+	result := decision(t, hold(t, h.OnConnect(ctx)))
+	if result.Action != Drop {
+		t.Errorf("expected Drop after timeout, got %v", result.Action)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("expected the connection to be held for the queue timeout")
+	}
+}
+
+func TestRateLimitGlobal_QueueFull(t *testing.T) {
+	h, err := NewRateLimitGlobalHandler(json.RawMessage(`{"max_parallel_connections": 1, "mode": "queue", "max_queued": 1}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	full := func() int64 { return 1 }
+	hold(t, h.OnConnect(&Context{SessionCount: full})) // Queue now occupied
+
+	start := time.Now()
+	result := decision(t, hold(t, h.OnConnect(&Context{SessionCount: full})))
+	if result.Action != Drop || time.Since(start) > 500*time.Millisecond {
+		t.Errorf("expected immediate Drop when queue is full, got %v", result.Action)
+	}
+}
+
+func TestRateLimitGlobal_QueueSkipsDeprioritized(t *testing.T) {
+	h, err := NewRateLimitGlobalHandler(json.RawMessage(`{"max_parallel_connections": 1, "mode": "queue", "queue_timeout_ms": 5000}`))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+
+	ctx := &Context{SessionCount: func() int64 { return 1 }}
+	ctx.Set(DeprioritizedKey, true)
+
+	start := time.Now()
+	if result := h.OnConnect(ctx); result.Action != Drop {
+		t.Errorf("expected Drop for deprioritized connection, got %v", result.Action)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("deprioritized connection waited %v", elapsed)
+	}
+}
+
+// admitN admits n connections and returns their contexts.
+func admitN(t *testing.T, h Handler, n int) []*Context {
+	t.Helper()
+	ctxs := make([]*Context, n)
+	for i := range ctxs {
+		ctxs[i] = &Context{}
+		if result := h.OnConnect(ctxs[i]); result.Action != Continue {
+			t.Fatalf("connection %d: %v (%v)", i, result.Action, result.Error)
+		}
+	}
+	return ctxs
+}
+
+func TestRateLimitGlobal_OwnsCount(t *testing.T) {
+	h, err := NewRateLimitGlobalHandler(json.RawMessage(`{"max_parallel_connections": 2}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := h.(*RateLimitGlobalHandler)
+	ctxs := admitN(t, h, 2)
+	if result := h.OnConnect(&Context{}); result.Action != Drop {
+		t.Fatalf("third connection: %v, want Drop", result.Action)
+	}
+
+	// Sessions that end and connections refused later in the chain free
+	// their slot once
+	h.OnDisconnect(ctxs[0])
+	h.OnDisconnect(ctxs[0])
+	rl.CancelConnect(ctxs[1])
+	if n := rl.Active(); n != 0 {
+		t.Fatalf("active = %d after release, want 0", n)
+	}
+	h.OnDisconnect(&Context{}) // Never admitted
+	if n := rl.Active(); n != 0 {
+		t.Fatalf("active = %d after unknown disconnect, want 0", n)
+	}
+	admitN(t, h, 2)
+
+	// Restored sessions count without the limit applying
+	restored := &Context{}
+	if result := rl.Restore(restored, 7); result.Action != Continue {
+		t.Fatalf("Restore: %v", result.Action)
+	}
+	if n := rl.Active(); n != 3 {
+		t.Errorf("active = %d after restore, want 3", n)
+	}
+	h.OnDisconnect(restored)
+	if n := rl.Active(); n != 2 {
+		t.Errorf("active = %d after restored session ended, want 2", n)
+	}
+}
+
+func TestRateLimitGlobal_Reload(t *testing.T) {
+	cfg := json.RawMessage(`{"max_parallel_connections": 2}`)
+	old, _ := NewRateLimitGlobalHandler(cfg)
+	var live int64
+	sessionCount := func() int64 { return live }
+	oldCtx := &Context{SessionCount: sessionCount}
+	if result := old.OnConnect(oldCtx); result.Action != Continue {
+		t.Fatal(result.Error)
+	}
+	live = 1
+
+	// The new handler starts without counts; the proxy's count covers the
+	// session admitted before the reload
+	h, _ := NewRateLimitGlobalHandler(cfg)
+	rl := h.(*RateLimitGlobalHandler)
+	ctx := &Context{SessionCount: sessionCount}
+	if result := h.OnConnect(ctx); result.Action != Continue {
+		t.Fatal(result.Error)
+	}
+	live = 2
+	if result := h.OnConnect(&Context{SessionCount: sessionCount}); result.Action != Drop {
+		t.Fatalf("over the limit after reload: %v, want Drop", result.Action)
+	}
+
+	// Old sessions end through the new chain and release the old count
+	h.OnDisconnect(oldCtx)
+	live = 1
+	if n := rl.Active(); n != 1 {
+		t.Errorf("active = %d, want 1", n)
+	}
+	if n := old.(*RateLimitGlobalHandler).Active(); n != 0 {
+		t.Errorf("old handler active = %d, want 0", n)
+	}
+	if result := h.OnConnect(&Context{SessionCount: sessionCount}); result.Action != Continue {
+		t.Errorf("after old session ended: %v, want Continue", result.Action)
+	}
+}
+
+func TestRateLimitGlobal_Rates(t *testing.T) {
+	h, err := NewRateLimitGlobalHandler(json.RawMessage(`{"rates": [
+		{"limit": 2, "window_ms": 60000, "per": "ip"},
+		{"limit": 3, "window_ms": 60000, "algorithm": "fixed-window"}
+	]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := func(ip string) *Context {
+		return &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP(ip), Port: 40000}}
+	}
+
+	for i, tt := range []struct {
+		ip   string
+		want Action
+	}{
+		{"192.0.2.1", Continue},
+		{"192.0.2.1", Continue},
+		{"192.0.2.1", Drop}, // Over the per-IP rate
+		{"192.0.2.2", Continue},
+		{"192.0.2.3", Drop}, // Over the global rate
+	} {
+		result := h.OnConnect(from(tt.ip))
+		if result.Action != tt.want {
+			t.Fatalf("connection %d from %s: %v (%v), want %v", i, tt.ip, result.Action, result.Error, tt.want)
+		}
+		if result.Action == Drop && !strings.Contains(result.Error.Error(), "connection rate exceeded") {
+			t.Errorf("drop error = %v", result.Error)
+		}
+	}
+	if n := h.(*RateLimitGlobalHandler).Active(); n != 0 {
+		t.Errorf("active = %d without max_parallel_connections, want 0", n)
+	}
+}
+
+func TestRateLimitGlobal_InvalidRates(t *testing.T) {
+	for _, raw := range []string{
+		`{"rates": []}`,
+		`{"rates": [{"limit": 0}]}`,
+		`{"rates": [{"limit": 10, "algorithm": "gcra"}]}`,
+		`{"rates": [{"limit": 10}], "mode": "queue"}`,
+	} {
+		if _, err := NewRateLimitGlobalHandler(json.RawMessage(raw)); err == nil {
+			t.Errorf("config %s accepted, want error", raw)
+		}
+	}
+}
+
+// slotContext returns a context that reserves session slots from slots the
+// way the proxy does.
+func slotContext(slots *atomic.Int64) *Context {
+	var held atomic.Bool
+	ctx := &Context{}
+	ctx.TryAcquireSession = func(limit int64) bool {
+		if held.Load() {
+			return slots.Load() <= limit
+		}
+		for {
+			n := slots.Load()
+			if n >= limit {
+				return false
+			}
+			if slots.CompareAndSwap(n, n+1) {
+				held.Store(true)
+				return true
+			}
+		}
+	}
+	ctx.ReleaseSession = func() {
+		if held.CompareAndSwap(true, false) {
+			slots.Add(-1)
+		}
+	}
+	return ctx
+}
+
+func TestRateLimitGlobal_SessionSlots(t *testing.T) {
+	// Two handlers, as after a reload or in two tenant chains, admitting at
+	// once: the proxy-wide slots keep them within the limit together
+	cfg := json.RawMessage(`{"max_parallel_connections": 3}`)
+	a, _ := NewRateLimitGlobalHandler(cfg)
+	b, _ := NewRateLimitGlobalHandler(cfg)
+	var slots atomic.Int64
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	ctxs := make(chan *Context, 40)
+	for i := range 40 {
+		h := a
+		if i%2 == 1 {
+			h = b
+		}
+		wg.Go(func() {
+			ctx := slotContext(&slots)
+			if h.OnConnect(ctx).Action == Continue {
+				admitted.Add(1)
+				ctxs <- ctx
+			} else {
+				ctx.ReleaseSession()
+			}
+		})
+	}
+	wg.Wait()
+	if n := admitted.Load(); n != 3 || slots.Load() != 3 {
+		t.Fatalf("admitted %d with %d slots, want 3", n, slots.Load())
+	}
+
+	// A queued connection gets the slot of a session that ends, before the
+	// proxy deletes the session
+	q, _ := NewRateLimitGlobalHandler(json.RawMessage(`{"max_parallel_connections": 3, "mode": "queue", "queue_timeout_ms": 2000}`))
+	decided := hold(t, q.OnConnect(slotContext(&slots)))
+	q.OnDisconnect(<-ctxs)
+	if result := decision(t, decided); result.Action != Continue {
+		t.Errorf("queued connection: %v (%v)", result.Action, result.Error)
+	}
+}
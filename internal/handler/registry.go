@@ -7,8 +7,11 @@ import (
 
 // HandlerConfig represents a handler configuration from JSON.
 type HandlerConfig struct {
-	Type   string          `json:"type"`
-	Config json.RawMessage `json:"config,omitempty"`
+	Type    string          `json:"type"`
+	Config  json.RawMessage `json:"config,omitempty"`
+	OnDrop  *DropPolicy     `json:"on_drop,omitempty"` // Response to the client when this handler drops
+	Enforce *bool           `json:"enforce,omitempty"` // false: only log what the handler would do (default: true)
+	Feature string          `json:"feature,omitempty"` // Feature flag the handler runs behind (default: always runs)
 }
 
 // HandlerFactory creates a handler from JSON config.
@@ -25,6 +28,7 @@ func Register(name string, factory HandlerFactory) {
 // BuildChain creates a handler chain from configuration.
 func BuildChain(configs []HandlerConfig) (*Chain, error) {
 	var handlers []Handler
+	var policies []*DropPolicy
 	for _, cfg := range configs {
 		factory, ok := registry[cfg.Type]
 		if !ok {
@@ -34,9 +38,25 @@ func BuildChain(configs []HandlerConfig) (*Chain, error) {
 		if err != nil {
 			return nil, fmt.Errorf("failed to create handler %s: %w", cfg.Type, err)
 		}
+		if cfg.Enforce != nil && !*cfg.Enforce {
+			if !canShadow(cfg.Type) {
+				return nil, fmt.Errorf("handler %s cannot run with enforce: false", cfg.Type)
+			}
+			h = newShadowHandler(h)
+		}
+		if cfg.Feature != "" {
+			if err := checkFeature(cfg.Feature, FeatureFlag{}); err != nil {
+				return nil, fmt.Errorf("handler %s: %w", cfg.Type, err)
+			}
+			h = newFeatureHandler(h, cfg.Feature)
+		}
 		handlers = append(handlers, h)
+		policies = append(policies, cfg.OnDrop)
 	}
-	return NewChain(handlers...), nil
+	chain := NewChain(handlers...)
+	chain.policies = policies
+	chain.configs = configs
+	return chain, nil
 }
 
 // ListHandlers returns all registered handler names.
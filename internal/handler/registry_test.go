@@ -0,0 +1,70 @@
+package handler
+
+import (
+	"encoding/json"
+	"slices"
+	"testing"
+)
+
+// fuzzFactories replace factories that act outside the process: they only
+// parse their config, so the fuzzer creates no files and binds no addresses.
+var fuzzFactories = map[string]HandlerFactory{
+	"honeypot": func(raw json.RawMessage) (Handler, error) { return newHoneypot(raw) },
+	"latency-router": func(raw json.RawMessage) (Handler, error) {
+		return newLatencyRouter(raw)
+	},
+	"terminator": func(raw json.RawMessage) (Handler, error) {
+		var cfg TerminatorHandlerConfig
+		return nil, json.Unmarshal(raw, &cfg)
+	},
+}
+
+// fuzzSeeds are valid configs to start mutating from, by handler.
+var fuzzSeeds = map[string][]string{
+	"simple-router":    {`{"backend":"127.0.0.1:4433"}`},
+	"sni-router":       {`{"routes":{"play.example.com":{"backends":[{"addr":"127.0.0.1:4433","weight":2,"max_sessions":10}],"waiting_room":{"max":5}},"*":"127.0.0.1:4434"}}`},
+	"protocol-router":  {`{"routes":{"h3":"127.0.0.1:4433"}}`},
+	"forwarder":        {`{"max_datagram":1400,"oversize":"truncate"}`},
+	"ratelimit-global": {`{"max_parallel_connections":100}`, `{"rates":[{"limit":100,"window_ms":60000,"per":"ip"},{"limit":5,"algorithm":"leaky-bucket","burst":2}]}`},
+	"tarpit":           {`{"networks":["192.0.2.0/24"],"interval":1}`},
+	"honeypot":         {`{"dir":"captures","max_packets":4}`},
+	"hello-sample":     {`{"rate":0.5,"max":10}`},
+	"latency-router":   {`{"backends":["127.0.0.1:4433","127.0.0.1:4434"]}`},
+	"tenants":          {`{"tenants":{"a":{"snis":["a.example.com"],"handlers":[{"type":"simple-router","config":{"backend":"127.0.0.1:4433"}}]}}}`},
+}
+
+// FuzzHandlerConfig feeds configs to every registered handler factory, by
+// the index of its name in ListHandlers order. Factories must return an
+// error for bad configs, never panic.
+func FuzzHandlerConfig(f *testing.F) {
+	saved := make(map[string]HandlerFactory)
+	for name, factory := range fuzzFactories {
+		saved[name] = registry[name]
+		registry[name] = factory
+	}
+	f.Cleanup(func() {
+		for name, factory := range saved {
+			registry[name] = factory
+		}
+	})
+
+	names := ListHandlers()
+	slices.Sort(names)
+	for i, name := range names {
+		f.Add(uint8(i), []byte(`{}`))
+		f.Add(uint8(i), []byte(`null`))
+		for _, seed := range fuzzSeeds[name] {
+			f.Add(uint8(i), []byte(seed))
+		}
+	}
+
+	f.Fuzz(func(t *testing.T, idx uint8, config []byte) {
+		h, err := registry[names[int(idx)%len(names)]](config)
+		if err != nil {
+			return
+		}
+		if c, ok := h.(Closer); ok {
+			c.Close()
+		}
+	})
+}
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"strings"
+)
+
+// RelayScheme marks a backend as another quic-relay instance ("relay://host:port").
+// Datagrams to such a backend carry a hop header with the original client metadata.
+const RelayScheme = "relay://"
+
+// Hop header layout:
+//
+//	magic "QRH1" (4) | flags (1) | header length (2) | session ID (8) | TLVs
+//
+// TLVs (type, length, value) are only present when flagHopMetadata is set.
+var hopMagic = []byte("QRH1")
+
+const (
+	hopFixedLen     = 15
+	hopMaxLen       = hopFixedLen + 3*(2+255) // Fixed part and all TLVs at maximum length
+	flagHopMetadata = 0x01
+
+	hopTLVNode   = 1
+	hopTLVClient = 2 // IP (4 or 16) + port (2)
+	hopTLVSNI    = 3
+)
+
+// HopInfo describes a connection relayed by an upstream quic-relay.
+type HopInfo struct {
+	NodeID     string       // Upstream relay that accepted the client
+	SessionID  uint64       // Session ID on the upstream relay
+	ClientAddr *net.UDPAddr // Original client address
+	SNI        string       // SNI seen by the upstream relay
+}
+
+// ParseRelayBackend splits a "relay://host:port" backend.
+// Returns the address and whether the scheme was present.
+func ParseRelayBackend(backend string) (string, bool) {
+	return strings.CutPrefix(backend, RelayScheme)
+}
+
+// AppendHopHeader appends a hop header to dst. Metadata is included when info has any.
+func AppendHopHeader(dst []byte, info *HopInfo) []byte {
+	start := len(dst)
+	dst = append(dst, hopMagic...)
+	dst = append(dst, 0, 0, 0) // Flags and length, patched below
+	dst = binary.BigEndian.AppendUint64(dst, info.SessionID)
+
+	flags := byte(0)
+	if info.NodeID != "" {
+		dst = appendHopTLV(dst, hopTLVNode, []byte(info.NodeID))
+		flags |= flagHopMetadata
+	}
+	if info.ClientAddr != nil {
+		ip := info.ClientAddr.IP
+		if v4 := ip.To4(); v4 != nil {
+			ip = v4
+		}
+		v := binary.BigEndian.AppendUint16(append([]byte{}, ip...), uint16(info.ClientAddr.Port))
+		dst = appendHopTLV(dst, hopTLVClient, v)
+		flags |= flagHopMetadata
+	}
+	if info.SNI != "" {
+		dst = appendHopTLV(dst, hopTLVSNI, []byte(info.SNI))
+		flags |= flagHopMetadata
+	}
+
+	dst[start+4] = flags
+	binary.BigEndian.PutUint16(dst[start+5:], uint16(len(dst)-start))
+	return dst
+}
+
+func appendHopTLV(dst []byte, typ byte, v []byte) []byte {
+	if len(v) > 255 {
+		v = v[:255]
+	}
+	dst = append(dst, typ, byte(len(v)))
+	return append(dst, v...)
+}
+
+// HasHopHeader reports whether a datagram starts with a hop header.
+func HasHopHeader(packet []byte) bool {
+	return len(packet) >= hopFixedLen && string(packet[:4]) == string(hopMagic)
+}
+
+// ParseHopHeader parses a hop header. It returns the header info (metadata
+// fields are empty when the header carries none) and the encapsulated payload.
+func ParseHopHeader(packet []byte) (*HopInfo, []byte, error) {
+	if !HasHopHeader(packet) {
+		return nil, nil, errors.New("no hop header")
+	}
+	hdrLen := int(binary.BigEndian.Uint16(packet[5:7]))
+	if hdrLen < hopFixedLen || hdrLen > len(packet) {
+		return nil, nil, errors.New("invalid hop header length")
+	}
+	info := &HopInfo{SessionID: binary.BigEndian.Uint64(packet[7:15])}
+
+	if packet[4]&flagHopMetadata != 0 {
+		tlvs := packet[hopFixedLen:hdrLen]
+		for len(tlvs) >= 2 {
+			typ, n := tlvs[0], int(tlvs[1])
+			if len(tlvs) < 2+n {
+				return nil, nil, errors.New("truncated hop TLV")
+			}
+			v := tlvs[2 : 2+n]
+			switch typ {
+			case hopTLVNode:
+				info.NodeID = string(v)
+			case hopTLVClient:
+				if n == 6 || n == 18 {
+					ip := make(net.IP, n-2)
+					copy(ip, v)
+					info.ClientAddr = &net.UDPAddr{IP: ip, Port: int(binary.BigEndian.Uint16(v[n-2:]))}
+				}
+			case hopTLVSNI:
+				info.SNI = string(v)
+			}
+			tlvs = tlvs[2+n:]
+		}
+	}
+	return info, packet[hdrLen:], nil
+}
+
+// OriginalClientAddr returns the client address as seen by the first relay.
+func (c *Context) OriginalClientAddr() *net.UDPAddr {
+	if c.Hop != nil && c.Hop.ClientAddr != nil {
+		return c.Hop.ClientAddr
+	}
+	return c.ClientAddr
+}
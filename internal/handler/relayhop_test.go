@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"net"
+	"testing"
+)
+
+func TestHopHeader_RoundTrip(t *testing.T) {
+	tests := []struct {
+		name string
+		info HopInfo
+	}{
+		{"ipv4", HopInfo{NodeID: "edge-1", SessionID: 42, ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, SNI: "play.example.com"}},
+		{"ipv6", HopInfo{NodeID: "edge-2", SessionID: 1, ClientAddr: &net.UDPAddr{IP: net.ParseIP("2001:db8::1"), Port: 443}}},
+		{"session only", HopInfo{SessionID: 7}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pkt := AppendHopHeader(nil, &tt.info)
+			pkt = append(pkt, "payload"...)
+
+			if !HasHopHeader(pkt) {
+				t.Fatal("header not detected")
+			}
+			got, payload, err := ParseHopHeader(pkt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(payload) != "payload" {
+				t.Errorf("payload = %q", payload)
+			}
+			if got.NodeID != tt.info.NodeID || got.SessionID != tt.info.SessionID || got.SNI != tt.info.SNI {
+				t.Errorf("got %+v, want %+v", got, tt.info)
+			}
+			if tt.info.ClientAddr != nil && got.ClientAddr.String() != tt.info.ClientAddr.String() {
+				t.Errorf("client = %v, want %v", got.ClientAddr, tt.info.ClientAddr)
+			}
+		})
+	}
+}
+
+func TestParseHopHeader_Invalid(t *testing.T) {
+	pkt := AppendHopHeader(nil, &HopInfo{NodeID: "edge"})
+	pkt[6] = 0xff // Length beyond the packet
+	if _, _, err := ParseHopHeader(pkt); err == nil {
+		t.Error("expected error for bad length")
+	}
+	if _, _, err := ParseHopHeader([]byte{0xc0, 0, 0, 0, 1}); err == nil {
+		t.Error("expected error for QUIC packet")
+	}
+}
+
+func TestParseRelayBackend(t *testing.T) {
+	if addr, ok := ParseRelayBackend("relay://10.0.0.1:5520"); !ok || addr != "10.0.0.1:5520" {
+		t.Errorf("got %q, %v", addr, ok)
+	}
+	if addr, ok := ParseRelayBackend("10.0.0.1:5520"); ok || addr != "10.0.0.1:5520" {
+		t.Errorf("got %q, %v", addr, ok)
+	}
+}
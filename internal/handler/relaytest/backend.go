@@ -0,0 +1,158 @@
+package relaytest
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+// Backend is a UDP server on localhost that echoes every datagram and
+// keeps a copy. It is closed when the test ends.
+type Backend struct {
+	Addr string
+
+	conn     *net.UDPConn
+	mu       sync.Mutex
+	received [][]byte
+	notify   chan struct{}
+}
+
+// NewBackend starts an echo backend.
+func NewBackend(t testing.TB) *Backend {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &Backend{Addr: conn.LocalAddr().String(), conn: conn, notify: make(chan struct{}, 1)}
+	t.Cleanup(func() { conn.Close() })
+	go b.serve()
+	return b
+}
+
+func (b *Backend) serve() {
+	buf := make([]byte, 65535)
+	for {
+		n, from, err := b.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.received = append(b.received, append([]byte(nil), buf[:n]...))
+		b.mu.Unlock()
+		select {
+		case b.notify <- struct{}{}:
+		default:
+		}
+		b.conn.WriteToUDP(buf[:n], from)
+	}
+}
+
+// Received returns copies of the datagrams received so far.
+func (b *Backend) Received() [][]byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([][]byte(nil), b.received...)
+}
+
+// WaitFor waits until the backend has received n datagrams, failing t
+// after timeout.
+func (b *Backend) WaitFor(t testing.TB, n int, timeout time.Duration) [][]byte {
+	t.Helper()
+	deadline := time.After(timeout)
+	for {
+		if got := b.Received(); len(got) >= n {
+			return got
+		}
+		select {
+		case <-b.notify:
+		case <-deadline:
+			t.Fatalf("backend received %d datagrams within %v, want %d", len(b.Received()), timeout, n)
+			return nil
+		}
+	}
+}
+
+// QUICBackend is a QUIC server on localhost that echoes every stream. It
+// accepts any ALPN and uses a self-signed certificate. It is closed when the
+// test ends.
+type QUICBackend struct {
+	Addr string
+}
+
+// NewQUICBackend starts a QUIC echo backend.
+func NewQUICBackend(t testing.TB) *QUICBackend {
+	t.Helper()
+	cert := selfSigned(t)
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: hello.SupportedProtos}, nil
+		},
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go echoStreams(conn)
+		}
+	}()
+	return &QUICBackend{Addr: ln.Addr().String()}
+}
+
+func echoStreams(conn *quic.Conn) {
+	for {
+		s, err := conn.AcceptStream(conn.Context())
+		if err != nil {
+			return
+		}
+		go func() {
+			io.Copy(s, s)
+			s.Close()
+		}()
+	}
+}
+
+// Dial opens a QUIC connection to addr, usually the relay in front of the
+// backend, with the given server name and ALPN. Certificates are not verified.
+func Dial(t testing.TB, addr, sni string, alpn ...string) *quic.Conn {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{ServerName: sni, NextProtos: alpn, InsecureSkipVerify: true}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.CloseWithError(0, "") })
+	return conn
+}
+
+func selfSigned(t testing.TB) tls.Certificate {
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(24 * time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
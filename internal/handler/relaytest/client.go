@@ -0,0 +1,52 @@
+package relaytest
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// Client is a fake ClientConn. It keeps the datagrams handlers send to the
+// client so tests can read them.
+type Client struct {
+	Addr *net.UDPAddr // Expected destination of the datagrams
+
+	received chan []byte
+}
+
+func newClient(addr *net.UDPAddr) *Client {
+	return &Client{Addr: addr, received: make(chan []byte, 1024)}
+}
+
+// WriteToUDP records b. Datagrams beyond the buffer of 1024 are dropped, as
+// a full socket buffer would.
+func (c *Client) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	select {
+	case c.received <- append([]byte(nil), b...):
+	default:
+	}
+	return len(b), nil
+}
+
+// LocalAddr returns DefaultRelayAddr.
+func (c *Client) LocalAddr() net.Addr {
+	return DefaultRelayAddr
+}
+
+// Next returns the next datagram sent to the client, failing t if none
+// arrives within timeout.
+func (c *Client) Next(t testing.TB, timeout time.Duration) []byte {
+	t.Helper()
+	select {
+	case b := <-c.received:
+		return b
+	case <-time.After(timeout):
+		t.Fatalf("no datagram to the client within %v", timeout)
+		return nil
+	}
+}
+
+// Pending returns the number of datagrams not read yet.
+func (c *Client) Pending() int {
+	return len(c.received)
+}
@@ -0,0 +1,161 @@
+// Package relaytest provides utilities for testing handlers: fake client
+// connections, scripted packet exchanges, echo backends and assertions on
+// handler and chain results.
+//
+// A typical test builds a connection, runs it through the handler and checks
+// what the handler decided:
+//
+//	h, _ := NewMyHandler(nil)
+//	conn := relaytest.NewConn(relaytest.SNI("play.example.com"))
+//	relaytest.Run(t, h, conn, handler.Continue,
+//		relaytest.In([]byte{0x40, 1}, handler.Continue),
+//	)
+//	relaytest.ExpectBackend(t, conn, "10.0.0.1:5520")
+package relaytest
+
+import (
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+// Defaults of NewConn.
+var (
+	DefaultClientAddr = &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	DefaultRelayAddr  = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5520}
+)
+
+// Conn is a fake client connection. Its Ctx is what the proxy would pass to
+// handlers; the callbacks the proxy sets are recorded instead of sending.
+type Conn struct {
+	Ctx    *handler.Context
+	Client *Client // Ctx.ProxyConn, receives what handlers send to the client
+
+	mu      sync.Mutex
+	closes  []Close
+	acks    atomic.Int32
+	dropped atomic.Bool
+}
+
+// Close is a CONNECTION_CLOSE a handler sent via Context.Refuse.
+type Close struct {
+	Code   uint64
+	Reason string
+}
+
+// Option configures a Conn.
+type Option func(*Conn)
+
+// SNI sets the server name of the connection's ClientHello.
+func SNI(sni string) Option {
+	return func(c *Conn) { c.hello().SNI = sni }
+}
+
+// ALPN sets the application protocols of the connection's ClientHello.
+func ALPN(protos ...string) Option {
+	return func(c *Conn) { c.hello().ALPNProtocols = protos }
+}
+
+// ClientHello sets the raw ClientHello handshake message, for handlers that
+// fingerprint clients.
+func ClientHello(raw []byte) Option {
+	return func(c *Conn) { c.hello().Raw = raw }
+}
+
+// ClientAddr sets the client address, "ip:port".
+func ClientAddr(addr string) Option {
+	return func(c *Conn) {
+		c.Ctx.ClientAddr = net.UDPAddrFromAddrPort(netip.MustParseAddrPort(addr))
+		c.Client.Addr = c.Ctx.ClientAddr
+	}
+}
+
+// Protocol makes the connection a non-QUIC flow of the named protocol rule.
+// Such flows have no ClientHello.
+func Protocol(name string) Option {
+	return func(c *Conn) {
+		c.Ctx.Protocol = name
+		c.Ctx.Hello = nil
+	}
+}
+
+// Initial sets the first packet of the connection.
+func Initial(packet []byte) Option {
+	return func(c *Conn) { c.Ctx.InitialPacket = packet }
+}
+
+// Value sets a context value, as an earlier handler would.
+func Value(key string, value any) Option {
+	return func(c *Conn) { c.Ctx.Set(key, value) }
+}
+
+// RoutedTo sets the backend a router would have picked.
+func RoutedTo(addr string) Option {
+	return Value("backend", addr)
+}
+
+// NewConn returns a QUIC connection from DefaultClientAddr with an empty
+// ClientHello, modified by opts.
+func NewConn(opts ...Option) *Conn {
+	c := &Conn{Client: newClient(DefaultClientAddr)}
+	c.Ctx = &handler.Context{
+		ClientAddr:    DefaultClientAddr,
+		InitialPacket: []byte{0xc0, 0, 0, 0, 1},
+		Hello:         &handler.ClientHello{},
+		ProxyConn:     c.Client,
+		SessionCount:  func() int64 { return 0 },
+	}
+	c.Ctx.SendConnectionClose = func(code uint64, reason string) error {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		c.closes = append(c.closes, Close{Code: code, Reason: reason})
+		return nil
+	}
+	c.Ctx.SendInitialAck = func() error {
+		c.acks.Add(1)
+		return nil
+	}
+	c.Ctx.DropSession = func() { c.dropped.Store(true) }
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+func (c *Conn) hello() *handler.ClientHello {
+	if c.Ctx.Hello == nil {
+		c.Ctx.Hello = &handler.ClientHello{}
+	}
+	return c.Ctx.Hello
+}
+
+// WithSession gives the connection a session without a backend, as if a
+// forwarder had handled it, so OnPacket and OnDisconnect can be tested alone.
+func (c *Conn) WithSession(id uint64) *Conn {
+	s := &handler.Session{ID: id, CreatedAt: time.Now()}
+	s.SetClientAddr(c.Ctx.ClientAddr)
+	s.Touch()
+	c.Ctx.Session = s
+	return c
+}
+
+// Closes returns the CONNECTION_CLOSEs sent to the client.
+func (c *Conn) Closes() []Close {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]Close(nil), c.closes...)
+}
+
+// Acks returns how often a handler acknowledged the client's Initial.
+func (c *Conn) Acks() int {
+	return int(c.acks.Load())
+}
+
+// Dropped reports whether a handler removed the session via Context.Drop.
+func (c *Conn) Dropped() bool {
+	return c.dropped.Load()
+}
@@ -0,0 +1,71 @@
+package relaytest_test
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/handler/relaytest"
+)
+
+func TestChainToEchoBackend(t *testing.T) {
+	backend := relaytest.NewBackend(t)
+	chain := relaytest.Chain(t, `[
+		{"type": "sni-router", "config": {"routes": {"play.example.com": "`+backend.Addr+`"}}},
+		{"type": "forwarder"}
+	]`)
+
+	initial := []byte{0xc0, 0, 0, 0, 1, 8}
+	conn := relaytest.NewConn(relaytest.SNI("play.example.com"), relaytest.ClientAddr("127.0.0.1:40001"), relaytest.Initial(initial))
+	relaytest.Run(t, chain, conn, handler.Handled,
+		relaytest.In([]byte{0x40, 1, 2}, handler.Handled),
+	)
+	relaytest.ExpectBackend(t, conn, backend.Addr)
+
+	got := backend.WaitFor(t, 2, 2*time.Second)
+	if !bytes.Equal(got[0], initial) || !bytes.Equal(got[1], []byte{0x40, 1, 2}) {
+		t.Errorf("backend received %x", got)
+	}
+	if echo := conn.Client.Next(t, 2*time.Second); !bytes.Equal(echo, initial) {
+		t.Errorf("client received %x", echo)
+	}
+
+	// Unknown names are dropped by the router
+	relaytest.Run(t, chain, relaytest.NewConn(relaytest.SNI("other.example.com")), handler.Drop)
+}
+
+func TestConn(t *testing.T) {
+	conn := relaytest.NewConn(relaytest.Value("tenant", "blue")).WithSession(7)
+	relaytest.ExpectValue(t, conn, "tenant", "blue")
+	if err := conn.Ctx.Refuse(0x2, "full"); err != nil {
+		t.Fatal(err)
+	}
+	conn.Ctx.SendInitialAck()
+	conn.Ctx.Drop()
+	if c := conn.Closes(); len(c) != 1 || c[0].Reason != "full" || conn.Acks() != 1 || !conn.Dropped() {
+		t.Errorf("closes = %v, acks = %d, dropped = %v", c, conn.Acks(), conn.Dropped())
+	}
+	if conn.Ctx.Session.ID != 7 || !conn.Ctx.Session.ClientAddr().IP.Equal(relaytest.DefaultClientAddr.IP) {
+		t.Errorf("session = %+v", conn.Ctx.Session)
+	}
+	if flow := relaytest.NewConn(relaytest.Protocol("raknet")); flow.Ctx.Hello != nil {
+		t.Error("non-QUIC flow has a ClientHello")
+	}
+}
+
+func TestQUICBackend(t *testing.T) {
+	backend := relaytest.NewQUICBackend(t)
+	conn := relaytest.Dial(t, backend.Addr, "play.example.com", "hytale/1")
+	s, err := conn.OpenStreamSync(t.Context())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.Write([]byte("ping"))
+	s.Close()
+	got, err := io.ReadAll(s)
+	if err != nil || string(got) != "ping" {
+		t.Errorf("echo = %q, %v", got, err)
+	}
+}
@@ -0,0 +1,125 @@
+package relaytest
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+// Target is a handler or a *handler.Chain.
+type Target interface {
+	OnConnect(ctx *handler.Context) handler.Result
+	OnPacket(ctx *handler.Context, packet []byte, dir handler.Direction) handler.Result
+	OnDisconnect(ctx *handler.Context)
+}
+
+// Step is a packet of a scripted exchange and the action expected for it.
+type Step struct {
+	Dir    handler.Direction
+	Packet []byte
+	Want   handler.Action
+}
+
+// In is a client packet expected to get action want.
+func In(packet []byte, want handler.Action) Step {
+	return Step{Dir: handler.Inbound, Packet: packet, Want: want}
+}
+
+// Out is a backend packet expected to get action want.
+func Out(packet []byte, want handler.Action) Step {
+	return Step{Dir: handler.Outbound, Packet: packet, Want: want}
+}
+
+// Chain builds a chain from a JSON array of handler configs, as in the
+// "handlers" section of the relay config.
+func Chain(t testing.TB, config string) *handler.Chain {
+	t.Helper()
+	var cfgs []handler.HandlerConfig
+	if err := json.Unmarshal([]byte(config), &cfgs); err != nil {
+		t.Fatalf("invalid chain config: %v", err)
+	}
+	chain, err := handler.BuildChain(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(chain.Close)
+	return chain
+}
+
+// Run connects conn through target, expecting wantConnect, then plays the
+// steps in order. Unless the connection was dropped, target's OnDisconnect
+// runs when the test ends.
+func Run(t testing.TB, target Target, conn *Conn, wantConnect handler.Action, steps ...Step) {
+	t.Helper()
+	if res := ExpectConnect(t, target, conn, wantConnect); res.Action == handler.Drop {
+		return
+	}
+	t.Cleanup(func() { target.OnDisconnect(conn.Ctx) })
+	for i, s := range steps {
+		if res := target.OnPacket(conn.Ctx, s.Packet, s.Dir); res.Action != s.Want {
+			t.Errorf("step %d: %s = %s (%v), want %s", i, dirName(s.Dir), actionName(res.Action), res.Error, actionName(s.Want))
+		}
+	}
+}
+
+// ExpectConnect runs target's OnConnect for conn and checks the action.
+func ExpectConnect(t testing.TB, target Target, conn *Conn, want handler.Action) handler.Result {
+	t.Helper()
+	res := target.OnConnect(conn.Ctx)
+	if res.Action != want {
+		t.Errorf("OnConnect = %s (%v), want %s", actionName(res.Action), res.Error, actionName(want))
+	}
+	return res
+}
+
+// ExpectPacket runs target's OnPacket for conn and checks the action.
+func ExpectPacket(t testing.TB, target Target, conn *Conn, dir handler.Direction, packet []byte, want handler.Action) handler.Result {
+	t.Helper()
+	res := target.OnPacket(conn.Ctx, packet, dir)
+	if res.Action != want {
+		t.Errorf("%s = %s (%v), want %s", dirName(dir), actionName(res.Action), res.Error, actionName(want))
+	}
+	return res
+}
+
+// ExpectBackend checks the backend a router picked for conn.
+func ExpectBackend(t testing.TB, conn *Conn, want string) {
+	t.Helper()
+	if got := conn.Ctx.GetString("backend"); got != want {
+		t.Errorf("backend = %q, want %q", got, want)
+	}
+}
+
+// ExpectValue checks a context value set by a handler.
+func ExpectValue(t testing.TB, conn *Conn, key string, want any) {
+	t.Helper()
+	got, ok := conn.Ctx.Get(key)
+	if !ok || !reflect.DeepEqual(got, want) {
+		t.Errorf("value %q = %v (set: %v), want %v", key, got, ok, want)
+	}
+}
+
+func actionName(a handler.Action) string {
+	switch a {
+	case handler.Continue:
+		return "Continue"
+	case handler.Handled:
+		return "Handled"
+	case handler.Drop:
+		return "Drop"
+	case handler.Redirect:
+		return "Redirect"
+	case handler.Hold:
+		return "Hold"
+	}
+	return "unknown"
+}
+
+func dirName(d handler.Direction) string {
+	if d == handler.Outbound {
+		return "outbound OnPacket"
+	}
+	return "inbound OnPacket"
+}
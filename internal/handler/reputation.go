@@ -0,0 +1,590 @@
+package handler
+
+import (
+	"cmp"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quic-relay/internal/privacy"
+)
+
+func init() {
+	Register("reputation", NewReputationHandler)
+}
+
+// DeprioritizedKey is the context key set to true on connections from sources
+// whose reputation score reached deprioritize_score. Such sessions are evicted
+// first when the session table fills up and are not queued by ratelimit-global.
+const DeprioritizedKey = "deprioritized"
+
+// Deprioritized reports whether the reputation handler deprioritized ctx.
+func Deprioritized(ctx *Context) bool {
+	v, _ := GetValue[bool](ctx, DeprioritizedKey)
+	return v
+}
+
+// challengeMinDelay is how long after a challenge a retransmitted Initial must
+// arrive to solve it. Clients retransmit after a probe timeout of about a
+// second; floods that send every packet twice right away do not pass.
+const challengeMinDelay = 500 * time.Millisecond
+
+// handshakeFirstFlight is the most client packets a session may have forwarded
+// and still count as a failed handshake when it ends without a clean close.
+const handshakeFirstFlight = 2
+
+// ReputationConfig is the configuration for the reputation handler.
+type ReputationConfig struct {
+	Churn            *float64 `json:"churn,omitempty"`             // Points per new connection (default: 1)
+	HandshakeFailure *float64 `json:"handshake_failure,omitempty"` // Points per session that never completed a handshake (default: 5)
+	Refused          *float64 `json:"refused,omitempty"`           // Points when a later handler refuses the connection, e.g. a rate limit (default: 10)
+	Violation        *float64 `json:"violation,omitempty"`         // Points when the proxy's violations policy drops for a protocol violation (default: 20)
+	HalfLife         int      `json:"half_life,omitempty"`         // Seconds for earned points to halve (default: 300)
+	Honeypot         *float64 `json:"honeypot,omitempty"`          // Fixed points of sources a honeypot route saw, while listed (default: 100)
+
+	Networks     map[string]float64 `json:"networks,omitempty"`      // Client CIDR -> fixed points, negative to trust
+	NetworksFile string             `json:"networks_file,omitempty"` // CSV of "cidr,points[,label]" lines (e.g. converted GeoIP/ASN data)
+
+	DeprioritizeScore float64 `json:"deprioritize_score,omitempty"` // Score from which connections are deprioritized (default: 50)
+	ChallengeScore    float64 `json:"challenge_score,omitempty"`    // Score from which QUIC clients must retransmit their Initial (0 = off)
+	ChallengeWindow   int     `json:"challenge_window,omitempty"`   // Seconds a challenge stays open (default: 10)
+	DropScore         float64 `json:"drop_score,omitempty"`         // Score from which connections are dropped (default: 100)
+
+	IPv6Prefix int `json:"ipv6_prefix,omitempty"` // IPv6 clients are scored per prefix of this length (default: 64)
+	MaxEntries int `json:"max_entries,omitempty"` // Sources tracked at once (default: 100000)
+}
+
+// ReputationScore is the admin view of one source's score.
+type ReputationScore struct {
+	Source   string  `json:"source"`
+	Score    float64 `json:"score"`             // Base plus decayed earned points
+	Base     float64 `json:"base,omitempty"`    // Fixed points of the source's network
+	Network  string  `json:"network,omitempty"` // Label of the matching networks_file line
+	Action   string  `json:"action,omitempty"`  // "drop", "challenge" or "deprioritize" at the current score
+	Updated  string  `json:"updated,omitempty"`
+	Connects uint64  `json:"connects"`
+	Failures uint64  `json:"handshake_failures"`
+	Refusals uint64  `json:"refusals"`
+	Dropped  uint64  `json:"dropped"`
+
+	Violations uint64 `json:"violations,omitempty"` // Protocol violations the proxy dropped for
+
+	Challenged uint64 `json:"challenged,omitempty"` // Initials dropped pending a retransmit
+	Solved     uint64 `json:"solved,omitempty"`     // Challenges answered by a retransmit
+}
+
+// ipReputation holds the earned points of one source. Points are stored as of
+// updated and decay from there on every read.
+type ipReputation struct {
+	points   float64
+	updated  time.Time
+	connects uint64
+	failures uint64
+	refusals uint64
+	dropped  uint64
+
+	violations         uint64
+	challenged, solved uint64
+}
+
+// reputations is package-level so scores survive handler chain reloads.
+var reputations = struct {
+	sync.Mutex
+	m map[netip.Addr]*ipReputation
+}{m: make(map[netip.Addr]*ipReputation)}
+
+// challenges holds when each open challenge was issued, by source and DCID.
+// Package-level like reputations, so reloads do not reopen solved challenges.
+var challenges = struct {
+	sync.Mutex
+	m map[string]time.Time
+}{m: make(map[string]time.Time)}
+
+// reputationStateKey holds the source address a connection is scored under.
+const reputationStateKey = "_reputation_source"
+
+// networkScore is a networks entry: fixed points and an optional label.
+type networkScore struct {
+	points float64
+	label  string
+}
+
+// ReputationHandler scores client sources and drops or deprioritizes bad ones.
+// Place it before rate limiters and routers so it sees their refusals.
+type ReputationHandler struct {
+	churn, failure, refused float64
+	violation               float64
+	honeypot                float64
+	halfLife                time.Duration
+	deprioritize, drop      float64
+	challenge               float64 // 0 when off
+	challengeWindow         time.Duration
+	ipv6Bits                int
+	maxEntries              int
+
+	byBits map[int]map[netip.Prefix]networkScore
+	bits   []int // Prefix lengths present, longest first
+
+	now func() time.Time
+}
+
+// NewReputationHandler creates a new reputation handler.
+func NewReputationHandler(raw json.RawMessage) (Handler, error) {
+	var cfg ReputationConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid reputation config: %w", err)
+		}
+	}
+
+	h := &ReputationHandler{
+		churn:           pointsOr(cfg.Churn, 1),
+		failure:         pointsOr(cfg.HandshakeFailure, 5),
+		refused:         pointsOr(cfg.Refused, 10),
+		violation:       pointsOr(cfg.Violation, 20),
+		honeypot:        pointsOr(cfg.Honeypot, 100),
+		halfLife:        time.Duration(cfg.HalfLife) * time.Second,
+		deprioritize:    cfg.DeprioritizeScore,
+		drop:            cfg.DropScore,
+		challenge:       cfg.ChallengeScore,
+		challengeWindow: time.Duration(cfg.ChallengeWindow) * time.Second,
+		ipv6Bits:        cfg.IPv6Prefix,
+		maxEntries:      cfg.MaxEntries,
+		byBits:          make(map[int]map[netip.Prefix]networkScore),
+		now:             time.Now,
+	}
+	if cfg.HalfLife < 0 || cfg.DeprioritizeScore < 0 || cfg.ChallengeScore < 0 || cfg.DropScore < 0 ||
+		cfg.ChallengeWindow < 0 || cfg.MaxEntries < 0 {
+		return nil, fmt.Errorf("reputation 'half_life', 'challenge_window', scores and 'max_entries' must be >= 0")
+	}
+	if h.halfLife == 0 {
+		h.halfLife = 300 * time.Second
+	}
+	if h.deprioritize == 0 {
+		h.deprioritize = 50
+	}
+	if h.drop == 0 {
+		h.drop = 100
+	}
+	if h.challengeWindow == 0 {
+		h.challengeWindow = 10 * time.Second
+	}
+	if h.ipv6Bits == 0 {
+		h.ipv6Bits = 64
+	}
+	if h.ipv6Bits < 0 || h.ipv6Bits > 128 {
+		return nil, fmt.Errorf("reputation 'ipv6_prefix' must be between 1 and 128")
+	}
+	if h.maxEntries == 0 {
+		h.maxEntries = 100000
+	}
+
+	for cidr, points := range cfg.Networks {
+		if err := h.addNetwork(cidr, networkScore{points: points}); err != nil {
+			return nil, fmt.Errorf("invalid reputation config: %w", err)
+		}
+	}
+	if cfg.NetworksFile != "" {
+		f, err := os.Open(cfg.NetworksFile)
+		if err != nil {
+			return nil, fmt.Errorf("reputation networks_file: %w", err)
+		}
+		defer f.Close()
+		if err := h.loadNetworks(f); err != nil {
+			return nil, fmt.Errorf("reputation networks_file %s: %w", cfg.NetworksFile, err)
+		}
+	}
+	return h, nil
+}
+
+// pointsOr returns *p, or def when the weight is not configured.
+func pointsOr(p *float64, def float64) float64 {
+	if p == nil {
+		return def
+	}
+	return *p
+}
+
+// addNetwork assigns fixed points to a CIDR (or bare IP).
+func (h *ReputationHandler) addNetwork(cidr string, ns networkScore) error {
+	p, err := parseClientPrefix(cidr)
+	if err != nil {
+		return err
+	}
+	m, ok := h.byBits[p.Bits()]
+	if !ok {
+		m = make(map[netip.Prefix]networkScore)
+		h.byBits[p.Bits()] = m
+		h.bits = append(h.bits, p.Bits())
+		slices.SortFunc(h.bits, func(a, b int) int { return b - a })
+	}
+	m[p] = ns
+	return nil
+}
+
+// loadNetworks reads "cidr,points[,label]" records. Lines starting with '#'
+// and a "network,..." header are skipped.
+func (h *ReputationHandler) loadNetworks(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		line, _ := cr.FieldPos(0)
+		if len(rec) < 2 {
+			return fmt.Errorf("line %d: expected 'cidr,points'", line)
+		}
+		if rec[0] == "network" {
+			continue
+		}
+		points, err := strconv.ParseFloat(strings.TrimSpace(rec[1]), 64)
+		if err != nil {
+			return fmt.Errorf("line %d: invalid points %q", line, rec[1])
+		}
+		ns := networkScore{points: points}
+		if len(rec) > 2 {
+			ns.label = strings.TrimSpace(rec[2])
+		}
+		if err := h.addNetwork(rec[0], ns); err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// network returns the fixed points of addr's longest matching network.
+func (h *ReputationHandler) network(addr netip.Addr) networkScore {
+	addr = mapClientAddr(addr)
+	for _, bits := range h.bits {
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if ns, ok := h.byBits[bits][p]; ok {
+			return ns
+		}
+	}
+	return networkScore{}
+}
+
+// base returns the fixed points of src: those of its network, plus the
+// honeypot points while a honeypot route has the source listed.
+func (h *ReputationHandler) base(src netip.Addr) networkScore {
+	ns := h.network(src)
+	if h.honeypot != 0 && honeypotListed(src, h.now()) {
+		ns.points += h.honeypot
+		ns.label = cmp.Or(ns.label, "honeypot")
+	}
+	return ns
+}
+
+// source returns the address a client is scored under: the IPv4 address, or
+// the IPv6 address masked to ipv6_prefix.
+func (h *ReputationHandler) source(ip net.IP) (netip.Addr, bool) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return addr, false
+	}
+	addr = addr.Unmap()
+	if addr.Is6() {
+		if p, err := addr.Prefix(h.ipv6Bits); err == nil {
+			addr = p.Addr()
+		}
+	}
+	return addr, true
+}
+
+// clientSource returns the source of a connection's original client.
+func (h *ReputationHandler) clientSource(ctx *Context) (netip.Addr, bool) {
+	client := ctx.OriginalClientAddr()
+	if client == nil {
+		return netip.Addr{}, false
+	}
+	return h.source(client.IP)
+}
+
+// decayed returns rep's earned points as of now.
+func (h *ReputationHandler) decayed(rep *ipReputation, now time.Time) float64 {
+	elapsed := now.Sub(rep.updated)
+	if elapsed <= 0 {
+		return rep.points
+	}
+	return rep.points * math.Exp2(-elapsed.Seconds()/h.halfLife.Seconds())
+}
+
+// record adds points to a source and applies fn to its entry. Returns the
+// source's earned points afterwards. Must not be called with reputations held.
+func (h *ReputationHandler) record(src netip.Addr, points float64, fn func(*ipReputation)) float64 {
+	now := h.now()
+	reputations.Lock()
+	defer reputations.Unlock()
+	rep := reputations.m[src]
+	if rep == nil {
+		if len(reputations.m) >= h.maxEntries {
+			h.prune(now)
+			if len(reputations.m) >= h.maxEntries {
+				return points
+			}
+		}
+		rep = &ipReputation{}
+		reputations.m[src] = rep
+	}
+	rep.points = max(0, h.decayed(rep, now)+points)
+	rep.updated = now
+	if fn != nil {
+		fn(rep)
+	}
+	return rep.points
+}
+
+// prune forgets sources whose earned points decayed below one.
+// Called with reputations held.
+func (h *ReputationHandler) prune(now time.Time) {
+	for src, rep := range reputations.m {
+		if h.decayed(rep, now) < 1 {
+			delete(reputations.m, src)
+		}
+	}
+}
+
+// action returns what happens to new connections at score.
+func (h *ReputationHandler) action(score float64) string {
+	switch {
+	case score >= h.drop:
+		return "drop"
+	case h.challenge > 0 && score >= h.challenge:
+		return "challenge"
+	case score >= h.deprioritize:
+		return "deprioritize"
+	default:
+		return ""
+	}
+}
+
+// Name returns the handler name.
+func (h *ReputationHandler) Name() string {
+	return "reputation"
+}
+
+// OnConnect scores the new connection's source, dropping it or marking it
+// deprioritized when the score is too high.
+func (h *ReputationHandler) OnConnect(ctx *Context) Result {
+	src, ok := h.clientSource(ctx)
+	if !ok {
+		return Result{Action: Continue}
+	}
+	ctx.Set(reputationStateKey, src)
+
+	base := h.base(src)
+	score := base.points + h.record(src, h.churn, func(rep *ipReputation) { rep.connects++ })
+	if score >= h.drop {
+		h.record(src, 0, func(rep *ipReputation) { rep.dropped++ })
+		return Result{Action: Drop, Error: fmt.Errorf("reputation score %.0f of %s reached %.0f", score, src, h.drop)}
+	}
+	if h.challenge > 0 && score >= h.challenge && ctx.Hello != nil {
+		if !h.solve(src, ctx.InitialPacket) {
+			h.record(src, 0, func(rep *ipReputation) { rep.challenged++ })
+			return Result{
+				Action: Drop,
+				Error:  fmt.Errorf("reputation score %.0f of %s: challenged, waiting for a retransmit", score, src),
+				Policy: &DropPolicy{Action: DropSilent}, // Any response would end the client's retries
+			}
+		}
+		h.record(src, 0, func(rep *ipReputation) { rep.solved++ })
+	}
+	if score >= h.deprioritize {
+		ctx.Set(DeprioritizedKey, true)
+	}
+	return Result{Action: Continue}
+}
+
+// solve reports whether the Initial answers an open challenge of src: it
+// retransmits an Initial dropped earlier, for the same DCID, at least
+// challengeMinDelay and at most challenge_window later. Otherwise a new
+// challenge is opened unless one is pending.
+func (h *ReputationHandler) solve(src netip.Addr, initial []byte) bool {
+	if len(initial) < 6 || len(initial) < 6+int(initial[5]) {
+		return true // Not a long header packet to challenge
+	}
+	key := src.String() + "|" + string(initial[6:6+int(initial[5])])
+	now := h.now()
+
+	challenges.Lock()
+	defer challenges.Unlock()
+	issued, ok := challenges.m[key]
+	switch {
+	case ok && now.Sub(issued) > h.challengeWindow:
+	case ok && now.Sub(issued) >= challengeMinDelay:
+		delete(challenges.m, key)
+		return true
+	case ok:
+		return false
+	}
+	if len(challenges.m) >= h.maxEntries {
+		for k, t := range challenges.m {
+			if now.Sub(t) > h.challengeWindow {
+				delete(challenges.m, k)
+			}
+		}
+		if len(challenges.m) >= h.maxEntries {
+			return false
+		}
+	}
+	challenges.m[key] = now
+	return false
+}
+
+// CancelConnect penalizes a source whose connection a later handler refused.
+func (h *ReputationHandler) CancelConnect(ctx *Context) {
+	if src, ok := GetValue[netip.Addr](ctx, reputationStateKey); ok {
+		h.record(src, h.refused, func(rep *ipReputation) { rep.refusals++ })
+	}
+}
+
+// PenalizeViolation penalizes the source of client for a protocol violation
+// the proxy dropped. Called by the proxy, outside of any session.
+func (h *ReputationHandler) PenalizeViolation(client net.IP) {
+	if src, ok := h.source(client); ok {
+		h.record(src, h.violation, func(rep *ipReputation) { rep.violations++ })
+	}
+}
+
+// OnPacket passes through.
+func (h *ReputationHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect penalizes sessions that ended without completing a handshake:
+// they timed out or failed before the backend answered, or after the client
+// sent no more than its first flight.
+func (h *ReputationHandler) OnDisconnect(ctx *Context) {
+	src, ok := GetValue[netip.Addr](ctx, reputationStateKey)
+	if !ok || ctx.Session == nil {
+		return
+	}
+	switch ctx.CloseReason() {
+	case CloseIdle, CloseBackendError, CloseBackendReset:
+	default:
+		return
+	}
+	c := ctx.Session.Counters()
+	if c.PacketsOut > 0 && c.PacketsIn > handshakeFirstFlight {
+		return
+	}
+	h.record(src, h.failure, func(rep *ipReputation) { rep.failures++ })
+}
+
+// score returns the admin view of a source; rep is nil for untracked sources.
+func (h *ReputationHandler) score(src netip.Addr, rep *ipReputation, now time.Time) ReputationScore {
+	base := h.base(src)
+	s := ReputationScore{
+		Source:  privacy.IP(src),
+		Base:    base.points,
+		Network: base.label,
+		Score:   base.points,
+	}
+	if rep != nil {
+		s.Score += h.decayed(rep, now)
+		s.Updated = rep.updated.UTC().Format(time.RFC3339)
+		s.Connects = rep.connects
+		s.Failures = rep.failures
+		s.Refusals = rep.refusals
+		s.Dropped = rep.dropped
+		s.Violations = rep.violations
+		s.Challenged = rep.challenged
+		s.Solved = rep.solved
+	}
+	s.Score = math.Round(s.Score*100) / 100
+	s.Action = h.action(s.Score)
+	return s
+}
+
+// Scores returns the tracked sources, highest score first.
+func (h *ReputationHandler) Scores() []ReputationScore {
+	now := h.now()
+	reputations.Lock()
+	scores := make([]ReputationScore, 0, len(reputations.m))
+	for src, rep := range reputations.m {
+		scores = append(scores, h.score(src, rep, now))
+	}
+	reputations.Unlock()
+	slices.SortFunc(scores, func(a, b ReputationScore) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), strings.Compare(a.Source, b.Source))
+	})
+	return scores
+}
+
+// ServeAdmin reports and resets source scores.
+//
+//	GET    /?limit=N  highest scores (default: 100)
+//	GET    /<ip>      score of the source ip is counted under
+//	DELETE /<ip>      forget the source's earned points
+func (h *ReputationHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		limit := 100
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n <= 0 {
+				writeAdminError(w, http.StatusBadRequest, "invalid limit")
+				return
+			}
+			limit = n
+		}
+		scores := h.Scores()
+		writeAdminJSON(w, http.StatusOK, map[string]any{
+			"deprioritize_score": h.deprioritize,
+			"challenge_score":    h.challenge,
+			"drop_score":         h.drop,
+			"tracked":            len(scores),
+			"sources":            scores[:min(limit, len(scores))],
+		})
+		return
+	}
+
+	src, ok := h.source(net.ParseIP(name))
+	if !ok {
+		writeAdminError(w, http.StatusBadRequest, "invalid IP address")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		reputations.Lock()
+		s := h.score(src, reputations.m[src], h.now())
+		reputations.Unlock()
+		writeAdminJSON(w, http.StatusOK, s)
+	case http.MethodDelete:
+		reputations.Lock()
+		_, ok := reputations.m[src]
+		delete(reputations.m, src)
+		reputations.Unlock()
+		if !ok {
+			writeAdminError(w, http.StatusNotFound, "source not tracked")
+			return
+		}
+		writeAdminJSON(w, http.StatusOK, h.score(src, nil, h.now()))
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
@@ -0,0 +1,280 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestReputation(t *testing.T, config string) *ReputationHandler {
+	t.Helper()
+	h, err := NewReputationHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h.(*ReputationHandler)
+}
+
+func reputationCtx(ip string) *Context {
+	return &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP(ip), Port: 50000}}
+}
+
+func TestNewReputationHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"defaults", `{}`, ""},
+		{"networks", `{"networks": {"203.0.113.0/24": 40, "2001:db8::/32": -100}}`, ""},
+		{"invalid JSON", `{invalid`, "invalid reputation config"},
+		{"bad CIDR", `{"networks": {"10.0.0.0/99": 1}}`, "invalid subnet"},
+		{"negative score", `{"drop_score": -1}`, "must be >= 0"},
+		{"bad prefix", `{"ipv6_prefix": 129}`, "between 1 and 128"},
+		{"negative window", `{"challenge_window": -1}`, "must be >= 0"},
+		{"missing file", `{"networks_file": "/nonexistent/asn.csv"}`, "networks_file"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReputationHandler(json.RawMessage(tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReputationHandler_NetworksFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "asn.csv")
+	data := "network,points,label\n# comment\n198.51.100.0/24,30,AS64500\n198.51.100.128/25, 60, AS64501\n2001:db8::/32,-20\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := newTestReputation(t, `{"networks_file": "`+path+`"}`)
+
+	tests := []struct {
+		ip     string
+		points float64
+		label  string
+	}{
+		{"198.51.100.1", 30, "AS64500"},
+		{"198.51.100.200", 60, "AS64501"},
+		{"2001:db8::1", -20, ""},
+		{"192.0.2.1", 0, ""},
+	}
+	for _, tt := range tests {
+		src, _ := h.source(net.ParseIP(tt.ip))
+		if ns := h.network(src); ns.points != tt.points || ns.label != tt.label {
+			t.Errorf("network(%s) = %+v, want %v %q", tt.ip, ns, tt.points, tt.label)
+		}
+	}
+
+	bad := filepath.Join(t.TempDir(), "bad.csv")
+	os.WriteFile(bad, []byte("198.51.100.0/24,many\n"), 0o644)
+	if _, err := NewReputationHandler(json.RawMessage(`{"networks_file": "` + bad + `"}`)); err == nil || !strings.Contains(err.Error(), "line 1") {
+		t.Errorf("error = %v, want line number", err)
+	}
+}
+
+func TestReputationHandler_ChurnAndDecay(t *testing.T) {
+	h := newTestReputation(t, `{"churn": 10, "deprioritize_score": 30, "drop_score": 50, "half_life": 60}`)
+	now := time.Unix(1_700_000_000, 0)
+	h.now = func() time.Time { return now }
+
+	want := []Action{Continue, Continue, Continue, Continue, Drop}
+	for i, action := range want {
+		ctx := reputationCtx("192.0.2.10")
+		if res := h.OnConnect(ctx); res.Action != action {
+			t.Fatalf("connection %d: action = %v, want %v", i+1, res.Action, action)
+		}
+		if got := Deprioritized(ctx); got != (i >= 2 && action == Continue) {
+			t.Errorf("connection %d: deprioritized = %v", i+1, got)
+		}
+	}
+
+	// Two half-lives later the earned 50 points are down to 12.5
+	now = now.Add(2 * time.Minute)
+	ctx := reputationCtx("192.0.2.10")
+	if res := h.OnConnect(ctx); res.Action != Continue || Deprioritized(ctx) {
+		t.Errorf("after decay: action = %v, deprioritized = %v", res.Action, Deprioritized(ctx))
+	}
+	src, _ := h.source(net.ParseIP("192.0.2.10"))
+	reputations.Lock()
+	s := h.score(src, reputations.m[src], now)
+	reputations.Unlock()
+	if s.Score != 22.5 || s.Connects != 6 || s.Dropped != 1 {
+		t.Errorf("score = %+v", s)
+	}
+}
+
+func TestReputationHandler_Signals(t *testing.T) {
+	h := newTestReputation(t, `{"churn": 0, "networks": {"192.0.2.32/28": 45}}`)
+	router, err := NewDynamicHandler(json.RawMessage(`{"routes": {"b.example.com": "10.0.0.1:5520"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := NewChain(h, router)
+
+	// The router refuses the unknown name: 10 points
+	ctx := reputationCtx("192.0.2.33")
+	ctx.Hello = &ClientHello{SNI: "a.example.com"}
+	if res := chain.OnConnect(ctx); res.Action != Drop {
+		t.Fatalf("action = %v", res.Action)
+	}
+
+	// A session the backend never answered: 5 points
+	ctx = reputationCtx("192.0.2.33")
+	h.OnConnect(ctx)
+	ctx.Session = &Session{}
+	ctx.Session.CountIn(1200)
+	ctx.SetCloseReason(CloseIdle)
+	h.OnDisconnect(ctx)
+
+	// A completed session that went idle does not count
+	ctx = reputationCtx("192.0.2.33")
+	h.OnConnect(ctx)
+	ctx.Session = &Session{}
+	for range 5 {
+		ctx.Session.CountIn(100)
+		ctx.Session.CountOut(100)
+	}
+	ctx.SetCloseReason(CloseIdle)
+	h.OnDisconnect(ctx)
+
+	// 45 base + 15 earned is over deprioritize_score
+	ctx = reputationCtx("192.0.2.33")
+	if res := h.OnConnect(ctx); res.Action != Continue || !Deprioritized(ctx) {
+		t.Errorf("action = %v, deprioritized = %v", res.Action, Deprioritized(ctx))
+	}
+	rec := httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodGet, "/192.0.2.33", nil))
+	var s ReputationScore
+	if err := json.NewDecoder(rec.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+	if s.Base != 45 || s.Refusals != 1 || s.Failures != 1 || s.Action != "deprioritize" {
+		t.Errorf("score = %+v", s)
+	}
+}
+
+func TestReputationHandler_Challenge(t *testing.T) {
+	h := newTestReputation(t, `{"churn": 0, "networks": {"192.0.2.64/28": 60}, "challenge_score": 50, "challenge_window": 5}`)
+	now := time.Unix(1_700_000_000, 0)
+	h.now = func() time.Time { return now }
+
+	initial := func(ip string, dcid byte) *Context {
+		ctx := reputationCtx(ip)
+		ctx.Hello = &ClientHello{SNI: "a.example.com"}
+		ctx.InitialPacket = []byte{0xc0, 0, 0, 0, 1, 4, dcid, dcid, dcid, dcid, 0}
+		return ctx
+	}
+
+	steps := []struct {
+		name  string
+		after time.Duration
+		dcid  byte
+		want  Action
+	}{
+		{"first Initial", 0, 1, Drop},
+		{"immediate retransmit", 100 * time.Millisecond, 1, Drop},
+		{"retransmit after PTO", time.Second, 1, Continue},
+		{"new connection", 0, 2, Drop},
+		{"retransmit too late", 6 * time.Second, 2, Drop},
+		{"retransmit of the new challenge", time.Second, 2, Continue},
+	}
+	for _, st := range steps {
+		now = now.Add(st.after)
+		ctx := initial("192.0.2.65", st.dcid)
+		res := h.OnConnect(ctx)
+		if res.Action != st.want {
+			t.Fatalf("%s: action = %v, want %v", st.name, res.Action, st.want)
+		}
+		if res.Action == Drop && (res.Policy == nil || res.Policy.Action != DropSilent) {
+			t.Errorf("%s: policy = %+v, want silent", st.name, res.Policy)
+		}
+		if res.Action == Continue && !Deprioritized(ctx) {
+			t.Errorf("%s: solved connection not deprioritized", st.name)
+		}
+	}
+
+	// Sources under challenge_score and non-QUIC flows are never challenged
+	if res := h.OnConnect(initial("192.0.2.1", 3)); res.Action != Continue {
+		t.Errorf("clean source: action = %v", res.Action)
+	}
+	if res := h.OnConnect(reputationCtx("192.0.2.66")); res.Action != Continue {
+		t.Errorf("non-QUIC flow: action = %v", res.Action)
+	}
+
+	src, _ := h.source(net.ParseIP("192.0.2.65"))
+	reputations.Lock()
+	s := h.score(src, reputations.m[src], now)
+	reputations.Unlock()
+	if s.Challenged != 4 || s.Solved != 2 || s.Action != "challenge" {
+		t.Errorf("score = %+v", s)
+	}
+}
+
+func TestReputationHandler_IPv6Prefix(t *testing.T) {
+	h := newTestReputation(t, `{"churn": 60}`)
+	h.OnConnect(reputationCtx("2001:db8:1:2::1"))
+	if res := h.OnConnect(reputationCtx("2001:db8:1:2::ffff")); res.Action != Drop {
+		t.Errorf("same /64: action = %v, want drop", res.Action)
+	}
+	if res := h.OnConnect(reputationCtx("2001:db8:1:3::1")); res.Action != Continue {
+		t.Errorf("other /64: action = %v, want continue", res.Action)
+	}
+}
+
+func TestReputationHandler_ServeAdmin(t *testing.T) {
+	h := newTestReputation(t, `{"churn": 20}`)
+	for range 3 {
+		h.OnConnect(reputationCtx("192.0.2.50"))
+	}
+	h.OnConnect(reputationCtx("192.0.2.51"))
+
+	rec := httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodGet, "/?limit=1", nil))
+	var list struct {
+		Sources []ReputationScore `json:"sources"`
+	}
+	if err := json.NewDecoder(rec.Body).Decode(&list); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || len(list.Sources) != 1 || list.Sources[0].Score < 60 {
+		t.Errorf("status %d, sources %+v", rec.Code, list.Sources)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodDelete, "/192.0.2.50", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("reset status = %d", rec.Code)
+	}
+	if res := h.OnConnect(reputationCtx("192.0.2.50")); res.Action != Continue {
+		t.Errorf("after reset: action = %v", res.Action)
+	}
+
+	for path, want := range map[string]int{"/not-an-ip": http.StatusBadRequest, "/?limit=0": http.StatusBadRequest} {
+		rec = httptest.NewRecorder()
+		h.ServeAdmin(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		if rec.Code != want {
+			t.Errorf("GET %s status = %d, want %d", path, rec.Code, want)
+		}
+	}
+	rec = httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodDelete, "/192.0.2.99", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("reset untracked status = %d", rec.Code)
+	}
+}
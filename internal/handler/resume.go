@@ -0,0 +1,285 @@
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var resumeLog = logging.ForHandler("resume")
+
+func init() {
+	Register("resume", NewResumeHandler)
+}
+
+// ResumeKey is the context key holding a backend remembered from an earlier
+// connection of the same client. Routers use it instead of routing again when
+// the backend is still valid; handlers doing expensive per-connection work
+// (external lookups) may skip it for resumed connections.
+const ResumeKey = "resume_backend"
+
+// ResumeConfig is the configuration for the resume handler.
+type ResumeConfig struct {
+	TTL        int    `json:"ttl,omitempty"`         // Seconds a decision is remembered (default: 300)
+	Key        string `json:"key,omitempty"`         // Hex HMAC key for tokens (default: random per process)
+	MaxEntries int    `json:"max_entries,omitempty"` // Remembered clients (default: 100000)
+}
+
+// resumeTokens is package-level so remembered decisions survive handler chain
+// reloads. Tokens signed with a previous key fail verification after a key change.
+var resumeTokens = struct {
+	sync.Mutex
+	m map[string]string // client IP + "|" + SNI or protocol -> token
+}{m: make(map[string]string)}
+
+var (
+	resumeDefaultKey     []byte
+	resumeDefaultKeyOnce sync.Once
+)
+
+// ResumeHandler remembers (client IP, SNI) -> backend decisions as signed tokens,
+// so a reconnecting client lands on the same backend without routing again.
+type ResumeHandler struct {
+	ttl        time.Duration
+	key        []byte
+	maxEntries int
+	now        func() time.Time
+}
+
+// NewResumeHandler creates a new resume handler.
+func NewResumeHandler(raw json.RawMessage) (Handler, error) {
+	var cfg ResumeConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid resume config: %w", err)
+		}
+	}
+	if cfg.TTL <= 0 {
+		cfg.TTL = 300
+	}
+	if cfg.MaxEntries <= 0 {
+		cfg.MaxEntries = 100000
+	}
+
+	h := &ResumeHandler{
+		ttl:        time.Duration(cfg.TTL) * time.Second,
+		maxEntries: cfg.MaxEntries,
+		now:        time.Now,
+	}
+	if cfg.Key != "" {
+		key, err := hex.DecodeString(cfg.Key)
+		if err != nil || len(key) < 16 {
+			return nil, errors.New("resume: key must be at least 16 hex-encoded bytes")
+		}
+		h.key = key
+	} else {
+		resumeDefaultKeyOnce.Do(func() {
+			resumeDefaultKey = make([]byte, 32)
+			rand.Read(resumeDefaultKey)
+		})
+		h.key = resumeDefaultKey
+	}
+	return h, nil
+}
+
+// Name returns the handler name.
+func (h *ResumeHandler) Name() string {
+	return "resume"
+}
+
+// resumeID identifies a client and the service it connects to.
+func resumeID(ctx *Context) (client, service string, ok bool) {
+	addr := ctx.OriginalClientAddr()
+	if addr == nil {
+		return "", "", false
+	}
+	switch {
+	case ctx.Hello != nil && ctx.Hello.SNI != "":
+		service = ctx.Hello.SNI
+	case ctx.Protocol != "":
+		service = "protocol:" + ctx.Protocol
+	default:
+		return "", "", false
+	}
+	return addr.IP.String(), service, true
+}
+
+// OnConnect offers a remembered backend to the routers and records the
+// decision once the backend answers.
+func (h *ResumeHandler) OnConnect(ctx *Context) Result {
+	client, service, ok := resumeID(ctx)
+	if !ok {
+		return Result{Action: Continue}
+	}
+	id := client + "|" + service
+
+	resumeTokens.Lock()
+	token := resumeTokens.m[id]
+	resumeTokens.Unlock()
+	if token != "" {
+		if backend, err := h.verify(token, client, service); err == nil {
+			ctx.Set(ResumeKey, backend)
+			sessionLog(ctx, resumeLog).Debugf("%s %s resumes on %s", privacy.Addr(ctx.OriginalClientAddr()), service, backend)
+		} else {
+			sessionLog(ctx, resumeLog).Debugf("%s %s: %v", privacy.Addr(ctx.OriginalClientAddr()), service, err)
+		}
+	}
+
+	// Remember the final backend once it has answered
+	var recorded atomic.Bool
+	next := ctx.OnServerPacket
+	ctx.OnServerPacket = func(packet []byte) {
+		if !recorded.Swap(true) {
+			h.remember(id, client, service, ctx.GetString("backend"))
+		}
+		if next != nil {
+			next(packet)
+		}
+	}
+	return Result{Action: Continue}
+}
+
+// OnPacket passes through.
+func (h *ResumeHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect renews the token so the TTL counts from the end of the session.
+func (h *ResumeHandler) OnDisconnect(ctx *Context) {
+	client, service, ok := resumeID(ctx)
+	if !ok || !ctx.CloseReason().Graceful() {
+		return
+	}
+	id := client + "|" + service
+	resumeTokens.Lock()
+	_, known := resumeTokens.m[id]
+	resumeTokens.Unlock()
+	if known {
+		h.remember(id, client, service, ctx.GetString("backend"))
+	}
+}
+
+// remember stores a signed token for a decision.
+func (h *ResumeHandler) remember(id, client, service, backend string) {
+	if backend == "" {
+		return
+	}
+	token := h.sign(client, service, backend, h.now().Add(h.ttl))
+
+	resumeTokens.Lock()
+	defer resumeTokens.Unlock()
+	if _, ok := resumeTokens.m[id]; !ok && len(resumeTokens.m) >= h.maxEntries {
+		h.sweepLocked()
+		if len(resumeTokens.m) >= h.maxEntries {
+			return
+		}
+	}
+	resumeTokens.m[id] = token
+}
+
+// sweepLocked removes expired and invalid tokens. resumeTokens must be locked.
+func (h *ResumeHandler) sweepLocked() {
+	for id, token := range resumeTokens.m {
+		client, service, _ := strings.Cut(id, "|")
+		if _, err := h.verify(token, client, service); err != nil {
+			delete(resumeTokens.m, id)
+		}
+	}
+}
+
+// sign builds a token: base64url("expiry|client|service|backend") "." base64url(mac).
+func (h *ResumeHandler) sign(client, service, backend string, expiry time.Time) string {
+	payload := strconv.FormatInt(expiry.Unix(), 10) + "|" + client + "|" + service + "|" + backend
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString([]byte(payload)) + "." +
+		base64.RawURLEncoding.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// verify checks a token's signature, expiry and binding, and returns its backend.
+func (h *ResumeHandler) verify(token, client, service string) (string, error) {
+	encPayload, encMAC, ok := strings.Cut(token, ".")
+	if !ok {
+		return "", errors.New("malformed resume token")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encPayload)
+	if err != nil {
+		return "", errors.New("malformed resume token")
+	}
+	got, err := base64.RawURLEncoding.DecodeString(encMAC)
+	if err != nil {
+		return "", errors.New("malformed resume token")
+	}
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write(payload)
+	if !hmac.Equal(got, mac.Sum(nil)[:16]) {
+		return "", errors.New("invalid resume token signature")
+	}
+
+	parts := strings.SplitN(string(payload), "|", 4)
+	if len(parts) != 4 {
+		return "", errors.New("malformed resume token")
+	}
+	expiry, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return "", errors.New("malformed resume token")
+	}
+	if h.now().Unix() > expiry {
+		return "", errors.New("resume token expired")
+	}
+	if parts[1] != client || parts[2] != service {
+		return "", errors.New("resume token for another client")
+	}
+	return parts[3], nil
+}
+
+// ServeAdmin reports and clears remembered decisions.
+//
+//	GET    /   -> {"entries": n}
+//	DELETE /   -> forget all decisions
+func (h *ResumeHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		resumeTokens.Lock()
+		h.sweepLocked()
+		n := len(resumeTokens.m)
+		resumeTokens.Unlock()
+		writeAdminJSON(w, http.StatusOK, map[string]int{"entries": n})
+	case http.MethodDelete:
+		resumeTokens.Lock()
+		clear(resumeTokens.m)
+		resumeTokens.Unlock()
+		resumeLog.Printf("remembered decisions cleared via admin API")
+		writeAdminJSON(w, http.StatusOK, map[string]int{"entries": 0})
+	default:
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+	}
+}
+
+// ResumedBackend returns the backend remembered for this client by the resume
+// handler, or "" when the connection is not a resumption or the backend is draining.
+func (c *Context) ResumedBackend() string {
+	backend := c.GetString(ResumeKey)
+	if Draining(backend) {
+		return ""
+	}
+	return backend
+}
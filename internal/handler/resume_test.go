@@ -0,0 +1,167 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func newTestResume(t *testing.T, config string) *ResumeHandler {
+	t.Helper()
+	resumeTokens.Lock()
+	clear(resumeTokens.m)
+	resumeTokens.Unlock()
+	h, err := NewResumeHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	return h.(*ResumeHandler)
+}
+
+func TestResumeToken(t *testing.T) {
+	h := newTestResume(t, `{"key": "000102030405060708090a0b0c0d0e0f"}`)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	h.now = func() time.Time { return now }
+
+	token := h.sign("192.0.2.1", "play.com", "b1:5520", now.Add(time.Minute))
+	if backend, err := h.verify(token, "192.0.2.1", "play.com"); err != nil || backend != "b1:5520" {
+		t.Fatalf("verify = %q, %v", backend, err)
+	}
+
+	other, _ := NewResumeHandler(json.RawMessage(`{"key": "0f0e0d0c0b0a09080706050403020100"}`))
+	tests := []struct {
+		name    string
+		h       *ResumeHandler
+		token   string
+		client  string
+		service string
+	}{
+		{"other client", h, token, "192.0.2.2", "play.com"},
+		{"other sni", h, token, "192.0.2.1", "other.com"},
+		{"tampered", h, token[:len(token)-2] + "AA", "192.0.2.1", "play.com"},
+		{"malformed", h, "nope", "192.0.2.1", "play.com"},
+		{"key changed", other.(*ResumeHandler), token, "192.0.2.1", "play.com"},
+	}
+	for _, tt := range tests {
+		if _, err := tt.h.verify(tt.token, tt.client, tt.service); err == nil {
+			t.Errorf("%s: verify succeeded", tt.name)
+		}
+	}
+
+	now = now.Add(2 * time.Minute)
+	if _, err := h.verify(token, "192.0.2.1", "play.com"); err == nil {
+		t.Error("expired token verified")
+	}
+}
+
+func TestResumeHandler_SNIRouter(t *testing.T) {
+	resume := newTestResume(t, `{"ttl": 60}`)
+	router, err := NewDynamicHandler(json.RawMessage(`{"routes": {"play.com": ["b1:5520", "b2:5520"]}}`))
+	if err != nil {
+		t.Fatalf("failed to create router: %v", err)
+	}
+
+	connect := func() *Context {
+		t.Helper()
+		ctx := &Context{
+			ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000},
+			Hello:      &ClientHello{SNI: "play.com"},
+		}
+		resume.OnConnect(ctx)
+		if res := router.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("OnConnect: %v", res.Error)
+		}
+		return ctx
+	}
+
+	ctx := connect()
+	if ctx.ResumedBackend() != "" {
+		t.Fatal("first connection resumed")
+	}
+	first := ctx.GetString("backend")
+	ctx.OnServerPacket([]byte{0xc0})
+
+	// Round-robin would move the client; the remembered decision keeps it
+	for i := 0; i < 3; i++ {
+		ctx := connect()
+		if got := ctx.ResumedBackend(); got != first {
+			t.Errorf("resumed backend = %q, want %q", got, first)
+		}
+		if got := ctx.GetString("backend"); got != first {
+			t.Errorf("backend = %q, want %q", got, first)
+		}
+	}
+
+	// A backend removed from the route is not honoured
+	router, _ = NewDynamicHandler(json.RawMessage(`{"routes": {"play.com": ["b3:5520"]}}`))
+	if got := connect().GetString("backend"); got != "b3:5520" {
+		t.Errorf("backend = %q, want b3:5520", got)
+	}
+}
+
+func TestResumeHandler_NoAnswerNotRemembered(t *testing.T) {
+	resume := newTestResume(t, ``)
+	ctx := &Context{
+		ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000},
+		Hello:      &ClientHello{SNI: "play.com"},
+	}
+	resume.OnConnect(ctx)
+	ctx.Set("backend", "b1:5520")
+	ctx.SetCloseReason(CloseIdle)
+	resume.OnDisconnect(ctx)
+
+	resumeTokens.Lock()
+	n := len(resumeTokens.m)
+	resumeTokens.Unlock()
+	if n != 0 {
+		t.Errorf("entries = %d, want 0", n)
+	}
+}
+
+func TestResumeHandler_SimpleRouter(t *testing.T) {
+	h, err := NewStaticHandler(json.RawMessage(`{"backends": ["b1:5520", "b2:5520"]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		ctx := &Context{}
+		ctx.Set(ResumeKey, "b2:5520")
+		h.OnConnect(ctx)
+		if got := ctx.GetString("backend"); got != "b2:5520" {
+			t.Errorf("backend = %q, want b2:5520", got)
+		}
+	}
+	ctx := &Context{}
+	ctx.Set(ResumeKey, "gone:5520")
+	h.OnConnect(ctx)
+	if got := ctx.GetString("backend"); got == "gone:5520" {
+		t.Error("unknown resumed backend was used")
+	}
+}
+
+func TestResumeHandler_Admin(t *testing.T) {
+	h := newTestResume(t, ``)
+	h.remember("192.0.2.1|play.com", "192.0.2.1", "play.com", "b1:5520")
+
+	rec := httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	var status map[string]int
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil || status["entries"] != 1 {
+		t.Fatalf("GET = %s, %v", rec.Body, err)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodDelete, "/", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("DELETE status = %d", rec.Code)
+	}
+	resumeTokens.Lock()
+	n := len(resumeTokens.m)
+	resumeTokens.Unlock()
+	if n != 0 {
+		t.Errorf("entries = %d after DELETE, want 0", n)
+	}
+}
@@ -0,0 +1,241 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"math/rand/v2"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/debug"
+	"quic-relay/internal/logging"
+)
+
+// RouteLogConfig overrides logging for the connections of one route.
+type RouteLogConfig struct {
+	Level  string  `json:"level,omitempty"`  // Level for session logs of the route (default: configured levels)
+	Sample float64 `json:"sample,omitempty"` // Share of packets logged like -d packet debugging, 0 to 1
+	TTL    int     `json:"ttl,omitempty"`    // Seconds until the override expires (0 = until removed)
+}
+
+// RouteLogStatus is an active route logging override.
+type RouteLogStatus struct {
+	Route   string  `json:"route"`
+	Level   string  `json:"level,omitempty"`
+	Sample  float64 `json:"sample,omitempty"`
+	Expires string  `json:"expires,omitempty"`
+}
+
+// routeLog is a compiled override. Routes are SNIs, "*.example.com" patterns,
+// protocol rule names or "tenant:<name>".
+type routeLog struct {
+	route   string
+	level   logging.Level
+	leveled bool
+	sample  float64
+	expires time.Time // Zero when it does not expire
+}
+
+func (o *routeLog) status() RouteLogStatus {
+	st := RouteLogStatus{Route: o.route, Sample: o.sample}
+	if o.leveled {
+		st.Level = o.level.String()
+	}
+	if !o.expires.IsZero() {
+		st.Expires = o.expires.UTC().Format(time.RFC3339)
+	}
+	return st
+}
+
+// routeLogs is package-level so overrides survive handler chain reloads.
+// Copy-on-write: the packet path only loads the map.
+var (
+	routeLogs   atomic.Pointer[map[string]*routeLog]
+	routeLogsMu sync.Mutex
+)
+
+// packetDebugLog writes sampled packet debug lines under the debug component.
+var packetDebugLog = logging.For("debug").WithLevel(logging.LevelDebug)
+
+// SetRouteLogging sets or replaces the logging override of route.
+func SetRouteLogging(route string, cfg RouteLogConfig) (RouteLogStatus, error) {
+	route = strings.ToLower(strings.TrimSpace(route))
+	if route == "" {
+		return RouteLogStatus{}, errors.New("route is required")
+	}
+	o := &routeLog{route: route, sample: cfg.Sample}
+	if cfg.Level != "" {
+		level, err := logging.ParseLevel(cfg.Level)
+		if err != nil {
+			return RouteLogStatus{}, err
+		}
+		o.level, o.leveled = level, true
+	}
+	if cfg.Sample < 0 || cfg.Sample > 1 {
+		return RouteLogStatus{}, fmt.Errorf("sample must be between 0 and 1")
+	}
+	if !o.leveled && o.sample == 0 {
+		return RouteLogStatus{}, errors.New("'level' or 'sample' is required")
+	}
+	if cfg.TTL < 0 {
+		return RouteLogStatus{}, errors.New("ttl must not be negative")
+	}
+	if cfg.TTL > 0 {
+		o.expires = time.Now().Add(time.Duration(cfg.TTL) * time.Second)
+	}
+
+	updateRouteLogs(func(m map[string]*routeLog) { m[route] = o })
+	return o.status(), nil
+}
+
+// ClearRouteLogging removes the override of route. Returns false if it had none.
+func ClearRouteLogging(route string) bool {
+	route = strings.ToLower(strings.TrimSpace(route))
+	found := false
+	updateRouteLogs(func(m map[string]*routeLog) {
+		_, found = m[route]
+		delete(m, route)
+	})
+	return found
+}
+
+// RouteLogging returns the active overrides, sorted by route.
+func RouteLogging() []RouteLogStatus {
+	now := time.Now()
+	list := []RouteLogStatus{}
+	if m := routeLogs.Load(); m != nil {
+		for _, route := range slices.Sorted(maps.Keys(*m)) {
+			if o := (*m)[route]; o.expires.IsZero() || now.Before(o.expires) {
+				list = append(list, o.status())
+			}
+		}
+	}
+	return list
+}
+
+// updateRouteLogs applies fn to a copy of the overrides, dropping expired ones.
+func updateRouteLogs(fn func(map[string]*routeLog)) {
+	routeLogsMu.Lock()
+	defer routeLogsMu.Unlock()
+	now := time.Now()
+	m := make(map[string]*routeLog)
+	if old := routeLogs.Load(); old != nil {
+		for route, o := range *old {
+			if o.expires.IsZero() || now.Before(o.expires) {
+				m[route] = o
+			}
+		}
+	}
+	fn(m)
+	routeLogs.Store(&m)
+}
+
+// routeLogFor returns the override for ctx's route, or nil. An exact SNI wins
+// over the longest "*." pattern, then the protocol rule, then the tenant.
+func routeLogFor(ctx *Context) *routeLog {
+	p := routeLogs.Load()
+	if p == nil || len(*p) == 0 {
+		return nil
+	}
+	m := *p
+	// Keys are built in buf: looking up m[string(key)] does not allocate
+	var buf [2 + 255]byte
+	o := func() *routeLog {
+		sni := ""
+		if ctx.Hello != nil {
+			sni = ctx.Hello.SNI
+		} else if ctx.Hop != nil {
+			sni = ctx.Hop.SNI
+		}
+		if sni != "" {
+			// key is "*." and the SNI; the "*." of each parent's pattern
+			// overwrites the end of the label before it
+			key := appendLower(append(buf[:0], "*."...), sni)
+			if o, ok := m[string(key[2:])]; ok {
+				return o
+			}
+			for rest := key[2:]; ; {
+				dot := bytes.IndexByte(rest, '.')
+				if dot < 0 {
+					break
+				}
+				parent := rest[dot+1:]
+				pattern := key[len(key)-len(parent)-2:]
+				pattern[0], pattern[1] = '*', '.'
+				if o, ok := m[string(pattern)]; ok {
+					return o
+				}
+				rest = parent
+			}
+		}
+		if ctx.Protocol != "" {
+			if o, ok := m[string(appendLower(buf[:0], ctx.Protocol))]; ok {
+				return o
+			}
+		}
+		if tenant := ctx.GetString(TenantKey); tenant != "" {
+			return m[string(appendLower(append(buf[:0], "tenant:"...), tenant))]
+		}
+		return nil
+	}()
+	if o == nil || !o.expires.IsZero() && time.Now().After(o.expires) {
+		return nil
+	}
+	return o
+}
+
+// appendLower appends s to b with ASCII letters in lower case. SNIs are ASCII
+// (RFC 6066); unlike strings.ToLower this does not allocate.
+func appendLower(b []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		b = append(b, c)
+	}
+	return b
+}
+
+// sessionLog returns l, or l at the level of ctx's route override.
+func sessionLog(ctx *Context, l *logging.Logger) *logging.Logger {
+	if o := routeLogFor(ctx); o != nil && o.leveled {
+		return l.WithLevel(o.level)
+	}
+	return l
+}
+
+// packetDebugging reports whether a debug line is written for the current
+// packet of ctx: when -d is on, or for a sampled packet of a route with a
+// sample override. The packet path checks it before calling packetDebugf, so
+// arguments are not boxed for lines nobody writes.
+func packetDebugging(ctx *Context) bool {
+	if debug.IsEnabled() {
+		return true
+	}
+	o := routeLogFor(ctx)
+	return o != nil && o.sample != 0 && (o.sample >= 1 || rand.Float64() < o.sample)
+}
+
+// packetDebugf writes a packet debug line for a packet packetDebugging
+// chose.
+func packetDebugf(ctx *Context, format string, v ...any) {
+	if debug.IsEnabled() {
+		debug.Printf(format, v...)
+		return
+	}
+	o := routeLogFor(ctx)
+	if o == nil {
+		return
+	}
+	session := uint64(0)
+	if ctx.Session != nil {
+		session = ctx.Session.ID
+	}
+	packetDebugLog.Debugf("session=%d route=%s"+format, append([]any{session, o.route}, v...)...)
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"testing"
+	"time"
+
+	"quic-relay/internal/logging"
+)
+
+func TestSetRouteLogging_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		route string
+		cfg   RouteLogConfig
+	}{
+		{"no route", " ", RouteLogConfig{Level: "debug"}},
+		{"nothing set", "a.example.com", RouteLogConfig{}},
+		{"bad level", "a.example.com", RouteLogConfig{Level: "loud"}},
+		{"bad sample", "a.example.com", RouteLogConfig{Sample: 1.5}},
+		{"negative ttl", "a.example.com", RouteLogConfig{Level: "debug", TTL: -1}},
+	}
+	for _, tt := range tests {
+		if _, err := SetRouteLogging(tt.route, tt.cfg); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+	if len(RouteLogging()) != 0 {
+		t.Errorf("invalid overrides were stored: %v", RouteLogging())
+	}
+}
+
+func TestRouteLogFor(t *testing.T) {
+	for _, route := range []string{"Play.Example.com", "*.example.com", "*.eu.example.com", "rtp", "tenant:acme"} {
+		if _, err := SetRouteLogging(route, RouteLogConfig{Level: "debug"}); err != nil {
+			t.Fatal(err)
+		}
+		defer ClearRouteLogging(route)
+	}
+
+	tenantCtx := &Context{Hello: &ClientHello{SNI: "other.test"}}
+	tenantCtx.Set(TenantKey, "acme")
+	tests := []struct {
+		name string
+		ctx  *Context
+		want string
+	}{
+		{"exact", &Context{Hello: &ClientHello{SNI: "play.example.COM"}}, "play.example.com"},
+		{"longest pattern", &Context{Hello: &ClientHello{SNI: "lobby.eu.example.com"}}, "*.eu.example.com"},
+		{"pattern", &Context{Hello: &ClientHello{SNI: "lobby.example.com"}}, "*.example.com"},
+		{"pattern, mixed case", &Context{Hello: &ClientHello{SNI: "Lobby.EU.Example.com"}}, "*.eu.example.com"},
+		{"relayed", &Context{Hop: &HopInfo{SNI: "lobby.example.com"}}, "*.example.com"},
+		{"protocol", &Context{Protocol: "rtp"}, "rtp"},
+		{"tenant", tenantCtx, "tenant:acme"},
+		{"none", &Context{Hello: &ClientHello{SNI: "example.com"}}, ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if o := routeLogFor(tt.ctx); o != nil {
+			got = o.route
+		}
+		if got != tt.want {
+			t.Errorf("%s: route = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	if l := sessionLog(tests[0].ctx, forwarderLog); !l.Enabled(logging.LevelDebug) {
+		t.Error("session logger of overridden route does not log debug messages")
+	}
+	if l := sessionLog(tests[len(tests)-1].ctx, forwarderLog); l != forwarderLog {
+		t.Error("session logger without override is not the component logger")
+	}
+}
+
+func TestRouteLogging_Expires(t *testing.T) {
+	if _, err := SetRouteLogging("expiring.example.com", RouteLogConfig{Sample: 1, TTL: 60}); err != nil {
+		t.Fatal(err)
+	}
+	defer ClearRouteLogging("expiring.example.com")
+	ctx := &Context{Hello: &ClientHello{SNI: "expiring.example.com"}}
+	if routeLogFor(ctx) == nil {
+		t.Fatal("override not found")
+	}
+
+	o := (*routeLogs.Load())["expiring.example.com"]
+	o.expires = time.Now().Add(-time.Second)
+	if routeLogFor(ctx) != nil || len(RouteLogging()) != 0 {
+		t.Error("expired override still applies")
+	}
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"quic-relay/internal/metrics"
+)
+
+// TagsKey is the context key holding the map[string]string of tags of the
+// route a router picked. Sessions of untagged routes have none.
+const TagsKey = "tags"
+
+const maxRouteTags = 16
+
+// tagNamePattern matches the tag names allowed, which are used as metric
+// label names as they are.
+var tagNamePattern = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// validateTags checks the tags of a route.
+func validateTags(tags map[string]string) error {
+	if len(tags) > maxRouteTags {
+		return fmt.Errorf("at most %d tags are allowed", maxRouteTags)
+	}
+	for name := range tags {
+		switch {
+		case !tagNamePattern.MatchString(name):
+			return fmt.Errorf("tag %q: names must be letters, digits and underscores, not starting with a digit", name)
+		case strings.HasPrefix(name, "__"):
+			return fmt.Errorf("tag %q: names starting with __ are reserved", name)
+		case name == "direction":
+			return errors.New(`tag "direction" is reserved for metrics`)
+		}
+	}
+	return nil
+}
+
+// RouteTags returns the tags of ctx's route, or nil.
+func RouteTags(ctx *Context) map[string]string {
+	tags, _ := GetValue[map[string]string](ctx, TagsKey)
+	return tags
+}
+
+// formatTags formats tags for log lines as " tag.name=value ...", sorted by
+// name. It is empty without tags.
+func formatTags(tags map[string]string) string {
+	var b strings.Builder
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		b.WriteString(" tag.")
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(tags[name])
+	}
+	return b.String()
+}
+
+// tagCounters are the traffic counters of one set of route tags.
+type tagCounters struct {
+	labels   []string // Tags as sorted label pairs
+	sessions atomic.Uint64
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+}
+
+// tagStats is package-level so counters survive handler chain reloads.
+// Routes with the same tags share counters.
+var tagStats = struct {
+	sync.Mutex
+	m map[string]*tagCounters
+}{m: make(map[string]*tagCounters)}
+
+// tagCountersFor returns the counters of tags, creating them on first use.
+func tagCountersFor(tags map[string]string) *tagCounters {
+	var labels []string
+	for _, name := range slices.Sorted(maps.Keys(tags)) {
+		labels = append(labels, name, tags[name])
+	}
+	key := strings.Join(labels, "\x00")
+
+	tagStats.Lock()
+	defer tagStats.Unlock()
+	c := tagStats.m[key]
+	if c == nil {
+		c = &tagCounters{labels: labels}
+		tagStats.m[key] = c
+	}
+	return c
+}
+
+// countTaggedOpen counts a new session of a tagged route.
+func countTaggedOpen(ctx *Context) {
+	if tags := RouteTags(ctx); len(tags) > 0 {
+		tagCountersFor(tags).sessions.Add(1)
+	}
+}
+
+// countTaggedClose adds the traffic of a closed session of a tagged route.
+func countTaggedClose(ctx *Context) {
+	tags := RouteTags(ctx)
+	if len(tags) == 0 || ctx.Session == nil {
+		return
+	}
+	c := tagCountersFor(tags)
+	counters := ctx.Session.Counters()
+	c.bytesIn.Add(counters.BytesIn)
+	c.bytesOut.Add(counters.BytesOut)
+}
+
+func init() {
+	metrics.Register(writeTagStats)
+}
+
+// writeTagStats writes the counters of tagged routes, labelled with their tags.
+func writeTagStats(w io.Writer) {
+	tagStats.Lock()
+	all := make([]*tagCounters, 0, len(tagStats.m))
+	for _, key := range slices.Sorted(maps.Keys(tagStats.m)) {
+		all = append(all, tagStats.m[key])
+	}
+	tagStats.Unlock()
+	if len(all) == 0 {
+		return
+	}
+
+	const sessions = "quic_relay_route_sessions_total"
+	metrics.WriteHeader(w, sessions, "counter", "Sessions opened on tagged routes.")
+	for _, c := range all {
+		metrics.WriteSample(w, sessions, c.sessions.Load(), c.labels...)
+	}
+	const bytes = "quic_relay_route_bytes_total"
+	metrics.WriteHeader(w, bytes, "counter", "Bytes forwarded by closed sessions of tagged routes.")
+	for _, c := range all {
+		metrics.WriteSample(w, bytes, c.bytesIn.Load(), append(slices.Clip(c.labels), "direction", "in")...)
+		metrics.WriteSample(w, bytes, c.bytesOut.Load(), append(slices.Clip(c.labels), "direction", "out")...)
+	}
+}
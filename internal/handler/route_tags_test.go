@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"maps"
+	"strings"
+	"testing"
+)
+
+func TestRouteTags_SetOnConnect(t *testing.T) {
+	h, err := NewDynamicHandler(json.RawMessage(`{"routes": {
+		"play.example.com": {"backends": ["10.0.0.1:5520"], "tags": {"team": "platform", "env": "prod"}},
+		"other.example.com": "10.0.0.2:5520"
+	}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := &Context{Hello: &ClientHello{SNI: "play.example.com"}}
+	if r := h.OnConnect(ctx); r.Action != Continue {
+		t.Fatalf("OnConnect = %v", r.Action)
+	}
+	want := map[string]string{"team": "platform", "env": "prod"}
+	if got := RouteTags(ctx); !maps.Equal(got, want) {
+		t.Errorf("tags = %v, want %v", got, want)
+	}
+
+	ctx = &Context{Hello: &ClientHello{SNI: "other.example.com"}}
+	h.OnConnect(ctx)
+	if got := RouteTags(ctx); got != nil {
+		t.Errorf("tags of untagged route = %v, want nil", got)
+	}
+}
+
+func TestRouteTags_Invalid(t *testing.T) {
+	tests := []struct {
+		tags    string
+		wantErr string
+	}{
+		{`{"1st": "x"}`, "letters, digits and underscores"},
+		{`{"team-name": "x"}`, "letters, digits and underscores"},
+		{`{"__name__": "x"}`, "reserved"},
+		{`{"direction": "x"}`, "reserved for metrics"},
+		{`{"a":"","b":"","c":"","d":"","e":"","f":"","g":"","h":"","i":"","j":"","k":"","l":"","m":"","n":"","o":"","p":"","q":""}`, "at most 16 tags"},
+	}
+	for _, tt := range tests {
+		_, err := NewDynamicHandler(json.RawMessage(`{"routes": {"a.com": {"backends": ["b:1"], "tags": ` + tt.tags + `}}}`))
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("tags %s: error = %v, want %q", tt.tags, err, tt.wantErr)
+		}
+	}
+}
+
+func TestFormatTags(t *testing.T) {
+	if got := formatTags(nil); got != "" {
+		t.Errorf("formatTags(nil) = %q, want empty", got)
+	}
+	got := formatTags(map[string]string{"team": "platform", "env": "prod"})
+	if want := " tag.env=prod tag.team=platform"; got != want {
+		t.Errorf("formatTags = %q, want %q", got, want)
+	}
+}
+
+func TestRouteTags_Metrics(t *testing.T) {
+	tags := map[string]string{"team": "metrics_test", "env": "prod"}
+	ctx := &Context{Session: &Session{}}
+	ctx.Set(TagsKey, tags)
+	countTaggedOpen(ctx)
+	countTaggedOpen(ctx)
+	ctx.Session.CountIn(100)
+	ctx.Session.CountOut(40)
+	countTaggedClose(ctx)
+
+	var buf bytes.Buffer
+	writeTagStats(&buf)
+	for _, want := range []string{
+		"# TYPE quic_relay_route_sessions_total counter\n",
+		`quic_relay_route_sessions_total{env="prod",team="metrics_test"} 2` + "\n",
+		`quic_relay_route_bytes_total{env="prod",team="metrics_test",direction="in"} 100` + "\n",
+		`quic_relay_route_bytes_total{env="prod",team="metrics_test",direction="out"} 40` + "\n",
+	} {
+		if !strings.Contains(buf.String(), want) {
+			t.Errorf("metrics missing %q in\n%s", want, buf.String())
+		}
+	}
+}
@@ -0,0 +1,369 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var rtpLog = logging.ForHandler("rtp")
+
+func init() {
+	Register("rtp", NewRTPHandler)
+}
+
+// STUN message types relevant for ICE consent (RFC 7675).
+const (
+	stunBindingRequest = 0x0001
+	stunBindingSuccess = 0x0101
+	stunMagicCookie    = 0x2112A442
+)
+
+const (
+	defaultRTPMaxStreams = 32
+	rtpStreamIdle        = time.Minute // Streams silent this long are forgotten
+)
+
+// RTPConfig is the configuration for the rtp handler.
+type RTPConfig struct {
+	Protocol       string `json:"protocol,omitempty"`        // Protocol rule name (default: "rtp")
+	ConsentTimeout int    `json:"consent_timeout,omitempty"` // Seconds without ICE consent before dropping (default: 30, -1 = off)
+	ClockRate      int    `json:"clock_rate,omitempty"`      // RTP clock rate for jitter (default: 90000)
+	MaxStreams     int    `json:"max_streams,omitempty"`     // Streams tracked per flow (default: 32)
+}
+
+// RTPHandler observes RTP/WebRTC media flows. Packets are demultiplexed as in
+// RFC 7983 (STUN, DTLS, RTP/RTCP); RTP streams are tracked per SSRC with
+// RFC 3550 loss and jitter estimates. Flows that use ICE are dropped when
+// consent is not refreshed in time.
+type RTPHandler struct {
+	protocol       string
+	consentTimeout time.Duration
+	clockRate      float64
+	maxStreams     int
+
+	mu    sync.Mutex
+	flows map[*Context]*rtpFlow
+}
+
+// rtpFlow is the state of one media flow (client address).
+type rtpFlow struct {
+	mu          sync.Mutex
+	client      string
+	iceSeen     bool
+	lastConsent time.Time
+	streams     map[rtpStreamKey]*rtpStream
+	maxStreams  int
+	sweptAt     time.Time // Last removal of idle streams
+	untracked   bool      // A stream was not tracked because of maxStreams
+}
+
+type rtpStreamKey struct {
+	ssrc uint32
+	dir  Direction
+}
+
+// rtpStream holds RFC 3550 receiver statistics for one SSRC and direction.
+type rtpStream struct {
+	packets  uint64
+	bytes    uint64
+	baseSeq  uint16
+	maxSeq   uint16
+	cycles   uint64
+	jitter   float64 // In timestamp units
+	transit  float64
+	lastSeen time.Time
+}
+
+// RTPStreamStats is a snapshot of one stream for the admin API.
+type RTPStreamStats struct {
+	Client    string  `json:"client"`
+	SSRC      uint32  `json:"ssrc"`
+	Direction string  `json:"direction"`
+	Packets   uint64  `json:"packets"`
+	Bytes     uint64  `json:"bytes"`
+	Lost      int64   `json:"lost"`
+	LossPct   float64 `json:"loss_pct"`
+	JitterMs  float64 `json:"jitter_ms"`
+	IdleSecs  int64   `json:"idle_seconds"`
+}
+
+// NewRTPHandler creates a new rtp handler.
+func NewRTPHandler(raw json.RawMessage) (Handler, error) {
+	var cfg RTPConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid rtp config: %w", err)
+		}
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = "rtp"
+	}
+	if cfg.ConsentTimeout == 0 {
+		cfg.ConsentTimeout = 30
+	}
+	if cfg.ClockRate < 0 {
+		return nil, errors.New("rtp: clock_rate must be positive")
+	}
+	if cfg.ClockRate == 0 {
+		cfg.ClockRate = 90000
+	}
+	if cfg.MaxStreams < 0 {
+		return nil, errors.New("rtp: max_streams must be positive")
+	}
+	if cfg.MaxStreams == 0 {
+		cfg.MaxStreams = defaultRTPMaxStreams
+	}
+
+	h := &RTPHandler{
+		protocol:   cfg.Protocol,
+		clockRate:  float64(cfg.ClockRate),
+		maxStreams: cfg.MaxStreams,
+		flows:      make(map[*Context]*rtpFlow),
+	}
+	if cfg.ConsentTimeout > 0 {
+		h.consentTimeout = time.Duration(cfg.ConsentTimeout) * time.Second
+	}
+	return h, nil
+}
+
+// Name returns the handler name.
+func (h *RTPHandler) Name() string {
+	return "rtp"
+}
+
+// OnConnect starts tracking a media flow.
+func (h *RTPHandler) OnConnect(ctx *Context) Result {
+	if ctx.Protocol != h.protocol {
+		return Result{Action: Continue}
+	}
+
+	flow := &rtpFlow{
+		streams:     make(map[rtpStreamKey]*rtpStream),
+		maxStreams:  h.maxStreams,
+		lastConsent: time.Now(),
+	}
+	if ctx.ClientAddr != nil {
+		flow.client = privacy.Addr(ctx.ClientAddr)
+	}
+	flow.observe(ctx.InitialPacket, Inbound, h.clockRate)
+
+	h.mu.Lock()
+	h.flows[ctx] = flow
+	h.mu.Unlock()
+
+	// Observe media from the backend as well
+	next := ctx.OnServerPacket
+	ctx.OnServerPacket = func(packet []byte) {
+		flow.observe(packet, Outbound, h.clockRate)
+		if next != nil {
+			next(packet)
+		}
+	}
+	return Result{Action: Continue}
+}
+
+// OnPacket records client packets and enforces ICE consent freshness.
+func (h *RTPHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	if ctx.Protocol != h.protocol {
+		return Result{Action: Continue}
+	}
+	h.mu.Lock()
+	flow := h.flows[ctx]
+	h.mu.Unlock()
+	if flow == nil {
+		return Result{Action: Continue}
+	}
+
+	flow.observe(packet, dir, h.clockRate)
+	if h.consentTimeout > 0 && flow.consentExpired(h.consentTimeout) {
+		rtpLog.Printf("%s: ICE consent expired, dropping flow", flow.client)
+		ctx.DropWithReason(CloseHandlerDrop)
+		return Result{Action: Drop, Error: errors.New("ICE consent expired")}
+	}
+	return Result{Action: Continue}
+}
+
+// OnDisconnect stops tracking the flow.
+func (h *RTPHandler) OnDisconnect(ctx *Context) {
+	h.mu.Lock()
+	delete(h.flows, ctx)
+	h.mu.Unlock()
+}
+
+// observe classifies a packet (RFC 7983) and updates flow state.
+func (f *rtpFlow) observe(packet []byte, dir Direction, clockRate float64) {
+	if len(packet) == 0 {
+		return
+	}
+	now := time.Now()
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	switch b := packet[0]; {
+	case b <= 3:
+		f.observeSTUN(packet, now)
+	case b >= 128 && b <= 191:
+		// RTCP packet types 192-223 share the range; only RTP carries media
+		if len(packet) < 12 || (packet[1] >= 192 && packet[1] <= 223) {
+			return
+		}
+		key := rtpStreamKey{ssrc: binary.BigEndian.Uint32(packet[8:12]), dir: dir}
+		s := f.streams[key]
+		if s == nil {
+			if s = f.addStream(key, now); s == nil {
+				return
+			}
+		}
+		s.update(packet, now, clockRate)
+	}
+}
+
+// addStream starts tracking a stream. When the flow already tracks
+// maxStreams, idle ones are forgotten first; if none is, the new stream is
+// not tracked and nil is returned, so random SSRCs cannot grow the map.
+func (f *rtpFlow) addStream(key rtpStreamKey, now time.Time) *rtpStream {
+	if len(f.streams) >= f.maxStreams && now.Sub(f.sweptAt) >= time.Second {
+		f.sweptAt = now
+		for k, s := range f.streams {
+			if now.Sub(s.lastSeen) >= rtpStreamIdle {
+				delete(f.streams, k)
+			}
+		}
+	}
+	if len(f.streams) >= f.maxStreams {
+		if !f.untracked {
+			f.untracked = true
+			rtpLog.Printf("%s: more than %d streams, new ones are not tracked", f.client, f.maxStreams)
+		}
+		return nil
+	}
+	s := &rtpStream{}
+	f.streams[key] = s
+	return s
+}
+
+// observeSTUN refreshes consent on binding success responses.
+func (f *rtpFlow) observeSTUN(packet []byte, now time.Time) {
+	if len(packet) < 20 || binary.BigEndian.Uint32(packet[4:8]) != stunMagicCookie {
+		return
+	}
+	switch binary.BigEndian.Uint16(packet[0:2]) {
+	case stunBindingRequest:
+		f.iceSeen = true
+	case stunBindingSuccess:
+		f.iceSeen = true
+		f.lastConsent = now
+	}
+}
+
+// consentExpired reports whether an ICE flow went too long without consent.
+// Flows that never used ICE are not subject to consent checks.
+func (f *rtpFlow) consentExpired(timeout time.Duration) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.iceSeen && time.Since(f.lastConsent) > timeout
+}
+
+// update applies an RTP packet to the stream statistics (RFC 3550 A.1 and A.8).
+func (s *rtpStream) update(packet []byte, now time.Time, clockRate float64) {
+	seq := binary.BigEndian.Uint16(packet[2:4])
+	ts := binary.BigEndian.Uint32(packet[4:8])
+
+	if s.packets == 0 {
+		s.baseSeq = seq
+		s.maxSeq = seq
+	} else if delta := seq - s.maxSeq; delta < 0x8000 {
+		if seq < s.maxSeq {
+			s.cycles += 1 << 16 // Sequence number wrapped
+		}
+		s.maxSeq = seq
+	}
+	s.packets++
+	s.bytes += uint64(len(packet))
+
+	arrival := float64(now.UnixNano()) / 1e9 * clockRate
+	transit := arrival - float64(ts)
+	if !s.lastSeen.IsZero() {
+		d := transit - s.transit
+		if d < 0 {
+			d = -d
+		}
+		s.jitter += (d - s.jitter) / 16
+	}
+	s.transit = transit
+	s.lastSeen = now
+}
+
+// lost returns the cumulative number of lost packets.
+func (s *rtpStream) lost() int64 {
+	expected := s.cycles + uint64(s.maxSeq) - uint64(s.baseSeq) + 1
+	lost := int64(expected) - int64(s.packets)
+	if lost < 0 {
+		return 0 // Duplicates
+	}
+	return lost
+}
+
+// Streams returns statistics of all tracked streams.
+func (h *RTPHandler) Streams() []RTPStreamStats {
+	h.mu.Lock()
+	flows := make([]*rtpFlow, 0, len(h.flows))
+	for _, f := range h.flows {
+		flows = append(flows, f)
+	}
+	h.mu.Unlock()
+
+	var out []RTPStreamStats
+	for _, f := range flows {
+		f.mu.Lock()
+		for key, s := range f.streams {
+			st := RTPStreamStats{
+				Client:    f.client,
+				SSRC:      key.ssrc,
+				Direction: "inbound",
+				Packets:   s.packets,
+				Bytes:     s.bytes,
+				Lost:      s.lost(),
+				JitterMs:  s.jitter / h.clockRate * 1000,
+				IdleSecs:  int64(time.Since(s.lastSeen).Seconds()),
+			}
+			if key.dir == Outbound {
+				st.Direction = "outbound"
+			}
+			if expected := s.packets + uint64(st.Lost); expected > 0 {
+				st.LossPct = float64(st.Lost) / float64(expected) * 100
+			}
+			out = append(out, st)
+		}
+		f.mu.Unlock()
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Client != out[j].Client {
+			return out[i].Client < out[j].Client
+		}
+		return out[i].SSRC < out[j].SSRC
+	})
+	return out
+}
+
+// ServeAdmin lists per-stream statistics.
+func (h *RTPHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, h.Streams())
+}
@@ -0,0 +1,148 @@
+package handler
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func buildRTP(ssrc uint32, seq uint16, ts uint32) []byte {
+	pkt := make([]byte, 20)
+	pkt[0] = 0x80
+	pkt[1] = 96
+	binary.BigEndian.PutUint16(pkt[2:4], seq)
+	binary.BigEndian.PutUint32(pkt[4:8], ts)
+	binary.BigEndian.PutUint32(pkt[8:12], ssrc)
+	return pkt
+}
+
+func buildSTUN(msgType uint16) []byte {
+	pkt := make([]byte, 20)
+	binary.BigEndian.PutUint16(pkt[0:2], msgType)
+	binary.BigEndian.PutUint32(pkt[4:8], stunMagicCookie)
+	return pkt
+}
+
+func newTestRTPHandler(t *testing.T, config string) *RTPHandler {
+	t.Helper()
+	h, err := NewRTPHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	return h.(*RTPHandler)
+}
+
+func TestRTPStream_Loss(t *testing.T) {
+	h := newTestRTPHandler(t, `{}`)
+	ctx := &Context{Protocol: "rtp", ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, InitialPacket: buildRTP(1, 65533, 0)}
+	h.OnConnect(ctx)
+
+	// 65533 .. 4 with 65535 and 2 missing, across the wrap
+	for _, seq := range []uint16{65534, 0, 1, 3, 4} {
+		h.OnPacket(ctx, buildRTP(1, seq, uint32(seq)*3000), Inbound)
+	}
+	// RTCP sender report is not counted as media
+	h.OnPacket(ctx, []byte{0x80, 200, 0, 6, 0, 0, 0, 1, 0, 0, 0, 0}, Inbound)
+
+	streams := h.Streams()
+	if len(streams) != 1 {
+		t.Fatalf("streams = %+v", streams)
+	}
+	s := streams[0]
+	if s.SSRC != 1 || s.Packets != 6 || s.Lost != 2 {
+		t.Errorf("stats = %+v, want 6 packets and 2 lost", s)
+	}
+	if s.LossPct < 24.9 || s.LossPct > 25.1 {
+		t.Errorf("loss = %.2f%%, want 25%%", s.LossPct)
+	}
+}
+
+func TestRTPHandler_MaxStreams(t *testing.T) {
+	h := newTestRTPHandler(t, `{"max_streams": 4}`)
+	ctx := &Context{Protocol: "rtp", ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, InitialPacket: buildRTP(1, 1, 0)}
+	h.OnConnect(ctx)
+
+	for ssrc := uint32(2); ssrc < 10000; ssrc++ {
+		h.OnPacket(ctx, buildRTP(ssrc, 1, 0), Inbound)
+	}
+	if n := len(h.Streams()); n != 4 {
+		t.Fatalf("%d streams tracked, want 4", n)
+	}
+	if streams := h.Streams(); streams[0].SSRC != 1 || streams[0].Packets != 1 {
+		t.Errorf("first stream = %+v", streams[0])
+	}
+
+	// Idle streams make room for new ones
+	flow := h.flows[ctx]
+	flow.mu.Lock()
+	for _, s := range flow.streams {
+		s.lastSeen = s.lastSeen.Add(-rtpStreamIdle)
+	}
+	flow.sweptAt = time.Time{}
+	flow.mu.Unlock()
+	h.OnPacket(ctx, buildRTP(20000, 1, 0), Inbound)
+	if streams := h.Streams(); len(streams) != 1 || streams[0].SSRC != 20000 {
+		t.Errorf("streams = %+v", streams)
+	}
+}
+
+func TestRTPHandler_ServerPackets(t *testing.T) {
+	h := newTestRTPHandler(t, `{}`)
+	ctx := &Context{Protocol: "rtp", ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, InitialPacket: buildRTP(1, 1, 0)}
+	h.OnConnect(ctx)
+	ctx.NotifyServerPacket(buildRTP(2, 1, 0))
+
+	streams := h.Streams()
+	if len(streams) != 2 || streams[1].SSRC != 2 || streams[1].Direction != "outbound" {
+		t.Errorf("streams = %+v", streams)
+	}
+
+	h.OnDisconnect(ctx)
+	if len(h.Streams()) != 0 {
+		t.Error("flow not released on disconnect")
+	}
+}
+
+func TestRTPHandler_Consent(t *testing.T) {
+	h := newTestRTPHandler(t, `{"consent_timeout": 30}`)
+
+	// Plain RTP without ICE is never subject to consent
+	plain := &Context{Protocol: "rtp", InitialPacket: buildRTP(1, 1, 0)}
+	h.OnConnect(plain)
+	h.flows[plain].lastConsent = time.Now().Add(-time.Hour)
+	if result := h.OnPacket(plain, buildRTP(1, 2, 0), Inbound); result.Action != Continue {
+		t.Errorf("plain RTP dropped: %v", result.Error)
+	}
+
+	ice := &Context{Protocol: "rtp", InitialPacket: buildSTUN(stunBindingRequest)}
+	h.OnConnect(ice)
+	ice.NotifyServerPacket(buildSTUN(stunBindingSuccess))
+	if result := h.OnPacket(ice, buildRTP(1, 1, 0), Inbound); result.Action != Continue {
+		t.Fatalf("fresh consent dropped: %v", result.Error)
+	}
+
+	h.flows[ice].lastConsent = time.Now().Add(-time.Minute)
+	result := h.OnPacket(ice, buildRTP(1, 2, 0), Inbound)
+	if result.Action != Drop {
+		t.Fatalf("expected Drop after consent expiry, got %v", result.Action)
+	}
+	if ice.CloseReason() != CloseHandlerDrop {
+		t.Errorf("close reason = %s", ice.CloseReason())
+	}
+}
+
+func TestRTPHandler_ServeAdmin(t *testing.T) {
+	h := newTestRTPHandler(t, `{}`)
+	ctx := &Context{Protocol: "rtp", ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 5000}, InitialPacket: buildRTP(42, 1, 0)}
+	h.OnConnect(ctx)
+
+	w := httptest.NewRecorder()
+	h.ServeAdmin(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != 200 || !strings.Contains(w.Body.String(), `"ssrc":42`) {
+		t.Errorf("response %d: %s", w.Code, w.Body)
+	}
+}
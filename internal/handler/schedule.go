@@ -0,0 +1,99 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// scheduleConfig is a time window within a route that selects its own backends.
+type scheduleConfig struct {
+	Days     []string       `json:"days,omitempty"` // e.g. ["mon", "fri"]; empty = every day
+	From     string         `json:"from"`           // "HH:MM", inclusive
+	To       string         `json:"to"`             // "HH:MM", exclusive; may be earlier than From to wrap midnight
+	Backends []backendEntry `json:"backends,omitempty"`
+	Block    bool           `json:"block,omitempty"` // Refuse connections during this window
+}
+
+// schedule is a parsed scheduleConfig.
+type schedule struct {
+	days     [7]bool // Indexed by time.Weekday; all false = every day
+	anyDay   bool
+	from, to int // Minutes since midnight
+	block    bool
+	pool     *backendPool
+}
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// parseSchedule validates and converts a schedule config.
+func parseSchedule(cfg scheduleConfig) (*schedule, error) {
+	s := &schedule{block: cfg.Block, anyDay: len(cfg.Days) == 0}
+	for _, d := range cfg.Days {
+		wd, ok := weekdays[strings.ToLower(d)[:min(3, len(d))]]
+		if !ok {
+			return nil, fmt.Errorf("invalid day %q", d)
+		}
+		s.days[wd] = true
+	}
+
+	var err error
+	if s.from, err = parseClock(cfg.From); err != nil {
+		return nil, fmt.Errorf("invalid 'from': %w", err)
+	}
+	if s.to, err = parseClock(cfg.To); err != nil {
+		return nil, fmt.Errorf("invalid 'to': %w", err)
+	}
+	if s.from == s.to {
+		return nil, errors.New("'from' and 'to' must differ")
+	}
+	if s.block {
+		return s, nil
+	}
+	if len(cfg.Backends) == 0 {
+		return nil, errors.New("schedule requires 'backends' or 'block'")
+	}
+	if s.pool, err = newBackendPool(cfg.Backends); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight. "24:00" is allowed as end of day.
+func parseClock(v string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(v, "%d:%d", &h, &m); err != nil {
+		return 0, fmt.Errorf("expected HH:MM, got %q", v)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("time out of range: %q", v)
+	}
+	return h*60 + m, nil
+}
+
+// matches reports whether t falls into the window.
+// For windows wrapping midnight, the day check applies to the day the window starts.
+func (s *schedule) matches(t time.Time) bool {
+	minute := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+
+	if s.from < s.to {
+		return minute >= s.from && minute < s.to && s.onDay(day)
+	}
+	// Wraps midnight: [from, 24:00) on the start day, [00:00, to) on the following day
+	if minute >= s.from {
+		return s.onDay(day)
+	}
+	if minute < s.to {
+		return s.onDay((day + 6) % 7)
+	}
+	return false
+}
+
+func (s *schedule) onDay(d time.Weekday) bool {
+	return s.anyDay || s.days[d]
+}
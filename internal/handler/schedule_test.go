@@ -0,0 +1,155 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseClock(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    int
+		wantErr bool
+	}{
+		{"00:00", 0, false},
+		{"18:30", 18*60 + 30, false},
+		{"24:00", 24 * 60, false},
+		{"24:01", 0, true},
+		{"12:60", 0, true},
+		{"noon", 0, true},
+	}
+	for _, tt := range tests {
+		got, err := parseClock(tt.in)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseClock(%q) error = %v, wantErr %v", tt.in, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseClock(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleMatches(t *testing.T) {
+	// 2026-10-17 is a Saturday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2026, 10, day, hour, minute, 0, 0, time.UTC)
+	}
+
+	tests := []struct {
+		name string
+		cfg  scheduleConfig
+		t    time.Time
+		want bool
+	}{
+		{"inside", scheduleConfig{From: "18:00", To: "23:00", Block: true}, at(17, 20, 0), true},
+		{"at end", scheduleConfig{From: "18:00", To: "23:00", Block: true}, at(17, 23, 0), false},
+		{"wrong day", scheduleConfig{Days: []string{"sun"}, From: "18:00", To: "23:00", Block: true}, at(17, 20, 0), false},
+		{"right day", scheduleConfig{Days: []string{"Saturday"}, From: "18:00", To: "23:00", Block: true}, at(17, 20, 0), true},
+		{"wrap before midnight", scheduleConfig{Days: []string{"sat"}, From: "22:00", To: "02:00", Block: true}, at(17, 23, 0), true},
+		{"wrap after midnight", scheduleConfig{Days: []string{"sat"}, From: "22:00", To: "02:00", Block: true}, at(18, 1, 0), true},
+		{"wrap next night", scheduleConfig{Days: []string{"sat"}, From: "22:00", To: "02:00", Block: true}, at(18, 23, 0), false},
+		{"wrap gap", scheduleConfig{From: "22:00", To: "02:00", Block: true}, at(17, 12, 0), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := parseSchedule(tt.cfg)
+			if err != nil {
+				t.Fatalf("parseSchedule: %v", err)
+			}
+			if got := s.matches(tt.t); got != tt.want {
+				t.Errorf("matches = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDynamicHandler_Schedules(t *testing.T) {
+	config := `{"routes": {"play.com": {
+		"backends": ["day:5520"],
+		"timezone": "UTC",
+		"schedules": [
+			{"days": ["sat", "sun"], "from": "18:00", "to": "23:00", "backends": ["event:5520"]},
+			{"from": "03:00", "to": "04:00", "block": true}
+		]
+	}}}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	dh := h.(*DynamicHandler)
+
+	tests := []struct {
+		name    string
+		now     time.Time
+		backend string
+	}{
+		{"default", time.Date(2026, 10, 16, 20, 0, 0, 0, time.UTC), "day:5520"},
+		{"weekend event", time.Date(2026, 10, 17, 20, 0, 0, 0, time.UTC), "event:5520"},
+		{"maintenance", time.Date(2026, 10, 16, 3, 30, 0, 0, time.UTC), ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dh.now = func() time.Time { return tt.now }
+			ctx := &Context{Hello: &ClientHello{SNI: "play.com"}}
+			result := h.OnConnect(ctx)
+			if tt.backend == "" {
+				if result.Action != Drop {
+					t.Fatalf("expected Drop, got %v", result.Action)
+				}
+				return
+			}
+			if result.Action != Continue {
+				t.Fatalf("expected Continue, got %v (%v)", result.Action, result.Error)
+			}
+			if got := ctx.GetString("backend"); got != tt.backend {
+				t.Errorf("backend = %q, want %q", got, tt.backend)
+			}
+		})
+	}
+}
+
+func TestDynamicHandler_ScheduleOnly(t *testing.T) {
+	config := `{"routes": {"event.com": {"schedules": [{"from": "18:00", "to": "20:00", "backends": ["event:5520"]}]}}}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	h.(*DynamicHandler).now = func() time.Time { return time.Date(2026, 10, 16, 12, 0, 0, 0, time.Local) }
+
+	result := h.OnConnect(&Context{Hello: &ClientHello{SNI: "event.com"}})
+	if result.Action != Drop {
+		t.Fatalf("expected Drop, got %v", result.Action)
+	}
+	if result.Error == nil || !strings.Contains(result.Error.Error(), "outside scheduled hours") {
+		t.Errorf("unexpected error: %v", result.Error)
+	}
+}
+
+func TestDynamicHandler_InvalidSchedules(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"empty object", `{"routes": {"a.com": {}}}`, "empty backends"},
+		{"bad timezone", `{"routes": {"a.com": {"backends": ["b:1"], "timezone": "Mars/Olympus"}}}`, "invalid timezone"},
+		{"bad day", `{"routes": {"a.com": {"schedules": [{"days": ["xyz"], "from": "1:00", "to": "2:00", "block": true}]}}}`, "invalid day"},
+		{"bad time", `{"routes": {"a.com": {"schedules": [{"from": "25:00", "to": "2:00", "block": true}]}}}`, "invalid 'from'"},
+		{"empty window", `{"routes": {"a.com": {"schedules": [{"from": "2:00", "to": "2:00", "block": true}]}}}`, "must differ"},
+		{"no action", `{"routes": {"a.com": {"schedules": [{"from": "1:00", "to": "2:00"}]}}}`, "requires 'backends' or 'block'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDynamicHandler(json.RawMessage(tt.config))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+			if !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error %q should contain %q", err, tt.errMsg)
+			}
+		})
+	}
+}
@@ -0,0 +1,199 @@
+package handler
+
+import (
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var shadowLog = logging.For("shadow")
+
+// shadowHandler evaluates a handler configured with "enforce": false without
+// applying its decisions: drops are logged and ignored, and context changes
+// made in OnConnect (routing decisions, a rewritten SNI) are logged and undone.
+// A Handled result cannot be undone and is applied.
+type shadowHandler struct {
+	Handler
+}
+
+// canShadow reports whether handlers of type name can be evaluated without
+// enforcing them. The forwarder and terminator carry the traffic itself, and
+// chaos only acts on the traffic.
+func canShadow(name string) bool {
+	return name != "forwarder" && name != "terminator" && name != "chaos"
+}
+
+// newShadowHandler wraps h for shadow evaluation.
+func newShadowHandler(h Handler) *shadowHandler {
+	return &shadowHandler{Handler: h}
+}
+
+// Unwrap returns the handler evaluated in shadow mode or behind a feature
+// flag, or h itself. Admin controls of such handlers are applied as usual.
+func Unwrap(h Handler) Handler {
+	if f, ok := h.(*featureHandler); ok {
+		h = f.Handler
+	}
+	if s, ok := h.(*shadowHandler); ok {
+		return s.Handler
+	}
+	return h
+}
+
+// DisplayName returns the handler name for logs, marking shadowed handlers
+// and those behind a feature flag.
+func DisplayName(h Handler) string {
+	name, feature := h.Name(), ""
+	if f, ok := h.(*featureHandler); ok {
+		h, feature = f.Handler, f.feature
+	}
+	if _, ok := h.(*shadowHandler); ok {
+		name += " (shadow)"
+	}
+	if feature != "" {
+		name += " (feature " + feature + ")"
+	}
+	return name
+}
+
+// OnConnect runs the handler on ctx and reverts what it decided.
+func (s *shadowHandler) OnConnect(ctx *Context) Result {
+	values, hello := ctx.copyValues(), ctx.Hello
+	result := s.Handler.OnConnect(ctx)
+	changes := diffValues(values, ctx.copyValues())
+	if ctx.Hello != hello {
+		if hello != nil && ctx.Hello != nil && ctx.Hello.SNI != hello.SNI {
+			changes = append(changes, "sni="+ctx.Hello.SNI)
+		}
+		ctx.Hello = hello
+	}
+
+	switch result.Action {
+	case Drop:
+		s.logf(ctx, "would drop: %s", dropReason(result))
+	case Redirect:
+		s.logf(ctx, "would redirect to pipeline %s", result.Target)
+	case Hold:
+		s.logf(ctx, "would hold the connection")
+	case Handled:
+		shadowLog.Warnf("handler=%s took over the connection, which cannot be evaluated without applying it", s.Name())
+		return result
+	}
+	if len(changes) > 0 {
+		s.logf(ctx, "would set %s", strings.Join(changes, " "))
+	}
+	ctx.replaceValues(values)
+	return Result{Action: Continue}
+}
+
+// OnPacket ignores drops and delays. Changes made to the packet itself are kept.
+func (s *shadowHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	result := s.Handler.OnPacket(ctx, packet, dir)
+	switch result.Action {
+	case Delay:
+		key := "_shadow_packet_delay_" + s.Name()
+		if !ctx.GetBool(key) {
+			ctx.Set(key, true)
+			s.logf(ctx, "would delay %s packets by %s", directionName(dir), result.Delay)
+		}
+		return Result{Action: Continue}
+	case Drop:
+		// Log once per session, packets arrive too often to log each one
+		key := "_shadow_packet_drop_" + s.Name()
+		if !ctx.GetBool(key) {
+			ctx.Set(key, true)
+			s.logf(ctx, "would drop %s packets: %s", directionName(dir), dropReason(result))
+		}
+		return Result{Action: Continue}
+	case Handled:
+		return result
+	}
+	return Result{Action: Continue}
+}
+
+// OnDatagram reports what the handler would answer without sending it.
+func (s *shadowHandler) OnDatagram(clientAddr *net.UDPAddr, packet []byte, reply func([]byte) error) bool {
+	dh, ok := s.Handler.(DatagramHandler)
+	if !ok {
+		return false
+	}
+	var replies int
+	if dh.OnDatagram(clientAddr, packet, func([]byte) error { replies++; return nil }) {
+		shadowLog.Printf("handler=%s client=%s would consume datagram (%d replies)", s.Name(), privacy.Addr(clientAddr), replies)
+	}
+	return false
+}
+
+// Restore ignores drops of restored sessions.
+func (s *shadowHandler) Restore(ctx *Context, id uint64) Result {
+	r, ok := s.Handler.(Restorer)
+	if !ok {
+		return Result{Action: Continue}
+	}
+	result := r.Restore(ctx, id)
+	if result.Action == Drop {
+		s.logf(ctx, "would drop restored session %d: %s", id, dropReason(result))
+		return Result{Action: Continue}
+	}
+	return result
+}
+
+// CancelConnect forwards to the handler.
+func (s *shadowHandler) CancelConnect(ctx *Context) {
+	if cc, ok := s.Handler.(ConnectCanceler); ok {
+		cc.CancelConnect(ctx)
+	}
+}
+
+// Close forwards to the handler.
+func (s *shadowHandler) Close() error {
+	if cl, ok := s.Handler.(Closer); ok {
+		return cl.Close()
+	}
+	return nil
+}
+
+func (s *shadowHandler) logf(ctx *Context, format string, v ...any) {
+	who := fmt.Sprintf("handler=%s client=%s", s.Name(), privacy.Addr(ctx.OriginalClientAddr()))
+	switch {
+	case ctx.Hello != nil && ctx.Hello.SNI != "":
+		who += " sni=" + ctx.Hello.SNI
+	case ctx.Protocol != "":
+		who += " protocol=" + ctx.Protocol
+	}
+	shadowLog.Printf("%s "+format, append([]any{who}, v...)...)
+}
+
+func directionName(dir Direction) string {
+	if dir == Outbound {
+		return "backend"
+	}
+	return "client"
+}
+
+func dropReason(result Result) string {
+	if result.Error != nil {
+		return result.Error.Error()
+	}
+	return "no reason given"
+}
+
+// diffValues lists keys set or changed in after as key=value, sorted.
+// Keys starting with "_" are internal bookkeeping and skipped.
+func diffValues(before, after map[string]any) []string {
+	var changes []string
+	for _, k := range slices.Sorted(maps.Keys(after)) {
+		if strings.HasPrefix(k, "_") {
+			continue
+		}
+		if old, ok := before[k]; !ok || fmt.Sprint(old) != fmt.Sprint(after[k]) {
+			changes = append(changes, fmt.Sprintf("%s=%v", k, after[k]))
+		}
+	}
+	return changes
+}
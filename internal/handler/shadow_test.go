@@ -0,0 +1,108 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+)
+
+func buildShadowChain(t *testing.T, configs string) *Chain {
+	t.Helper()
+	var cfgs []HandlerConfig
+	if err := json.Unmarshal([]byte(configs), &cfgs); err != nil {
+		t.Fatal(err)
+	}
+	chain, err := BuildChain(cfgs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(chain.Close)
+	return chain
+}
+
+func TestShadowHandler_IgnoresDrop(t *testing.T) {
+	chain := buildShadowChain(t, `[
+		{"type": "sni-rewrite", "enforce": false, "config": {"map": {"a.example.com": "a.internal"}, "drop_unmatched": true}},
+		{"type": "sni-router", "config": {"routes": {"other.org": "10.0.0.1:5520"}}}
+	]`)
+	if got := DisplayName(chain.Handlers()[0]); got != "sni-rewrite (shadow)" {
+		t.Errorf("DisplayName = %q", got)
+	}
+
+	ctx := &Context{Hello: &ClientHello{SNI: "other.org"}, ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}}
+	for _, h := range chain.Handlers() {
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("%s: action = %v, err %v", h.Name(), res.Action, res.Error)
+		}
+	}
+	if got := ctx.GetString("backend"); got != "10.0.0.1:5520" {
+		t.Errorf("backend = %q", got)
+	}
+}
+
+func TestShadowHandler_RevertsChanges(t *testing.T) {
+	chain := buildShadowChain(t, `[
+		{"type": "sni-rewrite", "enforce": false, "config": {"map": {"a.example.com": "a.internal"}}},
+		{"type": "sni-router", "enforce": false, "config": {"routes": {"a.example.com": "10.0.0.2:5520"}}}
+	]`)
+	hello := &ClientHello{SNI: "a.example.com"}
+	ctx := &Context{Hello: hello, ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}}
+	ctx.Set("backend", "10.0.0.1:5520")
+	for _, h := range chain.Handlers() {
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("%s: action = %v", h.Name(), res.Action)
+		}
+	}
+	if ctx.Hello != hello || ctx.Hello.SNI != "a.example.com" {
+		t.Errorf("SNI = %q, want rewrite reverted", ctx.Hello.SNI)
+	}
+	if _, ok := ctx.Get(OriginalSNIKey); ok {
+		t.Error("original_sni left in context")
+	}
+	if got := ctx.GetString("backend"); got != "10.0.0.1:5520" {
+		t.Errorf("backend = %q, want routing reverted", got)
+	}
+}
+
+func TestShadowHandler_TenantSlots(t *testing.T) {
+	chain := buildShadowChain(t, `[
+		{"type": "tenants", "enforce": false, "config": {"tenants": {"shadow-a": {"snis": ["a.example.com"], "max_connections": 1}}}}
+	]`)
+	h := chain.Handlers()[0]
+	first := tenantCtx("a.example.com", "192.0.2.1")
+	for range 2 {
+		ctx := tenantCtx("a.example.com", "192.0.2.2")
+		if res := h.OnConnect(first); res.Action != Continue {
+			t.Fatalf("action = %v", res.Action)
+		}
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("over quota in shadow mode: action = %v", res.Action)
+		}
+		if ctx.GetString(TenantKey) != "" {
+			t.Error("tenant label kept in shadow mode")
+		}
+		h.OnDisconnect(first)
+		h.OnDisconnect(ctx)
+	}
+	if st := GetTenantStats()["shadow-a"]; st.Active != 0 || st.RejectedQuota != 2 {
+		t.Errorf("stats = %+v, want slots released and rejections counted", st)
+	}
+}
+
+func TestShadowHandler_Unwrap(t *testing.T) {
+	chain := buildShadowChain(t, `[{"type": "tenants", "enforce": false, "config": {"tenants": {"unwrap-a": {}}}}]`)
+	if _, ok := Unwrap(chain.Handlers()[0]).(AdminHandler); !ok {
+		t.Error("admin controls of shadowed handler not reachable")
+	}
+	if _, ok := chain.Handlers()[0].(*shadowHandler); !ok {
+		t.Error("handler not wrapped")
+	}
+}
+
+func TestBuildChain_EnforceForwarder(t *testing.T) {
+	_, err := BuildChain([]HandlerConfig{{Type: "forwarder", Enforce: new(bool)}})
+	if err == nil || !strings.Contains(err.Error(), "cannot run with enforce: false") {
+		t.Errorf("err = %v", err)
+	}
+}
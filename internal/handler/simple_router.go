@@ -4,7 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"sync/atomic"
+	"slices"
 )
 
 func init() {
@@ -20,7 +20,7 @@ type StaticConfig struct {
 // StaticHandler routes all connections to a fixed backend or load-balances across multiple.
 type StaticHandler struct {
 	backends []string
-	counter  atomic.Uint64
+	pool     *backendPool
 }
 
 // NewStaticHandler creates a new static handler.
@@ -43,7 +43,7 @@ func NewStaticHandler(raw json.RawMessage) (Handler, error) {
 		return nil, fmt.Errorf("simple-router requires 'backend', 'backends' config or QUIC_RELAY_BACKEND env")
 	}
 
-	return &StaticHandler{backends: backends}, nil
+	return &StaticHandler{backends: backends, pool: poolFromStrings(backends)}, nil
 }
 
 // Name returns the handler name.
@@ -51,11 +51,20 @@ func (h *StaticHandler) Name() string {
 	return "simple-router"
 }
 
-// OnConnect sets the backend address in context (round-robin if multiple).
+// Backends lists the configured backends.
+func (h *StaticHandler) Backends() []string {
+	return h.backends
+}
+
+// OnConnect sets the backend address in context (round-robin if multiple,
+// skipping draining backends).
+// A resumed backend is kept while it is still configured.
 func (h *StaticHandler) OnConnect(ctx *Context) Result {
-	idx := h.counter.Add(1) - 1
-	backend := h.backends[idx%uint64(len(h.backends))]
-	ctx.Set("backend", backend)
+	if backend := ctx.ResumedBackend(); backend != "" && slices.Contains(h.backends, backend) {
+		ctx.Set("backend", backend)
+		return Result{Action: Continue}
+	}
+	ctx.Set("backend", h.pool.pick(nil))
 	return Result{Action: Continue}
 }
 
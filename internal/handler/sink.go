@@ -0,0 +1,71 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Register("sink", NewSinkHandler)
+}
+
+// SinkHandler accepts sessions like the forwarder does and discards their
+// packets without opening backend sockets. Replays use it in place of the
+// forwarder and terminator; it is also handy for testing routing policies.
+type SinkHandler struct {
+	sessionCounter atomic.Uint64
+}
+
+// NewSinkHandler creates a new sink handler. It takes no configuration.
+func NewSinkHandler(raw json.RawMessage) (Handler, error) {
+	return &SinkHandler{}, nil
+}
+
+// Name returns the handler name.
+func (h *SinkHandler) Name() string { return "sink" }
+
+// OnConnect attaches a session to ctx for the backend the router chose.
+func (h *SinkHandler) OnConnect(ctx *Context) Result {
+	backend := ctx.GetString("backend")
+	if backend == "" {
+		return Result{Action: Drop, Error: errors.New("no backend address")}
+	}
+
+	now := time.Now()
+	session := &Session{ID: h.sessionCounter.Add(1), CreatedAt: now}
+	backend, _ = ParseRelayBackend(backend)
+	if ap, err := netip.ParseAddrPort(backend); err == nil {
+		session.SetBackendAddr(net.UDPAddrFromAddrPort(ap))
+	}
+	session.SetClientAddr(ctx.ClientAddr)
+	session.LastActivity.Store(now.Unix())
+	if len(ctx.InitialPacket) > 0 {
+		session.CountIn(len(ctx.InitialPacket))
+	}
+	ctx.InitialPacket = nil
+	ctx.Session = session
+	return Result{Action: Handled}
+}
+
+// OnPacket counts and discards client packets.
+func (h *SinkHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	if ctx.Session == nil || ctx.Session.IsClosed() {
+		return Result{Action: Drop}
+	}
+	ctx.Session.Touch()
+	if dir == Inbound {
+		ctx.Session.CountIn(len(packet))
+	}
+	return Result{Action: Handled}
+}
+
+// OnDisconnect closes the session.
+func (h *SinkHandler) OnDisconnect(ctx *Context) {
+	if ctx.Session != nil {
+		ctx.Session.Close()
+	}
+}
@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"quic-relay/internal/logging"
+)
+
+var sniRewriteLog = logging.ForHandler("sni-rewrite")
+
+func init() {
+	Register("sni-rewrite", NewSNIRewriteHandler)
+}
+
+// OriginalSNIKey is the context key holding the SNI the client sent when
+// sni-rewrite replaced it. ctx.Hello.SNI holds the rewritten name.
+const OriginalSNIKey = "original_sni"
+
+// SNIRewriteConfig is the configuration for the sni-rewrite handler.
+type SNIRewriteConfig struct {
+	Map           map[string]string      `json:"map,omitempty"`            // Exact name -> new name, checked first
+	Rules         []SNIRewriteRuleConfig `json:"rules,omitempty"`          // Patterns, first match wins
+	DropUnmatched bool                   `json:"drop_unmatched,omitempty"` // Refuse names no map entry or rule matches
+}
+
+// SNIRewriteRuleConfig maps names matching a pattern to a new name.
+// Each * in Match matches exactly one DNS label; Rewrite refers to the
+// matched labels as $1, $2, ... in order (${1} when followed by a letter).
+type SNIRewriteRuleConfig struct {
+	Match   string `json:"match"`   // e.g. "play.*.example.com"
+	Rewrite string `json:"rewrite"` // e.g. "$1.internal"
+}
+
+// sniRewriteRule is a compiled pattern rule.
+type sniRewriteRule struct {
+	re      *regexp.Regexp
+	rewrite string
+}
+
+// SNIRewriteHandler replaces the SNI routers see, decoupling public names
+// from internal service names. Place it before the router.
+type SNIRewriteHandler struct {
+	exact         map[string]string
+	rules         []sniRewriteRule
+	dropUnmatched bool
+}
+
+// NewSNIRewriteHandler creates a new sni-rewrite handler.
+func NewSNIRewriteHandler(raw json.RawMessage) (Handler, error) {
+	var cfg SNIRewriteConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid sni-rewrite config: %w", err)
+		}
+	}
+	if len(cfg.Map) == 0 && len(cfg.Rules) == 0 {
+		return nil, errors.New("sni-rewrite: map or rules required")
+	}
+
+	h := &SNIRewriteHandler{exact: make(map[string]string, len(cfg.Map)), dropUnmatched: cfg.DropUnmatched}
+	for from, to := range cfg.Map {
+		if from == "" || to == "" {
+			return nil, fmt.Errorf("sni-rewrite: empty name in map entry %q -> %q", from, to)
+		}
+		h.exact[strings.ToLower(from)] = strings.ToLower(to)
+	}
+	for i, rc := range cfg.Rules {
+		rule, err := compileSNIRewriteRule(rc)
+		if err != nil {
+			return nil, fmt.Errorf("sni-rewrite: rule %d: %w", i, err)
+		}
+		h.rules = append(h.rules, rule)
+	}
+	return h, nil
+}
+
+// sniRewriteRef finds $N and ${N} references in a rewrite template.
+var sniRewriteRef = regexp.MustCompile(`\$\{?(\d+)`)
+
+// compileSNIRewriteRule turns a label pattern into an anchored regexp.
+func compileSNIRewriteRule(rc SNIRewriteRuleConfig) (sniRewriteRule, error) {
+	if rc.Match == "" || rc.Rewrite == "" {
+		return sniRewriteRule{}, errors.New("match and rewrite required")
+	}
+	labels := strings.Split(strings.ToLower(rc.Match), ".")
+	wildcards := 0
+	for i, label := range labels {
+		switch {
+		case label == "*":
+			labels[i] = `([^.]+)`
+			wildcards++
+		case label == "" || strings.Contains(label, "*"):
+			return sniRewriteRule{}, fmt.Errorf("invalid match %q: * must be a whole label", rc.Match)
+		default:
+			labels[i] = regexp.QuoteMeta(label)
+		}
+	}
+	for _, m := range sniRewriteRef.FindAllStringSubmatch(rc.Rewrite, -1) {
+		if n, _ := strconv.Atoi(m[1]); n < 1 || n > wildcards {
+			return sniRewriteRule{}, fmt.Errorf("rewrite %q refers to $%s, but match %q has %d wildcard(s)", rc.Rewrite, m[1], rc.Match, wildcards)
+		}
+	}
+	return sniRewriteRule{
+		re:      regexp.MustCompile(`^` + strings.Join(labels, `\.`) + `$`),
+		rewrite: strings.ToLower(rc.Rewrite),
+	}, nil
+}
+
+// Name returns the handler name.
+func (h *SNIRewriteHandler) Name() string { return "sni-rewrite" }
+
+// rewrite returns the new name for sni, or false when nothing matches.
+func (h *SNIRewriteHandler) rewrite(sni string) (string, bool) {
+	sni = strings.ToLower(sni)
+	if to, ok := h.exact[sni]; ok {
+		return to, true
+	}
+	for _, r := range h.rules {
+		if m := r.re.FindStringSubmatchIndex(sni); m != nil {
+			return string(r.re.ExpandString(nil, r.rewrite, sni, m)), true
+		}
+	}
+	return "", false
+}
+
+// OnConnect replaces ctx.Hello.SNI and remembers the original name.
+// Non-QUIC flows and connections without SNI pass through.
+func (h *SNIRewriteHandler) OnConnect(ctx *Context) Result {
+	if ctx.Hello == nil || ctx.Hello.SNI == "" {
+		return Result{Action: Continue}
+	}
+	sni := ctx.Hello.SNI
+	to, ok := h.rewrite(sni)
+	if !ok {
+		if h.dropUnmatched {
+			return Result{Action: Drop, Error: fmt.Errorf("no SNI rewrite for %s", sni)}
+		}
+		return Result{Action: Continue}
+	}
+
+	// Copy so the parsed ClientHello stays untouched for anyone holding it
+	hello := *ctx.Hello
+	hello.SNI = to
+	ctx.Hello = &hello
+	ctx.Set(OriginalSNIKey, sni)
+	sniRewriteLog.Debugf("%s -> %s", sni, to)
+	return Result{Action: Continue}
+}
+
+// OnPacket passes through.
+func (h *SNIRewriteHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect does nothing.
+func (h *SNIRewriteHandler) OnDisconnect(ctx *Context) {}
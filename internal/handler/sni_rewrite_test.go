@@ -0,0 +1,119 @@
+package handler
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestNewSNIRewriteHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"map", `{"map": {"a.example.com": "a.internal"}}`, ""},
+		{"rule", `{"rules": [{"match": "play.*.example.com", "rewrite": "$1.internal"}]}`, ""},
+		{"braced reference", `{"rules": [{"match": "*.*.example.com", "rewrite": "${2}x.${1}"}]}`, ""},
+		{"empty", `{}`, "map or rules required"},
+		{"invalid JSON", `{invalid`, "invalid sni-rewrite config"},
+		{"empty map name", `{"map": {"a.example.com": ""}}`, "empty name"},
+		{"partial wildcard", `{"rules": [{"match": "play*.example.com", "rewrite": "x"}]}`, "whole label"},
+		{"missing rewrite", `{"rules": [{"match": "*.example.com"}]}`, "match and rewrite required"},
+		{"reference out of range", `{"rules": [{"match": "*.example.com", "rewrite": "$2.internal"}]}`, "refers to $2"},
+		{"reference zero", `{"rules": [{"match": "*.example.com", "rewrite": "$0"}]}`, "refers to $0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewSNIRewriteHandler(json.RawMessage(tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSNIRewriteHandler_OnConnect(t *testing.T) {
+	h, err := NewSNIRewriteHandler(json.RawMessage(`{
+		"map": {"lobby.example.com": "lobby.internal"},
+		"rules": [
+			{"match": "play.*.example.com", "rewrite": "$1.internal"},
+			{"match": "*.*.example.com", "rewrite": "${2}-${1}.internal"}
+		]
+	}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		sni, want string
+		rewritten bool
+	}{
+		{"lobby.example.com", "lobby.internal", true},
+		{"LOBBY.Example.com", "lobby.internal", true},
+		{"play.tenant.example.com", "tenant.internal", true},
+		{"eu.tenant.example.com", "tenant-eu.internal", true},
+		{"a.b.c.example.com", "a.b.c.example.com", false},
+		{"other.com", "other.com", false},
+	}
+	for _, tt := range tests {
+		hello := &ClientHello{SNI: tt.sni}
+		ctx := &Context{Hello: hello}
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("%s: action = %v", tt.sni, res.Action)
+		}
+		if ctx.Hello.SNI != tt.want {
+			t.Errorf("%s: SNI = %q, want %q", tt.sni, ctx.Hello.SNI, tt.want)
+		}
+		if got := ctx.GetString(OriginalSNIKey); (got == tt.sni) != tt.rewritten {
+			t.Errorf("%s: original SNI = %q, rewritten %v", tt.sni, got, tt.rewritten)
+		}
+		if hello.SNI != tt.sni {
+			t.Errorf("%s: parsed ClientHello modified to %q", tt.sni, hello.SNI)
+		}
+	}
+
+	// Non-QUIC flows pass through
+	if res := h.OnConnect(&Context{Protocol: "rtp"}); res.Action != Continue {
+		t.Errorf("non-QUIC action = %v", res.Action)
+	}
+}
+
+func TestSNIRewriteHandler_DropUnmatched(t *testing.T) {
+	h, err := NewSNIRewriteHandler(json.RawMessage(`{"map": {"a.example.com": "a.internal"}, "drop_unmatched": true}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res := h.OnConnect(&Context{Hello: &ClientHello{SNI: "a.internal"}}); res.Action != Drop {
+		t.Errorf("internal name reachable directly: action = %v", res.Action)
+	}
+	if res := h.OnConnect(&Context{Hello: &ClientHello{SNI: "a.example.com"}}); res.Action != Continue {
+		t.Errorf("mapped name action = %v", res.Action)
+	}
+}
+
+func TestSNIRewriteHandler_WithRouter(t *testing.T) {
+	rw, err := NewSNIRewriteHandler(json.RawMessage(`{"rules": [{"match": "play.*.example.com", "rewrite": "$1.internal"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	router, err := NewDynamicHandler(json.RawMessage(`{"routes": {"tenant.internal": "10.0.0.1:5520"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{Hello: &ClientHello{SNI: "play.tenant.example.com"}}
+	for _, h := range []Handler{rw, router} {
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("%s: action = %v, err %v", h.Name(), res.Action, res.Error)
+		}
+	}
+	if got := ctx.GetString("backend"); got != "10.0.0.1:5520" {
+		t.Errorf("backend = %q", got)
+	}
+}
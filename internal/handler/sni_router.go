@@ -1,38 +1,330 @@
 package handler
 
 import (
+	"cmp"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"sync/atomic"
+	"maps"
+	"slices"
+	"time"
+
+	"quic-relay/internal/logging"
 )
 
+var sniRouterLog = logging.ForHandler("sni-router")
+
 func init() {
 	Register("sni-router", NewDynamicHandler)
 }
 
-// route holds backends for a single SNI with its own round-robin counter.
+// route holds backends for a single SNI.
 type route struct {
-	backends []string
-	counter  atomic.Uint64
+	pool *backendPool // Default backends, nil if only schedules, regions or versions are configured
+
+	// Optional named backend sets (blue/green), one of them active
+	versions   map[string]*backendPool
+	active     string // Configured active version
+	versionKey string // Identifies the route's admin cutover
+
+	// Optional time-based schedules, first match wins
+	schedules []*schedule
+	location  *time.Location
+
+	// Optional per-region backends, chosen by client address
+	regions  []*routeRegion
+	steering *steering
+
+	failover *failover // Secondary backends used while the default ones fail, nil if none
+
+	honeypot bool // Connections go to the honeypot handler, never to a backend
+
+	maxDatagram int // Overrides the forwarder's max_datagram (0 = not set)
+
+	overrides *RouteOverrides // Set as OverridesKey on the route's connections, nil if none
+
+	tags map[string]string // Set as TagsKey on the route's connections, nil if none
+
+	upstream *upstream // Proxy the route's backends are reached through, nil if none
+
+	waiting *waitingRoom // Queues clients while all backends are full, nil if none
+}
+
+// pools lists every backend pool of the route.
+func (r *route) pools() []*backendPool {
+	var pools []*backendPool
+	if r.pool != nil {
+		pools = append(pools, r.pool)
+	}
+	if r.failover != nil {
+		pools = append(pools, r.failover.secondary)
+	}
+	for _, name := range slices.Sorted(maps.Keys(r.versions)) {
+		pools = append(pools, r.versions[name])
+	}
+	for _, s := range r.schedules {
+		if s.pool != nil {
+			pools = append(pools, s.pool)
+		}
+	}
+	for _, region := range r.regions {
+		pools = append(pools, region.pool)
+	}
+	return pools
+}
+
+// backends lists every backend the route may pick.
+func (r *route) backends() []string {
+	var addrs []string
+	for _, pool := range r.pools() {
+		addrs = append(addrs, pool.addrs...)
+	}
+	return addrs
+}
+
+// restore counts a restored session against the max_sessions of its backend,
+// without applying the limit: the session exists already.
+func (r *route) restore(ctx *Context) {
+	backend := ctx.GetString("backend")
+	for _, pool := range r.pools() {
+		if i := slices.Index(pool.addrs, backend); i >= 0 && pool.caps != nil && pool.caps[i] > 0 {
+			slot, _ := reserveBackend(backend, 0)
+			holdBackendSlot(ctx, slot)
+			return
+		}
+	}
+}
+
+// routeConfig is the object form of a route entry.
+type routeConfig struct {
+	Type      string           `json:"type,omitempty"` // "backend" (default) or "honeypot"
+	Backends  []backendEntry   `json:"backends,omitempty"`
+	Timezone  string           `json:"timezone,omitempty"` // IANA name, default: local time
+	Schedules []scheduleConfig `json:"schedules,omitempty"`
+
+	Regions     map[string][]backendEntry `json:"regions,omitempty"`
+	RegionOrder []string                  `json:"region_order,omitempty"` // Fallback order (default: sorted by name)
+
+	Versions map[string][]backendEntry `json:"versions,omitempty"` // Named backend sets, e.g. blue and green
+	Active   string                    `json:"active,omitempty"`   // Version receiving new connections
+
+	Secondaries []backendEntry  `json:"secondaries,omitempty"` // Used while the backends fail
+	Failover    *failoverConfig `json:"failover,omitempty"`
+
+	MaxDatagram int `json:"max_datagram,omitempty"` // Largest datagram forwarded for the route (default: forwarder's)
+
+	Overrides *RouteOverrides `json:"overrides,omitempty"` // Parameters of later handlers for the route's sessions
+
+	Tags map[string]string `json:"tags,omitempty"` // Attached to the route's sessions, metrics and logs
+
+	Upstream *UpstreamConfig `json:"upstream,omitempty"` // Proxy to reach the backends through
+
+	WaitingRoom *waitingRoomConfig `json:"waiting_room,omitempty"` // Queue clients while all backends are at max_sessions
+}
+
+// pick selects a backend for a connection at time now.
+// A matching schedule overrides the default backends; a blocking schedule refuses.
+// Otherwise a resumed backend that is still part of the route is kept, and
+// regions take precedence over the default backends.
+func (r *route) pick(ctx *Context, now time.Time) (string, error) {
+	if backend, ok := r.resume(ctx); ok {
+		return backend, nil
+	}
+	if len(r.schedules) > 0 {
+		if r.location != nil {
+			now = now.In(r.location)
+		}
+		for _, s := range r.schedules {
+			if !s.matches(now) {
+				continue
+			}
+			if s.block {
+				return "", errors.New("blocked by schedule")
+			}
+			return s.pool.pickFor(ctx)
+		}
+	}
+	if len(r.regions) > 0 {
+		return r.steering.pickRegion(ctx, r.regions)
+	}
+	if r.failover != nil {
+		return r.failover.pick(ctx)
+	}
+	pool := r.defaultPool()
+	if pool == nil {
+		return "", errors.New("outside scheduled hours")
+	}
+	return pool.pickFor(ctx)
 }
 
-// next returns the next backend using round-robin.
-func (r *route) next() string {
-	idx := r.counter.Add(1) - 1
-	return r.backends[idx%uint64(len(r.backends))]
+// resume returns the backend remembered by the resume handler when the route
+// would still send the client there and it is not full. Scheduled routes
+// always route again.
+func (r *route) resume(ctx *Context) (string, bool) {
+	backend := ctx.ResumedBackend()
+	if backend == "" || len(r.schedules) > 0 {
+		return "", false
+	}
+	if len(r.regions) > 0 {
+		return r.steering.resumeRegion(ctx, r.regions, backend)
+	}
+	if r.failover != nil {
+		return r.failover.resume(ctx, backend)
+	}
+	if pool := r.defaultPool(); pool != nil && pool.contains(backend) && pool.reserveAddr(ctx, backend) {
+		return backend, true
+	}
+	return "", false
+}
+
+// parseRouteObject parses the object form of a route entry.
+func parseRouteObject(v map[string]any) (*route, error) {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var cfg routeConfig
+	if err := json.Unmarshal(raw, &cfg); err != nil {
+		return nil, err
+	}
+
+	switch cfg.Type {
+	case "", "backend":
+	case "honeypot":
+		if len(v) > 1 {
+			return nil, errors.New("a honeypot route takes no other settings")
+		}
+		return &route{honeypot: true}, nil
+	default:
+		return nil, fmt.Errorf("unknown route type %q", cfg.Type)
+	}
+
+	r := &route{}
+	if len(cfg.Backends) > 0 {
+		if r.pool, err = newBackendPool(cfg.Backends); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Timezone != "" {
+		if r.location, err = time.LoadLocation(cfg.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone: %w", err)
+		}
+	}
+	for i, sc := range cfg.Schedules {
+		s, err := parseSchedule(sc)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %d: %w", i, err)
+		}
+		r.schedules = append(r.schedules, s)
+	}
+	if len(cfg.Regions) > 0 {
+		if r.regions, err = parseRegions(cfg.Regions, cfg.RegionOrder); err != nil {
+			return nil, err
+		}
+	}
+	if len(cfg.Versions) > 0 {
+		if r.pool != nil {
+			return nil, errors.New("use either 'backends' or 'versions'")
+		}
+		if r.versions, err = parseVersions(cfg.Versions, cfg.Active); err != nil {
+			return nil, err
+		}
+		r.active = cfg.Active
+	} else if cfg.Active != "" {
+		return nil, errors.New("'active' requires 'versions'")
+	}
+	if len(cfg.Secondaries) > 0 {
+		if r.pool == nil || len(r.regions) > 0 {
+			return nil, errors.New("'secondaries' requires 'backends' and cannot be combined with 'regions'")
+		}
+		if r.failover, err = newFailover(r.pool, cfg.Secondaries, cfg.Failover); err != nil {
+			return nil, fmt.Errorf("secondaries: %w", err)
+		}
+	} else if cfg.Failover != nil {
+		return nil, errors.New("'failover' requires 'secondaries'")
+	}
+	if r.pool == nil && r.versions == nil && len(r.schedules) == 0 && len(r.regions) == 0 {
+		return nil, errors.New("empty backends")
+	}
+	if err := validateMaxDatagram(cfg.MaxDatagram); err != nil {
+		return nil, err
+	}
+	r.maxDatagram = cfg.MaxDatagram
+	if o := cfg.Overrides; o != nil {
+		if err := o.validate(); err != nil {
+			return nil, err
+		}
+		if cfg.MaxDatagram > 0 && o.MaxDatagram > 0 {
+			return nil, errors.New("set either 'max_datagram' or 'overrides.max_datagram'")
+		}
+		r.maxDatagram = cmp.Or(r.maxDatagram, o.MaxDatagram)
+		r.overrides = o
+	}
+	if err := validateTags(cfg.Tags); err != nil {
+		return nil, err
+	}
+	if len(cfg.Tags) > 0 {
+		r.tags = cfg.Tags
+	}
+	if cfg.Upstream != nil {
+		if r.upstream, err = newUpstream(*cfg.Upstream); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.WaitingRoom != nil {
+		if r.freeSlots() == unlimitedSlots {
+			return nil, errors.New("waiting_room requires max_sessions on every backend")
+		}
+		if r.waiting, err = newWaitingRoom(*cfg.WaitingRoom); err != nil {
+			return nil, err
+		}
+	}
+	return r, nil
+}
+
+// parseRegions builds the region list of a route, ordered for fallback.
+func parseRegions(cfg map[string][]backendEntry, order []string) ([]*routeRegion, error) {
+	names := make([]string, 0, len(cfg))
+	for _, name := range order {
+		if _, ok := cfg[name]; !ok {
+			return nil, fmt.Errorf("region_order: unknown region %q", name)
+		}
+		if !slices.Contains(names, name) {
+			names = append(names, name)
+		}
+	}
+	rest := make([]string, 0, len(cfg))
+	for name := range cfg {
+		if !slices.Contains(names, name) {
+			rest = append(rest, name)
+		}
+	}
+	slices.Sort(rest)
+	names = append(names, rest...)
+
+	regions := make([]*routeRegion, 0, len(names))
+	for _, name := range names {
+		pool, err := newBackendPool(cfg[name])
+		if err != nil {
+			return nil, fmt.Errorf("region %s: %w", name, err)
+		}
+		regions = append(regions, &routeRegion{name: name, pool: pool})
+	}
+	return regions, nil
 }
 
 // DynamicHandler routes connections based on SNI to different backends.
 type DynamicHandler struct {
 	routes map[string]*route
+	now    func() time.Time // Clock for schedules (overridable in tests)
 }
 
 // NewDynamicHandler creates a new dynamic handler.
 func NewDynamicHandler(raw json.RawMessage) (Handler, error) {
 	// Parse as map[string]any to handle both string and []string values
 	var cfg struct {
-		Routes map[string]any `json:"routes"`
+		Routes   map[string]any  `json:"routes"`
+		Steering *steeringConfig `json:"steering,omitempty"`
 	}
 	if len(raw) > 0 {
 		if err := json.Unmarshal(raw, &cfg); err != nil {
@@ -43,10 +335,40 @@ func NewDynamicHandler(raw json.RawMessage) (Handler, error) {
 		return nil, fmt.Errorf("dynamic handler requires 'routes' config")
 	}
 
-	routes := make(map[string]*route, len(cfg.Routes))
-	for sni, val := range cfg.Routes {
+	routes, err := parseRoutes(cfg.Routes, "SNI")
+	if err != nil {
+		return nil, err
+	}
+	for sni, r := range routes {
+		if r.maxDatagram > 0 && r.maxDatagram < minQUICDatagram {
+			return nil, fmt.Errorf("invalid route for SNI %s: max_datagram must be at least %d for QUIC", sni, minQUICDatagram)
+		}
+	}
+	if err := applySteering(routes, cfg.Steering, "SNI"); err != nil {
+		return nil, err
+	}
+
+	return &DynamicHandler{routes: routes, now: time.Now}, nil
+}
+
+// parseRoutes parses a routes map whose values are a backend string, an array of
+// backends or a route object. label names the key kind in error messages.
+func parseRoutes(raw map[string]any, label string) (map[string]*route, error) {
+	routes := make(map[string]*route, len(raw))
+	for key, val := range raw {
 		var backends []string
 		switch v := val.(type) {
+		case map[string]any:
+			r, err := parseRouteObject(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid route for %s %s: %w", label, key, err)
+			}
+			r.versionKey = label + " " + key
+			if r.failover != nil {
+				r.failover.name = r.versionKey
+			}
+			routes[key] = r
+			continue
 		case string:
 			backends = []string{v}
 		case []any:
@@ -54,20 +376,20 @@ func NewDynamicHandler(raw json.RawMessage) (Handler, error) {
 			for i, b := range v {
 				s, ok := b.(string)
 				if !ok {
-					return nil, fmt.Errorf("invalid backend for SNI %s: expected string", sni)
+					return nil, fmt.Errorf("invalid backend for %s %s: expected string", label, key)
 				}
 				backends[i] = s
 			}
 		default:
-			return nil, fmt.Errorf("invalid backend for SNI %s: expected string or array", sni)
+			return nil, fmt.Errorf("invalid backend for %s %s: expected string or array of backends, or route object", label, key)
 		}
 		if len(backends) == 0 {
-			return nil, fmt.Errorf("empty backends for SNI %s", sni)
+			return nil, fmt.Errorf("empty backends for %s %s", label, key)
 		}
-		routes[sni] = &route{backends: backends}
+		routes[key] = &route{pool: poolFromStrings(backends)}
 	}
 
-	return &DynamicHandler{routes: routes}, nil
+	return routes, nil
 }
 
 // Name returns the handler name.
@@ -75,30 +397,112 @@ func (h *DynamicHandler) Name() string {
 	return "sni-router"
 }
 
+// Backends lists the backends of all routes.
+func (h *DynamicHandler) Backends() []string {
+	var addrs []string
+	for _, r := range h.routes {
+		addrs = append(addrs, r.backends()...)
+	}
+	return addrs
+}
+
+// SNIs lists the SNIs the handler has routes for, without the "*" route.
+func (h *DynamicHandler) SNIs() []string {
+	var snis []string
+	for _, sni := range slices.Sorted(maps.Keys(h.routes)) {
+		if sni != "*" {
+			snis = append(snis, sni)
+		}
+	}
+	return snis
+}
+
 // OnConnect sets the backend address based on SNI.
 func (h *DynamicHandler) OnConnect(ctx *Context) Result {
 	if ctx.Hello == nil {
 		return Result{Action: Drop, Error: errors.New("no ClientHello")}
 	}
 
-	sni := ctx.Hello.SNI
-	if sni == "" {
-		return Result{Action: Drop, Error: errors.New("no SNI")}
-	}
-
-	r, ok := h.routes[sni]
+	sni, r, ok := h.route(ctx.Hello.SNI)
 	if !ok {
+		if sni == "" {
+			return Result{Action: Drop, Error: errors.New("no SNI")}
+		}
 		return Result{Action: Drop, Error: fmt.Errorf("unknown SNI: %s", sni)}
 	}
+	if r.honeypot {
+		ctx.Set(HoneypotKey, sni)
+		return Result{Action: Continue}
+	}
+
+	backend, err := r.connect(ctx, h.now())
+	if err != nil {
+		return Result{Action: Drop, Error: fmt.Errorf("SNI %s: %w", sni, err)}
+	}
+	if r.steering != nil {
+		r.steering.observe(ctx)
+		logSteering(ctx, "sni", sni, backend)
+	}
+	observeFailover(ctx)
+	if r.maxDatagram > 0 {
+		ctx.Set(MaxDatagramKey, r.maxDatagram)
+	}
+	if r.overrides != nil {
+		ctx.Set(OverridesKey, r.overrides)
+	}
+	if r.tags != nil {
+		ctx.Set(TagsKey, r.tags)
+	}
+	if r.upstream != nil {
+		ctx.Set(UpstreamKey, r.upstream)
+	}
 
-	ctx.Set("backend", r.next())
+	ctx.Set("backend", backend)
 	return Result{Action: Continue}
 }
 
+// route returns the route of an SNI and its key. The "*" route catches
+// everything else, typically a honeypot.
+func (h *DynamicHandler) route(sni string) (string, *route, bool) {
+	if r, ok := h.routes[sni]; ok && sni != "" {
+		return sni, r, true
+	}
+	if r, ok := h.routes["*"]; ok {
+		return "*", r, true
+	}
+	return sni, nil, false
+}
+
 // OnPacket passes through.
 func (h *DynamicHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
 	return Result{Action: Continue}
 }
 
-// OnDisconnect does nothing.
-func (h *DynamicHandler) OnDisconnect(ctx *Context) {}
+// OnDisconnect reports the outcome of steered and failover sessions and
+// releases the session's backend slot.
+func (h *DynamicHandler) OnDisconnect(ctx *Context) {
+	releaseBackendSlot(ctx)
+	reportFailover(ctx)
+	if ctx.Hello == nil {
+		return
+	}
+	if _, r, ok := h.route(ctx.Hello.SNI); ok && r.steering != nil {
+		r.steering.report(ctx)
+	}
+}
+
+// CancelConnect releases the backend slot of a connection refused later in the chain.
+func (h *DynamicHandler) CancelConnect(ctx *Context) {
+	releaseBackendSlot(ctx)
+}
+
+// Restore counts a restored session against its backend's max_sessions.
+func (h *DynamicHandler) Restore(ctx *Context, id uint64) Result {
+	if ctx.Hello == nil {
+		return Result{Action: Continue}
+	}
+	if _, r, ok := h.route(ctx.Hello.SNI); ok {
+		r.restore(ctx)
+	}
+	return Result{Action: Continue}
+}
@@ -58,6 +58,35 @@ func TestNewDynamicHandler(t *testing.T) {
 			config:  `{"routes": {"x.com": ["ok", 123]}}`,
 			wantErr: "expected string",
 		},
+		{
+			name:    "route max_datagram",
+			config:  `{"routes": {"x.com": {"backends": ["b1:443"], "max_datagram": 1350}}}`,
+			wantErr: "",
+		},
+		{
+			name:    "route max_datagram below QUIC minimum",
+			config:  `{"routes": {"x.com": {"backends": ["b1:443"], "max_datagram": 1000}}}`,
+			wantErr: "at least 1200",
+		},
+		{
+			name:    "route max_datagram too large",
+			config:  `{"routes": {"x.com": {"backends": ["b1:443"], "max_datagram": 70000}}}`,
+			wantErr: "between 0 and",
+		},
+		{
+			name:   "honeypot route",
+			config: `{"routes": {"a.com": "backend:443", "*": {"type": "honeypot"}}}`,
+		},
+		{
+			name:    "honeypot route with backends",
+			config:  `{"routes": {"*": {"type": "honeypot", "backends": ["b:443"]}}}`,
+			wantErr: "takes no other settings",
+		},
+		{
+			name:    "unknown route type",
+			config:  `{"routes": {"x.com": {"type": "mirror", "backends": ["b:443"]}}}`,
+			wantErr: "unknown route type",
+		},
 	}
 
 	for _, tt := range tests {
@@ -96,12 +125,13 @@ func TestDynamicHandler_Name(t *testing.T) {
 
 func TestDynamicHandler_OnConnect(t *testing.T) {
 	tests := []struct {
-		name        string
-		config      string
-		hello       *ClientHello
-		wantAction  Action
-		wantErr     string
-		wantBackend string
+		name         string
+		config       string
+		hello        *ClientHello
+		wantAction   Action
+		wantErr      string
+		wantBackend  string
+		wantHoneypot string
 	}{
 		{
 			name:        "successful single backend",
@@ -131,6 +161,20 @@ func TestDynamicHandler_OnConnect(t *testing.T) {
 			wantAction: Drop,
 			wantErr:    "unknown SNI",
 		},
+		{
+			name:        "unknown SNI to catch-all route",
+			config:      `{"routes": {"example.com": "backend:443", "*": "fallback:443"}}`,
+			hello:       &ClientHello{SNI: "unknown.com"},
+			wantAction:  Continue,
+			wantBackend: "fallback:443",
+		},
+		{
+			name:         "empty SNI to honeypot",
+			config:       `{"routes": {"example.com": "backend:443", "*": {"type": "honeypot"}}}`,
+			hello:        &ClientHello{SNI: ""},
+			wantAction:   Continue,
+			wantHoneypot: "*",
+		},
 	}
 
 	for _, tt := range tests {
@@ -162,6 +206,9 @@ func TestDynamicHandler_OnConnect(t *testing.T) {
 					t.Errorf("expected backend %q, got %q", tt.wantBackend, backend)
 				}
 			}
+			if route := ctx.GetString(HoneypotKey); route != tt.wantHoneypot {
+				t.Errorf("expected honeypot route %q, got %q", tt.wantHoneypot, route)
+			}
 		})
 	}
 }
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"net"
+	"sync/atomic"
+)
+
+// Default socket buffer sizes. Kernel defaults (around 200 KB on Linux) drop
+// packets when bursts arrive faster than the read loop drains them.
+const (
+	DefaultListenerBuffer = 4 << 20 // Shared listener sockets carry all clients
+	DefaultBackendBuffer  = 1 << 20 // Per-session backend sockets
+)
+
+// SocketBufferConfig sets SO_RCVBUF and SO_SNDBUF of a UDP socket in bytes.
+// Zero uses the default; a negative value keeps the kernel default.
+type SocketBufferConfig struct {
+	Receive int `json:"receive,omitempty"`
+	Send    int `json:"send,omitempty"`
+}
+
+// WithDefault fills zero fields with size.
+func (c SocketBufferConfig) WithDefault(size int) SocketBufferConfig {
+	if c.Receive == 0 {
+		c.Receive = size
+	}
+	if c.Send == 0 {
+		c.Send = size
+	}
+	return c
+}
+
+// SocketBufferResult reports the sizes requested and the sizes the kernel applied.
+// Actual sizes are 0 when the platform cannot report them.
+type SocketBufferResult struct {
+	Receive, ActualReceive int
+	Send, ActualSend       int
+}
+
+// ReceiveClamped reports whether the kernel applied a smaller receive buffer than requested.
+func (r SocketBufferResult) ReceiveClamped() bool {
+	return r.Receive > 0 && r.ActualReceive > 0 && r.ActualReceive < r.Receive
+}
+
+// SendClamped reports whether the kernel applied a smaller send buffer than requested.
+func (r SocketBufferResult) SendClamped() bool {
+	return r.Send > 0 && r.ActualSend > 0 && r.ActualSend < r.Send
+}
+
+// minSocketBuffer is the smallest size tried when the kernel rejects a request.
+const minSocketBuffer = 64 << 10
+
+// ApplySocketBuffers sets the buffer sizes of conn and reads back what the kernel applied.
+// Linux reports twice the usable size (bookkeeping overhead included) and clamps
+// requests to net.core.rmem_max / wmem_max. macOS and the BSDs reject requests above
+// kern.ipc.maxsockbuf instead, so rejected sizes are halved until one is accepted.
+func ApplySocketBuffers(conn *net.UDPConn, cfg SocketBufferConfig) (SocketBufferResult, error) {
+	res := SocketBufferResult{Receive: cfg.Receive, Send: cfg.Send}
+	if cfg.Receive > 0 {
+		if err := setSocketBuffer(conn.SetReadBuffer, cfg.Receive); err != nil {
+			return res, err
+		}
+	}
+	if cfg.Send > 0 {
+		if err := setSocketBuffer(conn.SetWriteBuffer, cfg.Send); err != nil {
+			return res, err
+		}
+	}
+	res.ActualReceive, res.ActualSend = socketBufferSizes(conn)
+	return res, nil
+}
+
+// setSocketBuffer calls set with size, halving it on error down to minSocketBuffer.
+// The first error is returned when no size is accepted.
+func setSocketBuffer(set func(int) error, size int) error {
+	err := set(size)
+	for try := size / 2; err != nil && try >= minSocketBuffer; try /= 2 {
+		if set(try) == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// backendBuffers holds the buffer sizes of forwarder backend sockets.
+var backendBuffers atomic.Pointer[SocketBufferConfig]
+
+// backendClampWarned limits the clamping warning to once per configuration.
+var backendClampWarned atomic.Bool
+
+func init() {
+	ConfigureBackendSocketBuffers(SocketBufferConfig{})
+}
+
+// ConfigureBackendSocketBuffers sets the buffer sizes of backend sockets opened from now on.
+func ConfigureBackendSocketBuffers(cfg SocketBufferConfig) {
+	cfg = cfg.WithDefault(DefaultBackendBuffer)
+	backendBuffers.Store(&cfg)
+	backendClampWarned.Store(false)
+}
+
+// applyBackendBuffers sizes a new backend socket. Failures are logged, not fatal.
+func applyBackendBuffers(conn *net.UDPConn) {
+	res, err := ApplySocketBuffers(conn, *backendBuffers.Load())
+	if err != nil {
+		forwarderLog.Warnf("failed to set backend socket buffers: %v", err)
+		return
+	}
+	if (res.ReceiveClamped() || res.SendClamped()) && backendClampWarned.CompareAndSwap(false, true) {
+		forwarderLog.Warnf("backend socket buffers clamped by the kernel: receive %d (wanted %d), send %d (wanted %d); raise net.core.rmem_max / wmem_max",
+			res.ActualReceive, res.Receive, res.ActualSend, res.Send)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build windows || plan9
+
+package handler
+
+import "net"
+
+// socketBufferSizes is not supported on this platform; clamping goes undetected.
+func socketBufferSizes(conn *net.UDPConn) (receive, send int) {
+	return 0, 0
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"errors"
+	"net"
+	"runtime"
+	"slices"
+	"testing"
+)
+
+func TestSocketBufferConfig_WithDefault(t *testing.T) {
+	got := SocketBufferConfig{Receive: 1024, Send: -1}.WithDefault(4096)
+	if got.Receive != 1024 || got.Send != -1 {
+		t.Errorf("WithDefault = %+v, want explicit values kept", got)
+	}
+	got = SocketBufferConfig{}.WithDefault(4096)
+	if got.Receive != 4096 || got.Send != 4096 {
+		t.Errorf("WithDefault = %+v, want 4096", got)
+	}
+}
+
+func TestApplySocketBuffers(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	res, err := ApplySocketBuffers(conn, SocketBufferConfig{Receive: 64 << 10, Send: -1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if runtime.GOOS == "windows" || runtime.GOOS == "plan9" {
+		return
+	}
+	if res.ActualReceive < 64<<10 || res.ReceiveClamped() {
+		t.Errorf("receive = %d, want at least %d", res.ActualReceive, 64<<10)
+	}
+	if res.ActualSend == 0 || res.SendClamped() {
+		t.Errorf("send = %d, want kernel default reported and not clamped", res.ActualSend)
+	}
+
+	// Requests beyond the kernel limit are reported as clamped
+	res, err = ApplySocketBuffers(conn, SocketBufferConfig{Receive: 1 << 30})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.ActualReceive >= 1<<30 {
+		t.Skip("kernel accepted a 1 GB buffer")
+	}
+	if !res.ReceiveClamped() {
+		t.Errorf("receive %d not reported as clamped", res.ActualReceive)
+	}
+}
+
+func TestSetSocketBuffer_Halves(t *testing.T) {
+	var tried []int
+	set := func(size int) error {
+		tried = append(tried, size)
+		if size > 256<<10 {
+			return errors.New("no buffer space available")
+		}
+		return nil
+	}
+	if err := setSocketBuffer(set, 1<<20); err != nil {
+		t.Fatalf("setSocketBuffer: %v", err)
+	}
+	if want := []int{1 << 20, 512 << 10, 256 << 10}; !slices.Equal(tried, want) {
+		t.Errorf("tried %v, want %v", tried, want)
+	}
+
+	tried = nil
+	err := setSocketBuffer(func(size int) error { tried = append(tried, size); return errors.New("refused") }, 256<<10)
+	if err == nil {
+		t.Fatal("expected error when every size is rejected")
+	}
+	if tried[len(tried)-1] != minSocketBuffer {
+		t.Errorf("last try %d, want %d", tried[len(tried)-1], minSocketBuffer)
+	}
+}
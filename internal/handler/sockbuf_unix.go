@@ -0,0 +1,21 @@
+//go:build !windows && !plan9
+
+package handler
+
+import (
+	"net"
+	"syscall"
+)
+
+// socketBufferSizes reads SO_RCVBUF and SO_SNDBUF as reported by the kernel.
+func socketBufferSizes(conn *net.UDPConn) (receive, send int) {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return 0, 0
+	}
+	raw.Control(func(fd uintptr) {
+		receive, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+		send, _ = syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_SNDBUF)
+	})
+	return receive, send
+}
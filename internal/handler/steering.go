@@ -0,0 +1,338 @@
+package handler
+
+import (
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var steeringLog = logging.ForHandler("steering")
+
+// Context keys set by routers when a connection was steered.
+const (
+	// RegionKey holds the region whose backend was selected.
+	RegionKey = "region"
+	// SteeringKey holds how the region was chosen: "preferred", "fallback", "all_down",
+	// or "resumed" when the resume handler kept an earlier backend.
+	SteeringKey = "steering"
+
+	steeringStateKey = "steering.state"
+)
+
+// steeringConfig maps client addresses to preferred regions.
+type steeringConfig struct {
+	Regions       map[string][]string `json:"regions,omitempty"`        // Region -> client CIDRs
+	SubnetsFile   string              `json:"subnets_file,omitempty"`   // CSV of "cidr,region" lines (e.g. converted GeoIP data)
+	DefaultRegion string              `json:"default_region,omitempty"` // Region for clients matching no subnet
+	FailThreshold int                 `json:"fail_threshold,omitempty"` // Consecutive failed sessions before a region is skipped (default: 3)
+	Cooldown      int                 `json:"cooldown,omitempty"`       // Seconds a failed region is skipped (default: 30)
+}
+
+// steering resolves client addresses to regions by longest prefix match.
+type steering struct {
+	byBits        map[int]map[netip.Prefix]string
+	bits          []int // Prefix lengths present, longest first
+	defaultRegion string
+	failThreshold int32
+	cooldown      time.Duration
+	now           func() time.Time
+}
+
+// newSteering builds the client mapping from inline subnets and an optional file.
+func newSteering(cfg steeringConfig) (*steering, error) {
+	s := &steering{
+		byBits:        make(map[int]map[netip.Prefix]string),
+		defaultRegion: cfg.DefaultRegion,
+		failThreshold: int32(cfg.FailThreshold),
+		cooldown:      time.Duration(cfg.Cooldown) * time.Second,
+		now:           time.Now,
+	}
+	if s.failThreshold <= 0 {
+		s.failThreshold = 3
+	}
+	if s.cooldown <= 0 {
+		s.cooldown = 30 * time.Second
+	}
+
+	for region, cidrs := range cfg.Regions {
+		for _, c := range cidrs {
+			if err := s.add(c, region); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if cfg.SubnetsFile != "" {
+		f, err := os.Open(cfg.SubnetsFile)
+		if err != nil {
+			return nil, fmt.Errorf("subnets_file: %w", err)
+		}
+		defer f.Close()
+		if err := s.load(f); err != nil {
+			return nil, fmt.Errorf("subnets_file %s: %w", cfg.SubnetsFile, err)
+		}
+	}
+	if len(s.bits) == 0 && s.defaultRegion == "" {
+		return nil, errors.New("steering requires 'regions', 'subnets_file' or 'default_region'")
+	}
+	return s, nil
+}
+
+// parseClientPrefix parses a CIDR or bare IP. IPv4 prefixes are returned in
+// their IPv4-mapped IPv6 form, to be matched against mapClientAddr results.
+func parseClientPrefix(cidr string) (netip.Prefix, error) {
+	cidr = strings.TrimSpace(cidr)
+	var p netip.Prefix
+	if strings.Contains(cidr, "/") {
+		var err error
+		if p, err = netip.ParsePrefix(cidr); err != nil {
+			return p, fmt.Errorf("invalid subnet %q", cidr)
+		}
+	} else {
+		addr, err := netip.ParseAddr(cidr)
+		if err != nil {
+			return p, fmt.Errorf("invalid subnet %q", cidr)
+		}
+		p = netip.PrefixFrom(addr, addr.BitLen())
+	}
+	p = p.Masked()
+	if p.Addr().Is4() {
+		// Match IPv4 clients on dual-stack sockets too
+		p = netip.PrefixFrom(netip.AddrFrom16(p.Addr().As16()), p.Bits()+96)
+	}
+	return p, nil
+}
+
+// mapClientAddr returns addr in the form parseClientPrefix prefixes use.
+func mapClientAddr(addr netip.Addr) netip.Addr {
+	if addr.Is4() {
+		return netip.AddrFrom16(addr.As16())
+	}
+	return addr
+}
+
+// add maps a CIDR (or bare IP) to a region.
+func (s *steering) add(cidr, region string) error {
+	p, err := parseClientPrefix(cidr)
+	if err != nil {
+		return err
+	}
+
+	m, ok := s.byBits[p.Bits()]
+	if !ok {
+		m = make(map[netip.Prefix]string)
+		s.byBits[p.Bits()] = m
+		s.bits = append(s.bits, p.Bits())
+		slices.SortFunc(s.bits, func(a, b int) int { return b - a })
+	}
+	m[p] = region
+	return nil
+}
+
+// load reads "cidr,region" records. Lines starting with '#' and a
+// "network,..." header are skipped.
+func (s *steering) load(r io.Reader) error {
+	cr := csv.NewReader(r)
+	cr.Comment = '#'
+	cr.FieldsPerRecord = -1
+	cr.TrimLeadingSpace = true
+	for {
+		rec, err := cr.Read()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if len(rec) < 2 {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("line %d: expected 'cidr,region'", line)
+		}
+		if rec[0] == "network" {
+			continue
+		}
+		if err := s.add(rec[0], strings.TrimSpace(rec[1])); err != nil {
+			line, _ := cr.FieldPos(0)
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+	}
+}
+
+// lookup returns the preferred region for a client address.
+func (s *steering) lookup(addr netip.Addr) string {
+	addr = mapClientAddr(addr)
+	for _, bits := range s.bits {
+		p, err := addr.Prefix(bits)
+		if err != nil {
+			continue
+		}
+		if region, ok := s.byBits[bits][p]; ok {
+			return region
+		}
+	}
+	return s.defaultRegion
+}
+
+// routeRegion holds one region's backends of a route and its passive health.
+type routeRegion struct {
+	name      string
+	pool      *backendPool
+	failures  atomic.Int32
+	downUntil atomic.Int64 // Unix nanoseconds, 0 when healthy
+}
+
+func (rr *routeRegion) healthy(now time.Time) bool {
+	return now.UnixNano() >= rr.downUntil.Load()
+}
+
+// steeringState tracks whether a steered session got an answer from its backend.
+type steeringState struct {
+	region    *routeRegion
+	responded atomic.Bool
+}
+
+// pickRegion selects the client's preferred region, falling back to the other
+// regions in order while it is marked down or all its backends are full. The
+// decision is recorded on ctx.
+func (s *steering) pickRegion(ctx *Context, regions []*routeRegion) (string, error) {
+	preferred := ""
+	if client := ctx.OriginalClientAddr(); client != nil {
+		if addr, ok := netip.AddrFromSlice(client.IP); ok {
+			preferred = s.lookup(addr)
+		}
+	}
+
+	order := make([]*routeRegion, 0, len(regions))
+	for _, rr := range regions {
+		if rr.name == preferred {
+			order = append([]*routeRegion{rr}, order...)
+		} else {
+			order = append(order, rr)
+		}
+	}
+
+	// Healthy regions first, in order; when all are down, try them anyway
+	now := s.now()
+	for _, healthy := range []bool{true, false} {
+		for _, rr := range order {
+			if rr.healthy(now) != healthy {
+				continue
+			}
+			backend, err := rr.pool.pickFor(ctx)
+			if err != nil {
+				continue
+			}
+			decision := "all_down"
+			switch {
+			case !healthy:
+			case rr.name == preferred:
+				decision = "preferred"
+			default:
+				decision = "fallback"
+			}
+			ctx.Set(RegionKey, rr.name)
+			ctx.Set(SteeringKey, decision)
+			ctx.Set(steeringStateKey, &steeringState{region: rr})
+			return backend, nil
+		}
+	}
+	return "", errBackendsFull
+}
+
+// resumeRegion keeps a resumed backend when its region is healthy,
+// recording the decision on ctx like pickRegion.
+func (s *steering) resumeRegion(ctx *Context, regions []*routeRegion, backend string) (string, bool) {
+	now := s.now()
+	for _, rr := range regions {
+		if !rr.pool.contains(backend) || !rr.healthy(now) || !rr.pool.reserveAddr(ctx, backend) {
+			continue
+		}
+		ctx.Set(RegionKey, rr.name)
+		ctx.Set(SteeringKey, "resumed")
+		ctx.Set(steeringStateKey, &steeringState{region: rr})
+		return backend, true
+	}
+	return "", false
+}
+
+// observe watches backend responses of a steered session.
+// Called by routers in OnConnect after a backend was picked.
+func (s *steering) observe(ctx *Context) {
+	state, ok := GetValue[*steeringState](ctx, steeringStateKey)
+	if !ok {
+		return
+	}
+	next := ctx.OnServerPacket
+	ctx.OnServerPacket = func(packet []byte) {
+		state.responded.Store(true)
+		if next != nil {
+			next(packet)
+		}
+	}
+}
+
+// report updates region health when a steered session ends. Sessions that failed
+// on the backend side or timed out without hearing from the backend count as
+// failures; sessions ended by handlers or operators are ignored.
+func (s *steering) report(ctx *Context) {
+	state, ok := GetValue[*steeringState](ctx, steeringStateKey)
+	if !ok {
+		return
+	}
+	rr := state.region
+	reason := ctx.CloseReason()
+	switch {
+	case reason == CloseBackendError:
+	case reason == CloseIdle && !state.responded.Load():
+	case state.responded.Load():
+		rr.failures.Store(0)
+		return
+	default:
+		return
+	}
+	if rr.failures.Add(1) >= s.failThreshold {
+		rr.failures.Store(0)
+		rr.downUntil.Store(s.now().Add(s.cooldown).UnixNano())
+		steeringLog.Warnf("region %s down for %v after %d failed sessions", rr.name, s.cooldown, s.failThreshold)
+	}
+}
+
+// logSteering writes the steering decision of a connection.
+func logSteering(ctx *Context, label, key, backend string) {
+	region := ctx.GetString(RegionKey)
+	if region == "" {
+		return
+	}
+	sessionLog(ctx, steeringLog).Printf("client=%s %s=%s region=%s decision=%s backend=%s",
+		privacy.Addr(ctx.OriginalClientAddr()), label, key, region, ctx.GetString(SteeringKey), backend)
+}
+
+// applySteering attaches the steering config to routes with regions.
+func applySteering(routes map[string]*route, cfg *steeringConfig, label string) error {
+	var s *steering
+	if cfg != nil {
+		var err error
+		if s, err = newSteering(*cfg); err != nil {
+			return fmt.Errorf("invalid steering config: %w", err)
+		}
+	}
+	for key, r := range routes {
+		if len(r.regions) == 0 {
+			continue
+		}
+		if s == nil {
+			return fmt.Errorf("route for %s %s has regions but no 'steering' config", label, key)
+		}
+		r.steering = s
+	}
+	return nil
+}
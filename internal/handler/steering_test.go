@@ -0,0 +1,205 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSteeringLookup(t *testing.T) {
+	s, err := newSteering(steeringConfig{
+		Regions: map[string][]string{
+			"eu": {"192.0.2.0/24", "2001:db8:e::/48"},
+			"us": {"192.0.0.0/16", "198.51.100.7"},
+		},
+		DefaultRegion: "us",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{"192.0.2.10", "eu"},        // Longest prefix wins
+		{"192.0.3.10", "us"},        // Shorter prefix
+		{"::ffff:192.0.2.10", "eu"}, // Dual-stack socket
+		{"198.51.100.7", "us"},      // Bare IP
+		{"2001:db8:e::1", "eu"},     // IPv6
+		{"203.0.113.1", "us"},       // Default
+	}
+	for _, tt := range tests {
+		if got := s.lookup(netip.MustParseAddr(tt.addr)); got != tt.want {
+			t.Errorf("lookup(%s) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+func TestSteeringSubnetsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "subnets.csv")
+	data := "network,region\n# comment\n10.0.0.0/8,eu\n2001:db8::/32, ap\n"
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := newSteering(steeringConfig{SubnetsFile: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := s.lookup(netip.MustParseAddr("10.1.2.3")); got != "eu" {
+		t.Errorf("lookup = %q, want eu", got)
+	}
+	if got := s.lookup(netip.MustParseAddr("2001:db8::1")); got != "ap" {
+		t.Errorf("lookup = %q, want ap", got)
+	}
+
+	if err := os.WriteFile(path, []byte("10.0.0.0/8,eu\nbogus,us\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newSteering(steeringConfig{SubnetsFile: path}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Errorf("error = %v, want line 2", err)
+	}
+}
+
+func TestSteering_ConfigErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"regions without steering", `{"routes": {"a.com": {"regions": {"eu": ["e:1"]}}}}`, "no 'steering' config"},
+		{"empty steering", `{"routes": {"a.com": {"regions": {"eu": ["e:1"]}}}, "steering": {}}`, "invalid steering config"},
+		{"bad subnet", `{"routes": {"a.com": "b:1"}, "steering": {"regions": {"eu": ["nope"]}}}`, "invalid subnet"},
+		{"bad order", `{"routes": {"a.com": {"regions": {"eu": ["e:1"]}, "region_order": ["us"]}}, "steering": {"default_region": "eu"}}`, "unknown region"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewDynamicHandler(json.RawMessage(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestDynamicHandler_Steering(t *testing.T) {
+	config := `{
+		"routes": {"play.com": {
+			"regions": {"eu": ["eu:5520"], "us": ["us:5520"], "ap": ["ap:5520"]},
+			"region_order": ["us"]
+		}},
+		"steering": {"regions": {"eu": ["192.0.2.0/24"]}, "default_region": "ap", "fail_threshold": 2, "cooldown": 60}
+	}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	dh := h.(*DynamicHandler)
+	now := time.Date(2026, 10, 16, 12, 0, 0, 0, time.UTC)
+	dh.routes["play.com"].steering.now = func() time.Time { return now }
+
+	connect := func(ip net.IP) *Context {
+		t.Helper()
+		ctx := &Context{
+			ClientAddr: &net.UDPAddr{IP: ip, Port: 40000},
+			Hello:      &ClientHello{SNI: "play.com"},
+		}
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("OnConnect: %v", res.Error)
+		}
+		return ctx
+	}
+	eu := net.IPv4(192, 0, 2, 9)
+
+	ctx := connect(eu)
+	if got := ctx.GetString("backend"); got != "eu:5520" {
+		t.Errorf("backend = %q, want eu:5520", got)
+	}
+	if got := ctx.GetString(SteeringKey); got != "preferred" {
+		t.Errorf("decision = %q, want preferred", got)
+	}
+	if got := connect(net.IPv4(203, 0, 113, 1)).GetString("backend"); got != "ap:5520" {
+		t.Errorf("default region backend = %q, want ap:5520", got)
+	}
+
+	// A session that got answers resets failures
+	ctx.OnServerPacket([]byte{0xc0})
+	ctx.SetCloseReason(CloseIdle)
+	h.OnDisconnect(ctx)
+
+	// Two sessions without a backend answer mark eu down
+	for i := 0; i < 2; i++ {
+		ctx := connect(eu)
+		ctx.SetCloseReason(CloseIdle)
+		h.OnDisconnect(ctx)
+	}
+	ctx = connect(eu)
+	if got := ctx.GetString("backend"); got != "us:5520" {
+		t.Errorf("backend = %q, want us:5520 (region_order fallback)", got)
+	}
+	if got := ctx.GetString(SteeringKey); got != "fallback" || ctx.GetString(RegionKey) != "us" {
+		t.Errorf("decision = %q region = %q, want fallback/us", got, ctx.GetString(RegionKey))
+	}
+
+	// Handler drops don't count as failures
+	ctx.DropWithReason(CloseHandlerDrop)
+	h.OnDisconnect(ctx)
+
+	// After the cooldown eu is preferred again
+	now = now.Add(61 * time.Second)
+	if got := connect(eu).GetString("backend"); got != "eu:5520" {
+		t.Errorf("backend = %q, want eu:5520 after cooldown", got)
+	}
+}
+
+func TestDynamicHandler_SteeringRelayedClient(t *testing.T) {
+	config := `{
+		"routes": {"play.com": {"regions": {"eu": ["eu:5520"], "us": ["us:5520"]}}},
+		"steering": {"regions": {"eu": ["192.0.2.0/24"]}, "default_region": "us"}
+	}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	ctx := &Context{
+		ClientAddr: &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 5520},
+		Hello:      &ClientHello{SNI: "play.com"},
+		Hop:        &HopInfo{ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 1}},
+	}
+	h.OnConnect(ctx)
+	if got := ctx.GetString("backend"); got != "eu:5520" {
+		t.Errorf("backend = %q, want eu:5520 (original client)", got)
+	}
+}
+
+func TestDynamicHandler_SteeringCatchAll(t *testing.T) {
+	config := `{
+		"routes": {"*": {"regions": {"eu": ["eu:5520"], "us": ["us:5520"]}, "region_order": ["us"]}},
+		"steering": {"regions": {"eu": ["192.0.2.0/24"]}, "default_region": "us", "fail_threshold": 1, "cooldown": 60}
+	}`
+	h, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	connect := func() *Context {
+		ctx := &Context{
+			ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 9), Port: 40000},
+			Hello:      &ClientHello{SNI: "other.com"},
+		}
+		h.OnConnect(ctx)
+		return ctx
+	}
+
+	// Sessions of unmatched SNIs report their outcome to the "*" route
+	ctx := connect()
+	ctx.SetCloseReason(CloseIdle)
+	h.OnDisconnect(ctx)
+	if got := connect().GetString("backend"); got != "us:5520" {
+		t.Errorf("backend = %q, want us:5520 after eu failed", got)
+	}
+}
@@ -0,0 +1,550 @@
+package handler
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var tarpitLog = logging.For("tarpit")
+
+func init() {
+	Register("tarpit", NewTarpitHandler)
+}
+
+// Tarpit modes.
+const (
+	TarpitAck       = "ack"       // Acknowledge the client's Initial, never answer it
+	TarpitTerminate = "terminate" // Complete the handshake locally, then read at a trickle
+)
+
+// tarpitStateKey holds the *tarpitClient of a tarpitted connection.
+const tarpitStateKey = "_tarpit"
+
+// tarpitTick is how often acknowledgements and max_duration are checked.
+const tarpitTick = 250 * time.Millisecond
+
+// Flow control windows of tarpit connections in terminate mode. Clients can
+// send no more than this before waiting for the tarpit to read.
+const (
+	tarpitStreamWindow     = 512
+	tarpitConnectionWindow = 2048
+)
+
+// TarpitConfig is the configuration for the tarpit handler.
+type TarpitConfig struct {
+	Mode          string   `json:"mode,omitempty"`          // "ack" (default) or "terminate"
+	Networks      []string `json:"networks,omitempty"`      // Client CIDRs or IPs to tarpit
+	NetworksFile  string   `json:"networks_file,omitempty"` // File with one CIDR or IP per line
+	Deprioritized bool     `json:"deprioritized,omitempty"` // Also tarpit connections the reputation handler deprioritized
+
+	Interval    int `json:"interval,omitempty"`     // ack: seconds between acknowledgements (default: 2)
+	ReadRate    int `json:"read_rate,omitempty"`    // terminate: bytes per second read from each stream (default: 16)
+	MaxDuration int `json:"max_duration,omitempty"` // Seconds a client is held before it is released (default: 600)
+	MaxClients  int `json:"max_clients,omitempty"`  // Clients held at once; more are dropped (default: 1000)
+
+	Cert string `json:"cert,omitempty"` // terminate: TLS certificate (default: self-signed)
+	Key  string `json:"key,omitempty"`  // terminate: TLS private key
+}
+
+// TarpitStats are the tarpit's counters, served by its admin endpoint.
+type TarpitStats struct {
+	Mode      string `json:"mode"`
+	Active    int    `json:"active"`
+	Tarpitted uint64 `json:"tarpitted"`
+	Refused   uint64 `json:"refused"` // Dropped because max_clients were held
+	Acks      uint64 `json:"acks_sent,omitempty"`
+	Handshake uint64 `json:"handshakes,omitempty"` // Handshakes completed in terminate mode
+	Absorbed  uint64 `json:"bytes_absorbed"`       // Client bytes taken in without reaching a backend
+}
+
+// TarpitClient is the admin view of a held client.
+type TarpitClient struct {
+	Client string `json:"client"`
+	Since  string `json:"since"`
+	Held   int64  `json:"held_seconds"`
+}
+
+// tarpitClient is the state of one tarpitted connection.
+type tarpitClient struct {
+	ctx     *Context
+	since   time.Time
+	acking  bool         // Acknowledgements are sent (ack mode, QUIC clients)
+	lastAck atomic.Int64 // Unix nanoseconds
+}
+
+// TarpitHandler holds matching clients instead of dropping them: it keeps
+// them waiting at almost no cost to the relay and away from backends, so
+// they do not reconnect at once. Place it before routers.
+type TarpitHandler struct {
+	mode          string
+	networks      []netip.Prefix
+	deprioritized bool
+	interval      time.Duration
+	readRate      int
+	maxDuration   time.Duration
+	maxClients    int
+
+	fwd      *ForwarderHandler // terminate: carries client traffic to the listener
+	listener *quic.Listener    // terminate: completes handshakes
+	addr     string
+
+	sessionCounter atomic.Uint64
+
+	mu      sync.Mutex
+	clients map[*Context]*tarpitClient
+
+	tarpitted, refused, acks, handshakes, absorbed atomic.Uint64
+
+	stop      chan struct{}
+	closeOnce sync.Once
+}
+
+// NewTarpitHandler creates a new tarpit handler.
+func NewTarpitHandler(raw json.RawMessage) (Handler, error) {
+	var cfg TarpitConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid tarpit config: %w", err)
+		}
+	}
+	h, err := newTarpit(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tarpit config: %w", err)
+	}
+	if h.mode == TarpitTerminate {
+		if err := h.listen(cfg); err != nil {
+			return nil, fmt.Errorf("tarpit listener: %w", err)
+		}
+	}
+	go h.run()
+	return h, nil
+}
+
+func newTarpit(cfg TarpitConfig) (*TarpitHandler, error) {
+	if cfg.Mode == "" {
+		cfg.Mode = TarpitAck
+	}
+	if cfg.Mode != TarpitAck && cfg.Mode != TarpitTerminate {
+		return nil, fmt.Errorf("unknown mode %q (want %q or %q)", cfg.Mode, TarpitAck, TarpitTerminate)
+	}
+	if cfg.Interval < 0 || cfg.ReadRate < 0 || cfg.MaxDuration < 0 || cfg.MaxClients < 0 {
+		return nil, errors.New("'interval', 'read_rate', 'max_duration' and 'max_clients' must be >= 0")
+	}
+	if (cfg.Cert == "") != (cfg.Key == "") {
+		return nil, errors.New("'cert' and 'key' must be set together")
+	}
+	h := &TarpitHandler{
+		mode:          cfg.Mode,
+		deprioritized: cfg.Deprioritized,
+		interval:      time.Duration(cmp.Or(cfg.Interval, 2)) * time.Second,
+		readRate:      cmp.Or(cfg.ReadRate, 16),
+		maxDuration:   time.Duration(cmp.Or(cfg.MaxDuration, 600)) * time.Second,
+		maxClients:    cmp.Or(cfg.MaxClients, 1000),
+		clients:       make(map[*Context]*tarpitClient),
+		stop:          make(chan struct{}),
+	}
+	for _, cidr := range cfg.Networks {
+		p, err := parseClientPrefix(cidr)
+		if err != nil {
+			return nil, err
+		}
+		h.networks = append(h.networks, p)
+	}
+	if cfg.NetworksFile != "" {
+		if err := h.loadNetworks(cfg.NetworksFile); err != nil {
+			return nil, fmt.Errorf("networks_file: %w", err)
+		}
+	}
+	if len(h.networks) == 0 && !h.deprioritized {
+		return nil, errors.New("'networks', 'networks_file' or 'deprioritized' is required")
+	}
+	return h, nil
+}
+
+// loadNetworks reads one CIDR or IP per line. Blank lines and lines starting
+// with '#' are skipped.
+func (h *TarpitHandler) loadNetworks(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	for line := 1; sc.Scan(); line++ {
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		p, err := parseClientPrefix(text)
+		if err != nil {
+			return fmt.Errorf("line %d: %w", line, err)
+		}
+		h.networks = append(h.networks, p)
+	}
+	return sc.Err()
+}
+
+// listen starts the local QUIC server that completes tarpitted handshakes.
+func (h *TarpitHandler) listen(cfg TarpitConfig) error {
+	cert, err := tarpitCertificate(cfg.Cert, cfg.Key)
+	if err != nil {
+		return err
+	}
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// Accept whatever application protocol the client offers
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			return &tls.Config{Certificates: []tls.Certificate{cert}, NextProtos: hello.SupportedProtos}, nil
+		},
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsConf, &quic.Config{
+		MaxIdleTimeout:                 h.maxDuration,
+		KeepAlivePeriod:                10 * time.Second,
+		InitialStreamReceiveWindow:     tarpitStreamWindow,
+		MaxStreamReceiveWindow:         tarpitStreamWindow,
+		InitialConnectionReceiveWindow: tarpitConnectionWindow,
+		MaxConnectionReceiveWindow:     tarpitConnectionWindow,
+		MaxIncomingStreams:             4,
+		MaxIncomingUniStreams:          4,
+	})
+	if err != nil {
+		return err
+	}
+	fwd, _ := NewForwarderHandler(nil)
+	h.fwd = fwd.(*ForwarderHandler)
+	h.listener = ln
+	h.addr = ln.Addr().String()
+	go h.accept()
+	return nil
+}
+
+// tarpitCertificate loads the configured certificate or creates a self-signed one.
+func tarpitCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	if certFile != "" {
+		return tls.LoadX509KeyPair(certFile, keyFile)
+	}
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotAfter:     time.Now().AddDate(10, 0, 0),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}, nil
+}
+
+// accept completes handshakes until the listener is closed.
+func (h *TarpitHandler) accept() {
+	for {
+		conn, err := h.listener.Accept(context.Background())
+		if err != nil {
+			return
+		}
+		h.handshakes.Add(1)
+		go h.hold(conn)
+	}
+}
+
+// hold reads each stream of conn at read_rate bytes per second, so the
+// client's flow control window stays nearly closed.
+func (h *TarpitHandler) hold(conn *quic.Conn) {
+	ctx := conn.Context()
+	drain := func(r interface{ Read([]byte) (int, error) }) {
+		buf := make([]byte, h.readRate)
+		tick := time.NewTicker(time.Second)
+		defer tick.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-tick.C:
+			}
+			n, err := r.Read(buf)
+			h.absorbed.Add(uint64(n))
+			if err != nil {
+				return
+			}
+		}
+	}
+	go func() {
+		for {
+			s, err := conn.AcceptUniStream(ctx)
+			if err != nil {
+				return
+			}
+			go drain(s)
+		}
+	}()
+	for {
+		s, err := conn.AcceptStream(ctx)
+		if err != nil {
+			return
+		}
+		go drain(s)
+	}
+}
+
+// Name returns the handler name.
+func (h *TarpitHandler) Name() string {
+	return "tarpit"
+}
+
+// matches reports whether ctx's client is to be tarpitted.
+func (h *TarpitHandler) matches(ctx *Context) bool {
+	if h.deprioritized && Deprioritized(ctx) {
+		return true
+	}
+	client := ctx.OriginalClientAddr()
+	if client == nil || len(h.networks) == 0 {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(client.IP)
+	if !ok {
+		return false
+	}
+	addr = mapClientAddr(addr)
+	return slices.ContainsFunc(h.networks, func(p netip.Prefix) bool { return p.Contains(addr) })
+}
+
+// OnConnect takes over matching connections. Others continue down the chain.
+func (h *TarpitHandler) OnConnect(ctx *Context) Result {
+	if !h.matches(ctx) {
+		return Result{Action: Continue}
+	}
+	h.mu.Lock()
+	full := len(h.clients) >= h.maxClients
+	h.mu.Unlock()
+	if full {
+		h.refused.Add(1)
+		return Result{Action: Drop, Error: fmt.Errorf("tarpit full (%d clients)", h.maxClients)}
+	}
+
+	c := &tarpitClient{ctx: ctx, since: time.Now()}
+	var result Result
+	if h.mode == TarpitTerminate && ctx.Protocol == "" {
+		ctx.Set("backend", h.addr)
+		result = h.fwd.OnConnect(ctx)
+	} else {
+		result = h.openSession(ctx, c)
+	}
+	if result.Action != Handled {
+		return result
+	}
+	ctx.Set(tarpitStateKey, c)
+	h.mu.Lock()
+	h.clients[ctx] = c
+	h.mu.Unlock()
+	h.tarpitted.Add(1)
+	tarpitLog.Printf("session=%d %s tarpitted (%s)", ctx.Session.ID, privacy.Addr(ctx.OriginalClientAddr()), h.mode)
+	return Result{Action: Handled}
+}
+
+// openSession attaches a session without a backend and acknowledges the
+// client's Initial. Non-QUIC clients are only absorbed.
+func (h *TarpitHandler) openSession(ctx *Context, c *tarpitClient) Result {
+	now := time.Now()
+	session := &Session{ID: h.sessionCounter.Add(1), CreatedAt: now}
+	session.SetClientAddr(ctx.ClientAddr)
+	session.LastActivity.Store(now.Unix())
+	if n := len(ctx.InitialPacket); n > 0 {
+		session.CountIn(n)
+		h.absorbed.Add(uint64(n))
+	}
+	ctx.Session = session
+
+	if ctx.Protocol == "" && ctx.SendInitialAck != nil {
+		if err := h.ack(c); err != nil {
+			tarpitLog.Debugf("session=%d: cannot acknowledge Initial: %v", session.ID, err)
+		} else {
+			c.acking = true
+		}
+	}
+	ctx.InitialPacket = nil
+	return Result{Action: Handled}
+}
+
+// ack sends an acknowledgement of the client's Initial.
+func (h *TarpitHandler) ack(c *tarpitClient) error {
+	c.lastAck.Store(time.Now().UnixNano())
+	if err := c.ctx.SendInitialAck(); err != nil {
+		return err
+	}
+	h.acks.Add(1)
+	return nil
+}
+
+// OnPacket absorbs the packets of tarpitted sessions.
+func (h *TarpitHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	_, ok := GetValue[*tarpitClient](ctx, tarpitStateKey)
+	if !ok {
+		return Result{Action: Continue}
+	}
+	if ctx.Session == nil || ctx.Session.IsClosed() {
+		return Result{Action: Drop}
+	}
+	if h.fwd != nil && ctx.Session.BackendConn != nil {
+		return h.fwd.OnPacket(ctx, packet, dir)
+	}
+	ctx.Session.Touch()
+	if dir == Inbound {
+		ctx.Session.CountIn(len(packet))
+		h.absorbed.Add(uint64(len(packet)))
+	}
+	return Result{Action: Handled}
+}
+
+// OnDisconnect releases a tarpitted client.
+func (h *TarpitHandler) OnDisconnect(ctx *Context) {
+	c, ok := GetValue[*tarpitClient](ctx, tarpitStateKey)
+	if !ok {
+		return
+	}
+	h.mu.Lock()
+	_, held := h.clients[ctx]
+	delete(h.clients, ctx)
+	h.mu.Unlock()
+	if !held || ctx.Session == nil {
+		return
+	}
+	if ctx.Session.BackendConn != nil {
+		h.fwd.OnDisconnect(ctx)
+	} else {
+		ctx.Session.Close()
+	}
+	tarpitLog.Debugf("session=%d released after %v reason=%s", ctx.Session.ID, time.Since(c.since).Round(time.Second), ctx.CloseReason())
+}
+
+// run acknowledges held clients every interval and releases them after max_duration.
+func (h *TarpitHandler) run() {
+	tick := time.NewTicker(tarpitTick)
+	defer tick.Stop()
+	for {
+		select {
+		case <-h.stop:
+			return
+		case <-tick.C:
+		}
+		now := time.Now()
+		var expired, due []*tarpitClient
+		h.mu.Lock()
+		for _, c := range h.clients {
+			switch {
+			case now.Sub(c.since) >= h.maxDuration:
+				expired = append(expired, c)
+			case c.acking && now.UnixNano()-c.lastAck.Load() >= int64(h.interval):
+				due = append(due, c)
+			}
+		}
+		h.mu.Unlock()
+
+		for _, c := range due {
+			if err := h.ack(c); err != nil {
+				tarpitLog.Debugf("session=%d: acknowledgement failed: %v", c.ctx.Session.ID, err)
+			}
+		}
+		for _, c := range expired {
+			c.ctx.DropWithReason(CloseHandlerDrop)
+		}
+	}
+}
+
+// Stats returns the tarpit's counters.
+func (h *TarpitHandler) Stats() TarpitStats {
+	h.mu.Lock()
+	active := len(h.clients)
+	h.mu.Unlock()
+	return TarpitStats{
+		Mode:      h.mode,
+		Active:    active,
+		Tarpitted: h.tarpitted.Load(),
+		Refused:   h.refused.Load(),
+		Acks:      h.acks.Load(),
+		Handshake: h.handshakes.Load(),
+		Absorbed:  h.absorbed.Load(),
+	}
+}
+
+// Clients returns the held clients, longest held first.
+func (h *TarpitHandler) Clients() []TarpitClient {
+	now := time.Now()
+	h.mu.Lock()
+	held := make([]*tarpitClient, 0, len(h.clients))
+	for _, c := range h.clients {
+		held = append(held, c)
+	}
+	h.mu.Unlock()
+	slices.SortFunc(held, func(a, b *tarpitClient) int { return a.since.Compare(b.since) })
+	list := make([]TarpitClient, len(held))
+	for i, c := range held {
+		list[i] = TarpitClient{
+			Client: privacy.Addr(c.ctx.OriginalClientAddr()),
+			Since:  c.since.UTC().Format(time.RFC3339),
+			Held:   int64(now.Sub(c.since).Seconds()),
+		}
+	}
+	return list
+}
+
+// ServeAdmin serves GET / with the counters and held clients.
+func (h *TarpitHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if strings.TrimPrefix(r.URL.Path, "/") != "" {
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, map[string]any{
+		"stats":   h.Stats(),
+		"clients": h.Clients(),
+	})
+}
+
+// Close stops the tarpit and releases its clients. A reloaded chain
+// evaluates them again when they reconnect.
+func (h *TarpitHandler) Close() error {
+	h.closeOnce.Do(func() {
+		close(h.stop)
+		if h.listener != nil {
+			h.listener.Close()
+		}
+		h.mu.Lock()
+		held := make([]*Context, 0, len(h.clients))
+		for ctx := range h.clients {
+			held = append(held, ctx)
+		}
+		h.mu.Unlock()
+		for _, ctx := range held {
+			ctx.DropWithReason(CloseDrain)
+		}
+	})
+	return nil
+}
@@ -0,0 +1,181 @@
+package handler
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+)
+
+func TestNewTarpitHandler_Config(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "networks.txt")
+	os.WriteFile(file, []byte("# scanners\n203.0.113.0/24\n\n2001:db8::/32\n"), 0o644)
+	bad := filepath.Join(dir, "bad.txt")
+	os.WriteFile(bad, []byte("203.0.113.0/24\nnot-a-network\n"), 0o644)
+
+	tests := []struct {
+		name    string
+		cfg     string
+		wantErr string
+	}{
+		{"networks", `{"networks": ["198.51.100.7"]}`, ""},
+		{"networks file", `{"networks_file": "` + file + `"}`, ""},
+		{"deprioritized", `{"deprioritized": true}`, ""},
+		{"nothing to match", `{}`, "'networks', 'networks_file' or 'deprioritized' is required"},
+		{"unknown mode", `{"mode": "slow", "deprioritized": true}`, "unknown mode"},
+		{"negative interval", `{"interval": -1, "deprioritized": true}`, "must be >= 0"},
+		{"cert without key", `{"mode": "terminate", "cert": "c.pem", "deprioritized": true}`, "set together"},
+		{"bad network", `{"networks": ["10.0.0.300"]}`, "invalid subnet"},
+		{"bad networks file", `{"networks_file": "` + bad + `"}`, "line 2"},
+	}
+	for _, tt := range tests {
+		h, err := NewTarpitHandler(json.RawMessage(tt.cfg))
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%s: %v", tt.name, err)
+				continue
+			}
+			h.(*TarpitHandler).Close()
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestTarpit_Matches(t *testing.T) {
+	h, err := newTarpit(TarpitConfig{Networks: []string{"203.0.113.0/24", "2001:db8::/32"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		ip            string
+		deprioritized bool
+		want          bool
+	}{
+		{"203.0.113.9", false, true},
+		{"2001:db8::1", false, true},
+		{"198.51.100.7", false, false},
+		{"198.51.100.7", true, false},
+	}
+	for _, tt := range tests {
+		ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP(tt.ip), Port: 4433}}
+		ctx.Set(DeprioritizedKey, tt.deprioritized)
+		if got := h.matches(ctx); got != tt.want {
+			t.Errorf("matches(%s, deprioritized=%v) = %v, want %v", tt.ip, tt.deprioritized, got, tt.want)
+		}
+	}
+
+	h.deprioritized = true
+	ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.ParseIP("198.51.100.7"), Port: 4433}}
+	ctx.Set(DeprioritizedKey, true)
+	if !h.matches(ctx) {
+		t.Error("deprioritized connection not matched")
+	}
+}
+
+func TestTarpit_Ack(t *testing.T) {
+	h, err := newTarpit(TarpitConfig{Networks: []string{"203.0.113.0/24"}, Interval: 1, MaxClients: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 9), Port: 4433}
+	var acks atomic.Int32
+	ctx := &Context{ClientAddr: client, InitialPacket: make([]byte, 1200)}
+	ctx.SendInitialAck = func() error {
+		acks.Add(1)
+		return nil
+	}
+	dropped := make(chan struct{})
+	ctx.DropSession = func() {
+		h.OnDisconnect(ctx)
+		close(dropped)
+	}
+
+	if res := h.OnConnect(ctx); res.Action != Handled {
+		t.Fatalf("OnConnect = %v (%v), want Handled", res.Action, res.Error)
+	}
+	if ctx.Session == nil || ctx.InitialPacket != nil || acks.Load() != 1 {
+		t.Fatalf("session=%v initial=%d acks=%d after OnConnect", ctx.Session, len(ctx.InitialPacket), acks.Load())
+	}
+	if res := h.OnPacket(ctx, make([]byte, 1200), Inbound); res.Action != Handled {
+		t.Errorf("OnPacket = %v, want Handled", res.Action)
+	}
+	if res := h.OnPacket(&Context{}, []byte{0x40}, Inbound); res.Action != Continue {
+		t.Errorf("OnPacket of another session = %v, want Continue", res.Action)
+	}
+
+	// A second client does not fit
+	other := &Context{ClientAddr: &net.UDPAddr{IP: net.IPv4(203, 0, 113, 10), Port: 4433}}
+	if res := h.OnConnect(other); res.Action != Drop {
+		t.Errorf("OnConnect beyond max_clients = %v, want Drop", res.Action)
+	}
+
+	// Acknowledgements continue every interval
+	go h.run()
+	defer h.Close()
+	deadline := time.Now().Add(3 * time.Second)
+	for acks.Load() < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
+	if acks.Load() < 2 {
+		t.Fatal("no acknowledgement after the interval")
+	}
+
+	h.mu.Lock()
+	h.clients[ctx].since = time.Now().Add(-h.maxDuration)
+	h.mu.Unlock()
+	select {
+	case <-dropped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("client not released after max_duration")
+	}
+	st := h.Stats()
+	if st.Active != 0 || st.Tarpitted != 1 || st.Refused != 1 || st.Absorbed != 2400 || !ctx.Session.IsClosed() {
+		t.Errorf("stats = %+v, session closed = %v", st, ctx.Session.IsClosed())
+	}
+}
+
+func TestTarpit_Terminate(t *testing.T) {
+	raw, err := NewTarpitHandler(json.RawMessage(`{"mode": "terminate", "networks": ["127.0.0.1"], "read_rate": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := raw.(*TarpitHandler)
+	defer h.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, h.addr, &tls.Config{InsecureSkipVerify: true, NextProtos: []string{"hytale"}}, nil)
+	if err != nil {
+		t.Fatalf("handshake not completed: %v", err)
+	}
+	defer conn.CloseWithError(0, "")
+	if conn.ConnectionState().TLS.NegotiatedProtocol != "hytale" {
+		t.Errorf("ALPN = %q, want the client's", conn.ConnectionState().TLS.NegotiatedProtocol)
+	}
+
+	// The client can send little more than the stream window
+	stream, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stream.SetWriteDeadline(time.Now().Add(time.Second))
+	n, err := stream.Write(make([]byte, 64<<10))
+	if err == nil || n > 2*tarpitStreamWindow {
+		t.Errorf("wrote %d bytes (err %v), want to be throttled", n, err)
+	}
+	if h.Stats().Handshake != 1 {
+		t.Errorf("handshakes = %d, want 1", h.Stats().Handshake)
+	}
+}
@@ -0,0 +1,526 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+func init() {
+	Register("tenants", NewTenantsHandler)
+}
+
+// TenantKey is the context key holding the name of the tenant a connection
+// belongs to. Session listings, stats and forwarder logs are labelled with it.
+const TenantKey = "tenant"
+
+// tenantStateKey holds the *tenantState a connection counts against. It is
+// internal so shadow evaluation keeps it when reverting the tenant label.
+const tenantStateKey = "_tenant_state"
+
+// TenantsConfig is the configuration for the tenants handler.
+type TenantsConfig struct {
+	Tenants       map[string]TenantConfig `json:"tenants"`
+	Default       string                  `json:"default,omitempty"`        // Tenant for connections no tenant claims
+	DropUnmatched bool                    `json:"drop_unmatched,omitempty"` // Refuse connections no tenant claims
+}
+
+// TenantConfig groups names under a tenant with its own limits and handlers.
+type TenantConfig struct {
+	SNIs      []string `json:"snis,omitempty"`      // Exact names, or "*.example.com" for all subdomains
+	Protocols []string `json:"protocols,omitempty"` // Protocol rule names of non-QUIC flows
+
+	MaxConnections  int     `json:"max_connections,omitempty"`  // Concurrent sessions (0 = unlimited)
+	ConnectionRate  float64 `json:"connection_rate,omitempty"`  // New connections per second (0 = unlimited)
+	ConnectionBurst int     `json:"connection_burst,omitempty"` // Connections allowed at once (default: rate, at least 1)
+
+	Allow []string `json:"allow,omitempty"` // Client CIDRs or IPs allowed (default: all)
+	Deny  []string `json:"deny,omitempty"`  // Client CIDRs or IPs refused, checked before allow
+
+	Handlers []HandlerConfig `json:"handlers,omitempty"` // Run for this tenant before the rest of the chain
+}
+
+// TenantStats are the counters of one tenant.
+type TenantStats struct {
+	Active        int    `json:"active"`
+	Connections   uint64 `json:"connections"`
+	RejectedACL   uint64 `json:"rejected_acl"`
+	RejectedRate  uint64 `json:"rejected_rate"`
+	RejectedQuota uint64 `json:"rejected_quota"`
+
+	SessionCounters // Traffic of active and finished sessions
+}
+
+// tenantState is kept per tenant name across chain reloads, so active
+// sessions keep counting against the tenant's limits.
+type tenantState struct {
+	connections   atomic.Uint64
+	rejectedACL   atomic.Uint64
+	rejectedRate  atomic.Uint64
+	rejectedQuota atomic.Uint64
+
+	mu       sync.Mutex
+	active   map[*Context]struct{}
+	finished SessionCounters // Traffic of sessions that ended
+	tokens   float64         // Connection rate bucket
+	refilled time.Time       // Zero until the first connection
+}
+
+// tenantStates is package-level so counters survive handler chain reloads.
+var tenantStates = struct {
+	sync.Mutex
+	m map[string]*tenantState
+}{m: make(map[string]*tenantState)}
+
+// tenantStateFor returns the state of a tenant, creating it on first use.
+func tenantStateFor(name string) *tenantState {
+	tenantStates.Lock()
+	defer tenantStates.Unlock()
+	st := tenantStates.m[name]
+	if st == nil {
+		st = &tenantState{active: make(map[*Context]struct{})}
+		tenantStates.m[name] = st
+	}
+	return st
+}
+
+// release removes ctx from the active sessions and adds its traffic to the totals.
+// It is safe to call more than once.
+func (st *tenantState) release(ctx *Context) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if _, ok := st.active[ctx]; !ok {
+		return
+	}
+	delete(st.active, ctx)
+	if ctx.Session != nil {
+		st.finished = addCounters(st.finished, ctx.Session.Counters())
+	}
+}
+
+func (st *tenantState) stats() TenantStats {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	s := TenantStats{
+		Active:          len(st.active),
+		Connections:     st.connections.Load(),
+		RejectedACL:     st.rejectedACL.Load(),
+		RejectedRate:    st.rejectedRate.Load(),
+		RejectedQuota:   st.rejectedQuota.Load(),
+		SessionCounters: st.finished,
+	}
+	for ctx := range st.active {
+		if ctx.Session != nil {
+			s.SessionCounters = addCounters(s.SessionCounters, ctx.Session.Counters())
+		}
+	}
+	return s
+}
+
+func addCounters(a, b SessionCounters) SessionCounters {
+	return SessionCounters{
+		PacketsIn:  a.PacketsIn + b.PacketsIn,
+		PacketsOut: a.PacketsOut + b.PacketsOut,
+		BytesIn:    a.BytesIn + b.BytesIn,
+		BytesOut:   a.BytesOut + b.BytesOut,
+	}
+}
+
+// GetTenantStats returns the counters of every tenant seen since startup.
+func GetTenantStats() map[string]TenantStats {
+	tenantStates.Lock()
+	states := make(map[string]*tenantState, len(tenantStates.m))
+	for name, st := range tenantStates.m {
+		states[name] = st
+	}
+	tenantStates.Unlock()
+
+	stats := make(map[string]TenantStats, len(states))
+	for name, st := range states {
+		stats[name] = st.stats()
+	}
+	return stats
+}
+
+// tenant is a compiled tenant config.
+type tenant struct {
+	name           string
+	maxConnections int
+	rate, burst    float64
+	allow, deny    []netip.Prefix
+	chain          *Chain // Tenant handlers, nil if none
+	state          *tenantState
+}
+
+// admit checks the tenant's ACL and limits and counts ctx as active.
+func (t *tenant) admit(ctx *Context, now time.Time) error {
+	st := t.state
+	if !t.allowed(ctx) {
+		st.rejectedACL.Add(1)
+		return errors.New("client not allowed")
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if t.maxConnections > 0 && len(st.active) >= t.maxConnections {
+		st.rejectedQuota.Add(1)
+		return fmt.Errorf("connection limit of %d reached", t.maxConnections)
+	}
+	if t.rate > 0 {
+		if st.refilled.IsZero() {
+			st.tokens = t.burst
+		} else {
+			st.tokens = min(t.burst, st.tokens+now.Sub(st.refilled).Seconds()*t.rate)
+		}
+		st.refilled = now
+		if st.tokens < 1 {
+			st.rejectedRate.Add(1)
+			return errors.New("connection rate exceeded")
+		}
+		st.tokens--
+	}
+	st.active[ctx] = struct{}{}
+	st.connections.Add(1)
+	ctx.Set(tenantStateKey, st)
+	return nil
+}
+
+// releaseTenant frees the slot ctx holds, if any.
+func releaseTenant(ctx *Context) {
+	if st, ok := GetValue[*tenantState](ctx, tenantStateKey); ok {
+		st.release(ctx)
+	}
+}
+
+// allowed reports whether the client passes the tenant's deny and allow lists.
+func (t *tenant) allowed(ctx *Context) bool {
+	if len(t.allow) == 0 && len(t.deny) == 0 {
+		return true
+	}
+	client := ctx.OriginalClientAddr()
+	if client == nil {
+		return false
+	}
+	addr, ok := netip.AddrFromSlice(client.IP)
+	if !ok {
+		return false
+	}
+	addr = mapClientAddr(addr)
+	contains := func(p netip.Prefix) bool { return p.Contains(addr) }
+	if slices.ContainsFunc(t.deny, contains) {
+		return false
+	}
+	return len(t.allow) == 0 || slices.ContainsFunc(t.allow, contains)
+}
+
+// tenantSuffix maps a "*.example.com" pattern to its tenant.
+type tenantSuffix struct {
+	suffix string // ".example.com"
+	tenant *tenant
+}
+
+// TenantsHandler assigns connections to tenants by SNI or protocol and applies
+// per-tenant ACLs, limits and handlers. Place it before the router.
+type TenantsHandler struct {
+	byName        map[string]*tenant
+	exact         map[string]*tenant
+	suffixes      []tenantSuffix // Longest first
+	protocols     map[string]*tenant
+	fallback      *tenant
+	dropUnmatched bool
+	now           func() time.Time
+}
+
+// NewTenantsHandler creates a new tenants handler.
+func NewTenantsHandler(raw json.RawMessage) (Handler, error) {
+	var cfg TenantsConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid tenants config: %w", err)
+		}
+	}
+	if len(cfg.Tenants) == 0 {
+		return nil, errors.New("tenants requires at least one tenant")
+	}
+
+	h := &TenantsHandler{
+		byName:        make(map[string]*tenant),
+		exact:         make(map[string]*tenant),
+		protocols:     make(map[string]*tenant),
+		dropUnmatched: cfg.DropUnmatched,
+		now:           time.Now,
+	}
+	// Close the chains built so far if a later tenant is invalid
+	ok := false
+	defer func() {
+		if !ok {
+			h.Close()
+		}
+	}()
+
+	names := make([]string, 0, len(cfg.Tenants))
+	for name := range cfg.Tenants {
+		names = append(names, name)
+	}
+	slices.Sort(names) // Deterministic errors for names claimed twice
+	for _, name := range names {
+		t, err := newTenant(name, cfg.Tenants[name])
+		if err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", name, err)
+		}
+		h.byName[name] = t
+		if err := h.claim(t, cfg.Tenants[name]); err != nil {
+			return nil, fmt.Errorf("tenant %q: %w", name, err)
+		}
+	}
+	slices.SortFunc(h.suffixes, func(a, b tenantSuffix) int { return len(b.suffix) - len(a.suffix) })
+
+	if cfg.Default != "" {
+		if h.fallback = h.byName[cfg.Default]; h.fallback == nil {
+			return nil, fmt.Errorf("default tenant %q is not defined", cfg.Default)
+		}
+	}
+	ok = true
+	return h, nil
+}
+
+// newTenant compiles a tenant config.
+func newTenant(name string, cfg TenantConfig) (*tenant, error) {
+	if name == "" {
+		return nil, errors.New("empty tenant name")
+	}
+	if cfg.MaxConnections < 0 || cfg.ConnectionRate < 0 || cfg.ConnectionBurst < 0 {
+		return nil, errors.New("limits must be >= 0")
+	}
+	t := &tenant{
+		name:           name,
+		maxConnections: cfg.MaxConnections,
+		rate:           cfg.ConnectionRate,
+		burst:          float64(cfg.ConnectionBurst),
+	}
+	if t.rate > 0 && t.burst == 0 {
+		t.burst = max(1, math.Ceil(t.rate))
+	}
+	for _, list := range []struct {
+		in  []string
+		out *[]netip.Prefix
+	}{{cfg.Allow, &t.allow}, {cfg.Deny, &t.deny}} {
+		for _, cidr := range list.in {
+			p, err := parseClientPrefix(cidr)
+			if err != nil {
+				return nil, err
+			}
+			*list.out = append(*list.out, p)
+		}
+	}
+	if len(cfg.Handlers) > 0 {
+		chain, err := BuildChain(cfg.Handlers)
+		if err != nil {
+			return nil, err
+		}
+		t.chain = chain
+	}
+	t.state = tenantStateFor(name)
+	return t, nil
+}
+
+// claim registers the names and protocols of t, refusing ones another tenant claimed.
+func (h *TenantsHandler) claim(t *tenant, cfg TenantConfig) error {
+	for _, sni := range cfg.SNIs {
+		sni = strings.ToLower(strings.TrimSpace(sni))
+		if suffix, ok := strings.CutPrefix(sni, "*."); ok {
+			if suffix == "" || strings.Contains(suffix, "*") {
+				return fmt.Errorf("invalid SNI pattern %q", sni)
+			}
+			for _, s := range h.suffixes {
+				if s.suffix == "."+suffix {
+					return fmt.Errorf("SNI %q already belongs to tenant %q", sni, s.tenant.name)
+				}
+			}
+			h.suffixes = append(h.suffixes, tenantSuffix{suffix: "." + suffix, tenant: t})
+			continue
+		}
+		if sni == "" || strings.Contains(sni, "*") {
+			return fmt.Errorf("invalid SNI %q", sni)
+		}
+		if other, ok := h.exact[sni]; ok {
+			return fmt.Errorf("SNI %q already belongs to tenant %q", sni, other.name)
+		}
+		h.exact[sni] = t
+	}
+	for _, proto := range cfg.Protocols {
+		if other, ok := h.protocols[proto]; ok {
+			return fmt.Errorf("protocol %q already belongs to tenant %q", proto, other.name)
+		}
+		h.protocols[proto] = t
+	}
+	return nil
+}
+
+// Name returns the handler name.
+func (h *TenantsHandler) Name() string { return "tenants" }
+
+// Backends lists the backends of the tenants' own handlers.
+func (h *TenantsHandler) Backends() []string {
+	var addrs []string
+	for _, t := range h.byName {
+		if t.chain != nil {
+			for b := range t.chain.Backends() {
+				addrs = append(addrs, b)
+			}
+		}
+	}
+	return addrs
+}
+
+// match returns the tenant claiming the connection, or the default tenant.
+func (h *TenantsHandler) match(ctx *Context) *tenant {
+	switch {
+	case ctx.Protocol != "":
+		if t, ok := h.protocols[ctx.Protocol]; ok {
+			return t
+		}
+	case ctx.Hello != nil && ctx.Hello.SNI != "":
+		sni := strings.ToLower(ctx.Hello.SNI)
+		if t, ok := h.exact[sni]; ok {
+			return t
+		}
+		for _, s := range h.suffixes {
+			if strings.HasSuffix(sni, s.suffix) {
+				return s.tenant
+			}
+		}
+	}
+	return h.fallback
+}
+
+// tenantOf returns the tenant ctx was assigned to, if it is still configured.
+func (h *TenantsHandler) tenantOf(ctx *Context) *tenant {
+	name := ctx.GetString(TenantKey)
+	if name == "" {
+		return nil
+	}
+	return h.byName[name]
+}
+
+// OnConnect assigns the connection to a tenant, applies its ACL and limits,
+// and runs the tenant's handlers.
+func (h *TenantsHandler) OnConnect(ctx *Context) Result {
+	t := h.match(ctx)
+	if t == nil {
+		if h.dropUnmatched {
+			return Result{Action: Drop, Error: errors.New("no tenant for connection")}
+		}
+		return Result{Action: Continue}
+	}
+	ctx.Set(TenantKey, t.name)
+	if err := t.admit(ctx, h.now()); err != nil {
+		return Result{Action: Drop, Error: fmt.Errorf("tenant %s: %w", t.name, err)}
+	}
+	if t.chain == nil {
+		return Result{Action: Continue}
+	}
+	return t.chain.connect(ctx, 0, func(ctx *Context, result Result, i int) Result {
+		if result.Action == Drop {
+			t.chain.cancelConnect(ctx, i)
+			t.state.release(ctx)
+		}
+		return result
+	})
+}
+
+// CancelConnect releases the tenant slot of a connection refused later in the chain.
+func (h *TenantsHandler) CancelConnect(ctx *Context) {
+	if t := h.tenantOf(ctx); t != nil && t.chain != nil {
+		t.chain.cancelConnect(ctx, len(t.chain.handlers))
+	}
+	releaseTenant(ctx)
+}
+
+// OnPacket runs the tenant's handlers.
+func (h *TenantsHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	if t := h.tenantOf(ctx); t != nil && t.chain != nil {
+		return t.chain.packet(ctx, packet, dir)
+	}
+	return Result{Action: Continue}
+}
+
+// OnDisconnect runs the tenant's handlers and releases the session's slot.
+func (h *TenantsHandler) OnDisconnect(ctx *Context) {
+	if t := h.tenantOf(ctx); t != nil && t.chain != nil {
+		t.chain.OnDisconnect(ctx)
+	}
+	releaseTenant(ctx)
+}
+
+// Restore counts a restored session against its tenant without applying
+// limits, then offers it to the tenant's handlers.
+func (h *TenantsHandler) Restore(ctx *Context, id uint64) Result {
+	t := h.tenantOf(ctx)
+	if t == nil {
+		if t = h.match(ctx); t == nil {
+			return Result{Action: Continue}
+		}
+		ctx.Set(TenantKey, t.name)
+	}
+	t.state.mu.Lock()
+	t.state.active[ctx] = struct{}{}
+	t.state.mu.Unlock()
+	ctx.Set(tenantStateKey, t.state)
+	if t.chain == nil {
+		return Result{Action: Continue}
+	}
+	for i, th := range t.chain.handlers {
+		if r, ok := th.(Restorer); ok {
+			if result := r.Restore(ctx, id); result.Action != Continue {
+				if result.Action == Drop {
+					t.chain.cancelConnect(ctx, i)
+					t.state.release(ctx)
+				}
+				return result
+			}
+		}
+	}
+	return Result{Action: Continue}
+}
+
+// Close releases the resources of the tenants' handlers.
+func (h *TenantsHandler) Close() error {
+	for _, t := range h.byName {
+		if t.chain != nil {
+			t.chain.Close()
+		}
+	}
+	return nil
+}
+
+// ServeAdmin reports per-tenant counters.
+//
+//	GET /         all tenants
+//	GET /<name>   one tenant
+func (h *TenantsHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	}
+	stats := GetTenantStats()
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if name == "" {
+		writeAdminJSON(w, http.StatusOK, stats)
+		return
+	}
+	st, ok := stats[name]
+	if !ok {
+		writeAdminError(w, http.StatusNotFound, "unknown tenant")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, st)
+}
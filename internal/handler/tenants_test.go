@@ -0,0 +1,256 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestTenants(t *testing.T, config string) *TenantsHandler {
+	t.Helper()
+	h, err := NewTenantsHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { h.(*TenantsHandler).Close() })
+	return h.(*TenantsHandler)
+}
+
+func tenantCtx(sni, ip string) *Context {
+	return &Context{Hello: &ClientHello{SNI: sni}, ClientAddr: &net.UDPAddr{IP: net.ParseIP(ip), Port: 50000}}
+}
+
+func TestNewTenantsHandler(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr string
+	}{
+		{"valid", `{"tenants": {"cfg-a": {"snis": ["a.example.com", "*.a.example.com"], "max_connections": 10}}}`, ""},
+		{"empty", `{}`, "at least one tenant"},
+		{"invalid JSON", `{invalid`, "invalid tenants config"},
+		{"name claimed twice", `{"tenants": {"cfg-a": {"snis": ["x.com"]}, "cfg-b": {"snis": ["X.com"]}}}`, `already belongs to tenant "cfg-a"`},
+		{"pattern claimed twice", `{"tenants": {"cfg-a": {"snis": ["*.x.com"]}, "cfg-b": {"snis": ["*.x.com"]}}}`, "already belongs"},
+		{"protocol claimed twice", `{"tenants": {"cfg-a": {"protocols": ["rtp"]}, "cfg-b": {"protocols": ["rtp"]}}}`, "already belongs"},
+		{"bad pattern", `{"tenants": {"cfg-a": {"snis": ["a*.x.com"]}}}`, "invalid SNI"},
+		{"bad CIDR", `{"tenants": {"cfg-a": {"allow": ["10.0.0.0/99"]}}}`, "invalid subnet"},
+		{"negative limit", `{"tenants": {"cfg-a": {"max_connections": -1}}}`, "must be >= 0"},
+		{"unknown default", `{"tenants": {"cfg-a": {}}, "default": "nope"}`, `default tenant "nope"`},
+		{"bad handler", `{"tenants": {"cfg-a": {"handlers": [{"type": "nope"}]}}}`, "unknown handler type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h, err := NewTenantsHandler(json.RawMessage(tt.config))
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				h.(*TenantsHandler).Close()
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTenantsHandler_Match(t *testing.T) {
+	h := newTestTenants(t, `{
+		"tenants": {
+			"match-a": {"snis": ["a.example.com", "*.example.com"]},
+			"match-b": {"snis": ["*.b.example.com"], "protocols": ["rtp"]},
+			"match-public": {}
+		},
+		"default": "match-public"
+	}`)
+
+	tests := []struct {
+		ctx  *Context
+		want string
+	}{
+		{tenantCtx("a.example.com", "192.0.2.1"), "match-a"},
+		{tenantCtx("x.example.com", "192.0.2.1"), "match-a"},
+		{tenantCtx("play.B.example.com", "192.0.2.1"), "match-b"},
+		{tenantCtx("example.com", "192.0.2.1"), "match-public"},
+		{tenantCtx("other.org", "192.0.2.1"), "match-public"},
+		{&Context{Protocol: "rtp", ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}}, "match-b"},
+	}
+	for _, tt := range tests {
+		if res := h.OnConnect(tt.ctx); res.Action != Continue {
+			t.Fatalf("action = %v, err %v", res.Action, res.Error)
+		}
+		if got := tt.ctx.GetString(TenantKey); got != tt.want {
+			t.Errorf("tenant of %+v = %q, want %q", tt.ctx.Hello, got, tt.want)
+		}
+		h.OnDisconnect(tt.ctx)
+	}
+}
+
+func TestTenantsHandler_Unmatched(t *testing.T) {
+	h := newTestTenants(t, `{"tenants": {"unmatched-a": {"snis": ["a.example.com"]}}}`)
+	ctx := tenantCtx("other.org", "192.0.2.1")
+	if res := h.OnConnect(ctx); res.Action != Continue || ctx.GetString(TenantKey) != "" {
+		t.Errorf("unmatched: action = %v, tenant %q", res.Action, ctx.GetString(TenantKey))
+	}
+
+	h = newTestTenants(t, `{"tenants": {"unmatched-a": {"snis": ["a.example.com"]}}, "drop_unmatched": true}`)
+	if res := h.OnConnect(tenantCtx("other.org", "192.0.2.1")); res.Action != Drop {
+		t.Errorf("drop_unmatched: action = %v", res.Action)
+	}
+}
+
+func TestTenantsHandler_ACL(t *testing.T) {
+	h := newTestTenants(t, `{"tenants": {"acl-a": {
+		"snis": ["a.example.com"],
+		"allow": ["10.0.0.0/8", "2001:db8::/32"],
+		"deny": ["10.9.0.0/16"]
+	}}}`)
+	tests := []struct {
+		ip   string
+		want Action
+	}{
+		{"10.1.2.3", Continue},
+		{"::ffff:10.1.2.3", Continue},
+		{"2001:db8::1", Continue},
+		{"10.9.0.1", Drop},
+		{"192.0.2.1", Drop},
+	}
+	for _, tt := range tests {
+		ctx := tenantCtx("a.example.com", tt.ip)
+		if res := h.OnConnect(ctx); res.Action != tt.want {
+			t.Errorf("%s: action = %v, want %v", tt.ip, res.Action, tt.want)
+		}
+		h.OnDisconnect(ctx)
+	}
+	if st := GetTenantStats()["acl-a"]; st.RejectedACL != 2 || st.Connections != 3 || st.Active != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestTenantsHandler_MaxConnections(t *testing.T) {
+	h := newTestTenants(t, `{"tenants": {"quota-a": {"snis": ["a.example.com"], "max_connections": 2}}}`)
+	first, second := tenantCtx("a.example.com", "192.0.2.1"), tenantCtx("a.example.com", "192.0.2.2")
+	for _, ctx := range []*Context{first, second} {
+		if res := h.OnConnect(ctx); res.Action != Continue {
+			t.Fatalf("action = %v, err %v", res.Action, res.Error)
+		}
+	}
+	res := h.OnConnect(tenantCtx("a.example.com", "192.0.2.3"))
+	if res.Action != Drop || !strings.Contains(res.Error.Error(), "tenant quota-a: connection limit") {
+		t.Fatalf("over quota: action = %v, err %v", res.Action, res.Error)
+	}
+
+	// Disconnecting twice frees one slot only
+	h.OnDisconnect(first)
+	h.OnDisconnect(first)
+	if res := h.OnConnect(tenantCtx("a.example.com", "192.0.2.3")); res.Action != Continue {
+		t.Errorf("after disconnect: action = %v", res.Action)
+	}
+	if res := h.OnConnect(tenantCtx("a.example.com", "192.0.2.4")); res.Action != Drop {
+		t.Errorf("slot freed twice: action = %v", res.Action)
+	}
+	if st := GetTenantStats()["quota-a"]; st.Active != 2 || st.RejectedQuota != 2 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestTenantsHandler_CancelledByChain(t *testing.T) {
+	h := newTestTenants(t, `{"tenants": {"cancel-a": {"snis": ["a.example.com"], "max_connections": 1}}}`)
+	router, err := NewDynamicHandler(json.RawMessage(`{"routes": {"b.example.com": "10.0.0.1:5520"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain := NewChain(h, router)
+
+	// The router refuses the unknown name; the tenant slot must be released
+	for range 3 {
+		if res := chain.OnConnect(tenantCtx("a.example.com", "192.0.2.1")); res.Action != Drop {
+			t.Fatalf("action = %v", res.Action)
+		}
+	}
+	if st := GetTenantStats()["cancel-a"]; st.Active != 0 || st.RejectedQuota != 0 {
+		t.Errorf("stats = %+v, want no leaked slots", st)
+	}
+}
+
+func TestTenantsHandler_ConnectionRate(t *testing.T) {
+	h := newTestTenants(t, `{"tenants": {"rate-a": {"snis": ["a.example.com"], "connection_rate": 2, "connection_burst": 2}}}`)
+	now := time.Unix(1000, 0)
+	h.now = func() time.Time { return now }
+
+	connect := func() Action {
+		ctx := tenantCtx("a.example.com", "192.0.2.1")
+		res := h.OnConnect(ctx)
+		h.OnDisconnect(ctx)
+		return res.Action
+	}
+	if connect() != Continue || connect() != Continue {
+		t.Fatal("burst refused")
+	}
+	if connect() != Drop {
+		t.Error("third connection within the burst allowed")
+	}
+	now = now.Add(500 * time.Millisecond)
+	if connect() != Continue {
+		t.Error("connection refused after refill")
+	}
+	if st := GetTenantStats()["rate-a"]; st.RejectedRate != 1 || st.Connections != 3 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestTenantsHandler_Handlers(t *testing.T) {
+	h := newTestTenants(t, `{"tenants": {"handlers-a": {
+		"snis": ["*.a.example.com"],
+		"handlers": [
+			{"type": "sni-rewrite", "config": {"rules": [{"match": "*.a.example.com", "rewrite": "$1.internal"}]}},
+			{"type": "sni-router", "config": {"routes": {"play.internal": "10.0.0.1:5520"}}}
+		]
+	}}}`)
+
+	ctx := tenantCtx("play.a.example.com", "192.0.2.1")
+	if res := h.OnConnect(ctx); res.Action != Continue {
+		t.Fatalf("action = %v, err %v", res.Action, res.Error)
+	}
+	if got := ctx.GetString("backend"); got != "10.0.0.1:5520" {
+		t.Errorf("backend = %q", got)
+	}
+	h.OnDisconnect(ctx)
+
+	// A tenant handler refusing the connection releases the slot
+	res := h.OnConnect(tenantCtx("lobby.a.example.com", "192.0.2.1"))
+	if res.Action != Drop {
+		t.Fatalf("unknown route: action = %v", res.Action)
+	}
+	if st := GetTenantStats()["handlers-a"]; st.Active != 0 {
+		t.Errorf("active = %d after refused connection", st.Active)
+	}
+}
+
+func TestTenantsHandler_ServeAdmin(t *testing.T) {
+	h := newTestTenants(t, `{"tenants": {"admin-a": {"snis": ["a.example.com"]}}}`)
+	ctx := tenantCtx("a.example.com", "192.0.2.1")
+	h.OnConnect(ctx)
+	defer h.OnDisconnect(ctx)
+
+	rec := httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodGet, "/admin-a", nil))
+	var st TenantStats
+	if err := json.NewDecoder(rec.Body).Decode(&st); err != nil {
+		t.Fatal(err)
+	}
+	if rec.Code != http.StatusOK || st.Active != 1 {
+		t.Errorf("status %d, stats %+v", rec.Code, st)
+	}
+
+	rec = httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodGet, "/nope", nil))
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("unknown tenant status = %d", rec.Code)
+	}
+}
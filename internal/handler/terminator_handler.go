@@ -4,11 +4,16 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
-	"log"
+	"fmt"
+	"sync"
 
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
 	terminator "quic-terminator"
 )
 
+var terminatorLog = logging.For("terminator")
+
 func init() {
 	Register("terminator", NewTerminatorHandler)
 }
@@ -36,11 +41,26 @@ type TerminatorHandlerConfig struct {
 	// Debug enables packet parsing and logging
 	Debug            bool `json:"debug"`
 	DebugPacketLimit int  `json:"debug_packet_limit"` // Max packets to log per stream (0 = unlimited)
+
+	// SharedMappings restores the old behavior for connections with the same
+	// DCID: the last one wins and the first to disconnect removes the mapping.
+	SharedMappings bool `json:"shared_mappings"`
+}
+
+// terminatorMapping is a backend registered for a DCID and the connections using it.
+type terminatorMapping struct {
+	backend string
+	client  string // Address of the first connection
+	refs    int
 }
 
 // TerminatorHandler wraps the terminator library as a HyProxy handler.
 type TerminatorHandler struct {
-	term *terminator.Terminator
+	term   *terminator.Terminator
+	shared bool
+
+	mu       sync.Mutex
+	mappings map[string]*terminatorMapping // DCID → mapping, unless shared
 }
 
 // NewTerminatorHandler creates a new terminator handler.
@@ -84,7 +104,7 @@ func NewTerminatorHandler(raw json.RawMessage) (Handler, error) {
 		return nil, err
 	}
 
-	return &TerminatorHandler{term: term}, nil
+	return &TerminatorHandler{term: term, shared: cfg.SharedMappings, mappings: make(map[string]*terminatorMapping)}, nil
 }
 
 // Name returns the handler name.
@@ -105,12 +125,14 @@ func (h *TerminatorHandler) OnConnect(ctx *Context) Result {
 		return Result{Action: Drop, Error: errors.New("no DCID in packet")}
 	}
 
+	if err := h.register(ctx, dcid, backend); err != nil {
+		terminatorLog.Warnf("%v", err)
+		return Result{Action: Drop, Error: err}
+	}
+
 	// Store DCID in context for cleanup in OnDisconnect
 	ctx.Set("terminator_dcid", dcid)
 
-	// Register backend for this DCID
-	h.term.RegisterBackend(dcid, backend)
-
 	sni := ""
 	if ctx.Hello != nil {
 		sni = ctx.Hello.SNI
@@ -119,7 +141,7 @@ func (h *TerminatorHandler) OnConnect(ctx *Context) Result {
 	if len(dcid) > 8 {
 		dcidShort = dcid[:8]
 	}
-	log.Printf("[terminator] %s (dcid=%s) → %s (via %s)", sni, dcidShort, backend, h.term.InternalAddr)
+	terminatorLog.Printf("%s (dcid=%s) → %s (via %s)", sni, dcidShort, backend, h.term.InternalAddr)
 
 	// Redirect to internal listener
 	ctx.Set("backend", h.term.InternalAddr)
@@ -136,6 +158,53 @@ func (h *TerminatorHandler) OnDisconnect(ctx *Context) {
 	// Clean up using DCID stored in context (InitialPacket may be nil at this point)
 	dcid := ctx.GetString("terminator_dcid")
 	if dcid != "" {
+		h.unregister(dcid)
+	}
+}
+
+// register maps dcid to backend in the terminator. A second connection with
+// the same DCID shares the mapping if it was routed to the same backend and
+// is refused otherwise, since the terminator could only bridge both to one
+// of them.
+func (h *TerminatorHandler) register(ctx *Context, dcid, backend string) error {
+	if h.shared {
+		h.term.RegisterBackend(dcid, backend)
+		return nil
+	}
+	client := ""
+	if ctx.ClientAddr != nil {
+		client = privacy.Addr(ctx.ClientAddr)
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if m, ok := h.mappings[dcid]; ok {
+		if m.backend != backend {
+			return fmt.Errorf("DCID %s of %s already maps %s to %s, refusing %s", dcid, client, m.client, m.backend, backend)
+		}
+		m.refs++
+		terminatorLog.Debugf("DCID %s of %s shares the mapping of %s to %s", dcid, client, m.client, backend)
+		return nil
+	}
+	h.mappings[dcid] = &terminatorMapping{backend: backend, client: client, refs: 1}
+	h.term.RegisterBackend(dcid, backend)
+	return nil
+}
+
+// unregister removes dcid's mapping when its last connection is gone.
+func (h *TerminatorHandler) unregister(dcid string) {
+	if h.shared {
+		h.term.UnregisterBackend(dcid)
+		return
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	m, ok := h.mappings[dcid]
+	if !ok {
+		return
+	}
+	if m.refs--; m.refs == 0 {
+		delete(h.mappings, dcid)
 		h.term.UnregisterBackend(dcid)
 	}
 }
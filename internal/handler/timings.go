@@ -0,0 +1,59 @@
+package handler
+
+import (
+	"io"
+	"maps"
+	"slices"
+	"sync"
+
+	"quic-relay/internal/metrics"
+)
+
+// handlerTimings holds the OnConnect and OnPacket latency of one handler
+// type. Handlers of the same type share it, across chains and reloads.
+type handlerTimings struct {
+	connect *metrics.Histogram
+	packet  *metrics.Histogram
+}
+
+var (
+	timingsMu sync.Mutex
+	timings   = make(map[string]*handlerTimings)
+)
+
+// timingsFor returns the timings of the handler type name.
+func timingsFor(name string) *handlerTimings {
+	timingsMu.Lock()
+	defer timingsMu.Unlock()
+	t, ok := timings[name]
+	if !ok {
+		t = &handlerTimings{
+			connect: metrics.NewHistogram(metrics.LatencyBuckets),
+			packet:  metrics.NewHistogram(metrics.LatencyBuckets),
+		}
+		timings[name] = t
+	}
+	return t
+}
+
+func init() {
+	metrics.Register(writeTimings)
+}
+
+// writeTimings writes the handler latency histograms.
+func writeTimings(w io.Writer) {
+	timingsMu.Lock()
+	names := slices.Sorted(maps.Keys(timings))
+	all := make([]*handlerTimings, len(names))
+	for i, name := range names {
+		all[i] = timings[name]
+	}
+	timingsMu.Unlock()
+
+	const name = "quic_relay_handler_duration_seconds"
+	metrics.WriteHeader(w, name, "histogram", "Time spent in handler callbacks.")
+	for i, t := range all {
+		t.connect.Write(w, name, "handler", names[i], "phase", "connect")
+		t.packet.Write(w, name, "handler", names[i], "phase", "packet")
+	}
+}
@@ -0,0 +1,31 @@
+package handler
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestChain_Timings(t *testing.T) {
+	h := newMockHandler("timings-test", Continue, Continue)
+	chain := NewChain(h, newMockHandler("timings-test-last", Handled, Handled))
+	connects, packets := timingsFor("timings-test").connect.Count(), timingsFor("timings-test").packet.Count()
+
+	chain.OnConnect(&Context{})
+	chain.OnPacket(&Context{}, []byte{1}, Inbound)
+	chain.OnPacket(&Context{}, []byte{1}, Inbound)
+
+	tm := timingsFor("timings-test")
+	if got := tm.connect.Count() - connects; got != 1 {
+		t.Errorf("connect observations = %d, want 1", got)
+	}
+	if got := tm.packet.Count() - packets; got != 2 {
+		t.Errorf("packet observations = %d, want 2", got)
+	}
+
+	var buf bytes.Buffer
+	writeTimings(&buf)
+	if !strings.Contains(buf.String(), `quic_relay_handler_duration_seconds_count{handler="timings-test",phase="packet"}`) {
+		t.Errorf("metrics output lacks the handler's series:\n%s", buf.String())
+	}
+}
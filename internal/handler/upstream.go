@@ -0,0 +1,185 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/logging"
+)
+
+var upstreamLog = logging.For("upstream")
+
+// UpstreamKey is the context key holding the *upstream a route's backend
+// connections go through.
+const UpstreamKey = "upstream"
+
+// Upstream proxy types.
+const (
+	UpstreamSOCKS5 = "socks5" // SOCKS5 UDP ASSOCIATE (RFC 1928)
+	UpstreamMASQUE = "masque" // HTTP/3 CONNECT-UDP (RFC 9298)
+)
+
+const defaultUpstreamTimeoutMs = 5000
+
+// UpstreamConfig sends the backend traffic of a route through a proxy, for
+// relays that cannot reach their backends directly.
+type UpstreamConfig struct {
+	Type      string `json:"type"`                 // "socks5" or "masque"
+	Addr      string `json:"addr"`                 // Proxy host:port
+	Username  string `json:"username,omitempty"`   // SOCKS5 username/password authentication
+	Password  string `json:"password,omitempty"`   // SOCKS5 password
+	TimeoutMs int    `json:"timeout_ms,omitempty"` // Time to set up a session's tunnel (default: 5000)
+
+	// MASQUE only
+	Template   string `json:"template,omitempty"`    // URI template (default: "https://{addr}/.well-known/masque/udp/{target_host}/{target_port}/")
+	ServerName string `json:"server_name,omitempty"` // TLS server name (default: host of addr)
+	CA         string `json:"ca,omitempty"`          // PEM file of CAs trusted for the proxy (default: system roots)
+	Insecure   bool   `json:"insecure,omitempty"`    // Skip verification of the proxy's certificate
+}
+
+// upstream is a compiled upstream proxy, shared by the sessions of a route.
+type upstream struct {
+	typ     string
+	addr    string
+	timeout time.Duration
+	socks5  *socks5Dialer
+	masque  *masqueDialer
+}
+
+func newUpstream(cfg UpstreamConfig) (*upstream, error) {
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return nil, fmt.Errorf("upstream 'addr': %w", err)
+	}
+	if cfg.TimeoutMs < 0 {
+		return nil, errors.New("upstream 'timeout_ms' must be >= 0")
+	}
+	if cfg.TimeoutMs == 0 {
+		cfg.TimeoutMs = defaultUpstreamTimeoutMs
+	}
+	u := &upstream{typ: cfg.Type, addr: cfg.Addr, timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond}
+	var err error
+	switch cfg.Type {
+	case UpstreamSOCKS5:
+		if cfg.Template != "" || cfg.ServerName != "" || cfg.CA != "" || cfg.Insecure {
+			return nil, errors.New("upstream 'template', 'server_name', 'ca' and 'insecure' are for masque only")
+		}
+		u.socks5, err = newSOCKS5Dialer(cfg)
+	case UpstreamMASQUE:
+		if cfg.Username != "" || cfg.Password != "" {
+			return nil, errors.New("upstream 'username' and 'password' are for socks5 only")
+		}
+		u.masque, err = newMASQUEDialer(cfg)
+	case "":
+		return nil, errors.New("upstream 'type' is required")
+	default:
+		return nil, fmt.Errorf("unknown upstream type %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// datagramCarrier moves the datagrams of one session over a proxy.
+type datagramCarrier interface {
+	send(p []byte) error
+	receive(buf []byte) (int, error) // Blocks until a datagram arrives or the carrier is closed
+	done() <-chan struct{}           // Closed when the proxy ends the tunnel
+	Close() error
+}
+
+// open sets up a tunnel to backend, a host:port resolved by the proxy.
+func (u *upstream) open(backend string) (*upstreamTunnel, error) {
+	var (
+		c   datagramCarrier
+		err error
+	)
+	switch u.typ {
+	case UpstreamSOCKS5:
+		c, err = u.socks5.dial(backend, u.timeout)
+	case UpstreamMASQUE:
+		c, err = u.masque.dial(backend, u.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s %s: %w", u.typ, u.addr, err)
+	}
+	return newUpstreamTunnel(c)
+}
+
+// upstreamTunnel carries one session's datagrams through a proxy. The
+// forwarder talks to it over a loopback socket, so the session's BackendConn
+// stays a plain UDP socket connected to Addr.
+type upstreamTunnel struct {
+	Addr *net.UDPAddr // Loopback address to send backend datagrams to
+
+	local   *net.UDPConn
+	carrier datagramCarrier
+	peer    atomic.Pointer[net.UDPAddr] // The forwarder's socket
+
+	closeOnce sync.Once
+}
+
+func newUpstreamTunnel(c datagramCarrier) (*upstreamTunnel, error) {
+	local, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		c.Close()
+		return nil, err
+	}
+	t := &upstreamTunnel{Addr: local.LocalAddr().(*net.UDPAddr), local: local, carrier: c}
+	go func() {
+		<-c.done()
+		t.Close()
+	}()
+	return t, nil
+}
+
+// attach starts forwarding between the proxy and the socket at peer.
+// Datagrams from other local sockets are ignored.
+func (t *upstreamTunnel) attach(peer *net.UDPAddr) {
+	t.peer.Store(peer)
+	go t.toProxy()
+	go t.fromProxy()
+}
+
+func (t *upstreamTunnel) toProxy() {
+	defer t.Close()
+	buf := make([]byte, LargeBufferSize)
+	for {
+		n, from, err := t.local.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.AddrPort().Addr().IsLoopback() || from.Port != t.peer.Load().Port {
+			continue
+		}
+		if err := t.carrier.send(buf[:n]); err != nil {
+			upstreamLog.Debugf("send to proxy failed: %v", err)
+		}
+	}
+}
+
+func (t *upstreamTunnel) fromProxy() {
+	defer t.Close()
+	buf := make([]byte, LargeBufferSize)
+	for {
+		n, err := t.carrier.receive(buf)
+		if err != nil {
+			return
+		}
+		t.local.WriteToUDP(buf[:n], t.peer.Load())
+	}
+}
+
+// Close ends the tunnel. Once the forwarder sends again, its socket reports
+// connection refused and the session closes with a backend error.
+func (t *upstreamTunnel) Close() error {
+	t.closeOnce.Do(func() {
+		t.local.Close()
+		t.carrier.Close()
+	})
+	return nil
+}
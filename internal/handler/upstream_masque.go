@@ -0,0 +1,204 @@
+package handler
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// masqueDialer opens CONNECT-UDP tunnels on one HTTP/3 connection to a
+// MASQUE proxy, dialed on first use and again after it closes.
+type masqueDialer struct {
+	addr     string
+	template string
+	tlsConf  *tls.Config
+
+	mu   sync.Mutex
+	conn *quic.Conn
+	cc   *http3.ClientConn
+}
+
+func newMASQUEDialer(cfg UpstreamConfig) (*masqueDialer, error) {
+	host, _, _ := net.SplitHostPort(cfg.Addr)
+	template := cfg.Template
+	if template == "" {
+		template = "https://" + cfg.Addr + "/.well-known/masque/udp/{target_host}/{target_port}/"
+	}
+	u, err := url.Parse(strings.NewReplacer("{target_host}", "h", "{target_port}", "1").Replace(template))
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return nil, fmt.Errorf("upstream 'template' must be an https URI: %q", template)
+	}
+	if !strings.Contains(template, "{target_host}") || !strings.Contains(template, "{target_port}") {
+		return nil, errors.New("upstream 'template' must contain {target_host} and {target_port}")
+	}
+
+	tlsConf := &tls.Config{
+		ServerName:         cmp.Or(cfg.ServerName, host),
+		NextProtos:         []string{http3.NextProtoH3},
+		InsecureSkipVerify: cfg.Insecure,
+	}
+	if cfg.CA != "" {
+		pem, err := os.ReadFile(cfg.CA)
+		if err != nil {
+			return nil, fmt.Errorf("upstream 'ca': %w", err)
+		}
+		tlsConf.RootCAs = x509.NewCertPool()
+		if !tlsConf.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("upstream 'ca': no certificates in %s", cfg.CA)
+		}
+	}
+	return &masqueDialer{addr: cfg.Addr, template: template, tlsConf: tlsConf}, nil
+}
+
+// clientConn returns the HTTP/3 connection to the proxy, dialing it when
+// there is none or it has closed.
+func (d *masqueDialer) clientConn(ctx context.Context) (*http3.ClientConn, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.conn != nil && d.conn.Context().Err() == nil {
+		return d.cc, nil
+	}
+	conn, err := quic.DialAddr(ctx, d.addr, d.tlsConf, &quic.Config{
+		EnableDatagrams: true,
+		KeepAlivePeriod: 15 * time.Second,
+	})
+	if err != nil {
+		return nil, err
+	}
+	cc := (&http3.Transport{EnableDatagrams: true}).NewClientConn(conn)
+	select {
+	case <-cc.ReceivedSettings():
+	case <-ctx.Done():
+		conn.CloseWithError(0, "")
+		return nil, ctx.Err()
+	}
+	if s := cc.Settings(); !s.EnableDatagrams || !s.EnableExtendedConnect {
+		conn.CloseWithError(0, "")
+		return nil, errors.New("proxy does not support HTTP datagrams and extended CONNECT")
+	}
+	d.conn, d.cc = conn, cc
+	return cc, nil
+}
+
+// dial sends a CONNECT-UDP request for backend and returns a carrier for
+// the datagrams of the request stream.
+func (d *masqueDialer) dial(backend string, timeout time.Duration) (datagramCarrier, error) {
+	host, port, err := net.SplitHostPort(backend)
+	if err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(strings.NewReplacer(
+		"{target_host}", url.QueryEscape(host),
+		"{target_port}", url.QueryEscape(port),
+	).Replace(d.template))
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	cc, err := d.clientConn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	str, err := cc.OpenRequestStream(ctx)
+	if err != nil {
+		return nil, err
+	}
+	str.SetDeadline(time.Now().Add(timeout))
+	req := &http.Request{
+		Method: http.MethodConnect,
+		Proto:  "connect-udp",
+		Host:   target.Host,
+		URL:    target,
+		Header: http.Header{"Capsule-Protocol": {"?1"}},
+	}
+	if err := str.SendRequestHeader(req); err != nil {
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+		return nil, err
+	}
+	resp, err := str.ReadResponse()
+	if err != nil {
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		str.CancelRead(quic.StreamErrorCode(http3.ErrCodeRequestCanceled))
+		str.Close()
+		return nil, fmt.Errorf("CONNECT-UDP %s: %s", target, resp.Status)
+	}
+	str.SetDeadline(time.Time{})
+
+	ctx, stop := context.WithCancel(context.Background())
+	c := &masqueCarrier{str: str, ctx: ctx, stop: stop}
+	go c.watch()
+	return c, nil
+}
+
+// masqueCarrier sends datagrams as HTTP datagrams of a CONNECT-UDP stream,
+// with context ID 0 (RFC 9298).
+type masqueCarrier struct {
+	str  *http3.RequestStream
+	ctx  context.Context // Done when the carrier is closed
+	stop context.CancelFunc
+
+	closeOnce sync.Once
+}
+
+// watch ends the carrier when the proxy closes the request stream. Capsules
+// sent on the stream are ignored.
+func (c *masqueCarrier) watch() {
+	buf := make([]byte, 1024)
+	for {
+		if _, err := c.str.Read(buf); err != nil {
+			c.Close()
+			return
+		}
+	}
+}
+
+func (c *masqueCarrier) send(p []byte) error {
+	buf := GetBufferSized(1 + len(p))
+	out := append(append((*buf)[:0], 0), p...)
+	err := c.str.SendDatagram(out)
+	PutBuffer(buf)
+	return err
+}
+
+// receive returns the payload of the next datagram with context ID 0.
+func (c *masqueCarrier) receive(buf []byte) (int, error) {
+	for {
+		d, err := c.str.ReceiveDatagram(c.ctx)
+		if err != nil {
+			return 0, err
+		}
+		if len(d) == 0 || d[0] != 0 {
+			continue
+		}
+		return copy(buf, d[1:]), nil
+	}
+}
+
+func (c *masqueCarrier) done() <-chan struct{} { return c.ctx.Done() }
+
+func (c *masqueCarrier) Close() error {
+	c.closeOnce.Do(func() {
+		c.stop()
+		c.str.CancelRead(quic.StreamErrorCode(http3.ErrCodeNoError))
+		c.str.Close()
+	})
+	return nil
+}
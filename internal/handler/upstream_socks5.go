@@ -0,0 +1,274 @@
+package handler
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// SOCKS5 client constants (RFC 1928, RFC 1929).
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5CmdAssociate = 0x03
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+)
+
+// socks5Dialer sets up UDP associations with a SOCKS5 proxy.
+type socks5Dialer struct {
+	addr     string
+	username string
+	password string
+}
+
+func newSOCKS5Dialer(cfg UpstreamConfig) (*socks5Dialer, error) {
+	if len(cfg.Username) > 255 || len(cfg.Password) > 255 {
+		return nil, errors.New("upstream 'username' and 'password' must be at most 255 bytes")
+	}
+	if cfg.Password != "" && cfg.Username == "" {
+		return nil, errors.New("upstream 'password' requires 'username'")
+	}
+	return &socks5Dialer{addr: cfg.Addr, username: cfg.Username, password: cfg.Password}, nil
+}
+
+// dial opens a control connection, requests a UDP association and returns a
+// carrier sending to backend through it. The association lasts as long as
+// the control connection.
+func (d *socks5Dialer) dial(backend string, timeout time.Duration) (datagramCarrier, error) {
+	header, err := socks5UDPHeader(backend)
+	if err != nil {
+		return nil, err
+	}
+	ctrl, err := net.DialTimeout("tcp", d.addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	ctrl.SetDeadline(time.Now().Add(timeout))
+	relay, err := d.associate(ctrl)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	ctrl.SetDeadline(time.Time{})
+	udp, err := net.DialUDP("udp", nil, relay)
+	if err != nil {
+		ctrl.Close()
+		return nil, err
+	}
+	c := &socks5Carrier{ctrl: ctrl, udp: udp, header: header, closed: make(chan struct{})}
+	go c.watch()
+	return c, nil
+}
+
+// associate authenticates and requests a UDP association on ctrl. It returns
+// the proxy's relay address.
+func (d *socks5Dialer) associate(ctrl net.Conn) (*net.UDPAddr, error) {
+	methods := []byte{socks5AuthNone}
+	if d.username != "" {
+		methods = []byte{socks5AuthPassword}
+	}
+	if _, err := ctrl.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return nil, err
+	}
+	var choice [2]byte
+	if _, err := io.ReadFull(ctrl, choice[:]); err != nil {
+		return nil, err
+	}
+	if choice[0] != socks5Version || choice[1] != methods[0] {
+		return nil, errors.New("proxy refused the authentication method")
+	}
+	if d.username != "" {
+		req := []byte{0x01, byte(len(d.username))}
+		req = append(req, d.username...)
+		req = append(req, byte(len(d.password)))
+		req = append(req, d.password...)
+		if _, err := ctrl.Write(req); err != nil {
+			return nil, err
+		}
+		var status [2]byte
+		if _, err := io.ReadFull(ctrl, status[:]); err != nil {
+			return nil, err
+		}
+		if status[1] != 0 {
+			return nil, errors.New("proxy rejected username and password")
+		}
+	}
+
+	// The relay's own address is unknown to it behind NAT, so ask for any
+	if _, err := ctrl.Write([]byte{socks5Version, socks5CmdAssociate, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0}); err != nil {
+		return nil, err
+	}
+	var reply [3]byte
+	if _, err := io.ReadFull(ctrl, reply[:]); err != nil {
+		return nil, err
+	}
+	if reply[0] != socks5Version {
+		return nil, fmt.Errorf("bad SOCKS version %d", reply[0])
+	}
+	if reply[1] != 0 {
+		return nil, fmt.Errorf("UDP ASSOCIATE refused with code %d", reply[1])
+	}
+	bound, err := readSOCKS5Addr(ctrl)
+	if err != nil {
+		return nil, err
+	}
+	relay, err := net.ResolveUDPAddr("udp", bound)
+	if err != nil {
+		return nil, err
+	}
+	// An unspecified relay address means the proxy's own address
+	if relay.IP == nil || relay.IP.IsUnspecified() {
+		relay.IP = ctrl.RemoteAddr().(*net.TCPAddr).IP
+	}
+	return relay, nil
+}
+
+// readSOCKS5Addr reads an ATYP, address and port and returns them as host:port.
+func readSOCKS5Addr(r io.Reader) (string, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return "", err
+	}
+	var host string
+	switch atyp[0] {
+	case socks5AtypIPv4, socks5AtypIPv6:
+		ip := make([]byte, 4)
+		if atyp[0] == socks5AtypIPv6 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(r, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case socks5AtypDomain:
+		var n [1]byte
+		if _, err := io.ReadFull(r, n[:]); err != nil {
+			return "", err
+		}
+		name := make([]byte, n[0])
+		if _, err := io.ReadFull(r, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		return "", fmt.Errorf("unknown SOCKS address type %d", atyp[0])
+	}
+	var port [2]byte
+	if _, err := io.ReadFull(r, port[:]); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:])))), nil
+}
+
+// socks5UDPHeader builds the header of datagrams to backend. Host names are
+// passed on for the proxy to resolve.
+func socks5UDPHeader(backend string) ([]byte, error) {
+	host, portStr, err := net.SplitHostPort(backend)
+	if err != nil {
+		return nil, err
+	}
+	port, err := strconv.ParseUint(portStr, 10, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port %q", portStr)
+	}
+	header := []byte{0, 0, 0} // RSV, FRAG
+	if ip, err := netip.ParseAddr(host); err == nil {
+		if ip.Unmap().Is4() {
+			header = append(header, socks5AtypIPv4)
+			header = append(header, ip.Unmap().AsSlice()...)
+		} else {
+			header = append(header, socks5AtypIPv6)
+			header = append(header, ip.AsSlice()...)
+		}
+	} else {
+		if len(host) > 255 {
+			return nil, errors.New("host name too long")
+		}
+		header = append(header, socks5AtypDomain, byte(len(host)))
+		header = append(header, host...)
+	}
+	return binary.BigEndian.AppendUint16(header, uint16(port)), nil
+}
+
+// socks5Carrier sends datagrams through a SOCKS5 UDP association.
+type socks5Carrier struct {
+	ctrl   net.Conn
+	udp    *net.UDPConn
+	header []byte
+
+	closeOnce sync.Once
+	closed    chan struct{}
+}
+
+// watch ends the carrier when the proxy closes the control connection.
+func (c *socks5Carrier) watch() {
+	io.Copy(io.Discard, c.ctrl)
+	c.Close()
+}
+
+func (c *socks5Carrier) send(p []byte) error {
+	buf := GetBufferSized(len(c.header) + len(p))
+	out := append(append((*buf)[:0], c.header...), p...)
+	_, err := c.udp.Write(out)
+	PutBuffer(buf)
+	return err
+}
+
+// receive reads a datagram and strips its header. Fragments are dropped, as
+// RFC 1928 allows.
+func (c *socks5Carrier) receive(buf []byte) (int, error) {
+	for {
+		n, err := c.udp.Read(buf)
+		if err != nil {
+			return 0, err
+		}
+		hlen := socks5HeaderLen(buf[:n])
+		if hlen < 0 {
+			continue
+		}
+		return copy(buf, buf[hlen:n]), nil
+	}
+}
+
+// socks5HeaderLen returns the length of the header of a relayed datagram, or
+// -1 if it is malformed or a fragment.
+func socks5HeaderLen(p []byte) int {
+	if len(p) < 4 || p[2] != 0 {
+		return -1
+	}
+	n := -1
+	switch p[3] {
+	case socks5AtypIPv4:
+		n = 4 + 4 + 2
+	case socks5AtypIPv6:
+		n = 4 + 16 + 2
+	case socks5AtypDomain:
+		if len(p) > 4 {
+			n = 4 + 1 + int(p[4]) + 2
+		}
+	}
+	if n > len(p) {
+		return -1
+	}
+	return n
+}
+
+func (c *socks5Carrier) done() <-chan struct{} { return c.closed }
+
+func (c *socks5Carrier) Close() error {
+	c.closeOnce.Do(func() {
+		c.ctrl.Close()
+		c.udp.Close()
+		close(c.closed)
+	})
+	return nil
+}
@@ -0,0 +1,366 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go/http3"
+)
+
+func listenLoopbackUDP(t *testing.T) *net.UDPConn {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// echoBackend answers every datagram with "echo:" and the datagram.
+func echoBackend(t *testing.T) *net.UDPConn {
+	conn := listenLoopbackUDP(t)
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, from, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			conn.WriteToUDP(append([]byte("echo:"), buf[:n]...), from)
+		}
+	}()
+	return conn
+}
+
+// fakeSOCKS5 is a SOCKS5 proxy supporting UDP ASSOCIATE only. It replies
+// with an unspecified relay address, as proxies behind NAT do.
+type fakeSOCKS5 struct {
+	ln       net.Listener
+	username string
+	password string
+	ctrl     chan net.Conn // Accepted control connections
+}
+
+func newFakeSOCKS5(t *testing.T, username, password string) *fakeSOCKS5 {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	s := &fakeSOCKS5{ln: ln, username: username, password: password, ctrl: make(chan net.Conn, 8)}
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { c.Close() })
+			go s.handle(t, c)
+		}
+	}()
+	return s
+}
+
+func (s *fakeSOCKS5) handle(t *testing.T, c net.Conn) {
+	var hello [2]byte
+	io.ReadFull(c, hello[:])
+	methods := make([]byte, hello[1])
+	io.ReadFull(c, methods)
+	if s.username == "" {
+		c.Write([]byte{socks5Version, socks5AuthNone})
+	} else {
+		c.Write([]byte{socks5Version, socks5AuthPassword})
+		var head [2]byte
+		io.ReadFull(c, head[:])
+		user := make([]byte, head[1])
+		io.ReadFull(c, user)
+		var plen [1]byte
+		io.ReadFull(c, plen[:])
+		pass := make([]byte, plen[0])
+		io.ReadFull(c, pass)
+		if string(user) != s.username || string(pass) != s.password {
+			c.Write([]byte{1, 1})
+			c.Close()
+			return
+		}
+		c.Write([]byte{1, 0})
+	}
+	var req [3]byte
+	io.ReadFull(c, req[:])
+	readSOCKS5Addr(c)
+
+	relay := listenLoopbackUDP(t)
+	port := binary.BigEndian.AppendUint16(nil, uint16(relay.LocalAddr().(*net.UDPAddr).Port))
+	c.Write(append([]byte{socks5Version, 0, 0, socks5AtypIPv4, 0, 0, 0, 0}, port...))
+	s.ctrl <- c
+
+	go func() {
+		io.Copy(io.Discard, c)
+		relay.Close()
+	}()
+	var client *net.UDPAddr
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := relay.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if client == nil || from.Port == client.Port {
+			// From the client: strip the header and send on
+			client = from
+			hlen := socks5HeaderLen(buf[:n])
+			target, err := readSOCKS5Addr(bytes.NewReader(buf[3:hlen]))
+			if err != nil {
+				continue
+			}
+			dst, err := net.ResolveUDPAddr("udp", target)
+			if err != nil {
+				continue
+			}
+			relay.WriteToUDP(buf[hlen:n], dst)
+			continue
+		}
+		header, _ := socks5UDPHeader(from.String())
+		relay.WriteToUDP(append(header, buf[:n]...), client)
+	}
+}
+
+// connectThrough opens a forwarder session for backend through the route's
+// upstream, and returns the client socket receiving the backend's answers.
+func connectThrough(t *testing.T, upstream, backend string) (Handler, *Context, *net.UDPConn) {
+	t.Helper()
+	router, err := NewDynamicHandler(json.RawMessage(`{"routes": {"play.example.com": {
+		"backends": ["` + backend + `"], "upstream": ` + upstream + `}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := listenLoopbackUDP(t)
+	fwd, _ := NewForwarderHandler(nil)
+	ctx := &Context{
+		Hello:         &ClientHello{SNI: "play.example.com"},
+		ClientAddr:    client.LocalAddr().(*net.UDPAddr),
+		InitialPacket: []byte("hello"),
+		ProxyConn:     listenLoopbackUDP(t),
+		DropSession:   func() {},
+	}
+	if res := router.OnConnect(ctx); res.Action != Continue {
+		t.Fatalf("router: %v", res.Error)
+	}
+	if res := fwd.OnConnect(ctx); res.Action != Handled {
+		t.Fatalf("forwarder: %v", res.Error)
+	}
+	t.Cleanup(func() { fwd.OnDisconnect(ctx) })
+	return fwd, ctx, client
+}
+
+func expectEcho(t *testing.T, client *net.UDPConn, want string) {
+	t.Helper()
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("no answer from the backend: %v", err)
+	}
+	if got := string(buf[:n]); got != want {
+		t.Errorf("client got %q, want %q", got, want)
+	}
+}
+
+func TestUpstream_SOCKS5(t *testing.T) {
+	backend := echoBackend(t)
+	proxy := newFakeSOCKS5(t, "relay", "secret")
+	fwd, ctx, client := connectThrough(t,
+		`{"type": "socks5", "addr": "`+proxy.ln.Addr().String()+`", "username": "relay", "password": "secret"}`,
+		backend.LocalAddr().String())
+
+	expectEcho(t, client, "echo:hello")
+	if res := fwd.OnPacket(ctx, []byte("again"), Inbound); res.Action != Handled {
+		t.Fatalf("OnPacket: %v", res.Error)
+	}
+	expectEcho(t, client, "echo:again")
+
+	// Closing the session ends the association
+	ctrl := <-proxy.ctrl
+	fwd.OnDisconnect(ctx)
+	ctrl.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, err := ctrl.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("control connection read after session close: %v, want EOF", err)
+	}
+}
+
+func TestUpstream_SOCKS5HostName(t *testing.T) {
+	backend := echoBackend(t)
+	proxy := newFakeSOCKS5(t, "", "")
+	port := strconv.Itoa(backend.LocalAddr().(*net.UDPAddr).Port)
+	_, ctx, client := connectThrough(t, `{"type": "socks5", "addr": "`+proxy.ln.Addr().String()+`"}`, "localhost:"+port)
+
+	expectEcho(t, client, "echo:hello")
+	if addr := ctx.Session.BackendAddr(); addr != nil {
+		t.Errorf("backend address = %v, want none for a name resolved by the proxy", addr)
+	}
+}
+
+func TestUpstream_SOCKS5AuthRefused(t *testing.T) {
+	proxy := newFakeSOCKS5(t, "relay", "secret")
+	router, err := NewDynamicHandler(json.RawMessage(`{"routes": {"a.com": {"backends": ["127.0.0.1:9"],
+		"upstream": {"type": "socks5", "addr": "` + proxy.ln.Addr().String() + `", "username": "relay", "password": "wrong"}}}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := &Context{Hello: &ClientHello{SNI: "a.com"}}
+	router.OnConnect(ctx)
+	fwd, _ := NewForwarderHandler(nil)
+	res := fwd.OnConnect(ctx)
+	if res.Action != Drop || res.Error == nil || !strings.Contains(res.Error.Error(), "rejected username and password") {
+		t.Errorf("OnConnect = %v, %v; want Drop for refused credentials", res.Action, res.Error)
+	}
+}
+
+// newFakeMASQUE starts an HTTP/3 proxy answering CONNECT-UDP requests.
+func newFakeMASQUE(t *testing.T) string {
+	_, priv, _ := ed25519.GenerateKey(rand.Reader)
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn := listenLoopbackUDP(t)
+	srv := &http3.Server{
+		TLSConfig:       http3.ConfigureTLSConfig(&tls.Config{Certificates: []tls.Certificate{{Certificate: [][]byte{der}, PrivateKey: priv}}}),
+		EnableDatagrams: true,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+			if r.Method != http.MethodConnect || r.Proto != "connect-udp" || len(parts) != 5 {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			target, err := net.ResolveUDPAddr("udp", net.JoinHostPort(parts[3], parts[4]))
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			udp, err := net.DialUDP("udp", nil, target)
+			if err != nil {
+				w.WriteHeader(http.StatusBadGateway)
+				return
+			}
+			defer udp.Close()
+			w.Header().Set("Capsule-Protocol", "?1")
+			w.WriteHeader(http.StatusOK)
+			w.(http.Flusher).Flush()
+			str := w.(http3.HTTPStreamer).HTTPStream()
+			ctx, cancel := context.WithCancel(r.Context())
+			defer cancel()
+			go func() {
+				// The client closes the stream to end the tunnel
+				io.Copy(io.Discard, str)
+				cancel()
+			}()
+			go func() {
+				buf := make([]byte, 1500)
+				for {
+					n, err := udp.Read(buf)
+					if err != nil {
+						return
+					}
+					str.SendDatagram(append([]byte{0}, buf[:n]...))
+				}
+			}()
+			for {
+				d, err := str.ReceiveDatagram(ctx)
+				if err != nil {
+					return
+				}
+				udp.Write(d[1:])
+			}
+		}),
+	}
+	go srv.Serve(conn)
+	t.Cleanup(func() { srv.Close() })
+	return conn.LocalAddr().String()
+}
+
+func TestUpstream_MASQUE(t *testing.T) {
+	backend := echoBackend(t)
+	proxy := newFakeMASQUE(t)
+	fwd, ctx, client := connectThrough(t, `{"type": "masque", "addr": "`+proxy+`", "insecure": true}`, backend.LocalAddr().String())
+
+	expectEcho(t, client, "echo:hello")
+	if res := fwd.OnPacket(ctx, []byte("again"), Inbound); res.Action != Handled {
+		t.Fatalf("OnPacket: %v", res.Error)
+	}
+	expectEcho(t, client, "echo:again")
+}
+
+func TestUpstream_MASQUERefused(t *testing.T) {
+	proxy := newFakeMASQUE(t)
+	u, err := newUpstream(UpstreamConfig{Type: UpstreamMASQUE, Addr: proxy, Insecure: true,
+		Template: "https://" + proxy + "/wrong/{target_host}/{target_port}"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.open("127.0.0.1:9"); err == nil || !strings.Contains(err.Error(), "400") {
+		t.Errorf("open = %v, want the proxy's 400 response", err)
+	}
+}
+
+func TestNewUpstream_Invalid(t *testing.T) {
+	tests := []struct {
+		cfg     UpstreamConfig
+		wantErr string
+	}{
+		{UpstreamConfig{Addr: "127.0.0.1:1080"}, "'type' is required"},
+		{UpstreamConfig{Type: "http", Addr: "127.0.0.1:1080"}, "unknown upstream type"},
+		{UpstreamConfig{Type: UpstreamSOCKS5, Addr: "proxy"}, "'addr'"},
+		{UpstreamConfig{Type: UpstreamSOCKS5, Addr: "127.0.0.1:1080", TimeoutMs: -1}, "'timeout_ms'"},
+		{UpstreamConfig{Type: UpstreamSOCKS5, Addr: "127.0.0.1:1080", Password: "x"}, "requires 'username'"},
+		{UpstreamConfig{Type: UpstreamSOCKS5, Addr: "127.0.0.1:1080", Insecure: true}, "masque only"},
+		{UpstreamConfig{Type: UpstreamMASQUE, Addr: "127.0.0.1:443", Username: "x"}, "socks5 only"},
+		{UpstreamConfig{Type: UpstreamMASQUE, Addr: "127.0.0.1:443", Template: "http://proxy/{target_host}/{target_port}"}, "https URI"},
+		{UpstreamConfig{Type: UpstreamMASQUE, Addr: "127.0.0.1:443", Template: "https://proxy/{target_host}"}, "{target_port}"},
+		{UpstreamConfig{Type: UpstreamMASQUE, Addr: "127.0.0.1:443", CA: "/nonexistent/ca.pem"}, "'ca'"},
+	}
+	for _, tt := range tests {
+		if _, err := newUpstream(tt.cfg); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%+v: error = %v, want %q", tt.cfg, err, tt.wantErr)
+		}
+	}
+}
+
+func TestSOCKS5UDPHeader(t *testing.T) {
+	tests := []struct {
+		backend string
+		want    []byte
+	}{
+		{"10.0.0.1:5520", []byte{0, 0, 0, socks5AtypIPv4, 10, 0, 0, 1, 0x15, 0x90}},
+		{"[2001:db8::1]:443", append(append([]byte{0, 0, 0, socks5AtypIPv6}, net.ParseIP("2001:db8::1")...), 0x01, 0xbb)},
+		{"play.example.com:443", append(append([]byte{0, 0, 0, socks5AtypDomain, 16}, "play.example.com"...), 0x01, 0xbb)},
+	}
+	for _, tt := range tests {
+		got, err := socks5UDPHeader(tt.backend)
+		if err != nil || !bytes.Equal(got, tt.want) {
+			t.Errorf("socks5UDPHeader(%s) = %x, %v; want %x", tt.backend, got, err, tt.want)
+		}
+		if n := socks5HeaderLen(append(got, "payload"...)); n != len(tt.want) {
+			t.Errorf("socks5HeaderLen of %s header = %d, want %d", tt.backend, n, len(tt.want))
+		}
+	}
+	if n := socks5HeaderLen([]byte{0, 0, 1, socks5AtypIPv4, 10, 0, 0, 1, 0, 1}); n != -1 {
+		t.Errorf("socks5HeaderLen of a fragment = %d, want -1", n)
+	}
+}
@@ -0,0 +1,220 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// versionSwitch is a route version activated via the admin API.
+type versionSwitch struct {
+	Active   string    `json:"active"`
+	Previous string    `json:"previous,omitempty"` // Version a rollback returns to
+	Switched time.Time `json:"switched"`
+}
+
+// versionSwitches holds admin API cutovers by route key. It is package-level
+// so cutovers survive handler chain reloads. Readers load the map without
+// locking; writers replace it.
+var versionSwitches struct {
+	mu sync.Mutex
+	m  atomic.Pointer[map[string]versionSwitch]
+}
+
+func loadVersionSwitch(key string) (versionSwitch, bool) {
+	m := versionSwitches.m.Load()
+	if m == nil {
+		return versionSwitch{}, false
+	}
+	sw, ok := (*m)[key]
+	return sw, ok
+}
+
+// updateVersionSwitch replaces the cutover of key; nil deletes it.
+func updateVersionSwitch(key string, sw *versionSwitch) {
+	versionSwitches.mu.Lock()
+	defer versionSwitches.mu.Unlock()
+	m := make(map[string]versionSwitch)
+	if old := versionSwitches.m.Load(); old != nil {
+		maps.Copy(m, *old)
+	}
+	if sw == nil {
+		delete(m, key)
+	} else {
+		m[key] = *sw
+	}
+	versionSwitches.m.Store(&m)
+}
+
+// activeVersion returns the route's active version: the admin cutover if it
+// names a version the route still has, otherwise the configured one.
+func (r *route) activeVersion() string {
+	if sw, ok := loadVersionSwitch(r.versionKey); ok {
+		if _, exists := r.versions[sw.Active]; exists {
+			return sw.Active
+		}
+	}
+	return r.active
+}
+
+// defaultPool returns the route's default backends: those of the active
+// version for versioned routes.
+func (r *route) defaultPool() *backendPool {
+	if r.versions == nil {
+		return r.pool
+	}
+	return r.versions[r.activeVersion()]
+}
+
+// switchVersion makes version the active one of the route.
+func (r *route) switchVersion(version string) error {
+	if _, ok := r.versions[version]; !ok {
+		return fmt.Errorf("unknown version %q", version)
+	}
+	current := r.activeVersion()
+	if current == version {
+		return nil
+	}
+	updateVersionSwitch(r.versionKey, &versionSwitch{Active: version, Previous: current, Switched: time.Now()})
+	return nil
+}
+
+// rollback returns the route to the version active before the last cutover.
+func (r *route) rollback() (string, error) {
+	sw, ok := loadVersionSwitch(r.versionKey)
+	if !ok || sw.Previous == "" {
+		return "", errors.New("no previous version")
+	}
+	if err := r.switchVersion(sw.Previous); err != nil {
+		return "", err
+	}
+	return sw.Previous, nil
+}
+
+// routeVersions describes the versions of a route in admin API responses.
+type routeVersions struct {
+	Versions   map[string][]string `json:"versions"`
+	Configured string              `json:"configured"`
+	Active     string              `json:"active"`
+	Previous   string              `json:"previous,omitempty"`
+	Switched   time.Time           `json:"switched,omitzero"`
+}
+
+func (r *route) versionInfo() routeVersions {
+	info := routeVersions{
+		Versions:   make(map[string][]string, len(r.versions)),
+		Configured: r.active,
+		Active:     r.activeVersion(),
+	}
+	for name, pool := range r.versions {
+		info.Versions[name] = pool.addrs
+	}
+	if sw, ok := loadVersionSwitch(r.versionKey); ok && sw.Active == info.Active {
+		info.Previous, info.Switched = sw.Previous, sw.Switched
+	}
+	return info
+}
+
+// ServeAdmin switches versioned routes between their backend sets. Sessions
+// keep their backend; new connections use the active version.
+//
+//	GET    /                 versions of all versioned routes
+//	GET    /{sni}            versions of one route
+//	POST   /{sni}            {"active": "green"} - cut over to a version
+//	POST   /{sni}/rollback   return to the version active before the last cutover
+//	DELETE /{sni}            clear the cutover, revert to the configured version
+//	GET    /{sni}/queue      waiting room of a route
+func (h *DynamicHandler) ServeAdmin(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/")
+	if path == "" {
+		if r.Method != http.MethodGet {
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		all := make(map[string]routeVersions)
+		for sni, rt := range h.routes {
+			if rt.versions != nil {
+				all[sni] = rt.versionInfo()
+			}
+		}
+		writeAdminJSON(w, http.StatusOK, all)
+		return
+	}
+
+	sni, action, _ := strings.Cut(path, "/")
+	rt, ok := h.routes[sni]
+	if action == "queue" {
+		switch {
+		case !ok || rt.waiting == nil:
+			writeAdminError(w, http.StatusNotFound, "no waiting room for "+sni)
+		case r.Method != http.MethodGet:
+			writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		default:
+			writeAdminJSON(w, http.StatusOK, rt.waiting.stats(rt.versionKey, rt.freeSlots(), h.now()))
+		}
+		return
+	}
+	if !ok || rt.versions == nil {
+		writeAdminError(w, http.StatusNotFound, "no versioned route for "+sni)
+		return
+	}
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+	case action == "" && r.Method == http.MethodPost:
+		var req struct {
+			Active string `json:"active"`
+		}
+		if !readAdminJSON(w, r, &req) {
+			return
+		}
+		from := rt.activeVersion()
+		if err := rt.switchVersion(req.Active); err != nil {
+			writeAdminError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		sniRouterLog.Printf("%s: switched from version %s to %s via admin API", sni, from, req.Active)
+	case action == "rollback" && r.Method == http.MethodPost:
+		from := rt.activeVersion()
+		to, err := rt.rollback()
+		if err != nil {
+			writeAdminError(w, http.StatusConflict, err.Error())
+			return
+		}
+		sniRouterLog.Printf("%s: rolled back from version %s to %s via admin API", sni, from, to)
+	case action == "" && r.Method == http.MethodDelete:
+		updateVersionSwitch(rt.versionKey, nil)
+		sniRouterLog.Printf("%s: version cutover cleared, using configured version %s", sni, rt.active)
+	case action == "" || action == "rollback":
+		writeAdminError(w, http.StatusMethodNotAllowed, "method not allowed")
+		return
+	default:
+		writeAdminError(w, http.StatusNotFound, "not found")
+		return
+	}
+	writeAdminJSON(w, http.StatusOK, rt.versionInfo())
+}
+
+// parseVersions builds the backend pools of a versioned route.
+func parseVersions(cfg map[string][]backendEntry, active string) (map[string]*backendPool, error) {
+	if active == "" {
+		return nil, errors.New("versions require 'active'")
+	}
+	if _, ok := cfg[active]; !ok {
+		return nil, fmt.Errorf("active version %q not in versions %v", active, slices.Sorted(maps.Keys(cfg)))
+	}
+	versions := make(map[string]*backendPool, len(cfg))
+	for name, entries := range cfg {
+		pool, err := newBackendPool(entries)
+		if err != nil {
+			return nil, fmt.Errorf("version %s: %w", name, err)
+		}
+		versions[name] = pool
+	}
+	return versions, nil
+}
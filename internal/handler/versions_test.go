@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const versionedConfig = `{"routes": {"play.example.com": {
+	"versions": {"blue": ["10.0.0.1:5520"], "green": ["10.0.1.1:5520"]},
+	"active": "blue"
+}}}`
+
+// routeTo returns the backend the handler picks for sni.
+func routeTo(t *testing.T, h Handler, sni string, resumed string) string {
+	t.Helper()
+	ctx := &Context{Hello: &ClientHello{SNI: sni}}
+	if resumed != "" {
+		ctx.Set(ResumeKey, resumed)
+	}
+	if res := h.OnConnect(ctx); res.Action != Continue {
+		t.Fatalf("OnConnect: %v", res.Error)
+	}
+	return ctx.GetString("backend")
+}
+
+func serveVersions(h Handler, method, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.(AdminHandler).ServeAdmin(rec, httptest.NewRequest(method, path, strings.NewReader(body)))
+	return rec
+}
+
+func TestRouteVersions_Config(t *testing.T) {
+	for _, cfg := range []string{
+		`{"routes": {"a": {"versions": {"blue": ["x:1"]}}}}`,
+		`{"routes": {"a": {"versions": {"blue": ["x:1"]}, "active": "green"}}}`,
+		`{"routes": {"a": {"versions": {"blue": []}, "active": "blue"}}}`,
+		`{"routes": {"a": {"backends": ["x:1"], "versions": {"blue": ["y:1"]}, "active": "blue"}}}`,
+		`{"routes": {"a": {"backends": ["x:1"], "active": "blue"}}}`,
+	} {
+		if _, err := NewDynamicHandler(json.RawMessage(cfg)); err == nil {
+			t.Errorf("expected error for %s", cfg)
+		}
+	}
+}
+
+func TestRouteVersions_Cutover(t *testing.T) {
+	defer updateVersionSwitch("SNI play.example.com", nil)
+	h, err := NewDynamicHandler(json.RawMessage(versionedConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := routeTo(t, h, "play.example.com", ""); got != "10.0.0.1:5520" {
+		t.Fatalf("before cutover: %s", got)
+	}
+	if got := h.(BackendLister).Backends(); len(got) != 2 {
+		t.Errorf("Backends = %v, want both versions", got)
+	}
+
+	if rec := serveVersions(h, http.MethodPost, "/play.example.com", `{"active": "purple"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("unknown version: %d", rec.Code)
+	}
+	if rec := serveVersions(h, http.MethodPost, "/play.example.com/rollback", ""); rec.Code != http.StatusConflict {
+		t.Errorf("rollback without cutover: %d", rec.Code)
+	}
+	rec := serveVersions(h, http.MethodPost, "/play.example.com", `{"active": "green"}`)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"previous":"blue"`) {
+		t.Fatalf("cutover: %d %s", rec.Code, rec.Body)
+	}
+	if got := routeTo(t, h, "play.example.com", ""); got != "10.0.1.1:5520" {
+		t.Errorf("after cutover: %s", got)
+	}
+	// Resumed clients follow the cutover too
+	if got := routeTo(t, h, "play.example.com", "10.0.0.1:5520"); got != "10.0.1.1:5520" {
+		t.Errorf("resumed client after cutover: %s", got)
+	}
+
+	// The cutover survives a reload
+	reloaded, _ := NewDynamicHandler(json.RawMessage(versionedConfig))
+	if got := routeTo(t, reloaded, "play.example.com", ""); got != "10.0.1.1:5520" {
+		t.Errorf("after reload: %s", got)
+	}
+
+	if rec := serveVersions(h, http.MethodPost, "/play.example.com/rollback", ""); rec.Code != http.StatusOK {
+		t.Fatalf("rollback: %d %s", rec.Code, rec.Body)
+	}
+	if got := routeTo(t, h, "play.example.com", ""); got != "10.0.0.1:5520" {
+		t.Errorf("after rollback: %s", got)
+	}
+	serveVersions(h, http.MethodPost, "/play.example.com", `{"active": "green"}`)
+	if rec := serveVersions(h, http.MethodDelete, "/play.example.com", ""); rec.Code != http.StatusOK {
+		t.Fatalf("delete: %d", rec.Code)
+	}
+	if got := routeTo(t, h, "play.example.com", ""); got != "10.0.0.1:5520" {
+		t.Errorf("after clearing the cutover: %s", got)
+	}
+}
+
+func TestRouteVersions_Admin(t *testing.T) {
+	h, _ := NewDynamicHandler(json.RawMessage(`{"routes": {"plain.example.com": "x:1", "play.example.com": {
+		"versions": {"blue": ["10.0.0.1:5520"], "green": ["10.0.1.1:5520"]}, "active": "blue"}}}`))
+	rec := serveVersions(h, http.MethodGet, "/", "")
+	var all map[string]routeVersions
+	if err := json.Unmarshal(rec.Body.Bytes(), &all); err != nil || len(all) != 1 || all["play.example.com"].Active != "blue" {
+		t.Errorf("GET / = %s", rec.Body)
+	}
+	if rec := serveVersions(h, http.MethodGet, "/plain.example.com", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("unversioned route: %d", rec.Code)
+	}
+	if rec := serveVersions(h, http.MethodPut, "/play.example.com", "{}"); rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("PUT: %d", rec.Code)
+	}
+}
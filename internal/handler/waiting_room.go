@@ -0,0 +1,195 @@
+package handler
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// waitingRoomConfig queues clients of a route whose backends are all full.
+type waitingRoomConfig struct {
+	Max         int    `json:"max,omitempty"`          // Clients queued at once; more are refused (default: 10000)
+	RetryWindow int    `json:"retry_window,omitempty"` // Seconds a queued client may take to try again before losing its place (default: 30)
+	Reason      string `json:"reason,omitempty"`       // CONNECTION_CLOSE reason, followed by the queue position (default: "server full")
+}
+
+const (
+	defaultWaitingRoomMax   = 10000
+	defaultWaitingRetry     = 30 * time.Second
+	defaultWaitingRoomReply = "server full"
+)
+
+// waitingRoom is the validated waiting room of a route.
+type waitingRoom struct {
+	max    int
+	retry  time.Duration
+	reason string
+}
+
+func newWaitingRoom(cfg waitingRoomConfig) (*waitingRoom, error) {
+	if cfg.Max < 0 || cfg.RetryWindow < 0 {
+		return nil, errors.New("waiting_room: max and retry_window must be >= 0")
+	}
+	w := &waitingRoom{
+		max:    cmp.Or(cfg.Max, defaultWaitingRoomMax),
+		retry:  time.Duration(cfg.RetryWindow) * time.Second,
+		reason: cfg.Reason,
+	}
+	if w.retry == 0 {
+		w.retry = defaultWaitingRetry
+	}
+	if w.reason == "" {
+		w.reason = defaultWaitingRoomReply
+	}
+	return w, nil
+}
+
+// waitingQueue holds the clients waiting for a route, oldest first. Queues
+// are package-level by route key so clients keep their place across reloads.
+type waitingQueue struct {
+	mu      sync.Mutex
+	tickets []*waitingTicket
+
+	admitted atomic.Uint64 // Queued clients that got a backend
+	expired  atomic.Uint64 // Queued clients that did not retry in time
+	refused  atomic.Uint64 // Clients refused because the queue was full
+}
+
+// waitingTicket is a client's place in a queue. Clients are identified by IP,
+// since every retry may come from a new port.
+type waitingTicket struct {
+	client   string
+	since    time.Time
+	lastSeen time.Time
+}
+
+var waitingQueues sync.Map // route key -> *waitingQueue
+
+func waitingQueueFor(key string) *waitingQueue {
+	v, _ := waitingQueues.LoadOrStore(key, &waitingQueue{})
+	return v.(*waitingQueue)
+}
+
+// WaitingRoomStats describes a route's queue in admin API responses.
+type WaitingRoomStats struct {
+	Waiting  int    `json:"waiting"`
+	Max      int    `json:"max"`
+	Free     int    `json:"free"`     // Free session slots on the route's backends, -1 if any is uncapped
+	Oldest   string `json:"oldest"`   // Time the first client has been waiting
+	Admitted uint64 `json:"admitted"` // Queued clients that got a backend
+	Expired  uint64 `json:"expired"`  // Queued clients that did not retry in time
+	Refused  uint64 `json:"refused"`  // Clients refused because the queue was full
+}
+
+// admit lets a client of route key through when nobody ahead of it in the
+// queue can take the free slots, calling pick for the backend. Otherwise, or
+// when pick finds every backend full, the client is queued and refused with
+// its position so it retries. free is the number of free backend slots.
+func (w *waitingRoom) admit(ctx *Context, key string, free int, now time.Time, pick func() (string, error)) (string, error) {
+	client := waitingClient(ctx)
+	q := waitingQueueFor(key)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire(now, w.retry)
+
+	pos := slices.IndexFunc(q.tickets, func(t *waitingTicket) bool { return t.client == client })
+	if client == "" || (pos >= 0 && pos < free) || (pos < 0 && len(q.tickets) < free) {
+		backend, err := pick()
+		if !errors.Is(err, errBackendsFull) {
+			if err == nil && pos >= 0 {
+				q.tickets = slices.Delete(q.tickets, pos, pos+1)
+				q.admitted.Add(1)
+			}
+			return backend, err
+		}
+	}
+	if client == "" {
+		return "", errBackendsFull
+	}
+	if pos < 0 {
+		if len(q.tickets) >= w.max {
+			q.refused.Add(1)
+			return "", errors.New("all backends full, waiting room full")
+		}
+		q.tickets = append(q.tickets, &waitingTicket{client: client, since: now})
+		pos = len(q.tickets) - 1
+	}
+	q.tickets[pos].lastSeen = now
+	reason := fmt.Sprintf("%s, queue position %d of %d", w.reason, pos+1, len(q.tickets))
+	ctx.Refuse(connectionRefused, reason)
+	return "", fmt.Errorf("queued: %s", reason)
+}
+
+// expire removes clients that did not retry within the retry window.
+func (q *waitingQueue) expire(now time.Time, retry time.Duration) {
+	n := len(q.tickets)
+	q.tickets = slices.DeleteFunc(q.tickets, func(t *waitingTicket) bool { return now.Sub(t.lastSeen) > retry })
+	q.expired.Add(uint64(n - len(q.tickets)))
+}
+
+func (w *waitingRoom) stats(key string, free int, now time.Time) WaitingRoomStats {
+	q := waitingQueueFor(key)
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.expire(now, w.retry)
+	st := WaitingRoomStats{
+		Waiting:  len(q.tickets),
+		Max:      w.max,
+		Free:     free,
+		Admitted: q.admitted.Load(),
+		Expired:  q.expired.Load(),
+		Refused:  q.refused.Load(),
+	}
+	if st.Free == unlimitedSlots {
+		st.Free = -1
+	}
+	if len(q.tickets) > 0 {
+		st.Oldest = now.Sub(q.tickets[0].since).Round(time.Second).String()
+	}
+	return st
+}
+
+// waitingClient identifies the client of ctx in a queue.
+func waitingClient(ctx *Context) string {
+	if client := ctx.OriginalClientAddr(); client != nil {
+		return client.IP.String()
+	}
+	return ""
+}
+
+// unlimitedSlots is the free slot count of routes with an uncapped backend.
+const unlimitedSlots = int(^uint(0) >> 1)
+
+// freeSlots returns the free session slots on the route's backends that are
+// not draining. Routes with an uncapped backend are never full.
+func (r *route) freeSlots() int {
+	free := 0
+	seen := make(map[string]bool)
+	for _, pool := range r.pools() {
+		for i, addr := range pool.addrs {
+			if seen[addr] || Draining(addr) || (pool.cumulative != nil && pool.weight(i) == 0) {
+				continue
+			}
+			seen[addr] = true
+			if pool.caps == nil || pool.caps[i] == 0 {
+				return unlimitedSlots
+			}
+			free += max(pool.caps[i]-backendSessionCount(addr), 0)
+		}
+	}
+	return free
+}
+
+// connect picks a backend for ctx, through the waiting room if the route has one.
+func (r *route) connect(ctx *Context, now time.Time) (string, error) {
+	if r.waiting == nil {
+		return r.pick(ctx, now)
+	}
+	return r.waiting.admit(ctx, r.versionKey, r.freeSlots(), now, func() (string, error) {
+		return r.pick(ctx, now)
+	})
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWaitingRoom(t *testing.T) {
+	config := `{"routes": {"queue.test": {"backends": [{"addr": "wr1:443", "max_sessions": 1}], "waiting_room": {"retry_window": 10}}}}`
+	raw, err := NewDynamicHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := raw.(*DynamicHandler)
+	now := time.Now()
+	h.now = func() time.Time { return now }
+
+	var reasons []string
+	connect := func(ip byte) (*Context, Result) {
+		ctx := &Context{ClientAddr: &net.UDPAddr{IP: net.IPv4(192, 0, 2, ip), Port: 4000}, Hello: &ClientHello{SNI: "queue.test"}}
+		ctx.SendConnectionClose = func(code uint64, reason string) error {
+			reasons = append(reasons, reason)
+			return nil
+		}
+		return ctx, h.OnConnect(ctx)
+	}
+
+	first, res := connect(1)
+	if res.Action != Continue {
+		t.Fatalf("first client: %v", res.Error)
+	}
+	for _, ip := range []byte{2, 3, 2} {
+		if _, res := connect(ip); res.Action != Drop {
+			t.Fatalf("client %d with a full backend = %v", ip, res.Action)
+		}
+	}
+	want := []string{"server full, queue position 1 of 1", "server full, queue position 2 of 2", "server full, queue position 1 of 2"}
+	if strings.Join(reasons, "|") != strings.Join(want, "|") {
+		t.Errorf("reasons = %q, want %q", reasons, want)
+	}
+
+	// A freed slot goes to the head of the queue, not to a newcomer
+	h.OnDisconnect(first)
+	if _, res := connect(4); res.Action != Drop {
+		t.Error("newcomer took the slot of a queued client")
+	}
+	if _, res := connect(3); res.Action != Drop {
+		t.Error("second in line took the slot of the first")
+	}
+	if ctx, res := connect(2); res.Action != Continue || ctx.GetString("backend") != "wr1:443" {
+		t.Fatalf("head of the queue = %v: %v", res.Action, res.Error)
+	}
+
+	// Clients that stop retrying lose their place
+	now = now.Add(time.Minute)
+	rec := httptest.NewRecorder()
+	h.ServeAdmin(rec, httptest.NewRequest(http.MethodGet, "/queue.test/queue", nil))
+	var st WaitingRoomStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Waiting != 0 || st.Admitted != 1 || st.Expired != 2 || st.Free != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
+
+func TestWaitingRoom_Config(t *testing.T) {
+	tests := []struct {
+		config  string
+		wantErr string
+	}{
+		{`{"routes": {"x.com": {"backends": ["b1:443"], "waiting_room": {}}}}`, "requires max_sessions"},
+		{`{"routes": {"x.com": {"backends": [{"addr": "b1:443", "max_sessions": 5}], "waiting_room": {"max": -1}}}}`, "must be >= 0"},
+	}
+	for _, tt := range tests {
+		if _, err := NewDynamicHandler(json.RawMessage(tt.config)); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.config, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,251 @@
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"golang.org/x/crypto/blake2s"
+
+	"quic-relay/internal/logging"
+	"quic-relay/internal/privacy"
+)
+
+var wireguardLog = logging.ForHandler("wireguard")
+
+func init() {
+	Register("wireguard", NewWireGuardHandler)
+}
+
+// WireGuard message types and sizes (little-endian header: type, 3 reserved bytes).
+const (
+	wgHandshakeInitiation = 1
+	wgHandshakeResponse   = 2
+	wgCookieReply         = 3
+	wgTransportData       = 4
+
+	wgInitiationSize = 148
+	wgResponseSize   = 92
+	wgMinDataSize    = 32 // Header (16) + empty payload tag (16)
+
+	wgMac1Offset = 116 // mac1 covers the initiation up to here
+)
+
+// wgLabelMac1 is the label hashed with the responder's public key to derive the mac1 key.
+var wgLabelMac1 = []byte("mac1----")
+
+// WireGuardPeerConfig maps a WireGuard server identity to its backend.
+type WireGuardPeerConfig struct {
+	Name      string `json:"name,omitempty"` // Tenant label for logs
+	PublicKey string `json:"public_key"`     // Server public key (base64, as in wg(8))
+	Backend   string `json:"backend"`        // Server address
+}
+
+// WireGuardConfig is the configuration for the wireguard handler.
+type WireGuardConfig struct {
+	Protocol string                `json:"protocol,omitempty"` // Protocol rule name (default: "wireguard")
+	Servers  []WireGuardPeerConfig `json:"servers"`
+	Default  string                `json:"default,omitempty"` // Backend for initiations matching no server
+}
+
+// wgServer is a backend identified by its mac1 key.
+type wgServer struct {
+	name    string
+	backend string
+	mac1Key [blake2s.Size]byte
+}
+
+// wgIndex is a learned server session index.
+type wgIndex struct {
+	backend string
+	owner   *Context // Flow that currently uses the index
+}
+
+// WireGuardHandler routes WireGuard flows to per-tenant servers behind one port.
+// Handshake initiations are matched to a server by their mac1, which is keyed
+// with the server's public key. Server session indices learned from handshake
+// responses let data packets from a roamed client address find their server.
+type WireGuardHandler struct {
+	protocol string
+	servers  []wgServer
+	fallback string
+
+	mu      sync.Mutex
+	indices map[uint32]*wgIndex // Server receiver index -> backend
+}
+
+// NewWireGuardHandler creates a new wireguard handler.
+func NewWireGuardHandler(raw json.RawMessage) (Handler, error) {
+	var cfg WireGuardConfig
+	if len(raw) > 0 {
+		if err := json.Unmarshal(raw, &cfg); err != nil {
+			return nil, fmt.Errorf("invalid wireguard config: %w", err)
+		}
+	}
+	if len(cfg.Servers) == 0 && cfg.Default == "" {
+		return nil, errors.New("wireguard: requires 'servers' or 'default'")
+	}
+	if cfg.Protocol == "" {
+		cfg.Protocol = "wireguard"
+	}
+
+	h := &WireGuardHandler{
+		protocol: cfg.Protocol,
+		fallback: cfg.Default,
+		indices:  make(map[uint32]*wgIndex),
+	}
+	for i, s := range cfg.Servers {
+		pub, err := base64.StdEncoding.DecodeString(s.PublicKey)
+		if err != nil || len(pub) != 32 {
+			return nil, fmt.Errorf("wireguard: server %d: invalid public_key", i)
+		}
+		if s.Backend == "" {
+			return nil, fmt.Errorf("wireguard: server %d: missing backend", i)
+		}
+		name := s.Name
+		if name == "" {
+			name = s.Backend
+		}
+		h.servers = append(h.servers, wgServer{name: name, backend: s.Backend, mac1Key: wgMac1Key(pub)})
+	}
+	return h, nil
+}
+
+// wgMac1Key derives the mac1 key of a responder: HASH(LABEL_MAC1 || public key).
+func wgMac1Key(publicKey []byte) [blake2s.Size]byte {
+	return blake2s.Sum256(append(append([]byte{}, wgLabelMac1...), publicKey...))
+}
+
+// wgMac1 computes mac1 over an initiation: MAC(key, msg[:116]).
+func wgMac1(key [blake2s.Size]byte, msg []byte) []byte {
+	mac, _ := blake2s.New128(key[:]) // Only fails for keys longer than 32 bytes
+	mac.Write(msg[:wgMac1Offset])
+	return mac.Sum(nil)
+}
+
+// wgMessageType returns the message type, or 0 if the header is malformed.
+func wgMessageType(packet []byte) byte {
+	if len(packet) < 4 || packet[1] != 0 || packet[2] != 0 || packet[3] != 0 {
+		return 0
+	}
+	return packet[0]
+}
+
+// Name returns the handler name.
+func (h *WireGuardHandler) Name() string {
+	return "wireguard"
+}
+
+// Backends lists the WireGuard servers and the fallback.
+func (h *WireGuardHandler) Backends() []string {
+	var addrs []string
+	for _, s := range h.servers {
+		addrs = append(addrs, s.backend)
+	}
+	if h.fallback != "" {
+		addrs = append(addrs, h.fallback)
+	}
+	return addrs
+}
+
+// OnConnect selects the server for a new WireGuard flow.
+func (h *WireGuardHandler) OnConnect(ctx *Context) Result {
+	if ctx.Protocol != h.protocol {
+		return Result{Action: Continue}
+	}
+
+	pkt := ctx.InitialPacket
+	var backend string
+	switch wgMessageType(pkt) {
+	case wgHandshakeInitiation:
+		if len(pkt) != wgInitiationSize {
+			return Result{Action: Drop, Error: errors.New("wireguard: malformed initiation")}
+		}
+		server := h.matchServer(pkt)
+		switch {
+		case server != nil:
+			wireguardLog.Debugf("%s: initiation for %s", privacy.Addr(ctx.ClientAddr), server.name)
+			backend = server.backend
+		case h.fallback != "":
+			backend = h.fallback
+		default:
+			return Result{Action: Drop, Error: errors.New("wireguard: initiation matches no server")}
+		}
+
+	case wgTransportData:
+		// A known session from a new client address (roaming or NAT rebinding)
+		if len(pkt) < wgMinDataSize {
+			return Result{Action: Drop, Error: errors.New("wireguard: malformed data packet")}
+		}
+		idx := binary.LittleEndian.Uint32(pkt[4:8])
+		h.mu.Lock()
+		entry, ok := h.indices[idx]
+		if ok {
+			entry.owner = ctx
+			backend = entry.backend
+		}
+		h.mu.Unlock()
+		if !ok {
+			return Result{Action: Drop, Error: fmt.Errorf("wireguard: unknown receiver index %08x", idx)}
+		}
+		wireguardLog.Debugf("%s: resumed session %08x", privacy.Addr(ctx.ClientAddr), idx)
+
+	default:
+		return Result{Action: Drop, Error: errors.New("wireguard: flow must start with an initiation or data packet")}
+	}
+
+	ctx.Set("backend", backend)
+
+	// Learn server indices from handshake responses
+	next := ctx.OnServerPacket
+	ctx.OnServerPacket = func(packet []byte) {
+		if wgMessageType(packet) == wgHandshakeResponse && len(packet) == wgResponseSize {
+			h.learnIndex(binary.LittleEndian.Uint32(packet[4:8]), backend, ctx)
+		}
+		if next != nil {
+			next(packet)
+		}
+	}
+	return Result{Action: Continue}
+}
+
+// matchServer returns the server whose mac1 key authenticates the initiation.
+func (h *WireGuardHandler) matchServer(pkt []byte) *wgServer {
+	mac1 := pkt[wgMac1Offset : wgMac1Offset+16]
+	for i := range h.servers {
+		if subtle.ConstantTimeCompare(wgMac1(h.servers[i].mac1Key, pkt), mac1) == 1 {
+			return &h.servers[i]
+		}
+	}
+	return nil
+}
+
+// learnIndex records the server's sender index of a handshake response.
+func (h *WireGuardHandler) learnIndex(idx uint32, backend string, owner *Context) {
+	h.mu.Lock()
+	h.indices[idx] = &wgIndex{backend: backend, owner: owner}
+	h.mu.Unlock()
+}
+
+// OnPacket passes through.
+func (h *WireGuardHandler) OnPacket(ctx *Context, packet []byte, dir Direction) Result {
+	return Result{Action: Continue}
+}
+
+// OnDisconnect forgets the indices owned by the flow.
+func (h *WireGuardHandler) OnDisconnect(ctx *Context) {
+	if ctx.Protocol != h.protocol {
+		return
+	}
+	h.mu.Lock()
+	for idx, entry := range h.indices {
+		if entry.owner == ctx {
+			delete(h.indices, idx)
+		}
+	}
+	h.mu.Unlock()
+}
@@ -0,0 +1,142 @@
+package handler
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+var (
+	wgKeyA = base64.StdEncoding.EncodeToString(make([]byte, 32))
+	wgKeyB = base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+)
+
+// buildWGInitiation builds an initiation addressed to publicKey (fields other than mac1 are filler).
+func buildWGInitiation(t *testing.T, publicKey string, sender uint32) []byte {
+	t.Helper()
+	pub, err := base64.StdEncoding.DecodeString(publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pkt := make([]byte, wgInitiationSize)
+	pkt[0] = wgHandshakeInitiation
+	binary.LittleEndian.PutUint32(pkt[4:8], sender)
+	for i := 8; i < wgMac1Offset; i++ {
+		pkt[i] = byte(i)
+	}
+	copy(pkt[wgMac1Offset:], wgMac1(wgMac1Key(pub), pkt))
+	return pkt
+}
+
+func newTestWireGuardHandler(t *testing.T, config string) *WireGuardHandler {
+	t.Helper()
+	h, err := NewWireGuardHandler(json.RawMessage(config))
+	if err != nil {
+		t.Fatalf("failed to create handler: %v", err)
+	}
+	return h.(*WireGuardHandler)
+}
+
+func TestNewWireGuardHandler_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		errMsg string
+	}{
+		{"empty", `{}`, "requires 'servers' or 'default'"},
+		{"bad key", `{"servers": [{"public_key": "abc", "backend": "b:1"}]}`, "invalid public_key"},
+		{"no backend", `{"servers": [{"public_key": "` + wgKeyA + `"}]}`, "missing backend"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewWireGuardHandler(json.RawMessage(tt.config))
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+}
+
+func TestWireGuardHandler_RoutesByServerKey(t *testing.T) {
+	h := newTestWireGuardHandler(t, `{"servers": [
+		{"name": "tenant-a", "public_key": "`+wgKeyA+`", "backend": "a:51820"},
+		{"name": "tenant-b", "public_key": "`+wgKeyB+`", "backend": "b:51820"}
+	]}`)
+
+	for key, want := range map[string]string{wgKeyA: "a:51820", wgKeyB: "b:51820"} {
+		ctx := &Context{Protocol: "wireguard", InitialPacket: buildWGInitiation(t, key, 1)}
+		if result := h.OnConnect(ctx); result.Action != Continue {
+			t.Fatalf("expected Continue, got %v (%v)", result.Action, result.Error)
+		}
+		if got := ctx.GetString("backend"); got != want {
+			t.Errorf("backend = %q, want %q", got, want)
+		}
+	}
+
+	// Corrupted mac1 matches nobody
+	pkt := buildWGInitiation(t, wgKeyA, 1)
+	pkt[wgMac1Offset] ^= 0xff
+	if result := h.OnConnect(&Context{Protocol: "wireguard", InitialPacket: pkt}); result.Action != Drop {
+		t.Errorf("expected Drop, got %v", result.Action)
+	}
+
+	// Other protocols and QUIC pass through
+	if result := h.OnConnect(&Context{}); result.Action != Continue {
+		t.Errorf("expected Continue for QUIC, got %v", result.Action)
+	}
+}
+
+func TestWireGuardHandler_Default(t *testing.T) {
+	h := newTestWireGuardHandler(t, `{"default": "fallback:51820"}`)
+	ctx := &Context{Protocol: "wireguard", InitialPacket: buildWGInitiation(t, wgKeyA, 1)}
+	if result := h.OnConnect(ctx); result.Action != Continue {
+		t.Fatalf("expected Continue, got %v", result.Action)
+	}
+	if got := ctx.GetString("backend"); got != "fallback:51820" {
+		t.Errorf("backend = %q", got)
+	}
+}
+
+func TestWireGuardHandler_Roaming(t *testing.T) {
+	h := newTestWireGuardHandler(t, `{"servers": [{"public_key": "`+wgKeyB+`", "backend": "b:51820"}]}`)
+
+	// Handshake on the first address; the server answers with its index
+	first := &Context{Protocol: "wireguard", InitialPacket: buildWGInitiation(t, wgKeyB, 7)}
+	if result := h.OnConnect(first); result.Action != Continue {
+		t.Fatalf("expected Continue, got %v", result.Action)
+	}
+	resp := make([]byte, wgResponseSize)
+	resp[0] = wgHandshakeResponse
+	binary.LittleEndian.PutUint32(resp[4:8], 0xabcd)
+	binary.LittleEndian.PutUint32(resp[8:12], 7)
+	first.NotifyServerPacket(resp)
+
+	// Data from a new address resumes on the same server
+	data := make([]byte, wgMinDataSize)
+	data[0] = wgTransportData
+	binary.LittleEndian.PutUint32(data[4:8], 0xabcd)
+	roamed := &Context{Protocol: "wireguard", InitialPacket: data}
+	if result := h.OnConnect(roamed); result.Action != Continue {
+		t.Fatalf("expected Continue, got %v (%v)", result.Action, result.Error)
+	}
+	if got := roamed.GetString("backend"); got != "b:51820" {
+		t.Errorf("backend = %q", got)
+	}
+
+	// The old flow no longer owns the index
+	h.OnDisconnect(first)
+	if len(h.indices) != 1 {
+		t.Fatalf("index dropped with old flow")
+	}
+	h.OnDisconnect(roamed)
+	if len(h.indices) != 0 {
+		t.Errorf("index not released")
+	}
+
+	// Unknown index is refused
+	if result := h.OnConnect(&Context{Protocol: "wireguard", InitialPacket: data}); result.Action != Drop {
+		t.Errorf("expected Drop, got %v", result.Action)
+	}
+}
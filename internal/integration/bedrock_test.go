@@ -0,0 +1,235 @@
+package integration
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// A Minecraft Bedrock style RakNet exchange: unconnected ping and pong, the
+// two open connection requests, then connected frame sets, which carry no
+// marker a protocol rule could match on. Relays route Bedrock by listen port.
+
+const (
+	raknetPing     = 0x01
+	raknetPong     = 0x1c
+	raknetOpenReq1 = 0x05
+	raknetOpenRep1 = 0x06
+	raknetOpenReq2 = 0x07
+	raknetOpenRep2 = 0x08
+	raknetFrameSet = 0x84
+
+	raknetProtocol = 11
+	raknetMTU      = 1200
+)
+
+var raknetMagic = []byte{
+	0x00, 0xff, 0xff, 0x00, 0xfe, 0xfe, 0xfe, 0xfe,
+	0xfd, 0xfd, 0xfd, 0xfd, 0x12, 0x34, 0x56, 0x78,
+}
+
+// BedrockServer simulates a Bedrock server: it answers pings with its MOTD,
+// accepts RakNet connections and echoes the payload of frame sets. It
+// records the clients that completed the open connection handshake.
+type BedrockServer struct {
+	Addr string
+	MOTD string
+
+	conn    *net.UDPConn
+	guid    uint64
+	mu      sync.Mutex
+	clients map[string]uint64 // Client address -> client GUID
+}
+
+// NewBedrockServer starts a simulated Bedrock server.
+func NewBedrockServer(t testing.TB, motd string) *BedrockServer {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	s := &BedrockServer{
+		Addr:    conn.LocalAddr().String(),
+		MOTD:    motd,
+		conn:    conn,
+		guid:    uint64(time.Now().UnixNano()),
+		clients: make(map[string]uint64),
+	}
+	go s.serve()
+	return s
+}
+
+// Clients returns the GUIDs of connected clients, by address as seen by the
+// server.
+func (s *BedrockServer) Clients() map[string]uint64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	clients := make(map[string]uint64, len(s.clients))
+	for addr, guid := range s.clients {
+		clients[addr] = guid
+	}
+	return clients
+}
+
+func (s *BedrockServer) serve() {
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := s.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if reply := s.handle(from, buf[:n]); reply != nil {
+			s.conn.WriteToUDP(reply, from)
+		}
+	}
+}
+
+func (s *BedrockServer) handle(from *net.UDPAddr, p []byte) []byte {
+	switch {
+	case len(p) >= 33 && p[0] == raknetPing:
+		status := fmt.Sprintf("MCPE;%s;0;1.0;0;10;%d;sim;Survival;1;0;0;", s.MOTD, s.guid)
+		return pong(p[1:9], s.guid, status)
+	case len(p) >= 18 && p[0] == raknetOpenReq1 && bytes.Equal(p[1:17], raknetMagic):
+		reply := append([]byte{raknetOpenRep1}, raknetMagic...)
+		reply = binary.BigEndian.AppendUint64(reply, s.guid)
+		reply = append(reply, 0) // No security
+		return binary.BigEndian.AppendUint16(reply, uint16(min(len(p)+28, raknetMTU)))
+	case len(p) >= 34 && p[0] == raknetOpenReq2 && bytes.Equal(p[1:17], raknetMagic):
+		s.mu.Lock()
+		s.clients[from.String()] = binary.BigEndian.Uint64(p[len(p)-8:])
+		s.mu.Unlock()
+		reply := append([]byte{raknetOpenRep2}, raknetMagic...)
+		reply = binary.BigEndian.AppendUint64(reply, s.guid)
+		reply = appendRakNetAddr(reply, from)
+		reply = binary.BigEndian.AppendUint16(reply, raknetMTU)
+		return append(reply, 0) // No encryption
+	case len(p) > 4 && p[0] == raknetFrameSet:
+		return p
+	}
+	return nil
+}
+
+func pong(pingTime []byte, guid uint64, status string) []byte {
+	b := append([]byte{raknetPong}, pingTime...)
+	b = binary.BigEndian.AppendUint64(b, guid)
+	b = append(b, raknetMagic...)
+	b = binary.BigEndian.AppendUint16(b, uint16(len(status)))
+	return append(b, status...)
+}
+
+// appendRakNetAddr appends an IPv4 system address, with the bytes of the
+// address inverted as RakNet does.
+func appendRakNetAddr(b []byte, addr *net.UDPAddr) []byte {
+	b = append(b, 4)
+	for _, c := range addr.IP.To4() {
+		b = append(b, ^c)
+	}
+	return binary.BigEndian.AppendUint16(b, uint16(addr.Port))
+}
+
+// BedrockClient simulates a Bedrock client talking to addr.
+type BedrockClient struct {
+	GUID uint64
+	conn *net.UDPConn
+}
+
+// NewBedrockClient opens a client socket to addr, closed when the test ends.
+func NewBedrockClient(t testing.TB, addr string) *BedrockClient {
+	t.Helper()
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := net.DialUDP("udp", nil, raddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return &BedrockClient{GUID: uint64(time.Now().UnixNano()), conn: conn}
+}
+
+// Addr returns the client's local address.
+func (c *BedrockClient) Addr() string {
+	return c.conn.LocalAddr().String()
+}
+
+// Ping sends an unconnected ping and returns the status string of the pong.
+func (c *BedrockClient) Ping() (string, error) {
+	ping := binary.BigEndian.AppendUint64([]byte{raknetPing}, uint64(time.Now().UnixMilli()))
+	ping = append(ping, raknetMagic...)
+	ping = binary.BigEndian.AppendUint64(ping, c.GUID)
+	reply, err := c.exchange(ping, raknetPong)
+	if err != nil {
+		return "", err
+	}
+	if len(reply) < 35 {
+		return "", errors.New("short pong")
+	}
+	n := int(binary.BigEndian.Uint16(reply[33:35]))
+	if len(reply) < 35+n {
+		return "", errors.New("short pong status")
+	}
+	return string(reply[35 : 35+n]), nil
+}
+
+// Connect runs the open connection handshake with the server behind addr.
+func (c *BedrockClient) Connect(server string) error {
+	req1 := append([]byte{raknetOpenReq1}, raknetMagic...)
+	req1 = append(req1, raknetProtocol)
+	req1 = append(req1, make([]byte, raknetMTU-28-len(req1))...) // MTU discovery padding
+	if _, err := c.exchange(req1, raknetOpenRep1); err != nil {
+		return fmt.Errorf("open connection request 1: %w", err)
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return err
+	}
+	req2 := append([]byte{raknetOpenReq2}, raknetMagic...)
+	req2 = appendRakNetAddr(req2, addr)
+	req2 = binary.BigEndian.AppendUint16(req2, raknetMTU)
+	req2 = binary.BigEndian.AppendUint64(req2, c.GUID)
+	if _, err := c.exchange(req2, raknetOpenRep2); err != nil {
+		return fmt.Errorf("open connection request 2: %w", err)
+	}
+	return nil
+}
+
+// Send sends payload in a frame set with sequence number seq and returns
+// the frame set the server echoed.
+func (c *BedrockClient) Send(seq uint32, payload []byte) ([]byte, error) {
+	frame := []byte{raknetFrameSet, byte(seq), byte(seq >> 8), byte(seq >> 16)}
+	reply, err := c.exchange(append(frame, payload...), raknetFrameSet)
+	if err != nil {
+		return nil, err
+	}
+	return reply[4:], nil
+}
+
+// exchange sends p and waits for a datagram starting with want, skipping
+// others. Lost datagrams are retried, as RakNet clients do.
+func (c *BedrockClient) exchange(p []byte, want byte) ([]byte, error) {
+	buf := make([]byte, 1500)
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err := c.conn.Write(p); err != nil {
+			return nil, err
+		}
+		c.conn.SetReadDeadline(time.Now().Add(time.Second))
+		for {
+			n, err := c.conn.Read(buf)
+			if err != nil {
+				break
+			}
+			if n > 0 && buf[0] == want {
+				return append([]byte(nil), buf[:n]...), nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no reply 0x%02x", want)
+}
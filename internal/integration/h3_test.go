@@ -0,0 +1,503 @@
+package integration
+
+import (
+	"bufio"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+	"github.com/quic-go/quic-go/quicvarint"
+	"golang.org/x/net/http2/hpack"
+)
+
+// A minimal HTTP/3 (RFC 9114): GET requests without a body, no server push,
+// and QPACK without a dynamic table, which peers may not use since both
+// sides advertise a table capacity of 0. The transport is real quic-go with
+// ALPN "h3", so the relay sees the same handshakes as from browsers.
+
+const (
+	h3FrameData     = 0x00
+	h3FrameHeaders  = 0x01
+	h3FrameSettings = 0x04
+
+	h3StreamControl = 0x00
+)
+
+// H3Request is a request an H3Backend received.
+type H3Request struct {
+	Method    string
+	Authority string
+	Path      string
+	UserAgent string
+	SNI       string // Server name of the connection
+}
+
+// H3Backend is an HTTP/3 server on localhost. It answers every request with
+// 200 and "<name> <path>" and records it. It is closed when the test ends.
+type H3Backend struct {
+	Name string
+	Addr string
+
+	mu       sync.Mutex
+	requests []H3Request
+}
+
+// NewH3Backend starts an HTTP/3 backend.
+func NewH3Backend(t testing.TB, name string) *H3Backend {
+	t.Helper()
+	tlsConf := &tls.Config{
+		Certificates: []tls.Certificate{selfSigned(t)},
+		NextProtos:   []string{"h3"},
+	}
+	ln, err := quic.ListenAddr("127.0.0.1:0", tlsConf, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	b := &H3Backend{Name: name, Addr: ln.Addr().String()}
+	go func() {
+		for {
+			conn, err := ln.Accept(context.Background())
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+// Requests returns the requests received so far.
+func (b *H3Backend) Requests() []H3Request {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]H3Request(nil), b.requests...)
+}
+
+func (b *H3Backend) serve(conn *quic.Conn) {
+	if err := openControlStream(conn); err != nil {
+		return
+	}
+	go discardUniStreams(conn)
+	for {
+		s, err := conn.AcceptStream(conn.Context())
+		if err != nil {
+			return
+		}
+		go b.serveRequest(conn, s)
+	}
+}
+
+func (b *H3Backend) serveRequest(conn *quic.Conn, s *quic.Stream) {
+	defer s.Close()
+	fields, _, err := readMessage(s)
+	if err != nil {
+		s.CancelRead(0x10e) // H3_MESSAGE_ERROR
+		return
+	}
+	req := H3Request{
+		Method:    fields[":method"],
+		Authority: fields[":authority"],
+		Path:      fields[":path"],
+		UserAgent: fields["user-agent"],
+		SNI:       conn.ConnectionState().TLS.ServerName,
+	}
+	b.mu.Lock()
+	b.requests = append(b.requests, req)
+	b.mu.Unlock()
+
+	body := b.Name + " " + req.Path
+	headers := encodeFields([][2]string{{":status", "200"}, {"content-type", "text/plain"}, {"content-length", fmt.Sprint(len(body))}})
+	w := bufio.NewWriter(s)
+	writeFrame(w, h3FrameHeaders, headers)
+	writeFrame(w, h3FrameData, []byte(body))
+	w.Flush()
+}
+
+// H3Response is the response to an H3Get.
+type H3Response struct {
+	Status string
+	Body   string
+}
+
+// H3Get connects to addr with the server name of url's host and GETs it.
+// Certificates are not verified.
+func H3Get(addr, url string) (*H3Response, error) {
+	authority, path, _ := strings.Cut(strings.TrimPrefix(url, "https://"), "/")
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := quic.DialAddr(ctx, addr, &tls.Config{
+		ServerName:         strings.Split(authority, ":")[0],
+		NextProtos:         []string{"h3"},
+		InsecureSkipVerify: true,
+	}, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.CloseWithError(0x100, "") // H3_NO_ERROR
+
+	if err := openControlStream(conn); err != nil {
+		return nil, err
+	}
+	go discardUniStreams(conn)
+	s, err := conn.OpenStreamSync(ctx)
+	if err != nil {
+		return nil, err
+	}
+	headers := encodeFields([][2]string{
+		{":method", "GET"},
+		{":scheme", "https"},
+		{":authority", authority},
+		{":path", "/" + path},
+		{"user-agent", "quic-relay-integration"},
+	})
+	w := bufio.NewWriter(s)
+	writeFrame(w, h3FrameHeaders, headers)
+	if err := w.Flush(); err != nil {
+		return nil, err
+	}
+	s.Close()
+
+	s.SetReadDeadline(time.Now().Add(5 * time.Second))
+	fields, body, err := readMessage(s)
+	if err != nil {
+		return nil, err
+	}
+	return &H3Response{Status: fields[":status"], Body: string(body)}, nil
+}
+
+// openControlStream opens the control stream with an empty SETTINGS frame,
+// which leaves the QPACK dynamic table disabled.
+func openControlStream(conn *quic.Conn) error {
+	s, err := conn.OpenUniStream()
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(s)
+	w.Write(quicvarint.Append(nil, h3StreamControl))
+	writeFrame(w, h3FrameSettings, nil)
+	return w.Flush()
+}
+
+// discardUniStreams reads the peer's control and QPACK streams, which carry
+// nothing this implementation uses.
+func discardUniStreams(conn *quic.Conn) {
+	for {
+		s, err := conn.AcceptUniStream(conn.Context())
+		if err != nil {
+			return
+		}
+		go io.Copy(io.Discard, s)
+	}
+}
+
+func writeFrame(w *bufio.Writer, typ uint64, payload []byte) {
+	w.Write(quicvarint.Append(nil, typ))
+	w.Write(quicvarint.Append(nil, uint64(len(payload))))
+	w.Write(payload)
+}
+
+// readMessage reads the HEADERS and DATA frames of a request or response
+// until the stream ends. Unknown frame types are skipped.
+func readMessage(s io.Reader) (map[string]string, []byte, error) {
+	r := quicvarint.NewReader(s)
+	var fields map[string]string
+	var body []byte
+	for {
+		typ, err := quicvarint.Read(r)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		length, err := quicvarint.Read(r)
+		if err != nil {
+			return nil, nil, err
+		}
+		if length > 1<<20 {
+			return nil, nil, fmt.Errorf("frame of %d bytes", length)
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, nil, err
+		}
+		switch typ {
+		case h3FrameHeaders:
+			if fields != nil {
+				continue // Trailers
+			}
+			if fields, err = decodeFields(payload); err != nil {
+				return nil, nil, err
+			}
+		case h3FrameData:
+			body = append(body, payload...)
+		}
+	}
+	if fields == nil {
+		return nil, nil, errors.New("no HEADERS frame")
+	}
+	return fields, body, nil
+}
+
+// encodeFields encodes a QPACK field section of literals with literal names.
+func encodeFields(fields [][2]string) []byte {
+	b := []byte{0, 0} // Required Insert Count and Delta Base
+	for _, f := range fields {
+		b = appendPrefixInt(b, 0x20, 3, uint64(len(f[0])))
+		b = append(b, f[0]...)
+		b = appendPrefixInt(b, 0x00, 7, uint64(len(f[1])))
+		b = append(b, f[1]...)
+	}
+	return b
+}
+
+// decodeFields decodes a QPACK field section that references only the
+// static table.
+func decodeFields(b []byte) (map[string]string, error) {
+	ric, b, err := readPrefixInt(b, 8)
+	if err != nil {
+		return nil, err
+	}
+	if ric != 0 {
+		return nil, errors.New("field section uses the dynamic table")
+	}
+	if _, b, err = readPrefixInt(b, 7); err != nil { // Delta Base
+		return nil, err
+	}
+	fields := make(map[string]string)
+	for len(b) > 0 {
+		var name, value string
+		switch first := b[0]; {
+		case first&0x80 != 0: // Indexed field line
+			if first&0x40 == 0 {
+				return nil, errors.New("dynamic table reference")
+			}
+			var idx uint64
+			if idx, b, err = readPrefixInt(b, 6); err != nil {
+				return nil, err
+			}
+			if idx >= uint64(len(qpackStaticTable)) {
+				return nil, fmt.Errorf("static index %d", idx)
+			}
+			name, value = qpackStaticTable[idx][0], qpackStaticTable[idx][1]
+		case first&0x40 != 0: // Literal with name reference
+			if first&0x10 == 0 {
+				return nil, errors.New("dynamic table reference")
+			}
+			var idx uint64
+			if idx, b, err = readPrefixInt(b, 4); err != nil {
+				return nil, err
+			}
+			if idx >= uint64(len(qpackStaticTable)) {
+				return nil, fmt.Errorf("static index %d", idx)
+			}
+			name = qpackStaticTable[idx][0]
+			if value, b, err = readString(b, 7); err != nil {
+				return nil, err
+			}
+		case first&0x20 != 0: // Literal with literal name
+			if name, b, err = readString(b, 3); err != nil {
+				return nil, err
+			}
+			if value, b, err = readString(b, 7); err != nil {
+				return nil, err
+			}
+		default:
+			return nil, errors.New("post-base reference")
+		}
+		fields[name] = value
+	}
+	return fields, nil
+}
+
+// appendPrefixInt appends v as an integer with an n-bit prefix (RFC 7541
+// Section 5.1) in a byte starting with the bits of first.
+func appendPrefixInt(b []byte, first byte, n uint, v uint64) []byte {
+	limit := uint64(1)<<n - 1
+	if v < limit {
+		return append(b, first|byte(v))
+	}
+	b = append(b, first|byte(limit))
+	for v -= limit; v >= 0x80; v >>= 7 {
+		b = append(b, byte(v)|0x80)
+	}
+	return append(b, byte(v))
+}
+
+func readPrefixInt(b []byte, n uint) (uint64, []byte, error) {
+	if len(b) == 0 {
+		return 0, nil, io.ErrUnexpectedEOF
+	}
+	limit := uint64(1)<<n - 1
+	v := uint64(b[0]) & limit
+	b = b[1:]
+	if v < limit {
+		return v, b, nil
+	}
+	for shift := uint(0); shift <= 56; shift += 7 {
+		if len(b) == 0 {
+			return 0, nil, io.ErrUnexpectedEOF
+		}
+		c := b[0]
+		b = b[1:]
+		v += uint64(c&0x7f) << shift
+		if c&0x80 == 0 {
+			return v, b, nil
+		}
+	}
+	return 0, nil, errors.New("integer too large")
+}
+
+// readString reads a string literal whose length has an n-bit prefix,
+// preceded by its Huffman flag.
+func readString(b []byte, n uint) (string, []byte, error) {
+	if len(b) == 0 {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	huffman := b[0]&(1<<n) != 0
+	length, b, err := readPrefixInt(b, n)
+	if err != nil {
+		return "", nil, err
+	}
+	if length > uint64(len(b)) {
+		return "", nil, io.ErrUnexpectedEOF
+	}
+	raw, b := b[:length], b[length:]
+	if !huffman {
+		return string(raw), b, nil
+	}
+	s, err := hpack.HuffmanDecodeToString(raw)
+	return s, b, err
+}
+
+// qpackStaticTable is the QPACK static table (RFC 9204 Appendix A).
+var qpackStaticTable = [...][2]string{
+	{":authority", ""},
+	{":path", "/"},
+	{"age", "0"},
+	{"content-disposition", ""},
+	{"content-length", "0"},
+	{"cookie", ""},
+	{"date", ""},
+	{"etag", ""},
+	{"if-modified-since", ""},
+	{"if-none-match", ""},
+	{"last-modified", ""},
+	{"link", ""},
+	{"location", ""},
+	{"referer", ""},
+	{"set-cookie", ""},
+	{":method", "CONNECT"},
+	{":method", "DELETE"},
+	{":method", "GET"},
+	{":method", "HEAD"},
+	{":method", "OPTIONS"},
+	{":method", "POST"},
+	{":method", "PUT"},
+	{":scheme", "http"},
+	{":scheme", "https"},
+	{":status", "103"},
+	{":status", "200"},
+	{":status", "304"},
+	{":status", "404"},
+	{":status", "503"},
+	{"accept", "*/*"},
+	{"accept", "application/dns-message"},
+	{"accept-encoding", "gzip, deflate, br"},
+	{"accept-ranges", "bytes"},
+	{"access-control-allow-headers", "cache-control"},
+	{"access-control-allow-headers", "content-type"},
+	{"access-control-allow-origin", "*"},
+	{"cache-control", "max-age=0"},
+	{"cache-control", "max-age=2592000"},
+	{"cache-control", "max-age=604800"},
+	{"cache-control", "no-cache"},
+	{"cache-control", "no-store"},
+	{"cache-control", "public, max-age=31536000"},
+	{"content-encoding", "br"},
+	{"content-encoding", "gzip"},
+	{"content-type", "application/dns-message"},
+	{"content-type", "application/javascript"},
+	{"content-type", "application/json"},
+	{"content-type", "application/x-www-form-urlencoded"},
+	{"content-type", "image/gif"},
+	{"content-type", "image/jpeg"},
+	{"content-type", "image/png"},
+	{"content-type", "text/css"},
+	{"content-type", "text/html; charset=utf-8"},
+	{"content-type", "text/plain"},
+	{"content-type", "text/plain;charset=utf-8"},
+	{"range", "bytes=0-"},
+	{"strict-transport-security", "max-age=31536000"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains"},
+	{"strict-transport-security", "max-age=31536000; includesubdomains; preload"},
+	{"vary", "accept-encoding"},
+	{"vary", "origin"},
+	{"x-content-type-options", "nosniff"},
+	{"x-xss-protection", "1; mode=block"},
+	{":status", "100"},
+	{":status", "204"},
+	{":status", "206"},
+	{":status", "302"},
+	{":status", "400"},
+	{":status", "403"},
+	{":status", "421"},
+	{":status", "425"},
+	{":status", "500"},
+	{"accept-language", ""},
+	{"access-control-allow-credentials", "FALSE"},
+	{"access-control-allow-credentials", "TRUE"},
+	{"access-control-allow-headers", "*"},
+	{"access-control-allow-methods", "get"},
+	{"access-control-allow-methods", "get, post, options"},
+	{"access-control-allow-methods", "options"},
+	{"access-control-expose-headers", "content-length"},
+	{"access-control-request-headers", "content-type"},
+	{"access-control-request-method", "get"},
+	{"access-control-request-method", "post"},
+	{"alt-svc", "clear"},
+	{"authorization", ""},
+	{"content-security-policy", "script-src 'none'; object-src 'none'; base-uri 'none'"},
+	{"early-data", "1"},
+	{"expect-ct", ""},
+	{"forwarded", ""},
+	{"if-range", ""},
+	{"origin", ""},
+	{"purpose", "prefetch"},
+	{"server", ""},
+	{"timing-allow-origin", "*"},
+	{"upgrade-insecure-requests", "1"},
+	{"user-agent", ""},
+	{"x-forwarded-for", ""},
+	{"x-frame-options", "deny"},
+	{"x-frame-options", "sameorigin"},
+}
+
+// selfSigned returns a certificate for any name, with an ECDSA key that
+// every TLS 1.3 client accepts.
+func selfSigned(t testing.TB) tls.Certificate {
+	t.Helper()
+	priv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{SerialNumber: big.NewInt(1), NotAfter: time.Now().Add(24 * time.Hour)}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, priv.Public(), priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: priv}
+}
@@ -0,0 +1,237 @@
+package integration
+
+import (
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const handlerCalls = "quic_relay_handler_duration_seconds_count"
+
+func TestHTTP3_RoutesBySNI(t *testing.T) {
+	a := NewH3Backend(t, "a")
+	b := NewH3Backend(t, "b")
+	relay := StartRelay(t, `{
+		"handlers": [
+			{"type": "sni-router", "on_drop": "close", "config": {"routes": {
+				"a.example.com": "{{a}}",
+				"b.example.com": "{{b}}"
+			}}},
+			{"type": "forwarder"}
+		]
+	}`, Vars{"a": a.Addr, "b": b.Addr})
+	connects := relay.Metric(t, handlerCalls, "handler", "sni-router", "phase", "connect")
+
+	for _, tt := range []struct{ url, body string }{
+		{"https://a.example.com/one", "a /one"},
+		{"https://b.example.com/two", "b /two"},
+		{"https://a.example.com/three", "a /three"},
+	} {
+		resp, err := H3Get(relay.Addr, tt.url)
+		if err != nil {
+			t.Fatalf("GET %s: %v", tt.url, err)
+		}
+		if resp.Status != "200" || resp.Body != tt.body {
+			t.Errorf("GET %s = %s %q, want 200 %q", tt.url, resp.Status, resp.Body, tt.body)
+		}
+	}
+
+	if got := a.Requests(); len(got) != 2 || got[0].SNI != "a.example.com" || got[1].Path != "/three" {
+		t.Errorf("backend a requests = %+v", got)
+	}
+	if got := b.Requests(); len(got) != 1 || got[0].Authority != "b.example.com" || got[0].Method != "GET" {
+		t.Errorf("backend b requests = %+v", got)
+	}
+	if got := relay.Sessions(); len(got) != 3 {
+		t.Errorf("sessions = %v, want 3", got)
+	}
+	if got := relay.Metric(t, handlerCalls, "handler", "sni-router", "phase", "connect") - connects; got != 3 {
+		t.Errorf("sni-router OnConnect calls = %v, want 3", got)
+	}
+	if got := relay.Metric(t, handlerCalls, "handler", "forwarder", "phase", "packet"); got == 0 {
+		t.Error("forwarder OnPacket calls not counted")
+	}
+}
+
+func TestHTTP3_Refused(t *testing.T) {
+	backend := NewH3Backend(t, "play")
+	tests := []struct {
+		name    string
+		config  string
+		refused string // URL of the request to refuse
+		reason  string // Expected in the CONNECTION_CLOSE reason
+	}{
+		{
+			name: "unknown SNI",
+			config: `{"handlers": [
+				{"type": "sni-router", "on_drop": "close", "config": {"routes": {"play.example.com": "{{backend}}"}}},
+				{"type": "forwarder"}
+			]}`,
+			refused: "https://other.example.com/",
+		},
+		{
+			name: "max_sessions",
+			config: `{"handlers": [
+				{"type": "sni-router", "on_drop": "close", "config": {"routes": {
+					"play.example.com": {"backends": [{"addr": "{{backend}}", "max_sessions": 1}]}
+				}}},
+				{"type": "forwarder"}
+			]}`,
+			refused: "https://play.example.com/second",
+			reason:  "all backends full",
+		},
+		{
+			name: "max_parallel_connections",
+			config: `{"handlers": [
+				{"type": "ratelimit-global", "on_drop": {"action": "close", "reason": "relay busy"}, "config": {"max_parallel_connections": 1}},
+				{"type": "sni-router", "config": {"routes": {"play.example.com": "{{backend}}"}}},
+				{"type": "forwarder"}
+			]}`,
+			refused: "https://play.example.com/second",
+			reason:  "relay busy",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			relay := StartRelay(t, tt.config, Vars{"backend": backend.Addr})
+			before := len(backend.Requests())
+			if resp, err := H3Get(relay.Addr, "https://play.example.com/first"); err != nil || resp.Body != "play /first" {
+				t.Fatalf("first GET = %+v, %v", resp, err)
+			}
+
+			start := time.Now()
+			_, err := H3Get(relay.Addr, tt.refused)
+			if err == nil {
+				t.Fatalf("GET %s succeeded, want refused", tt.refused)
+			}
+			if !strings.Contains(err.Error(), tt.reason) {
+				t.Errorf("error = %v, want reason %q", err, tt.reason)
+			}
+			if d := time.Since(start); d > 2*time.Second {
+				t.Errorf("refused after %v, want a CONNECTION_CLOSE rather than a timeout", d)
+			}
+			if got := len(backend.Requests()) - before; got != 1 {
+				t.Errorf("backend got %d requests, want 1", got)
+			}
+			if n := relay.Proxy.Stats().Sessions; n != 1 {
+				t.Errorf("sessions = %d, want 1", n)
+			}
+		})
+	}
+}
+
+// TestCurlHTTP3 runs curl against the relay when it is built with HTTP/3.
+func TestCurlHTTP3(t *testing.T) {
+	curl, err := exec.LookPath("curl")
+	if err != nil {
+		t.Skip("curl not installed")
+	}
+	version, err := exec.Command(curl, "--version").Output()
+	if err != nil || !strings.Contains(string(version), "HTTP3") {
+		t.Skip("curl built without HTTP/3")
+	}
+
+	backend := NewH3Backend(t, "web")
+	relay := StartRelay(t, `{"handlers": [
+		{"type": "sni-router", "config": {"routes": {"web.example.com": "{{backend}}"}}},
+		{"type": "forwarder"}
+	]}`, Vars{"backend": backend.Addr})
+	port := relay.Addr[strings.LastIndex(relay.Addr, ":")+1:]
+
+	out, err := exec.Command(curl, "--http3-only", "--insecure", "--silent", "--show-error", "--max-time", "10",
+		"--resolve", "web.example.com:"+port+":127.0.0.1",
+		"https://web.example.com:"+port+"/curl").CombinedOutput()
+	if err != nil {
+		t.Fatalf("curl: %v: %s", err, out)
+	}
+	if string(out) != "web /curl" {
+		t.Errorf("curl output = %q, want %q", out, "web /curl")
+	}
+	if got := backend.Requests(); len(got) != 1 || !strings.HasPrefix(got[0].UserAgent, "curl/") || got[0].SNI != "web.example.com" {
+		t.Errorf("backend requests = %+v", got)
+	}
+}
+
+func TestBedrock_RoutedByPort(t *testing.T) {
+	server := NewBedrockServer(t, "Bedrock Sim")
+	relay := StartRelay(t, `{
+		"protocols": [{"name": "bedrock", "port": {{relay_port}}}],
+		"handlers": [
+			{"type": "protocol-router", "config": {"routes": {"bedrock": "{{server}}"}}},
+			{"type": "forwarder"}
+		]
+	}`, Vars{"server": server.Addr})
+
+	clients := []*BedrockClient{NewBedrockClient(t, relay.Addr), NewBedrockClient(t, relay.Addr)}
+	for i, c := range clients {
+		status, err := c.Ping()
+		if err != nil {
+			t.Fatalf("client %d ping: %v", i, err)
+		}
+		if !strings.Contains(status, ";Bedrock Sim;") {
+			t.Errorf("client %d status = %q", i, status)
+		}
+		if err := c.Connect(relay.Addr); err != nil {
+			t.Fatalf("client %d: %v", i, err)
+		}
+		for seq := uint32(0); seq < 3; seq++ {
+			payload := []byte("move " + strconv.Itoa(i) + "/" + strconv.Itoa(int(seq)))
+			echo, err := c.Send(seq, payload)
+			if err != nil || string(echo) != string(payload) {
+				t.Fatalf("client %d frame %d echo = %q, %v", i, seq, echo, err)
+			}
+		}
+	}
+
+	// The server sees each client from its own relay socket
+	connected := server.Clients()
+	if len(connected) != len(clients) {
+		t.Fatalf("server clients = %v, want %d", connected, len(clients))
+	}
+	for _, c := range clients {
+		found := false
+		for addr, guid := range connected {
+			found = found || guid == c.GUID && addr != c.Addr()
+		}
+		if !found {
+			t.Errorf("client %x not connected through the relay: %v", c.GUID, connected)
+		}
+	}
+	sessions := relay.Proxy.Sessions()
+	if len(sessions) != len(clients) {
+		t.Fatalf("sessions = %+v", sessions)
+	}
+	for _, s := range sessions {
+		if s.Protocol != "bedrock" || s.Backend != server.Addr {
+			t.Errorf("session = %+v, want bedrock to %s", s, server.Addr)
+		}
+	}
+}
+
+func TestBedrock_PingAnsweredByRelay(t *testing.T) {
+	backend := NewH3Backend(t, "play")
+	relay := StartRelay(t, `{"handlers": [
+		{"type": "raknet", "config": {"motd": "Relay MOTD", "max_players": 50}},
+		{"type": "sni-router", "config": {"routes": {"play.example.com": "{{backend}}"}}},
+		{"type": "forwarder"}
+	]}`, Vars{"backend": backend.Addr})
+
+	status, err := NewBedrockClient(t, relay.Addr).Ping()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(status, "MCPE;Relay MOTD;") || !strings.Contains(status, ";50;") {
+		t.Errorf("status = %q", status)
+	}
+	if n := relay.Proxy.Stats().Sessions; n != 0 {
+		t.Errorf("sessions = %d, want 0", n)
+	}
+
+	// QUIC on the same port is unaffected
+	if resp, err := H3Get(relay.Addr, "https://play.example.com/"); err != nil || resp.Body != "play /" {
+		t.Errorf("GET = %+v, %v", resp, err)
+	}
+	eventually(t, time.Second, "one session", func() bool { return relay.Proxy.Stats().Sessions == 1 })
+}
@@ -0,0 +1,166 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"quic-relay/internal/retention"
+)
+
+// rotateRetry is how long a sink whose rotation failed keeps appending to its
+// current file before it tries again.
+const rotateRetry = time.Minute
+
+// rename renames files on rotation; replaced in tests.
+var rename = os.Rename
+
+// FileConfig configures the rotating file sink.
+type FileConfig struct {
+	Path           string `json:"path"`
+	MaxSizeMB      int    `json:"max_size_mb,omitempty"`     // Rotate when file exceeds this size (0 = no size limit)
+	RotateInterval int    `json:"rotate_interval,omitempty"` // Rotate after this many seconds (0 = no time-based rotation)
+	MaxBackups     int    `json:"max_backups,omitempty"`     // Rotated files to keep (0 = keep all)
+}
+
+// fileSink writes to a file and rotates it by size and/or age.
+// Rotated files are renamed to "<path>.<timestamp>".
+type fileSink struct {
+	cfg      FileConfig
+	maxSize  int64
+	interval time.Duration
+
+	mu       sync.Mutex
+	file     *os.File
+	size     int64
+	openedAt time.Time
+	retryAt  time.Time // No rotation before this, after one failed
+
+	removeStore func() // Unregisters the rotated files from retention
+}
+
+func newFileSink(cfg *FileConfig) (*fileSink, error) {
+	s := &fileSink{
+		cfg:      *cfg,
+		maxSize:  int64(cfg.MaxSizeMB) * 1024 * 1024,
+		interval: time.Duration(cfg.RotateInterval) * time.Second,
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	s.removeStore = retention.AddStore(filepath.Dir(cfg.Path), filepath.Base(cfg.Path)+".")
+	return s, nil
+}
+
+// open opens (or creates) the log file in append mode and makes it the
+// current file.
+func (s *fileSink) open() error {
+	if err := os.MkdirAll(filepath.Dir(s.cfg.Path), 0o755); err != nil {
+		return fmt.Errorf("failed to create log directory: %w", err)
+	}
+	f, err := os.OpenFile(s.cfg.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file = f
+	s.size = info.Size()
+	s.openedAt = time.Now()
+	return nil
+}
+
+func (s *fileSink) Write(level Level, component, msg string) error {
+	now := time.Now()
+	line := formatLine(now, level, msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.file == nil {
+		return os.ErrClosed
+	}
+	if s.needsRotation(now, int64(len(line))) {
+		if err := s.rotate(now); err != nil {
+			// The line goes to the current file; nothing else can report this
+			fmt.Fprintf(os.Stderr, "log rotation failed, retrying in %v: %v\n", rotateRetry, err)
+			s.retryAt = now.Add(rotateRetry)
+		}
+	}
+	n, err := s.file.Write(line)
+	s.size += int64(n)
+	return err
+}
+
+// needsRotation reports whether writing n more bytes requires a rotation first.
+func (s *fileSink) needsRotation(now time.Time, n int64) bool {
+	if now.Before(s.retryAt) {
+		return false
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+n > s.maxSize {
+		return true
+	}
+	return s.interval > 0 && now.Sub(s.openedAt) >= s.interval
+}
+
+// rotate renames the current file, opens a fresh one and prunes old backups.
+// The current file is closed only once the fresh one is open; on error it
+// stays the current file, under its old or its backup name.
+func (s *fileSink) rotate(now time.Time) error {
+	backup := s.cfg.Path + "." + now.Format("20060102-150405.000")
+	if err := rename(s.cfg.Path, backup); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	old := s.file
+	if err := s.open(); err != nil {
+		return err
+	}
+	old.Close()
+	s.pruneBackups()
+	return nil
+}
+
+// pruneBackups removes the oldest rotated files beyond MaxBackups.
+func (s *fileSink) pruneBackups() {
+	if s.cfg.MaxBackups <= 0 {
+		return
+	}
+	matches, err := filepath.Glob(s.cfg.Path + ".*")
+	if err != nil {
+		return
+	}
+	prefix := s.cfg.Path + "."
+	backups := matches[:0]
+	for _, m := range matches {
+		if strings.HasPrefix(m, prefix) {
+			backups = append(backups, m)
+		}
+	}
+	if len(backups) <= s.cfg.MaxBackups {
+		return
+	}
+	// Timestamp suffix sorts chronologically
+	sort.Strings(backups)
+	for _, old := range backups[:len(backups)-s.cfg.MaxBackups] {
+		os.Remove(old)
+	}
+}
+
+func (s *fileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.removeStore()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
@@ -0,0 +1,78 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+)
+
+// journaldSocket is the systemd-journald native protocol socket.
+const journaldSocket = "/run/systemd/journal/socket"
+
+// journaldSink writes structured entries to systemd-journald using the native protocol.
+// The component is attached as QUIC_RELAY_COMPONENT so it can be filtered with journalctl.
+type journaldSink struct {
+	mu   sync.Mutex
+	conn *net.UnixConn
+}
+
+func newJournaldSink() (Sink, error) {
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: journaldSocket, Net: "unixgram"})
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to journald: %w", err)
+	}
+	return &journaldSink{conn: conn}, nil
+}
+
+// journaldPriority maps a level to a syslog priority (RFC 5424).
+func journaldPriority(level Level) int {
+	switch level {
+	case LevelDebug:
+		return 7
+	case LevelWarn:
+		return 4
+	case LevelError:
+		return 3
+	default:
+		return 6
+	}
+}
+
+func (s *journaldSink) Write(level Level, component, msg string) error {
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "PRIORITY", fmt.Sprint(journaldPriority(level)))
+	writeJournaldField(&buf, "SYSLOG_IDENTIFIER", "quic-relay")
+	writeJournaldField(&buf, "QUIC_RELAY_COMPONENT", component)
+	writeJournaldField(&buf, "MESSAGE", msg)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.conn.Write(buf.Bytes())
+	return err
+}
+
+// writeJournaldField appends one field in journald native format.
+// Values containing newlines use the length-prefixed binary form.
+func writeJournaldField(buf *bytes.Buffer, key, value string) {
+	if !strings.Contains(value, "\n") {
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(value)
+		buf.WriteByte('\n')
+		return
+	}
+	buf.WriteString(key)
+	buf.WriteByte('\n')
+	var size [8]byte
+	binary.LittleEndian.PutUint64(size[:], uint64(len(value)))
+	buf.Write(size[:])
+	buf.WriteString(value)
+	buf.WriteByte('\n')
+}
+
+func (s *journaldSink) Close() error {
+	return s.conn.Close()
+}
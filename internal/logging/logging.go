@@ -0,0 +1,257 @@
+// Package logging provides leveled, per-component logging with selectable sinks.
+//
+// Components obtain a Logger once via For and log through it. The active sink
+// and levels are swapped atomically by Configure, so configuration can be
+// hot-reloaded without touching the loggers held by components.
+package logging
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Level is a log severity.
+type Level int
+
+const (
+	LevelDebug Level = iota
+	LevelInfo
+	LevelWarn
+	LevelError
+)
+
+// String returns the lowercase level name.
+func (l Level) String() string {
+	switch l {
+	case LevelDebug:
+		return "debug"
+	case LevelInfo:
+		return "info"
+	case LevelWarn:
+		return "warn"
+	case LevelError:
+		return "error"
+	default:
+		return fmt.Sprintf("level(%d)", int(l))
+	}
+}
+
+// ParseLevel parses a level name. Empty string means info.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(s) {
+	case "debug":
+		return LevelDebug, nil
+	case "", "info":
+		return LevelInfo, nil
+	case "warn", "warning":
+		return LevelWarn, nil
+	case "error":
+		return LevelError, nil
+	default:
+		return LevelInfo, fmt.Errorf("unknown log level: %q", s)
+	}
+}
+
+// Config is the logging configuration.
+type Config struct {
+	Output string            `json:"output,omitempty"` // "stderr" (default), "file", "syslog", "journald"
+	Level  string            `json:"level,omitempty"`  // Default level for all components (default: info)
+	Levels map[string]string `json:"levels,omitempty"` // Per-component levels, e.g. {"forwarder": "debug"}
+	File   *FileConfig       `json:"file,omitempty"`   // Required for output "file"
+	Syslog *SyslogConfig     `json:"syslog,omitempty"` // Optional for output "syslog"
+}
+
+// Sink receives formatted log messages.
+type Sink interface {
+	Write(level Level, component, msg string) error
+	Close() error
+}
+
+// state is the active logging configuration, swapped atomically.
+type state struct {
+	sink   Sink
+	level  Level
+	levels map[string]Level
+}
+
+func (s *state) enabled(component string, level Level) bool {
+	min, ok := s.levels[component]
+	if !ok {
+		min = s.level
+	}
+	return level >= min
+}
+
+var (
+	current     atomic.Pointer[state]
+	configureMu sync.Mutex
+	// sinkMu is read-locked while a message is written, so Configure closes
+	// the previous sink only after the writes that loaded it are done.
+	sinkMu sync.RWMutex
+)
+
+func init() {
+	current.Store(&state{sink: newStderrSink(), level: LevelInfo})
+}
+
+// Configure builds the sink from cfg and atomically replaces the active configuration.
+// A nil cfg restores the default (stderr, info). The previous sink is closed
+// once messages being written to it are done.
+// The stdlib log package is redirected to the new sink as well.
+func Configure(cfg *Config) error {
+	apply, _, err := Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare validates cfg and opens its sink without changing the active
+// configuration. apply then does what Configure does; discard closes the sink
+// if it is not applied.
+func Prepare(cfg *Config) (apply, discard func(), err error) {
+	if cfg == nil {
+		cfg = &Config{}
+	}
+
+	level, err := ParseLevel(cfg.Level)
+	if err != nil {
+		return nil, nil, err
+	}
+	levels := make(map[string]Level, len(cfg.Levels))
+	for component, name := range cfg.Levels {
+		l, err := ParseLevel(name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("component %s: %w", component, err)
+		}
+		levels[component] = l
+	}
+
+	sink, err := newSink(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+	apply = func() { swap(&state{sink: sink, level: level, levels: levels}) }
+	return apply, func() { sink.Close() }, nil
+}
+
+// swap makes st the active configuration.
+func swap(st *state) {
+	configureMu.Lock()
+	defer configureMu.Unlock()
+	old := current.Swap(st)
+	log.SetFlags(0)
+	log.SetPrefix("")
+	log.SetOutput(stdlibWriter{})
+	if old != nil && old.sink != st.sink {
+		sinkMu.Lock()
+		sinkMu.Unlock()
+		old.sink.Close()
+	}
+}
+
+// newSink creates the sink selected by cfg.Output.
+func newSink(cfg *Config) (Sink, error) {
+	switch cfg.Output {
+	case "", "stderr":
+		return newStderrSink(), nil
+	case "file":
+		if cfg.File == nil || cfg.File.Path == "" {
+			return nil, fmt.Errorf("log output 'file' requires 'file.path'")
+		}
+		return newFileSink(cfg.File)
+	case "syslog":
+		return newSyslogSink(cfg.Syslog)
+	case "journald":
+		return newJournaldSink()
+	default:
+		return nil, fmt.Errorf("unknown log output: %q", cfg.Output)
+	}
+}
+
+// Logger logs messages for one component.
+// The zero value is not usable; obtain loggers via For or ForHandler.
+type Logger struct {
+	component string // Used for level lookup
+	tag       string // Printed as "[tag]" prefix
+	fixed     bool   // Use min instead of the configured levels
+	min       Level
+}
+
+// For returns a logger for a component (e.g. "proxy", "forwarder", "terminator").
+func For(component string) *Logger {
+	return &Logger{component: component, tag: component}
+}
+
+// ForHandler returns a logger for a handler. Levels are looked up under the
+// "handlers" component, while messages are tagged with the handler's own tag.
+func ForHandler(tag string) *Logger {
+	return &Logger{component: "handlers", tag: tag}
+}
+
+// WithLevel returns a logger for the same component that writes messages at
+// min and above, whatever levels are configured. Used for per-route overrides.
+func (l *Logger) WithLevel(min Level) *Logger {
+	return &Logger{component: l.component, tag: l.tag, fixed: true, min: min}
+}
+
+// Enabled reports whether messages at level would be written.
+// Use it to skip expensive argument formatting on hot paths.
+func (l *Logger) Enabled(level Level) bool {
+	if l.fixed {
+		return level >= l.min
+	}
+	return current.Load().enabled(l.component, level)
+}
+
+func (l *Logger) logf(level Level, format string, v ...any) {
+	st := current.Load()
+	if l.fixed && level < l.min || !l.fixed && !st.enabled(l.component, level) {
+		return
+	}
+	msg := fmt.Sprintf(format, v...)
+	if l.tag != "" {
+		msg = "[" + l.tag + "] " + msg
+	}
+	if err := write(level, l.component, msg); err != nil {
+		fmt.Fprintf(os.Stderr, "logging: write failed: %v: %s\n", err, msg)
+	}
+}
+
+// write writes a message to the active sink.
+func write(level Level, component, msg string) error {
+	sinkMu.RLock()
+	defer sinkMu.RUnlock()
+	return current.Load().sink.Write(level, component, msg)
+}
+
+// Debugf logs at debug level.
+func (l *Logger) Debugf(format string, v ...any) { l.logf(LevelDebug, format, v...) }
+
+// Printf logs at info level.
+func (l *Logger) Printf(format string, v ...any) { l.logf(LevelInfo, format, v...) }
+
+// Warnf logs at warn level.
+func (l *Logger) Warnf(format string, v ...any) { l.logf(LevelWarn, format, v...) }
+
+// Errorf logs at error level.
+func (l *Logger) Errorf(format string, v ...any) { l.logf(LevelError, format, v...) }
+
+// stdlibWriter forwards output of the stdlib log package (including
+// third-party libraries) to the active sink at info level.
+type stdlibWriter struct{}
+
+func (stdlibWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if current.Load().enabled("log", LevelInfo) {
+		if err := write(LevelInfo, "log", msg); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
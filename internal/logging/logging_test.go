@@ -0,0 +1,253 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// memorySink records messages for assertions.
+type memorySink struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (s *memorySink) Write(level Level, component, msg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.msgs = append(s.msgs, level.String()+" "+component+" "+msg)
+	return nil
+}
+
+func (s *memorySink) Close() error { return nil }
+
+func TestParseLevel(t *testing.T) {
+	tests := map[string]Level{
+		"":        LevelInfo,
+		"debug":   LevelDebug,
+		"INFO":    LevelInfo,
+		"warning": LevelWarn,
+		"error":   LevelError,
+	}
+	for in, want := range tests {
+		got, err := ParseLevel(in)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("expected error for unknown level")
+	}
+}
+
+func TestLogger_PerComponentLevels(t *testing.T) {
+	sink := &memorySink{}
+	old := current.Swap(&state{
+		sink:   sink,
+		level:  LevelWarn,
+		levels: map[string]Level{"forwarder": LevelDebug},
+	})
+	defer current.Store(old)
+
+	For("proxy").Printf("hidden")
+	For("proxy").Errorf("shown %d", 1)
+	For("forwarder").Debugf("verbose")
+	ForHandler("sni").Printf("hidden too")
+
+	want := []string{
+		"error proxy [proxy] shown 1",
+		"debug forwarder [forwarder] verbose",
+	}
+	if strings.Join(sink.msgs, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", sink.msgs, want)
+	}
+}
+
+func TestLogger_WithLevel(t *testing.T) {
+	sink := &memorySink{}
+	old := current.Swap(&state{sink: sink, level: LevelInfo})
+	defer current.Store(old)
+
+	For("forwarder").WithLevel(LevelDebug).Debugf("verbose")
+	For("forwarder").WithLevel(LevelWarn).Printf("quiet")
+	For("forwarder").Debugf("hidden")
+
+	want := []string{"debug forwarder [forwarder] verbose"}
+	if strings.Join(sink.msgs, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", sink.msgs, want)
+	}
+	if l := For("x").WithLevel(LevelError); l.Enabled(LevelWarn) || !l.Enabled(LevelError) {
+		t.Error("Enabled ignores the fixed level")
+	}
+}
+
+func TestConfigure_Invalid(t *testing.T) {
+	if err := Configure(&Config{Level: "loud"}); err == nil {
+		t.Error("expected error for invalid level")
+	}
+	if err := Configure(&Config{Levels: map[string]string{"proxy": "nope"}}); err == nil {
+		t.Error("expected error for invalid component level")
+	}
+	if err := Configure(&Config{Output: "file"}); err == nil {
+		t.Error("expected error for file output without path")
+	}
+	if err := Configure(&Config{Output: "carrier-pigeon"}); err == nil {
+		t.Error("expected error for unknown output")
+	}
+}
+
+// blockingSink holds writes until release is closed and records writes
+// made after Close.
+type blockingSink struct {
+	started, release chan struct{}
+	mu               sync.Mutex
+	closed, lost     bool
+}
+
+func (s *blockingSink) Write(level Level, component, msg string) error {
+	close(s.started)
+	<-s.release
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lost = s.closed
+	return nil
+}
+
+func (s *blockingSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closed = true
+	return nil
+}
+
+func TestConfigure_ClosesSinkAfterWrites(t *testing.T) {
+	sink := &blockingSink{started: make(chan struct{}), release: make(chan struct{})}
+	st := &state{sink: sink, level: LevelInfo}
+	old := current.Swap(st)
+	defer current.Store(old)
+
+	logged := make(chan struct{})
+	go func() {
+		For("proxy").Printf("in flight")
+		close(logged)
+	}()
+	<-sink.started
+	configured := make(chan struct{})
+	go func() {
+		Configure(nil)
+		close(configured)
+	}()
+	for current.Load() == st {
+		runtime.Gosched()
+	}
+	close(sink.release)
+	<-logged
+	<-configured
+	if sink.lost || !sink.closed {
+		t.Errorf("lost = %v, closed = %v: sink closed under a write", sink.lost, sink.closed)
+	}
+}
+
+func TestFileSink_RotatesBySize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.log")
+	s, err := newFileSink(&FileConfig{Path: path, MaxBackups: 1})
+	if err != nil {
+		t.Fatalf("newFileSink: %v", err)
+	}
+	defer s.Close()
+	s.maxSize = 64 // Bytes, to force rotation quickly
+
+	for i := 0; i < 10; i++ {
+		if err := s.Write(LevelInfo, "proxy", strings.Repeat("x", 30)); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+	}
+
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Errorf("expected 1 backup after pruning, got %d", len(backups))
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("stat: %v", err)
+	}
+	if info.Size() > 64 {
+		t.Errorf("active file exceeds max size: %d", info.Size())
+	}
+}
+
+func TestFileSink_RotationFailure(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "relay.log")
+	s, err := newFileSink(&FileConfig{Path: path})
+	if err != nil {
+		t.Fatalf("newFileSink: %v", err)
+	}
+	defer s.Close()
+	s.maxSize = 64
+	t.Cleanup(func() { rename = os.Rename })
+	write := func(msg string) {
+		t.Helper()
+		if err := s.Write(LevelInfo, "proxy", msg); err != nil {
+			t.Fatalf("Write %s: %v", msg, err)
+		}
+	}
+
+	// The rename fails: the current file is kept
+	rename = func(string, string) error { return os.ErrPermission }
+	write(strings.Repeat("a", 40))
+	write(strings.Repeat("b", 40))
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "bbbb") {
+		t.Errorf("log = %q", data)
+	}
+
+	// The reopen fails: writing continues to the renamed file
+	rename = func(from, to string) error {
+		if err := os.Rename(from, to); err != nil {
+			return err
+		}
+		return os.Mkdir(from, 0o755) // Opening a directory for writing fails
+	}
+	s.retryAt = time.Time{}
+	write(strings.Repeat("c", 40))
+	backups, _ := filepath.Glob(path + ".*")
+	if len(backups) != 1 {
+		t.Fatalf("backups = %v", backups)
+	}
+	if data, _ := os.ReadFile(backups[0]); !strings.Contains(string(data), "cccc") {
+		t.Errorf("backup = %q", data)
+	}
+
+	// Once the failure is gone, rotation resumes
+	rename = os.Rename
+	os.Remove(path)
+	s.retryAt = time.Time{}
+	write(strings.Repeat("d", 40))
+	if data, _ := os.ReadFile(path); !strings.Contains(string(data), "dddd") {
+		t.Errorf("log = %q", data)
+	}
+}
+
+func TestWriteJournaldField(t *testing.T) {
+	var buf bytes.Buffer
+	writeJournaldField(&buf, "MESSAGE", "hello")
+	if buf.String() != "MESSAGE=hello\n" {
+		t.Errorf("unexpected simple field: %q", buf.String())
+	}
+
+	buf.Reset()
+	writeJournaldField(&buf, "MESSAGE", "a\nb")
+	data := buf.Bytes()
+	if !bytes.HasPrefix(data, []byte("MESSAGE\n")) {
+		t.Fatalf("expected binary field, got %q", data)
+	}
+	size := binary.LittleEndian.Uint64(data[8:16])
+	if size != 3 || string(data[16:19]) != "a\nb" {
+		t.Errorf("unexpected binary field: %q", data)
+	}
+}
@@ -0,0 +1,47 @@
+package logging
+
+import (
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// stderrSink writes log lines in the stdlib log format to stderr.
+type stderrSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newStderrSink() *stderrSink {
+	return &stderrSink{out: os.Stderr}
+}
+
+func (s *stderrSink) Write(level Level, component, msg string) error {
+	line := formatLine(time.Now(), level, msg)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := s.out.Write(line)
+	return err
+}
+
+// Close does nothing - stderr stays open.
+func (s *stderrSink) Close() error { return nil }
+
+// formatLine formats a log line like the stdlib logger ("2006/01/02 15:04:05 msg").
+// Warnings and errors get a level marker so they stand out in plain text output.
+func formatLine(t time.Time, level Level, msg string) []byte {
+	buf := make([]byte, 0, 20+len(msg)+8)
+	buf = t.AppendFormat(buf, "2006/01/02 15:04:05 ")
+	switch level {
+	case LevelWarn:
+		buf = append(buf, "WARN "...)
+	case LevelError:
+		buf = append(buf, "ERROR "...)
+	}
+	buf = append(buf, msg...)
+	if len(msg) == 0 || msg[len(msg)-1] != '\n' {
+		buf = append(buf, '\n')
+	}
+	return buf
+}
@@ -0,0 +1,16 @@
+//go:build windows || plan9
+
+package logging
+
+import "errors"
+
+// SyslogConfig configures the syslog sink.
+type SyslogConfig struct {
+	Network string `json:"network,omitempty"`
+	Address string `json:"address,omitempty"`
+	Tag     string `json:"tag,omitempty"`
+}
+
+func newSyslogSink(cfg *SyslogConfig) (Sink, error) {
+	return nil, errors.New("syslog output is not supported on this platform")
+}
@@ -0,0 +1,52 @@
+//go:build !windows && !plan9
+
+package logging
+
+import (
+	"fmt"
+	"log/syslog"
+)
+
+// SyslogConfig configures the syslog sink.
+type SyslogConfig struct {
+	Network string `json:"network,omitempty"` // "udp", "tcp" or empty for the local syslog daemon
+	Address string `json:"address,omitempty"` // Remote address, e.g. "logs.example.com:514"
+	Tag     string `json:"tag,omitempty"`     // Syslog tag (default: quic-relay)
+}
+
+// syslogSink writes messages to syslog with the matching severity.
+type syslogSink struct {
+	w *syslog.Writer
+}
+
+func newSyslogSink(cfg *SyslogConfig) (Sink, error) {
+	if cfg == nil {
+		cfg = &SyslogConfig{}
+	}
+	tag := cfg.Tag
+	if tag == "" {
+		tag = "quic-relay"
+	}
+	w, err := syslog.Dial(cfg.Network, cfg.Address, syslog.LOG_INFO|syslog.LOG_DAEMON, tag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &syslogSink{w: w}, nil
+}
+
+func (s *syslogSink) Write(level Level, component, msg string) error {
+	switch level {
+	case LevelDebug:
+		return s.w.Debug(msg)
+	case LevelWarn:
+		return s.w.Warning(msg)
+	case LevelError:
+		return s.w.Err(msg)
+	default:
+		return s.w.Info(msg)
+	}
+}
+
+func (s *syslogSink) Close() error {
+	return s.w.Close()
+}
@@ -0,0 +1,324 @@
+package metrics
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"maps"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"quic-relay/internal/logging"
+)
+
+var exportLog = logging.For("metrics")
+
+// Exporter types.
+const (
+	ExportRemoteWrite = "remote_write" // Prometheus remote write over HTTP
+	ExportStatsd      = "statsd"       // Plain statsd over UDP, labels folded into the name
+	ExportDogStatsd   = "dogstatsd"    // Datadog statsd over UDP, labels as tags
+)
+
+const defaultExportInterval = 15 * time.Second
+
+// ExportConfig configures a push exporter, for relays that cannot be scraped.
+type ExportConfig struct {
+	Type     string            `json:"type"`               // remote_write, statsd or dogstatsd
+	URL      string            `json:"url,omitempty"`      // remote_write: receiver endpoint
+	Headers  map[string]string `json:"headers,omitempty"`  // remote_write: extra request headers, e.g. Authorization
+	Address  string            `json:"address,omitempty"`  // statsd: host:port (default: 127.0.0.1:8125)
+	Prefix   string            `json:"prefix,omitempty"`   // statsd: prepended to metric names
+	Interval int               `json:"interval,omitempty"` // Seconds between pushes (default: 15)
+	Metrics  []string          `json:"metrics,omitempty"`  // Metric families to push; "name_*" matches a prefix (default: all)
+	Labels   map[string]string `json:"labels,omitempty"`   // Added to every series, e.g. the node name
+}
+
+// Sample is one series value parsed from the text format.
+type Sample struct {
+	Name   string
+	Family string   // Metric family, without histogram suffixes
+	Type   string   // counter, gauge, histogram or untyped
+	Labels []string // Label pairs ("key", "value", ...) in exposition order
+	Value  float64
+}
+
+// pusher sends one batch of samples to a backend.
+type pusher interface {
+	push(ctx context.Context, samples []Sample, now time.Time) error
+	close()
+}
+
+// exporter gathers registered metrics on an interval and pushes them.
+type exporter struct {
+	cfg      ExportConfig
+	interval time.Duration
+	allow    []string
+	p        pusher
+}
+
+var (
+	exportersMu     sync.Mutex
+	stopExporters   context.CancelFunc
+	exportersConfig []ExportConfig
+)
+
+// ConfigureExporters replaces the running push exporters with cfgs.
+// Nothing changes when cfgs is invalid or equal to the running config.
+func ConfigureExporters(cfgs []ExportConfig) error {
+	apply, _, err := PrepareExporters(cfgs)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// PrepareExporters builds the exporters of cfgs without starting them. apply
+// then does what ConfigureExporters does; discard closes them if they are
+// not applied.
+func PrepareExporters(cfgs []ExportConfig) (apply, discard func(), err error) {
+	exporters := make([]*exporter, 0, len(cfgs))
+	discard = func() {
+		for _, e := range exporters {
+			e.p.close()
+		}
+	}
+	for i, cfg := range cfgs {
+		e, err := newExporter(cfg)
+		if err != nil {
+			discard()
+			return nil, nil, fmt.Errorf("exporters[%d]: %w", i, err)
+		}
+		exporters = append(exporters, e)
+	}
+	apply = func() {
+		exportersMu.Lock()
+		defer exportersMu.Unlock()
+		if slices.EqualFunc(cfgs, exportersConfig, equalExportConfig) {
+			discard()
+			return
+		}
+		if stopExporters != nil {
+			stopExporters()
+			stopExporters = nil
+		}
+		exportersConfig = cfgs
+		if len(exporters) == 0 {
+			return
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		stopExporters = cancel
+		for _, e := range exporters {
+			go e.run(ctx)
+		}
+	}
+	return apply, discard, nil
+}
+
+func equalExportConfig(a, b ExportConfig) bool {
+	return a.Type == b.Type && a.URL == b.URL && a.Address == b.Address && a.Prefix == b.Prefix &&
+		a.Interval == b.Interval && slices.Equal(a.Metrics, b.Metrics) &&
+		maps.Equal(a.Headers, b.Headers) && maps.Equal(a.Labels, b.Labels)
+}
+
+func newExporter(cfg ExportConfig) (*exporter, error) {
+	if cfg.Interval < 0 {
+		return nil, fmt.Errorf("'interval' must be >= 0")
+	}
+	e := &exporter{cfg: cfg, interval: time.Duration(cfg.Interval) * time.Second, allow: cfg.Metrics}
+	if e.interval == 0 {
+		e.interval = defaultExportInterval
+	}
+	var err error
+	switch cfg.Type {
+	case ExportRemoteWrite:
+		e.p, err = newRemoteWriter(cfg)
+	case ExportStatsd, ExportDogStatsd:
+		e.p, err = newStatsd(cfg)
+	default:
+		err = fmt.Errorf("unknown exporter type %q", cfg.Type)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+func (e *exporter) run(ctx context.Context) {
+	defer e.p.close()
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+	failing := false
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			pushCtx, cancel := context.WithTimeout(ctx, e.interval)
+			err := e.p.push(pushCtx, e.gather(), now)
+			cancel()
+			switch {
+			case err != nil && !failing:
+				exportLog.Warnf("%s push failed: %v", e.cfg.Type, err)
+				failing = true
+			case err == nil && failing:
+				exportLog.Printf("%s push recovered", e.cfg.Type)
+				failing = false
+			}
+		}
+	}
+}
+
+// gather collects the allowed samples of all registered collectors,
+// with the configured labels added.
+func (e *exporter) gather() []Sample {
+	var buf bytes.Buffer
+	WriteAll(&buf)
+	samples, err := ParseText(&buf)
+	if err != nil {
+		exportLog.Warnf("parsing metrics: %v", err)
+	}
+	extra := make([]string, 0, 2*len(e.cfg.Labels))
+	for _, k := range slices.Sorted(maps.Keys(e.cfg.Labels)) {
+		extra = append(extra, k, e.cfg.Labels[k])
+	}
+	kept := samples[:0]
+	for _, s := range samples {
+		if !allowed(e.allow, s.Family) {
+			continue
+		}
+		s.Labels = append(s.Labels, extra...)
+		kept = append(kept, s)
+	}
+	return kept
+}
+
+// allowed reports whether family matches one of the patterns. An empty list
+// allows everything; a trailing '*' matches any suffix.
+func allowed(patterns []string, family string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, p := range patterns {
+		if prefix, ok := strings.CutSuffix(p, "*"); ok {
+			if strings.HasPrefix(family, prefix) {
+				return true
+			}
+		} else if p == family {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseText parses the Prometheus text format as written by collectors.
+// Samples of families without a TYPE line are untyped. Timestamps are ignored.
+func ParseText(r io.Reader) ([]Sample, error) {
+	types := make(map[string]string)
+	var samples []Sample
+	sc := bufio.NewScanner(r)
+	for n := 1; sc.Scan(); n++ {
+		line := strings.TrimSpace(sc.Text())
+		if line == "" {
+			continue
+		}
+		if rest, ok := strings.CutPrefix(line, "# TYPE "); ok {
+			if name, typ, ok := strings.Cut(rest, " "); ok {
+				types[name] = typ
+			}
+			continue
+		}
+		if line[0] == '#' {
+			continue
+		}
+		s, err := parseSample(line)
+		if err != nil {
+			return samples, fmt.Errorf("line %d: %w", n, err)
+		}
+		s.Family, s.Type = s.Name, "untyped"
+		if typ, ok := types[s.Name]; ok {
+			s.Type = typ
+		} else {
+			for _, suffix := range []string{"_bucket", "_sum", "_count"} {
+				if base, ok := strings.CutSuffix(s.Name, suffix); ok && types[base] == "histogram" {
+					s.Family, s.Type = base, "histogram"
+					break
+				}
+			}
+		}
+		samples = append(samples, s)
+	}
+	return samples, sc.Err()
+}
+
+// parseSample parses `name{k="v",...} value [timestamp]`.
+func parseSample(line string) (Sample, error) {
+	var s Sample
+	i := strings.IndexAny(line, "{ ")
+	if i <= 0 {
+		return s, fmt.Errorf("malformed sample %q", line)
+	}
+	s.Name, line = line[:i], line[i:]
+	if line[0] == '{' {
+		line = line[1:]
+		for {
+			line = strings.TrimLeft(line, ", ")
+			if strings.HasPrefix(line, "}") {
+				line = line[1:]
+				break
+			}
+			eq := strings.Index(line, `="`)
+			if eq <= 0 {
+				return s, fmt.Errorf("malformed labels of %s", s.Name)
+			}
+			key := line[:eq]
+			value, rest, err := unquoteLabel(line[eq+2:])
+			if err != nil {
+				return s, fmt.Errorf("label %s of %s: %w", key, s.Name, err)
+			}
+			s.Labels = append(s.Labels, key, value)
+			line = rest
+		}
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return s, fmt.Errorf("missing value of %s", s.Name)
+	}
+	v, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return s, fmt.Errorf("invalid value of %s: %q", s.Name, fields[0])
+	}
+	s.Value = v
+	return s, nil
+}
+
+// unquoteLabel reads an escaped label value up to its closing quote and
+// returns the value and the rest of the line.
+func unquoteLabel(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return b.String(), s[i+1:], nil
+		case '\\':
+			i++
+			if i == len(s) {
+				return "", "", fmt.Errorf("unterminated value")
+			}
+			if s[i] == 'n' {
+				b.WriteByte('\n')
+			} else {
+				b.WriteByte(s[i])
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated value")
+}
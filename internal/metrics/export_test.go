@@ -0,0 +1,234 @@
+package metrics
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseText(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Millisecond})
+	h.Observe(time.Microsecond)
+	var buf bytes.Buffer
+	WriteHeader(&buf, "x_seconds", "histogram", "Test.")
+	h.Write(&buf, "x_seconds", "handler", `a"b`)
+	buf.WriteString("# TYPE up gauge\nup 1\nplain{k=\"v\\nw\"} 2.5 1700000000000\n")
+
+	samples, err := ParseText(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Sample{
+		{Name: "x_seconds_bucket", Family: "x_seconds", Type: "histogram", Labels: []string{"handler", `a"b`, "le", "0.001"}, Value: 1},
+		{Name: "x_seconds_bucket", Family: "x_seconds", Type: "histogram", Labels: []string{"handler", `a"b`, "le", "+Inf"}, Value: 1},
+		{Name: "x_seconds_sum", Family: "x_seconds", Type: "histogram", Labels: []string{"handler", `a"b`}, Value: 1e-06},
+		{Name: "x_seconds_count", Family: "x_seconds", Type: "histogram", Labels: []string{"handler", `a"b`}, Value: 1},
+		{Name: "up", Family: "up", Type: "gauge", Value: 1},
+		{Name: "plain", Family: "plain", Type: "untyped", Labels: []string{"k", "v\nw"}, Value: 2.5},
+	}
+	if !reflect.DeepEqual(samples, want) {
+		t.Errorf("got  %+v\nwant %+v", samples, want)
+	}
+
+	for _, bad := range []string{"x{k=\"v} 1", "x", "x one", "{} 1"} {
+		if _, err := ParseText(strings.NewReader(bad)); err == nil {
+			t.Errorf("ParseText(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	tests := []struct {
+		patterns []string
+		family   string
+		want     bool
+	}{
+		{nil, "anything", true},
+		{[]string{"quic_relay_handler_*"}, "quic_relay_handler_connect_seconds", true},
+		{[]string{"quic_relay_handler_*"}, "quic_relay_sessions", false},
+		{[]string{"up", "down"}, "down", true},
+		{[]string{"up"}, "upper", false},
+	}
+	for _, tt := range tests {
+		if got := allowed(tt.patterns, tt.family); got != tt.want {
+			t.Errorf("allowed(%q, %q) = %v, want %v", tt.patterns, tt.family, got, tt.want)
+		}
+	}
+}
+
+// snappyDecodeLiterals decodes the literal-only blocks snappyEncode writes.
+func snappyDecodeLiterals(t *testing.T, b []byte) []byte {
+	t.Helper()
+	n, k := binary.Uvarint(b)
+	b = b[k:]
+	var out []byte
+	for len(b) > 0 {
+		tag := b[0]
+		if tag&3 != 0 {
+			t.Fatalf("unexpected snappy tag %#x", tag)
+		}
+		size, hdr := int(tag>>2)+1, 1
+		switch tag >> 2 {
+		case 60:
+			size, hdr = int(b[1])+1, 2
+		case 61:
+			size, hdr = int(b[1])|int(b[2])<<8+1, 3
+		}
+		out = append(out, b[hdr:hdr+size]...)
+		b = b[hdr+size:]
+	}
+	if uint64(len(out)) != n {
+		t.Fatalf("decoded %d bytes, header says %d", len(out), n)
+	}
+	return out
+}
+
+func TestSnappyEncode(t *testing.T) {
+	for _, n := range []int{0, 1, 60, 61, 256, 257, 65536, 70000} {
+		src := bytes.Repeat([]byte{'x'}, n)
+		if got := snappyDecodeLiterals(t, snappyEncode(src)); !bytes.Equal(got, src) {
+			t.Errorf("round trip of %d bytes failed", n)
+		}
+	}
+}
+
+func TestRemoteWriter(t *testing.T) {
+	var body []byte
+	var header http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	rw, err := newRemoteWriter(ExportConfig{URL: srv.URL, Headers: map[string]string{"Authorization": "Bearer t"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rw.close()
+	samples := []Sample{{Name: "up", Labels: []string{"node", "edge-1", "az", "b"}, Value: 1}}
+	if err := rw.push(context.Background(), samples, time.UnixMilli(1700000000000)); err != nil {
+		t.Fatal(err)
+	}
+	if header.Get("Content-Encoding") != "snappy" || header.Get("Authorization") != "Bearer t" {
+		t.Errorf("headers = %v", header)
+	}
+	want := encodeWriteRequest(samples, 1700000000000)
+	if got := snappyDecodeLiterals(t, body); !bytes.Equal(got, want) {
+		t.Errorf("body = %x, want %x", got, want)
+	}
+
+	// Labels are sorted with __name__ first: "__name__" < "az" < "node"
+	idx := func(s string) int { return bytes.Index(want, []byte(s)) }
+	if !(idx("__name__") < idx("az") && idx("az") < idx("node")) {
+		t.Errorf("labels not sorted: %q", want)
+	}
+
+	srv.Config.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "out of order sample", http.StatusBadRequest)
+	})
+	if err := rw.push(context.Background(), samples, time.Now()); err == nil || !strings.Contains(err.Error(), "out of order") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestStatsd(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	read := func() string {
+		pc.SetReadDeadline(time.Now().Add(time.Second))
+		buf := make([]byte, 2048)
+		n, _, err := pc.ReadFrom(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(buf[:n])
+	}
+
+	samples := func(count float64) []Sample {
+		return []Sample{
+			{Name: "req_total", Type: "counter", Labels: []string{"route", "a.example.com"}, Value: count},
+			{Name: "sessions", Type: "gauge", Value: 7},
+		}
+	}
+
+	tests := []struct {
+		typ         string
+		first, next string
+	}{
+		{ExportStatsd, "relay.req_total.a_example_com:10|c\nrelay.sessions:7|g", "relay.req_total.a_example_com:5|c\nrelay.sessions:7|g"},
+		{ExportDogStatsd, "relay.req_total:10|c|#route:a_example_com\nrelay.sessions:7|g", "relay.req_total:5|c|#route:a_example_com\nrelay.sessions:7|g"},
+	}
+	for _, tt := range tests {
+		s, err := newStatsd(ExportConfig{Type: tt.typ, Address: pc.LocalAddr().String(), Prefix: "relay."})
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.push(context.Background(), samples(10), time.Now())
+		if got := read(); got != tt.first {
+			t.Errorf("%s first push = %q, want %q", tt.typ, got, tt.first)
+		}
+		s.push(context.Background(), samples(15), time.Now())
+		if got := read(); got != tt.next {
+			t.Errorf("%s second push = %q, want %q", tt.typ, got, tt.next)
+		}
+		s.close()
+	}
+}
+
+func TestConfigureExporters(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     ExportConfig
+		wantErr string
+	}{
+		{"unknown type", ExportConfig{Type: "graphite"}, "unknown exporter type"},
+		{"missing url", ExportConfig{Type: ExportRemoteWrite}, "requires an http(s) 'url'"},
+		{"negative interval", ExportConfig{Type: ExportStatsd, Interval: -1}, "'interval' must be >= 0"},
+	}
+	for _, tt := range tests {
+		err := ConfigureExporters([]ExportConfig{tt.cfg})
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error = %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+	Register(func(w io.Writer) {
+		WriteHeader(w, "export_test_up", "gauge", "A test gauge.")
+		io.WriteString(w, "export_test_up 1\n")
+	})
+	if err := ConfigureExporters([]ExportConfig{{
+		Type: ExportDogStatsd, Address: pc.LocalAddr().String(), Interval: 1,
+		Metrics: []string{"export_test_*"}, Labels: map[string]string{"node": "edge-1"},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	defer ConfigureExporters(nil)
+
+	pc.SetReadDeadline(time.Now().Add(3 * time.Second))
+	buf := make([]byte, 2048)
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != "export_test_up:1|g|#node:edge-1" {
+		t.Errorf("pushed %q", got)
+	}
+}
@@ -0,0 +1,144 @@
+// Package metrics exposes histograms in the Prometheus text format, without
+// pulling in a client library. Packages register collectors that write their
+// series; Handler serves all of them.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets covers hot-path work from a microsecond up to a second.
+var LatencyBuckets = []time.Duration{
+	time.Microsecond, 5 * time.Microsecond, 10 * time.Microsecond, 25 * time.Microsecond,
+	50 * time.Microsecond, 100 * time.Microsecond, 250 * time.Microsecond, 500 * time.Microsecond,
+	time.Millisecond, 2500 * time.Microsecond, 5 * time.Millisecond, 10 * time.Millisecond,
+	25 * time.Millisecond, 50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond,
+	500 * time.Millisecond, time.Second,
+}
+
+// Histogram counts durations into fixed buckets. It is safe for concurrent use.
+type Histogram struct {
+	bounds []time.Duration // Upper bounds, ascending
+	counts []atomic.Uint64 // One per bound plus +Inf
+	sum    atomic.Int64    // Nanoseconds
+}
+
+// NewHistogram creates a histogram with the given ascending upper bounds.
+func NewHistogram(bounds []time.Duration) *Histogram {
+	return &Histogram{bounds: bounds, counts: make([]atomic.Uint64, len(bounds)+1)}
+}
+
+// Observe records one duration.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < len(h.bounds) && d > h.bounds[i] {
+		i++
+	}
+	h.counts[i].Add(1)
+	h.sum.Add(int64(d))
+}
+
+// Count returns the number of observations.
+func (h *Histogram) Count() uint64 {
+	var n uint64
+	for i := range h.counts {
+		n += h.counts[i].Load()
+	}
+	return n
+}
+
+// WriteHeader writes the HELP and TYPE lines of a metric family.
+func WriteHeader(w io.Writer, name, typ, help string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Write writes the series of h as metric name with the given label pairs
+// ("key", "value", ...). The header must have been written before.
+func (h *Histogram) Write(w io.Writer, name string, labels ...string) {
+	base := formatLabels(labels)
+	sep := ""
+	if base != "" {
+		sep = ","
+	}
+	var cumulative uint64
+	for i, b := range h.bounds {
+		cumulative += h.counts[i].Load()
+		le := strconv.FormatFloat(b.Seconds(), 'g', -1, 64)
+		fmt.Fprintf(w, "%s_bucket{%s%sle=%q} %d\n", name, base, sep, le, cumulative)
+	}
+	cumulative += h.counts[len(h.bounds)].Load()
+	fmt.Fprintf(w, "%s_bucket{%s%sle=\"+Inf\"} %d\n", name, base, sep, cumulative)
+	braced := ""
+	if base != "" {
+		braced = "{" + base + "}"
+	}
+	fmt.Fprintf(w, "%s_sum%s %s\n", name, braced, strconv.FormatFloat(time.Duration(h.sum.Load()).Seconds(), 'g', -1, 64))
+	fmt.Fprintf(w, "%s_count%s %d\n", name, braced, cumulative)
+}
+
+// WriteSample writes one sample of metric name with the given label pairs
+// ("key", "value", ...). The header must have been written before.
+func WriteSample(w io.Writer, name string, value uint64, labels ...string) {
+	if base := formatLabels(labels); base != "" {
+		fmt.Fprintf(w, "%s{%s} %d\n", name, base, value)
+		return
+	}
+	fmt.Fprintf(w, "%s %d\n", name, value)
+}
+
+// labelEscaper escapes label values as the text format requires.
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(pairs []string) string {
+	var b strings.Builder
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(pairs[i])
+		b.WriteString(`="`)
+		labelEscaper.WriteString(&b, pairs[i+1])
+		b.WriteByte('"')
+	}
+	return b.String()
+}
+
+var (
+	collectorsMu sync.Mutex
+	collectors   []func(io.Writer)
+)
+
+// Register adds a collector that writes metric families when scraped.
+func Register(collect func(io.Writer)) {
+	collectorsMu.Lock()
+	defer collectorsMu.Unlock()
+	collectors = append(collectors, collect)
+}
+
+// WriteAll writes the output of all collectors.
+func WriteAll(w io.Writer) {
+	collectorsMu.Lock()
+	all := collectors
+	collectorsMu.Unlock()
+	for _, collect := range all {
+		collect(w)
+	}
+}
+
+// Handler serves all registered metrics in the Prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		bw := bufio.NewWriter(w)
+		WriteAll(bw)
+		bw.Flush()
+	})
+}
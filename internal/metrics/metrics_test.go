@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"bytes"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHistogram_Write(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Millisecond, 10 * time.Millisecond})
+	h.Observe(500 * time.Microsecond)
+	h.Observe(time.Millisecond) // Bounds are inclusive
+	h.Observe(5 * time.Millisecond)
+	h.Observe(time.Second)
+
+	var buf bytes.Buffer
+	h.Write(&buf, "x_seconds", "handler", `a"b`)
+	want := `x_seconds_bucket{handler="a\"b",le="0.001"} 2
+x_seconds_bucket{handler="a\"b",le="0.01"} 3
+x_seconds_bucket{handler="a\"b",le="+Inf"} 4
+x_seconds_sum{handler="a\"b"} 1.0065
+x_seconds_count{handler="a\"b"} 4
+`
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+	if h.Count() != 4 {
+		t.Errorf("count = %d, want 4", h.Count())
+	}
+}
+
+func TestHistogram_WriteNoLabels(t *testing.T) {
+	h := NewHistogram([]time.Duration{time.Second})
+	var buf bytes.Buffer
+	h.Write(&buf, "x")
+	want := "x_bucket{le=\"1\"} 0\nx_bucket{le=\"+Inf\"} 0\nx_sum 0\nx_count 0\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestWriteSample(t *testing.T) {
+	var buf bytes.Buffer
+	WriteSample(&buf, "x_total", 3)
+	WriteSample(&buf, "x_total", 7, "team", "plat\nform", "env", "prod")
+	want := "x_total 3\nx_total{team=\"plat\\nform\",env=\"prod\"} 7\n"
+	if buf.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestHandler(t *testing.T) {
+	Register(func(w io.Writer) {
+		WriteHeader(w, "test_total", "counter", "A test counter.")
+		io.WriteString(w, "test_total 1\n")
+	})
+	rec := httptest.NewRecorder()
+	Handler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("content type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "# TYPE test_total counter\ntest_total 1\n") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+}
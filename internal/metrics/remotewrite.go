@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"slices"
+	"time"
+)
+
+// remoteWriter pushes samples with the Prometheus remote write protocol (v1):
+// a snappy-compressed protobuf WriteRequest per push.
+type remoteWriter struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func newRemoteWriter(cfg ExportConfig) (*remoteWriter, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("remote_write requires an http(s) 'url'")
+	}
+	return &remoteWriter{url: cfg.URL, headers: cfg.Headers, client: &http.Client{}}, nil
+}
+
+func (rw *remoteWriter) push(ctx context.Context, samples []Sample, now time.Time) error {
+	if len(samples) == 0 {
+		return nil
+	}
+	body := snappyEncode(encodeWriteRequest(samples, now.UnixMilli()))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, rw.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-protobuf")
+	req.Header.Set("Content-Encoding", "snappy")
+	req.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	for k, v := range rw.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := rw.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 256))
+		return fmt.Errorf("%s: %s %s", rw.url, resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+func (rw *remoteWriter) close() {
+	rw.client.CloseIdleConnections()
+}
+
+// encodeWriteRequest encodes samples as a prometheus.WriteRequest:
+//
+//	WriteRequest { repeated TimeSeries timeseries = 1; }
+//	TimeSeries   { repeated Label labels = 1; repeated Sample samples = 2; }
+//	Label        { string name = 1; string value = 2; }
+//	Sample       { double value = 1; int64 timestamp = 2; }
+//
+// Labels are sorted by name, with __name__ first, as receivers require.
+func encodeWriteRequest(samples []Sample, timestamp int64) []byte {
+	var req, series, field []byte
+	for _, s := range samples {
+		labels := make([][2]string, 0, len(s.Labels)/2+1)
+		labels = append(labels, [2]string{"__name__", s.Name})
+		for i := 0; i+1 < len(s.Labels); i += 2 {
+			labels = append(labels, [2]string{s.Labels[i], s.Labels[i+1]})
+		}
+		slices.SortStableFunc(labels, func(a, b [2]string) int { return cmp.Compare(a[0], b[0]) })
+
+		series = series[:0]
+		for _, l := range labels {
+			field = field[:0]
+			field = appendBytesField(field, 1, []byte(l[0]))
+			field = appendBytesField(field, 2, []byte(l[1]))
+			series = appendBytesField(series, 1, field)
+		}
+		field = field[:0]
+		field = binary.AppendUvarint(field, 1<<3|1) // Field 1, 64-bit
+		field = binary.LittleEndian.AppendUint64(field, math.Float64bits(s.Value))
+		field = binary.AppendUvarint(field, 2<<3|0) // Field 2, varint
+		field = binary.AppendUvarint(field, uint64(timestamp))
+		series = appendBytesField(series, 2, field)
+
+		req = appendBytesField(req, 1, series)
+	}
+	return req
+}
+
+// appendBytesField appends a length-delimited protobuf field.
+func appendBytesField(b []byte, num int, v []byte) []byte {
+	b = binary.AppendUvarint(b, uint64(num)<<3|2)
+	b = binary.AppendUvarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+// snappyEncode frames src as a snappy block of literals. It does not
+// compress, but any snappy decoder accepts it, which is all remote write needs.
+func snappyEncode(src []byte) []byte {
+	dst := binary.AppendUvarint(make([]byte, 0, len(src)+len(src)/65536*5+10), uint64(len(src)))
+	for len(src) > 0 {
+		n := min(len(src), 65536)
+		switch {
+		case n <= 60:
+			dst = append(dst, byte(n-1)<<2)
+		case n <= 256:
+			dst = append(dst, 60<<2, byte(n-1))
+		default:
+			dst = append(dst, 61<<2, byte(n-1), byte((n-1)>>8))
+		}
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+	}
+	return dst
+}
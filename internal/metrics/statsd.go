@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultStatsdAddress = "127.0.0.1:8125"
+	maxStatsdDatagram    = 1432 // Fits a 1500 byte MTU with IPv6 and UDP headers
+)
+
+// statsd pushes samples as statsd lines over UDP. Counters and histogram
+// series are sent as increments since the previous push, other types as gauges.
+type statsd struct {
+	conn   net.Conn
+	prefix string
+	tags   bool               // dogstatsd: labels as tags instead of name parts
+	last   map[string]float64 // Previous value of each cumulative series
+}
+
+func newStatsd(cfg ExportConfig) (*statsd, error) {
+	addr := cfg.Address
+	if addr == "" {
+		addr = defaultStatsdAddress
+	}
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("statsd address: %w", err)
+	}
+	return &statsd{conn: conn, prefix: cfg.Prefix, tags: cfg.Type == ExportDogStatsd, last: make(map[string]float64)}, nil
+}
+
+func (s *statsd) push(_ context.Context, samples []Sample, _ time.Time) error {
+	var buf []byte
+	var firstErr error
+	flush := func() {
+		if len(buf) == 0 {
+			return
+		}
+		if _, err := s.conn.Write(buf); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		buf = buf[:0]
+	}
+	for _, sample := range samples {
+		line := s.line(sample)
+		if line == "" {
+			continue
+		}
+		if len(buf) > 0 && len(buf)+1+len(line) > maxStatsdDatagram {
+			flush()
+		}
+		if len(buf) > 0 {
+			buf = append(buf, '\n')
+		}
+		buf = append(buf, line...)
+	}
+	flush()
+	return firstErr
+}
+
+// line formats one sample, or returns "" for a cumulative series that did not change.
+func (s *statsd) line(sample Sample) string {
+	value, typ := sample.Value, "g"
+	if sample.Type == "counter" || sample.Type == "histogram" {
+		key := sample.Name + "\x00" + strings.Join(sample.Labels, "\x00")
+		prev, seen := s.last[key]
+		s.last[key] = value
+		if seen && value >= prev {
+			value -= prev
+		}
+		if value == 0 {
+			return ""
+		}
+		typ = "c"
+	}
+
+	var b strings.Builder
+	b.WriteString(s.prefix)
+	b.WriteString(sample.Name)
+	if !s.tags {
+		for i := 1; i < len(sample.Labels); i += 2 {
+			b.WriteByte('.')
+			b.WriteString(statsdSanitize(sample.Labels[i]))
+		}
+	}
+	b.WriteByte(':')
+	b.WriteString(strconv.FormatFloat(value, 'g', -1, 64))
+	b.WriteByte('|')
+	b.WriteString(typ)
+	if s.tags && len(sample.Labels) > 1 {
+		b.WriteString("|#")
+		for i := 0; i+1 < len(sample.Labels); i += 2 {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(sample.Labels[i])
+			b.WriteByte(':')
+			b.WriteString(statsdSanitize(sample.Labels[i+1]))
+		}
+	}
+	return b.String()
+}
+
+// statsdSanitize replaces characters with a meaning in statsd lines or names.
+func statsdSanitize(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch r {
+		case ':', '|', ',', '@', '#', '.', ' ', '\n':
+			return '_'
+		}
+		return r
+	}, v)
+}
+
+func (s *statsd) close() {
+	s.conn.Close()
+}
@@ -0,0 +1,267 @@
+// Package netflow encodes flow records as IPFIX (RFC 7011) or NetFlow v9
+// (RFC 3954) export messages, for sending to a flow collector over UDP.
+package netflow
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+	"time"
+)
+
+// Export formats.
+const (
+	IPFIX     = "ipfix"
+	NetFlowV9 = "netflow9"
+)
+
+// MaxMessage is the largest message Encode builds, so messages fit in one
+// datagram on common paths.
+const MaxMessage = 1400
+
+// Template IDs of the data records, one per address family.
+const (
+	templateIPv4 = 256
+	templateIPv6 = 257
+)
+
+// Information elements used in the templates. IPFIX and NetFlow v9 share
+// the numbers, except for the flow times.
+const (
+	ieOctetDeltaCount  = 1
+	iePacketDeltaCount = 2
+	ieProtocol         = 4
+	ieSrcPort          = 7
+	ieSrcIPv4          = 8
+	ieDstPort          = 11
+	ieDstIPv4          = 12
+	ieLastSwitched     = 21 // NetFlow v9: sysUptime in ms
+	ieFirstSwitched    = 22
+	ieSrcIPv6          = 27
+	ieDstIPv6          = 28
+	ieApplicationName  = 96 // Carries the SNI
+	ieFlowStartMillis  = 152
+	ieFlowEndMillis    = 153
+)
+
+// v9NameLength is the fixed length of the SNI field in NetFlow v9, which has
+// no variable length fields. Longer names are cut.
+const v9NameLength = 64
+
+// variableLength marks a variable length field in IPFIX templates.
+const variableLength = 0xffff
+
+// Record is one unidirectional flow.
+type Record struct {
+	Src, Dst   netip.AddrPort
+	Protocol   uint8 // IP protocol, 17 for UDP
+	Bytes      uint64
+	Packets    uint64
+	Start, End time.Time
+	SNI        string
+}
+
+type field struct{ id, length uint16 }
+
+// Encoder builds export messages. It keeps the sequence number, so one
+// Encoder must be used per collector. It is not safe for concurrent use.
+type Encoder struct {
+	format string
+	domain uint32
+	seq    uint32
+	boot   time.Time // NetFlow v9 flow times are relative to it
+}
+
+// NewEncoder returns an encoder for format with the given observation
+// domain (IPFIX) or source ID (NetFlow v9).
+func NewEncoder(format string, domain uint32) (*Encoder, error) {
+	switch format {
+	case IPFIX, NetFlowV9:
+	default:
+		return nil, fmt.Errorf("unknown flow export format %q", format)
+	}
+	return &Encoder{format: format, domain: domain, boot: time.Now()}, nil
+}
+
+// fields returns the template of records of one address family.
+func (e *Encoder) fields(v6 bool) []field {
+	addr, src, dst := uint16(4), uint16(ieSrcIPv4), uint16(ieDstIPv4)
+	if v6 {
+		addr, src, dst = 16, ieSrcIPv6, ieDstIPv6
+	}
+	fs := []field{
+		{src, addr}, {dst, addr}, {ieSrcPort, 2}, {ieDstPort, 2}, {ieProtocol, 1},
+		{ieOctetDeltaCount, 8}, {iePacketDeltaCount, 8},
+	}
+	if e.format == IPFIX {
+		return append(fs, field{ieFlowStartMillis, 8}, field{ieFlowEndMillis, 8}, field{ieApplicationName, variableLength})
+	}
+	return append(fs, field{ieFirstSwitched, 4}, field{ieLastSwitched, 4}, field{ieApplicationName, v9NameLength})
+}
+
+// Encode returns the messages carrying records, each at most MaxMessage
+// bytes. With templates set, the first message starts with the templates;
+// collectors need them before they can decode records, so send them first
+// and again at intervals.
+func (e *Encoder) Encode(records []Record, templates bool, now time.Time) [][]byte {
+	var msgs [][]byte
+	var msg []byte
+	var count, data int // Records and data records in msg
+	setStart, setID := -1, -1
+
+	closeSet := func() {
+		if setStart < 0 {
+			return
+		}
+		if e.format == NetFlowV9 {
+			for len(msg)%4 != 0 { // v9 flowsets are padded to 32 bits
+				msg = append(msg, 0)
+			}
+		}
+		binary.BigEndian.PutUint16(msg[setStart+2:], uint16(len(msg)-setStart))
+		setStart, setID = -1, -1
+	}
+	flush := func() {
+		closeSet()
+		if msg != nil {
+			msgs = append(msgs, e.finish(msg, count, data, now))
+		}
+		msg, count, data = nil, 0, 0
+	}
+	openSet := func(id int) {
+		if setID == id {
+			return
+		}
+		closeSet()
+		if msg == nil {
+			msg = make([]byte, e.headerLen(), MaxMessage)
+		}
+		setStart, setID = len(msg), id
+		msg = binary.BigEndian.AppendUint16(msg, uint16(id))
+		msg = append(msg, 0, 0) // Length, set by closeSet
+	}
+
+	if templates {
+		openSet(e.templateSetID())
+		for _, v6 := range []bool{false, true} {
+			id := uint16(templateIPv4)
+			if v6 {
+				id = templateIPv6
+			}
+			fs := e.fields(v6)
+			msg = binary.BigEndian.AppendUint16(msg, id)
+			msg = binary.BigEndian.AppendUint16(msg, uint16(len(fs)))
+			for _, f := range fs {
+				msg = binary.BigEndian.AppendUint16(msg, f.id)
+				msg = binary.BigEndian.AppendUint16(msg, f.length)
+			}
+			count++
+		}
+	}
+
+	for _, r := range records {
+		v6 := !r.Src.Addr().Unmap().Is4() || !r.Dst.Addr().Unmap().Is4()
+		rec := e.appendRecord(nil, r, v6)
+		id := templateIPv4
+		if v6 {
+			id = templateIPv6
+		}
+		extra := len(rec) + 3 // Padding
+		if setID != id {
+			extra += 4
+		}
+		if msg != nil && len(msg)+extra > MaxMessage {
+			flush()
+		}
+		openSet(id)
+		msg = append(msg, rec...)
+		count++
+		data++
+	}
+	flush()
+	return msgs
+}
+
+func (e *Encoder) templateSetID() int {
+	if e.format == IPFIX {
+		return 2
+	}
+	return 0
+}
+
+func (e *Encoder) headerLen() int {
+	if e.format == IPFIX {
+		return 16
+	}
+	return 20
+}
+
+// finish fills in the header of a message with count records, data of
+// them data records.
+func (e *Encoder) finish(msg []byte, count, data int, now time.Time) []byte {
+	if e.format == IPFIX {
+		binary.BigEndian.PutUint16(msg[0:], 10)
+		binary.BigEndian.PutUint16(msg[2:], uint16(len(msg)))
+		binary.BigEndian.PutUint32(msg[4:], uint32(now.Unix()))
+		// The sequence number counts the data records sent before this message
+		binary.BigEndian.PutUint32(msg[8:], e.seq)
+		binary.BigEndian.PutUint32(msg[12:], e.domain)
+		e.seq += uint32(data)
+		return msg
+	}
+	binary.BigEndian.PutUint16(msg[0:], 9)
+	binary.BigEndian.PutUint16(msg[2:], uint16(count))
+	binary.BigEndian.PutUint32(msg[4:], e.uptime(now))
+	binary.BigEndian.PutUint32(msg[8:], uint32(now.Unix()))
+	binary.BigEndian.PutUint32(msg[12:], e.seq)
+	binary.BigEndian.PutUint32(msg[16:], e.domain)
+	e.seq++
+	return msg
+}
+
+// uptime returns t as milliseconds since the encoder was created, the
+// NetFlow v9 sysUptime.
+func (e *Encoder) uptime(t time.Time) uint32 {
+	if t.Before(e.boot) {
+		return 0
+	}
+	return uint32(t.Sub(e.boot).Milliseconds())
+}
+
+// appendRecord appends the data record of r in the template of its family.
+func (e *Encoder) appendRecord(b []byte, r Record, v6 bool) []byte {
+	for _, ap := range []netip.AddrPort{r.Src, r.Dst} {
+		addr := ap.Addr().Unmap()
+		if v6 {
+			a := addr.As16()
+			b = append(b, a[:]...)
+		} else {
+			a := addr.As4()
+			b = append(b, a[:]...)
+		}
+	}
+	b = binary.BigEndian.AppendUint16(b, r.Src.Port())
+	b = binary.BigEndian.AppendUint16(b, r.Dst.Port())
+	b = append(b, r.Protocol)
+	b = binary.BigEndian.AppendUint64(b, r.Bytes)
+	b = binary.BigEndian.AppendUint64(b, r.Packets)
+
+	sni := r.SNI
+	if e.format == NetFlowV9 {
+		b = binary.BigEndian.AppendUint32(b, e.uptime(r.Start))
+		b = binary.BigEndian.AppendUint32(b, e.uptime(r.End))
+		sni = sni[:min(len(sni), v9NameLength)]
+		b = append(b, sni...)
+		return append(b, make([]byte, v9NameLength-len(sni))...)
+	}
+	b = binary.BigEndian.AppendUint64(b, uint64(r.Start.UnixMilli()))
+	b = binary.BigEndian.AppendUint64(b, uint64(r.End.UnixMilli()))
+	sni = sni[:min(len(sni), 1024)] // Keeps a record well within MaxMessage
+	if len(sni) < 255 {
+		b = append(b, byte(len(sni)))
+	} else {
+		b = append(b, 255)
+		b = binary.BigEndian.AppendUint16(b, uint16(len(sni)))
+	}
+	return append(b, sni...)
+}
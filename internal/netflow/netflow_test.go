@@ -0,0 +1,140 @@
+package netflow
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+// sets splits a message body into set ID and contents.
+func sets(t *testing.T, body []byte) (ids []uint16, contents [][]byte) {
+	t.Helper()
+	for len(body) > 0 {
+		if len(body) < 4 {
+			t.Fatalf("trailing %d bytes", len(body))
+		}
+		l := int(binary.BigEndian.Uint16(body[2:]))
+		if l < 4 || l > len(body) {
+			t.Fatalf("set length %d of %d bytes", l, len(body))
+		}
+		ids = append(ids, binary.BigEndian.Uint16(body))
+		contents = append(contents, body[4:l])
+		body = body[l:]
+	}
+	return ids, contents
+}
+
+func testRecord(src, dst string) Record {
+	start := time.Unix(1760000000, 0)
+	return Record{
+		Src: netip.MustParseAddrPort(src), Dst: netip.MustParseAddrPort(dst), Protocol: 17,
+		Bytes: 12000, Packets: 10, Start: start, End: start.Add(time.Minute), SNI: "play.example.com",
+	}
+}
+
+func TestEncode_IPFIX(t *testing.T) {
+	enc, err := NewEncoder(IPFIX, 7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1760000100, 0)
+	msgs := enc.Encode([]Record{
+		testRecord("192.0.2.1:50000", "198.51.100.1:5520"),
+		testRecord("[2001:db8::1]:50000", "[2001:db8::2]:5520"),
+	}, true, now)
+	if len(msgs) != 1 {
+		t.Fatalf("%d messages", len(msgs))
+	}
+	msg := msgs[0]
+	if v := binary.BigEndian.Uint16(msg); v != 10 {
+		t.Errorf("version = %d", v)
+	}
+	if l := binary.BigEndian.Uint16(msg[2:]); int(l) != len(msg) {
+		t.Errorf("length = %d, message %d bytes", l, len(msg))
+	}
+	if ts, seq, domain := binary.BigEndian.Uint32(msg[4:]), binary.BigEndian.Uint32(msg[8:]), binary.BigEndian.Uint32(msg[12:]); ts != 1760000100 || seq != 0 || domain != 7 {
+		t.Errorf("export time %d, sequence %d, domain %d", ts, seq, domain)
+	}
+	ids, contents := sets(t, msg[16:])
+	if len(ids) != 3 || ids[0] != 2 || ids[1] != templateIPv4 || ids[2] != templateIPv6 {
+		t.Fatalf("sets = %v", ids)
+	}
+
+	// IPv4 record: addresses, ports, protocol, counters, times, SNI
+	rec := contents[1]
+	if got := netip.AddrFrom4([4]byte(rec[0:4])); got.String() != "192.0.2.1" {
+		t.Errorf("source = %s", got)
+	}
+	if port := binary.BigEndian.Uint16(rec[10:]); port != 5520 || rec[12] != 17 {
+		t.Errorf("destination port %d, protocol %d", port, rec[12])
+	}
+	if bytes, pkts := binary.BigEndian.Uint64(rec[13:]), binary.BigEndian.Uint64(rec[21:]); bytes != 12000 || pkts != 10 {
+		t.Errorf("bytes %d, packets %d", bytes, pkts)
+	}
+	if start := binary.BigEndian.Uint64(rec[29:]); start != 1760000000000 {
+		t.Errorf("start = %d", start)
+	}
+	if n := int(rec[45]); string(rec[46:46+n]) != "play.example.com" {
+		t.Errorf("SNI = %q", rec[46:46+n])
+	}
+
+	// The sequence number counts the data records sent before
+	next := enc.Encode([]Record{testRecord("192.0.2.1:50000", "198.51.100.1:5520")}, false, now)
+	if seq := binary.BigEndian.Uint32(next[0][8:]); seq != 2 {
+		t.Errorf("next sequence = %d, want 2", seq)
+	}
+}
+
+func TestEncode_NetFlowV9(t *testing.T) {
+	enc, err := NewEncoder(NetFlowV9, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := testRecord("192.0.2.1:50000", "198.51.100.1:5520")
+	r.SNI = strings.Repeat("x", 100)
+	msgs := enc.Encode([]Record{r}, true, time.Now())
+	if len(msgs) != 1 {
+		t.Fatalf("%d messages", len(msgs))
+	}
+	msg := msgs[0]
+	if v, count := binary.BigEndian.Uint16(msg), binary.BigEndian.Uint16(msg[2:]); v != 9 || count != 3 {
+		t.Errorf("version %d, count %d; want 9, 3 (two templates, one record)", v, count)
+	}
+	ids, contents := sets(t, msg[20:])
+	if len(ids) != 2 || ids[0] != 0 || ids[1] != templateIPv4 {
+		t.Fatalf("flowsets = %v", ids)
+	}
+	if len(contents[1])%4 != 0 || len(contents[1]) < 4+4+2+2+1+8+8+4+4+v9NameLength {
+		t.Errorf("data flowset of %d bytes", len(contents[1]))
+	}
+	if seq := binary.BigEndian.Uint32(enc.Encode(nil, true, time.Now())[0][12:]); seq != 1 {
+		t.Errorf("second message sequence = %d, want 1", seq)
+	}
+}
+
+func TestEncode_Split(t *testing.T) {
+	for _, format := range []string{IPFIX, NetFlowV9} {
+		enc, _ := NewEncoder(format, 0)
+		records := make([]Record, 100)
+		for i := range records {
+			records[i] = testRecord("192.0.2.1:50000", "198.51.100.1:5520")
+		}
+		msgs := enc.Encode(records, true, time.Now())
+		if len(msgs) < 2 {
+			t.Errorf("%s: %d messages for 100 records", format, len(msgs))
+		}
+		for _, msg := range msgs {
+			if len(msg) > MaxMessage {
+				t.Errorf("%s: message of %d bytes", format, len(msg))
+			}
+		}
+	}
+	if enc, _ := NewEncoder(IPFIX, 0); len(enc.Encode(nil, false, time.Now())) != 0 {
+		t.Error("message without records or templates")
+	}
+	if _, err := NewEncoder("sflow", 0); err == nil {
+		t.Error("unknown format accepted")
+	}
+}
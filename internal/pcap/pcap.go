@@ -0,0 +1,283 @@
+// Package pcap reads UDP datagrams from packet captures in the classic
+// libpcap format, as written by tcpdump -w. pcapng files are not supported;
+// convert them with editcap -F pcap. IP fragments are skipped.
+package pcap
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net/netip"
+	"time"
+)
+
+// Link types the reader understands.
+const (
+	linkNull     = 0   // BSD loopback
+	linkEthernet = 1   // Ethernet
+	linkRaw      = 101 // Raw IP
+	linkLoop     = 108 // OpenBSD loopback
+	linkSLL      = 113 // Linux cooked capture (tcpdump -i any)
+	linkSLL2     = 276 // Linux cooked capture v2
+	linkRawAlt   = 12  // Raw IP on some platforms
+	linkRawAlt2  = 14
+)
+
+const (
+	etherTypeIPv4 = 0x0800
+	etherTypeIPv6 = 0x86dd
+	etherTypeVLAN = 0x8100
+	etherTypeQinQ = 0x88a8
+	protoUDP      = 17
+)
+
+// maxSnapLen bounds the record size accepted, protecting against corrupt files.
+const maxSnapLen = 256 * 1024
+
+// Datagram is a UDP datagram read from a capture.
+type Datagram struct {
+	Time    time.Time
+	Src     netip.AddrPort
+	Dst     netip.AddrPort
+	Payload []byte
+}
+
+// Reader reads UDP datagrams from a capture.
+type Reader struct {
+	r        io.Reader
+	order    binary.ByteOrder
+	nano     bool
+	linkType uint32
+	hdr      [16]byte
+
+	// Skipped counts records that were not complete UDP datagrams.
+	Skipped int
+}
+
+// NewReader reads the capture file header from r.
+func NewReader(r io.Reader) (*Reader, error) {
+	var hdr [24]byte
+	if _, err := io.ReadFull(r, hdr[:]); err != nil {
+		return nil, fmt.Errorf("reading pcap header: %w", err)
+	}
+	pr := &Reader{r: r}
+	switch magic := binary.LittleEndian.Uint32(hdr[:4]); magic {
+	case 0xa1b2c3d4:
+		pr.order = binary.LittleEndian
+	case 0xa1b23c4d:
+		pr.order, pr.nano = binary.LittleEndian, true
+	case 0xd4c3b2a1:
+		pr.order = binary.BigEndian
+	case 0x4d3cb2a1:
+		pr.order, pr.nano = binary.BigEndian, true
+	case 0x0a0d0d0a:
+		return nil, errors.New("pcapng is not supported, convert with: editcap -F pcap in.pcapng out.pcap")
+	default:
+		return nil, fmt.Errorf("not a pcap file (magic %08x)", magic)
+	}
+	pr.linkType = pr.order.Uint32(hdr[20:24]) & 0x0fffffff
+	switch pr.linkType {
+	case linkNull, linkEthernet, linkRaw, linkLoop, linkSLL, linkSLL2, linkRawAlt, linkRawAlt2:
+	default:
+		return nil, fmt.Errorf("unsupported link type %d", pr.linkType)
+	}
+	return pr, nil
+}
+
+// Next returns the next UDP datagram. Records that are not UDP over IPv4 or
+// IPv6 are skipped and counted. It returns io.EOF at the end of the capture.
+func (r *Reader) Next() (Datagram, error) {
+	for {
+		if _, err := io.ReadFull(r.r, r.hdr[:]); err != nil {
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return Datagram{}, fmt.Errorf("truncated record header: %w", err)
+			}
+			return Datagram{}, err
+		}
+		sec, frac := r.order.Uint32(r.hdr[0:4]), r.order.Uint32(r.hdr[4:8])
+		capLen := r.order.Uint32(r.hdr[8:12])
+		if capLen > maxSnapLen {
+			return Datagram{}, fmt.Errorf("record of %d bytes exceeds %d", capLen, maxSnapLen)
+		}
+		data := make([]byte, capLen)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return Datagram{}, fmt.Errorf("truncated record: %w", err)
+		}
+
+		d, ok := r.decode(data)
+		if !ok {
+			r.Skipped++
+			continue
+		}
+		if !r.nano {
+			frac *= 1000
+		}
+		d.Time = time.Unix(int64(sec), int64(frac))
+		return d, nil
+	}
+}
+
+// decode strips the link layer header and parses the IP and UDP headers.
+func (r *Reader) decode(data []byte) (Datagram, bool) {
+	var etherType uint16
+	switch r.linkType {
+	case linkEthernet:
+		if len(data) < 14 {
+			return Datagram{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[12:14]), data[14:]
+		for etherType == etherTypeVLAN || etherType == etherTypeQinQ {
+			if len(data) < 4 {
+				return Datagram{}, false
+			}
+			etherType, data = binary.BigEndian.Uint16(data[2:4]), data[4:]
+		}
+	case linkSLL:
+		if len(data) < 16 {
+			return Datagram{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[14:16]), data[16:]
+	case linkSLL2:
+		if len(data) < 20 {
+			return Datagram{}, false
+		}
+		etherType, data = binary.BigEndian.Uint16(data[0:2]), data[20:]
+	case linkNull, linkLoop:
+		if len(data) < 4 {
+			return Datagram{}, false
+		}
+		data = data[4:] // Address family in host order; the IP version tells us
+	}
+
+	if etherType != 0 && etherType != etherTypeIPv4 && etherType != etherTypeIPv6 {
+		return Datagram{}, false
+	}
+	if len(data) == 0 {
+		return Datagram{}, false
+	}
+	switch data[0] >> 4 {
+	case 4:
+		return decodeIPv4(data)
+	case 6:
+		return decodeIPv6(data)
+	}
+	return Datagram{}, false
+}
+
+func decodeIPv4(data []byte) (Datagram, bool) {
+	if len(data) < 20 {
+		return Datagram{}, false
+	}
+	ihl := int(data[0]&0x0f) * 4
+	total := int(binary.BigEndian.Uint16(data[2:4]))
+	if ihl < 20 || total < ihl || len(data) < total || data[9] != protoUDP {
+		return Datagram{}, false
+	}
+	if flags := binary.BigEndian.Uint16(data[6:8]); flags&0x2000 != 0 || flags&0x1fff != 0 {
+		return Datagram{}, false // Fragment
+	}
+	src := netip.AddrFrom4([4]byte(data[12:16]))
+	dst := netip.AddrFrom4([4]byte(data[16:20]))
+	return decodeUDP(src, dst, data[ihl:total])
+}
+
+func decodeIPv6(data []byte) (Datagram, bool) {
+	if len(data) < 40 || data[6] != protoUDP {
+		return Datagram{}, false // Extension headers are not followed
+	}
+	payload := int(binary.BigEndian.Uint16(data[4:6]))
+	if len(data) < 40+payload {
+		return Datagram{}, false
+	}
+	src := netip.AddrFrom16([16]byte(data[8:24]))
+	dst := netip.AddrFrom16([16]byte(data[24:40]))
+	return decodeUDP(src, dst, data[40:40+payload])
+}
+
+func decodeUDP(src, dst netip.Addr, data []byte) (Datagram, bool) {
+	if len(data) < 8 {
+		return Datagram{}, false
+	}
+	length := int(binary.BigEndian.Uint16(data[4:6]))
+	if length < 8 || length > len(data) {
+		return Datagram{}, false
+	}
+	return Datagram{
+		Src:     netip.AddrPortFrom(src, binary.BigEndian.Uint16(data[0:2])),
+		Dst:     netip.AddrPortFrom(dst, binary.BigEndian.Uint16(data[2:4])),
+		Payload: data[8:length],
+	}, true
+}
+
+// Writer writes UDP datagrams as a raw IP capture. The honeypot handler
+// records its traffic with it; tests use it to build captures.
+type Writer struct {
+	w io.Writer
+}
+
+// NewWriter writes the capture file header to w.
+func NewWriter(w io.Writer) (*Writer, error) {
+	var hdr [24]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b23c4d)
+	binary.LittleEndian.PutUint16(hdr[4:6], 2)
+	binary.LittleEndian.PutUint16(hdr[6:8], 4)
+	binary.LittleEndian.PutUint32(hdr[16:20], maxSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], linkRaw)
+	if _, err := w.Write(hdr[:]); err != nil {
+		return nil, err
+	}
+	return &Writer{w: w}, nil
+}
+
+// Write appends d to the capture. Checksums are left zero.
+func (w *Writer) Write(d Datagram) error {
+	pkt := AppendPacket(nil, d, len(d.Payload))
+	return w.WritePacket(d.Time, pkt, len(pkt))
+}
+
+// WritePacket appends a raw IP packet captured at t, of which pkt may be the
+// first bytes of orig.
+func (w *Writer) WritePacket(t time.Time, pkt []byte, orig int) error {
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[0:4], uint32(t.Unix()))
+	binary.LittleEndian.PutUint32(hdr[4:8], uint32(t.Nanosecond()))
+	binary.LittleEndian.PutUint32(hdr[8:12], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(hdr[12:16], uint32(orig))
+	if _, err := w.w.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := w.w.Write(pkt)
+	return err
+}
+
+// AppendPacket appends d to b as a raw IP packet with a UDP header, and
+// returns the extended slice. The headers give size as the payload length;
+// d.Payload may be its first bytes only. Checksums are left zero.
+func AppendPacket(b []byte, d Datagram, size int) []byte {
+	udpLen := 8 + size
+	if d.Src.Addr().Is4() {
+		ip := make([]byte, 20)
+		ip[0] = 0x45
+		binary.BigEndian.PutUint16(ip[2:4], uint16(20+udpLen))
+		ip[8], ip[9] = 64, protoUDP
+		src, dst := d.Src.Addr().As4(), d.Dst.Addr().As4()
+		copy(ip[12:16], src[:])
+		copy(ip[16:20], dst[:])
+		b = append(b, ip...)
+	} else {
+		ip := make([]byte, 40)
+		ip[0] = 0x60
+		binary.BigEndian.PutUint16(ip[4:6], uint16(udpLen))
+		ip[6], ip[7] = protoUDP, 64
+		src, dst := d.Src.Addr().As16(), d.Dst.Addr().As16()
+		copy(ip[8:24], src[:])
+		copy(ip[24:40], dst[:])
+		b = append(b, ip...)
+	}
+	b = binary.BigEndian.AppendUint16(b, d.Src.Port())
+	b = binary.BigEndian.AppendUint16(b, d.Dst.Port())
+	b = binary.BigEndian.AppendUint16(b, uint16(udpLen))
+	b = append(b, 0, 0) // Checksum
+	return append(b, d.Payload...)
+}
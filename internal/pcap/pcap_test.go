@@ -0,0 +1,170 @@
+package pcap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/netip"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRoundTrip(t *testing.T) {
+	want := []Datagram{
+		{
+			Time:    time.Unix(1700000000, 123456789),
+			Src:     netip.MustParseAddrPort("198.51.100.1:40000"),
+			Dst:     netip.MustParseAddrPort("192.0.2.1:5520"),
+			Payload: []byte("hello"),
+		},
+		{
+			Time:    time.Unix(1700000001, 0),
+			Src:     netip.MustParseAddrPort("[2001:db8::1]:40000"),
+			Dst:     netip.MustParseAddrPort("[2001:db8::2]:5520"),
+			Payload: []byte("world"),
+		},
+	}
+
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, d := range want {
+		if err := w.Write(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range want {
+		got, err := r.Next()
+		if err != nil {
+			t.Fatalf("datagram %d: %v", i, err)
+		}
+		if !got.Time.Equal(w.Time) || got.Src != w.Src || got.Dst != w.Dst || !bytes.Equal(got.Payload, w.Payload) {
+			t.Errorf("datagram %d = %+v, want %+v", i, got, w)
+		}
+	}
+	if _, err := r.Next(); err != io.EOF {
+		t.Errorf("expected io.EOF, got %v", err)
+	}
+}
+
+// record builds a microsecond capture with one record on the given link type.
+func record(linkType uint32, frame []byte) []byte {
+	var b bytes.Buffer
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], 0xa1b2c3d4)
+	binary.LittleEndian.PutUint32(hdr[20:24], linkType)
+	b.Write(hdr)
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec[0:4], 1700000000)
+	binary.LittleEndian.PutUint32(rec[4:8], 500) // 500µs
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(frame)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(len(frame)))
+	b.Write(rec)
+	b.Write(frame)
+	return b.Bytes()
+}
+
+// ipv4UDP returns an IPv4/UDP packet from 10.0.0.1:1000 to 10.0.0.2:2000.
+func ipv4UDP(payload string) []byte {
+	var buf bytes.Buffer
+	w, _ := NewWriter(&buf)
+	w.Write(Datagram{
+		Src:     netip.MustParseAddrPort("10.0.0.1:1000"),
+		Dst:     netip.MustParseAddrPort("10.0.0.2:2000"),
+		Payload: []byte(payload),
+	})
+	return buf.Bytes()[24+16:]
+}
+
+func TestLinkTypes(t *testing.T) {
+	ip := ipv4UDP("x")
+	ether := append(make([]byte, 12), 0x08, 0x00)
+	vlan := append(make([]byte, 12), 0x81, 0x00, 0x00, 0x05, 0x08, 0x00)
+	sll := append(make([]byte, 14), 0x08, 0x00)
+	sll2 := append([]byte{0x08, 0x00}, make([]byte, 18)...)
+
+	tests := []struct {
+		name     string
+		linkType uint32
+		frame    []byte
+	}{
+		{"ethernet", linkEthernet, append(ether, ip...)},
+		{"vlan", linkEthernet, append(vlan, ip...)},
+		{"sll", linkSLL, append(sll, ip...)},
+		{"sll2", linkSLL2, append(sll2, ip...)},
+		{"loopback", linkNull, append([]byte{2, 0, 0, 0}, ip...)},
+		{"raw", linkRaw, ip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := NewReader(bytes.NewReader(record(tt.linkType, tt.frame)))
+			if err != nil {
+				t.Fatal(err)
+			}
+			d, err := r.Next()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(d.Payload) != "x" || d.Dst.Port() != 2000 {
+				t.Errorf("got %+v", d)
+			}
+			if d.Time.Nanosecond() != 500000 {
+				t.Errorf("time = %v, want 500µs past the second", d.Time)
+			}
+		})
+	}
+}
+
+func TestSkipsNonUDP(t *testing.T) {
+	ip := ipv4UDP("x")
+	tcp := append([]byte(nil), ip...)
+	tcp[9] = 6
+	fragment := append([]byte(nil), ip...)
+	fragment[6] = 0x20 // More fragments
+
+	for _, frame := range [][]byte{tcp, fragment, {0x45}} {
+		r, err := NewReader(bytes.NewReader(record(linkRaw, frame)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := r.Next(); err != io.EOF {
+			t.Errorf("expected io.EOF, got %v", err)
+		}
+		if r.Skipped != 1 {
+			t.Errorf("skipped = %d, want 1", r.Skipped)
+		}
+	}
+}
+
+func TestNewReader_Errors(t *testing.T) {
+	pcapng := make([]byte, 24)
+	binary.LittleEndian.PutUint32(pcapng, 0x0a0d0d0a)
+	unknownLink := record(9999, nil)[:24]
+
+	tests := []struct {
+		name string
+		data []byte
+		want string
+	}{
+		{"short", []byte{1, 2, 3}, "reading pcap header"},
+		{"pcapng", pcapng, "pcapng"},
+		{"garbage", bytes.Repeat([]byte{0x42}, 24), "not a pcap file"},
+		{"link type", unknownLink, "unsupported link type"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := NewReader(bytes.NewReader(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("err = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
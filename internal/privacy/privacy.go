@@ -0,0 +1,173 @@
+// Package privacy hides client addresses where the relay shows them: log
+// lines, session traces and events, and the admin API. Addresses are either
+// truncated to a network prefix or replaced by a keyed hash, the same way
+// everywhere, so one client can still be followed across outputs.
+//
+// The mode is swapped atomically by Configure, like the logging sinks, so it
+// survives reloads. Addresses the relay works with (sessions, snapshots,
+// flow and packet exports) are never changed.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync/atomic"
+)
+
+// Modes.
+const (
+	ModeTruncate = "truncate" // Keep the network prefix, zero the host bits
+	ModeHMAC     = "hmac"     // Replace the address with a keyed hash
+)
+
+const (
+	defaultIPv4Prefix = 24
+	defaultIPv6Prefix = 48
+	minHMACKey        = 16
+	hashPrefix        = "anon-"
+	hashBytes         = 8 // Of the HMAC shown, as hex
+)
+
+// Config is the client address anonymization configuration.
+type Config struct {
+	Mode       string `json:"mode"`                  // "truncate" or "hmac"
+	IPv4Prefix int    `json:"ipv4_prefix,omitempty"` // Bits of IPv4 addresses kept by truncate (default: 24)
+	IPv6Prefix int    `json:"ipv6_prefix,omitempty"` // Bits of IPv6 addresses kept by truncate (default: 48)
+	Key        string `json:"key,omitempty"`         // HMAC key, at least 16 bytes (hmac)
+	HidePorts  bool   `json:"hide_ports,omitempty"`  // Show addresses without their port
+}
+
+// anonymizer is the active configuration.
+type anonymizer struct {
+	mode      string
+	v4, v6    int
+	key       []byte
+	hidePorts bool
+}
+
+var current atomic.Pointer[anonymizer]
+
+// Configure replaces the active configuration. A nil cfg shows addresses as
+// they are.
+func Configure(cfg *Config) error {
+	apply, err := Prepare(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+// Prepare validates cfg without changing the active configuration; apply
+// then does what Configure does.
+func Prepare(cfg *Config) (apply func(), err error) {
+	if cfg == nil {
+		return func() { current.Store(nil) }, nil
+	}
+	a := &anonymizer{mode: cfg.Mode, hidePorts: cfg.HidePorts}
+	switch cfg.Mode {
+	case ModeTruncate:
+		a.v4, a.v6 = defaultIPv4Prefix, defaultIPv6Prefix
+		if cfg.IPv4Prefix != 0 {
+			a.v4 = cfg.IPv4Prefix
+		}
+		if cfg.IPv6Prefix != 0 {
+			a.v6 = cfg.IPv6Prefix
+		}
+		if a.v4 < 0 || a.v4 > 32 || a.v6 < 0 || a.v6 > 128 {
+			return nil, errors.New("ipv4_prefix must be between 0 and 32, ipv6_prefix between 0 and 128")
+		}
+	case ModeHMAC:
+		if len(cfg.Key) < minHMACKey {
+			return nil, fmt.Errorf("'key' of at least %d bytes is required for mode hmac", minHMACKey)
+		}
+		a.key = []byte(cfg.Key)
+	default:
+		return nil, fmt.Errorf("unknown mode %q (want %q or %q)", cfg.Mode, ModeTruncate, ModeHMAC)
+	}
+	return func() { current.Store(a) }, nil
+}
+
+// Enabled reports whether addresses are anonymized.
+func Enabled() bool {
+	return current.Load() != nil
+}
+
+// IP returns addr as it may be shown.
+func IP(addr netip.Addr) string {
+	a := current.Load()
+	if a == nil || !addr.IsValid() {
+		return addr.String()
+	}
+	return a.ip(addr.Unmap())
+}
+
+// AddrPort returns addr as it may be shown.
+func AddrPort(addr netip.AddrPort) string {
+	a := current.Load()
+	if a == nil || !addr.IsValid() {
+		return addr.String()
+	}
+	ip := a.ip(addr.Addr().Unmap())
+	if a.hidePorts {
+		return ip
+	}
+	return net.JoinHostPort(ip, strconv.Itoa(int(addr.Port())))
+}
+
+// Addr returns a UDP or TCP address as it may be shown. Other addresses are
+// returned as they are.
+func Addr(addr net.Addr) string {
+	switch addr := addr.(type) {
+	case *net.UDPAddr:
+		if addr == nil || current.Load() == nil {
+			return addr.String()
+		}
+		return AddrPort(addr.AddrPort())
+	case *net.TCPAddr:
+		if addr == nil || current.Load() == nil {
+			return addr.String()
+		}
+		return AddrPort(addr.AddrPort())
+	case nil:
+		return "<nil>"
+	}
+	return addr.String()
+}
+
+// String returns an address in text form, with or without a port, as it may
+// be shown. Text that is not an address is returned as it is.
+func String(addr string) string {
+	if current.Load() == nil {
+		return addr
+	}
+	if ap, err := netip.ParseAddrPort(addr); err == nil {
+		return AddrPort(ap)
+	}
+	if ip, err := netip.ParseAddr(addr); err == nil {
+		return IP(ip)
+	}
+	return addr
+}
+
+func (a *anonymizer) ip(addr netip.Addr) string {
+	addr = addr.WithZone("")
+	if a.mode == ModeHMAC {
+		mac := hmac.New(sha256.New, a.key)
+		b := addr.As16()
+		mac.Write(b[:])
+		return hashPrefix + hex.EncodeToString(mac.Sum(nil)[:hashBytes])
+	}
+	bits := a.v6
+	if addr.Is4() {
+		bits = a.v4
+	}
+	prefix, _ := addr.Prefix(bits)
+	return prefix.Addr().String()
+}
@@ -0,0 +1,91 @@
+package privacy
+
+import (
+	"net"
+	"net/netip"
+	"strings"
+	"testing"
+)
+
+func configure(t *testing.T, cfg *Config) {
+	t.Helper()
+	if err := Configure(cfg); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { Configure(nil) })
+}
+
+func TestTruncate(t *testing.T) {
+	configure(t, &Config{Mode: ModeTruncate})
+	for in, want := range map[string]string{
+		"192.0.2.77:50000":           "192.0.2.0:50000",
+		"[2001:db8:1:2::5]:443":      "[2001:db8:1::]:443",
+		"[::ffff:192.0.2.77]:50000":  "192.0.2.0:50000",
+		"[fe80::1%eth0]:50000":       "[fe80::]:50000",
+		"198.51.100.1":               "198.51.100.0",
+		"not an address":             "not an address",
+		"[2001:db8:ffff:ffff::1]:1":  "[2001:db8:ffff::]:1",
+		"[2001:db8:0:ffff::1]:65535": "[2001:db8::]:65535",
+	} {
+		if got := String(in); got != want {
+			t.Errorf("String(%q) = %q, want %q", in, got, want)
+		}
+	}
+
+	configure(t, &Config{Mode: ModeTruncate, IPv4Prefix: 16, IPv6Prefix: 32, HidePorts: true})
+	if got := Addr(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 77), Port: 50000}); got != "192.0.0.0" {
+		t.Errorf("Addr = %q", got)
+	}
+	if got := IP(netip.MustParseAddr("2001:db8:1::1")); got != "2001:db8::" {
+		t.Errorf("IP = %q", got)
+	}
+}
+
+func TestHMAC(t *testing.T) {
+	key := strings.Repeat("k", 32)
+	configure(t, &Config{Mode: ModeHMAC, Key: key})
+	a := Addr(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000})
+	if !strings.HasPrefix(a, hashPrefix) || !strings.HasSuffix(a, ":50000") || strings.Contains(a, "192.0.2.1") {
+		t.Fatalf("Addr = %q", a)
+	}
+	// The same client gives the same hash in every form, other clients and
+	// keys do not
+	if b := AddrPort(netip.MustParseAddrPort("[::ffff:192.0.2.1]:50000")); b != a {
+		t.Errorf("mapped address: %q, want %q", b, a)
+	}
+	if ip := IP(netip.MustParseAddr("192.0.2.1")); ip+":50000" != a {
+		t.Errorf("IP = %q", ip)
+	}
+	if b := String("192.0.2.2:50000"); b == a {
+		t.Error("two clients share a hash")
+	}
+	configure(t, &Config{Mode: ModeHMAC, Key: strings.Repeat("x", 32)})
+	if b := String("192.0.2.1:50000"); b == a {
+		t.Error("hash does not depend on the key")
+	}
+}
+
+func TestConfigure(t *testing.T) {
+	t.Cleanup(func() { Configure(nil) })
+	for _, cfg := range []Config{
+		{},
+		{Mode: "mask"},
+		{Mode: ModeHMAC, Key: "short"},
+		{Mode: ModeTruncate, IPv4Prefix: 33},
+		{Mode: ModeTruncate, IPv6Prefix: -1},
+	} {
+		if err := Configure(&cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	if Enabled() {
+		t.Error("enabled by a rejected config")
+	}
+	if got := Addr(&net.TCPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 80}); got != "192.0.2.1:80" {
+		t.Errorf("disabled: Addr = %q", got)
+	}
+	var nilAddr *net.UDPAddr
+	if got := Addr(nilAddr); got != "<nil>" {
+		t.Errorf("Addr(nil) = %q", got)
+	}
+}
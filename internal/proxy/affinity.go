@@ -0,0 +1,258 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// CPUAffinityConfig pins packet processing threads to CPUs (Linux only).
+// Each list is a cpuset string such as "0-3,8".
+type CPUAffinityConfig struct {
+	Listeners string `json:"listeners,omitempty"` // CPUs for listener read loops, one CPU per listener in turn
+	Workers   string `json:"workers,omitempty"`   // CPUs for packet workers, one CPU per worker in turn
+}
+
+// cpuPlacement holds the CPUs packet processing is pinned to, and the
+// packet counts per listener for the distribution report.
+type cpuPlacement struct {
+	listeners []int // Empty = not pinned
+	workers   []int
+
+	listenerPackets []atomic.Uint64 // By listener index: primary first, then extra listeners
+}
+
+// CPULoad is one row of the per-core packet distribution: a pinned CPU, or
+// an unpinned listener or worker.
+type CPULoad struct {
+	CPU       int      `json:"cpu"`            // -1 when not pinned
+	Node      int      `json:"node"`           // NUMA node, -1 when unknown
+	Roles     []string `json:"roles"`          // e.g. "listener 0.0.0.0:5520", "worker 3"
+	Read      uint64   `json:"read"`           // Packets read by its listeners
+	Processed uint64   `json:"processed"`      // Packets handled by its workers
+	Share     float64  `json:"share"`          // Percent of all processed packets
+	Hint      string   `json:"hint,omitempty"` // Imbalance or cross-node hand-off
+}
+
+// parseCPUList parses a cpuset string: comma separated CPUs and ranges.
+func parseCPUList(s string) ([]int, error) {
+	var cpus []int
+	for part := range strings.SplitSeq(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		from, to, isRange := strings.Cut(part, "-")
+		lo, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil || lo < 0 {
+			return nil, fmt.Errorf("invalid CPU %q", part)
+		}
+		hi := lo
+		if isRange {
+			if hi, err = strconv.Atoi(strings.TrimSpace(to)); err != nil || hi < lo {
+				return nil, fmt.Errorf("invalid CPU range %q", part)
+			}
+		}
+		for cpu := lo; cpu <= hi; cpu++ {
+			if !slices.Contains(cpus, cpu) {
+				cpus = append(cpus, cpu)
+			}
+		}
+	}
+	if len(cpus) == 0 {
+		return nil, errors.New("empty CPU list")
+	}
+	return cpus, nil
+}
+
+// SetCPUAffinity pins listener read loops and packet workers to CPUs.
+// Must be called before Run. A nil cfg leaves scheduling to the Go runtime.
+// With workers pinned, the pool has two workers per listed CPU.
+func (p *Proxy) SetCPUAffinity(cfg *CPUAffinityConfig) error {
+	if cfg == nil || (cfg.Listeners == "" && cfg.Workers == "") {
+		p.placement.listeners, p.placement.workers = nil, nil
+		return nil
+	}
+	if !pinSupported {
+		return errors.New("CPU pinning is only supported on Linux")
+	}
+	var pl cpuPlacement
+	for _, set := range []struct {
+		name string
+		list string
+		dst  *[]int
+	}{{"listeners", cfg.Listeners, &pl.listeners}, {"workers", cfg.Workers, &pl.workers}} {
+		if set.list == "" {
+			continue
+		}
+		cpus, err := parseCPUList(set.list)
+		if err != nil {
+			return fmt.Errorf("%s: %w", set.name, err)
+		}
+		for _, cpu := range cpus {
+			if !cpuAvailable(cpu) {
+				return fmt.Errorf("%s: CPU %d is not available to the process", set.name, cpu)
+			}
+		}
+		*set.dst = cpus
+	}
+	p.placement.listeners, p.placement.workers = pl.listeners, pl.workers
+	return nil
+}
+
+// workerCount returns the size of the worker pool, 0 for the default.
+func (p *Proxy) workerCount() int {
+	return 2 * len(p.placement.workers)
+}
+
+// listenerIndex returns the index of conn: 0 for the primary listener,
+// then the extra listeners in order. -1 when unknown.
+func (p *Proxy) listenerIndex(conn *net.UDPConn) int {
+	if conn == p.conn {
+		return 0
+	}
+	if i := slices.Index(p.extraConns, conn); i >= 0 {
+		return i + 1
+	}
+	return -1
+}
+
+// listenerCPU returns the CPU of listener i, -1 when not pinned.
+func (p *Proxy) listenerCPU(i int) int {
+	if len(p.placement.listeners) == 0 || i < 0 {
+		return -1
+	}
+	return p.placement.listeners[i%len(p.placement.listeners)]
+}
+
+// workerCPU returns the CPU of worker i, -1 when not pinned.
+func (p *Proxy) workerCPU(i int) int {
+	if len(p.placement.workers) == 0 {
+		return -1
+	}
+	return p.placement.workers[i%len(p.placement.workers)]
+}
+
+// pinListener locks the calling read loop to its listener's CPU.
+func (p *Proxy) pinListener(i int) {
+	if cpu := p.listenerCPU(i); cpu >= 0 {
+		if err := pinThread(cpu); err != nil {
+			logger.Warnf("cannot pin listener %d to CPU %d: %v", i, cpu, err)
+		}
+	}
+}
+
+// pinWorker locks the calling worker to its CPU.
+func (p *Proxy) pinWorker(i int) {
+	if cpu := p.workerCPU(i); cpu >= 0 {
+		if err := pinThread(cpu); err != nil {
+			logger.Warnf("cannot pin worker %d to CPU %d: %v", i, cpu, err)
+		}
+	}
+}
+
+// countRead adds n packets read by listener i.
+func (p *Proxy) countRead(i, n int) {
+	if i >= 0 && i < len(p.placement.listenerPackets) {
+		p.placement.listenerPackets[i].Add(uint64(n))
+	}
+}
+
+// CPUDistribution reports how packets spread over CPUs: one row per pinned
+// CPU, and one per unpinned listener or worker. Rows with a worker share
+// above twice the average, and listeners handing packets to workers on
+// another NUMA node, carry a hint.
+func (p *Proxy) CPUDistribution() []CPULoad {
+	if p.workerPool == nil {
+		return nil
+	}
+	byCPU := make(map[int]*CPULoad)
+	var rows []*CPULoad
+	row := func(cpu int) *CPULoad {
+		if cpu >= 0 {
+			if r, ok := byCPU[cpu]; ok {
+				return r
+			}
+		}
+		r := &CPULoad{CPU: cpu, Node: -1}
+		if cpu >= 0 {
+			r.Node = cpuNode(cpu)
+			byCPU[cpu] = r
+		}
+		rows = append(rows, r)
+		return r
+	}
+
+	conns := append([]*net.UDPConn{p.conn}, p.extraConns...)
+	listenerNodes := make(map[int]bool)
+	for i, conn := range conns {
+		if conn == nil {
+			continue
+		}
+		r := row(p.listenerCPU(i))
+		r.Roles = append(r.Roles, "listener "+conn.LocalAddr().String())
+		if i < len(p.placement.listenerPackets) {
+			r.Read += p.placement.listenerPackets[i].Load()
+		}
+		if r.Node >= 0 {
+			listenerNodes[r.Node] = true
+		}
+	}
+
+	var total uint64
+	var workerRows []*CPULoad
+	for i := range p.workerPool.workers {
+		n := p.workerPool.Processed(i)
+		r := row(p.workerCPU(i))
+		r.Roles = append(r.Roles, "worker "+strconv.Itoa(i))
+		if !slices.Contains(workerRows, r) {
+			workerRows = append(workerRows, r)
+		}
+		r.Processed += n
+		total += n
+	}
+
+	for _, r := range workerRows {
+		n := r.Processed
+		if total > 0 {
+			r.Share = float64(n*1000/total) / 10
+		}
+		switch {
+		case len(workerRows) > 1 && total > 0 && n*uint64(len(workerRows)) > 2*total:
+			r.Hint = "over twice the average load: workers are chosen by client address, so a few busy clients land on one"
+		case r.Node >= 0 && len(listenerNodes) > 0 && !listenerNodes[r.Node]:
+			r.Hint = fmt.Sprintf("workers on node %d get packets from listeners on another node", r.Node)
+		}
+	}
+
+	loads := make([]CPULoad, len(rows))
+	for i, r := range rows {
+		loads[i] = *r
+	}
+	return loads
+}
+
+// FormatCPUDistribution renders the distribution as the text report logged
+// with -cpu-profile-hint.
+func FormatCPUDistribution(loads []CPULoad) string {
+	var b strings.Builder
+	b.WriteString("per-core packet distribution:")
+	for _, l := range loads {
+		cpu, node := "any", ""
+		if l.CPU >= 0 {
+			cpu = strconv.Itoa(l.CPU)
+		}
+		if l.Node >= 0 {
+			node = fmt.Sprintf(" node=%d", l.Node)
+		}
+		fmt.Fprintf(&b, "\n  cpu=%s%s read=%d processed=%d share=%.1f%% [%s]", cpu, node, l.Read, l.Processed, l.Share, strings.Join(l.Roles, ", "))
+		if l.Hint != "" {
+			b.WriteString(" hint: " + l.Hint)
+		}
+	}
+	return b.String()
+}
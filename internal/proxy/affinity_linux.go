@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+const pinSupported = true
+
+// pinThread locks the calling goroutine to its OS thread and the thread to
+// cpu. Memory the thread touches first is then allocated on the CPU's NUMA
+// node by the kernel's default policy.
+func pinThread(cpu int) error {
+	runtime.LockOSThread()
+	var set unix.CPUSet
+	set.Set(cpu)
+	return unix.SchedSetaffinity(0, &set)
+}
+
+// cpuAvailable reports whether the process may run on cpu.
+func cpuAvailable(cpu int) bool {
+	var set unix.CPUSet
+	if err := unix.SchedGetaffinity(0, &set); err != nil {
+		return cpu < runtime.NumCPU()
+	}
+	return set.IsSet(cpu)
+}
+
+// cpuNode returns the NUMA node of cpu, -1 when unknown.
+func cpuNode(cpu int) int {
+	nodes, _ := filepath.Glob("/sys/devices/system/cpu/cpu" + strconv.Itoa(cpu) + "/node*")
+	for _, n := range nodes {
+		if node, err := strconv.Atoi(strings.TrimPrefix(filepath.Base(n), "node")); err == nil {
+			return node
+		}
+	}
+	return -1
+}
@@ -0,0 +1,13 @@
+//go:build !linux
+
+package proxy
+
+import "errors"
+
+const pinSupported = false
+
+func pinThread(cpu int) error { return errors.ErrUnsupported }
+
+func cpuAvailable(cpu int) bool { return false }
+
+func cpuNode(cpu int) int { return -1 }
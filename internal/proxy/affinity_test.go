@@ -0,0 +1,115 @@
+package proxy
+
+import (
+	"net"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+func TestParseCPUList(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    []int
+		wantErr string
+	}{
+		{"0", []int{0}, ""},
+		{"0-3,8", []int{0, 1, 2, 3, 8}, ""},
+		{" 2 , 1-2 ", []int{2, 1}, ""},
+		{"", nil, "empty CPU list"},
+		{"3-1", nil, "invalid CPU range"},
+		{"a", nil, "invalid CPU"},
+		{"-1", nil, "invalid CPU"},
+	}
+	for _, tt := range tests {
+		got, err := parseCPUList(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("parseCPUList(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !slices.Equal(got, tt.want) {
+			t.Errorf("parseCPUList(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestSetCPUAffinity(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	if err := p.SetCPUAffinity(nil); err != nil || p.workerCount() != 0 {
+		t.Fatalf("nil config: %v, workers %d", err, p.workerCount())
+	}
+	if !pinSupported {
+		if err := p.SetCPUAffinity(&CPUAffinityConfig{Workers: "0"}); err == nil {
+			t.Error("pinning accepted on an unsupported platform")
+		}
+		return
+	}
+	if err := p.SetCPUAffinity(&CPUAffinityConfig{Workers: "100000"}); err == nil || !strings.Contains(err.Error(), "not available") {
+		t.Errorf("unavailable CPU: %v", err)
+	}
+	if err := p.SetCPUAffinity(&CPUAffinityConfig{Listeners: "x"}); err == nil || !strings.Contains(err.Error(), "listeners") {
+		t.Errorf("invalid listeners: %v", err)
+	}
+	if err := p.SetCPUAffinity(&CPUAffinityConfig{Listeners: "0", Workers: "0"}); err != nil {
+		t.Fatal(err)
+	}
+	if p.workerCount() != 2 || p.listenerCPU(3) != 0 || p.workerCPU(1) != 0 {
+		t.Errorf("workers=%d listener cpu=%d worker cpu=%d", p.workerCount(), p.listenerCPU(3), p.workerCPU(1))
+	}
+}
+
+func TestCPUDistribution(t *testing.T) {
+	p := New("127.0.0.1:0", handler.NewChain())
+	pinned := pinSupported && cpuAvailable(0)
+	if pinned {
+		if err := p.SetCPUAffinity(&CPUAffinityConfig{Listeners: "0", Workers: "0"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	go p.Run()
+	t.Cleanup(p.Stop)
+	<-p.Ready()
+
+	client, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	for range 5 {
+		client.Write([]byte{0x40, 1, 2, 3})
+	}
+
+	var loads []CPULoad
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		loads = p.CPUDistribution()
+		var read, processed uint64
+		for _, l := range loads {
+			read += l.Read
+			processed += l.Processed
+		}
+		if read == 5 && processed == 5 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("read=%d processed=%d, want 5: %+v", read, processed, loads)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	if pinned {
+		if len(loads) != 1 || loads[0].CPU != 0 || len(loads[0].Roles) != 3 || loads[0].Share != 100 {
+			t.Errorf("pinned distribution = %+v", loads)
+		}
+	} else if len(loads) != 1+p.workerPool.workers {
+		t.Errorf("unpinned distribution has %d rows, want one per listener and worker", len(loads))
+	}
+	if report := FormatCPUDistribution(loads); !strings.Contains(report, "listener 127.0.0.1:") {
+		t.Errorf("report = %q", report)
+	}
+}
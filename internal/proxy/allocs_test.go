@@ -0,0 +1,136 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+// The packets of established sessions pass the proxy, the handler chain and
+// the forwarder without allocating. These tests hold the path to that budget;
+// run the benchmarks with -memprofile to find what broke it.
+
+// packetPath is a proxy relaying to a local backend through simple-router and
+// forwarder, with the packets a client sends it.
+type packetPath struct {
+	p      *Proxy
+	conn   *net.UDPConn
+	client *net.UDPAddr
+}
+
+func newPacketPath(tb testing.TB) *packetPath {
+	tb.Helper()
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	backend, conn := listen(), listen()
+	router, err := handler.NewStaticHandler(json.RawMessage(`{"backend":"` + backend.LocalAddr().String() + `"}`))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	fwd, err := handler.NewForwarderHandler(json.RawMessage(`{}`))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	p := New("127.0.0.1:0", handler.NewChain(router, fwd))
+	tb.Cleanup(p.Stop)
+	return &packetPath{p: p, conn: conn, client: &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}}
+}
+
+// connect sets up a QUIC session with DCID dcid the way handlePacket does
+// for a new connection.
+func (pp *packetPath) connect(tb testing.TB, dcid []byte, hello *handler.ClientHello) {
+	tb.Helper()
+	p := pp.p
+	key := string(dcid)
+	ctx := &handler.Context{ClientAddr: pp.client, ProxyConn: pp.conn, Hello: hello}
+	ctx.DropSession = func() {
+		p.chain.Load().OnDisconnect(ctx)
+		p.deleteSession(key, ctx)
+	}
+	if result := p.chain.Load().OnConnect(ctx); result.Action != handler.Handled {
+		tb.Fatalf("OnConnect = %v: %v", result.Action, result.Error)
+	}
+	ctx.Session.DCID = dcid
+	p.store.AddCID(key, dcid)
+	p.storeSession(key, ctx)
+	p.store.SetClientSession("", addrKey(pp.client), key)
+}
+
+// allocs returns the allocations per packet of the session packet was sent on.
+func (pp *packetPath) allocs(packet []byte) float64 {
+	return testing.AllocsPerRun(1000, func() {
+		pp.p.handlePacket(pp.conn, pp.client, packet)
+	})
+}
+
+// packetPathCases are sessions of the kinds the relay forwards, each with a
+// client packet of full size.
+var packetPathCases = []struct {
+	name  string
+	setup func(tb testing.TB, pp *packetPath) []byte
+}{
+	{"short header", func(tb testing.TB, pp *packetPath) []byte {
+		dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		pp.connect(tb, dcid, nil)
+		return append(shortHeader(dcid), make([]byte, 1200)...)
+	}},
+	{"long header", func(tb testing.TB, pp *packetPath) []byte {
+		dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		pp.connect(tb, dcid, nil)
+		return testLongHeader(0xE0, dcid, nil, 1200)
+	}},
+	{"route log override", func(tb testing.TB, pp *packetPath) []byte {
+		// Overrides are looked up for every packet; this one matches no route
+		if _, err := handler.SetRouteLogging("*.example.net", handler.RouteLogConfig{Level: "debug"}); err != nil {
+			tb.Fatal(err)
+		}
+		tb.Cleanup(func() { handler.ClearRouteLogging("*.example.net") })
+		dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+		pp.connect(tb, dcid, &handler.ClientHello{SNI: "Lobby.EU-West.Play.Example.com"})
+		return append(shortHeader(dcid), make([]byte, 1200)...)
+	}},
+	{"raw flow", func(tb testing.TB, pp *packetPath) []byte {
+		if err := pp.p.SetProtocols([]ProtocolRule{{Name: "rtp", Prefix: "80"}}); err != nil {
+			tb.Fatal(err)
+		}
+		packet := append([]byte{0x80}, make([]byte, 1200)...)
+		pp.p.handlePacket(pp.conn, pp.client, packet) // Opens the flow
+		if pp.p.sessionCount.Load() != 1 {
+			tb.Fatal("flow not opened")
+		}
+		return packet
+	}},
+}
+
+func TestPacketPath_Allocs(t *testing.T) {
+	for _, tt := range packetPathCases {
+		t.Run(tt.name, func(t *testing.T) {
+			pp := newPacketPath(t)
+			packet := tt.setup(t, pp)
+			if allocs := pp.allocs(packet); allocs != 0 {
+				t.Errorf("%v allocations per packet, want 0", allocs)
+			}
+		})
+	}
+}
+
+func BenchmarkPacketPath(b *testing.B) {
+	for _, tt := range packetPathCases {
+		b.Run(tt.name, func(b *testing.B) {
+			pp := newPacketPath(b)
+			packet := tt.setup(b, pp)
+			b.ReportAllocs()
+			for range b.N {
+				pp.p.handlePacket(pp.conn, pp.client, packet)
+			}
+		})
+	}
+}
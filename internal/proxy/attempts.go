@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// initialDedupWindow is how long after a connection attempt ended without a
+// session retransmits of its Initial are still ignored. Clients retransmit
+// Initials on a timer while the handshake is slow, so each would otherwise run
+// the handler chain again.
+const initialDedupWindow = time.Second
+
+// connectAttempt is a connection attempt by one client for one DCID, from the
+// parsed ClientHello until the handler chain decided.
+type connectAttempt struct {
+	ended atomic.Int64 // Unix nanos when it ended without a session, 0 while in flight
+}
+
+// attemptKey identifies the attempt of clientKey for dcidKey.
+func attemptKey(clientKey, dcidKey string) string {
+	return clientKey + "|" + dcidKey
+}
+
+// beginAttempt records that the handler chain is deciding on a connection.
+func (p *Proxy) beginAttempt(key string) *connectAttempt {
+	a := &connectAttempt{}
+	p.attempts.Store(key, a)
+	return a
+}
+
+// endAttempt records the outcome of a. Once a session exists its packets are
+// found by DCID, so only attempts without one are kept for the dedup window.
+func (p *Proxy) endAttempt(key string, a *connectAttempt, session bool) {
+	if session {
+		p.attempts.CompareAndDelete(key, a)
+		return
+	}
+	a.ended.Store(time.Now().UnixNano())
+}
+
+// joinAttempt handles a retransmitted Initial of an attempt in flight or just
+// ended, and reports whether it did. While in flight the packet is buffered
+// and reaches the session once created, like early Handshake packets.
+func (p *Proxy) joinAttempt(key, dcidKey string, packet []byte) bool {
+	val, ok := p.attempts.Load(key)
+	if !ok {
+		return false
+	}
+	a := val.(*connectAttempt)
+	if ended := a.ended.Load(); ended == 0 {
+		p.bufferPendingPacket(dcidKey, packet)
+	} else if time.Since(time.Unix(0, ended)) > initialDedupWindow {
+		p.attempts.CompareAndDelete(key, a)
+		return false
+	}
+	p.retransmittedInitials.Add(1)
+	return true
+}
+
+// joinHeldFlow buffers a packet of a non-QUIC flow the handler chain holds,
+// and reports whether there is one. The packets reach the flow once created.
+func (p *Proxy) joinHeldFlow(key string, packet []byte) bool {
+	val, ok := p.attempts.Load(key)
+	if !ok || val.(*connectAttempt).ended.Load() != 0 {
+		return false
+	}
+	p.bufferPendingPacket(key, packet)
+	return true
+}
+
+// cleanupAttempts removes attempts whose dedup window has passed.
+func (p *Proxy) cleanupAttempts() {
+	p.attempts.Range(func(key, value any) bool {
+		a := value.(*connectAttempt)
+		if ended := a.ended.Load(); ended != 0 && time.Since(time.Unix(0, ended)) > initialDedupWindow {
+			p.attempts.CompareAndDelete(key, a)
+		}
+		return true
+	})
+}
@@ -0,0 +1,206 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-relay/internal/handler"
+)
+
+// countingHandler counts connection attempts and drops them.
+type countingHandler struct {
+	connects atomic.Int32
+}
+
+func (h *countingHandler) Name() string                      { return "counting" }
+func (h *countingHandler) OnDisconnect(ctx *handler.Context) {}
+func (h *countingHandler) OnConnect(ctx *handler.Context) handler.Result {
+	h.connects.Add(1)
+	return handler.Result{Action: handler.Drop}
+}
+func (h *countingHandler) OnPacket(ctx *handler.Context, packet []byte, dir handler.Direction) handler.Result {
+	return handler.Result{Action: handler.Drop}
+}
+
+// clientInitials captures the Initial datagrams a QUIC client sends until it
+// waits for a response. They carry its ClientHello.
+func clientInitials(t testing.TB) [][]byte {
+	t.Helper()
+	server, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	go quic.DialAddr(ctx, server.LocalAddr().String(), &tls.Config{ServerName: "play.example.com", NextProtos: []string{"test"}}, nil)
+
+	var datagrams [][]byte
+	buf := make([]byte, 1500)
+	for {
+		server.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		n, _, err := server.ReadFromUDP(buf)
+		if err != nil {
+			break
+		}
+		if ClassifyPacket(buf[:n]) != PacketInitial {
+			continue
+		}
+		datagrams = append(datagrams, append([]byte(nil), buf[:n]...))
+	}
+	if len(datagrams) == 0 {
+		t.Fatal("client sent no Initial")
+	}
+	return datagrams
+}
+
+func TestRetransmittedInitial_DroppedAttempt(t *testing.T) {
+	initials := clientInitials(t)
+	h := &countingHandler{}
+	p := New("127.0.0.1:0", handler.NewChain(h))
+	conn := &recordingConn{}
+	client := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 40000}
+
+	for range 3 {
+		for _, d := range initials {
+			p.handlePacket(conn, client, append([]byte(nil), d...))
+		}
+	}
+	if got := h.connects.Load(); got != 1 {
+		t.Errorf("OnConnect called %d times, want 1", got)
+	}
+	if p.Stats().RetransmittedInitials == 0 {
+		t.Error("retransmits not counted")
+	}
+
+	// After the window the client may try again
+	p.attempts.Range(func(key, value any) bool {
+		value.(*connectAttempt).ended.Store(time.Now().Add(-2 * initialDedupWindow).UnixNano())
+		return true
+	})
+	for _, d := range initials {
+		p.handlePacket(conn, client, append([]byte(nil), d...))
+	}
+	if got := h.connects.Load(); got != 2 {
+		t.Errorf("OnConnect called %d times after the window, want 2", got)
+	}
+
+	// Another client address is another attempt
+	other := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 40000}
+	for _, d := range initials {
+		p.handlePacket(conn, other, append([]byte(nil), d...))
+	}
+	if got := h.connects.Load(); got != 3 {
+		t.Errorf("OnConnect called %d times for a second client, want 3", got)
+	}
+}
+
+func TestJoinAttempt(t *testing.T) {
+	p := New("127.0.0.1:0", handler.NewChain())
+	key := attemptKey("198.51.100.1:40000", "dcid")
+
+	if p.joinAttempt(key, "dcid", []byte{0xc0}) {
+		t.Fatal("joined an attempt that does not exist")
+	}
+
+	// In flight: the retransmit is held for the session
+	a := p.beginAttempt(key)
+	if !p.joinAttempt(key, "dcid", []byte{0xc0}) {
+		t.Fatal("retransmit did not join the attempt in flight")
+	}
+	if _, ok := p.pendingPackets.Load("dcid"); !ok {
+		t.Error("retransmit not buffered for the session")
+	}
+
+	// Ended with a session: packets are found by DCID from now on
+	p.endAttempt(key, a, true)
+	if _, ok := p.attempts.Load(key); ok {
+		t.Error("attempt kept after the session was created")
+	}
+
+	// Ended without a session: ignored until the window passes
+	a = p.beginAttempt(key)
+	p.endAttempt(key, a, false)
+	if !p.joinAttempt(key, "dcid", []byte{0xc0}) {
+		t.Error("retransmit of a dropped attempt started a new one")
+	}
+	a.ended.Store(time.Now().Add(-2 * initialDedupWindow).UnixNano())
+	p.cleanupAttempts()
+	if _, ok := p.attempts.Load(key); ok {
+		t.Error("expired attempt not cleaned up")
+	}
+}
+
+// holdingHandler holds every new connection until decide is called.
+type holdingHandler struct {
+	holds  atomic.Int32
+	decide chan func(handler.Result)
+}
+
+func (h *holdingHandler) Name() string                      { return "holding" }
+func (h *holdingHandler) OnDisconnect(ctx *handler.Context) {}
+func (h *holdingHandler) OnConnect(ctx *handler.Context) handler.Result {
+	h.holds.Add(1)
+	return handler.Result{Action: handler.Hold, Hold: func(decide func(handler.Result)) { h.decide <- decide }}
+}
+func (h *holdingHandler) OnPacket(ctx *handler.Context, packet []byte, dir handler.Direction) handler.Result {
+	return handler.Result{Action: handler.Continue}
+}
+
+func TestHeldConnection(t *testing.T) {
+	initials := clientInitials(t)
+	hold := &holdingHandler{decide: make(chan func(handler.Result), 2)}
+	next := &countingHandler{}
+	p := New("127.0.0.1:0", handler.NewChain(hold, next))
+	conn := &recordingConn{}
+	client := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 40000}
+
+	// handlePacket returns while the connection is held; retransmits join it
+	for range 3 {
+		for _, d := range initials {
+			p.handlePacket(conn, client, append([]byte(nil), d...))
+		}
+	}
+	if got := hold.holds.Load(); got != 1 {
+		t.Fatalf("OnConnect called %d times, want 1", got)
+	}
+	if p.Stats().RetransmittedInitials == 0 {
+		t.Error("retransmits of the held connection not absorbed")
+	}
+
+	// The decision runs the rest of the chain
+	(<-hold.decide)(handler.Result{Action: handler.Continue})
+	if got := next.connects.Load(); got != 1 {
+		t.Errorf("next handler saw %d connections, want 1", got)
+	}
+	var ended bool
+	p.attempts.Range(func(key, value any) bool {
+		ended = value.(*connectAttempt).ended.Load() != 0
+		return true
+	})
+	if !ended {
+		t.Error("attempt still in flight after the decision")
+	}
+
+	// A held non-QUIC flow buffers the packets the client sends meanwhile
+	if err := p.SetProtocols([]ProtocolRule{{Name: "rtp", Prefix: "80"}}); err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		p.handlePacket(conn, client, []byte{0x80, 1, 2, 3})
+	}
+	if got := hold.holds.Load(); got != 2 {
+		t.Fatalf("OnConnect called %d times for the flow, want 1", got-1)
+	}
+	(<-hold.decide)(handler.Result{Action: handler.Drop})
+	if _, ok := p.attempts.Load(rawSessionKey("rtp", client)); ok {
+		t.Error("held flow still in flight after the decision")
+	}
+}
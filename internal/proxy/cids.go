@@ -0,0 +1,74 @@
+package proxy
+
+import (
+	"maps"
+	"slices"
+	"sync"
+	"sync/atomic"
+)
+
+// sessionCIDs are the connection IDs learned for one QUIC session: the
+// client's original DCID and the SCIDs its backend chose. Backends and clients
+// may use any length from 0 to 20 bytes, and one session may use several.
+type sessionCIDs struct {
+	mu         sync.RWMutex
+	cids       map[string]struct{}
+	lengths    map[int]struct{}
+	zeroLength bool // The backend uses zero-length CIDs: route by client address
+}
+
+// match returns the CID of the session a Short Header packet is addressed to.
+// ok is also true for sessions with zero-length CIDs, which carry none.
+func (s *sessionCIDs) match(packet []byte) (cid []byte, ok bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	for c := range s.cids {
+		if len(packet) > len(c) && string(packet[1:1+len(c)]) == c {
+			return packet[1 : 1+len(c)], true
+		}
+	}
+	return nil, s.zeroLength
+}
+
+// cidLengthSet counts the sessions using each CID length, so Short Header
+// packets are only parsed at lengths in use.
+type cidLengthSet struct {
+	mu     sync.Mutex
+	counts map[int]int
+	list   atomic.Pointer[[]int] // Lengths in use, longest first
+}
+
+// lengths returns the CID lengths in use, longest first to avoid prefix collisions.
+func (s *cidLengthSet) lengths() []int {
+	if l := s.list.Load(); l != nil {
+		return *l
+	}
+	return nil
+}
+
+func (s *cidLengthSet) add(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts == nil {
+		s.counts = make(map[int]int)
+	}
+	s.counts[n]++
+	if s.counts[n] == 1 {
+		s.publish()
+	}
+}
+
+func (s *cidLengthSet) remove(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.counts[n]--; s.counts[n] <= 0 {
+		delete(s.counts, n)
+		s.publish()
+	}
+}
+
+func (s *cidLengthSet) publish() {
+	l := slices.Sorted(maps.Keys(s.counts))
+	slices.Reverse(l)
+	s.list.Store(&l)
+}
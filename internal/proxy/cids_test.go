@@ -0,0 +1,98 @@
+package proxy
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+func TestCIDLengthSet(t *testing.T) {
+	var s cidLengthSet
+	s.add(8)
+	s.add(20)
+	s.add(8)
+	s.add(4)
+	if got := s.lengths(); !reflect.DeepEqual(got, []int{20, 8, 4}) {
+		t.Errorf("lengths = %v, want [20 8 4]", got)
+	}
+	s.remove(8)
+	s.remove(20)
+	if got := s.lengths(); !reflect.DeepEqual(got, []int{8, 4}) {
+		t.Errorf("lengths = %v, want [8 4] (8 is still used once)", got)
+	}
+}
+
+// addTestSession stores a QUIC session for client with the original DCID dcid.
+func addTestSession(p *Proxy, client *net.UDPAddr, dcid []byte) *handler.Context {
+	ctx := &handler.Context{ClientAddr: client, Session: &handler.Session{DCID: dcid}}
+	ctx.Session.SetClientAddr(client)
+	ctx.OnServerPacket = func(packet []byte) { p.learnServerSCID(string(dcid), ctx, packet) }
+	p.storeSession(string(dcid), ctx)
+	p.store.SetClientSession("", addrKey(client), string(dcid))
+	p.store.AddCID(string(dcid), dcid)
+	return ctx
+}
+
+// shortHeader returns a 1-RTT packet addressed to cid.
+func shortHeader(cid []byte) []byte {
+	return append(append([]byte{0x40}, cid...), make([]byte, 24)...)
+}
+
+func TestFindSession_CIDLengths(t *testing.T) {
+	p := New("127.0.0.1:0", handler.NewChain())
+	clientA := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 40000}
+	clientB := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 40000}
+	clientC := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 3), Port: 40000}
+	elsewhere := &net.UDPAddr{IP: net.IPv4(203, 0, 113, 9), Port: 50000}
+
+	// Backends choosing 20-byte, 4-byte and zero-length CIDs
+	a := addTestSession(p, clientA, []byte("dcid-aaa"))
+	scidA := []byte("0123456789abcdefghij")
+	a.OnServerPacket(testLongHeader(0xC0, nil, scidA, 20))
+	b := addTestSession(p, clientB, []byte("dcid-bbb"))
+	scidB := []byte{1, 2, 3, 4}
+	b.OnServerPacket(testLongHeader(0xC0, nil, scidB, 20))
+	c := addTestSession(p, clientC, []byte("dcid-ccc"))
+	c.OnServerPacket(testLongHeader(0xC0, nil, nil, 20))
+
+	if got := p.store.CIDLengths(); !reflect.DeepEqual(got, []int{20, 8, 4}) {
+		t.Errorf("lengths = %v, want [20 8 4]", got)
+	}
+
+	tests := []struct {
+		name   string
+		packet []byte
+		from   *net.UDPAddr
+		want   *handler.Context
+	}{
+		{"20-byte CID", shortHeader(scidA), clientA, a},
+		{"4-byte CID", shortHeader(scidB), clientB, b},
+		{"migrated 4-byte CID", shortHeader(scidB), elsewhere, b},
+		{"migrated 20-byte CID", shortHeader(scidA), elsewhere, a},
+		{"zero-length CID", shortHeader(nil), clientC, c},
+		{"zero-length CID from elsewhere", shortHeader(nil), elsewhere, nil},
+		{"unknown CID from known address", shortHeader([]byte("unknown-cid")), clientA, a},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := p.findSession(tt.packet, PacketShortHeader, tt.from)
+			if got != tt.want {
+				t.Errorf("findSession = %p, want %p", got, tt.want)
+			}
+		})
+	}
+
+	// Closing a session releases its aliases and unused lengths
+	p.deleteSession("dcid-aaa", a)
+	if _, ok := p.store.(*MemoryStore).aliases.Load(string(scidA)); ok {
+		t.Error("alias of the closed session kept")
+	}
+	if got := p.store.CIDLengths(); !reflect.DeepEqual(got, []int{8, 4}) {
+		t.Errorf("lengths after close = %v, want [8 4]", got)
+	}
+	if got, _ := p.findSession(shortHeader(scidA), PacketShortHeader, elsewhere); got != nil {
+		t.Error("closed session still found by CID")
+	}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/privacy"
+	"quic-relay/internal/timerwheel"
+)
+
+// ClientMigrationConfig controls how sessions follow clients to new addresses.
+// Without it, a session switches to any address its packets arrive from.
+type ClientMigrationConfig struct {
+	MaxPerMinute int `json:"max_per_minute,omitempty"` // Address changes accepted per session per minute (default: 4, -1 = unlimited)
+	Probation    int `json:"probation,omitempty"`      // Milliseconds a new address is on probation (0 = switch at once)
+}
+
+const defaultMigrationsPerMinute = 4
+
+// migrationPolicy is the validated client_migration config.
+type migrationPolicy struct {
+	perMinute int // 0 = unlimited
+	probation time.Duration
+}
+
+// ClientMigrationStats counts client address changes.
+type ClientMigrationStats struct {
+	Accepted       uint64 `json:"accepted"`        // Sessions switched to a new address
+	Probation      uint64 `json:"probation"`       // New addresses put on probation
+	RejectedRate   uint64 `json:"rejected_rate"`   // Packets dropped because the session changed address too often
+	RejectedActive uint64 `json:"rejected_active"` // Probations ended because the old address was still sending
+}
+
+// clientMove is what to do with a packet of a known session.
+type clientMove int
+
+const (
+	moveStay      clientMove = iota // From the current address
+	moveSwitch                      // Switch the session to the packet's address
+	moveProbation                   // Forward the packet, keep sending to the current address
+	moveReject                      // Drop the packet
+)
+
+// sessionMoves tracks the address changes of one session.
+type sessionMoves struct {
+	mu        sync.Mutex
+	candidate *net.UDPAddr      // Address on probation, nil if none
+	since     time.Time         // Start of the probation
+	accepted  []time.Time       // Switches within the last minute
+	timer     *timerwheel.Timer // Ends the probation, nil until the first one
+}
+
+// migrationCounters backs ClientMigrationStats.
+type migrationCounters struct {
+	accepted, probation, rejectedRate, rejectedActive atomic.Uint64
+}
+
+// SetClientMigration configures client address change validation (hot-reload safe).
+func (p *Proxy) SetClientMigration(cfg *ClientMigrationConfig) error {
+	pol, err := newMigrationPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	p.migration.Store(pol)
+	return nil
+}
+
+// newMigrationPolicy returns the policy for cfg, or nil for a nil cfg.
+func newMigrationPolicy(cfg *ClientMigrationConfig) (*migrationPolicy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.MaxPerMinute < -1 || cfg.Probation < 0 {
+		return nil, fmt.Errorf("client_migration: max_per_minute must be >= -1 and probation >= 0")
+	}
+	pol := &migrationPolicy{perMinute: cfg.MaxPerMinute, probation: time.Duration(cfg.Probation) * time.Millisecond}
+	switch cfg.MaxPerMinute {
+	case 0:
+		pol.perMinute = defaultMigrationsPerMinute
+	case -1:
+		pol.perMinute = 0
+	}
+	return pol, nil
+}
+
+// ClientMigrationStats returns the client address change counters.
+func (p *Proxy) ClientMigrationStats() ClientMigrationStats {
+	return ClientMigrationStats{
+		Accepted:       p.moves.accepted.Load(),
+		Probation:      p.moves.probation.Load(),
+		RejectedRate:   p.moves.rejectedRate.Load(),
+		RejectedActive: p.moves.rejectedActive.Load(),
+	}
+}
+
+// clientMove decides whether a packet of session s from addr may move the
+// session there. A new address is accepted once it has been on probation
+// for the configured time without the current address sending anything,
+// so spoofed or replayed packets cannot take over a live session.
+func (p *Proxy) clientMove(s *handler.Session, addr *net.UDPAddr, now time.Time) clientMove {
+	current := s.ClientAddr()
+	moved := !current.IP.Equal(addr.IP) || current.Port != addr.Port
+	pol := p.migration.Load()
+	if pol == nil {
+		if moved {
+			return moveSwitch
+		}
+		return moveStay
+	}
+
+	v, ok := p.sessionMoves.Load(s.ID)
+	if !moved {
+		if !ok {
+			return moveStay
+		}
+		m := v.(*sessionMoves)
+		m.mu.Lock()
+		if m.candidate != nil {
+			logger.Warnf("session %d: %s still active, not moving to %s", s.ID, privacy.Addr(current), privacy.Addr(m.candidate))
+			s.Note("migration", "rejected "+privacy.Addr(m.candidate)+": old address active")
+			p.moves.rejectedActive.Add(1)
+			m.candidate = nil
+			m.timer.Stop()
+		}
+		m.mu.Unlock()
+		return moveStay
+	}
+	if !ok {
+		v, _ = p.sessionMoves.LoadOrStore(s.ID, &sessionMoves{})
+	}
+	m := v.(*sessionMoves)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if pol.perMinute > 0 {
+		recent := m.accepted[:0]
+		for _, t := range m.accepted {
+			if now.Sub(t) < time.Minute {
+				recent = append(recent, t)
+			}
+		}
+		m.accepted = recent
+		if len(m.accepted) >= pol.perMinute {
+			logger.Debugf("session %d: too many address changes, dropping packet from %s", s.ID, privacy.Addr(addr))
+			p.moves.rejectedRate.Add(1)
+			return moveReject
+		}
+	}
+
+	if pol.probation > 0 {
+		if m.candidate == nil || !m.candidate.IP.Equal(addr.IP) || m.candidate.Port != addr.Port {
+			logger.Debugf("session %d: %s on probation (current %s)", s.ID, privacy.Addr(addr), privacy.Addr(current))
+			s.Note("migration", "probation "+privacy.Addr(addr))
+			p.moves.probation.Add(1)
+			m.candidate, m.since = addr, now
+			if m.timer == nil {
+				m.timer = timerwheel.NewTimer(func() { p.endProbation(s, m) })
+			}
+			m.timer.Reset(pol.probation)
+			return moveProbation
+		}
+		if now.Sub(m.since) < pol.probation {
+			return moveProbation
+		}
+	}
+	if m.candidate != nil {
+		m.candidate = nil
+		m.timer.Stop()
+	}
+	m.accepted = append(m.accepted, now)
+	return moveSwitch
+}
+
+// endProbation switches session s to the address on probation once its
+// probation has passed without the current address sending anything, so
+// backend packets reach it before it sends again.
+func (p *Proxy) endProbation(s *handler.Session, m *sessionMoves) {
+	m.mu.Lock()
+	addr := m.candidate
+	if addr == nil || s.IsClosed() {
+		m.mu.Unlock()
+		return
+	}
+	m.candidate = nil
+	m.accepted = append(m.accepted, time.Now())
+	m.mu.Unlock()
+	p.switchClient(s, addr)
+}
+
+// stop ends a probation of a closed session.
+func (m *sessionMoves) stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.candidate = nil
+	if m.timer != nil {
+		m.timer.Stop()
+	}
+}
+
+// switchClient moves session s to addr, if it is not there already.
+func (p *Proxy) switchClient(s *handler.Session, addr *net.UDPAddr) {
+	current := s.ClientAddr()
+	if current.IP.Equal(addr.IP) && current.Port == addr.Port {
+		return
+	}
+	logger.Printf("connection migration: %s -> %s (DCID=%x)", privacy.Addr(current), privacy.Addr(addr), s.DCID)
+	s.SetClientAddr(addr)
+	s.Note("migration", privacy.Addr(current)+" -> "+privacy.Addr(addr))
+	p.moves.accepted.Add(1)
+
+	// Update clientSessions mapping for the new address
+	p.store.DeleteClientSession("", addrKey(current))
+	p.store.SetClientSession("", addrKey(addr), string(s.DCID))
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+func TestSetClientMigration_Errors(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	for _, cfg := range []ClientMigrationConfig{{MaxPerMinute: -2}, {Probation: -1}} {
+		if err := p.SetClientMigration(&cfg); err == nil {
+			t.Errorf("%+v: no error", cfg)
+		}
+	}
+}
+
+func TestClientMove(t *testing.T) {
+	old := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+	rebound := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4001}
+	other := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 9), Port: 5000}
+	now := time.Now()
+	newSession := func() *handler.Session {
+		s := &handler.Session{ID: 1}
+		s.SetClientAddr(old)
+		return s
+	}
+
+	// Without config, any address is taken at once
+	p := New(":0", handler.NewChain())
+	s := newSession()
+	if got := p.clientMove(s, old, now); got != moveStay {
+		t.Errorf("same address = %v, want stay", got)
+	}
+	if got := p.clientMove(s, other, now); got != moveSwitch {
+		t.Errorf("no config = %v, want switch", got)
+	}
+
+	// Probation: the old address must stay silent
+	p.SetClientMigration(&ClientMigrationConfig{Probation: 500})
+	if got := p.clientMove(s, rebound, now); got != moveProbation {
+		t.Errorf("first packet = %v, want probation", got)
+	}
+	if got := p.clientMove(s, rebound, now.Add(100*time.Millisecond)); got != moveProbation {
+		t.Errorf("during probation = %v, want probation", got)
+	}
+	if got := p.clientMove(s, old, now.Add(200*time.Millisecond)); got != moveStay {
+		t.Errorf("old address = %v, want stay", got)
+	}
+	if got := p.clientMove(s, rebound, now.Add(time.Second)); got != moveProbation {
+		t.Errorf("after the old address spoke = %v, want a new probation", got)
+	}
+	if got := p.clientMove(s, rebound, now.Add(2*time.Second)); got != moveSwitch {
+		t.Errorf("after probation = %v, want switch", got)
+	}
+	st := p.ClientMigrationStats()
+	if st.Probation != 2 || st.RejectedActive != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	// Rate limit
+	p.SetClientMigration(&ClientMigrationConfig{MaxPerMinute: 2})
+	s = newSession()
+	s.ID = 2
+	addrs := []*net.UDPAddr{rebound, other, old}
+	var got []clientMove
+	for i, addr := range addrs {
+		move := p.clientMove(s, addr, now.Add(time.Duration(i)*time.Second))
+		if move == moveSwitch {
+			s.SetClientAddr(addr)
+		}
+		got = append(got, move)
+	}
+	if got[0] != moveSwitch || got[1] != moveSwitch || got[2] != moveReject {
+		t.Errorf("moves = %v, want switch, switch, reject", got)
+	}
+	if got := p.clientMove(s, old, now.Add(time.Minute+time.Second)); got != moveSwitch {
+		t.Errorf("a minute later = %v, want switch", got)
+	}
+	if st := p.ClientMigrationStats(); st.RejectedRate != 1 {
+		t.Errorf("rejected_rate = %d, want 1", st.RejectedRate)
+	}
+}
+
+func TestClientMove_ProbationEnds(t *testing.T) {
+	old := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4000}
+	rebound := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 4001}
+	p := New(":0", handler.NewChain())
+	p.SetClientMigration(&ClientMigrationConfig{Probation: 20})
+	s := &handler.Session{ID: 1}
+	s.SetClientAddr(old)
+
+	// The session switches when the probation ends, without another packet
+	if got := p.clientMove(s, rebound, time.Now()); got != moveProbation {
+		t.Fatalf("first packet = %v, want probation", got)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for s.ClientAddr().Port != rebound.Port && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if s.ClientAddr().Port != rebound.Port {
+		t.Fatal("session not switched after probation")
+	}
+	if st := p.ClientMigrationStats(); st.Accepted != 1 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	// A closed session's probation never ends
+	s.SetClientAddr(old)
+	p.clientMove(s, rebound, time.Now())
+	if m, ok := p.sessionMoves.LoadAndDelete(s.ID); ok {
+		m.(*sessionMoves).stop()
+	}
+	time.Sleep(50 * time.Millisecond)
+	if s.ClientAddr().Port != old.Port {
+		t.Error("stopped probation switched the session")
+	}
+}
@@ -0,0 +1,263 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// QUIC transport error codes (RFC 9000 Section 20.1) used when refusing connections.
+const (
+	ErrorCodeNoError           uint64 = 0x00
+	ErrorCodeConnectionRefused uint64 = 0x02
+)
+
+// maxCloseReasonLen bounds the reason phrase so the packet stays well below the minimum MTU.
+const maxCloseReasonLen = 512
+
+// BuildInitialConnectionClose builds a server Initial packet carrying a
+// CONNECTION_CLOSE frame (type 0x1c) in response to a client Initial.
+// The packet is protected with the server initial keys derived from the
+// client's original DCID, so the client can decrypt it and surface the reason.
+func BuildInitialConnectionClose(clientInitial []byte, errorCode uint64, reason string) ([]byte, error) {
+	if ClassifyPacket(clientInitial) != PacketInitial {
+		return nil, errors.New("not an Initial packet")
+	}
+	version := binary.BigEndian.Uint32(clientInitial[1:5])
+	if version != quicVersion1 {
+		return nil, fmt.Errorf("unsupported QUIC version: 0x%08x", version)
+	}
+	odcid, clientSCID, err := ExtractDCIDAndSCID(clientInitial)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(reason) > maxCloseReasonLen {
+		reason = reason[:maxCloseReasonLen]
+	}
+
+	// CONNECTION_CLOSE frame: type, error code, offending frame type (0), reason
+	payload := make([]byte, 0, 16+len(reason))
+	payload = appendVarInt(payload, 0x1c)
+	payload = appendVarInt(payload, errorCode)
+	payload = appendVarInt(payload, 0)
+	payload = appendVarInt(payload, uint64(len(reason)))
+	payload = append(payload, reason...)
+
+	serverSCID := make([]byte, 8)
+	if _, err := rand.Read(serverSCID); err != nil {
+		return nil, err
+	}
+	sealer, err := newServerInitialSealer(version, odcid)
+	if err != nil {
+		return nil, err
+	}
+	return sealer.seal(clientSCID, serverSCID, 0, payload), nil
+}
+
+// serverInitialSealer protects Initial packets sent to a client on behalf of
+// the server, with the server initial keys derived from the client's original DCID.
+type serverInitialSealer struct {
+	version uint32
+	aead    cipher.AEAD
+	iv      []byte
+	hp      cipher.Block
+}
+
+func newServerInitialSealer(version uint32, odcid []byte) (*serverInitialSealer, error) {
+	key, iv, hp, err := deriveServerInitialKeys(odcid)
+	if err != nil {
+		return nil, err
+	}
+	aesCipher, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(aesCipher)
+	if err != nil {
+		return nil, err
+	}
+	hpCipher, err := aes.NewCipher(hp)
+	if err != nil {
+		return nil, err
+	}
+	return &serverInitialSealer{version: version, aead: aead, iv: iv, hp: hpCipher}, nil
+}
+
+// seal builds a protected server Initial packet carrying payload.
+func (s *serverInitialSealer) seal(dcid, scid []byte, pn uint64, payload []byte) []byte {
+	pnLen := 1
+	if pn >= 1<<7 {
+		pnLen = 4
+	}
+	// Header protection samples 16 bytes starting 4 bytes after the packet number
+	for len(payload) < 20 {
+		payload = append(payload, 0x00) // PADDING
+	}
+
+	// Long header: Initial
+	header := make([]byte, 0, 32+len(dcid)+len(scid))
+	header = append(header, 0xC0|byte(pnLen-1))
+	header = binary.BigEndian.AppendUint32(header, s.version)
+	header = append(header, byte(len(dcid)))
+	header = append(header, dcid...)
+	header = append(header, byte(len(scid)))
+	header = append(header, scid...)
+	header = appendVarInt(header, 0) // Token length
+	header = appendVarInt2(header, uint64(pnLen+len(payload)+s.aead.Overhead()))
+	pnOffset := len(header)
+	for i := pnLen - 1; i >= 0; i-- {
+		header = append(header, byte(pn>>(8*i)))
+	}
+
+	var nonce [12]byte
+	copy(nonce[:], s.iv)
+	for i := 0; i < 8; i++ {
+		nonce[4+i] ^= byte(pn >> (56 - 8*i))
+	}
+	packet := s.aead.Seal(header, nonce[:], payload, header)
+
+	// Apply header protection
+	var mask [16]byte
+	sample := packet[pnOffset+4 : pnOffset+4+16]
+	s.hp.Encrypt(mask[:], sample)
+	packet[0] ^= mask[0] & 0x0f
+	for i := 0; i < pnLen; i++ {
+		packet[pnOffset+i] ^= mask[1+i]
+	}
+	return packet
+}
+
+// initialAcker acknowledges a client's first Initial on behalf of a server
+// that never answers it. Clients stop retransmitting and wait for the rest of
+// the handshake; every acknowledgement resets their handshake idle timer.
+type initialAcker struct {
+	mu         sync.Mutex
+	sealer     *serverInitialSealer
+	clientSCID []byte
+	serverSCID []byte
+	largest    uint64 // Packet number of the acknowledged client Initial
+	next       uint64 // Packet number of the next server Initial
+}
+
+// newInitialAcker prepares acknowledgements of clientInitial. It keeps no
+// reference to the packet.
+func newInitialAcker(clientInitial []byte) (*initialAcker, error) {
+	if ClassifyPacket(clientInitial) != PacketInitial {
+		return nil, errors.New("not an Initial packet")
+	}
+	version := binary.BigEndian.Uint32(clientInitial[1:5])
+	if version != quicVersion1 {
+		return nil, fmt.Errorf("unsupported QUIC version: 0x%08x", version)
+	}
+	odcid, clientSCID, err := ExtractDCIDAndSCID(clientInitial)
+	if err != nil {
+		return nil, err
+	}
+	pn, err := initialPacketNumber(clientInitial, odcid)
+	if err != nil {
+		return nil, err
+	}
+	sealer, err := newServerInitialSealer(version, odcid)
+	if err != nil {
+		return nil, err
+	}
+	serverSCID := make([]byte, 8)
+	if _, err := rand.Read(serverSCID); err != nil {
+		return nil, err
+	}
+	return &initialAcker{
+		sealer:     sealer,
+		clientSCID: bytes.Clone(clientSCID),
+		serverSCID: serverSCID,
+		largest:    pn,
+	}, nil
+}
+
+// packet builds the next acknowledgement. ACK-only packets are not
+// ack-eliciting, so the client sends nothing back for them.
+func (a *initialAcker) packet() []byte {
+	a.mu.Lock()
+	pn := a.next
+	a.next++
+	a.mu.Unlock()
+
+	// ACK frame: largest acknowledged, delay 0, no additional ranges, first range 0
+	payload := make([]byte, 0, 20)
+	payload = appendVarInt(payload, 0x02)
+	payload = appendVarInt(payload, a.largest)
+	payload = append(payload, 0, 0, 0)
+	return a.sealer.seal(a.clientSCID, a.serverSCID, pn, payload)
+}
+
+// initialPacketNumber removes the header protection of a client Initial and
+// returns its packet number. Initial packet numbers start at 0, so the
+// truncated number is the full one for a client's first packets.
+func initialPacketNumber(packet, odcid []byte) (uint64, error) {
+	offset := 6 + int(packet[5])
+	if offset >= len(packet) {
+		return 0, errors.New("packet too short for SCID length")
+	}
+	offset += 1 + int(packet[offset])
+	if offset > len(packet) {
+		return 0, errors.New("packet too short for SCID")
+	}
+	tokenLen, n, err := readVarInt(packet[offset:])
+	if err != nil {
+		return 0, fmt.Errorf("failed to read token length: %w", err)
+	}
+	offset += n + int(tokenLen)
+	if offset > len(packet) {
+		return 0, errors.New("packet too short for token")
+	}
+	_, n, err = readVarInt(packet[offset:])
+	if err != nil {
+		return 0, fmt.Errorf("failed to read payload length: %w", err)
+	}
+	pnOffset := offset + n
+	if pnOffset+4+16 > len(packet) {
+		return 0, errors.New("packet too short for header protection sample")
+	}
+
+	_, _, hp, err := deriveInitialKeys(odcid)
+	if err != nil {
+		return 0, err
+	}
+	hpCipher, err := aes.NewCipher(hp)
+	if err != nil {
+		return 0, err
+	}
+	var mask [16]byte
+	hpCipher.Encrypt(mask[:], packet[pnOffset+4:pnOffset+4+16])
+	pnLen := int((packet[0]^mask[0])&0x03) + 1
+	var pn uint64
+	for i := 0; i < pnLen; i++ {
+		pn = pn<<8 | uint64(packet[pnOffset+i]^mask[1+i])
+	}
+	return pn, nil
+}
+
+// appendVarInt appends a QUIC variable-length integer using the shortest encoding.
+func appendVarInt(b []byte, v uint64) []byte {
+	switch {
+	case v < 1<<6:
+		return append(b, byte(v))
+	case v < 1<<14:
+		return append(b, byte(v>>8)|0x40, byte(v))
+	case v < 1<<30:
+		return append(b, byte(v>>24)|0x80, byte(v>>16), byte(v>>8), byte(v))
+	default:
+		return append(b, byte(v>>56)|0xc0, byte(v>>48), byte(v>>40), byte(v>>32),
+			byte(v>>24), byte(v>>16), byte(v>>8), byte(v))
+	}
+}
+
+// appendVarInt2 appends v as a fixed 2-byte varint (v must be < 16384).
+func appendVarInt2(b []byte, v uint64) []byte {
+	return append(b, byte(v>>8)|0x40, byte(v))
+}
@@ -0,0 +1,120 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+// testClientInitialHeader returns a minimal client Initial long header.
+func testClientInitialHeader(dcid, scid []byte) []byte {
+	pkt := []byte{0xC3, 0x00, 0x00, 0x00, 0x01}
+	pkt = append(pkt, byte(len(dcid)))
+	pkt = append(pkt, dcid...)
+	pkt = append(pkt, byte(len(scid)))
+	pkt = append(pkt, scid...)
+	return append(pkt, make([]byte, 32)...)
+}
+
+func TestBuildInitialConnectionClose(t *testing.T) {
+	dcid := []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+	scid := []byte{0xaa, 0xbb, 0xcc, 0xdd}
+	reason := "server under maintenance"
+
+	pkt, err := BuildInitialConnectionClose(testClientInitialHeader(dcid, scid), ErrorCodeConnectionRefused, reason)
+	if err != nil {
+		t.Fatalf("BuildInitialConnectionClose: %v", err)
+	}
+
+	if ClassifyPacket(pkt) != PacketInitial {
+		t.Fatalf("expected Initial packet, got %v", ClassifyPacket(pkt))
+	}
+	gotDCID, _, err := ExtractDCIDAndSCID(pkt)
+	if err != nil || !bytes.Equal(gotDCID, scid) {
+		t.Fatalf("expected DCID=%x (client SCID), got %x (%v)", scid, gotDCID, err)
+	}
+
+	// Decrypt with server initial keys like a client would
+	key, iv, hp, err := deriveServerInitialKeys(dcid)
+	if err != nil {
+		t.Fatalf("deriveServerInitialKeys: %v", err)
+	}
+	hpCipher, _ := aes.NewCipher(hp)
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+
+	pnOffset := 1 + 4 + 1 + len(scid) + 1 + 8 + 1 + 2
+	plaintext, err := DecryptWithCachedCrypto(pkt, pkt[pnOffset:], hpCipher, aead, iv)
+	if err != nil {
+		t.Fatalf("client could not decrypt CONNECTION_CLOSE: %v", err)
+	}
+
+	want := append([]byte{0x1c, byte(ErrorCodeConnectionRefused), 0x00, byte(len(reason))}, reason...)
+	if !bytes.HasPrefix(plaintext, want) {
+		t.Errorf("unexpected frame: %x", plaintext)
+	}
+}
+
+func TestBuildInitialConnectionClose_RejectsNonInitial(t *testing.T) {
+	if _, err := BuildInitialConnectionClose([]byte{0x40, 0x01, 0x02}, 0, ""); err == nil {
+		t.Error("expected error for short header packet")
+	}
+}
+
+func TestAppendVarInt(t *testing.T) {
+	for _, v := range []uint64{0, 63, 64, 16383, 16384, 1<<30 - 1, 1 << 30} {
+		enc := appendVarInt(nil, v)
+		got, n, err := readVarInt(enc)
+		if err != nil || got != v || n != len(enc) {
+			t.Errorf("roundtrip %d: got %d (n=%d, len=%d, err=%v)", v, got, n, len(enc), err)
+		}
+	}
+}
+
+func TestInitialAcker(t *testing.T) {
+	initial := clientInitials(t)[0]
+	odcid, clientSCID, err := ExtractDCIDAndSCID(initial)
+	if err != nil {
+		t.Fatal(err)
+	}
+	acker, err := newInitialAcker(initial)
+	if err != nil {
+		t.Fatalf("newInitialAcker: %v", err)
+	}
+
+	key, iv, hp, err := deriveServerInitialKeys(odcid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hpCipher, _ := aes.NewCipher(hp)
+	block, _ := aes.NewCipher(key)
+	aead, _ := cipher.NewGCM(block)
+
+	var scid []byte
+	for i := range 2 {
+		pkt := acker.packet()
+		gotDCID, gotSCID, err := ExtractDCIDAndSCID(pkt)
+		if err != nil || !bytes.Equal(gotDCID, clientSCID) {
+			t.Fatalf("packet %d: DCID=%x, want client SCID %x (%v)", i, gotDCID, clientSCID, err)
+		}
+		if i > 0 && !bytes.Equal(gotSCID, scid) {
+			t.Errorf("packet %d: SCID changed from %x to %x", i, scid, gotSCID)
+		}
+		scid = gotSCID
+
+		pnOffset := 1 + 4 + 1 + len(clientSCID) + 1 + len(scid) + 1 + 2
+		plaintext, err := DecryptWithCachedCrypto(pkt, pkt[pnOffset:], hpCipher, aead, iv)
+		if err != nil {
+			t.Fatalf("packet %d: client could not decrypt ACK: %v", i, err)
+		}
+		// ACK of the client's packet 0, no delay, no additional ranges
+		if want := []byte{0x02, 0x00, 0x00, 0x00, 0x00}; !bytes.HasPrefix(plaintext, want) {
+			t.Errorf("packet %d: frame %x, want prefix %x", i, plaintext, want)
+		}
+	}
+
+	if _, err := newInitialAcker([]byte{0x40, 0x01, 0x02}); err == nil {
+		t.Error("expected error for short header packet")
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import (
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+// sessionBackend returns the backend a session was routed to.
+func sessionBackend(ctx *handler.Context) string {
+	return ctx.GetString("backend")
+}
+
+// BackendSessions returns the active sessions routed to backend.
+func (p *Proxy) BackendSessions(backend string) []SessionInfo {
+	infos := []SessionInfo{}
+	p.store.Range(func(key string, ctx *handler.Context) bool {
+		if ctx.Session != nil && sessionBackend(ctx) == backend {
+			infos = append(infos, sessionInfo(ctx))
+		}
+		return true
+	})
+	return infos
+}
+
+// closeDrainedSessions closes the sessions of draining backends whose deadline has passed.
+func (p *Proxy) closeDrainedSessions(now time.Time) {
+	if len(handler.DrainingBackends()) == 0 {
+		return
+	}
+	p.store.Range(func(key string, ctx *handler.Context) bool {
+		if ctx.Session == nil {
+			return true
+		}
+		info, ok := handler.DrainStatus(sessionBackend(ctx))
+		if ok && !info.Deadline.IsZero() && now.After(info.Deadline) {
+			logger.Printf("closing session %d, backend %s drained", ctx.Session.ID, info.Backend)
+			p.closeSession(key, ctx, handler.CloseDrain)
+		}
+		return true
+	})
+}
@@ -0,0 +1,36 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+func TestCloseDrainedSessions(t *testing.T) {
+	p := New("127.0.0.1:0", handler.NewChain())
+	a := addTestSession(p, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 40000}, []byte("dcid-aaa"))
+	a.Set("backend", "10.0.0.1:5520")
+	b := addTestSession(p, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 2), Port: 40000}, []byte("dcid-bbb"))
+	b.Set("backend", "10.0.0.2:5520")
+
+	deadline := time.Now().Add(time.Minute)
+	handler.DrainBackend("10.0.0.1:5520", deadline)
+	defer handler.UndrainBackend("10.0.0.1:5520")
+
+	if got := p.BackendSessions("10.0.0.1:5520"); len(got) != 1 {
+		t.Fatalf("BackendSessions = %+v, want one session", got)
+	}
+	p.closeDrainedSessions(time.Now())
+	if p.SessionCount() != 2 {
+		t.Fatal("sessions closed before the deadline")
+	}
+	p.closeDrainedSessions(deadline.Add(time.Second))
+	if p.SessionCount() != 1 || a.CloseReason() != handler.CloseDrain {
+		t.Errorf("sessions = %d, close reason = %v; want 1 and drain", p.SessionCount(), a.CloseReason())
+	}
+	if len(p.BackendSessions("10.0.0.2:5520")) != 1 {
+		t.Error("session of another backend closed")
+	}
+}
@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"net"
+	"sync"
+	"time"
+
+	"quic-relay/internal/debug"
+	"quic-relay/internal/handler"
+)
+
+// dropResponseRate bounds active drop responses per second across all clients,
+// so dropped traffic can't be turned into a reflection source.
+const dropResponseRate = 100
+
+// Stateless reset sizes (RFC 9000 Section 10.3).
+const (
+	minStatelessResetLen = 21 // 5 unpredictable bytes + 16 byte token
+	maxStatelessResetLen = 43
+)
+
+// dropResponder sends the responses configured by on_drop policies.
+type dropResponder struct {
+	mu     sync.Mutex
+	window int64 // Unix second of the current rate window
+	count  int
+
+	icmpOnce sync.Once
+	icmp4    net.PacketConn // nil if raw sockets are not permitted
+	icmp6    net.PacketConn
+}
+
+// allow reports whether another response fits into the current second.
+func (d *dropResponder) allow() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now().Unix()
+	if now != d.window {
+		d.window, d.count = now, 0
+	}
+	if d.count >= dropResponseRate {
+		return false
+	}
+	d.count++
+	return true
+}
+
+// respondDrop sends the response of a dropping handler's on_drop policy.
+// Actions that don't apply (close for established sessions, reset for non-QUIC
+// flows, icmp for ingress adapters) fall back to a silent drop.
+func (p *Proxy) respondDrop(conn handler.ClientConn, clientAddr *net.UDPAddr, packet []byte, ctx *handler.Context, result handler.Result) {
+	if p.replay != nil {
+		p.replay.dropped(ctx, result)
+	}
+	policy := result.Policy
+	if policy == nil || policy.Action == handler.DropSilent {
+		return
+	}
+	if ctx != nil && ctx.Refused() {
+		return // The handler already told the client
+	}
+	if !p.drops.allow() {
+		return
+	}
+
+	switch policy.Action {
+	case handler.DropClose:
+		if ctx == nil || ctx.Protocol != "" || ctx.Session != nil {
+			return
+		}
+		reason := policy.Reason
+		if reason == "" && result.Error != nil {
+			reason = result.Error.Error()
+		}
+		if err := ctx.Refuse(policy.ErrorCode, reason); err != nil {
+			debug.Notef(" on_drop close failed: %v", err)
+		}
+	case handler.DropReset:
+		if ctx != nil && ctx.Protocol != "" {
+			return
+		}
+		if reset := buildStatelessReset(len(packet), p.resetToken(packet)); reset != nil {
+			conn.WriteToUDP(reset, clientAddr)
+		}
+	case handler.DropICMP:
+		local, ok := conn.LocalAddr().(*net.UDPAddr)
+		if !ok || p.replay != nil {
+			return
+		}
+		if err := p.drops.sendPortUnreachable(local, clientAddr, len(packet)); err != nil {
+			debug.Notef(" on_drop icmp failed: %v", err)
+		}
+	}
+}
+
+// buildStatelessReset builds a QUIC stateless reset ending in token, smaller than
+// the packet that triggered it. Without a token (no stateless_reset key) the
+// packet only looks like a reset. Returns nil if the trigger is too small to
+// answer without risking reset loops.
+func buildStatelessReset(triggerLen int, token []byte) []byte {
+	n := min(triggerLen-1, maxStatelessResetLen)
+	if n < minStatelessResetLen {
+		return nil
+	}
+	pkt := make([]byte, n)
+	rand.Read(pkt)
+	pkt[0] = 0x40 | pkt[0]&0x3f // Short header, fixed bit set
+	copy(pkt[n-statelessResetTokenLen:], token)
+	return pkt
+}
+
+// openICMP opens the raw ICMP sockets once. Without CAP_NET_RAW they stay nil.
+func (d *dropResponder) openICMP() {
+	d.icmpOnce.Do(func() {
+		var err error
+		if d.icmp4, err = net.ListenPacket("ip4:icmp", "0.0.0.0"); err != nil {
+			d.icmp4 = nil
+			logger.Warnf("on_drop icmp unavailable for IPv4, dropping silently: %v", err)
+		}
+		if d.icmp6, err = net.ListenPacket("ip6:ipv6-icmp", "::"); err != nil {
+			d.icmp6 = nil
+			logger.Warnf("on_drop icmp unavailable for IPv6, dropping silently: %v", err)
+		}
+	})
+}
+
+// sendPortUnreachable sends an ICMP port unreachable for a datagram from client to local.
+func (d *dropResponder) sendPortUnreachable(local, client *net.UDPAddr, payloadLen int) error {
+	d.openICMP()
+
+	localIP := local.IP
+	if localIP == nil || localIP.IsUnspecified() {
+		// Wildcard listener: use the address the kernel would answer from
+		probe, err := net.DialUDP("udp", nil, client)
+		if err != nil {
+			return err
+		}
+		localIP = probe.LocalAddr().(*net.UDPAddr).IP
+		probe.Close()
+	}
+
+	if ip4 := client.IP.To4(); ip4 != nil {
+		if d.icmp4 == nil {
+			return nil
+		}
+		msg := buildICMPv4PortUnreachable(ip4, localIP.To4(), client.Port, local.Port, payloadLen)
+		_, err := d.icmp4.WriteTo(msg, &net.IPAddr{IP: ip4})
+		return err
+	}
+	if d.icmp6 == nil {
+		return nil
+	}
+	msg := buildICMPv6PortUnreachable(client.IP, localIP, client.Port, local.Port, payloadLen)
+	_, err := d.icmp6.WriteTo(msg, &net.IPAddr{IP: client.IP, Zone: client.Zone})
+	return err
+}
+
+// buildICMPv4PortUnreachable builds an ICMP destination unreachable (type 3, code 3)
+// quoting the IP and UDP header of the offending datagram (RFC 792).
+func buildICMPv4PortUnreachable(src, dst net.IP, srcPort, dstPort, payloadLen int) []byte {
+	msg := make([]byte, 8+20+8)
+	msg[0], msg[1] = 3, 3
+
+	ip := msg[8:28]
+	ip[0] = 0x45
+	binary.BigEndian.PutUint16(ip[2:], uint16(20+8+payloadLen))
+	ip[8] = 64 // TTL
+	ip[9] = 17 // UDP
+	copy(ip[12:16], src)
+	copy(ip[16:20], dst)
+	binary.BigEndian.PutUint16(ip[10:], internetChecksum(ip))
+
+	udp := msg[28:]
+	binary.BigEndian.PutUint16(udp[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+payloadLen))
+
+	binary.BigEndian.PutUint16(msg[2:], internetChecksum(msg))
+	return msg
+}
+
+// buildICMPv6PortUnreachable builds an ICMPv6 destination unreachable (type 1, code 4).
+// The kernel fills in the checksum for ICMPv6 raw sockets.
+func buildICMPv6PortUnreachable(src, dst net.IP, srcPort, dstPort, payloadLen int) []byte {
+	msg := make([]byte, 8+40+8)
+	msg[0], msg[1] = 1, 4
+
+	ip := msg[8:48]
+	ip[0] = 0x60
+	binary.BigEndian.PutUint16(ip[4:], uint16(8+payloadLen))
+	ip[6] = 17 // UDP
+	ip[7] = 64 // Hop limit
+	copy(ip[8:24], src.To16())
+	copy(ip[24:40], dst.To16())
+
+	udp := msg[48:]
+	binary.BigEndian.PutUint16(udp[0:], uint16(srcPort))
+	binary.BigEndian.PutUint16(udp[2:], uint16(dstPort))
+	binary.BigEndian.PutUint16(udp[4:], uint16(8+payloadLen))
+	return msg
+}
+
+// internetChecksum computes the RFC 1071 checksum.
+func internetChecksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(b[i:]))
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = sum&0xffff + sum>>16
+	}
+	return ^uint16(sum)
+}
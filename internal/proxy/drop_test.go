@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+// recordingConn captures datagrams sent to clients.
+type recordingConn struct {
+	sent [][]byte
+}
+
+func (c *recordingConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.sent = append(c.sent, append([]byte(nil), b...))
+	return len(b), nil
+}
+
+func (c *recordingConn) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 5520}
+}
+
+func TestBuildStatelessReset(t *testing.T) {
+	if buildStatelessReset(21, nil) != nil {
+		t.Error("reset for tiny trigger")
+	}
+	if r := buildStatelessReset(30, nil); len(r) != 29 || r[0]&0xc0 != 0x40 {
+		t.Errorf("reset = %x", r)
+	}
+	if r := buildStatelessReset(1200, nil); len(r) != maxStatelessResetLen {
+		t.Errorf("reset len = %d, want %d", len(r), maxStatelessResetLen)
+	}
+}
+
+func TestBuildICMPv4PortUnreachable(t *testing.T) {
+	msg := buildICMPv4PortUnreachable(net.IPv4(192, 0, 2, 1).To4(), net.IPv4(198, 51, 100, 1).To4(), 40000, 5520, 1200)
+	if msg[0] != 3 || msg[1] != 3 {
+		t.Errorf("type/code = %d/%d", msg[0], msg[1])
+	}
+	if internetChecksum(msg) != 0 {
+		t.Error("invalid ICMP checksum")
+	}
+	if internetChecksum(msg[8:28]) != 0 {
+		t.Error("invalid quoted IP header checksum")
+	}
+	udp := msg[28:]
+	if binary.BigEndian.Uint16(udp[0:]) != 40000 || binary.BigEndian.Uint16(udp[2:]) != 5520 {
+		t.Errorf("quoted ports = %x", udp[:4])
+	}
+}
+
+func TestRespondDrop(t *testing.T) {
+	client := &net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000}
+	initial := append(testClientInitialHeader([]byte{1, 2, 3, 4, 5, 6, 7, 8}, []byte{9, 9}), make([]byte, 1200)...)
+
+	tests := []struct {
+		name     string
+		policy   *handler.DropPolicy
+		protocol string
+		wantSent bool
+	}{
+		{"no policy", nil, "", false},
+		{"silent", &handler.DropPolicy{Action: handler.DropSilent}, "", false},
+		{"reset", &handler.DropPolicy{Action: handler.DropReset}, "", true},
+		{"reset non-QUIC", &handler.DropPolicy{Action: handler.DropReset}, "wireguard", false},
+		{"close", &handler.DropPolicy{Action: handler.DropClose, ErrorCode: ErrorCodeConnectionRefused}, "", true},
+		{"close non-QUIC", &handler.DropPolicy{Action: handler.DropClose}, "wireguard", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(":0", handler.NewChain())
+			conn := &recordingConn{}
+			ctx := &handler.Context{ClientAddr: client, Protocol: tt.protocol}
+			ctx.SendConnectionClose = func(code uint64, reason string) error {
+				pkt, err := BuildInitialConnectionClose(initial, code, reason)
+				if err != nil {
+					return err
+				}
+				_, err = conn.WriteToUDP(pkt, client)
+				return err
+			}
+
+			p.respondDrop(conn, client, initial, ctx, handler.Result{Action: handler.Drop, Error: errors.New("denied"), Policy: tt.policy})
+			if got := len(conn.sent) > 0; got != tt.wantSent {
+				t.Errorf("sent = %v, want %v", got, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestRespondDrop_AlreadyRefused(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	conn := &recordingConn{}
+	ctx := &handler.Context{}
+	ctx.SendConnectionClose = func(uint64, string) error { return nil }
+	ctx.Refuse(ErrorCodeConnectionRefused, "maintenance")
+
+	p.respondDrop(conn, &net.UDPAddr{}, make([]byte, 100), ctx, handler.Result{Action: handler.Drop, Policy: &handler.DropPolicy{Action: handler.DropReset}})
+	if len(conn.sent) != 0 {
+		t.Error("responded to a connection the handler already refused")
+	}
+}
+
+func TestDropResponder_RateLimit(t *testing.T) {
+	var d dropResponder
+	allowed := 0
+	for i := 0; i < dropResponseRate*2; i++ {
+		if d.allow() {
+			allowed++
+		}
+	}
+	if allowed != dropResponseRate {
+		t.Errorf("allowed = %d, want %d", allowed, dropResponseRate)
+	}
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+// Session event types.
+const (
+	EventOpen  = "open"  // Session established
+	EventClose = "close" // Session ended; Reason says why
+	EventStats = "stats" // Periodic counters of an active session
+)
+
+// Event describes a change in a session, streamed to admin API subscribers.
+type Event struct {
+	Type    string      `json:"type"`
+	Time    time.Time   `json:"time"`
+	Reason  string      `json:"reason,omitempty"` // Close reason, for close events
+	Session SessionInfo `json:"session"`
+}
+
+// subscriberBuffer is the number of events a subscriber may lag behind before
+// events are dropped for it. Slow subscribers never block the packet path.
+const subscriberBuffer = 1024
+
+// Subscription receives session events until Close is called.
+type Subscription struct {
+	C       <-chan Event
+	ch      chan Event
+	dropped atomic.Uint64
+	hub     *eventHub
+}
+
+// Dropped returns the number of events lost because the subscriber lagged behind.
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+// Close stops delivery to the subscription.
+func (s *Subscription) Close() {
+	s.hub.unsubscribe(s)
+}
+
+// eventHub fans out session events to subscribers.
+type eventHub struct {
+	mu     sync.RWMutex
+	subs   map[*Subscription]struct{}
+	active atomic.Int32 // Subscriber count, checked before building events
+}
+
+func (h *eventHub) subscribe() *Subscription {
+	ch := make(chan Event, subscriberBuffer)
+	sub := &Subscription{C: ch, ch: ch, hub: h}
+	h.mu.Lock()
+	if h.subs == nil {
+		h.subs = make(map[*Subscription]struct{})
+	}
+	h.subs[sub] = struct{}{}
+	h.active.Store(int32(len(h.subs)))
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	h.active.Store(int32(len(h.subs)))
+	h.mu.Unlock()
+}
+
+// publishSession sends a lifecycle event for ctx to all subscribers.
+func (h *eventHub) publishSession(typ string, ctx *handler.Context) {
+	if h.active.Load() == 0 || ctx == nil || ctx.Session == nil {
+		return
+	}
+	ev := Event{Type: typ, Time: time.Now(), Session: sessionInfo(ctx)}
+	if typ == EventClose {
+		ev.Reason = ctx.CloseReason().String()
+	}
+
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	for sub := range h.subs {
+		select {
+		case sub.ch <- ev:
+		default:
+			sub.dropped.Add(1)
+		}
+	}
+}
+
+// Subscribe returns a subscription to session open and close events.
+// The caller must Close it when done.
+func (p *Proxy) Subscribe() *Subscription {
+	return p.events.subscribe()
+}
@@ -0,0 +1,107 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/privacy"
+)
+
+func testSessionContext(id uint64) *handler.Context {
+	ctx := &handler.Context{Hello: &handler.ClientHello{SNI: "play.example.com"}}
+	ctx.Session = &handler.Session{ID: id, CreatedAt: time.Now()}
+	ctx.Session.SetClientAddr(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 40000})
+	return ctx
+}
+
+func TestEvents_Lifecycle(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	sub := p.Subscribe()
+	defer sub.Close()
+
+	ctx := testSessionContext(7)
+	p.storeSession("k", ctx)
+	ctx.Session.CountIn(100)
+	p.closeSession("k", ctx, handler.CloseAdminKill)
+
+	open := <-sub.C
+	if open.Type != EventOpen || open.Session.ID != 7 || open.Session.SNI != "play.example.com" {
+		t.Errorf("open event = %+v", open)
+	}
+	closed := <-sub.C
+	if closed.Type != EventClose || closed.Reason != "admin_kill" || closed.Session.BytesIn != 100 {
+		t.Errorf("close event = %+v", closed)
+	}
+}
+
+func TestEvents_SlowSubscriber(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	sub := p.Subscribe()
+
+	for i := 0; i < subscriberBuffer+5; i++ {
+		p.events.publishSession(EventOpen, testSessionContext(uint64(i)))
+	}
+	if got := sub.Dropped(); got != 5 {
+		t.Errorf("dropped = %d, want 5", got)
+	}
+
+	sub.Close()
+	if p.events.active.Load() != 0 {
+		t.Error("subscriber still registered after Close")
+	}
+}
+
+func TestEvents_Privacy(t *testing.T) {
+	if err := privacy.Configure(&privacy.Config{Mode: privacy.ModeTruncate}); err != nil {
+		t.Fatal(err)
+	}
+	defer privacy.Configure(nil)
+	p := New(":0", handler.NewChain())
+	sub := p.Subscribe()
+	defer sub.Close()
+
+	ctx := testSessionContext(1)
+	p.storeSession("k", ctx)
+	if ev := <-sub.C; ev.Session.Client != "192.0.2.0:40000" {
+		t.Errorf("event client = %q", ev.Session.Client)
+	}
+	if infos := p.Sessions(); len(infos) != 1 || infos[0].Client != "192.0.2.0:40000" {
+		t.Errorf("sessions = %+v", infos)
+	}
+	if ctx.Session.ClientAddr().String() != "192.0.2.1:40000" {
+		t.Error("session address changed")
+	}
+}
+
+func TestIdleTimer(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	sub := p.Subscribe()
+	defer sub.Close()
+
+	active, idle := testSessionContext(1), testSessionContext(2)
+	active.Session.LastActivity.Store(time.Now().Unix())
+	p.storeSession("active", active)
+	p.storeSession("idle", idle)
+	<-sub.C
+	<-sub.C
+
+	// A changed timeout applies to sessions right away
+	p.SetSessionTimeout(60)
+	select {
+	case ev := <-sub.C:
+		if ev.Type != EventClose || ev.Session.ID != 2 || ev.Reason != "idle" {
+			t.Errorf("event = %+v, want idle session closed", ev)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("idle session not closed")
+	}
+	if p.SessionCount() != 1 || p.Stats().Timers == 0 {
+		t.Errorf("%d sessions, stats %+v", p.SessionCount(), p.Stats())
+	}
+	p.closeSession("active", active, handler.CloseAdminKill)
+	if active.IdleTimer.Stop() {
+		t.Error("idle timer of a closed session still pending")
+	}
+}
@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/netflow"
+)
+
+const (
+	defaultFlowActiveTimeout    = 60 * time.Second
+	defaultFlowTemplateInterval = 60 * time.Second
+	flowQueue                   = 4096            // Records waiting for export before new ones are dropped
+	flowFlushInterval           = time.Second     // Longest a record waits for others to share its message
+	protoUDP                    = 17              // IP protocol of the exported flows
+	flowBatch                   = 1 << 10         // Records encoded at once
+	flowWriteTimeout            = 1 * time.Second // Per message sent to the collector
+)
+
+// FlowExportConfig sends flow records of the relay's sessions to an IPFIX
+// or NetFlow v9 collector.
+type FlowExportConfig struct {
+	Collector        string `json:"collector"`                   // host:port of the collector (UDP)
+	Format           string `json:"format,omitempty"`            // "ipfix" (default) or "netflow9"
+	DomainID         uint32 `json:"domain_id,omitempty"`         // Observation domain (IPFIX) or source ID (NetFlow v9)
+	ActiveTimeout    int    `json:"active_timeout,omitempty"`    // Seconds between records of sessions still open (default: 60)
+	TemplateInterval int    `json:"template_interval,omitempty"` // Seconds between template resends (default: 60)
+}
+
+// FlowExportStats count the exported flow records.
+type FlowExportStats struct {
+	Records  uint64 `json:"records"`
+	Messages uint64 `json:"messages"`
+	Dropped  uint64 `json:"dropped"` // Records lost because the export queue was full
+	Errors   uint64 `json:"errors"`  // Messages the collector could not be sent
+}
+
+// flowCounters are kept by the proxy so they survive exporter reloads.
+type flowCounters struct {
+	records, messages, dropped, errors atomic.Uint64
+}
+
+// flowMark is what was exported of a session that is still open.
+type flowMark struct {
+	counters handler.SessionCounters
+	since    time.Time
+}
+
+// flowTable holds the flow marks by session ID.
+type flowTable struct {
+	mu   sync.Mutex
+	last map[uint64]flowMark
+}
+
+// flowExporter batches flow records and sends them to the collector.
+type flowExporter struct {
+	conn      *net.UDPConn
+	enc       *netflow.Encoder
+	active    time.Duration
+	templates time.Duration
+	queue     chan netflow.Record
+	counters  *flowCounters
+	done      chan struct{}
+	stopped   chan struct{}
+}
+
+// SetFlowExport configures the flow exporter (hot-reload safe). The previous
+// exporter sends what it has queued before it stops; sessions keep their
+// flow state, so no traffic is counted twice.
+func (p *Proxy) SetFlowExport(cfg *FlowExportConfig) error {
+	x, err := p.newFlowExporter(cfg)
+	if err != nil {
+		return err
+	}
+	p.startFlowExport(x)
+	return nil
+}
+
+// newFlowExporter returns an exporter for cfg that is not started yet, or nil
+// for a nil cfg. One that is never started must have its conn closed.
+func (p *Proxy) newFlowExporter(cfg *FlowExportConfig) (*flowExporter, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Collector == "" {
+		return nil, errors.New("flow_export: 'collector' is required")
+	}
+	if cfg.ActiveTimeout < 0 || cfg.TemplateInterval < 0 {
+		return nil, errors.New("flow_export: active_timeout and template_interval must be >= 0")
+	}
+	enc, err := netflow.NewEncoder(cmp.Or(cfg.Format, netflow.IPFIX), cfg.DomainID)
+	if err != nil {
+		return nil, fmt.Errorf("flow_export: %w", err)
+	}
+	addr, err := net.ResolveUDPAddr("udp", cfg.Collector)
+	if err != nil {
+		return nil, fmt.Errorf("flow_export: %w", err)
+	}
+	conn, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		return nil, fmt.Errorf("flow_export: %w", err)
+	}
+	x := &flowExporter{
+		conn:      conn,
+		enc:       enc,
+		active:    cmp.Or(time.Duration(cfg.ActiveTimeout)*time.Second, defaultFlowActiveTimeout),
+		templates: cmp.Or(time.Duration(cfg.TemplateInterval)*time.Second, defaultFlowTemplateInterval),
+		queue:     make(chan netflow.Record, flowQueue),
+		counters:  &p.flowCounters,
+		done:      make(chan struct{}),
+		stopped:   make(chan struct{}),
+	}
+	return x, nil
+}
+
+// startFlowExport starts x, which may be nil, in place of the running exporter.
+func (p *Proxy) startFlowExport(x *flowExporter) {
+	if x != nil {
+		go x.run(p.exportActiveFlows)
+	}
+	p.stopFlowExport(p.flowExport.Swap(x))
+}
+
+func (p *Proxy) stopFlowExport(x *flowExporter) {
+	if x != nil {
+		close(x.done)
+		<-x.stopped
+	}
+}
+
+// FlowExportStats returns the flow export counters.
+func (p *Proxy) FlowExportStats() FlowExportStats {
+	return FlowExportStats{
+		Records:  p.flowCounters.records.Load(),
+		Messages: p.flowCounters.messages.Load(),
+		Dropped:  p.flowCounters.dropped.Load(),
+		Errors:   p.flowCounters.errors.Load(),
+	}
+}
+
+// exportFlow exports what ctx's session forwarded since its last record: all
+// of it, as the session ends, if final is set.
+func (p *Proxy) exportFlow(ctx *handler.Context, final bool) {
+	if ctx == nil || ctx.Session == nil {
+		return
+	}
+	x := p.flowExport.Load()
+	id := ctx.Session.ID
+	if x == nil {
+		if final {
+			p.flows.mu.Lock()
+			delete(p.flows.last, id)
+			p.flows.mu.Unlock()
+		}
+		return
+	}
+
+	now := time.Now()
+	cur := ctx.Session.Counters()
+	p.flows.mu.Lock()
+	mark, ok := p.flows.last[id]
+	if !ok {
+		mark.since = ctx.Session.CreatedAt
+	}
+	if final {
+		delete(p.flows.last, id)
+	} else {
+		if p.flows.last == nil {
+			p.flows.last = make(map[uint64]flowMark)
+		}
+		p.flows.last[id] = flowMark{counters: cur, since: now}
+	}
+	p.flows.mu.Unlock()
+
+	var client, listener netip.AddrPort
+	if addr := ctx.Session.ClientAddr(); addr != nil {
+		client = addr.AddrPort()
+	}
+	if ctx.ProxyConn != nil {
+		if addr, ok := ctx.ProxyConn.LocalAddr().(*net.UDPAddr); ok {
+			listener = addr.AddrPort()
+		}
+	}
+	sni := ""
+	if ctx.Hello != nil {
+		sni = ctx.Hello.SNI
+	}
+	in := netflow.Record{
+		Src: client, Dst: listener, Protocol: protoUDP, SNI: sni, Start: mark.since, End: now,
+		Bytes: cur.BytesIn - mark.counters.BytesIn, Packets: cur.PacketsIn - mark.counters.PacketsIn,
+	}
+	out := netflow.Record{
+		Src: listener, Dst: client, Protocol: protoUDP, SNI: sni, Start: mark.since, End: now,
+		Bytes: cur.BytesOut - mark.counters.BytesOut, Packets: cur.PacketsOut - mark.counters.PacketsOut,
+	}
+	for _, r := range []netflow.Record{in, out} {
+		if r.Packets > 0 {
+			x.enqueue(r)
+		}
+	}
+}
+
+// exportActiveFlows exports the traffic of open sessions since their last
+// record.
+func (p *Proxy) exportActiveFlows() {
+	p.store.Range(func(_ string, ctx *handler.Context) bool {
+		p.exportFlow(ctx, false)
+		return true
+	})
+}
+
+// enqueue queues a record without ever blocking the packet path.
+func (x *flowExporter) enqueue(r netflow.Record) {
+	select {
+	case x.queue <- r:
+	default:
+		x.counters.dropped.Add(1)
+	}
+}
+
+// run sends queued records at least every flowFlushInterval, exports open
+// sessions every active timeout and resends the templates every template
+// interval, until the exporter is stopped.
+func (x *flowExporter) run(exportActive func()) {
+	defer close(x.stopped)
+	defer x.conn.Close()
+	flush := time.NewTicker(flowFlushInterval)
+	defer flush.Stop()
+	active := time.NewTicker(x.active)
+	defer active.Stop()
+	lastTemplates := time.Time{}
+
+	var batch []netflow.Record
+	send := func() {
+		now := time.Now()
+		templates := now.Sub(lastTemplates) >= x.templates
+		if len(batch) == 0 && !templates {
+			return
+		}
+		for _, msg := range x.enc.Encode(batch, templates, now) {
+			x.conn.SetWriteDeadline(now.Add(flowWriteTimeout))
+			if _, err := x.conn.Write(msg); err != nil {
+				x.counters.errors.Add(1)
+				logger.Debugf("flow export: %v", err)
+				continue
+			}
+			x.counters.messages.Add(1)
+		}
+		if templates {
+			lastTemplates = now
+		}
+		x.counters.records.Add(uint64(len(batch)))
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case r := <-x.queue:
+			batch = append(batch, r)
+			if len(batch) >= flowBatch {
+				send()
+			}
+		case <-flush.C:
+			send()
+		case <-active.C:
+			exportActive()
+		case <-x.done:
+			for {
+				select {
+				case r := <-x.queue:
+					batch = append(batch, r)
+				default:
+					send()
+					return
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+func TestFlowExport(t *testing.T) {
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	listener, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	p := New("127.0.0.1:0", handler.NewChain())
+	for _, cfg := range []FlowExportConfig{{}, {Collector: collector.LocalAddr().String(), Format: "sflow"}, {Collector: "x", ActiveTimeout: -1}} {
+		if err := p.SetFlowExport(&cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	if err := p.SetFlowExport(&FlowExportConfig{Collector: collector.LocalAddr().String(), DomainID: 9}); err != nil {
+		t.Fatal(err)
+	}
+
+	session := &handler.Session{ID: 1, CreatedAt: time.Now()}
+	session.SetClientAddr(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1), Port: 50000})
+	ctx := &handler.Context{Session: session, ProxyConn: listener, Hello: &handler.ClientHello{SNI: "play.example.com"}}
+	session.CountIn(1200)
+	session.CountOut(300)
+	p.exportFlow(ctx, false)
+	session.CountIn(100)
+	p.exportFlow(ctx, true)
+	if len(p.flows.last) != 0 {
+		t.Error("flow mark kept after the session ended")
+	}
+	p.SetFlowExport(nil) // Sends what is queued
+
+	// Templates, the interim records of both directions and the final
+	// client -> relay record, whose count starts after the interim one
+	var bytesIn []uint64
+	collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2000)
+	for len(bytesIn) < 3 {
+		n, err := collector.Read(buf)
+		if err != nil {
+			t.Fatalf("after %v: %v", bytesIn, err)
+		}
+		msg := buf[:n]
+		if binary.BigEndian.Uint16(msg) != 10 || binary.BigEndian.Uint32(msg[12:]) != 9 {
+			t.Fatalf("header %x", msg[:16])
+		}
+		for body := msg[16:]; len(body) >= 4; {
+			l := int(binary.BigEndian.Uint16(body[2:]))
+			if binary.BigEndian.Uint16(body) == 256 {
+				for rec := body[4:l]; len(rec) > 45; rec = rec[46+int(rec[45]):] {
+					bytesIn = append(bytesIn, binary.BigEndian.Uint64(rec[13:]))
+				}
+			}
+			body = body[l:]
+		}
+	}
+	if bytesIn[0] != 1200 || bytesIn[1] != 300 || bytesIn[2] != 100 {
+		t.Errorf("record bytes = %v, want [1200 300 100]", bytesIn)
+	}
+	if st := p.FlowExportStats(); st.Records != 3 || st.Dropped != 0 || st.Errors != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+
+	"quic-relay/internal/handler"
+)
+
+// Ingress adapter types.
+const (
+	IngressSOCKS5 = "socks5" // SOCKS5 UDP ASSOCIATE (RFC 1928)
+	IngressTCP    = "tcp"    // Datagrams framed with a 2-byte big-endian length over TCP
+)
+
+// IngressConfig configures an alternate way for clients to reach the relay
+// when UDP to the listen port is blocked. Datagrams received through an
+// adapter go through the same handler chain and session table as UDP.
+type IngressConfig struct {
+	Type     string `json:"type"`               // "socks5" or "tcp"
+	Listen   string `json:"listen"`             // TCP listen address
+	Username string `json:"username,omitempty"` // SOCKS5 username/password auth (RFC 1929)
+	Password string `json:"password,omitempty"`
+}
+
+// ingress is a running ingress adapter.
+type ingress interface {
+	Addr() net.Addr
+	Close() error
+}
+
+// SetIngress sets the ingress adapters. Must be called before Run.
+func (p *Proxy) SetIngress(cfgs []IngressConfig) error {
+	for i, cfg := range cfgs {
+		switch cfg.Type {
+		case IngressSOCKS5, IngressTCP:
+		default:
+			return fmt.Errorf("ingress %d: unknown type %q", i, cfg.Type)
+		}
+		if cfg.Listen == "" {
+			return fmt.Errorf("ingress %d: missing listen", i)
+		}
+	}
+	p.ingressCfgs = cfgs
+	return nil
+}
+
+// startIngress opens the listener of an adapter and starts serving it.
+func (p *Proxy) startIngress(cfg IngressConfig) (ingress, error) {
+	ln, err := net.Listen("tcp", cfg.Listen)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", cfg.Listen, err)
+	}
+	switch cfg.Type {
+	case IngressSOCKS5:
+		s := &socks5Ingress{proxy: p, ln: ln, username: cfg.Username, password: cfg.Password}
+		go s.serve()
+		return s, nil
+	default:
+		t := &tcpIngress{proxy: p, ln: ln}
+		go t.serve()
+		return t, nil
+	}
+}
+
+// submit queues a datagram received through an ingress adapter.
+func (p *Proxy) submit(conn handler.ClientConn, clientAddr *net.UDPAddr, packet []byte) {
+	buf := handler.GetBufferSized(len(packet))
+	copy(*buf, packet)
+	p.workerPool.Submit(WorkItem{
+		ClientAddr: clientAddr,
+		Packet:     (*buf)[:len(packet)],
+		Buffer:     buf,
+		Conn:       conn,
+	})
+}
+
+// addrPort returns the port of a UDP or TCP address, or 0.
+func addrPort(addr net.Addr) int {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.Port
+	case *net.TCPAddr:
+		return a.Port
+	}
+	return 0
+}
+
+// tcpIngress tunnels datagrams over TCP, each framed with a 2-byte length.
+// The client is identified by its TCP address.
+type tcpIngress struct {
+	proxy *Proxy
+	ln    net.Listener
+}
+
+func (t *tcpIngress) Addr() net.Addr { return t.ln.Addr() }
+func (t *tcpIngress) Close() error   { return t.ln.Close() }
+
+func (t *tcpIngress) serve() {
+	for {
+		c, err := t.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("tcp ingress accept: %v", err)
+			}
+			return
+		}
+		go t.handle(c)
+	}
+}
+
+// handle reads framed datagrams until the client disconnects.
+func (t *tcpIngress) handle(c net.Conn) {
+	defer c.Close()
+	tcpAddr := c.RemoteAddr().(*net.TCPAddr)
+	clientAddr := &net.UDPAddr{IP: tcpAddr.IP, Port: tcpAddr.Port, Zone: tcpAddr.Zone}
+	conn := &tunnelConn{c: c}
+
+	buf := make([]byte, handler.LargeBufferSize)
+	for {
+		var hdr [2]byte
+		if _, err := io.ReadFull(c, hdr[:]); err != nil {
+			return
+		}
+		n := int(binary.BigEndian.Uint16(hdr[:]))
+		if n == 0 {
+			continue
+		}
+		if _, err := io.ReadFull(c, buf[:n]); err != nil {
+			return
+		}
+		t.proxy.submit(conn, clientAddr, buf[:n])
+	}
+}
+
+// tunnelConn writes datagrams back to a TCP tunnel client.
+type tunnelConn struct {
+	c  net.Conn
+	mu sync.Mutex // Frames from several sessions must not interleave
+}
+
+func (t *tunnelConn) WriteToUDP(b []byte, _ *net.UDPAddr) (int, error) {
+	if len(b) > 0xffff {
+		return 0, errors.New("datagram too large for tunnel")
+	}
+	frame := make([]byte, 2+len(b))
+	binary.BigEndian.PutUint16(frame, uint16(len(b)))
+	copy(frame[2:], b)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, err := t.c.Write(frame); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (t *tunnelConn) LocalAddr() net.Addr { return t.c.LocalAddr() }
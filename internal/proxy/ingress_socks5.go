@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/privacy"
+)
+
+// SOCKS5 protocol constants (RFC 1928, RFC 1929).
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5AuthNoAccept = 0xff
+	socks5CmdAssociate = 0x03
+	socks5AtypIPv4     = 0x01
+	socks5AtypDomain   = 0x03
+	socks5AtypIPv6     = 0x04
+
+	socks5ReplySucceeded      = 0x00
+	socks5ReplyCmdUnsupported = 0x07
+
+	socks5HandshakeTimeout = 10 * time.Second
+)
+
+// socks5Ingress accepts SOCKS5 UDP ASSOCIATE requests. Each association gets
+// its own UDP socket; the association ends when the control connection closes.
+// Only UDP ASSOCIATE is supported: the relay is not a general purpose proxy.
+type socks5Ingress struct {
+	proxy    *Proxy
+	ln       net.Listener
+	username string
+	password string
+}
+
+func (s *socks5Ingress) Addr() net.Addr { return s.ln.Addr() }
+func (s *socks5Ingress) Close() error   { return s.ln.Close() }
+
+func (s *socks5Ingress) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				logger.Errorf("socks5 ingress accept: %v", err)
+			}
+			return
+		}
+		go func() {
+			if err := s.handle(c); err != nil {
+				logger.Printf("socks5 ingress %s: %v", privacy.Addr(c.RemoteAddr()), err)
+			}
+		}()
+	}
+}
+
+// handle runs the control connection of one client.
+func (s *socks5Ingress) handle(c net.Conn) error {
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(socks5HandshakeTimeout))
+
+	if err := s.negotiateAuth(c); err != nil {
+		return err
+	}
+
+	// Request: VER CMD RSV ATYP DST.ADDR DST.PORT
+	var req [3]byte
+	if _, err := io.ReadFull(c, req[:]); err != nil {
+		return err
+	}
+	if req[0] != socks5Version {
+		return fmt.Errorf("bad version %d", req[0])
+	}
+	if _, err := readSOCKS5Addr(c); err != nil {
+		return err
+	}
+	if req[1] != socks5CmdAssociate {
+		writeSOCKS5Reply(c, socks5ReplyCmdUnsupported, &net.UDPAddr{IP: net.IPv4zero})
+		return fmt.Errorf("unsupported command %d", req[1])
+	}
+
+	// Bind the association on the address the client reached us on
+	local := c.LocalAddr().(*net.TCPAddr)
+	udp, err := net.ListenUDP("udp", &net.UDPAddr{IP: local.IP, Zone: local.Zone})
+	if err != nil {
+		return err
+	}
+	defer udp.Close()
+
+	if err := writeSOCKS5Reply(c, socks5ReplySucceeded, udp.LocalAddr().(*net.UDPAddr)); err != nil {
+		return err
+	}
+	c.SetDeadline(time.Time{})
+
+	clientIP := c.RemoteAddr().(*net.TCPAddr).IP
+	go s.relay(udp, clientIP)
+
+	// The association lives as long as the control connection
+	io.Copy(io.Discard, c)
+	return nil
+}
+
+// negotiateAuth performs method selection and optional username/password auth.
+func (s *socks5Ingress) negotiateAuth(c net.Conn) error {
+	var hdr [2]byte
+	if _, err := io.ReadFull(c, hdr[:]); err != nil {
+		return err
+	}
+	if hdr[0] != socks5Version {
+		return fmt.Errorf("bad version %d", hdr[0])
+	}
+	methods := make([]byte, hdr[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return err
+	}
+
+	want := byte(socks5AuthNone)
+	if s.username != "" {
+		want = socks5AuthPassword
+	}
+	offered := false
+	for _, m := range methods {
+		offered = offered || m == want
+	}
+	if !offered {
+		c.Write([]byte{socks5Version, socks5AuthNoAccept})
+		return errors.New("no acceptable auth method")
+	}
+	if _, err := c.Write([]byte{socks5Version, want}); err != nil {
+		return err
+	}
+	if want == socks5AuthNone {
+		return nil
+	}
+
+	// RFC 1929: VER ULEN UNAME PLEN PASSWD
+	var ver [2]byte
+	if _, err := io.ReadFull(c, ver[:]); err != nil {
+		return err
+	}
+	user := make([]byte, ver[1])
+	if _, err := io.ReadFull(c, user); err != nil {
+		return err
+	}
+	var plen [1]byte
+	if _, err := io.ReadFull(c, plen[:]); err != nil {
+		return err
+	}
+	pass := make([]byte, plen[0])
+	if _, err := io.ReadFull(c, pass); err != nil {
+		return err
+	}
+	userOK := subtle.ConstantTimeCompare(user, []byte(s.username)) == 1
+	passOK := subtle.ConstantTimeCompare(pass, []byte(s.password)) == 1
+	if !userOK || !passOK {
+		c.Write([]byte{0x01, 0x01})
+		return errors.New("authentication failed")
+	}
+	_, err := c.Write([]byte{0x01, 0x00})
+	return err
+}
+
+// relay reads encapsulated datagrams from the association socket.
+func (s *socks5Ingress) relay(udp *net.UDPConn, clientIP net.IP) {
+	conn := &socks5Conn{udp: udp}
+	buf := make([]byte, handler.LargeBufferSize)
+	for {
+		n, from, err := udp.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		if !from.IP.Equal(clientIP) {
+			continue // Only the client that opened the association may use it
+		}
+
+		// RSV(2) FRAG(1) ATYP DST.ADDR DST.PORT DATA
+		if n < 4 || buf[2] != 0 {
+			continue // Fragmentation is not supported
+		}
+		hdrLen := socks5HeaderLen(buf[:n])
+		if hdrLen <= 0 || hdrLen >= n {
+			continue
+		}
+		conn.setHeader(buf[:hdrLen])
+		s.proxy.submit(conn, from, buf[hdrLen:n])
+	}
+}
+
+// socks5Conn writes datagrams back to a SOCKS5 client, prefixed with the
+// header of the client's last datagram so they appear to come from its destination.
+type socks5Conn struct {
+	udp    *net.UDPConn
+	mu     sync.RWMutex
+	header []byte
+}
+
+func (c *socks5Conn) setHeader(h []byte) {
+	c.mu.Lock()
+	if len(c.header) != len(h) || string(c.header) != string(h) {
+		c.header = append(c.header[:0], h...)
+	}
+	c.mu.Unlock()
+}
+
+func (c *socks5Conn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.mu.RLock()
+	pkt := make([]byte, 0, len(c.header)+len(b))
+	pkt = append(pkt, c.header...)
+	c.mu.RUnlock()
+	pkt = append(pkt, b...)
+	if _, err := c.udp.WriteToUDP(pkt, addr); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+func (c *socks5Conn) LocalAddr() net.Addr { return c.udp.LocalAddr() }
+
+// readSOCKS5Addr reads ATYP DST.ADDR DST.PORT from r.
+func readSOCKS5Addr(r io.Reader) ([]byte, error) {
+	var atyp [1]byte
+	if _, err := io.ReadFull(r, atyp[:]); err != nil {
+		return nil, err
+	}
+	var n int
+	switch atyp[0] {
+	case socks5AtypIPv4:
+		n = 4
+	case socks5AtypIPv6:
+		n = 16
+	case socks5AtypDomain:
+		var l [1]byte
+		if _, err := io.ReadFull(r, l[:]); err != nil {
+			return nil, err
+		}
+		n = int(l[0])
+	default:
+		return nil, fmt.Errorf("bad address type %d", atyp[0])
+	}
+	addr := make([]byte, n+2)
+	_, err := io.ReadFull(r, addr)
+	return addr, err
+}
+
+// socks5HeaderLen returns the length of a UDP request header, or -1 if malformed.
+func socks5HeaderLen(pkt []byte) int {
+	switch pkt[3] {
+	case socks5AtypIPv4:
+		return 4 + 4 + 2
+	case socks5AtypIPv6:
+		return 4 + 16 + 2
+	case socks5AtypDomain:
+		if len(pkt) < 5 {
+			return -1
+		}
+		return 4 + 1 + int(pkt[4]) + 2
+	}
+	return -1
+}
+
+// writeSOCKS5Reply writes VER REP RSV ATYP BND.ADDR BND.PORT.
+func writeSOCKS5Reply(w io.Writer, rep byte, bind *net.UDPAddr) error {
+	reply := []byte{socks5Version, rep, 0x00}
+	if ip4 := bind.IP.To4(); ip4 != nil {
+		reply = append(reply, socks5AtypIPv4)
+		reply = append(reply, ip4...)
+	} else {
+		reply = append(reply, socks5AtypIPv6)
+		reply = append(reply, bind.IP.To16()...)
+	}
+	reply = binary.BigEndian.AppendUint16(reply, uint16(bind.Port))
+	_, err := w.Write(reply)
+	return err
+}
@@ -0,0 +1,173 @@
+package proxy
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+// newEchoProxy returns a proxy with a running worker pool that relays
+// datagrams starting with "echo" to a UDP echo backend.
+func newEchoProxy(t *testing.T) *Proxy {
+	t.Helper()
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteToUDP(append([]byte("re:"), buf[:n]...), addr)
+		}
+	}()
+
+	router, err := handler.NewProtocolRouterHandler(json.RawMessage(`{"routes": {"echo": "` + backend.LocalAddr().String() + `"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd, _ := handler.NewForwarderHandler(nil)
+
+	p := New("127.0.0.1:0", handler.NewChain(router, fwd))
+	if err := p.SetProtocols([]ProtocolRule{{Name: "echo", Prefix: "6563686f"}}); err != nil {
+		t.Fatal(err)
+	}
+	p.workerPool = newItemWorkerPool(1, 100, p.processItem)
+	p.workerPool.Start()
+	t.Cleanup(p.Stop)
+	return p
+}
+
+func TestSetIngress_Errors(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	if err := p.SetIngress([]IngressConfig{{Type: "http", Listen: ":0"}}); err == nil || !strings.Contains(err.Error(), "unknown type") {
+		t.Errorf("error = %v", err)
+	}
+	if err := p.SetIngress([]IngressConfig{{Type: "tcp"}}); err == nil || !strings.Contains(err.Error(), "missing listen") {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestTCPIngress(t *testing.T) {
+	p := newEchoProxy(t)
+	in, err := p.startIngress(IngressConfig{Type: IngressTCP, Listen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	c, err := net.Dial("tcp", in.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	for _, msg := range []string{"echo a", "echo b"} {
+		frame := binary.BigEndian.AppendUint16(nil, uint16(len(msg)))
+		if _, err := c.Write(append(frame, msg...)); err != nil {
+			t.Fatal(err)
+		}
+		var hdr [2]byte
+		if _, err := io.ReadFull(c, hdr[:]); err != nil {
+			t.Fatal(err)
+		}
+		reply := make([]byte, binary.BigEndian.Uint16(hdr[:]))
+		if _, err := io.ReadFull(c, reply); err != nil {
+			t.Fatal(err)
+		}
+		if string(reply) != "re:"+msg {
+			t.Errorf("reply = %q", reply)
+		}
+	}
+}
+
+func TestSOCKS5Ingress(t *testing.T) {
+	p := newEchoProxy(t)
+	in, err := p.startIngress(IngressConfig{Type: IngressSOCKS5, Listen: "127.0.0.1:0", Username: "u", Password: "p"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	c, err := net.Dial("tcp", in.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	// Method selection and username/password auth
+	c.Write([]byte{5, 1, socks5AuthPassword})
+	resp := make([]byte, 2)
+	if _, err := io.ReadFull(c, resp); err != nil || resp[1] != socks5AuthPassword {
+		t.Fatalf("method selection: %v %x", err, resp)
+	}
+	c.Write([]byte{1, 1, 'u', 1, 'p'})
+	if _, err := io.ReadFull(c, resp); err != nil || resp[1] != 0 {
+		t.Fatalf("auth: %v %x", err, resp)
+	}
+
+	// UDP ASSOCIATE
+	c.Write([]byte{5, socks5CmdAssociate, 0, socks5AtypIPv4, 0, 0, 0, 0, 0, 0})
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(c, reply); err != nil || reply[1] != socks5ReplySucceeded {
+		t.Fatalf("associate: %v %x", err, reply)
+	}
+	bind := &net.UDPAddr{IP: net.IP(reply[4:8]), Port: int(binary.BigEndian.Uint16(reply[8:10]))}
+
+	udp, err := net.DialUDP("udp", nil, bind)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer udp.Close()
+	udp.SetDeadline(time.Now().Add(2 * time.Second))
+
+	header := []byte{0, 0, 0, socks5AtypIPv4, 203, 0, 113, 9, 0x15, 0x90}
+	if _, err := udp.Write(append(append([]byte{}, header...), "echo hi"...)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, err := udp.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := string(buf[:n]); got != string(header)+"re:echo hi" {
+		t.Errorf("reply = %q", got)
+	}
+}
+
+func TestSOCKS5Ingress_RejectsConnect(t *testing.T) {
+	p := newEchoProxy(t)
+	in, err := p.startIngress(IngressConfig{Type: IngressSOCKS5, Listen: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer in.Close()
+
+	c, err := net.Dial("tcp", in.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	c.SetDeadline(time.Now().Add(2 * time.Second))
+
+	c.Write([]byte{5, 1, socks5AuthNone})
+	resp := make([]byte, 2)
+	io.ReadFull(c, resp)
+	c.Write([]byte{5, 0x01, 0, socks5AtypIPv4, 10, 0, 0, 1, 0, 80}) // CONNECT
+	reply := make([]byte, 10)
+	if _, err := io.ReadFull(c, reply); err != nil || reply[1] != socks5ReplyCmdUnsupported {
+		t.Errorf("expected command unsupported, got %v %x", err, reply)
+	}
+}
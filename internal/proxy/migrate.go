@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/privacy"
+)
+
+// ErrSessionNotFound is returned by session operations for an unknown session ID.
+var ErrSessionNotFound = errors.New("session not found")
+
+// sessionByID returns the key and context of the session with the given ID.
+func (p *Proxy) sessionByID(id uint64) (string, *handler.Context, bool) {
+	var (
+		key   string
+		found *handler.Context
+	)
+	p.store.Range(func(k string, ctx *handler.Context) bool {
+		if ctx.Session == nil || ctx.Session.ID != id {
+			return true
+		}
+		key, found = k, ctx
+		return false
+	})
+	return key, found, found != nil
+}
+
+// PauseSession holds back the client packets of a session, e.g. while its game
+// server is being moved. Backend packets are still forwarded.
+func (p *Proxy) PauseSession(id uint64) error {
+	_, ctx, ok := p.sessionByID(id)
+	if !ok {
+		return ErrSessionNotFound
+	}
+	if !ctx.Session.Pause() {
+		return errors.New("session already paused")
+	}
+	logger.Printf("session %d paused (admin)", id)
+	return nil
+}
+
+// ResumeSession sends the held client packets of a paused session to its
+// current backend and forwards again. Returns how many packets were held.
+func (p *Proxy) ResumeSession(id uint64) (int, error) {
+	_, ctx, ok := p.sessionByID(id)
+	if !ok {
+		return 0, ErrSessionNotFound
+	}
+	held, ok := ctx.Session.Resume()
+	if !ok {
+		return 0, errors.New("session not paused")
+	}
+	logger.Printf("session %d resumed (admin), %d held packets sent", id, held)
+	return held, nil
+}
+
+// MoveSession sends the client packets of a session to a new backend address
+// and returns the previous one. The backend must be able to take over the
+// connection, e.g. a game server migrated with its QUIC state; the relay only
+// changes where packets go.
+func (p *Proxy) MoveSession(id uint64, backend string) (string, error) {
+	_, ctx, ok := p.sessionByID(id)
+	if !ok {
+		return "", ErrSessionNotFound
+	}
+	addr, err := net.ResolveUDPAddr("udp", backend)
+	if err != nil {
+		return "", err
+	}
+	old := ctx.GetString("backend")
+	if err := ctx.Session.MoveBackend(addr); err != nil {
+		return "", err
+	}
+	ctx.Set("backend", addr.String())
+	logger.Printf("session %d moved %s -> %s (admin)", id, old, addr)
+	return old, nil
+}
+
+// ExportSession returns a session in the snapshot format, for a migration hook
+// or ImportSession on another relay.
+func (p *Proxy) ExportSession(id uint64) ([]byte, error) {
+	_, ctx, ok := p.sessionByID(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	s, ok := p.snapshotSession(ctx)
+	if !ok {
+		return nil, errors.New("session cannot be exported")
+	}
+	return json.Marshal(s)
+}
+
+// ImportSession re-establishes a session exported by ExportSession, like a
+// session restored from a snapshot: it waits for its client to confirm it.
+// Returns the session ID.
+func (p *Proxy) ImportSession(data []byte) (uint64, error) {
+	var s sessionSnapshot
+	if err := json.Unmarshal(data, &s); err != nil {
+		return 0, fmt.Errorf("invalid session: %w", err)
+	}
+	if _, _, ok := p.sessionByID(s.ID); ok {
+		return 0, fmt.Errorf("session %d already exists", s.ID)
+	}
+	if s.Protocol == "" {
+		if _, ok := p.store.Load(string(s.DCID)); ok {
+			return 0, errors.New("session with this DCID already exists")
+		}
+	}
+	if err := p.restoreSession(s); err != nil {
+		return 0, err
+	}
+	logger.Printf("session %d imported (admin), waiting for %s", s.ID, privacy.String(s.Client))
+	return s.ID, nil
+}
@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"errors"
+	"net"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+func TestSessionOperations(t *testing.T) {
+	p := New("127.0.0.1:0", handler.NewChain())
+	client := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 40000}
+	ctx := addTestSession(p, client, []byte("dcid-aaa"))
+	ctx.Session.ID = 7
+
+	if err := p.PauseSession(8); !errors.Is(err, ErrSessionNotFound) {
+		t.Errorf("PauseSession(unknown) = %v, want ErrSessionNotFound", err)
+	}
+	if _, err := p.ResumeSession(7); err == nil {
+		t.Error("ResumeSession of an active session should fail")
+	}
+	if err := p.PauseSession(7); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.PauseSession(7); err == nil {
+		t.Error("second PauseSession should fail")
+	}
+	if infos := p.Sessions(); len(infos) != 1 || !infos[0].Paused {
+		t.Errorf("sessions = %+v, want one paused session", infos)
+	}
+	if _, err := p.ResumeSession(7); err != nil {
+		t.Fatal(err)
+	}
+
+	// Test sessions have no forwarder socket to redirect
+	if _, err := p.MoveSession(7, "10.0.0.7:5520"); !errors.Is(err, handler.ErrBackendPinned) {
+		t.Errorf("MoveSession = %v, want ErrBackendPinned", err)
+	}
+	if _, err := p.MoveSession(7, "not an address"); err == nil {
+		t.Error("MoveSession with invalid address should fail")
+	}
+}
+
+func TestImportSession_Invalid(t *testing.T) {
+	p := New("127.0.0.1:0", handler.NewChain())
+	client := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 40000}
+	addTestSession(p, client, []byte("dcid-aaa")).Session.ID = 7
+
+	for _, data := range []string{
+		`not json`,
+		`{"id": 7, "dcid": "ZGNpZC1iYmI=", "client": "198.51.100.2:40000", "backend": "10.0.0.1:5520"}`,
+		`{"id": 8, "dcid": "ZGNpZC1hYWE=", "client": "198.51.100.2:40000", "backend": "10.0.0.1:5520"}`,
+		`{"id": 9, "dcid": "ZGNpZC1jY2M=", "client": "198.51.100.2:40000", "listener": "127.0.0.1:1", "backend": "10.0.0.1:5520"}`,
+	} {
+		if _, err := p.ImportSession([]byte(data)); err == nil {
+			t.Errorf("ImportSession(%s) should fail", data)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/binary"
@@ -199,70 +200,76 @@ func ExtractAllSCIDs(datagram []byte) [][]byte {
 	var scids [][]byte
 	seen := make(map[string]bool)
 
-	offset := 0
-	for offset < len(datagram) {
-		if offset+1 > len(datagram) {
-			break
+	for _, pkt := range CoalescedPackets(datagram) {
+		if pkt[0]&0x80 == 0 {
+			break // Short Header - no more Long Header packets possible
 		}
-
-		// Check if Long Header (first bit = 1)
-		if datagram[offset]&0x80 == 0 {
-			// Short Header - no more Long Header packets possible
-			break
-		}
-
-		// Parse Long Header to extract SCID and find packet length
-		pkt := datagram[offset:]
-		if len(pkt) < 6 {
-			break
-		}
-
-		// Skip header byte (1) + version (4)
-		headerOffset := 5
-		dcidLen := int(pkt[headerOffset])
-		headerOffset++
-		headerOffset += dcidLen
-
-		if headerOffset >= len(pkt) {
-			break
+		scid, err := ExtractSCID(pkt)
+		if err != nil || len(scid) == 0 || seen[string(scid)] {
+			continue
 		}
+		seen[string(scid)] = true
+		scidCopy := make([]byte, len(scid))
+		copy(scidCopy, scid)
+		scids = append(scids, scidCopy)
+	}
 
-		scidLen := int(pkt[headerOffset])
-		headerOffset++
+	return scids
+}
 
-		if headerOffset+scidLen > len(pkt) {
+// CoalescedPackets splits a datagram into the QUIC packets coalesced in it
+// (RFC 9000 Section 12.2). Long Header packets end where their Length field
+// says; a Short Header, Retry or Version Negotiation packet takes the rest of
+// the datagram. Splitting stops at the first malformed header.
+func CoalescedPackets(datagram []byte) [][]byte {
+	var packets [][]byte
+	for len(datagram) > 0 {
+		n := packetLength(datagram)
+		if n == 0 {
 			break
 		}
+		packets = append(packets, datagram[:n])
+		datagram = datagram[n:]
+	}
+	return packets
+}
 
-		scid := pkt[headerOffset : headerOffset+scidLen]
-		scidKey := string(scid)
-		if !seen[scidKey] && len(scid) > 0 {
-			seen[scidKey] = true
-			scidCopy := make([]byte, len(scid))
-			copy(scidCopy, scid)
-			scids = append(scids, scidCopy)
-		}
-		headerOffset += scidLen
+// packetLength returns the length of the first packet in datagram, or 0 if
+// its header is malformed.
+func packetLength(datagram []byte) int {
+	if datagram[0]&0x80 == 0 {
+		return len(datagram) // Short Header: no length field
+	}
+	if len(datagram) < 7 {
+		return 0
+	}
+	pktType := ClassifyPacket(datagram)
+	if binary.BigEndian.Uint32(datagram[1:5]) == 0 || pktType == PacketRetry {
+		return len(datagram) // Version Negotiation and Retry have no length field
+	}
 
-		// Read variable-length packet length field
-		if headerOffset >= len(pkt) {
-			break
-		}
-		pktLen, lenBytes, err := readVarInt(pkt[headerOffset:])
-		if err != nil || lenBytes == 0 {
-			break
-		}
-		headerOffset += lenBytes
+	offset := 6 + int(datagram[5]) // DCID
+	if offset >= len(datagram) {
+		return 0
+	}
+	offset += 1 + int(datagram[offset]) // SCID
+	if offset >= len(datagram) {
+		return 0
+	}
 
-		// Move to next packet (header + payload length)
-		nextOffset := headerOffset + int(pktLen)
-		if nextOffset <= 0 || nextOffset > len(pkt) {
-			break // Invalid length or end of datagram
+	if pktType == PacketInitial {
+		tokenLen, n, err := readVarInt(datagram[offset:])
+		if err != nil || tokenLen >= uint64(len(datagram)-offset-n) {
+			return 0
 		}
-		offset += nextOffset
+		offset += n + int(tokenLen)
 	}
 
-	return scids
+	length, n, err := readVarInt(datagram[offset:])
+	if err != nil || length > uint64(len(datagram)-offset-n) {
+		return 0
+	}
+	return offset + n + int(length)
 }
 
 // PacketTypeError is returned when a packet is not the expected type
@@ -282,9 +289,43 @@ var quicV1InitialSalt = []byte{
 	0xcc, 0xbb, 0x7f, 0x0a,
 }
 
-// ExtractCryptoFramesFromPacket decrypts an Initial packet and extracts CRYPTO frames
+// ExtractCryptoFramesFromPacket decrypts an Initial datagram and extracts CRYPTO frames
 // This is the main entry point for CRYPTO reassembly
-func ExtractCryptoFramesFromPacket(packet []byte) ([]CryptoFrame, error) {
+// Initial packets coalesced after the first one are included when they belong
+// to the same connection; 0-RTT and Handshake packets are skipped.
+func ExtractCryptoFramesFromPacket(datagram []byte) ([]CryptoFrame, error) {
+	frames, err := extractInitialCryptoFrames(datagram)
+	if err != nil {
+		return nil, err
+	}
+
+	packets := CoalescedPackets(datagram)
+	if len(packets) < 2 {
+		return frames, nil
+	}
+	// Receivers ignore coalesced packets for another connection (RFC 9000 Section 12.2)
+	dcid, _ := ExtractDCID(datagram, 0)
+	version := binary.BigEndian.Uint32(datagram[1:5])
+	for _, pkt := range packets[1:] {
+		if ClassifyPacket(pkt) != PacketInitial || binary.BigEndian.Uint32(pkt[1:5]) != version {
+			continue
+		}
+		if pktDCID, err := ExtractDCID(pkt, 0); err != nil || !bytes.Equal(pktDCID, dcid) {
+			continue
+		}
+		more, err := extractInitialCryptoFrames(pkt)
+		if err != nil {
+			debug.Notef(" coalesced Initial skipped: %v", err)
+			continue
+		}
+		frames = append(frames, more...)
+	}
+	return frames, nil
+}
+
+// extractInitialCryptoFrames decrypts the first packet of datagram, which
+// must be an Initial, and extracts its CRYPTO frames.
+func extractInitialCryptoFrames(packet []byte) ([]CryptoFrame, error) {
 	if len(packet) < 5 {
 		return nil, errors.New("packet too short")
 	}
@@ -323,6 +364,9 @@ func ExtractCryptoFramesFromPacket(packet []byte) ([]CryptoFrame, error) {
 	}
 	scidLen := int(packet[offset])
 	offset++
+	if offset+scidLen > len(packet) {
+		return nil, errors.New("packet too short for SCID")
+	}
 	offset += scidLen
 
 	// Token Length
@@ -331,6 +375,9 @@ func ExtractCryptoFramesFromPacket(packet []byte) ([]CryptoFrame, error) {
 		return nil, fmt.Errorf("failed to read token length: %w", err)
 	}
 	offset += n
+	if tokenLen > uint64(len(packet)-offset) {
+		return nil, errors.New("packet too short for token")
+	}
 	offset += int(tokenLen)
 
 	// Payload Length
@@ -340,10 +387,12 @@ func ExtractCryptoFramesFromPacket(packet []byte) ([]CryptoFrame, error) {
 	}
 	offset += n
 
-	if offset+int(payloadLen) > len(packet) {
+	if payloadLen > uint64(len(packet)-offset) {
 		return nil, errors.New("packet too short for payload")
 	}
-	encrypted := packet[offset : offset+int(payloadLen)]
+	// Coalesced packets after this one are not part of it
+	packet = packet[:offset+int(payloadLen)]
+	encrypted := packet[offset:]
 
 	// Derive keys and decrypt
 	clientKey, clientIV, clientHP, err := deriveInitialKeys(dcid)
@@ -362,13 +411,24 @@ func ExtractCryptoFramesFromPacket(packet []byte) ([]CryptoFrame, error) {
 
 // deriveInitialKeys derives the client initial keys from DCID.
 func deriveInitialKeys(dcid []byte) (key, iv, hp []byte, err error) {
+	return deriveInitialKeysFor(dcid, "client in")
+}
+
+// deriveServerInitialKeys derives the server initial keys from the client's original DCID.
+// Used to build Initial packets sent to the client on behalf of the server.
+func deriveServerInitialKeys(dcid []byte) (key, iv, hp []byte, err error) {
+	return deriveInitialKeysFor(dcid, "server in")
+}
+
+// deriveInitialKeysFor derives initial keys for one side ("client in" or "server in").
+func deriveInitialKeysFor(dcid []byte, label string) (key, iv, hp []byte, err error) {
 	// Step 1: Extract initial secret
 	// initial_secret = HKDF-Extract(initial_salt, DCID)
 	initialSecret := hkdf.Extract(sha256.New, dcid, quicV1InitialSalt)
 
-	// Step 2: Derive client initial secret
+	// Step 2: Derive client/server initial secret
 	// client_initial_secret = HKDF-Expand-Label(initial_secret, "client in", "", 32)
-	clientSecret, err := hkdfExpandLabel(initialSecret, "client in", nil, 32)
+	clientSecret, err := hkdfExpandLabel(initialSecret, label, nil, 32)
 	if err != nil {
 		return nil, nil, nil, err
 	}
@@ -474,7 +534,7 @@ func DecryptWithCachedCrypto(packet, encrypted []byte,
 	hpCipher.Encrypt(mask[:], sample)
 
 	// Get buffer from pool for packet copy (avoids allocation)
-	bufPtr := handler.GetBuffer()
+	bufPtr := handler.GetBufferSized(len(packet))
 	defer handler.PutBuffer(bufPtr)
 	packetCopy := (*bufPtr)[:len(packet)]
 	copy(packetCopy, packet)
@@ -633,8 +693,8 @@ func extractCryptoFrames(data []byte) []CryptoFrame {
 			offset += n
 
 			// Crypto data
-			if offset+int(length) > len(data) {
-				break
+			if length > uint64(len(data)-offset) {
+				return frames
 			}
 
 			frameData := make([]byte, length)
@@ -774,7 +834,7 @@ func parseTLSClientHello(data []byte) (*handler.ClientHello, error) {
 		debug.Printf(" extension type=0x%04x len=%d", extType, extLen)
 
 		if offset+extLen > len(data) {
-			debug.Printf(" extension truncated: offset=%d extLen=%d dataLen=%d", offset, extLen, len(data))
+			debug.Notef(" extension truncated: offset=%d extLen=%d dataLen=%d", offset, extLen, len(data))
 			break
 		}
 
@@ -1,10 +1,12 @@
 package proxy
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"encoding/hex"
 	"testing"
 
+	"github.com/quic-go/quic-go/quicvarint"
 	"golang.org/x/crypto/hkdf"
 )
 
@@ -95,3 +97,193 @@ func TestClassifyPacket(t *testing.T) {
 		})
 	}
 }
+
+// testLongHeader returns a long header packet of the given first byte with a
+// payload of n bytes. Initial packets get an empty token.
+func testLongHeader(first byte, dcid, scid []byte, n int) []byte {
+	pkt := []byte{first, 0x00, 0x00, 0x00, 0x01, byte(len(dcid))}
+	pkt = append(pkt, dcid...)
+	pkt = append(pkt, byte(len(scid)))
+	pkt = append(pkt, scid...)
+	if ClassifyPacket(pkt) == PacketInitial {
+		pkt = appendVarInt(pkt, 0)
+	}
+	pkt = appendVarInt2(pkt, uint64(n))
+	return append(pkt, make([]byte, n)...)
+}
+
+func TestCoalescedPackets(t *testing.T) {
+	dcid := []byte{1, 2, 3, 4}
+	initial := testLongHeader(0xC0, dcid, nil, 30)
+	zeroRTT := testLongHeader(0xD0, dcid, nil, 25)
+	handshake := testLongHeader(0xE0, dcid, nil, 20)
+	short := append([]byte{0x40}, dcid...)
+	truncated := testLongHeader(0xE0, dcid, nil, 20)[:15]
+
+	concat := func(pkts ...[]byte) []byte { return bytes.Join(pkts, nil) }
+	tests := []struct {
+		name     string
+		datagram []byte
+		want     [][]byte
+	}{
+		{"initial", initial, [][]byte{initial}},
+		{"initial and 0-rtt", concat(initial, zeroRTT), [][]byte{initial, zeroRTT}},
+		{"all levels", concat(initial, handshake, short), [][]byte{initial, handshake, short}},
+		{"short header takes the rest", concat(short, initial), [][]byte{concat(short, initial)}},
+		{"truncated", concat(initial, truncated), [][]byte{initial}},
+		{"retry", []byte{0xF0, 0, 0, 0, 1, 0, 0, 9, 9}, [][]byte{{0xF0, 0, 0, 0, 1, 0, 0, 9, 9}}},
+		{"too short", []byte{0xC0, 0, 0}, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := CoalescedPackets(tt.datagram)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d packets, want %d", len(got), len(tt.want))
+			}
+			for i := range got {
+				if !bytes.Equal(got[i], tt.want[i]) {
+					t.Errorf("packet %d = %x, want %x", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestExtractAllSCIDs_CoalescedInitial(t *testing.T) {
+	clientDCID := []byte{0x83, 0x94, 0xc8, 0xf0, 0x3e, 0x51, 0x57, 0x08}
+	clientSCID := []byte{0xaa, 0xbb}
+	initial, err := BuildInitialConnectionClose(testClientInitialHeader(clientDCID, clientSCID), 0, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSCID, _ := ExtractSCID(initial)
+	handshakeSCID := []byte{0x01, 0x02, 0x03, 0x04}
+
+	// The Initial's token length must not be mistaken for its length
+	scids := ExtractAllSCIDs(append(initial, testLongHeader(0xE0, clientSCID, handshakeSCID, 20)...))
+	if len(scids) != 2 || !bytes.Equal(scids[0], serverSCID) || !bytes.Equal(scids[1], handshakeSCID) {
+		t.Errorf("scids = %x, want [%x %x]", scids, serverSCID, handshakeSCID)
+	}
+}
+
+func TestExtractCryptoFramesFromPacket_Coalesced(t *testing.T) {
+	initials := clientInitials(t)
+	first := initials[0]
+	alone, err := ExtractCryptoFramesFromPacket(first)
+	if err != nil || len(alone) == 0 {
+		t.Fatalf("frames = %d, err = %v", len(alone), err)
+	}
+	dcid, _ := ExtractDCID(first, 0)
+
+	// A 0-RTT packet coalesced after the Initial must not break decryption
+	datagram := append(append([]byte(nil), first...), testLongHeader(0xD0, dcid, nil, 40)...)
+	frames, err := ExtractCryptoFramesFromPacket(datagram)
+	if err != nil || len(frames) != len(alone) {
+		t.Errorf("with 0-RTT: frames = %d, err = %v; want %d", len(frames), err, len(alone))
+	}
+
+	// Initials of the same connection contribute their CRYPTO frames
+	var all []byte
+	want := 0
+	for _, d := range initials {
+		if f, err := ExtractCryptoFramesFromPacket(d); err == nil {
+			want += len(f)
+			all = append(all, d...)
+		}
+	}
+	frames, err = ExtractCryptoFramesFromPacket(all)
+	if err != nil || len(frames) != want {
+		t.Errorf("coalesced Initials: frames = %d, err = %v; want %d", len(frames), err, want)
+	}
+
+	// Initials for another connection are ignored
+	other := append([]byte(nil), first...)
+	other[6] ^= 0xff
+	frames, err = ExtractCryptoFramesFromPacket(append(append([]byte(nil), first...), other...))
+	if err != nil || len(frames) != len(alone) {
+		t.Errorf("foreign DCID: frames = %d, err = %v; want %d", len(frames), err, len(alone))
+	}
+}
+
+// cryptoSeeds returns real client Initials and the ClientHello they carry.
+func cryptoSeeds(t testing.TB) (initials [][]byte, hello []byte) {
+	initials = clientInitials(t)
+	var frames []CryptoFrame
+	for _, d := range initials {
+		f, err := ExtractCryptoFramesFromPacket(d)
+		if err != nil {
+			t.Fatalf("seed Initial: %v", err)
+		}
+		frames = append(frames, f...)
+	}
+	return initials, reassembleCryptoData(frames)
+}
+
+// cryptoFrame encodes data as a CRYPTO frame at offset.
+func cryptoFrame(offset uint64, data []byte) []byte {
+	frame := quicvarint.Append([]byte{0x06}, offset)
+	frame = quicvarint.Append(frame, uint64(len(data)))
+	return append(frame, data...)
+}
+
+// FuzzInitial feeds datagrams through everything the proxy does with an
+// unknown packet before any handler sees it.
+func FuzzInitial(f *testing.F) {
+	initials, _ := cryptoSeeds(f)
+	for _, d := range initials {
+		f.Add(d)
+	}
+	f.Add(append(append([]byte(nil), initials[0]...), initials[len(initials)-1]...))
+	f.Add([]byte{0xc0, 0, 0, 0, 1, 0x08})
+	f.Add([]byte{0x40, 1, 2, 3})
+
+	f.Fuzz(func(t *testing.T, datagram []byte) {
+		ClassifyPacket(datagram)
+		ExtractDCID(datagram, 8)
+		ExtractDCIDAndSCID(datagram)
+		ExtractAllSCIDs(datagram)
+		CoalescedPackets(datagram)
+		frames, err := ExtractCryptoFramesFromPacket(datagram)
+		if err != nil {
+			return
+		}
+		a := NewCryptoAssembler()
+		for _, fr := range frames {
+			a.AddFrame(fr.Offset, fr.Data)
+		}
+		a.TryParse()
+	})
+}
+
+// FuzzCryptoFrames feeds decrypted Initial payloads to the frame parser and
+// the same frames, split at every offset, to the CryptoAssembler.
+func FuzzCryptoFrames(f *testing.F) {
+	_, hello := cryptoSeeds(f)
+	f.Add(cryptoFrame(0, hello))
+	f.Add(append(cryptoFrame(100, hello[100:]), cryptoFrame(0, hello[:100])...))
+	f.Add(append([]byte{0x00, 0x01}, cryptoFrame(0, hello[:10])...))
+
+	f.Fuzz(func(t *testing.T, payload []byte) {
+		parseFrames(payload)
+		a := NewCryptoAssembler()
+		for _, fr := range extractCryptoFrames(payload) {
+			a.AddFrame(fr.Offset, fr.Data)
+		}
+		a.TryParse()
+	})
+}
+
+// FuzzClientHello feeds TLS handshake messages to the ClientHello parser.
+func FuzzClientHello(f *testing.F) {
+	_, hello := cryptoSeeds(f)
+	f.Add(hello)
+	f.Add(hello[:len(hello)/2])
+	f.Add([]byte{0x01, 0, 0, 2, 3, 3})
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		h, err := parseTLSClientHello(data)
+		if err == nil && h == nil {
+			t.Fatal("nil ClientHello without error")
+		}
+	})
+}
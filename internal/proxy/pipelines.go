@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+
+	"quic-relay/internal/handler"
+)
+
+// PipelineConfig is the handler chain of a pipeline.
+type PipelineConfig []handler.HandlerConfig
+
+// pipelineSet holds the pipelines by name.
+type pipelineSet map[string]*handler.Chain
+
+// SetPipelines builds the named handler chains that Redirect results and
+// on_drop "redirect" hand new connections to (hot-reload safe). Sessions
+// already redirected keep the pipeline they were handed to; the handlers of
+// replaced pipelines are closed.
+func (p *Proxy) SetPipelines(cfgs map[string]PipelineConfig) error {
+	pipelines, err := buildPipelines(cfgs)
+	if err != nil {
+		return err
+	}
+	p.storePipelines(pipelines)
+	return nil
+}
+
+// buildPipelines builds the chains of cfgs. On error, the ones already built
+// are closed.
+func buildPipelines(cfgs map[string]PipelineConfig) (pipelineSet, error) {
+	pipelines := make(pipelineSet, len(cfgs))
+	for name, handlers := range cfgs {
+		if name == "" {
+			pipelines.close()
+			return nil, errors.New("pipelines: empty name")
+		}
+		pl, err := handler.BuildChain(handlers)
+		if err != nil {
+			pipelines.close()
+			return nil, fmt.Errorf("pipeline %s: %w", name, err)
+		}
+		pipelines[name] = pl
+	}
+	return pipelines, nil
+}
+
+// storePipelines makes pipelines active and closes the replaced ones.
+func (p *Proxy) storePipelines(pipelines pipelineSet) {
+	if old := p.pipelines.Swap(&pipelines); old != nil {
+		old.close()
+	}
+}
+
+func (s pipelineSet) close() {
+	for _, pl := range s {
+		pl.Close()
+	}
+}
+
+// pipeline returns the pipeline called name, or nil.
+func (p *Proxy) pipeline(name string) *handler.Chain {
+	if pipelines := p.pipelines.Load(); pipelines != nil {
+		return (*pipelines)[name]
+	}
+	return nil
+}
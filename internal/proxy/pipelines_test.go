@@ -0,0 +1,52 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+func TestSetPipelines(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	if err := p.SetPipelines(map[string]PipelineConfig{"inspect": {{Type: "logsni"}}}); err != nil {
+		t.Fatal(err)
+	}
+	pl := p.pipeline("inspect")
+	if pl == nil || len(pl.Handlers()) != 1 || p.pipeline("other") != nil {
+		t.Fatalf("pipeline = %v", pl)
+	}
+
+	// An invalid config keeps the pipelines in use
+	err := p.SetPipelines(map[string]PipelineConfig{"inspect": {{Type: "no-such-handler"}}})
+	if err == nil || !strings.Contains(err.Error(), "pipeline inspect") {
+		t.Errorf("error = %v", err)
+	}
+	if p.pipeline("inspect") != pl {
+		t.Error("pipelines replaced by an invalid config")
+	}
+
+	// Reloaded chains find the pipelines too
+	chain := handler.NewChain(&redirectHandler{target: "inspect"})
+	p.ReloadChain(chain)
+	ctx := &handler.Context{}
+	// logsni passes every connection on, so the pipeline itself refuses it
+	if result := chain.OnConnect(ctx); result.Action != handler.Drop || result.Error != nil {
+		t.Errorf("OnConnect = %+v", result)
+	}
+	if err := p.SetPipelines(nil); err != nil || p.pipeline("inspect") != nil {
+		t.Errorf("pipelines not cleared: %v", err)
+	}
+}
+
+// redirectHandler redirects every connection to target.
+type redirectHandler struct{ target string }
+
+func (h *redirectHandler) Name() string { return "redirect" }
+func (h *redirectHandler) OnConnect(ctx *handler.Context) handler.Result {
+	return handler.Result{Action: handler.Redirect, Target: h.target}
+}
+func (h *redirectHandler) OnPacket(*handler.Context, []byte, handler.Direction) handler.Result {
+	return handler.Result{Action: handler.Continue}
+}
+func (h *redirectHandler) OnDisconnect(*handler.Context) {}
@@ -12,7 +12,8 @@ import (
 type WorkItem struct {
 	ClientAddr *net.UDPAddr
 	Packet     []byte
-	Buffer     *[]byte // Reference for returning to pool
+	Buffer     *[]byte            // Reference for returning to pool
+	Conn       handler.ClientConn // Listener the packet arrived on (nil = primary)
 }
 
 // WorkerPool manages a sharded pool of packet processing workers.
@@ -21,16 +22,30 @@ type WorkItem struct {
 type WorkerPool struct {
 	queues        []chan WorkItem
 	wg            sync.WaitGroup
-	handler       func(*net.UDPAddr, []byte)
+	handler       func(WorkItem)
 	workers       int
 	queuePerShard int
 	dropped       []uint64 // Per-shard drop counters (atomic)
+	processed     []atomic.Uint64
+
+	// Read loops and ingress adapters may still submit while Stop runs
+	mu     sync.RWMutex
+	closed bool
+
+	onStart func(worker int) // Called on each worker goroutine before it takes packets (e.g. CPU pinning)
 }
 
 // NewWorkerPool creates a sharded worker pool.
 // workers: number of workers/shards (0 = NumCPU * 2)
 // queueSize: total queue capacity across all shards (0 = 10000)
 func NewWorkerPool(workers, queueSize int, handler func(*net.UDPAddr, []byte)) *WorkerPool {
+	return newItemWorkerPool(workers, queueSize, func(item WorkItem) {
+		handler(item.ClientAddr, item.Packet)
+	})
+}
+
+// newItemWorkerPool creates a sharded worker pool whose handler receives the full WorkItem.
+func newItemWorkerPool(workers, queueSize int, handler func(WorkItem)) *WorkerPool {
 	if workers <= 0 {
 		workers = runtime.NumCPU() * 2
 	}
@@ -49,6 +64,7 @@ func NewWorkerPool(workers, queueSize int, handler func(*net.UDPAddr, []byte)) *
 		workers:       workers,
 		queuePerShard: queuePerShard,
 		dropped:       make([]uint64, workers),
+		processed:     make([]atomic.Uint64, workers),
 	}
 
 	for i := 0; i < workers; i++ {
@@ -68,16 +84,29 @@ func (p *WorkerPool) Start() {
 
 // Stop gracefully shuts down the pool and waits for workers to finish.
 func (p *WorkerPool) Stop() {
-	for i := 0; i < p.workers; i++ {
-		close(p.queues[i])
+	p.mu.Lock()
+	if !p.closed {
+		p.closed = true
+		for i := 0; i < p.workers; i++ {
+			close(p.queues[i])
+		}
 	}
+	p.mu.Unlock()
 	p.wg.Wait()
 }
 
 // Submit adds a packet to the appropriate shard's queue.
 // Uses client address hash for affinity (same client -> same worker).
-// Returns false if queue is full (backpressure).
+// Returns false if queue is full (backpressure) or the pool is stopped.
 func (p *WorkerPool) Submit(item WorkItem) bool {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	if p.closed {
+		if item.Buffer != nil {
+			handler.PutBuffer(item.Buffer)
+		}
+		return false
+	}
 	idx := hashAddr(item.ClientAddr) % uint32(p.workers)
 	select {
 	case p.queues[idx] <- item:
@@ -100,6 +129,11 @@ func (p *WorkerPool) Dropped() uint64 {
 	return total
 }
 
+// Processed returns the packets worker i has handled.
+func (p *WorkerPool) Processed(i int) uint64 {
+	return p.processed[i].Load()
+}
+
 // QueueSize returns total pending items across all shards.
 func (p *WorkerPool) QueueSize() int {
 	var total int
@@ -111,8 +145,12 @@ func (p *WorkerPool) QueueSize() int {
 
 func (p *WorkerPool) worker(id int) {
 	defer p.wg.Done()
+	if p.onStart != nil {
+		p.onStart(id)
+	}
 	for item := range p.queues[id] {
-		p.handler(item.ClientAddr, item.Packet)
+		p.handler(item)
+		p.processed[id].Add(1)
 		if item.Buffer != nil {
 			handler.PutBuffer(item.Buffer)
 		}
@@ -86,6 +86,29 @@ func TestWorkerPool_Stop(t *testing.T) {
 	}
 }
 
+func TestWorkerPool_SubmitDuringStop(t *testing.T) {
+	pool := NewWorkerPool(2, 100, func(addr *net.UDPAddr, packet []byte) {})
+	pool.Start()
+	addr := &net.UDPAddr{IP: net.ParseIP("127.0.0.1"), Port: 1234}
+
+	// A read loop still submitting while the proxy stops must not panic
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10000; i++ {
+			pool.Submit(WorkItem{ClientAddr: addr, Packet: []byte{0x01}})
+		}
+	}()
+	time.Sleep(time.Millisecond)
+	pool.Stop()
+	<-done
+
+	if pool.Submit(WorkItem{ClientAddr: addr, Packet: []byte{0x01}}) {
+		t.Error("Submit after Stop should fail")
+	}
+	pool.Stop() // Idempotent
+}
+
 func TestWorkerPool_QueueSize(t *testing.T) {
 	pool := NewWorkerPool(1, 100, func(addr *net.UDPAddr, packet []byte) {
 		time.Sleep(10 * time.Millisecond)
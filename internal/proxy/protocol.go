@@ -0,0 +1,198 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/privacy"
+)
+
+// ProtocolRule classifies non-QUIC datagrams so they can be relayed through the
+// handler chain. A rule matches when all of its set conditions hold.
+type ProtocolRule struct {
+	Name   string `json:"name"`             // Protocol label, exposed to handlers as ctx.Protocol
+	Port   int    `json:"port,omitempty"`   // Local port the datagram arrived on
+	Prefix string `json:"prefix,omitempty"` // Hex bytes expected at Offset, e.g. "01000000"
+	Offset int    `json:"offset,omitempty"` // Byte offset of Prefix
+	MinLen int    `json:"min_len,omitempty"`
+}
+
+// protocolMatcher is a compiled ProtocolRule.
+type protocolMatcher struct {
+	name   string
+	port   int
+	prefix []byte
+	offset int
+	minLen int
+}
+
+// compileProtocolRules validates rules and decodes their prefixes.
+func compileProtocolRules(rules []ProtocolRule) ([]protocolMatcher, error) {
+	matchers := make([]protocolMatcher, 0, len(rules))
+	for i, r := range rules {
+		if r.Name == "" {
+			return nil, fmt.Errorf("protocol rule %d: missing name", i)
+		}
+		if strings.EqualFold(r.Name, "quic") {
+			return nil, fmt.Errorf("protocol rule %d: name %q is reserved", i, r.Name)
+		}
+		prefix, err := hex.DecodeString(strings.ReplaceAll(r.Prefix, " ", ""))
+		if err != nil {
+			return nil, fmt.Errorf("protocol rule %s: invalid prefix: %w", r.Name, err)
+		}
+		if r.Port == 0 && len(prefix) == 0 {
+			return nil, fmt.Errorf("protocol rule %s: requires 'port' or 'prefix'", r.Name)
+		}
+		if r.Offset < 0 || r.MinLen < 0 {
+			return nil, fmt.Errorf("protocol rule %s: negative offset or min_len", r.Name)
+		}
+		matchers = append(matchers, protocolMatcher{
+			name:   r.Name,
+			port:   r.Port,
+			prefix: prefix,
+			offset: r.Offset,
+			minLen: r.MinLen,
+		})
+	}
+	return matchers, nil
+}
+
+// match reports whether a datagram received on localPort matches the rule.
+func (m *protocolMatcher) match(localPort int, packet []byte) bool {
+	if m.port != 0 && m.port != localPort {
+		return false
+	}
+	if len(packet) < m.minLen {
+		return false
+	}
+	if len(m.prefix) > 0 {
+		end := m.offset + len(m.prefix)
+		if len(packet) < end || !bytes.Equal(packet[m.offset:end], m.prefix) {
+			return false
+		}
+	}
+	return true
+}
+
+// detectProtocol returns the name of the first matching rule, or "" for QUIC.
+func detectProtocol(matchers []protocolMatcher, localPort int, packet []byte) string {
+	for i := range matchers {
+		if matchers[i].match(localPort, packet) {
+			return matchers[i].name
+		}
+	}
+	return ""
+}
+
+// SetProtocols replaces the protocol detection rules (hot-reload safe).
+func (p *Proxy) SetProtocols(rules []ProtocolRule) error {
+	matchers, err := compileProtocolRules(rules)
+	if err != nil {
+		return err
+	}
+	p.protocols.Store(&matchers)
+	return nil
+}
+
+// rawSessionKey is the session key of a non-QUIC flow.
+// Flows are identified by protocol and client address since there is no connection ID.
+// The packet path finds a flow through the client address index of the store.
+func rawSessionKey(protocol string, clientAddr *net.UDPAddr) string {
+	return "udp/" + protocol + "/" + addrKey(clientAddr).String()
+}
+
+// rawSession returns the flow of protocol from clientAddr.
+func (p *Proxy) rawSession(protocol string, clientAddr *net.UDPAddr) (*handler.Context, bool) {
+	if key, ok := p.store.ClientSession(protocol, addrKey(clientAddr)); ok {
+		return p.store.Load(key)
+	}
+	return nil, false
+}
+
+// handleRawPacket relays a datagram that matched a protocol rule.
+// The first datagram of a flow runs OnConnect with ctx.Protocol set and no ClientHello.
+func (p *Proxy) handleRawPacket(conn handler.ClientConn, protocol string, clientAddr *net.UDPAddr, packet []byte, hop *handler.HopInfo) {
+	if ctx, ok := p.rawSession(protocol, clientAddr); ok {
+		if ctx.Session != nil && ctx.Session.Unconfirmed() {
+			p.confirmSession(ctx, clientAddr)
+		}
+		result := p.chain.Load().OnPacket(ctx, packet, handler.Inbound)
+		if result.Action == handler.Drop {
+			if result.Error != nil {
+				logger.Printf("packet dropped: %v", result.Error)
+			}
+			p.respondDrop(conn, clientAddr, packet, ctx, result)
+		}
+		return
+	}
+
+	// Packets of a flow held by the chain must not start another one
+	key := rawSessionKey(protocol, clientAddr)
+	if p.joinHeldFlow(key, packet) {
+		return
+	}
+
+	if !p.admitNew(time.Now()) {
+		return
+	}
+	logger.Printf("new %s flow from %s", protocol, privacy.Addr(clientAddr))
+
+	newCtx := &handler.Context{
+		ClientAddr:    clientAddr,
+		InitialPacket: packet,
+		Protocol:      protocol,
+		ProxyConn:     conn,
+		Hop:           hop,
+	}
+	newCtx.SessionCount = p.sessionCount.Load
+	p.setSessionSlot(newCtx)
+	handler.AssignFeatures(newCtx)
+	newCtx.SendConnectionClose = func(uint64, string) error {
+		return errors.New("not a QUIC connection")
+	}
+
+	result := p.chain.Load().OnConnect(newCtx)
+	if result.Action == handler.Hold {
+		// The packet is held past this call and the buffer it is in
+		newCtx.InitialPacket = bytes.Clone(packet)
+		attempt := p.beginAttempt(key)
+		result.Hold(func(result handler.Result) {
+			p.flowConnected(newCtx, key, result)
+			p.attempts.CompareAndDelete(key, attempt)
+		})
+		return
+	}
+	p.flowConnected(newCtx, key, result)
+}
+
+// flowConnected finishes the new flow stored under key with the chain's decision.
+func (p *Proxy) flowConnected(newCtx *handler.Context, key string, result handler.Result) {
+	conn, clientAddr, packet, protocol := newCtx.ProxyConn, newCtx.ClientAddr, newCtx.InitialPacket, newCtx.Protocol
+	if result.Action == handler.Drop {
+		newCtx.ReleaseSession()
+		if result.Error != nil {
+			logger.Printf("%s flow dropped: %v", protocol, result.Error)
+		}
+		p.respondDrop(conn, clientAddr, packet, newCtx, result)
+		return
+	}
+
+	if result.Action == handler.Handled && newCtx.Session != nil {
+		p.storeSession(key, newCtx)
+		p.store.SetClientSession(protocol, addrKey(clientAddr), key)
+		p.flushPendingPackets(key, newCtx)
+		newCtx.DropSession = func() {
+			newCtx.SetCloseReason(handler.CloseHandlerDrop)
+			p.chain.Load().OnDisconnect(newCtx)
+			p.deleteSession(key, newCtx)
+		}
+		return
+	}
+	newCtx.ReleaseSession()
+}
@@ -0,0 +1,130 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+func TestCompileProtocolRules_Errors(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules []ProtocolRule
+		err   string
+	}{
+		{"missing name", []ProtocolRule{{Port: 1}}, "missing name"},
+		{"reserved name", []ProtocolRule{{Name: "QUIC", Port: 1}}, "reserved"},
+		{"bad hex", []ProtocolRule{{Name: "x", Prefix: "zz"}}, "invalid prefix"},
+		{"no condition", []ProtocolRule{{Name: "x"}}, "requires 'port' or 'prefix'"},
+		{"negative offset", []ProtocolRule{{Name: "x", Prefix: "01", Offset: -1}}, "negative"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := compileProtocolRules(tt.rules)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDetectProtocol(t *testing.T) {
+	matchers, err := compileProtocolRules([]ProtocolRule{
+		{Name: "wireguard", Prefix: "01 00 00 00", MinLen: 148},
+		{Name: "rtp", Port: 5004},
+		{Name: "magic", Prefix: "cafe", Offset: 2, Port: 7000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wgInit := make([]byte, 148)
+	wgInit[0] = 0x01
+
+	tests := []struct {
+		name   string
+		port   int
+		packet []byte
+		want   string
+	}{
+		{"wireguard initiation", 5520, wgInit, "wireguard"},
+		{"wireguard too short", 5520, wgInit[:100], ""},
+		{"port rule", 5004, []byte{0x80, 0x00}, "rtp"},
+		{"offset prefix", 7000, []byte{0, 0, 0xca, 0xfe}, "magic"},
+		{"offset prefix wrong port", 7001, []byte{0, 0, 0xca, 0xfe}, ""},
+		{"quic initial", 5520, []byte{0xc3, 0, 0, 0, 1}, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := detectProtocol(matchers, tt.port, tt.packet); got != tt.want {
+				t.Errorf("detectProtocol = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestProxy_RawFlow(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteToUDP(append([]byte("echo:"), buf[:n]...), addr)
+		}
+	}()
+
+	router, err := handler.NewProtocolRouterHandler(json.RawMessage(`{"routes": {"echo": "` + backend.LocalAddr().String() + `"}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd, _ := handler.NewForwarderHandler(nil)
+
+	p := New("127.0.0.1:0", handler.NewChain(router, fwd))
+	if err := p.SetProtocols([]ProtocolRule{{Name: "echo", Prefix: "6563686f"}}); err != nil {
+		t.Fatal(err)
+	}
+	p.conn, err = net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	clientAddr := client.LocalAddr().(*net.UDPAddr)
+
+	for i, msg := range []string{"echo 1", "echo 2"} {
+		p.handlePacket(p.conn, clientAddr, []byte(msg))
+
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		buf := make([]byte, 1500)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("message %d: %v", i, err)
+		}
+		if got := string(buf[:n]); got != "echo:"+msg {
+			t.Errorf("reply = %q", got)
+		}
+	}
+
+	if n := p.SessionCount(); n != 1 {
+		t.Errorf("sessions = %d, want 1", n)
+	}
+	if s := p.Sessions(); len(s) != 1 || s[0].Protocol != "echo" {
+		t.Errorf("sessions = %+v", s)
+	}
+}
@@ -7,24 +7,93 @@ import (
 	"crypto/aes"
 	"crypto/cipher"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"net"
+	"net/netip"
 	"os"
-	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
 
+	"quic-relay/internal/audit"
 	"quic-relay/internal/debug"
+	"quic-relay/internal/dns"
 	"quic-relay/internal/handler"
+	"quic-relay/internal/logging"
+	"quic-relay/internal/metrics"
+	"quic-relay/internal/privacy"
+	"quic-relay/internal/retention"
+	"quic-relay/internal/secrets"
+	"quic-relay/internal/timerwheel"
 )
 
+var logger = logging.For("proxy")
+
+// AdminConfig configures the admin API listener.
+// Without tokens or a client CA the API is unauthenticated.
+type AdminConfig struct {
+	Listen      string              `json:"listen"`                 // e.g. "127.0.0.1:9090"
+	TLS         *AdminTLSConfig     `json:"tls,omitempty"`          // Serve HTTPS, optionally requiring client certificates
+	Tokens      []AdminToken        `json:"tokens,omitempty"`       // Bearer tokens and their roles
+	ClientRoles map[string]string   `json:"client_roles,omitempty"` // Client certificate common name -> role
+	Roles       map[string][]string `json:"roles,omitempty"`        // Custom or overridden role allowlists
+	MigrateHook string              `json:"migrate_hook,omitempty"` // URL called before POST /sessions/{id}/migrate moves a session
+
+	Snapshots *AdminSnapshotsConfig `json:"snapshots,omitempty"` // Keep snapshots of the handler configuration for rollback
+}
+
+// AdminSnapshotsConfig keeps timestamped snapshots of the handler configuration.
+type AdminSnapshotsConfig struct {
+	Dir      string `json:"dir"`                // Directory holding one JSON file per snapshot
+	Interval int    `json:"interval,omitempty"` // Seconds between checks for changes (default: 300)
+	Keep     int    `json:"keep,omitempty"`     // Snapshots kept, oldest are removed (default: 100)
+}
+
+// AdminTLSConfig configures HTTPS for the admin API.
+type AdminTLSConfig struct {
+	Cert     string `json:"cert"`
+	Key      string `json:"key"`
+	ClientCA string `json:"client_ca,omitempty"` // Require client certificates signed by this CA
+}
+
+// AdminToken is a bearer token for the admin API.
+type AdminToken struct {
+	Name      string `json:"name"`                 // Identifies the caller in logs and the audit log
+	Token     string `json:"token,omitempty"`      // The token itself
+	TokenFile string `json:"token_file,omitempty"` // Or a file containing it
+	Role      string `json:"role"`                 // read, operator, admin or a custom role
+}
+
 // Config represents the proxy configuration.
 type Config struct {
-	Listen         string                  `json:"listen"`
-	Handlers       []handler.HandlerConfig `json:"handlers"`
-	SessionTimeout int                     `json:"session_timeout,omitempty"` // Idle timeout in seconds (default: 600)
+	Listen          string                    `json:"listen"`
+	Handlers        []handler.HandlerConfig   `json:"handlers"`
+	Pipelines       map[string]PipelineConfig `json:"pipelines,omitempty"`        // Named chains connections can be redirected to
+	FeatureFlags    handler.FeatureConfig     `json:"feature_flags,omitempty"`    // Share of new connections each feature is on for
+	SessionTimeout  int                       `json:"session_timeout,omitempty"`  // Idle timeout in seconds (default: 600)
+	Log             *logging.Config           `json:"log,omitempty"`              // Logging output and levels (default: stderr, info)
+	Privacy         *privacy.Config           `json:"privacy,omitempty"`          // Anonymize client addresses in logs and the admin API
+	DebugServer     *debug.ServerConfig       `json:"debug_server,omitempty"`     // Optional pprof/expvar listener
+	BufferPool      *handler.BufferPoolConfig `json:"buffer_pool,omitempty"`      // Idle buffer limits per size tier
+	Admin           *AdminConfig              `json:"admin,omitempty"`            // Optional admin API listener
+	ExtraListen     []string                  `json:"extra_listen,omitempty"`     // Additional UDP listen addresses
+	Protocols       []ProtocolRule            `json:"protocols,omitempty"`        // Non-QUIC protocol detection rules
+	Ingress         []IngressConfig           `json:"ingress,omitempty"`          // SOCKS5 / TCP tunnel ingress adapters
+	Relay           *RelayConfig              `json:"relay,omitempty"`            // Accept connections from upstream relays
+	Snapshot        *SnapshotConfig           `json:"snapshot,omitempty"`         // Persist sessions across restarts
+	StatelessReset  *StatelessResetConfig     `json:"stateless_reset,omitempty"`  // Reset clients of unknown connections
+	ClientMigration *ClientMigrationConfig    `json:"client_migration,omitempty"` // Validate client address changes
+	Violations      *ViolationsConfig         `json:"violations,omitempty"`       // What to do with protocol violations
+	SlowStart       *SlowStartConfig          `json:"slow_start,omitempty"`       // Ramp up admission after startup and maintenance
+	FlowExport      *FlowExportConfig         `json:"flow_export,omitempty"`      // Send session flow records to an IPFIX/NetFlow collector
+	PacketSampling  *PacketSamplingConfig     `json:"packet_sampling,omitempty"`  // Export sampled datagram headers (sFlow, pcap)
+	Retention       *retention.Config         `json:"retention,omitempty"`        // Delete old log and capture files
+	SocketBuffers   *SocketBuffersConfig      `json:"socket_buffers,omitempty"`   // SO_RCVBUF / SO_SNDBUF sizes
+	CPUAffinity     *CPUAffinityConfig        `json:"cpu_affinity,omitempty"`     // Pin listeners and workers to CPUs (Linux)
+	Audit           *audit.Config             `json:"audit,omitempty"`            // Append-only log of operator actions
+	Exporters       []metrics.ExportConfig    `json:"exporters,omitempty"`        // Push metrics to remote write or statsd receivers
+	DNS             *dns.Config               `json:"dns,omitempty"`              // Answer DNS queries for route hostnames
 }
 
 // LoadConfig loads configuration from a JSON file.
@@ -37,7 +106,12 @@ func LoadConfig(path string) (*Config, error) {
 }
 
 // ParseConfig parses configuration from JSON bytes.
+// ${...} references in string values are expanded first (see package secrets).
 func ParseConfig(data []byte) (*Config, error) {
+	data, err := secrets.ExpandJSON(data)
+	if err != nil {
+		return nil, err
+	}
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, err
@@ -45,6 +119,57 @@ func ParseConfig(data []byte) (*Config, error) {
 	return &cfg, nil
 }
 
+// NewFromConfig creates a proxy running chain with the settings of cfg, as
+// the relay starts. Process-wide settings (logging, audit, exporters, buffer
+// pool, feature flags) and the admin API are left to the caller.
+func NewFromConfig(cfg *Config, chain *handler.Chain) (*Proxy, error) {
+	p := New(cfg.Listen, chain)
+	p.SetSessionTimeout(cfg.SessionTimeout)
+	p.SetExtraListen(cfg.ExtraListen)
+	if err := p.SetProtocols(cfg.Protocols); err != nil {
+		return nil, fmt.Errorf("invalid protocol rules: %w", err)
+	}
+	if err := p.SetIngress(cfg.Ingress); err != nil {
+		return nil, fmt.Errorf("invalid ingress config: %w", err)
+	}
+	if err := p.SetRelayConfig(cfg.Relay); err != nil {
+		return nil, fmt.Errorf("invalid relay config: %w", err)
+	}
+	if err := p.SetSnapshot(cfg.Snapshot); err != nil {
+		return nil, fmt.Errorf("invalid snapshot config: %w", err)
+	}
+	if err := p.SetStatelessReset(cfg.StatelessReset); err != nil {
+		return nil, fmt.Errorf("invalid stateless reset config: %w", err)
+	}
+	if err := p.SetClientMigration(cfg.ClientMigration); err != nil {
+		return nil, fmt.Errorf("invalid client_migration config: %w", err)
+	}
+	if err := p.SetViolations(cfg.Violations); err != nil {
+		return nil, fmt.Errorf("invalid violations config: %w", err)
+	}
+	if err := p.SetPipelines(cfg.Pipelines); err != nil {
+		return nil, err
+	}
+	if err := p.SetSlowStart(cfg.SlowStart); err != nil {
+		return nil, fmt.Errorf("invalid slow_start config: %w", err)
+	}
+	if err := p.SetFlowExport(cfg.FlowExport); err != nil {
+		return nil, fmt.Errorf("invalid flow_export config: %w", err)
+	}
+	if err := p.SetPacketSampling(cfg.PacketSampling); err != nil {
+		return nil, fmt.Errorf("invalid packet_sampling config: %w", err)
+	}
+	if err := p.SetRetention(cfg.Retention); err != nil {
+		return nil, fmt.Errorf("invalid retention config: %w", err)
+	}
+	p.SetSocketBuffers(cfg.SocketBuffers)
+	if err := p.SetCPUAffinity(cfg.CPUAffinity); err != nil {
+		return nil, fmt.Errorf("invalid cpu_affinity config: %w", err)
+	}
+	p.config.Store(cfg)
+	return p, nil
+}
+
 // CryptoAssembler collects CRYPTO frames from multiple Initial packets.
 // Production-ready: bounded memory, timeout-based cleanup, cached crypto objects.
 type CryptoAssembler struct {
@@ -245,20 +370,89 @@ type Proxy struct {
 	listenAddr     string
 	conn           *net.UDPConn
 	chain          atomic.Pointer[handler.Chain] // Atomic for hot reload
+	config         atomic.Pointer[Config]        // Config created from or last reloaded, see reload.go
+	reloadMu       sync.Mutex                    // Serializes Reload
+	pipelines      atomic.Pointer[pipelineSet]   // Redirect targets by name, see pipelines.go
 	sessionTimeout atomic.Int64                  // Idle timeout in seconds (atomic for hot reload)
-	sessions       sync.Map                      // DCID (string) -> *handler.Context
+	store          SessionStore                  // Sessions and their CID and client address indexes
 	sessionCount   atomic.Int64                  // O(1) session counter
+	sessionSlots   atomic.Int64                  // Sessions and connections in OnConnect holding a slot, see reserve.go
 	assemblers     sync.Map                      // DCID (string) -> *CryptoAssembler
 	pendingPackets sync.Map                      // DCID (string) -> *pendingBuffer (out-of-order packets)
-	dcidAliases    sync.Map                      // Server SCID (string) -> original DCID (string)
-	clientSessions sync.Map                      // Client address (string) -> original DCID (string)
+	attempts       sync.Map                      // Client address + DCID -> *connectAttempt (retransmitted Initials)
 	workerPool     *WorkerPool
 	ctx            context.Context
 	cancel         context.CancelFunc
 
-	// DCID length tracking for Short Header parsing
-	dcidLengths   map[int]struct{}
-	dcidLengthsMu sync.RWMutex
+	// Additional listeners and non-QUIC protocol detection
+	extraAddrs []string
+	extraConns []*net.UDPConn
+	protocols  atomic.Pointer[[]protocolMatcher] // Atomic for hot reload
+
+	// Non-UDP ingress adapters
+	ingressCfgs []IngressConfig
+	ingresses   []ingress
+
+	// Upstream relays allowed to send hop headers
+	trustedRelays atomic.Pointer[[]*net.IPNet]
+
+	// Client responses for dropped connections (on_drop) and unknown connections
+	drops    dropResponder
+	resetter atomic.Pointer[statelessResetter] // Atomic for hot reload
+
+	// Client address change validation
+	migration    atomic.Pointer[migrationPolicy] // Atomic for hot reload, nil = switch at once
+	sessionMoves sync.Map                        // Session ID -> *sessionMoves
+	moves        migrationCounters
+
+	// Protocol violation policy and recent violations per client address
+	violations       atomic.Pointer[violationPolicy] // Atomic for hot reload, nil = count only
+	violationSources struct {
+		sync.Mutex
+		m map[netip.Addr]*violationSource
+	}
+
+	// Admission ramp after startup and maintenance, see slowstart.go
+	slowStartPolicy atomic.Pointer[slowStartPolicy] // Atomic for hot reload, nil = no ramp
+	slowStart       slowStart
+
+	// Flow records for IPFIX/NetFlow collectors, see flowexport.go
+	flowExport   atomic.Pointer[flowExporter] // Atomic for hot reload, nil = not exported
+	flows        flowTable
+	flowCounters flowCounters
+
+	// 1-in-N datagram samples for sFlow collectors and captures, see sampling.go
+	sampler        atomic.Pointer[packetSampler] // Atomic for hot reload, nil = not sampled
+	sampleCounters sampleCounters
+
+	// Deletes old log and capture files, see retention.go
+	janitor           atomic.Pointer[janitor] // Atomic for hot reload, nil = files are kept
+	retentionCounters retentionCounters
+
+	// Session snapshots for restarts
+	snapshotCfg *SnapshotConfig
+	stopped     chan struct{} // Closed when Stop has finished
+	stopOnce    sync.Once     // Stop runs once
+
+	// SO_RCVBUF / SO_SNDBUF of client-facing listeners
+	listenerBuffers handler.SocketBufferConfig
+
+	// CPUs listeners and workers are pinned to, and packets per listener
+	placement cpuPlacement
+
+	// Session lifecycle events for live subscribers (admin API)
+	events eventHub
+
+	// Listeners inherited from the service manager (socket activation)
+	inherited []*net.UDPConn
+	ready     chan struct{} // Closed once listeners are open and packets are read
+	lastRead  atomic.Int64  // Unix nanos of the last primary read loop iteration
+
+	// Collects connection decisions while replaying a capture (nil when live)
+	replay *replayRecorder
+
+	// Initials absorbed by a connection attempt in flight or just ended
+	retransmittedInitials atomic.Uint64
 }
 
 const defaultSessionTimeout = 600 // 10 minutes in seconds
@@ -267,11 +461,15 @@ const defaultSessionTimeout = 600 // 10 minutes in seconds
 func New(listenAddr string, chain *handler.Chain) *Proxy {
 	ctx, cancel := context.WithCancel(context.Background())
 	p := &Proxy{
-		listenAddr:  listenAddr,
-		dcidLengths: make(map[int]struct{}),
-		ctx:         ctx,
-		cancel:      cancel,
+		listenAddr: listenAddr,
+		store:      NewMemoryStore(),
+		ctx:        ctx,
+		cancel:     cancel,
+		stopped:    make(chan struct{}),
+		ready:      make(chan struct{}),
 	}
+	p.listenerBuffers = handler.SocketBufferConfig{}.WithDefault(handler.DefaultListenerBuffer)
+	chain.SetPipelines(p.pipeline)
 	p.chain.Store(chain)
 	p.sessionTimeout.Store(defaultSessionTimeout)
 	return p
@@ -284,112 +482,325 @@ func (p *Proxy) SetSessionTimeout(seconds int) {
 		seconds = defaultSessionTimeout
 	}
 	p.sessionTimeout.Store(int64(seconds))
+	p.recheckIdle()
+}
+
+// SetSessionStore replaces the in-memory session store, e.g. with one an
+// embedding program replicates to a standby. Must be called before Run,
+// while the proxy holds no sessions.
+func (p *Proxy) SetSessionStore(store SessionStore) {
+	p.store = store
+}
+
+// SetExtraListen sets additional listen addresses. Must be called before Run.
+// Datagrams on every listener share the handler chain and session table.
+func (p *Proxy) SetExtraListen(addrs []string) {
+	p.extraAddrs = addrs
 }
 
+// SetListeners hands the proxy already open listeners, such as sockets passed by
+// systemd socket activation. The first replaces the listen address and the rest
+// replace extra_listen. Must be called before Run.
+func (p *Proxy) SetListeners(conns []*net.UDPConn) {
+	p.inherited = conns
+}
+
+// Ready returns a channel that is closed once the proxy is reading packets.
+func (p *Proxy) Ready() <-chan struct{} {
+	return p.ready
+}
+
+// Healthy reports an error when the proxy is shutting down or the primary
+// read loop has stalled. The loop wakes at least once per second.
+func (p *Proxy) Healthy() error {
+	if p.ctx.Err() != nil {
+		return errors.New("proxy is stopping")
+	}
+	last := p.lastRead.Load()
+	if last == 0 {
+		return errors.New("proxy is not running")
+	}
+	if stall := time.Since(time.Unix(0, last)); stall > readLoopStallTimeout {
+		return fmt.Errorf("read loop stalled for %s", stall.Round(time.Second))
+	}
+	return nil
+}
+
+// readLoopStallTimeout is how long the primary read loop may go without an
+// iteration before the proxy reports itself unhealthy.
+const readLoopStallTimeout = 10 * time.Second
+
 // ReloadChain atomically replaces the handler chain.
 // Existing sessions continue with their established connections.
+// Background resources of the previous chain's handlers are released.
 func (p *Proxy) ReloadChain(chain *handler.Chain) {
-	p.chain.Store(chain)
+	chain.SetPipelines(p.pipeline)
+	if old := p.chain.Swap(chain); old != nil && old != chain {
+		old.Close()
+	}
 }
 
 // Run starts the proxy server.
 func (p *Proxy) Run() error {
 	// Start coarse clock for efficient session activity tracking
 	handler.StartCoarseClock(p.ctx)
+	p.slowStart.started.Store(time.Now().UnixNano())
+
+	if len(p.inherited) > 0 {
+		p.conn = p.inherited[0]
+		p.extraConns = p.inherited[1:]
+		p.listenAddr = p.conn.LocalAddr().String()
+		for _, conn := range p.inherited {
+			defer conn.Close()
+		}
+		logger.Printf("using %d inherited listener(s), listen and extra_listen are ignored", len(p.inherited))
+	} else {
+		addr, err := net.ResolveUDPAddr("udp", p.listenAddr)
+		if err != nil {
+			return fmt.Errorf("failed to resolve address: %w", err)
+		}
 
-	addr, err := net.ResolveUDPAddr("udp", p.listenAddr)
-	if err != nil {
-		return fmt.Errorf("failed to resolve address: %w", err)
+		p.conn, err = net.ListenUDP("udp", addr)
+		if err != nil {
+			return fmt.Errorf("failed to listen: %w", err)
+		}
+		defer p.conn.Close()
+
+		for _, extra := range p.extraAddrs {
+			conn, err := listenUDP(extra)
+			if err != nil {
+				return err
+			}
+			defer conn.Close()
+			p.extraConns = append(p.extraConns, conn)
+		}
 	}
 
-	p.conn, err = net.ListenUDP("udp", addr)
-	if err != nil {
-		return fmt.Errorf("failed to listen: %w", err)
+	for _, conn := range append([]*net.UDPConn{p.conn}, p.extraConns...) {
+		if err := setupListener(conn); err != nil {
+			logger.Warnf("%s: platform socket setup failed: %v", conn.LocalAddr(), err)
+		}
+		p.sizeListener(conn)
 	}
-	defer p.conn.Close()
 
-	log.Printf("[proxy] listening on %s", p.listenAddr)
-	log.Printf("[proxy] handler chain: %v", p.handlerNames())
-	log.Printf("[proxy] session timeout: %ds", p.sessionTimeout.Load())
+	logger.Printf("listening on %s", p.listenAddr)
+	for _, conn := range p.extraConns {
+		logger.Printf("listening on %s", conn.LocalAddr())
+	}
+	logger.Printf("handler chain: %v", p.handlerNames())
+	logger.Printf("session timeout: %ds", p.sessionTimeout.Load())
 
 	// Start worker pool (bounded goroutines instead of unbounded per-packet)
 	// Note: workerPool.Stop() is called in Stop() for proper graceful shutdown
-	p.workerPool = NewWorkerPool(0, 0, p.handlePacket)
+	p.placement.listenerPackets = make([]atomic.Uint64, 1+len(p.extraConns))
+	p.workerPool = newItemWorkerPool(p.workerCount(), 0, p.processItem)
+	p.workerPool.onStart = p.pinWorker
 	p.workerPool.Start()
 
 	// Start session cleanup goroutine
 	go p.cleanupSessions()
 
+	if p.snapshotCfg != nil {
+		if err := p.restoreSnapshot(); err != nil {
+			logger.Warnf("session restore failed: %v", err)
+		}
+		go p.snapshotLoop()
+	}
+
+	for _, cfg := range p.ingressCfgs {
+		in, err := p.startIngress(cfg)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		p.ingresses = append(p.ingresses, in)
+		logger.Printf("%s ingress listening on %s", cfg.Type, in.Addr())
+	}
+
+	for _, conn := range p.extraConns {
+		go p.readLoop(conn)
+	}
+	p.lastRead.Store(time.Now().UnixNano())
+	close(p.ready)
+	p.readLoop(p.conn)
+
+	// readLoop only returns on shutdown; let Stop finish before returning
+	<-p.stopped
+	return nil
+}
+
+// processItem handles a packet taken from the worker pool.
+func (p *Proxy) processItem(item WorkItem) {
+	conn := item.Conn
+	if conn == nil {
+		conn = p.conn
+	}
+	p.handlePacket(conn, item.ClientAddr, item.Packet)
+}
+
+// listenUDP opens an additional UDP listener.
+func listenUDP(listenAddr string) (*net.UDPConn, error) {
+	addr, err := net.ResolveUDPAddr("udp", listenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve address %s: %w", listenAddr, err)
+	}
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", listenAddr, err)
+	}
+	return conn, nil
+}
+
+// readLoop reads datagrams from conn and submits them to the worker pool until shutdown.
+func (p *Proxy) readLoop(conn *net.UDPConn) {
+	// Read into max-size scratch buffers, then copy into right-sized
+	// pooled buffers so queued packets don't pin 64KB each
+	reader := newBatchReader(conn, handler.LargeBufferSize)
+	idx := p.listenerIndex(conn)
+	p.pinListener(idx)
+
 	for {
 		select {
 		case <-p.ctx.Done():
-			return nil
+			return
 		default:
 		}
 
-		// Get buffer from pool (eliminates per-packet allocation)
-		buf := handler.GetBuffer()
-
-		p.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-		n, clientAddr, err := p.conn.ReadFromUDP(*buf)
+		now := time.Now()
+		if conn == p.conn {
+			p.lastRead.Store(now.UnixNano())
+		}
+		conn.SetReadDeadline(now.Add(1 * time.Second))
+		count, err := reader.read()
 		if err != nil {
-			handler.PutBuffer(buf)
 			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 				continue
 			}
-			log.Printf("Read error: %v", err)
+			logger.Errorf("read error: %v", err)
 			continue
 		}
+		p.countRead(idx, count)
+
+		for i := 0; i < count; i++ {
+			packet, clientAddr := reader.packet(i)
+			if clientAddr == nil {
+				continue
+			}
 
-		// Submit to worker pool (non-blocking with backpressure)
-		// Buffer is returned to pool by worker after processing
-		if !p.workerPool.Submit(WorkItem{
-			ClientAddr: clientAddr,
-			Packet:     (*buf)[:n],
-			Buffer:     buf,
-		}) {
-			// Queue full - packet already dropped, buffer returned by Submit
+			// Get buffer from pool (eliminates per-packet allocation)
+			buf := handler.GetBufferSized(len(packet))
+			copy(*buf, packet)
+
+			// Submit to worker pool (non-blocking with backpressure)
+			// Buffer is returned to pool by worker after processing;
+			// when the queue is full the packet is dropped and counted
+			p.workerPool.Submit(WorkItem{
+				ClientAddr: clientAddr,
+				Packet:     (*buf)[:len(packet)],
+				Buffer:     buf,
+				Conn:       conn,
+			})
 		}
 	}
 }
 
-// handlePacket processes an incoming UDP packet.
+// handlePacket processes an incoming UDP packet received on conn.
 // Uses QUIC Connection ID (DCID) for session lookup instead of IP:Port.
 // This enables Connection Migration (RFC 9000 Section 9).
-func (p *Proxy) handlePacket(clientAddr *net.UDPAddr, packet []byte) {
+// Datagrams matching a protocol rule are relayed as non-QUIC flows instead.
+func (p *Proxy) handlePacket(conn handler.ClientConn, clientAddr *net.UDPAddr, packet []byte) {
 	// DEBUG: Log packet reception
-	debug.Printf(" received %d bytes from %s, first byte: 0x%02x", len(packet), clientAddr, packet[0])
+	if debug.IsEnabled() {
+		debug.Printf(" received %d bytes from %s, first byte: 0x%02x", len(packet), privacy.Addr(clientAddr), packet[0])
+	}
+	if p.sampler.Load() != nil {
+		p.samplePacket(sampleClients, clientAddr.AddrPort(), localAddrPort(conn), packet)
+	}
+
+	// Strip the hop header of datagrams from upstream relays
+	var hop *handler.HopInfo
+	if handler.HasHopHeader(packet) && p.isTrustedRelay(clientAddr) {
+		info, payload, err := handler.ParseHopHeader(packet)
+		if err != nil || len(payload) == 0 {
+			debug.Notef(" invalid hop header from %s: %v", clientAddr, err)
+			return
+		}
+		packet = payload
+		if info.NodeID != "" || info.ClientAddr != nil {
+			hop = info
+		}
+	}
+
+	if rules := p.protocols.Load(); rules != nil && len(*rules) > 0 {
+		if protocol := detectProtocol(*rules, addrPort(conn.LocalAddr()), packet); protocol != "" {
+			p.handleRawPacket(conn, protocol, clientAddr, packet, hop)
+			return
+		}
+	}
 
 	pktType := ClassifyPacket(packet)
 	debug.Printf(" packet type: %s", pktType)
 
 	// 1. Try to find existing session by DCID (with client address fallback)
 	ctx, dcid := p.findSession(packet, pktType, clientAddr)
+	if invalidCID(packet) {
+		source := clientAddr
+		if hop != nil && hop.ClientAddr != nil {
+			source = hop.ClientAddr
+		}
+		if p.violation(violationInvalidCID, source, ctx, fmt.Sprintf("%s with %d-byte DCID", pktType, packet[5])) {
+			return
+		}
+	}
 	if ctx != nil {
-		// Connection Migration: update client address if changed (atomic)
-		currentAddr := ctx.Session.ClientAddr()
-		if !currentAddr.IP.Equal(clientAddr.IP) || currentAddr.Port != clientAddr.Port {
-			log.Printf("[proxy] connection migration: %s -> %s (DCID=%x)",
-				currentAddr, clientAddr, ctx.Session.DCID)
-			ctx.Session.SetClientAddr(clientAddr)
+		if shortPacket(packet, dcid) && p.violation(violationShortPacket, clientAddr, ctx, fmt.Sprintf("%d-byte %s", len(packet), pktType)) {
+			return
+		}
 
-			// Update clientSessions mapping for the new address
-			dcidKey := string(ctx.Session.DCID)
-			oldKey := currentAddr.String()
-			newKey := clientAddr.String()
-			p.clientSessions.Delete(oldKey)
-			p.clientSessions.Store(newKey, dcidKey)
+		// Connection Migration: update client address if changed (atomic).
+		// A restored session follows the client that confirms it
+		move := moveSwitch
+		if ctx.Session.Unconfirmed() {
+			p.confirmSession(ctx, clientAddr)
+		} else {
+			move = p.clientMove(ctx.Session, clientAddr, time.Now())
+		}
+		switch move {
+		case moveSwitch:
+			p.switchClient(ctx.Session, clientAddr)
+		case moveReject:
+			// Not the session's fault: only the sender is penalized
+			p.violation(violationAddressBurst, clientAddr, nil, fmt.Sprintf("session %d changes address too often", ctx.Session.ID))
+			return
 		}
 
 		// Forward packet through handler chain
 		result := p.chain.Load().OnPacket(ctx, packet, handler.Inbound)
-		if result.Action == handler.Drop && result.Error != nil {
-			log.Printf("[proxy] packet dropped: %v", result.Error)
+		if result.Action == handler.Drop {
+			if result.Error != nil {
+				logger.Printf("packet dropped: %v", result.Error)
+			}
+			p.respondDrop(conn, clientAddr, packet, ctx, result)
 		}
 		return
 	}
 
 	// 2. No session found - only Initial packets can create new sessions
+	if pktType == PacketShortHeader || pktType == PacketUnknown || pktType == PacketRetry {
+		// Not part of a QUIC handshake: give datagram handlers (e.g. ping responders) a chance
+		reply := func(b []byte) error {
+			_, err := conn.WriteToUDP(b, clientAddr)
+			return err
+		}
+		if p.chain.Load().OnDatagram(clientAddr, packet, reply) {
+			return
+		}
+		if pktType == PacketShortHeader {
+			// Connection we don't know (e.g. lost in a restart): tell the client right away
+			p.sendStatelessReset(conn, clientAddr, packet)
+			return
+		}
+	}
 	if pktType != PacketInitial {
 		// Buffer 0-RTT and Handshake packets that arrived before Initial
 		if pktType == PacketZeroRTT || pktType == PacketHandshake {
@@ -413,6 +824,14 @@ func (p *Proxy) handlePacket(clientAddr *net.UDPAddr, packet []byte) {
 	}
 	dcidKey := string(dcid)
 
+	// Retransmits must not start another attempt while the chain decides
+	clientKey := clientAddr.String()
+	connectKey := attemptKey(clientKey, dcidKey)
+	if p.joinAttempt(connectKey, dcidKey, packet) {
+		debug.Printf(" retransmitted Initial joins pending connection (DCID=%x)", dcid)
+		return
+	}
+
 	// 3. Try to parse ClientHello from Initial packet
 	assemblerVal, loaded := p.assemblers.LoadOrStore(dcidKey, NewCryptoAssembler())
 	assembler := assemblerVal.(*CryptoAssembler)
@@ -424,6 +843,13 @@ func (p *Proxy) handlePacket(clientAddr *net.UDPAddr, packet []byte) {
 		p.assemblers.Store(dcidKey, assembler)
 	}
 
+	// First Initial of a new connection: the slow start ramp may turn it away,
+	// the client retransmits it later
+	if !loaded && !p.admitNew(time.Now()) {
+		p.assemblers.Delete(dcidKey)
+		return
+	}
+
 	// If assembler is complete, we already have the ClientHello
 	if assembler.IsComplete() {
 		return
@@ -432,7 +858,7 @@ func (p *Proxy) handlePacket(clientAddr *net.UDPAddr, packet []byte) {
 	// Extract and add CRYPTO frames from this packet
 	frames, err := ExtractCryptoFramesFromPacket(packet)
 	if err != nil {
-		debug.Printf(" CRYPTO extraction failed: %v", err)
+		debug.Notef(" CRYPTO extraction failed: %v", err)
 	} else {
 		debug.Printf(" extracted %d CRYPTO frames", len(frames))
 		for _, f := range frames {
@@ -451,17 +877,44 @@ func (p *Proxy) handlePacket(clientAddr *net.UDPAddr, packet []byte) {
 	// Clean up assembler
 	p.assemblers.Delete(dcidKey)
 
-	log.Printf("[proxy] new connection: SNI=%q DCID=%x", hello.SNI, dcid)
+	if hop != nil {
+		logger.Printf("new connection: SNI=%q DCID=%x via %s (session %d, client %s)", hello.SNI, dcid, hop.NodeID, hop.SessionID, privacy.Addr(hop.ClientAddr))
+	} else {
+		logger.Printf("new connection: SNI=%q DCID=%x", hello.SNI, dcid)
+	}
 
 	// Create context with DCID
 	newCtx := &handler.Context{
 		ClientAddr:    clientAddr,
 		InitialPacket: packet,
 		Hello:         hello,
-		ProxyConn:     p.conn,
+		ProxyConn:     conn,
+		Hop:           hop,
 	}
 	// Set session count for rate limiters
-	newCtx.Set("_session_count", p.sessionCount.Load())
+	newCtx.SessionCount = p.sessionCount.Load
+	p.setSessionSlot(newCtx)
+	handler.AssignFeatures(newCtx)
+	newCtx.SendConnectionClose = func(errorCode uint64, reason string) error {
+		closePkt, err := BuildInitialConnectionClose(newCtx.InitialPacket, errorCode, reason)
+		if err != nil {
+			return err
+		}
+		_, err = conn.WriteToUDP(closePkt, clientAddr)
+		return err
+	}
+	initialAck := sync.OnceValues(func() (*initialAcker, error) { return newInitialAcker(newCtx.InitialPacket) })
+	newCtx.SendInitialAck = func() error {
+		acker, err := initialAck()
+		if err != nil {
+			return err
+		}
+		ackPkt := acker.packet()
+		// Route the client's next packets, addressed to the acker's SCID, to this session
+		newCtx.NotifyServerPacket(ackPkt)
+		_, err = conn.WriteToUDP(ackPkt, clientAddr)
+		return err
+	}
 
 	// Set callback to learn server's SCID(s) from response packets
 	// This enables routing subsequent client packets that use server's CID
@@ -470,91 +923,112 @@ func (p *Proxy) handlePacket(clientAddr *net.UDPAddr, packet []byte) {
 	}
 
 	// Process through handler chain
+	attempt := p.beginAttempt(connectKey)
 	result := p.chain.Load().OnConnect(newCtx)
+	if result.Action == handler.Hold {
+		// The packet is held past this call and the buffer it is in. The
+		// attempt stays in flight, so retransmits are buffered meanwhile
+		newCtx.InitialPacket = bytes.Clone(packet)
+		result.Hold(func(result handler.Result) {
+			p.connected(newCtx, dcidKey, connectKey, attempt, result)
+		})
+		return
+	}
+	p.connected(newCtx, dcidKey, connectKey, attempt, result)
+}
+
+// connected finishes a new QUIC connection with the chain's decision.
+func (p *Proxy) connected(newCtx *handler.Context, dcidKey, connectKey string, attempt *connectAttempt, result handler.Result) {
+	conn, clientAddr, packet := newCtx.ProxyConn, newCtx.ClientAddr, newCtx.InitialPacket
 	if result.Action == handler.Drop {
+		newCtx.ReleaseSession()
+		p.endAttempt(connectKey, attempt, false)
 		if result.Error != nil {
-			log.Printf("[proxy] connection dropped: %v", result.Error)
+			logger.Printf("connection dropped: %v", result.Error)
 		}
+		p.respondDrop(conn, clientAddr, packet, newCtx, result)
 		return
 	}
 
 	if result.Action == handler.Handled && newCtx.Session != nil {
 		// Store DCID in session for future lookups
-		newCtx.Session.DCID = make([]byte, len(dcid))
-		copy(newCtx.Session.DCID, dcid)
+		dcid := []byte(dcidKey)
+		newCtx.Session.DCID = dcid
 
-		// Register DCID length for Short Header parsing
-		p.registerDCIDLength(len(dcid))
+		// Register DCID for Short Header parsing
+		p.store.AddCID(dcidKey, dcid)
 
 		// Store session by DCID
 		p.storeSession(dcidKey, newCtx)
 
 		// Also store by client address for fallback lookup
 		// (handles cases where client uses CIDs we don't know about)
-		clientKey := clientAddr.String()
-		p.clientSessions.Store(clientKey, dcidKey)
+		p.store.SetClientSession("", addrKey(clientAddr), dcidKey)
 
 		// Flush any packets that arrived before this Initial (out-of-order)
+		// or were retransmitted while the chain decided
 		p.flushPendingPackets(dcidKey, newCtx)
+		p.endAttempt(connectKey, attempt, true)
 
 		// Set DropSession callback for immediate session termination by handlers
 		newCtx.DropSession = func() {
+			newCtx.SetCloseReason(handler.CloseHandlerDrop)
 			p.chain.Load().OnDisconnect(newCtx)
 			p.deleteSession(dcidKey, newCtx)
 		}
+		return
 	}
+	newCtx.ReleaseSession()
+	p.endAttempt(connectKey, attempt, false)
+}
+
+// addrKey returns the session store key of a client address. IPv4-mapped
+// addresses are unmapped, so a client is the same key whichever socket saw
+// it. Unlike the address's string, building the key does not allocate.
+func addrKey(addr *net.UDPAddr) netip.AddrPort {
+	ap := addr.AddrPort()
+	return netip.AddrPortFrom(ap.Addr().Unmap(), ap.Port())
 }
 
 // findSession looks up a session by DCID.
 // For Long Header packets, DCID is extracted directly.
-// For Short Header packets, tries all known DCID lengths.
-// Also checks dcidAliases for server's SCID -> original DCID mapping.
+// For Short Header packets, the CIDs of the client address's session are tried
+// first, then all CID lengths in use (the client may have migrated).
+// Also finds sessions by the server's SCIDs learned as aliases.
 // Falls back to client address lookup if DCID-based lookups fail.
 func (p *Proxy) findSession(packet []byte, pktType PacketType, clientAddr *net.UDPAddr) (*handler.Context, []byte) {
 	if pktType == PacketShortHeader {
-		// Short Header: try all known DCID lengths (longest first to avoid prefix collisions)
-		p.dcidLengthsMu.RLock()
-		lengths := make([]int, 0, len(p.dcidLengths))
-		for l := range p.dcidLengths {
-			lengths = append(lengths, l)
+		// Session of this client address, if the packet carries one of its CIDs
+		// or its backend uses zero-length CIDs
+		var byAddr *handler.Context
+		if clientAddr != nil {
+			if key, ok := p.store.ClientSession("", addrKey(clientAddr)); ok {
+				if ctx, ok := p.store.Load(key); ok {
+					byAddr = ctx
+					if cid, ok := p.store.MatchCID(key, packet); ok {
+						return byAddr, cid
+					}
+				}
+			}
 		}
-		p.dcidLengthsMu.RUnlock()
-		sort.Sort(sort.Reverse(sort.IntSlice(lengths)))
 
-		for _, dcidLen := range lengths {
+		// Direct or alias lookup (server's SCID -> original DCID)
+		for _, dcidLen := range p.store.CIDLengths() {
 			dcid, err := ExtractDCID(packet, dcidLen)
 			if err != nil {
 				continue
 			}
-			dcidKey := string(dcid)
-
-			// Direct lookup
-			if val, ok := p.sessions.Load(dcidKey); ok {
-				return val.(*handler.Context), dcid
-			}
-
-			// Alias lookup (server's SCID -> original DCID)
-			if originalKey, ok := p.dcidAliases.Load(dcidKey); ok {
-				if val, ok := p.sessions.Load(originalKey.(string)); ok {
-					return val.(*handler.Context), dcid
-				}
+			if ctx, ok := p.store.LookupCID(dcid); ok {
+				return ctx, dcid
 			}
 		}
 
-		// Fallback: lookup by client address for Short Header packets too
+		// Fallback: the client address's session for CIDs we never saw
 		// This is needed when server issues NEW_CONNECTION_ID in encrypted frames
-		if clientAddr != nil {
-			clientKey := clientAddr.String()
-			if originalDCID, ok := p.clientSessions.Load(clientKey); ok {
-				debug.Printf(" findSession (short): trying client address fallback (%s)", clientKey)
-				if val, ok := p.sessions.Load(originalDCID.(string)); ok {
-					debug.Printf(" findSession (short): found session via client address")
-					return val.(*handler.Context), nil
-				}
-			}
+		if byAddr != nil {
+			debug.Printf(" findSession (short): found session via client address")
 		}
-
-		return nil, nil
+		return byAddr, nil
 	}
 
 	// Long Header: DCID length is in packet
@@ -563,38 +1037,26 @@ func (p *Proxy) findSession(packet []byte, pktType PacketType, clientAddr *net.U
 		debug.Printf(" findSession: failed to extract DCID: %v", err)
 		return nil, nil
 	}
-	dcidKey := string(dcid)
-
-	debug.Printf(" findSession: DCID=%x (len=%d)", dcid, len(dcid))
-
-	// Direct lookup
-	if val, ok := p.sessions.Load(dcidKey); ok {
-		debug.Printf(" findSession: found via direct lookup")
-		return val.(*handler.Context), dcid
+	if debug.IsEnabled() {
+		debug.Printf(" findSession: DCID=%x (len=%d)", dcid, len(dcid))
 	}
 
-	// Alias lookup (server's SCID -> original DCID)
-	if originalKey, ok := p.dcidAliases.Load(dcidKey); ok {
-		debug.Printf(" findSession: found alias -> %x", originalKey.(string))
-		if val, ok := p.sessions.Load(originalKey.(string)); ok {
-			debug.Printf(" findSession: found session via alias")
-			return val.(*handler.Context), dcid
-		}
-		debug.Printf(" findSession: alias found but session not found")
-	} else {
-		debug.Printf(" findSession: no alias found for DCID")
+	// Direct or alias lookup (server's SCID -> original DCID)
+	if ctx, ok := p.store.LookupCID(dcid); ok {
+		debug.Printf(" findSession: found via DCID")
+		return ctx, dcid
 	}
+	debug.Printf(" findSession: no session or alias for DCID")
 
 	// Fallback: lookup by client address
 	// This handles cases where client uses Connection IDs we don't know about
 	// (e.g., NEW_CONNECTION_ID issued by server in encrypted frames)
 	if clientAddr != nil {
-		clientKey := clientAddr.String()
-		if originalDCID, ok := p.clientSessions.Load(clientKey); ok {
-			debug.Printf(" findSession: trying client address fallback (%s)", clientKey)
-			if val, ok := p.sessions.Load(originalDCID.(string)); ok {
+		if originalDCID, ok := p.store.ClientSession("", addrKey(clientAddr)); ok {
+			debug.Printf(" findSession: trying client address fallback (%s)", privacy.Addr(clientAddr))
+			if ctx, ok := p.store.Load(originalDCID); ok {
 				debug.Printf(" findSession: found session via client address")
-				return val.(*handler.Context), dcid
+				return ctx, dcid
 			}
 		}
 	}
@@ -602,16 +1064,6 @@ func (p *Proxy) findSession(packet []byte, pktType PacketType, clientAddr *net.U
 	return nil, dcid
 }
 
-// registerDCIDLength adds a DCID length to the known lengths.
-func (p *Proxy) registerDCIDLength(length int) {
-	p.dcidLengthsMu.Lock()
-	if p.dcidLengths == nil {
-		p.dcidLengths = make(map[int]struct{})
-	}
-	p.dcidLengths[length] = struct{}{}
-	p.dcidLengthsMu.Unlock()
-}
-
 // learnServerSCID extracts server's SCID(s) from a Long Header response datagram
 // and registers them as aliases for the original DCID.
 // This enables routing subsequent client packets that use server's CID as DCID.
@@ -625,6 +1077,15 @@ func (p *Proxy) learnServerSCID(originalDCID string, ctx *handler.Context, datag
 		debug.Printf(" learnServerSCID: first 20 bytes: % x", hexDump)
 	}
 
+	if ctx.Session != nil && ctx.Session.IsClosed() {
+		return // Late packet of a closed session
+	}
+
+	// A backend using zero-length CIDs is only reachable by client address
+	if scid, err := ExtractSCID(datagram); err == nil && len(scid) == 0 {
+		p.store.AddCID(originalDCID, nil)
+	}
+
 	// Extract all SCIDs from potentially coalesced packets
 	scids := ExtractAllSCIDs(datagram)
 
@@ -634,23 +1095,28 @@ func (p *Proxy) learnServerSCID(originalDCID string, ctx *handler.Context, datag
 			continue // Same as original, no need for alias
 		}
 
-		// Check if we already have this alias (avoid duplicate logging)
-		if _, exists := p.dcidAliases.Load(scidKey); exists {
+		// Store alias: server's SCID -> original DCID, tracked for Short
+		// Header parsing. Known aliases are skipped (avoid duplicate logging)
+		if !p.store.AddAlias(originalDCID, scid) {
 			continue
 		}
 
-		// Store alias: server's SCID -> original DCID
-		p.dcidAliases.Store(scidKey, originalDCID)
-
-		// Track SCID length for Short Header parsing
-		p.registerDCIDLength(len(scid))
+		// With a shared stateless reset key, resets for this CID can be recognized
+		if r := p.resetter.Load(); r != nil {
+			ctx.AddResetToken(r.token(scid))
+		}
 
-		log.Printf("[proxy] learned server SCID=%x for session (original DCID=%x)", scid, []byte(originalDCID)[:min(8, len(originalDCID))])
+		logger.Printf("learned server SCID=%x for session (original DCID=%x)", scid, []byte(originalDCID)[:min(8, len(originalDCID))])
 	}
 }
 
-// Stop stops the proxy server gracefully.
+// Stop stops the proxy server gracefully. Safe to call more than once.
 func (p *Proxy) Stop() {
+	p.stopOnce.Do(p.stop)
+}
+
+// stop performs the shutdown sequence.
+func (p *Proxy) stop() {
 	// 1. Signal shutdown to stop accepting new packets
 	p.cancel()
 
@@ -658,22 +1124,94 @@ func (p *Proxy) Stop() {
 	if p.conn != nil {
 		p.conn.Close()
 	}
+	for _, conn := range p.extraConns {
+		conn.Close()
+	}
+	for _, in := range p.ingresses {
+		in.Close()
+	}
 
 	// 3. Drain worker pool - wait for in-flight packets to finish
 	if p.workerPool != nil {
 		p.workerPool.Stop()
 	}
 
-	// 4. Cleanup all sessions (now safe - no more packet processing)
-	p.sessions.Range(func(key, value any) bool {
-		ctx := value.(*handler.Context)
-		p.chain.Load().OnDisconnect(ctx)
-		p.deleteSession(key.(string), ctx)
+	// 4. Save sessions for the next start, then clean them up (now safe - no more packet processing)
+	if p.snapshotCfg != nil {
+		if err := p.writeSnapshot(); err != nil {
+			logger.Warnf("session snapshot failed: %v", err)
+		}
+	}
+	p.store.Range(func(key string, ctx *handler.Context) bool {
+		p.closeSession(key, ctx, handler.CloseDrain)
+		return true
+	})
+
+	// 5. Release handler resources, after the closed sessions were exported
+	p.stopFlowExport(p.flowExport.Swap(nil))
+	p.SetPacketSampling(nil)
+	p.SetRetention(nil)
+	p.chain.Load().Close()
+	if pipelines := p.pipelines.Load(); pipelines != nil {
+		for _, pl := range *pipelines {
+			pl.Close()
+		}
+	}
+	close(p.stopped)
+}
+
+// closeSession records the close reason, notifies the handler chain and removes the session.
+func (p *Proxy) closeSession(key string, ctx *handler.Context, reason handler.CloseReason) {
+	ctx.SetCloseReason(reason)
+	p.chain.Load().OnDisconnect(ctx)
+	p.deleteSession(key, ctx)
+}
+
+// idleLimit returns how long ctx's session may go without client packets.
+func (p *Proxy) idleLimit(ctx *handler.Context) time.Duration {
+	if ctx.Session.Unconfirmed() {
+		return restoreGrace
+	}
+	return handler.IdleTimeout(ctx, time.Duration(p.sessionTimeout.Load())*time.Second)
+}
+
+// checkIdle runs when the idle timer of the session stored under key fires.
+// It closes the session if it is idle, or rearms the timer for when it would
+// be: packets only update the session's last activity, not the timer.
+func (p *Proxy) checkIdle(key string, ctx *handler.Context) {
+	if v, ok := p.store.Load(key); !ok || v != ctx {
+		return
+	}
+	idle, limit := ctx.Session.IdleDuration(), p.idleLimit(ctx)
+	if idle <= limit {
+		// Last activity has a resolution of one second
+		ctx.IdleTimer.Reset(limit - idle + time.Second)
+		return
+	}
+	// Off the timer goroutine: OnDisconnect may block
+	go func() {
+		if ctx.Session.Unconfirmed() {
+			logger.Printf("restored session %d not confirmed by client, closing", ctx.Session.ID)
+		} else {
+			logger.Printf("cleaning up idle session: %s (idle %v)", key, idle)
+		}
+		p.closeSession(key, ctx, handler.CloseIdle)
+	}()
+}
+
+// recheckIdle makes the idle timers of all sessions fire, so a lowered
+// timeout applies to them right away.
+func (p *Proxy) recheckIdle() {
+	p.store.Range(func(_ string, ctx *handler.Context) bool {
+		if t := ctx.IdleTimer; t != nil {
+			t.Reset(0)
+		}
 		return true
 	})
 }
 
-// cleanupSessions periodically removes stale sessions and expired assemblers.
+// cleanupSessions periodically closes drained sessions and removes expired
+// assemblers. Idle sessions are closed by their idle timers.
 func (p *Proxy) cleanupSessions() {
 	ticker := time.NewTicker(cleanupInterval)
 	defer ticker.Stop()
@@ -683,19 +1221,7 @@ func (p *Proxy) cleanupSessions() {
 		case <-p.ctx.Done():
 			return
 		case <-ticker.C:
-			// Cleanup idle sessions
-			timeout := time.Duration(p.sessionTimeout.Load()) * time.Second
-			p.sessions.Range(func(key, value any) bool {
-				ctx := value.(*handler.Context)
-				if ctx.Session != nil {
-					if ctx.Session.IdleDuration() > timeout {
-						log.Printf("[proxy] cleaning up idle session: %s (idle %v)", key, ctx.Session.IdleDuration())
-						p.chain.Load().OnDisconnect(ctx)
-						p.deleteSession(key.(string), ctx)
-					}
-				}
-				return true
-			})
+			p.closeDrainedSessions(time.Now())
 
 			// Cleanup expired assemblers (prevents memory leaks)
 			assemblerCount := 0
@@ -711,7 +1237,7 @@ func (p *Proxy) cleanupSessions() {
 
 			// Aggressive cleanup if approaching assembler limit
 			if assemblerCount >= maxAssemblers*9/10 {
-				log.Printf("[proxy] assembler count %d approaching limit, cleaning up", assemblerCount)
+				logger.Warnf("assembler count %d approaching limit, cleaning up", assemblerCount)
 				p.assemblers.Range(func(key, value any) bool {
 					assembler := value.(*CryptoAssembler)
 					if assembler.IsComplete() || time.Since(assembler.createdAt) > 2*time.Second {
@@ -721,6 +1247,8 @@ func (p *Proxy) cleanupSessions() {
 				})
 			}
 
+			p.cleanupAttempts()
+
 			// Cleanup expired pending packet buffers
 			p.pendingPackets.Range(func(key, value any) bool {
 				buf := value.(*pendingBuffer)
@@ -737,7 +1265,7 @@ func (p *Proxy) cleanupSessions() {
 func (p *Proxy) handlerNames() []string {
 	var names []string
 	for _, h := range p.chain.Load().Handlers() {
-		names = append(names, h.Name())
+		names = append(names, handler.DisplayName(h))
 	}
 	return names
 }
@@ -748,16 +1276,179 @@ func (p *Proxy) SessionCount() int {
 	return int(p.sessionCount.Load())
 }
 
+// SessionInfo is a point-in-time description of a session for diagnostics.
+type SessionInfo struct {
+	ID            uint64            `json:"id"`
+	DCID          string            `json:"dcid"`
+	Protocol      string            `json:"protocol,omitempty"` // Empty for QUIC
+	SNI           string            `json:"sni,omitempty"`
+	RawSNI        string            `json:"original_sni,omitempty"` // Name the client sent, when sni-rewrite changed it
+	Client        string            `json:"client"`
+	Via           string            `json:"via,omitempty"` // Upstream relay node and session, for relayed connections
+	Backend       string            `json:"backend"`
+	Region        string            `json:"region,omitempty"` // Region chosen by client steering
+	Tenant        string            `json:"tenant,omitempty"`
+	Pipeline      string            `json:"pipeline,omitempty"` // Pipeline the connection was redirected to
+	Tags          map[string]string `json:"tags,omitempty"`     // Tags of the route
+	Features      []string          `json:"features,omitempty"` // Feature flags on for the session
+	Created       string            `json:"created"`
+	IdleSecs      int64             `json:"idle_seconds"`
+	Paused        bool              `json:"paused,omitempty"`        // Client packets held back (admin pause)
+	Deprioritized bool              `json:"deprioritized,omitempty"` // Source has a high reputation score
+
+	handler.SessionCounters
+}
+
+// Sessions returns a snapshot of all active sessions.
+func (p *Proxy) Sessions() []SessionInfo {
+	infos := make([]SessionInfo, 0, p.SessionCount())
+	p.store.Range(func(key string, ctx *handler.Context) bool {
+		if ctx.Session == nil {
+			return true
+		}
+		infos = append(infos, sessionInfo(ctx))
+		return true
+	})
+	return infos
+}
+
+// sessionInfo describes the session of ctx. ctx.Session must be set.
+func sessionInfo(ctx *handler.Context) SessionInfo {
+	info := SessionInfo{
+		ID:              ctx.Session.ID,
+		DCID:            fmt.Sprintf("%x", ctx.Session.DCID),
+		Protocol:        ctx.Protocol,
+		RawSNI:          ctx.GetString(handler.OriginalSNIKey),
+		Region:          ctx.GetString(handler.RegionKey),
+		Tenant:          ctx.GetString(handler.TenantKey),
+		Pipeline:        ctx.GetString(handler.PipelineKey),
+		Tags:            handler.RouteTags(ctx),
+		Features:        handler.Features(ctx),
+		Created:         ctx.Session.CreatedAt.Format(time.RFC3339),
+		IdleSecs:        int64(ctx.Session.IdleDuration().Seconds()),
+		Paused:          ctx.Session.Paused(),
+		Deprioritized:   handler.Deprioritized(ctx),
+		SessionCounters: ctx.Session.Counters(),
+	}
+	if ctx.Hello != nil {
+		info.SNI = ctx.Hello.SNI
+	}
+	if addr := ctx.Session.ClientAddr(); addr != nil {
+		info.Client = privacy.Addr(addr)
+	}
+	if ctx.Hop != nil {
+		info.Via = fmt.Sprintf("%s/%d", ctx.Hop.NodeID, ctx.Hop.SessionID)
+		if ctx.Hop.ClientAddr != nil {
+			info.Client = privacy.Addr(ctx.Hop.ClientAddr)
+		}
+	}
+	if addr := ctx.Session.BackendAddr(); addr != nil {
+		info.Backend = addr.String()
+	} else {
+		// Host names behind an upstream proxy are resolved by the proxy
+		info.Backend, _ = handler.ParseRelayBackend(ctx.GetString("backend"))
+	}
+	return info
+}
+
+// KillSession terminates the session with the given ID.
+// Returns false if no such session exists.
+func (p *Proxy) KillSession(id uint64) bool {
+	key, ctx, ok := p.sessionByID(id)
+	if !ok {
+		return false
+	}
+	logger.Printf("killing session %d (admin)", id)
+	p.closeSession(key, ctx, handler.CloseAdminKill)
+	return true
+}
+
+// SessionTrace returns the recent packets and events of the session with the
+// given ID, oldest first.
+func (p *Proxy) SessionTrace(id uint64) ([]debug.Event, error) {
+	_, ctx, ok := p.sessionByID(id)
+	if !ok {
+		return nil, ErrSessionNotFound
+	}
+	return ctx.Session.Trace(), nil
+}
+
+// Handlers returns the handlers of the active chain.
+func (p *Proxy) Handlers() []handler.Handler {
+	return p.chain.Load().Handlers()
+}
+
+// HandlerConfigs returns the configs the active chain was built from, or nil
+// if it was not built from config.
+func (p *Proxy) HandlerConfigs() []handler.HandlerConfig {
+	return p.chain.Load().Configs()
+}
+
+// Stats is a summary of proxy state for diagnostics.
+type Stats struct {
+	Sessions       int    `json:"sessions"`
+	QueuedPackets  int    `json:"queued_packets"`
+	DroppedPackets uint64 `json:"dropped_packets"` // Dropped because worker queues were full
+
+	RetransmittedInitials uint64 `json:"retransmitted_initials"` // Initials of a connection attempt in flight or just dropped
+	KeepAlives            uint64 `json:"keepalives"`             // Keep-alives sent to clients of idle sessions
+	Timers                int    `json:"timers"`                 // Pending idle timeouts, keep-alives and delayed packets
+
+	Overload        handler.OverloadStats          `json:"overload"`          // Backend -> client packets dropped or delayed
+	ClientMigration ClientMigrationStats           `json:"client_migration"`  // Client address changes
+	SlowStart       SlowStartStats                 `json:"slow_start"`        // Admission ramp after startup and maintenance
+	FlowExport      FlowExportStats                `json:"flow_export"`       // Flow records sent to the collector
+	PacketSampling  PacketSamplingStats            `json:"packet_sampling"`   // Sampled datagrams
+	Retention       RetentionStats                 `json:"retention"`         // Old files deleted
+	Tenants         map[string]handler.TenantStats `json:"tenants,omitempty"` // Per-tenant counters (tenants handler)
+}
+
+// Stats returns current proxy counters.
+func (p *Proxy) Stats() Stats {
+	st := Stats{
+		Sessions:              p.SessionCount(),
+		RetransmittedInitials: p.retransmittedInitials.Load(),
+		KeepAlives:            handler.KeepAlivesSent(),
+		Timers:                timerwheel.Len(),
+		Overload:              handler.GetOverloadStats(),
+		ClientMigration:       p.ClientMigrationStats(),
+		SlowStart:             p.SlowStartStats(),
+		FlowExport:            p.FlowExportStats(),
+		PacketSampling:        p.PacketSamplingStats(),
+		Retention:             p.RetentionStats(),
+		Tenants:               handler.GetTenantStats(),
+	}
+	if p.workerPool != nil {
+		st.QueuedPackets = p.workerPool.QueueSize()
+		st.DroppedPackets = p.workerPool.Dropped()
+	}
+	return st
+}
+
 // deleteSession removes a session and decrements the counter.
 // Note: DCID aliases are cleaned up by timeout-based cleanup.
 func (p *Proxy) deleteSession(key string, ctx *handler.Context) {
-	if _, loaded := p.sessions.LoadAndDelete(key); loaded {
+	if _, loaded := p.store.Delete(key); loaded {
 		p.sessionCount.Add(-1)
+		if ctx != nil && ctx.ReleaseSession != nil {
+			ctx.ReleaseSession()
+		}
+		p.events.publishSession(EventClose, ctx)
+		p.exportFlow(ctx, true)
+		if ctx != nil && ctx.IdleTimer != nil {
+			ctx.IdleTimer.Stop()
+		}
+		if ctx != nil && ctx.Session != nil {
+			if m, ok := p.sessionMoves.LoadAndDelete(ctx.Session.ID); ok {
+				m.(*sessionMoves).stop()
+			}
+		}
 
 		// O(1) - directly delete using known client address from context
-		if ctx != nil && ctx.Session != nil {
+		// (restored QUIC sessions have no entry until confirmed)
+		if ctx != nil && ctx.Session != nil && (ctx.Protocol != "" || !ctx.Session.Unconfirmed()) {
 			if clientAddr := ctx.Session.ClientAddr(); clientAddr != nil {
-				p.clientSessions.Delete(clientAddr.String())
+				p.store.DeleteClientSession(ctx.Protocol, addrKey(clientAddr))
 			}
 		}
 	}
@@ -768,13 +1459,20 @@ func (p *Proxy) deleteSession(key string, ctx *handler.Context) {
 func (p *Proxy) storeSession(key string, ctx *handler.Context) {
 	// O(1) increment
 	count := p.sessionCount.Add(1)
+	p.takeSessionSlot(ctx)
 
 	// Approaching limit - cleanup oldest 10%
 	if count >= maxSessions*9/10 {
 		p.cleanupOldestSessions(int(count) / 10)
 	}
 
-	p.sessions.Store(key, ctx)
+	ctx.IdleTimer = timerwheel.NewTimer(func() { p.checkIdle(key, ctx) })
+	ctx.IdleTimer.Reset(p.idleLimit(ctx))
+	p.store.Store(key, ctx)
+	p.events.publishSession(EventOpen, ctx)
+	if p.replay != nil {
+		p.replay.opened(ctx)
+	}
 }
 
 // bufferPendingPacket stores a packet that arrived before its session existed.
@@ -819,6 +1517,16 @@ func (p *Proxy) flushPendingPackets(dcidKey string, ctx *handler.Context) {
 type sessionAge struct {
 	key  string
 	idle time.Duration
+	low  bool // Deprioritized by the reputation handler
+}
+
+// older reports whether a is evicted before b: deprioritized sessions first,
+// then the longest idle.
+func (a sessionAge) older(b sessionAge) bool {
+	if a.low != b.low {
+		return a.low
+	}
+	return a.idle > b.idle
 }
 
 // sessionHeap implements heap.Interface for finding N oldest sessions.
@@ -826,7 +1534,7 @@ type sessionAge struct {
 type sessionHeap []sessionAge
 
 func (h sessionHeap) Len() int           { return len(h) }
-func (h sessionHeap) Less(i, j int) bool { return h[i].idle < h[j].idle } // Min-heap
+func (h sessionHeap) Less(i, j int) bool { return h[j].older(h[i]) } // Min-heap
 func (h sessionHeap) Swap(i, j int)      { h[i], h[j] = h[j], h[i] }
 
 func (h *sessionHeap) Push(x any) {
@@ -852,20 +1560,20 @@ func (p *Proxy) cleanupOldestSessions(n int) {
 	h := &sessionHeap{}
 	heap.Init(h)
 
-	p.sessions.Range(func(key, value any) bool {
-		ctx := value.(*handler.Context)
+	p.store.Range(func(key string, ctx *handler.Context) bool {
 		if ctx.Session == nil {
 			return true
 		}
 
 		age := sessionAge{
-			key:  key.(string),
+			key:  key,
 			idle: ctx.Session.IdleDuration(),
+			low:  handler.Deprioritized(ctx),
 		}
 
 		if h.Len() < n {
 			heap.Push(h, age)
-		} else if age.idle > (*h)[0].idle {
+		} else if age.older((*h)[0]) {
 			// This session is older than the youngest in our top-N
 			heap.Pop(h)
 			heap.Push(h, age)
@@ -877,15 +1585,13 @@ func (p *Proxy) cleanupOldestSessions(n int) {
 	removed := 0
 	for h.Len() > 0 {
 		age := heap.Pop(h).(sessionAge)
-		if val, ok := p.sessions.Load(age.key); ok {
-			ctx := val.(*handler.Context)
-			p.chain.Load().OnDisconnect(ctx)
-			p.deleteSession(age.key, ctx)
+		if ctx, ok := p.store.Load(age.key); ok {
+			p.closeSession(age.key, ctx, handler.CloseEvicted)
 			removed++
 		}
 	}
 
 	if removed > 0 {
-		log.Printf("[proxy] cleaned up %d oldest sessions (approaching limit)", removed)
+		logger.Warnf("cleaned up %d oldest sessions (approaching limit)", removed)
 	}
 }
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"net"
 	"quic-relay/internal/handler"
 	"testing"
 	"time"
@@ -154,6 +155,24 @@ func TestSessionHeap(t *testing.T) {
 	}
 }
 
+func TestSessionAgeOlder(t *testing.T) {
+	tests := []struct {
+		a, b sessionAge
+		want bool
+	}{
+		{sessionAge{idle: time.Minute}, sessionAge{idle: time.Second}, true},
+		{sessionAge{idle: time.Second}, sessionAge{idle: time.Minute}, false},
+		{sessionAge{idle: time.Second, low: true}, sessionAge{idle: time.Minute}, true},
+		{sessionAge{idle: time.Hour}, sessionAge{idle: time.Second, low: true}, false},
+		{sessionAge{idle: time.Minute, low: true}, sessionAge{idle: time.Second, low: true}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.a.older(tt.b); got != tt.want {
+			t.Errorf("%+v.older(%+v) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
 func TestBufferPool(t *testing.T) {
 	// Get buffer
 	buf1 := handler.GetBuffer()
@@ -178,3 +197,39 @@ func TestBufferPool(t *testing.T) {
 	// PutBuffer with nil should not panic
 	handler.PutBuffer(nil)
 }
+
+func TestRun_InheritedListener(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New("invalid address", handler.NewChain())
+	if err := p.Healthy(); err == nil {
+		t.Error("healthy before Run")
+	}
+	p.SetListeners([]*net.UDPConn{conn})
+
+	done := make(chan error, 1)
+	go func() { done <- p.Run() }()
+	select {
+	case <-p.Ready():
+	case err := <-done:
+		t.Fatalf("Run: %v", err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("proxy not ready")
+	}
+	if p.conn != conn {
+		t.Error("inherited listener not used")
+	}
+	if err := p.Healthy(); err != nil {
+		t.Errorf("Healthy: %v", err)
+	}
+
+	p.Stop()
+	if err := <-done; err != nil {
+		t.Errorf("Run: %v", err)
+	}
+	if err := p.Healthy(); err == nil {
+		t.Error("healthy after Stop")
+	}
+}
@@ -0,0 +1,61 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"strings"
+)
+
+// RelayConfig configures acceptance of connections relayed by other quic-relay
+// instances (relay:// backends on the upstream side).
+type RelayConfig struct {
+	AcceptFrom []string `json:"accept_from"` // CIDRs or IPs of upstream relays
+}
+
+// SetRelayConfig replaces the trusted upstream relays (hot-reload safe).
+// With a nil config, hop headers are not accepted.
+func (p *Proxy) SetRelayConfig(cfg *RelayConfig) error {
+	nets, err := parseRelayConfig(cfg)
+	if err != nil {
+		return err
+	}
+	p.trustedRelays.Store(nets)
+	return nil
+}
+
+// parseRelayConfig returns the networks of cfg's trusted relays, or nil for a
+// nil cfg.
+func parseRelayConfig(cfg *RelayConfig) (*[]*net.IPNet, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	nets := make([]*net.IPNet, 0, len(cfg.AcceptFrom))
+	for _, s := range cfg.AcceptFrom {
+		if !strings.Contains(s, "/") {
+			if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+				s += "/32"
+			} else {
+				s += "/128"
+			}
+		}
+		_, n, err := net.ParseCIDR(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid relay accept_from %q: %w", s, err)
+		}
+		nets = append(nets, n)
+	}
+	return &nets, nil
+}
+
+func (p *Proxy) isTrustedRelay(addr *net.UDPAddr) bool {
+	nets := p.trustedRelays.Load()
+	if nets == nil {
+		return false
+	}
+	for _, n := range *nets {
+		if n.Contains(addr.IP) {
+			return true
+		}
+	}
+	return false
+}
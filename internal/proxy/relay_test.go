@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+// startTestProxy runs a proxy with its own UDP listener and returns its address.
+func startTestProxy(t *testing.T, chain *handler.Chain) (*Proxy, *net.UDPAddr) {
+	t.Helper()
+	return startTestProxyOn(t, chain, &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+}
+
+// startTestProxyOn runs a proxy listening on addr. setup runs before packets are read.
+func startTestProxyOn(t *testing.T, chain *handler.Chain, addr *net.UDPAddr, setup func(*Proxy)) (*Proxy, *net.UDPAddr) {
+	t.Helper()
+	p := New(addr.String(), chain)
+	conn, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.conn = conn
+	if setup != nil {
+		setup(p)
+	}
+	p.workerPool = newItemWorkerPool(1, 100, p.processItem)
+	p.workerPool.Start()
+	go p.readLoop(conn)
+	t.Cleanup(p.Stop)
+	return p, conn.LocalAddr().(*net.UDPAddr)
+}
+
+func TestSetRelayConfig(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	addr := &net.UDPAddr{IP: net.IPv4(10, 1, 2, 3)}
+	if p.isTrustedRelay(addr) {
+		t.Error("trusted without config")
+	}
+	if err := p.SetRelayConfig(&RelayConfig{AcceptFrom: []string{"10.0.0.0/8", "192.0.2.1", "2001:db8::1"}}); err != nil {
+		t.Fatal(err)
+	}
+	if !p.isTrustedRelay(addr) || !p.isTrustedRelay(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 1)}) {
+		t.Error("configured relay not trusted")
+	}
+	if p.isTrustedRelay(&net.UDPAddr{IP: net.IPv4(192, 0, 2, 2)}) {
+		t.Error("unexpected trust")
+	}
+	if err := p.SetRelayConfig(&RelayConfig{AcceptFrom: []string{"nonsense"}}); err == nil {
+		t.Error("expected error")
+	}
+}
+
+func TestRelayChaining(t *testing.T) {
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer backend.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteToUDP(append([]byte("re:"), buf[:n]...), addr)
+		}
+	}()
+
+	newChain := func(route string) *handler.Chain {
+		router, err := handler.NewProtocolRouterHandler(json.RawMessage(`{"routes": {"echo": "` + route + `"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fwd, err := handler.NewForwarderHandler(json.RawMessage(`{"node_id": "edge-1"}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return handler.NewChain(router, fwd)
+	}
+	rules := []ProtocolRule{{Name: "echo", Prefix: "6563686f"}}
+
+	regional, regionalAddr := startTestProxy(t, newChain(backend.LocalAddr().String()))
+	regional.SetProtocols(rules)
+	if err := regional.SetRelayConfig(&RelayConfig{AcceptFrom: []string{"127.0.0.1"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	edge, edgeAddr := startTestProxy(t, newChain("relay://"+regionalAddr.String()))
+	edge.SetProtocols(rules)
+
+	client, err := net.DialUDP("udp", nil, edgeAddr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+
+	for _, msg := range []string{"echo 1", "echo 2"} {
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1500)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(buf[:n]); got != "re:"+msg {
+			t.Errorf("reply = %q", got)
+		}
+	}
+
+	sessions := regional.Sessions()
+	if len(sessions) != 1 {
+		t.Fatalf("regional sessions = %+v", sessions)
+	}
+	if sessions[0].Via != "edge-1/1" || sessions[0].Client != client.LocalAddr().String() {
+		t.Errorf("regional session = %+v, want via edge-1/1 from %s", sessions[0], client.LocalAddr())
+	}
+}
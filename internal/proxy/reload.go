@@ -0,0 +1,170 @@
+package proxy
+
+import (
+	"fmt"
+
+	"quic-relay/internal/audit"
+	"quic-relay/internal/handler"
+	"quic-relay/internal/logging"
+	"quic-relay/internal/metrics"
+	"quic-relay/internal/privacy"
+)
+
+// Reload applies every setting of cfg that can change at runtime: the
+// handler chain, process-wide settings (logging, privacy, audit, exporters,
+// feature flags) and the hot-reload safe settings of the proxy. Everything is
+// validated and built before anything is applied, so if any part of cfg is
+// invalid, Reload returns an error and the relay keeps its previous
+// configuration.
+func (p *Proxy) Reload(cfg *Config) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	apply, _, err := p.prepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	apply()
+	p.config.Store(cfg)
+	return nil
+}
+
+// CheckConfig reports whether Reload would accept cfg, without applying it.
+func (p *Proxy) CheckConfig(cfg *Config) error {
+	p.reloadMu.Lock()
+	defer p.reloadMu.Unlock()
+	_, discard, err := p.prepareReload(cfg)
+	if err != nil {
+		return err
+	}
+	discard()
+	return nil
+}
+
+// Config returns the config the proxy was created from or last reloaded
+// with, with the handlers of the active chain, which may have been changed
+// through the admin API since. It returns nil if the proxy or its chain was
+// not built from config.
+func (p *Proxy) Config() *Config {
+	cfg := p.config.Load()
+	handlers := p.HandlerConfigs()
+	if cfg == nil || handlers == nil {
+		return nil
+	}
+	c := *cfg
+	c.Handlers = handlers
+	return &c
+}
+
+// prepareReload validates cfg and builds what it needs without changing
+// anything. apply then makes all of it active and cannot fail; discard
+// releases it instead. On error, what was built is already released.
+func (p *Proxy) prepareReload(cfg *Config) (apply, discard func(), err error) {
+	var discards []func()
+	release := func() {
+		for _, d := range discards {
+			d()
+		}
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+
+	chain, err := handler.BuildChain(cfg.Handlers)
+	if err != nil {
+		return nil, nil, err
+	}
+	discards = append(discards, chain.Close)
+	applyLog, closeSink, err := logging.Prepare(cfg.Log)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid log config: %w", err)
+	}
+	discards = append(discards, closeSink)
+	applyPrivacy, err := privacy.Prepare(cfg.Privacy)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid privacy config: %w", err)
+	}
+	applyAudit, closeAudit, err := audit.Prepare(cfg.Audit)
+	if err != nil {
+		return nil, nil, err
+	}
+	discards = append(discards, closeAudit)
+	applyExporters, closeExporters, err := metrics.PrepareExporters(cfg.Exporters)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid exporters config: %w", err)
+	}
+	discards = append(discards, closeExporters)
+	applyFeatures, err := handler.PrepareFeatureFlags(cfg.FeatureFlags)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid feature_flags config: %w", err)
+	}
+	protocols, err := compileProtocolRules(cfg.Protocols)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid protocol rules: %w", err)
+	}
+	relays, err := parseRelayConfig(cfg.Relay)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid relay config: %w", err)
+	}
+	resetter, err := newStatelessResetter(cfg.StatelessReset)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid stateless reset config: %w", err)
+	}
+	migration, err := newMigrationPolicy(cfg.ClientMigration)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid client_migration config: %w", err)
+	}
+	violations, err := newViolationPolicy(cfg.Violations)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid violations config: %w", err)
+	}
+	pipelines, err := buildPipelines(cfg.Pipelines)
+	if err != nil {
+		return nil, nil, err
+	}
+	discards = append(discards, pipelines.close)
+	slowStart, err := newSlowStartPolicy(cfg.SlowStart)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid slow_start config: %w", err)
+	}
+	flowExport, err := p.newFlowExporter(cfg.FlowExport)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid flow_export config: %w", err)
+	}
+	if flowExport != nil {
+		discards = append(discards, func() { flowExport.conn.Close() })
+	}
+	sampler, err := p.newPacketSampler(cfg.PacketSampling)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid packet_sampling config: %w", err)
+	}
+	if sampler != nil {
+		discards = append(discards, sampler.close)
+	}
+	janitor, err := p.newJanitor(cfg.Retention)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid retention config: %w", err)
+	}
+
+	apply = func() {
+		applyLog()
+		applyPrivacy()
+		applyAudit()
+		applyExporters()
+		applyFeatures()
+		p.protocols.Store(&protocols)
+		p.trustedRelays.Store(relays)
+		p.resetter.Store(resetter)
+		p.migration.Store(migration)
+		p.violations.Store(violations)
+		p.storePipelines(pipelines)
+		p.slowStartPolicy.Store(slowStart)
+		p.startFlowExport(flowExport)
+		p.startSampler(sampler)
+		p.startJanitor(janitor)
+		p.ReloadChain(chain)
+		p.SetSessionTimeout(cfg.SessionTimeout)
+	}
+	return apply, release, nil
+}
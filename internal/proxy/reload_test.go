@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"strings"
+	"testing"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/retention"
+)
+
+func TestReload(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	cfg := &Config{
+		Handlers:       []handler.HandlerConfig{{Type: "logsni"}},
+		Pipelines:      map[string]PipelineConfig{"inspect": {{Type: "logsni"}}},
+		SlowStart:      &SlowStartConfig{Duration: 10, To: 100},
+		SessionTimeout: 30,
+		Retention:      &retention.Config{Interval: -1},
+	}
+
+	// An invalid setting after valid ones leaves everything as it was
+	err := p.Reload(cfg)
+	if err == nil || !strings.Contains(err.Error(), "retention") {
+		t.Fatalf("error = %v", err)
+	}
+	if len(p.Handlers()) != 0 || p.pipeline("inspect") != nil || p.slowStartPolicy.Load() != nil || p.sessionTimeout.Load() == 30 {
+		t.Error("invalid config partly applied")
+	}
+
+	cfg.Retention = nil
+	if err := p.Reload(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Handlers()) != 1 || p.pipeline("inspect") == nil || p.slowStartPolicy.Load() == nil || p.sessionTimeout.Load() != 30 {
+		t.Error("config not applied")
+	}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/pcap"
+)
+
+// ReplayOptions controls how a capture is replayed.
+type ReplayOptions struct {
+	// Ports are the relay ports in the capture; datagrams sent to them are
+	// replayed as client traffic. Default: the listen and extra_listen ports.
+	Ports []uint16
+	// Speed scales the capture timing: 1 replays in real time, 2 twice as
+	// fast. 0 replays as fast as possible.
+	Speed float64
+	// OnDecision is called for every connection the chain accepts or drops.
+	OnDecision func(ReplayDecision)
+}
+
+// ReplayDecision is the handler chain's decision on a new connection.
+type ReplayDecision struct {
+	Time     time.Time         `json:"time"` // Capture time of the datagram that led to it
+	Client   string            `json:"client"`
+	SNI      string            `json:"sni,omitempty"`
+	Protocol string            `json:"protocol,omitempty"`
+	Accepted bool              `json:"accepted"`
+	Backend  string            `json:"backend,omitempty"`
+	Tenant   string            `json:"tenant,omitempty"`
+	Tags     map[string]string `json:"tags,omitempty"`
+	Reason   string            `json:"reason,omitempty"` // Why the connection was dropped
+}
+
+// ReplayReport summarises a replay.
+type ReplayReport struct {
+	Datagrams   int            `json:"datagrams"` // Client datagrams replayed
+	Bytes       int64          `json:"bytes"`
+	Ignored     int            `json:"ignored"` // UDP datagrams not sent to a relay port
+	Skipped     int            `json:"skipped"` // Capture records that are not UDP
+	Accepted    int            `json:"accepted"`
+	Dropped     int            `json:"dropped"`
+	PacketDrops int            `json:"packet_drops"` // Packets of accepted connections dropped
+	Responses   int            `json:"responses"`    // Datagrams the relay would have sent to clients
+	Backends    map[string]int `json:"backends,omitempty"`
+	DropReasons map[string]int `json:"drop_reasons,omitempty"`
+
+	Elapsed         time.Duration `json:"elapsed_ns"` // Time spent in the relay, without pacing
+	DatagramsPerSec float64       `json:"datagrams_per_sec"`
+	Mbps            float64       `json:"mbps"`
+}
+
+// ReplayChain builds the handler chain for a replay: the forwarder and the
+// terminator are replaced by a sink, so no backend sockets are opened.
+// Handlers that contact backends themselves (latency probes, raknet pings)
+// still do.
+func ReplayChain(configs []handler.HandlerConfig) (*handler.Chain, error) {
+	configs = append([]handler.HandlerConfig(nil), configs...)
+	for i, cfg := range configs {
+		if cfg.Type == "forwarder" || cfg.Type == "terminator" {
+			configs[i] = handler.HandlerConfig{Type: "sink", OnDrop: cfg.OnDrop}
+		}
+	}
+	return handler.BuildChain(configs)
+}
+
+// replayRecorder collects decisions while a capture is replayed.
+type replayRecorder struct {
+	report     *ReplayReport
+	onDecision func(ReplayDecision)
+	now        time.Time // Capture time of the datagram being replayed
+}
+
+func (r *replayRecorder) decide(ctx *handler.Context, accepted bool, reason string) {
+	d := ReplayDecision{
+		Time:     r.now,
+		Client:   ctx.OriginalClientAddr().String(),
+		Protocol: ctx.Protocol,
+		Accepted: accepted,
+		Backend:  ctx.GetString("backend"),
+		Tenant:   ctx.GetString(handler.TenantKey),
+		Tags:     handler.RouteTags(ctx),
+		Reason:   reason,
+	}
+	if ctx.Hello != nil {
+		d.SNI = ctx.Hello.SNI
+	}
+	if accepted {
+		r.report.Accepted++
+		r.report.Backends[d.Backend]++
+	} else {
+		r.report.Dropped++
+		r.report.DropReasons[reason]++
+	}
+	if r.onDecision != nil {
+		r.onDecision(d)
+	}
+}
+
+// opened records a connection the chain accepted.
+func (r *replayRecorder) opened(ctx *handler.Context) {
+	r.decide(ctx, true, "")
+}
+
+// dropped records a connection or packet the chain dropped.
+func (r *replayRecorder) dropped(ctx *handler.Context, result handler.Result) {
+	if ctx == nil {
+		return
+	}
+	if ctx.Session != nil {
+		r.report.PacketDrops++
+		return
+	}
+	r.decide(ctx, false, dropReason(result))
+}
+
+func dropReason(result handler.Result) string {
+	if result.Error != nil {
+		return result.Error.Error()
+	}
+	return "no reason given"
+}
+
+// replayConn stands in for a listener socket; responses are counted, not sent.
+type replayConn struct {
+	local  *net.UDPAddr
+	report *ReplayReport
+}
+
+func (c *replayConn) WriteToUDP(b []byte, addr *net.UDPAddr) (int, error) {
+	c.report.Responses++
+	return len(b), nil
+}
+
+func (c *replayConn) LocalAddr() net.Addr { return c.local }
+
+// Replay feeds the client datagrams of a capture through the handler chain,
+// the same way the relay handles live traffic, and reports what the chain
+// decided. Nothing is sent: client responses are counted and backends are
+// not contacted when the chain comes from ReplayChain. The proxy must not be
+// running; it cannot be used after Replay returns.
+func (p *Proxy) Replay(r *pcap.Reader, opts ReplayOptions) (*ReplayReport, error) {
+	ports := opts.Ports
+	if len(ports) == 0 {
+		for _, addr := range append([]string{p.listenAddr}, p.extraAddrs...) {
+			if port, err := listenPort(addr); err == nil {
+				ports = append(ports, port)
+			}
+		}
+	}
+	if len(ports) == 0 {
+		return nil, errors.New("no relay ports to replay")
+	}
+
+	report := &ReplayReport{Backends: map[string]int{}, DropReasons: map[string]int{}}
+	rec := &replayRecorder{report: report, onDecision: opts.OnDecision}
+	p.replay = rec
+	handler.StartCoarseClock(p.ctx)
+	defer p.finishReplay()
+
+	conns := make(map[netip.AddrPort]*replayConn)
+	var first time.Time
+	var start time.Time
+	for {
+		d, err := r.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(ports, d.Dst.Port()) {
+			report.Ignored++
+			continue
+		}
+
+		if opts.Speed > 0 {
+			if first.IsZero() {
+				first, start = d.Time, time.Now()
+			}
+			due := start.Add(time.Duration(float64(d.Time.Sub(first)) / opts.Speed))
+			if wait := time.Until(due); wait > 0 {
+				time.Sleep(wait)
+			}
+		}
+
+		conn, ok := conns[d.Dst]
+		if !ok {
+			conn = &replayConn{local: net.UDPAddrFromAddrPort(d.Dst), report: report}
+			conns[d.Dst] = conn
+		}
+		rec.now = d.Time
+		report.Datagrams++
+		report.Bytes += int64(len(d.Payload))
+
+		began := time.Now()
+		p.handlePacket(conn, net.UDPAddrFromAddrPort(d.Src), d.Payload)
+		report.Elapsed += time.Since(began)
+	}
+	report.Skipped = r.Skipped
+
+	if secs := report.Elapsed.Seconds(); secs > 0 {
+		report.DatagramsPerSec = float64(report.Datagrams) / secs
+		report.Mbps = float64(report.Bytes) * 8 / secs / 1e6
+	}
+	return report, nil
+}
+
+// finishReplay ends the sessions left at the end of a capture and closes the chain.
+func (p *Proxy) finishReplay() {
+	p.store.Range(func(key string, ctx *handler.Context) bool {
+		p.closeSession(key, ctx, handler.CloseDrain)
+		return true
+	})
+	p.chain.Load().Close()
+	p.cancel()
+}
+
+// listenPort returns the port of a listen address such as ":5520".
+func listenPort(addr string) (uint16, error) {
+	_, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return 0, err
+	}
+	n, err := strconv.ParseUint(port, 10, 16)
+	return uint16(n), err
+}
@@ -0,0 +1,100 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/netip"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/pcap"
+)
+
+func TestReplay(t *testing.T) {
+	var capture bytes.Buffer
+	w, err := pcap.NewWriter(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	relay := netip.MustParseAddrPort("192.0.2.1:5520")
+	client1 := netip.MustParseAddrPort("198.51.100.1:40000")
+	client2 := netip.MustParseAddrPort("198.51.100.2:40000")
+	start := time.Unix(1700000000, 0)
+	for i, d := range []pcap.Datagram{
+		{Src: client1, Dst: relay, Payload: []byte("echo 1")},
+		{Src: client1, Dst: relay, Payload: []byte("echo 2")},
+		{Src: client2, Dst: relay, Payload: []byte("ping")},
+		{Src: client1, Dst: netip.MustParseAddrPort("192.0.2.1:53"), Payload: []byte("dns")},
+		{Src: relay, Dst: client1, Payload: []byte("echo:echo 1")},
+	} {
+		d.Time = start.Add(time.Duration(i) * time.Millisecond)
+		if err := w.Write(d); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	chain, err := ReplayChain([]handler.HandlerConfig{
+		{Type: "protocol-router", Config: json.RawMessage(`{"routes": {"echo": "10.0.0.1:7000"}}`)},
+		{Type: "forwarder"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := chain.Handlers(); names[len(names)-1].Name() != "sink" {
+		t.Fatalf("forwarder not replaced: last handler is %s", names[len(names)-1].Name())
+	}
+
+	p := New(":5520", chain)
+	if err := p.SetProtocols([]ProtocolRule{
+		{Name: "echo", Prefix: "6563686f"},
+		{Name: "ping", Prefix: "70696e67"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := pcap.NewReader(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var decisions []ReplayDecision
+	report, err := p.Replay(r, ReplayOptions{OnDecision: func(d ReplayDecision) { decisions = append(decisions, d) }})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if report.Datagrams != 3 || report.Ignored != 2 {
+		t.Errorf("datagrams = %d, ignored = %d, want 3 and 2", report.Datagrams, report.Ignored)
+	}
+	if report.Accepted != 1 || report.Dropped != 1 {
+		t.Errorf("accepted = %d, dropped = %d, want 1 and 1", report.Accepted, report.Dropped)
+	}
+	if report.Backends["10.0.0.1:7000"] != 1 {
+		t.Errorf("backends = %v", report.Backends)
+	}
+	if report.DropReasons["no route for protocol ping"] != 1 {
+		t.Errorf("drop reasons = %v", report.DropReasons)
+	}
+	if len(decisions) != 2 || !decisions[0].Accepted || decisions[0].Client != client1.String() || decisions[0].Protocol != "echo" {
+		t.Errorf("decisions = %+v", decisions)
+	}
+	if !decisions[0].Time.Equal(start) {
+		t.Errorf("decision time = %v, want capture time %v", decisions[0].Time, start)
+	}
+	if n := p.SessionCount(); n != 0 {
+		t.Errorf("sessions left after replay = %d", n)
+	}
+}
+
+func TestReplay_NoPorts(t *testing.T) {
+	p := New("", handler.NewChain())
+	var capture bytes.Buffer
+	pcap.NewWriter(&capture)
+	r, err := pcap.NewReader(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.Replay(r, ReplayOptions{}); err == nil {
+		t.Error("expected error without relay ports")
+	}
+}
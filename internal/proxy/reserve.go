@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"math"
+	"sync/atomic"
+
+	"quic-relay/internal/handler"
+)
+
+// sessionSlot is a connection's claim on one of the session slots limited
+// through Context.TryAcquireSession.
+type sessionSlot struct {
+	p    *Proxy
+	held atomic.Bool
+}
+
+// tryAcquire takes a slot unless limit slots are taken. A connection that
+// holds a slot only checks the limit.
+func (s *sessionSlot) tryAcquire(limit int64) bool {
+	p := s.p
+	if s.held.Load() {
+		return p.sessionSlots.Load() <= limit
+	}
+	for {
+		n := p.sessionSlots.Load()
+		if n >= limit {
+			return false
+		}
+		if p.sessionSlots.CompareAndSwap(n, n+1) {
+			s.held.Store(true)
+			return true
+		}
+	}
+}
+
+// release gives the slot back. It is safe to call more than once.
+func (s *sessionSlot) release() {
+	if s.held.CompareAndSwap(true, false) {
+		s.p.sessionSlots.Add(-1)
+	}
+}
+
+// setSessionSlot lets the handlers of a new connection reserve a session slot.
+// The slot is kept by the session if one is stored, and released when it is
+// deleted; callers release it when the connection is not admitted.
+func (p *Proxy) setSessionSlot(ctx *handler.Context) {
+	s := &sessionSlot{p: p}
+	ctx.TryAcquireSession = s.tryAcquire
+	ctx.ReleaseSession = s.release
+}
+
+// takeSessionSlot gives a stored session a slot if no handler reserved one
+// (no limiter in the chain, restored and imported sessions), so the slots
+// count every session.
+func (p *Proxy) takeSessionSlot(ctx *handler.Context) {
+	if ctx.TryAcquireSession == nil {
+		p.setSessionSlot(ctx)
+	}
+	ctx.TryAcquireSession(math.MaxInt64)
+}
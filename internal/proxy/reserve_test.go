@@ -0,0 +1,56 @@
+package proxy
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+func TestSessionSlots(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	newCtx := func() *handler.Context {
+		ctx := &handler.Context{Session: &handler.Session{}}
+		p.setSessionSlot(ctx)
+		return ctx
+	}
+
+	// Concurrent connections never take more than limit slots
+	var admitted atomic.Int64
+	var wg sync.WaitGroup
+	for range 50 {
+		wg.Go(func() {
+			if newCtx().TryAcquireSession(5) {
+				admitted.Add(1)
+			}
+		})
+	}
+	wg.Wait()
+	if n := admitted.Load(); n != 5 || p.sessionSlots.Load() != 5 {
+		t.Fatalf("admitted %d, slots %d, want 5", n, p.sessionSlots.Load())
+	}
+	p.sessionSlots.Store(0)
+
+	// A stored session keeps its reserved slot, or takes one without a
+	// limiter, and releases it once when deleted
+	reserved := newCtx()
+	if !reserved.TryAcquireSession(2) || !reserved.TryAcquireSession(1) {
+		t.Fatal("reservation failed")
+	}
+	p.storeSession("a", reserved)
+	unlimited := &handler.Context{Session: &handler.Session{}}
+	p.storeSession("b", unlimited)
+	if n := p.sessionSlots.Load(); n != 2 {
+		t.Fatalf("slots = %d with two sessions, want 2", n)
+	}
+	if newCtx().TryAcquireSession(2) {
+		t.Error("slot granted over the limit")
+	}
+	reserved.ReleaseSession()
+	p.deleteSession("a", reserved)
+	p.deleteSession("b", unlimited)
+	if n := p.sessionSlots.Load(); n != 0 {
+		t.Errorf("slots = %d after delete, want 0", n)
+	}
+}
@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"quic-relay/internal/handler"
+)
+
+// StatelessResetConfig enables stateless resets for packets of unknown connections.
+type StatelessResetConfig struct {
+	Enabled   bool   `json:"enabled"`
+	Key       string `json:"key,omitempty"`        // Hex 32-byte key, shared with backends
+	KeyFile   string `json:"key_file,omitempty"`   // File holding the hex key; created with a random key if missing
+	CIDLength int    `json:"cid_length,omitempty"` // Length of backend-issued connection IDs (default: 4)
+}
+
+const (
+	statelessResetKeyLen   = 32
+	defaultResetCIDLength  = 4 // quic-go default connection ID length
+	statelessResetTokenLen = 16
+	maxResetCIDLength      = 20
+)
+
+// statelessResetter derives reset tokens the way quic-go does for its
+// StatelessResetKey: the first 16 bytes of HMAC-SHA256(key, connection ID).
+type statelessResetter struct {
+	key       []byte
+	cidLength int
+}
+
+// token returns the stateless reset token for a connection ID.
+func (r *statelessResetter) token(cid []byte) []byte {
+	h := hmac.New(sha256.New, r.key)
+	h.Write(cid)
+	return h.Sum(nil)[:statelessResetTokenLen]
+}
+
+// packetToken returns the token for the DCID of a short header packet, or nil.
+func (r *statelessResetter) packetToken(packet []byte) []byte {
+	if ClassifyPacket(packet) != PacketShortHeader {
+		return nil
+	}
+	dcid, err := ExtractDCID(packet, r.cidLength)
+	if err != nil {
+		return nil
+	}
+	return r.token(dcid)
+}
+
+// SetStatelessReset configures stateless resets (hot-reload safe).
+// With a nil or disabled config, packets of unknown connections are ignored.
+func (p *Proxy) SetStatelessReset(cfg *StatelessResetConfig) error {
+	r, err := newStatelessResetter(cfg)
+	if err != nil {
+		return err
+	}
+	p.resetter.Store(r)
+	return nil
+}
+
+// newStatelessResetter returns the resetter for cfg, or nil if stateless
+// resets are off.
+func newStatelessResetter(cfg *StatelessResetConfig) (*statelessResetter, error) {
+	if cfg == nil || !cfg.Enabled {
+		return nil, nil
+	}
+	key, err := loadStatelessResetKey(cfg)
+	if err != nil {
+		return nil, err
+	}
+	r := &statelessResetter{key: key, cidLength: cfg.CIDLength}
+	if r.cidLength == 0 {
+		r.cidLength = defaultResetCIDLength
+	}
+	if r.cidLength < 0 || r.cidLength > maxResetCIDLength {
+		return nil, fmt.Errorf("stateless_reset: invalid cid_length %d", cfg.CIDLength)
+	}
+	return r, nil
+}
+
+// loadStatelessResetKey returns the configured key, reading or creating key_file.
+func loadStatelessResetKey(cfg *StatelessResetConfig) ([]byte, error) {
+	encoded := cfg.Key
+	if encoded == "" {
+		if cfg.KeyFile == "" {
+			return nil, errors.New("stateless_reset: requires 'key' or 'key_file'")
+		}
+		data, err := os.ReadFile(cfg.KeyFile)
+		switch {
+		case errors.Is(err, os.ErrNotExist):
+			key := make([]byte, statelessResetKeyLen)
+			rand.Read(key)
+			if err := os.WriteFile(cfg.KeyFile, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+				return nil, fmt.Errorf("stateless_reset: %w", err)
+			}
+			logger.Printf("generated stateless reset key in %s", cfg.KeyFile)
+			return key, nil
+		case err != nil:
+			return nil, fmt.Errorf("stateless_reset: %w", err)
+		}
+		encoded = strings.TrimSpace(string(data))
+	}
+	key, err := hex.DecodeString(encoded)
+	if err != nil || len(key) != statelessResetKeyLen {
+		return nil, fmt.Errorf("stateless_reset: key must be %d hex-encoded bytes", statelessResetKeyLen)
+	}
+	return key, nil
+}
+
+// resetToken returns the reset token for a packet, or nil if resets are not configured.
+func (p *Proxy) resetToken(packet []byte) []byte {
+	r := p.resetter.Load()
+	if r == nil {
+		return nil
+	}
+	return r.packetToken(packet)
+}
+
+// sendStatelessReset answers a short header packet of an unknown connection,
+// so a client whose connection was lost (e.g. across a restart) closes immediately.
+func (p *Proxy) sendStatelessReset(conn handler.ClientConn, clientAddr *net.UDPAddr, packet []byte) {
+	token := p.resetToken(packet)
+	if token == nil || !p.drops.allow() {
+		return
+	}
+	if reset := buildStatelessReset(len(packet), token); reset != nil {
+		conn.WriteToUDP(reset, clientAddr)
+	}
+}
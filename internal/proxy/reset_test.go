@@ -0,0 +1,104 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+const testResetKey = "000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"
+
+func TestSetStatelessReset_Errors(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    StatelessResetConfig
+		errMsg string
+	}{
+		{"no key", StatelessResetConfig{Enabled: true}, "requires 'key' or 'key_file'"},
+		{"short key", StatelessResetConfig{Enabled: true, Key: "0011"}, "32 hex-encoded bytes"},
+		{"bad cid length", StatelessResetConfig{Enabled: true, Key: testResetKey, CIDLength: 21}, "invalid cid_length"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := New(":0", handler.NewChain())
+			err := p.SetStatelessReset(&tt.cfg)
+			if err == nil || !strings.Contains(err.Error(), tt.errMsg) {
+				t.Errorf("error = %v, want %q", err, tt.errMsg)
+			}
+		})
+	}
+
+	p := New(":0", handler.NewChain())
+	if err := p.SetStatelessReset(&StatelessResetConfig{Key: "nonsense"}); err != nil {
+		t.Errorf("disabled config validated: %v", err)
+	}
+}
+
+func TestStatelessResetKeyFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "reset.key")
+	cfg := &StatelessResetConfig{Enabled: true, KeyFile: path}
+
+	p := New(":0", handler.NewChain())
+	if err := p.SetStatelessReset(cfg); err != nil {
+		t.Fatal(err)
+	}
+	first := p.resetter.Load().key
+	if _, err := os.Stat(path); err != nil {
+		t.Fatalf("key file not created: %v", err)
+	}
+
+	// A restarted relay reuses the key
+	if err := p.SetStatelessReset(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(p.resetter.Load().key, first) {
+		t.Error("key changed across restarts")
+	}
+}
+
+func TestStatelessReset_UnknownConnection(t *testing.T) {
+	p, addr := startTestProxy(t, handler.NewChain())
+	if err := p.SetStatelessReset(&StatelessResetConfig{Enabled: true, Key: testResetKey, CIDLength: 8}); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	cid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	pkt := append([]byte{0x40}, cid...)
+	pkt = append(pkt, make([]byte, 60)...)
+	if _, err := client.Write(pkt); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 1500)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("no stateless reset: %v", err)
+	}
+	reset := buf[:n]
+
+	key, _ := loadStatelessResetKey(&StatelessResetConfig{Key: testResetKey})
+	h := hmac.New(sha256.New, key)
+	h.Write(cid)
+	want := h.Sum(nil)[:16]
+
+	if len(reset) >= len(pkt) || reset[0]&0xc0 != 0x40 {
+		t.Errorf("reset = %x, want short header smaller than trigger", reset)
+	}
+	if !bytes.Equal(reset[len(reset)-16:], want) {
+		t.Errorf("token = %x, want %x", reset[len(reset)-16:], want)
+	}
+}
@@ -0,0 +1,126 @@
+package proxy
+
+import (
+	"cmp"
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/retention"
+)
+
+const defaultRetentionInterval = 300 * time.Second
+
+// RetentionStats count the files the retention janitor deleted.
+type RetentionStats struct {
+	Stores    []string `json:"stores,omitempty"` // Directories swept
+	Files     uint64   `json:"files_deleted"`
+	Bytes     uint64   `json:"bytes_deleted"`
+	Errors    uint64   `json:"errors"`               // Files that could not be read or deleted
+	LastSweep string   `json:"last_sweep,omitempty"` // Time of the last sweep (RFC 3339)
+}
+
+// retentionCounters are kept by the proxy so they survive janitor reloads.
+type retentionCounters struct {
+	files, bytes, errors atomic.Uint64
+	lastSweep            atomic.Int64 // Unix seconds, 0 before the first sweep
+}
+
+// janitor sweeps the retention stores every interval.
+type janitor struct {
+	cfg      *retention.Config
+	interval time.Duration
+	counters *retentionCounters
+	done     chan struct{}
+	stopped  chan struct{}
+}
+
+// SetRetention configures the retention janitor (hot-reload safe). The new
+// janitor sweeps right away.
+func (p *Proxy) SetRetention(cfg *retention.Config) error {
+	j, err := p.newJanitor(cfg)
+	if err != nil {
+		return err
+	}
+	p.startJanitor(j)
+	return nil
+}
+
+// newJanitor returns a janitor for cfg that is not started yet, or nil for a
+// nil cfg.
+func (p *Proxy) newJanitor(cfg *retention.Config) (*janitor, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("retention: %w", err)
+	}
+	return &janitor{
+		cfg:      cfg,
+		interval: cmp.Or(time.Duration(cfg.Interval)*time.Second, defaultRetentionInterval),
+		counters: &p.retentionCounters,
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+	}, nil
+}
+
+// startJanitor starts j, which may be nil, in place of the running janitor.
+func (p *Proxy) startJanitor(j *janitor) {
+	p.stopJanitor(p.janitor.Swap(j))
+	if j != nil {
+		go j.run()
+	}
+}
+
+func (p *Proxy) stopJanitor(j *janitor) {
+	if j != nil {
+		close(j.done)
+		<-j.stopped
+	}
+}
+
+// RetentionStats returns the retention counters.
+func (p *Proxy) RetentionStats() RetentionStats {
+	c := &p.retentionCounters
+	st := RetentionStats{
+		Files:  c.files.Load(),
+		Bytes:  c.bytes.Load(),
+		Errors: c.errors.Load(),
+	}
+	if p.janitor.Load() != nil {
+		st.Stores = retention.Stores()
+	}
+	if last := c.lastSweep.Load(); last != 0 {
+		st.LastSweep = time.Unix(last, 0).UTC().Format(time.RFC3339)
+	}
+	return st
+}
+
+func (j *janitor) run() {
+	defer close(j.stopped)
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+	for {
+		j.sweep(time.Now())
+		select {
+		case <-ticker.C:
+		case <-j.done:
+			return
+		}
+	}
+}
+
+// sweep deletes what the policies no longer allow and counts it.
+func (j *janitor) sweep(now time.Time) {
+	res := retention.Sweep(j.cfg, now)
+	j.counters.files.Add(uint64(res.Files))
+	j.counters.bytes.Add(uint64(res.Bytes))
+	j.counters.errors.Add(uint64(len(res.Errors)))
+	j.counters.lastSweep.Store(now.Unix())
+	if res.Files > 0 {
+		logger.Printf("retention: deleted %d files (%d bytes)", res.Files, res.Bytes)
+	}
+	if len(res.Errors) > 0 {
+		logger.Warnf("retention: %v (%d errors)", res.Errors[0], len(res.Errors))
+	}
+}
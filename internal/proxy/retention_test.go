@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/retention"
+)
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+	old := filepath.Join(dir, "samples-old.pcap")
+	if err := os.WriteFile(old, make([]byte, 100), 0o640); err != nil {
+		t.Fatal(err)
+	}
+	past := time.Now().Add(-48 * time.Hour)
+	os.Chtimes(old, past, past)
+
+	p := New("127.0.0.1:0", handler.NewChain())
+	if err := p.SetRetention(&retention.Config{Default: retention.Policy{MaxAge: -1}}); err == nil {
+		t.Error("negative max_age accepted")
+	}
+	// The sampler registers its directory and holds its current file
+	if err := p.SetPacketSampling(&PacketSamplingConfig{Rate: 1, Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.SetPacketSampling(nil)
+	if err := p.SetRetention(&retention.Config{Default: retention.Policy{MaxAge: 3600}}); err != nil {
+		t.Fatal(err)
+	}
+	defer p.SetRetention(nil)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for p.RetentionStats().LastSweep == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	st := p.RetentionStats()
+	if st.Files != 1 || st.Bytes != 100 || st.Errors != 0 || len(st.Stores) == 0 {
+		t.Errorf("stats = %+v", st)
+	}
+	if _, err := os.Stat(old); err == nil {
+		t.Error("expired capture kept")
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "samples-*.pcap")); len(files) != 1 {
+		t.Errorf("current capture deleted: %v", files)
+	}
+}
@@ -0,0 +1,399 @@
+package proxy
+
+import (
+	"bufio"
+	"cmp"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/pcap"
+	"quic-relay/internal/retention"
+	"quic-relay/internal/sflow"
+)
+
+// Data sources packets are sampled on, reported as sFlow source and input
+// interface indexes.
+const (
+	sampleClients  = 1 // Datagrams from clients, on listeners and ingress adapters
+	sampleBackends = 2 // Datagrams from backends, on the forwarder's sockets
+)
+
+const (
+	defaultSampleHeader  = 128
+	maxSampleHeader      = 1024
+	defaultSampleFileMB  = 64
+	sampleQueue          = 1024        // Samples waiting for export before new ones are dropped
+	sampleFlushInterval  = time.Second // Longest a sample waits for others to share its datagram
+	sampleIPHeaderLength = 40 + 8      // Room for the IP and UDP headers of a sample
+)
+
+// PacketSamplingConfig samples 1 in rate datagrams and sends their headers to
+// an sFlow collector, a capture directory, or both.
+type PacketSamplingConfig struct {
+	Rate      int    `json:"rate"`                  // One in rate datagrams is sampled
+	Header    int    `json:"header,omitempty"`      // Bytes kept of each sample, IP and UDP headers included (default: 128)
+	Collector string `json:"collector,omitempty"`   // host:port of an sFlow collector
+	Agent     string `json:"agent,omitempty"`       // Agent address reported to the collector (default: the local address towards it)
+	Dir       string `json:"dir,omitempty"`         // Directory of capture files the samples are written to
+	MaxFileMB int    `json:"max_file_mb,omitempty"` // Size of a capture file before a new one is started (default: 64)
+}
+
+// PacketSamplingStats count sampled datagrams.
+type PacketSamplingStats struct {
+	Seen    uint64 `json:"seen"`    // Datagrams considered for sampling
+	Sampled uint64 `json:"sampled"` // Samples exported
+	Dropped uint64 `json:"dropped"` // Samples lost because the export queue was full
+	Errors  uint64 `json:"errors"`  // Failed sends and writes
+}
+
+// sampleCounters are kept by the proxy so they survive sampler reloads.
+type sampleCounters struct {
+	seen    [3]atomic.Uint64 // By data source
+	sampled atomic.Uint64
+	dropped atomic.Uint64
+	errors  atomic.Uint64
+}
+
+// sampledPacket is a sampled datagram waiting for export.
+type sampledPacket struct {
+	source   uint32
+	time     time.Time
+	src, dst netip.AddrPort
+	size     int    // Payload length
+	pool     uint64 // Datagrams of the source seen so far
+	payload  []byte // First bytes of the payload
+}
+
+// packetSampler decides which datagrams to sample and exports them.
+type packetSampler struct {
+	rate     uint64
+	header   int // Bytes kept of each sample
+	payload  int // Payload bytes copied of each sampled datagram
+	counters *sampleCounters
+	queue    chan sampledPacket
+	done     chan struct{}
+	stopped  chan struct{}
+
+	conn *net.UDPConn // nil without a collector
+	enc  *sflow.Encoder
+
+	dir         string // "" without captures
+	maxBytes    int64
+	removeStore func() // Unregisters dir from retention
+	release     func() // Lets retention delete the current file
+	file        *os.File
+	buf         *bufio.Writer
+	capture     *pcap.Writer
+	written     int64
+}
+
+// SetPacketSampling configures packet sampling (hot-reload safe). The
+// previous sampler exports what it has queued before it stops; every
+// sampler starts a new capture file.
+func (p *Proxy) SetPacketSampling(cfg *PacketSamplingConfig) error {
+	s, err := p.newPacketSampler(cfg)
+	if err != nil {
+		return err
+	}
+	p.startSampler(s)
+	return nil
+}
+
+// newPacketSampler returns a sampler for cfg that is not started yet, or nil
+// for a nil cfg. One that is never started must be closed.
+func (p *Proxy) newPacketSampler(cfg *PacketSamplingConfig) (*packetSampler, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Rate <= 0 {
+		return nil, errors.New("packet_sampling: 'rate' must be > 0")
+	}
+	if cfg.Collector == "" && cfg.Dir == "" {
+		return nil, errors.New("packet_sampling: 'collector' or 'dir' is required")
+	}
+	if cfg.Header < 0 || cfg.Header > maxSampleHeader || cfg.MaxFileMB < 0 {
+		return nil, fmt.Errorf("packet_sampling: header must be between 0 and %d, max_file_mb >= 0", maxSampleHeader)
+	}
+	header := cmp.Or(cfg.Header, defaultSampleHeader)
+	if header < sampleIPHeaderLength {
+		return nil, fmt.Errorf("packet_sampling: header must be at least %d", sampleIPHeaderLength)
+	}
+	s := &packetSampler{
+		rate:     uint64(cfg.Rate),
+		header:   header,
+		payload:  header - 8 - 20, // Headers of an IPv4 sample; IPv6 ones are cut further
+		counters: &p.sampleCounters,
+		queue:    make(chan sampledPacket, sampleQueue),
+		done:     make(chan struct{}),
+		stopped:  make(chan struct{}),
+		dir:      cfg.Dir,
+		maxBytes: int64(cmp.Or(cfg.MaxFileMB, defaultSampleFileMB)) << 20,
+	}
+	if cfg.Collector != "" {
+		if err := s.dial(cfg.Collector, cfg.Agent); err != nil {
+			return nil, fmt.Errorf("packet_sampling: %w", err)
+		}
+	}
+	if s.dir != "" {
+		if err := os.MkdirAll(s.dir, 0o750); err != nil {
+			s.close()
+			return nil, fmt.Errorf("packet_sampling: %w", err)
+		}
+		s.removeStore = retention.AddStore(s.dir, "samples-")
+		if err := s.rotate(); err != nil {
+			s.close()
+			return nil, fmt.Errorf("packet_sampling: %w", err)
+		}
+	}
+	return s, nil
+}
+
+// startSampler starts s, which may be nil, in place of the running sampler.
+func (p *Proxy) startSampler(s *packetSampler) {
+	if s == nil {
+		handler.SetPacketTap(nil)
+		p.stopSampler(p.sampler.Swap(nil))
+		return
+	}
+	go s.run()
+	p.stopSampler(p.sampler.Swap(s))
+	handler.SetPacketTap(func(src, dst netip.AddrPort, packet []byte) {
+		p.samplePacket(sampleBackends, src, dst, packet)
+	})
+}
+
+// dial connects to the collector and sets up the sFlow encoder.
+func (s *packetSampler) dial(collector, agent string) error {
+	addr, err := net.ResolveUDPAddr("udp", collector)
+	if err != nil {
+		return err
+	}
+	if s.conn, err = net.DialUDP("udp", nil, addr); err != nil {
+		return err
+	}
+	agentAddr := s.conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr()
+	if agent != "" {
+		if agentAddr, err = netip.ParseAddr(agent); err != nil {
+			s.conn.Close()
+			return fmt.Errorf("agent: %w", err)
+		}
+	}
+	s.enc = sflow.NewEncoder(agentAddr, 0)
+	return nil
+}
+
+func (p *Proxy) stopSampler(s *packetSampler) {
+	if s != nil {
+		close(s.done)
+		<-s.stopped
+	}
+}
+
+// PacketSamplingStats returns the packet sampling counters.
+func (p *Proxy) PacketSamplingStats() PacketSamplingStats {
+	c := &p.sampleCounters
+	return PacketSamplingStats{
+		Seen:    c.seen[sampleClients].Load() + c.seen[sampleBackends].Load(),
+		Sampled: c.sampled.Load(),
+		Dropped: c.dropped.Load(),
+		Errors:  c.errors.Load(),
+	}
+}
+
+// samplePacket samples a datagram of source with probability 1/rate.
+func (p *Proxy) samplePacket(source uint32, src, dst netip.AddrPort, packet []byte) {
+	s := p.sampler.Load()
+	if s == nil {
+		return
+	}
+	pool := p.sampleCounters.seen[source].Add(1)
+	if rand.Uint64N(s.rate) != 0 {
+		return
+	}
+	sp := sampledPacket{
+		source:  source,
+		time:    time.Now(),
+		src:     netip.AddrPortFrom(src.Addr().Unmap(), src.Port()),
+		dst:     netip.AddrPortFrom(dst.Addr().Unmap(), dst.Port()),
+		size:    len(packet),
+		pool:    pool,
+		payload: append([]byte(nil), packet[:min(len(packet), s.payload)]...),
+	}
+	select {
+	case s.queue <- sp:
+	default:
+		p.sampleCounters.dropped.Add(1)
+	}
+}
+
+// run exports queued samples at least every sampleFlushInterval until the
+// sampler is stopped.
+func (s *packetSampler) run() {
+	defer close(s.stopped)
+	defer s.close()
+	ticker := time.NewTicker(sampleFlushInterval)
+	defer ticker.Stop()
+
+	var batch []sampledPacket
+	for {
+		select {
+		case sp := <-s.queue:
+			batch = append(batch, sp)
+			if len(batch) >= sampleQueue/4 {
+				s.export(batch)
+				batch = batch[:0]
+			}
+		case <-ticker.C:
+			s.export(batch)
+			batch = batch[:0]
+		case <-s.done:
+			for {
+				select {
+				case sp := <-s.queue:
+					batch = append(batch, sp)
+				default:
+					s.export(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// export sends samples to the collector and writes them to the capture.
+func (s *packetSampler) export(batch []sampledPacket) {
+	if len(batch) == 0 {
+		return
+	}
+	var samples []sflow.FlowSample
+	for _, sp := range batch {
+		src, dst := sameFamily(sp.src, sp.dst)
+		pkt := pcap.AppendPacket(nil, pcap.Datagram{Time: sp.time, Src: src, Dst: dst, Payload: sp.payload}, sp.size)
+		orig := len(pkt) - len(sp.payload) + sp.size
+		pkt = pkt[:min(len(pkt), s.header)]
+		if s.capture != nil {
+			s.write(sp.time, pkt, orig)
+		}
+		if s.enc != nil {
+			proto := uint32(sflow.HeaderIPv6)
+			if src.Addr().Is4() {
+				proto = sflow.HeaderIPv4
+			}
+			samples = append(samples, sflow.FlowSample{
+				Source:      sp.source,
+				Rate:        uint32(s.rate),
+				Pool:        uint32(sp.pool),
+				Drops:       uint32(s.counters.dropped.Load()),
+				FrameLength: uint32(orig),
+				Protocol:    proto,
+				Header:      pkt,
+			})
+		}
+	}
+	if s.buf != nil {
+		if err := s.buf.Flush(); err != nil {
+			s.counters.errors.Add(1)
+		}
+	}
+	if s.enc != nil {
+		for _, dgram := range s.enc.Encode(samples, time.Now()) {
+			if _, err := s.conn.Write(dgram); err != nil {
+				s.counters.errors.Add(1)
+				logger.Debugf("packet sampling: %v", err)
+			}
+		}
+	}
+	s.counters.sampled.Add(uint64(len(batch)))
+}
+
+// sameFamily returns src and dst in one address family, as IP headers need:
+// a wildcard dst takes the family of src, otherwise IPv4 addresses are
+// mapped to IPv6 when the other one is IPv6.
+func sameFamily(src, dst netip.AddrPort) (netip.AddrPort, netip.AddrPort) {
+	if src.Addr().Is4() == dst.Addr().Is4() {
+		return src, dst
+	}
+	if !dst.Addr().IsValid() || dst.Addr().IsUnspecified() {
+		wildcard := netip.IPv6Unspecified()
+		if src.Addr().Is4() {
+			wildcard = netip.IPv4Unspecified()
+		}
+		return src, netip.AddrPortFrom(wildcard, dst.Port())
+	}
+	to6 := func(ap netip.AddrPort) netip.AddrPort {
+		return netip.AddrPortFrom(netip.AddrFrom16(ap.Addr().As16()), ap.Port())
+	}
+	return to6(src), to6(dst)
+}
+
+// write appends a sample to the capture, starting a new file at max_file_mb.
+func (s *packetSampler) write(t time.Time, pkt []byte, orig int) {
+	if s.written+int64(16+len(pkt)) > s.maxBytes {
+		if err := s.rotate(); err != nil {
+			s.counters.errors.Add(1)
+			logger.Warnf("packet sampling: %v", err)
+			return
+		}
+	}
+	if err := s.capture.WritePacket(t, pkt, orig); err != nil {
+		s.counters.errors.Add(1)
+		return
+	}
+	s.written += int64(16 + len(pkt))
+}
+
+// rotate closes the current capture file and starts a new one.
+func (s *packetSampler) rotate() error {
+	s.closeCapture()
+	path := filepath.Join(s.dir, "samples-"+time.Now().UTC().Format("20060102T150405.000000000")+".pcap")
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o640)
+	if err != nil {
+		return err
+	}
+	buf := bufio.NewWriter(f)
+	w, err := pcap.NewWriter(buf)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.file, s.buf, s.capture, s.written = f, buf, w, 24
+	s.release = retention.Hold(path)
+	return nil
+}
+
+func (s *packetSampler) closeCapture() {
+	if s.file == nil {
+		return
+	}
+	if err := errors.Join(s.buf.Flush(), s.file.Close()); err != nil {
+		logger.Warnf("packet sampling: %v", err)
+	}
+	s.file, s.buf, s.capture = nil, nil, nil
+	s.release()
+}
+
+func (s *packetSampler) close() {
+	s.closeCapture()
+	if s.removeStore != nil {
+		s.removeStore()
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// localAddrPort returns the local UDP address of conn, or the zero value.
+func localAddrPort(conn handler.ClientConn) netip.AddrPort {
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		return addr.AddrPort()
+	}
+	return netip.AddrPort{}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/pcap"
+)
+
+func TestPacketSampling(t *testing.T) {
+	collector, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer collector.Close()
+	dir := t.TempDir()
+
+	p := New("127.0.0.1:0", handler.NewChain())
+	for _, cfg := range []PacketSamplingConfig{{Dir: dir}, {Rate: 10}, {Rate: 10, Dir: dir, Header: 20}, {Rate: 10, Dir: dir, Header: 4096}} {
+		if err := p.SetPacketSampling(&cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	if err := p.SetPacketSampling(&PacketSamplingConfig{Rate: 1, Header: 64, Collector: collector.LocalAddr().String(), Dir: dir}); err != nil {
+		t.Fatal(err)
+	}
+	client := netip.MustParseAddrPort("192.0.2.1:50000")
+	listener := netip.MustParseAddrPort("[::]:5520")
+	p.samplePacket(sampleClients, client, listener, []byte("hello"))
+	p.samplePacket(sampleBackends, netip.MustParseAddrPort("198.51.100.1:5520"), client, bytes.Repeat([]byte{1}, 1000))
+	p.SetPacketSampling(nil) // Exports what is queued
+
+	collector.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 2000)
+	n, err := collector.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, samples := binary.BigEndian.Uint32(buf), binary.BigEndian.Uint32(buf[24:n]); v != 5 || samples != 2 {
+		t.Errorf("version %d, %d samples", v, samples)
+	}
+	if st := p.PacketSamplingStats(); st.Seen != 2 || st.Sampled != 2 || st.Dropped != 0 || st.Errors != 0 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	// The wildcard listener is written in the client's family; the cut
+	// backend datagram is kept, but readers skip it
+	files, _ := filepath.Glob(filepath.Join(dir, "samples-*.pcap"))
+	if len(files) != 1 {
+		t.Fatalf("capture files %v", files)
+	}
+	f, err := os.Open(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	r, err := pcap.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d, err := r.Next()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Src != client || d.Dst.String() != "0.0.0.0:5520" || string(d.Payload) != "hello" {
+		t.Errorf("sample %s -> %s %q", d.Src, d.Dst, d.Payload)
+	}
+	if _, err := r.Next(); err != io.EOF || r.Skipped != 1 {
+		t.Errorf("after the first sample: %v, %d skipped", err, r.Skipped)
+	}
+}
+
+func TestSameFamily(t *testing.T) {
+	for _, tc := range []struct{ src, dst, wantSrc, wantDst string }{
+		{"192.0.2.1:1", "198.51.100.1:2", "192.0.2.1:1", "198.51.100.1:2"},
+		{"[2001:db8::1]:1", "0.0.0.0:2", "[2001:db8::1]:1", "[::]:2"},
+		{"192.0.2.1:1", "[2001:db8::2]:2", "[::ffff:192.0.2.1]:1", "[2001:db8::2]:2"},
+	} {
+		src, dst := sameFamily(netip.MustParseAddrPort(tc.src), netip.MustParseAddrPort(tc.dst))
+		if src.String() != tc.wantSrc || dst.String() != tc.wantDst {
+			t.Errorf("sameFamily(%s, %s) = %s, %s", tc.src, tc.dst, src, dst)
+		}
+	}
+}
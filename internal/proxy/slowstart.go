@@ -0,0 +1,158 @@
+package proxy
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+// Ramp curves of slow start.
+const (
+	curveLinear      = "linear"      // Rate grows by the same amount every second
+	curveExponential = "exponential" // Rate grows by the same factor every second
+)
+
+const defaultSlowStartFrom = 1
+
+// SlowStartConfig limits how fast new connections are admitted after the
+// relay starts or maintenance mode ends, so clients reconnecting all at once
+// reach handlers and backends gradually.
+type SlowStartConfig struct {
+	Duration int     `json:"duration"`        // Seconds the ramp lasts; admission is unlimited afterwards
+	From     float64 `json:"from,omitempty"`  // New connections per second at the start (default: 1)
+	To       float64 `json:"to"`              // New connections per second at the end
+	Curve    string  `json:"curve,omitempty"` // "linear" (default) or "exponential"
+}
+
+// SlowStartStats describe the admission ramp.
+type SlowStartStats struct {
+	Active    bool    `json:"active"`
+	Rate      float64 `json:"rate,omitempty"`      // New connections per second admitted now
+	Remaining int     `json:"remaining,omitempty"` // Seconds until the ramp ends
+	Rejected  uint64  `json:"rejected"`            // New connections dropped by ramps so far
+}
+
+// slowStartPolicy is the validated slow start config.
+type slowStartPolicy struct {
+	duration    time.Duration
+	from, to    float64
+	exponential bool
+}
+
+// rate returns the admitted rate at elapsed into the ramp.
+func (pol *slowStartPolicy) rate(elapsed time.Duration) float64 {
+	x := min(float64(elapsed)/float64(pol.duration), 1)
+	if pol.exponential {
+		return pol.from * math.Pow(pol.to/pol.from, x)
+	}
+	return pol.from + (pol.to-pol.from)*x
+}
+
+// slowStart is the token bucket of the current ramp. Its rate changes with
+// time, and its burst is one second's worth of the current rate.
+type slowStart struct {
+	started  atomic.Int64 // Unix nanoseconds the relay started
+	rejected atomic.Uint64
+
+	mu     sync.Mutex
+	since  time.Time // Start of the ramp the bucket belongs to
+	tokens float64
+	last   time.Time
+}
+
+// SetSlowStart configures the admission ramp (hot-reload safe). A ramp in
+// progress continues with the new settings.
+func (p *Proxy) SetSlowStart(cfg *SlowStartConfig) error {
+	pol, err := newSlowStartPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	p.slowStartPolicy.Store(pol)
+	return nil
+}
+
+// newSlowStartPolicy returns the ramp for cfg, or nil for a nil cfg.
+func newSlowStartPolicy(cfg *SlowStartConfig) (*slowStartPolicy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Duration <= 0 || cfg.To <= 0 || cfg.From < 0 {
+		return nil, fmt.Errorf("slow_start: duration and to must be > 0, from >= 0")
+	}
+	pol := &slowStartPolicy{duration: time.Duration(cfg.Duration) * time.Second, from: cfg.From, to: cfg.To}
+	if pol.from == 0 {
+		pol.from = min(defaultSlowStartFrom, pol.to)
+	}
+	if pol.from > pol.to {
+		return nil, fmt.Errorf("slow_start: from (%g) is above to (%g)", pol.from, pol.to)
+	}
+	switch cfg.Curve {
+	case "", curveLinear:
+	case curveExponential:
+		pol.exponential = true
+	default:
+		return nil, fmt.Errorf("slow_start: unknown curve %q", cfg.Curve)
+	}
+	return pol, nil
+}
+
+// rampStart returns when the current ramp began: when the relay started, or
+// when maintenance mode last ended, whichever is later.
+func (p *Proxy) rampStart() time.Time {
+	since := time.Unix(0, p.slowStart.started.Load())
+	if ended := handler.MaintenanceEndedAt(); ended.After(since) {
+		since = ended
+	}
+	return since
+}
+
+// admitNew reports whether the slow start ramp admits a new connection now.
+func (p *Proxy) admitNew(now time.Time) bool {
+	pol := p.slowStartPolicy.Load()
+	if pol == nil {
+		return true
+	}
+	since := p.rampStart()
+	elapsed := now.Sub(since)
+	if elapsed >= pol.duration || elapsed < 0 {
+		return true
+	}
+	rate := pol.rate(elapsed)
+
+	s := &p.slowStart
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.since.Equal(since) {
+		// New ramp: start with one connection's worth
+		s.since, s.tokens, s.last = since, 1, now
+	}
+	s.tokens = min(s.tokens+rate*now.Sub(s.last).Seconds(), max(rate, 1))
+	s.last = now
+	if s.tokens < 1 {
+		s.rejected.Add(1)
+		return false
+	}
+	s.tokens--
+	return true
+}
+
+// SlowStartStats returns the state of the admission ramp.
+func (p *Proxy) SlowStartStats() SlowStartStats {
+	st := SlowStartStats{Rejected: p.slowStart.rejected.Load()}
+	pol := p.slowStartPolicy.Load()
+	if pol == nil || p.slowStart.started.Load() == 0 {
+		return st
+	}
+	elapsed := time.Since(p.rampStart())
+	if elapsed < 0 || elapsed >= pol.duration {
+		return st
+	}
+	st.Active = true
+	st.Rate = math.Round(pol.rate(elapsed)*10) / 10
+	st.Remaining = int((pol.duration - elapsed + time.Second - 1) / time.Second)
+	return st
+}
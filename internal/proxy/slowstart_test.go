@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+func TestSlowStart_Curves(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	if err := p.SetSlowStart(&SlowStartConfig{Duration: 10, From: 10, To: 1000, Curve: "exponential"}); err != nil {
+		t.Fatal(err)
+	}
+	pol := p.slowStartPolicy.Load()
+	for elapsed, want := range map[time.Duration]float64{0: 10, 5 * time.Second: 100, 10 * time.Second: 1000, time.Minute: 1000} {
+		if got := pol.rate(elapsed); math.Abs(got-want) > 0.001 {
+			t.Errorf("exponential rate at %v = %g, want %g", elapsed, got, want)
+		}
+	}
+	p.SetSlowStart(&SlowStartConfig{Duration: 10, To: 101})
+	if got := p.slowStartPolicy.Load().rate(5 * time.Second); got != 51 {
+		t.Errorf("linear rate = %g, want 51", got)
+	}
+
+	for _, cfg := range []SlowStartConfig{
+		{To: 100},
+		{Duration: 10},
+		{Duration: 10, From: 200, To: 100},
+		{Duration: 10, To: 100, Curve: "cubic"},
+	} {
+		if err := p.SetSlowStart(&cfg); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+}
+
+func TestSlowStart_Admission(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	start := time.Now()
+	if !p.admitNew(start) {
+		t.Fatal("admission limited without slow_start")
+	}
+	p.SetSlowStart(&SlowStartConfig{Duration: 10, From: 2, To: 2})
+	if !p.admitNew(start) {
+		t.Fatal("admission limited before the relay started")
+	}
+	p.slowStart.started.Store(start.UnixNano())
+
+	// One connection at once, then two per second
+	admitted := 0
+	for i := 0; i < 10; i++ {
+		if p.admitNew(start) {
+			admitted++
+		}
+	}
+	if admitted != 1 {
+		t.Errorf("admitted %d at once, want 1", admitted)
+	}
+	now := start.Add(time.Second)
+	admitted = 0
+	for i := 0; i < 10; i++ {
+		if p.admitNew(now) {
+			admitted++
+		}
+	}
+	if admitted != 2 {
+		t.Errorf("admitted %d after a second, want 2", admitted)
+	}
+	if st := p.SlowStartStats(); st.Rejected != 17 {
+		t.Errorf("stats = %+v", st)
+	}
+
+	// The ramp ends after its duration
+	if !p.admitNew(start.Add(10 * time.Second)) {
+		t.Error("admission limited after the ramp")
+	}
+}
@@ -0,0 +1,318 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/privacy"
+)
+
+// SnapshotConfig configures periodic session snapshots, so sessions survive a
+// quick relay restart.
+type SnapshotConfig struct {
+	Path     string `json:"path"`
+	Interval int    `json:"interval,omitempty"` // Seconds between snapshots (default: 10)
+	MaxAge   int    `json:"max_age,omitempty"`  // Snapshots older than this many seconds are not restored (default: 60)
+}
+
+const (
+	defaultSnapshotInterval = 10
+	defaultSnapshotMaxAge   = 60
+	snapshotVersion         = 1
+
+	// restoreGrace is how long a restored session waits for its client to confirm it.
+	restoreGrace = 30 * time.Second
+)
+
+// snapshot is the on-disk format.
+type snapshot struct {
+	Version  int               `json:"version"`
+	SavedAt  time.Time         `json:"saved_at"`
+	Sessions []sessionSnapshot `json:"sessions"`
+}
+
+// sessionSnapshot holds what is needed to re-establish one session.
+type sessionSnapshot struct {
+	ID       uint64            `json:"id"`
+	DCID     []byte            `json:"dcid,omitempty"`     // QUIC only
+	Aliases  [][]byte          `json:"aliases,omitempty"`  // Learned server SCIDs
+	Protocol string            `json:"protocol,omitempty"` // Non-QUIC flows
+	SNI      string            `json:"sni,omitempty"`
+	ALPN     []string          `json:"alpn,omitempty"`
+	Client   string            `json:"client"`
+	Listener string            `json:"listener"` // Local address the client reached
+	Backend  string            `json:"backend"`
+	Tenant   string            `json:"tenant,omitempty"`
+	Pipeline string            `json:"pipeline,omitempty"` // Pipeline the connection was redirected to
+	Tags     map[string]string `json:"tags,omitempty"`
+	Features []string          `json:"features,omitempty"` // Feature flags on for the session
+	Hop      *handler.HopInfo  `json:"hop,omitempty"`
+	Created  time.Time         `json:"created"`
+
+	Overrides *handler.RouteOverrides `json:"overrides,omitempty"` // Parameters of the session's route
+}
+
+// SetSnapshot enables session snapshots. Must be called before Run.
+func (p *Proxy) SetSnapshot(cfg *SnapshotConfig) error {
+	if cfg == nil {
+		p.snapshotCfg = nil
+		return nil
+	}
+	if cfg.Path == "" {
+		return errors.New("snapshot: missing path")
+	}
+	c := *cfg
+	if c.Interval <= 0 {
+		c.Interval = defaultSnapshotInterval
+	}
+	if c.MaxAge <= 0 {
+		c.MaxAge = defaultSnapshotMaxAge
+	}
+	p.snapshotCfg = &c
+	return nil
+}
+
+// snapshotLoop writes a snapshot every interval until shutdown.
+func (p *Proxy) snapshotLoop() {
+	ticker := time.NewTicker(time.Duration(p.snapshotCfg.Interval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.ctx.Done():
+			return
+		case <-ticker.C:
+			if err := p.writeSnapshot(); err != nil {
+				logger.Warnf("session snapshot failed: %v", err)
+			}
+		}
+	}
+}
+
+// listenerName returns the local address of a UDP listener, or false for
+// connections that cannot be restored (ingress adapters).
+func (p *Proxy) listenerName(conn handler.ClientConn) (string, bool) {
+	if conn == nil {
+		return "", false
+	}
+	if conn == handler.ClientConn(p.conn) {
+		return conn.LocalAddr().String(), true
+	}
+	for _, c := range p.extraConns {
+		if conn == handler.ClientConn(c) {
+			return conn.LocalAddr().String(), true
+		}
+	}
+	return "", false
+}
+
+// listenerByName returns the UDP listener with the given local address.
+func (p *Proxy) listenerByName(name string) *net.UDPConn {
+	if p.conn != nil && p.conn.LocalAddr().String() == name {
+		return p.conn
+	}
+	for _, c := range p.extraConns {
+		if c.LocalAddr().String() == name {
+			return c
+		}
+	}
+	return nil
+}
+
+// takeSnapshot collects all restorable sessions.
+func (p *Proxy) takeSnapshot() snapshot {
+	snap := snapshot{Version: snapshotVersion, SavedAt: time.Now()}
+	p.store.Range(func(key string, ctx *handler.Context) bool {
+		if s, ok := p.snapshotSession(ctx); ok {
+			snap.Sessions = append(snap.Sessions, s)
+		}
+		return true
+	})
+	return snap
+}
+
+// snapshotSession describes one session for a snapshot or export, or returns
+// false if it cannot be restored.
+func (p *Proxy) snapshotSession(ctx *handler.Context) (sessionSnapshot, bool) {
+	if ctx.Session == nil || ctx.Session.IsClosed() {
+		return sessionSnapshot{}, false
+	}
+	listener, ok := p.listenerName(ctx.ProxyConn)
+	backend := ctx.GetString("backend")
+	if !ok || backend == "" {
+		return sessionSnapshot{}, false
+	}
+	s := sessionSnapshot{
+		ID:       ctx.Session.ID,
+		Protocol: ctx.Protocol,
+		Client:   ctx.Session.ClientAddr().String(),
+		Listener: listener,
+		Backend:  backend,
+		Tenant:   ctx.GetString(handler.TenantKey),
+		Pipeline: ctx.GetString(handler.PipelineKey),
+		Tags:     handler.RouteTags(ctx),
+		Features: handler.Features(ctx),
+		Hop:      ctx.Hop,
+		Created:  ctx.Session.CreatedAt,
+	}
+	if o, ok := handler.GetValue[*handler.RouteOverrides](ctx, handler.OverridesKey); ok {
+		s.Overrides = o
+	}
+	if ctx.Protocol == "" {
+		s.DCID = ctx.Session.DCID
+		s.Aliases = p.store.Aliases(string(ctx.Session.DCID))
+	}
+	if ctx.Hello != nil {
+		s.SNI = ctx.Hello.SNI
+		s.ALPN = ctx.Hello.ALPNProtocols
+	}
+	return s, true
+}
+
+// writeSnapshot atomically replaces the snapshot file.
+func (p *Proxy) writeSnapshot() error {
+	data, err := json.Marshal(p.takeSnapshot())
+	if err != nil {
+		return err
+	}
+	tmp := p.snapshotCfg.Path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, p.snapshotCfg.Path)
+}
+
+// restoreSnapshot re-establishes the sessions of a recent snapshot. Restored
+// sessions stay unconfirmed until their client sends a packet, and are dropped
+// if it doesn't within restoreGrace.
+func (p *Proxy) restoreSnapshot() error {
+	data, err := os.ReadFile(p.snapshotCfg.Path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("invalid snapshot %s: %w", p.snapshotCfg.Path, err)
+	}
+	if snap.Version != snapshotVersion {
+		return fmt.Errorf("snapshot %s: unsupported version %d", p.snapshotCfg.Path, snap.Version)
+	}
+	if age := time.Since(snap.SavedAt); age > time.Duration(p.snapshotCfg.MaxAge)*time.Second {
+		logger.Printf("ignoring session snapshot from %v ago", age.Round(time.Second))
+		return nil
+	}
+
+	restored := 0
+	for _, s := range snap.Sessions {
+		if err := p.restoreSession(s); err != nil {
+			logger.Warnf("cannot restore session %d: %v", s.ID, err)
+			continue
+		}
+		restored++
+	}
+	logger.Printf("restored %d of %d sessions from snapshot", restored, len(snap.Sessions))
+	return nil
+}
+
+// restoreSession re-establishes a single saved session.
+func (p *Proxy) restoreSession(s sessionSnapshot) error {
+	conn := p.listenerByName(s.Listener)
+	if conn == nil {
+		return fmt.Errorf("listener %s not configured", s.Listener)
+	}
+	clientAddr, err := net.ResolveUDPAddr("udp", s.Client)
+	if err != nil {
+		return err
+	}
+	if s.Protocol == "" && len(s.DCID) == 0 {
+		return errors.New("missing DCID")
+	}
+
+	ctx := &handler.Context{
+		ClientAddr: clientAddr,
+		Protocol:   s.Protocol,
+		ProxyConn:  conn,
+		Hop:        s.Hop,
+	}
+	if s.Protocol == "" {
+		ctx.Hello = &handler.ClientHello{SNI: s.SNI, ALPNProtocols: s.ALPN}
+	}
+	ctx.Set("backend", s.Backend)
+	if s.Tenant != "" {
+		ctx.Set(handler.TenantKey, s.Tenant)
+	}
+	if s.Pipeline != "" {
+		ctx.Set(handler.PipelineKey, s.Pipeline)
+	}
+	if len(s.Tags) > 0 {
+		ctx.Set(handler.TagsKey, s.Tags)
+	}
+	if len(s.Features) > 0 {
+		ctx.Set(handler.FeaturesKey, s.Features)
+	}
+	if s.Overrides != nil {
+		ctx.Set(handler.OverridesKey, s.Overrides)
+	}
+	ctx.SessionCount = p.sessionCount.Load
+	ctx.SendConnectionClose = func(uint64, string) error {
+		return errors.New("restored session")
+	}
+
+	key := rawSessionKey(s.Protocol, clientAddr)
+	if s.Protocol == "" {
+		key = string(s.DCID)
+		ctx.OnServerPacket = func(packet []byte) {
+			p.learnServerSCID(key, ctx, packet)
+		}
+	}
+
+	result := p.chain.Load().Restore(ctx, s.ID)
+	if result.Action != handler.Handled || ctx.Session == nil {
+		if result.Error != nil {
+			return result.Error
+		}
+		return errors.New("no handler restored the session")
+	}
+	ctx.Session.CreatedAt = s.Created
+
+	if s.Protocol == "" {
+		ctx.Session.DCID = s.DCID
+		p.store.AddCID(key, s.DCID)
+		r := p.resetter.Load()
+		for _, alias := range s.Aliases {
+			p.store.AddAlias(key, alias)
+			if r != nil {
+				ctx.AddResetToken(r.token(alias))
+			}
+		}
+		// The client address mapping is added once the client confirms the session
+	}
+	p.storeSession(key, ctx)
+	if s.Protocol != "" {
+		p.store.SetClientSession(s.Protocol, addrKey(clientAddr), key)
+	}
+	ctx.DropSession = func() {
+		ctx.SetCloseReason(handler.CloseHandlerDrop)
+		p.chain.Load().OnDisconnect(ctx)
+		p.deleteSession(key, ctx)
+	}
+	return nil
+}
+
+// confirmSession marks a restored session as live once its client sends a packet.
+func (p *Proxy) confirmSession(ctx *handler.Context, clientAddr *net.UDPAddr) {
+	if !ctx.Session.Confirm() {
+		return
+	}
+	logger.Printf("restored session %d confirmed by %s", ctx.Session.ID, privacy.Addr(clientAddr))
+	if ctx.Protocol == "" {
+		p.store.SetClientSession("", addrKey(clientAddr), string(ctx.Session.DCID))
+	}
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"quic-relay/internal/handler"
+)
+
+// startEchoBackend replies to every datagram with prefix + payload.
+func startEchoBackend(t *testing.T, prefix string) *net.UDPConn {
+	t.Helper()
+	backend, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { backend.Close() })
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := backend.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			backend.WriteToUDP(append([]byte(prefix), buf[:n]...), addr)
+		}
+	}()
+	return backend
+}
+
+func TestSetSnapshot(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	if err := p.SetSnapshot(&SnapshotConfig{}); err == nil {
+		t.Error("expected error for missing path")
+	}
+	if err := p.SetSnapshot(&SnapshotConfig{Path: "/tmp/x"}); err != nil {
+		t.Fatal(err)
+	}
+	if p.snapshotCfg.Interval != defaultSnapshotInterval || p.snapshotCfg.MaxAge != defaultSnapshotMaxAge {
+		t.Errorf("defaults not applied: %+v", p.snapshotCfg)
+	}
+}
+
+func TestSnapshotRestore_RawFlow(t *testing.T) {
+	backend := startEchoBackend(t, "echo:")
+	newChain := func() *handler.Chain {
+		router, err := handler.NewProtocolRouterHandler(json.RawMessage(`{"routes": {"echo": "` + backend.LocalAddr().String() + `"}}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fwd, _ := handler.NewForwarderHandler(nil)
+		return handler.NewChain(router, fwd)
+	}
+	snapCfg := &SnapshotConfig{Path: filepath.Join(t.TempDir(), "sessions.json")}
+	setup := func(p *Proxy) {
+		p.SetProtocols([]ProtocolRule{{Name: "echo", Prefix: "6563686f"}})
+		if err := p.SetSnapshot(snapCfg); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Feature flags are decided once per session and restored with it
+	if err := handler.SetFeatureFlags(handler.FeatureConfig{"snap": {Percent: 100}}); err != nil {
+		t.Fatal(err)
+	}
+	defer handler.SetFeatureFlags(nil)
+
+	first, addr := startTestProxyOn(t, newChain(), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, setup)
+
+	client, err := net.DialUDP("udp", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	roundTrip := func(msg string) {
+		t.Helper()
+		client.SetDeadline(time.Now().Add(2 * time.Second))
+		if _, err := client.Write([]byte(msg)); err != nil {
+			t.Fatal(err)
+		}
+		buf := make([]byte, 1500)
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("%s: %v", msg, err)
+		}
+		if got := string(buf[:n]); got != "echo:"+msg {
+			t.Errorf("reply = %q", got)
+		}
+	}
+
+	roundTrip("echo 1")
+	id := first.Sessions()[0].ID
+	if f := first.Sessions()[0].Features; !slices.Equal(f, []string{"snap"}) {
+		t.Errorf("features = %v", f)
+	}
+	first.Stop()
+	handler.SetFeatureFlags(nil)
+	if _, err := os.Stat(snapCfg.Path); err != nil {
+		t.Fatalf("snapshot not written: %v", err)
+	}
+
+	second, _ := startTestProxyOn(t, newChain(), addr, func(p *Proxy) {
+		setup(p)
+		if err := p.restoreSnapshot(); err != nil {
+			t.Fatal(err)
+		}
+	})
+	sessions := second.Sessions()
+	if len(sessions) != 1 || sessions[0].ID != id || sessions[0].Protocol != "echo" {
+		t.Fatalf("restored sessions = %+v, want id %d", sessions, id)
+	}
+	if f := sessions[0].Features; !slices.Equal(f, []string{"snap"}) {
+		t.Errorf("restored features = %v", f)
+	}
+
+	roundTrip("echo 2")
+	if n := second.SessionCount(); n != 1 {
+		t.Errorf("sessions = %d, want 1 (restored session reused)", n)
+	}
+}
+
+func TestSnapshotRestore_QUIC(t *testing.T) {
+	backend := startEchoBackend(t, "")
+	fwd, _ := handler.NewForwarderHandler(nil)
+	path := filepath.Join(t.TempDir(), "sessions.json")
+
+	dcid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	alias := []byte{9, 9, 9, 9}
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	p, addr := startTestProxyOn(t, handler.NewChain(fwd), &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)}, nil)
+	snap := snapshot{Version: snapshotVersion, SavedAt: time.Now(), Sessions: []sessionSnapshot{{
+		ID:       42,
+		DCID:     dcid,
+		Aliases:  [][]byte{alias},
+		SNI:      "play.example.com",
+		Client:   "127.0.0.1:1", // Client has moved since the snapshot
+		Listener: addr.String(),
+		Backend:  backend.LocalAddr().String(),
+		Created:  time.Now().Add(-time.Minute),
+	}}}
+	data, _ := json.Marshal(snap)
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p.SetSnapshot(&SnapshotConfig{Path: path})
+	if err := p.restoreSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, ok := p.store.Load(string(dcid))
+	if !ok {
+		t.Fatal("session not restored")
+	}
+	if !ctx.Session.Unconfirmed() || ctx.Hello.SNI != "play.example.com" {
+		t.Errorf("restored session = %+v", ctx.Session)
+	}
+	if _, ok := p.store.ClientSession("", netip.MustParseAddrPort("127.0.0.1:1")); ok {
+		t.Error("unconfirmed client address registered")
+	}
+
+	// Short header packet using the server's CID confirms the session from the new address
+	pkt := append([]byte{0x40}, alias...)
+	pkt = append(pkt, []byte("data")...)
+	client.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := client.WriteToUDP(pkt, addr); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 1500)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != string(pkt) {
+		t.Errorf("reply = %x, want %x", buf[:n], pkt)
+	}
+	if ctx.Session.Unconfirmed() {
+		t.Error("session not confirmed")
+	}
+	if _, ok := p.store.ClientSession("", addrKey(client.LocalAddr().(*net.UDPAddr))); !ok {
+		t.Error("confirmed client address not registered")
+	}
+}
+
+func TestSnapshotRestore_Stale(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sessions.json")
+	data, _ := json.Marshal(snapshot{Version: snapshotVersion, SavedAt: time.Now().Add(-time.Hour), Sessions: []sessionSnapshot{{
+		ID: 1, DCID: []byte{1}, Client: "127.0.0.1:1", Listener: "127.0.0.1:2", Backend: "127.0.0.1:3",
+	}}})
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	p := New(":0", handler.NewChain())
+	p.SetSnapshot(&SnapshotConfig{Path: path})
+	if err := p.restoreSnapshot(); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.SessionCount(); n != 0 {
+		t.Errorf("sessions = %d, want 0 for stale snapshot", n)
+	}
+}
@@ -0,0 +1,43 @@
+package proxy
+
+import (
+	"net"
+
+	"quic-relay/internal/handler"
+)
+
+// SocketBuffersConfig sets socket buffer sizes of listeners and backend sockets.
+type SocketBuffersConfig struct {
+	Listener handler.SocketBufferConfig `json:"listener"` // Client-facing UDP listeners (default: 4 MB)
+	Backend  handler.SocketBufferConfig `json:"backend"`  // Per-session forwarder sockets (default: 1 MB)
+}
+
+// SetSocketBuffers configures socket buffer sizes. Must be called before Run.
+// A nil cfg uses the defaults.
+func (p *Proxy) SetSocketBuffers(cfg *SocketBuffersConfig) {
+	var c SocketBuffersConfig
+	if cfg != nil {
+		c = *cfg
+	}
+	p.listenerBuffers = c.Listener.WithDefault(handler.DefaultListenerBuffer)
+	handler.ConfigureBackendSocketBuffers(c.Backend)
+}
+
+// sizeListener applies the listener buffer sizes to conn and warns when the
+// kernel clamps them, since small buffers drop packets under burst load.
+func (p *Proxy) sizeListener(conn *net.UDPConn) {
+	res, err := handler.ApplySocketBuffers(conn, p.listenerBuffers)
+	if err != nil {
+		logger.Warnf("%s: failed to set socket buffers: %v", conn.LocalAddr(), err)
+		return
+	}
+	if res.ReceiveClamped() {
+		logger.Warnf("%s: receive buffer is %d bytes, wanted %d; raise net.core.rmem_max to avoid drops under load",
+			conn.LocalAddr(), res.ActualReceive, res.Receive)
+	}
+	if res.SendClamped() {
+		logger.Warnf("%s: send buffer is %d bytes, wanted %d; raise net.core.wmem_max to avoid drops under load",
+			conn.LocalAddr(), res.ActualSend, res.Send)
+	}
+	logger.Debugf("%s: socket buffers receive=%d send=%d", conn.LocalAddr(), res.ActualReceive, res.ActualSend)
+}
@@ -0,0 +1,211 @@
+package proxy
+
+import (
+	"net/netip"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/shardmap"
+)
+
+// SessionStore keeps the sessions of a proxy and the indexes packets find
+// them by: connection IDs, CIDs learned from backends (aliases) and client
+// addresses. A session is stored under its key: the original DCID of a QUIC
+// session, or the flow key of a non-QUIC flow.
+//
+// Methods are called concurrently, the lookups for every packet. Lookups
+// take CIDs as slices of the packet so the packet path need not copy them;
+// implementations must not keep those slices. MemoryStore is the default.
+type SessionStore interface {
+	// Load returns the session stored under key.
+	Load(key string) (*handler.Context, bool)
+	// Store stores a session under key, replacing any session stored there.
+	Store(key string, ctx *handler.Context)
+	// Delete removes the session stored under key with its CIDs and aliases,
+	// and returns it if there was one. Client addresses are removed by the
+	// proxy.
+	Delete(key string) (*handler.Context, bool)
+	// Range calls fn for each session until fn returns false. fn may use the
+	// store.
+	Range(fn func(key string, ctx *handler.Context) bool)
+
+	// AddCID records a CID of the session stored under key, for MatchCID and
+	// CIDLengths. An empty CID marks a backend using zero-length CIDs.
+	AddCID(key string, cid []byte)
+	// AddAlias records a CID the backend chose for the session stored under
+	// key, so LookupCID finds the session by it. Returns false if cid is
+	// already known.
+	AddAlias(key string, cid []byte) bool
+	// Aliases returns the CIDs added with AddAlias for the session stored
+	// under key.
+	Aliases(key string) [][]byte
+	// LookupCID returns the session stored under cid, or the session cid is
+	// an alias of.
+	LookupCID(cid []byte) (*handler.Context, bool)
+	// MatchCID returns the CID of the session stored under key a Short Header
+	// packet is addressed to. ok is also true for sessions with zero-length
+	// CIDs, which carry none.
+	MatchCID(key string, packet []byte) (cid []byte, ok bool)
+	// CIDLengths returns the lengths of the CIDs of all sessions, longest
+	// first. Short Header packets are only parsed at these lengths.
+	CIDLengths() []int
+
+	// ClientSession returns the key of the session of a client address: its
+	// QUIC session for protocol "", or its flow of a non-QUIC protocol.
+	ClientSession(protocol string, addr netip.AddrPort) (key string, ok bool)
+	// SetClientSession makes key the session of a client address.
+	SetClientSession(protocol string, addr netip.AddrPort, key string)
+	// DeleteClientSession forgets the session of a client address.
+	DeleteClientSession(protocol string, addr netip.AddrPort)
+}
+
+// clientFlow is a client address with the protocol of its session.
+type clientFlow struct {
+	protocol string
+	addr     netip.AddrPort
+}
+
+// MemoryStore is the default SessionStore. It keeps everything in sharded
+// maps, so workers storing and deleting sessions rarely wait for each other.
+// The zero MemoryStore is empty and ready to use.
+type MemoryStore struct {
+	sessions shardmap.Map[string, *handler.Context]
+	aliases  shardmap.Map[string, string]       // Server SCID -> session key
+	clients  shardmap.Map[clientFlow, string]   // Client address -> session key
+	cids     shardmap.Map[string, *sessionCIDs] // Session key -> its CIDs
+	lengths  cidLengthSet
+}
+
+// NewMemoryStore returns an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+// Load returns the session stored under key.
+func (s *MemoryStore) Load(key string) (*handler.Context, bool) {
+	return s.sessions.Load(key)
+}
+
+// Store stores a session under key.
+func (s *MemoryStore) Store(key string, ctx *handler.Context) {
+	s.sessions.Store(key, ctx)
+}
+
+// Delete removes the session stored under key with its CIDs and aliases.
+func (s *MemoryStore) Delete(key string) (*handler.Context, bool) {
+	ctx, ok := s.sessions.LoadAndDelete(key)
+	if ok {
+		s.releaseCIDs(key)
+	}
+	return ctx, ok
+}
+
+// Range calls fn for each session until fn returns false.
+func (s *MemoryStore) Range(fn func(key string, ctx *handler.Context) bool) {
+	s.sessions.Range(fn)
+}
+
+// AddCID records a CID of the session stored under key.
+func (s *MemoryStore) AddCID(key string, cid []byte) {
+	c, _ := s.cids.LoadOrStore(key, &sessionCIDs{})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(cid) == 0 {
+		c.zeroLength = true
+		return
+	}
+	if c.cids == nil {
+		c.cids = make(map[string]struct{})
+		c.lengths = make(map[int]struct{})
+	}
+	c.cids[string(cid)] = struct{}{}
+	if _, ok := c.lengths[len(cid)]; !ok {
+		c.lengths[len(cid)] = struct{}{}
+		s.lengths.add(len(cid))
+	}
+}
+
+// AddAlias records a CID the backend chose for the session stored under key.
+func (s *MemoryStore) AddAlias(key string, cid []byte) bool {
+	if _, loaded := s.aliases.LoadOrStore(string(cid), key); loaded {
+		return false
+	}
+	s.AddCID(key, cid)
+	return true
+}
+
+// Aliases returns the aliases of the session stored under key.
+func (s *MemoryStore) Aliases(key string) [][]byte {
+	c, ok := s.cids.Load(key)
+	if !ok {
+		return nil
+	}
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	var aliases [][]byte
+	for cid := range c.cids {
+		if original, ok := s.aliases.Load(cid); ok && original == key {
+			aliases = append(aliases, []byte(cid))
+		}
+	}
+	return aliases
+}
+
+// LookupCID returns the session stored under cid or the one it is an alias of.
+func (s *MemoryStore) LookupCID(cid []byte) (*handler.Context, bool) {
+	if ctx, ok := s.sessions.Load(string(cid)); ok {
+		return ctx, true
+	}
+	if key, ok := s.aliases.Load(string(cid)); ok {
+		return s.sessions.Load(key)
+	}
+	return nil, false
+}
+
+// MatchCID returns the CID of the session stored under key packet is addressed to.
+func (s *MemoryStore) MatchCID(key string, packet []byte) ([]byte, bool) {
+	c, ok := s.cids.Load(key)
+	if !ok {
+		return nil, false
+	}
+	return c.match(packet)
+}
+
+// CIDLengths returns the CID lengths in use, longest first.
+func (s *MemoryStore) CIDLengths() []int {
+	return s.lengths.lengths()
+}
+
+// ClientSession returns the key of the session of a client address.
+func (s *MemoryStore) ClientSession(protocol string, addr netip.AddrPort) (string, bool) {
+	return s.clients.Load(clientFlow{protocol, addr})
+}
+
+// SetClientSession makes key the session of a client address.
+func (s *MemoryStore) SetClientSession(protocol string, addr netip.AddrPort, key string) {
+	s.clients.Store(clientFlow{protocol, addr}, key)
+}
+
+// DeleteClientSession forgets the session of a client address.
+func (s *MemoryStore) DeleteClientSession(protocol string, addr netip.AddrPort) {
+	s.clients.Delete(clientFlow{protocol, addr})
+}
+
+// releaseCIDs forgets the CIDs of the session stored under key, including
+// the aliases learned from its backend.
+func (s *MemoryStore) releaseCIDs(key string) {
+	c, ok := s.cids.LoadAndDelete(key)
+	if !ok {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for cid := range c.cids {
+		if cid != key {
+			s.aliases.DeleteFunc(cid, func(original string) bool { return original == key })
+		}
+	}
+	for n := range c.lengths {
+		s.lengths.remove(n)
+	}
+}
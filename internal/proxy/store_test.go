@@ -0,0 +1,105 @@
+package proxy
+
+import (
+	"net/netip"
+	"reflect"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+func TestMemoryStore(t *testing.T) {
+	s := NewMemoryStore()
+	a, b := &handler.Context{}, &handler.Context{}
+	s.Store("dcid-aaa", a)
+	s.Store("dcid-bbb", b)
+	s.AddCID("dcid-aaa", []byte("dcid-aaa"))
+	s.AddCID("dcid-bbb", []byte("dcid-bbb"))
+	if !s.AddAlias("dcid-aaa", []byte("scid-a")) || s.AddAlias("dcid-bbb", []byte("scid-a")) {
+		t.Error("AddAlias of a new and a known CID")
+	}
+	s.AddAlias("dcid-bbb", []byte("srv-b"))
+
+	for cid, want := range map[string]*handler.Context{"dcid-aaa": a, "scid-a": a, "srv-b": b, "unknown": nil} {
+		if got, _ := s.LookupCID([]byte(cid)); got != want {
+			t.Errorf("LookupCID(%s) = %p, want %p", cid, got, want)
+		}
+	}
+	if got := s.Aliases("dcid-aaa"); !reflect.DeepEqual(got, [][]byte{[]byte("scid-a")}) {
+		t.Errorf("Aliases = %q", got)
+	}
+	if got := s.CIDLengths(); !reflect.DeepEqual(got, []int{8, 6, 5}) {
+		t.Errorf("CIDLengths = %v", got)
+	}
+	if cid, ok := s.MatchCID("dcid-bbb", append([]byte{0x40}, "srv-b...."...)); !ok || string(cid) != "srv-b" {
+		t.Errorf("MatchCID = %q, %v", cid, ok)
+	}
+
+	// A client address has a session per protocol
+	addr := netip.MustParseAddrPort("192.0.2.1:40000")
+	s.SetClientSession("", addr, "dcid-aaa")
+	s.SetClientSession("rtp", addr, "udp/rtp/192.0.2.1:40000")
+	if key, _ := s.ClientSession("", addr); key != "dcid-aaa" {
+		t.Errorf("QUIC session of the address = %q", key)
+	}
+	s.DeleteClientSession("rtp", addr)
+	if _, ok := s.ClientSession("rtp", addr); ok {
+		t.Error("deleted flow of the address still found")
+	}
+
+	// Deleting a session releases its CIDs and aliases
+	if got, ok := s.Delete("dcid-aaa"); !ok || got != a {
+		t.Errorf("Delete = %p, %v", got, ok)
+	}
+	if _, ok := s.LookupCID([]byte("scid-a")); ok {
+		t.Error("alias of the deleted session kept")
+	}
+	if got := s.CIDLengths(); !reflect.DeepEqual(got, []int{8, 5}) {
+		t.Errorf("CIDLengths after delete = %v", got)
+	}
+	if _, ok := s.Delete("dcid-aaa"); ok {
+		t.Error("second Delete found the session")
+	}
+}
+
+// countingStore is a SessionStore of an embedding program, counting the
+// sessions the proxy stores in it.
+type countingStore struct {
+	*MemoryStore
+	stored int
+}
+
+func (s *countingStore) Store(key string, ctx *handler.Context) {
+	s.stored++
+	s.MemoryStore.Store(key, ctx)
+}
+
+func TestSetSessionStore(t *testing.T) {
+	pp := newPacketPath(t)
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+	pp.p.SetSessionStore(store)
+
+	// A raw flow is found by its client address in the store
+	if err := pp.p.SetProtocols([]ProtocolRule{{Name: "rtp", Prefix: "80"}}); err != nil {
+		t.Fatal(err)
+	}
+	packet := []byte{0x80, 1, 2, 3}
+	pp.p.handlePacket(pp.conn, pp.client, packet)
+	pp.p.handlePacket(pp.conn, pp.client, packet)
+	if store.stored != 1 {
+		t.Fatalf("%d sessions stored, want 1", store.stored)
+	}
+	key, ok := store.ClientSession("rtp", addrKey(pp.client))
+	if !ok {
+		t.Fatal("flow not indexed by client address")
+	}
+	ctx, ok := store.Load(key)
+	if !ok || ctx.Session.Counters().PacketsIn != 2 {
+		t.Fatal("packets not forwarded through the stored flow")
+	}
+
+	ctx.DropSession()
+	if _, ok := store.ClientSession("rtp", addrKey(pp.client)); ok {
+		t.Error("closed flow still indexed by client address")
+	}
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/quic-go/quic-go"
+
+	"quic-relay/internal/handler"
+)
+
+func TestTarpit_AckKeepsClientWaiting(t *testing.T) {
+	h, err := handler.NewTarpitHandler(json.RawMessage(`{"networks": ["127.0.0.1"], "interval": 1}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarpit := h.(*handler.TarpitHandler)
+	defer tarpit.Close()
+	p := New("127.0.0.1:0", handler.NewChain(tarpit))
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	go func() {
+		buf := make([]byte, 1500)
+		for {
+			n, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			p.handlePacket(conn, addr, append([]byte(nil), buf[:n]...))
+		}
+	}()
+
+	// Without acknowledgements the client gives up after its handshake idle timeout
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	_, err = quic.DialAddr(ctx, conn.LocalAddr().String(),
+		&tls.Config{ServerName: "play.example.com", NextProtos: []string{"test"}},
+		&quic.Config{HandshakeIdleTimeout: 1500 * time.Millisecond})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("dial ended with %v, want the client still waiting", err)
+	}
+	if st := tarpit.Stats(); st.Tarpitted != 1 || st.Acks < 3 {
+		t.Errorf("stats = %+v, want 1 client and at least 3 acknowledgements", st)
+	}
+}
@@ -0,0 +1,42 @@
+package proxy
+
+import "net"
+
+// batchSize is the number of datagrams read per system call where the
+// platform supports batched reads.
+const batchSize = 32
+
+// batchReader reads datagrams from a listener, several per system call where
+// the platform supports it (recvmmsg on Linux) and one at a time elsewhere.
+// Buffers are reused: packet results are only valid until the next read.
+type batchReader interface {
+	// read blocks until at least one datagram arrived and returns how many were read.
+	read() (int, error)
+	// packet returns datagram i of the last read.
+	packet(i int) ([]byte, *net.UDPAddr)
+}
+
+// singleReader reads one datagram per call. It works on every platform.
+type singleReader struct {
+	conn *net.UDPConn
+	buf  []byte
+	n    int
+	addr *net.UDPAddr
+}
+
+func newSingleReader(conn *net.UDPConn, size int) *singleReader {
+	return &singleReader{conn: conn, buf: make([]byte, size)}
+}
+
+func (r *singleReader) read() (int, error) {
+	n, addr, err := r.conn.ReadFromUDP(r.buf)
+	if err != nil {
+		return 0, err
+	}
+	r.n, r.addr = n, addr
+	return 1, nil
+}
+
+func (r *singleReader) packet(int) ([]byte, *net.UDPAddr) {
+	return r.buf[:r.n], r.addr
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net"
+
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// mmsgReader reads up to batchSize datagrams per recvmmsg call.
+type mmsgReader struct {
+	readBatch func([]ipv4.Message, int) (int, error)
+	msgs      []ipv4.Message
+}
+
+// newBatchReader returns a recvmmsg based reader for conn.
+func newBatchReader(conn *net.UDPConn, size int) batchReader {
+	r := &mmsgReader{msgs: make([]ipv4.Message, batchSize)}
+	for i := range r.msgs {
+		r.msgs[i].Buffers = [][]byte{make([]byte, size)}
+	}
+	// ipv4.Message and ipv6.Message are the same type; the family only
+	// matters for control messages, which are not requested
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && addr.IP.To4() == nil {
+		r.readBatch = ipv6.NewPacketConn(conn).ReadBatch
+	} else {
+		r.readBatch = ipv4.NewPacketConn(conn).ReadBatch
+	}
+	return r
+}
+
+func (r *mmsgReader) read() (int, error) {
+	return r.readBatch(r.msgs, 0)
+}
+
+func (r *mmsgReader) packet(i int) ([]byte, *net.UDPAddr) {
+	m := &r.msgs[i]
+	addr, _ := m.Addr.(*net.UDPAddr)
+	return m.Buffers[0][:m.N], addr
+}
+
+// setupListener sets the don't-fragment bit on datagrams to clients. The
+// kernel then refuses datagrams above the path MTU with EMSGSIZE, which the
+// forwarder counts and drops, instead of fragmenting them.
+func setupListener(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	v6 := false
+	if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok {
+		v6 = addr.IP.To4() == nil
+	}
+	var sockErr error
+	err = raw.Control(func(fd uintptr) {
+		if v6 {
+			sockErr = unix.SetsockoptInt(int(fd), unix.IPPROTO_IPV6, unix.IPV6_MTU_DISCOVER, unix.IPV6_PMTUDISC_DO)
+		}
+		// Also applies to IPv4 clients of dual-stack sockets; fails on IPv6-only ones
+		if v4Err := unix.SetsockoptInt(int(fd), unix.IPPROTO_IP, unix.IP_MTU_DISCOVER, unix.IP_PMTUDISC_DO); !v6 {
+			sockErr = v4Err
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
@@ -0,0 +1,16 @@
+//go:build !linux && !windows
+
+package proxy
+
+import "net"
+
+// newBatchReader returns a reader for conn. Batched reads are Linux only;
+// other platforms read one datagram per call.
+func newBatchReader(conn *net.UDPConn, size int) batchReader {
+	return newSingleReader(conn, size)
+}
+
+// setupListener applies platform specific listener options. None are needed here.
+func setupListener(conn *net.UDPConn) error {
+	return nil
+}
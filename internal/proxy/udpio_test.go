@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestBatchReader(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	const count = 5
+	for i := range count {
+		if _, err := fmt.Fprintf(client, "packet-%d", i); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	r := newBatchReader(conn, 1500)
+	var got []string
+	for len(got) < count {
+		n, err := r.read()
+		if err != nil {
+			t.Fatalf("read after %d packets: %v", len(got), err)
+		}
+		for i := range n {
+			data, addr := r.packet(i)
+			if addr.Port != client.LocalAddr().(*net.UDPAddr).Port {
+				t.Errorf("packet %d from %v, want %v", len(got), addr, client.LocalAddr())
+			}
+			got = append(got, string(data))
+		}
+	}
+	for i, s := range got {
+		if want := fmt.Sprintf("packet-%d", i); s != want {
+			t.Errorf("packet %d = %q, want %q", i, s, want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package proxy
+
+import (
+	"net"
+	"syscall"
+	"unsafe"
+)
+
+// sioUDPConnReset controls whether ICMP port unreachable messages are reported
+// as WSAECONNRESET on later reads of an unconnected UDP socket.
+const sioUDPConnReset = syscall.IOC_IN | syscall.IOC_VENDOR | 12
+
+// newBatchReader returns a reader for conn. Windows has no batched receive in
+// the runtime poller; datagrams are read one per call.
+func newBatchReader(conn *net.UDPConn, size int) batchReader {
+	return newSingleReader(conn, size)
+}
+
+// setupListener disables connection reset reporting. Without it, every ICMP
+// port unreachable from a departed client fails the next read on the shared
+// listener with WSAECONNRESET.
+func setupListener(conn *net.UDPConn) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var ioErr error
+	err = raw.Control(func(fd uintptr) {
+		enable := uint32(0)
+		var returned uint32
+		ioErr = syscall.WSAIoctl(syscall.Handle(fd), sioUDPConnReset,
+			(*byte)(unsafe.Pointer(&enable)), uint32(unsafe.Sizeof(enable)), nil, 0, &returned, nil, 0)
+	})
+	if err != nil {
+		return err
+	}
+	return ioErr
+}
@@ -0,0 +1,255 @@
+package proxy
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"sync/atomic"
+	"time"
+
+	"quic-relay/internal/handler"
+	"quic-relay/internal/metrics"
+	"quic-relay/internal/privacy"
+)
+
+// Kinds of protocol violations.
+const (
+	violationShortPacket  = "short_packet"  // Packet of a session too short to carry QUIC packet protection
+	violationInvalidCID   = "invalid_cid"   // Long header with a connection ID longer than QUIC v1/v2 allow
+	violationAddressBurst = "address_burst" // Packet from a new address of a session that changes address too often
+)
+
+var violationKinds = []string{violationShortPacket, violationInvalidCID, violationAddressBurst}
+
+// Actions for protocol violations.
+const (
+	violationLog       = "log"       // Count and log, forward the packet
+	violationThreshold = "threshold" // Like log until a source reaches the threshold, then like drop
+	violationDrop      = "drop"      // Drop the packet and the session, penalize the source
+)
+
+const (
+	defaultViolationThreshold = 10
+	defaultViolationWindow    = 60 * time.Second
+	maxViolationSources       = 10000
+
+	// maxCIDLen is the longest connection ID QUIC versions 1 and 2 allow (RFC 9000 Section 17.2).
+	maxCIDLen    = 20
+	quicVersion2 = 0x6b3343cf
+	// minPacketAfterCID is what a protected packet carries after the connection
+	// ID at least: a packet number and the 16-byte header protection sample,
+	// which starts 4 bytes after the packet number (RFC 9001 Section 5.4.2).
+	minPacketAfterCID = 4 + 16
+)
+
+// ViolationsConfig sets what happens when clients break the QUIC protocol.
+// Without it, violations are only counted.
+type ViolationsConfig struct {
+	Action    string            `json:"action,omitempty"`    // "log" (default), "threshold" or "drop"
+	Actions   map[string]string `json:"actions,omitempty"`   // Kind -> action, overriding action
+	Threshold int               `json:"threshold,omitempty"` // Violations of a source within window before "threshold" drops (default: 10)
+	Window    int               `json:"window,omitempty"`    // Seconds violations are counted over (default: 60)
+}
+
+// violationPolicy is the validated violations config.
+type violationPolicy struct {
+	actions   map[string]string // Kind -> action
+	threshold int
+	window    time.Duration
+}
+
+// violationSource counts the violations of one client address in a window.
+type violationSource struct {
+	count int
+	since time.Time
+}
+
+// violationCounts backs the violation metrics: kind -> [forwarded, dropped].
+var violationCounts = func() map[string]*[2]atomic.Uint64 {
+	m := make(map[string]*[2]atomic.Uint64)
+	for _, kind := range violationKinds {
+		m[kind] = new([2]atomic.Uint64)
+	}
+	return m
+}()
+
+func init() {
+	metrics.Register(writeViolationStats)
+}
+
+// writeViolationStats writes the violation counters.
+func writeViolationStats(w io.Writer) {
+	const name = "quic_relay_protocol_violations_total"
+	metrics.WriteHeader(w, name, "counter", "Protocol violations by clients, by kind and whether the packet was dropped.")
+	for _, kind := range violationKinds {
+		c := violationCounts[kind]
+		metrics.WriteSample(w, name, c[0].Load(), "kind", kind, "action", "forwarded")
+		metrics.WriteSample(w, name, c[1].Load(), "kind", kind, "action", "dropped")
+	}
+}
+
+// SetViolations configures the protocol violation policy (hot-reload safe).
+func (p *Proxy) SetViolations(cfg *ViolationsConfig) error {
+	pol, err := newViolationPolicy(cfg)
+	if err != nil {
+		return err
+	}
+	p.violations.Store(pol)
+	return nil
+}
+
+// newViolationPolicy returns the policy for cfg, or nil for a nil cfg.
+func newViolationPolicy(cfg *ViolationsConfig) (*violationPolicy, error) {
+	if cfg == nil {
+		return nil, nil
+	}
+	if cfg.Threshold < 0 || cfg.Window < 0 {
+		return nil, fmt.Errorf("violations: threshold and window must be >= 0")
+	}
+	pol := &violationPolicy{
+		actions:   make(map[string]string),
+		threshold: cfg.Threshold,
+		window:    time.Duration(cfg.Window) * time.Second,
+	}
+	if pol.threshold == 0 {
+		pol.threshold = defaultViolationThreshold
+	}
+	if pol.window == 0 {
+		pol.window = defaultViolationWindow
+	}
+	def := cfg.Action
+	if def == "" {
+		def = violationLog
+	}
+	for _, kind := range violationKinds {
+		pol.actions[kind] = def
+	}
+	for kind, action := range cfg.Actions {
+		if _, ok := pol.actions[kind]; !ok {
+			return nil, fmt.Errorf("violations: unknown kind %q", kind)
+		}
+		pol.actions[kind] = action
+	}
+	for _, action := range pol.actions {
+		switch action {
+		case violationLog, violationThreshold, violationDrop:
+		default:
+			return nil, fmt.Errorf("violations: unknown action %q", action)
+		}
+	}
+	return pol, nil
+}
+
+// violation records a protocol violation by client, in ctx's session if ctx
+// is not nil, and reports whether the packet must be dropped. Dropping also
+// ends ctx's session and penalizes the client in reputation handlers.
+func (p *Proxy) violation(kind string, client *net.UDPAddr, ctx *handler.Context, detail string) bool {
+	if ctx != nil {
+		client = ctx.OriginalClientAddr()
+	}
+	pol := p.violations.Load()
+	action := violationLog
+	if pol != nil {
+		action = pol.actions[kind]
+	}
+	n := p.countViolation(client, pol, time.Now())
+
+	drop := action == violationDrop || (action == violationThreshold && n >= pol.threshold)
+	counts := violationCounts[kind]
+	if drop {
+		counts[1].Add(1)
+	} else {
+		counts[0].Add(1)
+	}
+
+	switch {
+	case drop:
+		logger.Warnf("protocol violation %s from %s: %s, dropping", kind, privacy.Addr(client), detail)
+	case pol != nil && n == 1:
+		logger.Warnf("protocol violation %s from %s: %s", kind, privacy.Addr(client), detail)
+	default:
+		logger.Debugf("protocol violation %s from %s: %s (%d in window)", kind, privacy.Addr(client), detail, n)
+	}
+	if ctx != nil && ctx.Session != nil {
+		ctx.Session.Note("violation", kind)
+	}
+	if !drop {
+		return false
+	}
+	if ctx != nil {
+		ctx.DropWithReason(handler.CloseViolation)
+	}
+	if client != nil {
+		for _, h := range p.chain.Load().Handlers() {
+			if r, ok := handler.Unwrap(h).(interface{ PenalizeViolation(net.IP) }); ok {
+				r.PenalizeViolation(client.IP)
+			}
+		}
+	}
+	return true
+}
+
+// countViolation adds a violation of client and returns its count in the
+// current window. Without a policy, the window is the default.
+func (p *Proxy) countViolation(client *net.UDPAddr, pol *violationPolicy, now time.Time) int {
+	if client == nil {
+		return 1
+	}
+	addr, ok := netip.AddrFromSlice(client.IP)
+	if !ok {
+		return 1
+	}
+	addr = addr.Unmap()
+	window := defaultViolationWindow
+	if pol != nil {
+		window = pol.window
+	}
+
+	p.violationSources.Lock()
+	defer p.violationSources.Unlock()
+	if p.violationSources.m == nil {
+		p.violationSources.m = make(map[netip.Addr]*violationSource)
+	}
+	src := p.violationSources.m[addr]
+	if src == nil || now.Sub(src.since) >= window {
+		if src == nil && len(p.violationSources.m) >= maxViolationSources {
+			for a, s := range p.violationSources.m {
+				if now.Sub(s.since) >= window {
+					delete(p.violationSources.m, a)
+				}
+			}
+			if len(p.violationSources.m) >= maxViolationSources {
+				return 1
+			}
+		}
+		src = &violationSource{since: now}
+		p.violationSources.m[addr] = src
+	}
+	src.count++
+	return src.count
+}
+
+// invalidCID reports whether a long header packet of QUIC version 1 or 2
+// declares a connection ID longer than the version allows.
+func invalidCID(packet []byte) bool {
+	if len(packet) < 6 || packet[0]&0x80 == 0 {
+		return false
+	}
+	switch uint32(packet[1])<<24 | uint32(packet[2])<<16 | uint32(packet[3])<<8 | uint32(packet[4]) {
+	case quicVersion1, quicVersion2:
+	default:
+		return false
+	}
+	if int(packet[5]) > maxCIDLen {
+		return true
+	}
+	scidAt := 6 + int(packet[5])
+	return len(packet) > scidAt && int(packet[scidAt]) > maxCIDLen
+}
+
+// shortPacket reports whether a packet whose DCID is cid cannot carry QUIC
+// packet protection.
+func shortPacket(packet, cid []byte) bool {
+	return len(packet) < 1+len(cid)+minPacketAfterCID
+}
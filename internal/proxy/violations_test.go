@@ -0,0 +1,99 @@
+package proxy
+
+import (
+	"net"
+	"testing"
+
+	"quic-relay/internal/handler"
+)
+
+func TestSetViolations_Errors(t *testing.T) {
+	p := New(":0", handler.NewChain())
+	for _, cfg := range []ViolationsConfig{
+		{Action: "block"},
+		{Actions: map[string]string{"bad_crc": "drop"}},
+		{Actions: map[string]string{violationShortPacket: "ignore"}},
+		{Threshold: -1},
+	} {
+		if err := p.SetViolations(&cfg); err == nil {
+			t.Errorf("%+v: no error", cfg)
+		}
+	}
+}
+
+func TestViolation_Actions(t *testing.T) {
+	rep, err := handler.NewReputationHandler(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(":0", handler.NewChain(rep))
+	client := &net.UDPAddr{IP: net.IPv4(198, 51, 100, 84), Port: 4000}
+	newCtx := func() (*handler.Context, *bool) {
+		dropped := new(bool)
+		ctx := &handler.Context{ClientAddr: client}
+		ctx.DropSession = func() { *dropped = true }
+		return ctx, dropped
+	}
+	before := violationCounts[violationShortPacket][1].Load()
+
+	// Without config, violations are only counted
+	ctx, dropped := newCtx()
+	for range 20 {
+		if p.violation(violationShortPacket, nil, ctx, "test") || *dropped {
+			t.Fatal("dropped without config")
+		}
+	}
+
+	// Threshold counts per source within the window
+	p.violationSources.m = nil
+	p.SetViolations(&ViolationsConfig{Action: violationThreshold, Threshold: 3, Actions: map[string]string{violationInvalidCID: violationDrop}})
+	for i := range 2 {
+		if p.violation(violationShortPacket, nil, ctx, "test") {
+			t.Fatalf("violation %d dropped below threshold", i+1)
+		}
+	}
+	if !p.violation(violationShortPacket, nil, ctx, "test") || !*dropped || ctx.CloseReason() != handler.CloseViolation {
+		t.Fatalf("threshold: dropped %v, reason %v", *dropped, ctx.CloseReason())
+	}
+	if got := violationCounts[violationShortPacket][1].Load() - before; got != 1 {
+		t.Errorf("dropped count = %d, want 1", got)
+	}
+
+	// Drop applies to the first violation, also without a session
+	if !p.violation(violationInvalidCID, &net.UDPAddr{IP: net.IPv4(198, 51, 100, 85), Port: 1}, nil, "test") {
+		t.Error("drop action did not drop")
+	}
+
+	var violations uint64
+	for _, s := range rep.(*handler.ReputationHandler).Scores() {
+		if s.Source == "198.51.100.84" || s.Source == "198.51.100.85" {
+			violations += s.Violations
+		}
+	}
+	if violations != 2 {
+		t.Errorf("reputation violations = %d, want 2", violations)
+	}
+}
+
+func TestViolation_Checks(t *testing.T) {
+	initial := func(dcidLen, scidLen byte) []byte {
+		pkt := append([]byte{0xc0, 0, 0, 0, 1, dcidLen}, make([]byte, dcidLen)...)
+		return append(append(pkt, scidLen), make([]byte, 1200)...)
+	}
+	if invalidCID(initial(8, 8)) || invalidCID(initial(20, 0)) {
+		t.Error("valid CIDs flagged")
+	}
+	if !invalidCID(initial(21, 8)) || !invalidCID(initial(8, 21)) {
+		t.Error("21-byte CID not flagged")
+	}
+	long := initial(21, 0)
+	long[4] = 0x0a // Other versions may use longer CIDs
+	if invalidCID(long) {
+		t.Error("unknown version flagged")
+	}
+
+	cid := make([]byte, 8)
+	if !shortPacket(make([]byte, 28), cid) || shortPacket(make([]byte, 29), cid) {
+		t.Error("short packet bound wrong")
+	}
+}
@@ -0,0 +1,240 @@
+// Package redis is a minimal Redis client: commands over RESP2 on a small
+// pool of connections, with AUTH and SELECT on connect. It covers what the
+// relay shares between nodes, such as rate limit counters updated by scripts.
+package redis
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTimeoutMs = 50
+	defaultPoolSize  = 8
+	maxBulkLen       = 512 << 20
+)
+
+// Config is the server a Client connects to.
+type Config struct {
+	Addr      string `json:"addr"`                 // host:port
+	Username  string `json:"username,omitempty"`   // ACL user (default: the default user)
+	Password  string `json:"password,omitempty"`   // Sent with AUTH when set
+	DB        int    `json:"db,omitempty"`         // Selected after connecting
+	TimeoutMs int    `json:"timeout_ms,omitempty"` // Dial and command timeout (default: 50)
+	PoolSize  int    `json:"pool_size,omitempty"`  // Idle connections kept open (default: 8)
+}
+
+// Error is an error reply from the server. The connection stays usable.
+type Error string
+
+func (e Error) Error() string { return "redis: " + string(e) }
+
+// ErrClosed is returned by commands on a closed Client.
+var ErrClosed = errors.New("redis: client closed")
+
+// Client runs commands on a pool of connections. Connections are opened on
+// demand; those that fail are discarded. It is safe for concurrent use.
+type Client struct {
+	cfg     Config
+	timeout time.Duration
+
+	mu     sync.Mutex
+	idle   []*conn
+	closed bool
+}
+
+type conn struct {
+	nc net.Conn
+	r  *bufio.Reader
+	w  *bufio.Writer
+}
+
+// New returns a Client for cfg. It does not connect until the first command.
+func New(cfg Config) (*Client, error) {
+	if cfg.Addr == "" {
+		return nil, errors.New("redis 'addr' is required")
+	}
+	if _, _, err := net.SplitHostPort(cfg.Addr); err != nil {
+		return nil, fmt.Errorf("redis 'addr': %w", err)
+	}
+	if cfg.TimeoutMs < 0 || cfg.PoolSize < 0 || cfg.DB < 0 {
+		return nil, errors.New("redis 'timeout_ms', 'pool_size' and 'db' must be >= 0")
+	}
+	if cfg.TimeoutMs == 0 {
+		cfg.TimeoutMs = defaultTimeoutMs
+	}
+	if cfg.PoolSize == 0 {
+		cfg.PoolSize = defaultPoolSize
+	}
+	return &Client{cfg: cfg, timeout: time.Duration(cfg.TimeoutMs) * time.Millisecond}, nil
+}
+
+// Do runs a command and returns its reply: a string for simple and bulk
+// strings, int64 for integers, []any for arrays, and nil for null replies.
+// Error replies are returned as Error.
+func (c *Client) Do(args ...string) (any, error) {
+	cn, err := c.get()
+	if err != nil {
+		return nil, err
+	}
+	reply, err := cn.do(c.timeout, args)
+	var replyErr Error
+	if err != nil && !errors.As(err, &replyErr) {
+		cn.nc.Close()
+		return nil, err
+	}
+	c.put(cn)
+	return reply, err
+}
+
+// Close closes idle connections; connections in use are closed when their
+// command returns.
+func (c *Client) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	for _, cn := range c.idle {
+		cn.nc.Close()
+	}
+	c.idle = nil
+	return nil
+}
+
+func (c *Client) get() (*conn, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrClosed
+	}
+	if n := len(c.idle); n > 0 {
+		cn := c.idle[n-1]
+		c.idle = c.idle[:n-1]
+		c.mu.Unlock()
+		return cn, nil
+	}
+	c.mu.Unlock()
+	return c.dial()
+}
+
+func (c *Client) put(cn *conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed || len(c.idle) >= c.cfg.PoolSize {
+		cn.nc.Close()
+		return
+	}
+	c.idle = append(c.idle, cn)
+}
+
+// dial connects and authenticates a new connection.
+func (c *Client) dial() (*conn, error) {
+	nc, err := net.DialTimeout("tcp", c.cfg.Addr, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	cn := &conn{nc: nc, r: bufio.NewReader(nc), w: bufio.NewWriter(nc)}
+	var setup [][]string
+	switch {
+	case c.cfg.Username != "":
+		setup = append(setup, []string{"AUTH", c.cfg.Username, c.cfg.Password})
+	case c.cfg.Password != "":
+		setup = append(setup, []string{"AUTH", c.cfg.Password})
+	}
+	if c.cfg.DB != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(c.cfg.DB)})
+	}
+	for _, args := range setup {
+		if _, err := cn.do(c.timeout, args); err != nil {
+			nc.Close()
+			return nil, fmt.Errorf("%s: %w", args[0], err)
+		}
+	}
+	return cn, nil
+}
+
+// do writes a command and reads its reply within timeout.
+func (cn *conn) do(timeout time.Duration, args []string) (any, error) {
+	cn.nc.SetDeadline(time.Now().Add(timeout))
+	cn.w.WriteString("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, arg := range args {
+		cn.w.WriteString("$" + strconv.Itoa(len(arg)) + "\r\n")
+		cn.w.WriteString(arg)
+		cn.w.WriteString("\r\n")
+	}
+	if err := cn.w.Flush(); err != nil {
+		return nil, err
+	}
+	return readReply(cn.r)
+}
+
+// readReply reads one RESP2 reply. Error replies nested in arrays are
+// returned as Error values in the array.
+func readReply(r *bufio.Reader) (any, error) {
+	line, err := readLine(r)
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, Error(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 || n > maxBulkLen {
+			return nil, fmt.Errorf("redis: bad bulk length %q", line[1:])
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil || n < -1 {
+			return nil, fmt.Errorf("redis: bad array length %q", line[1:])
+		}
+		if n == -1 {
+			return nil, nil
+		}
+		items := make([]any, 0, min(n, 1024))
+		for range n {
+			item, err := readReply(r)
+			var replyErr Error
+			if errors.As(err, &replyErr) {
+				item = replyErr
+			} else if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+		}
+		return items, nil
+	}
+	return nil, fmt.Errorf("redis: unknown reply type %q", line[0])
+}
+
+// readLine reads a line without its CRLF.
+func readLine(r *bufio.Reader) (string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if len(line) < 2 || line[len(line)-2] != '\r' {
+		return "", errors.New("redis: malformed reply line")
+	}
+	return line[:len(line)-2], nil
+}
@@ -0,0 +1,164 @@
+package redis
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"quic-relay/internal/redis/redistest"
+)
+
+func TestDo_Replies(t *testing.T) {
+	srv := redistest.NewServer(t, func(args []string) any {
+		switch args[0] {
+		case "GET":
+			if args[1] == "missing" {
+				return nil
+			}
+			return "value\r\nwith crlf"
+		case "INCR":
+			return 7
+		case "EVAL":
+			return []any{int64(1), "two", nil, redistest.Error("ERR inner"), []any{"nested"}}
+		}
+		return redistest.Error("ERR unknown command '" + args[0] + "'")
+	})
+	c, err := New(Config{Addr: srv.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	tests := []struct {
+		args    []string
+		want    any
+		wantErr string
+	}{
+		{[]string{"GET", "key"}, "value\r\nwith crlf", ""},
+		{[]string{"GET", "missing"}, nil, ""},
+		{[]string{"INCR", "key"}, int64(7), ""},
+		{[]string{"EVAL", "return 1", "0"}, []any{int64(1), "two", nil, Error("ERR inner"), []any{"nested"}}, ""},
+		{[]string{"FLUSHALL"}, nil, "redis: ERR unknown command 'FLUSHALL'"},
+	}
+	for _, tt := range tests {
+		got, err := c.Do(tt.args...)
+		if tt.wantErr != "" {
+			var replyErr Error
+			if err == nil || err.Error() != tt.wantErr || !errors.As(err, &replyErr) {
+				t.Errorf("%v: error = %v, want %s", tt.args, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%v = %#v, %v; want %#v", tt.args, got, err, tt.want)
+		}
+	}
+	// An error reply leaves the connection usable
+	if got, err := c.Do("INCR", "key"); err != nil || got != int64(7) {
+		t.Errorf("after error reply: %v, %v", got, err)
+	}
+	if n := len(c.idle); n != 1 {
+		t.Errorf("%d idle connections, want 1", n)
+	}
+}
+
+func TestDo_AuthAndSelect(t *testing.T) {
+	tests := []struct {
+		cfg  Config
+		want [][]string
+	}{
+		{Config{}, [][]string{{"PING"}}},
+		{Config{Password: "secret"}, [][]string{{"AUTH", "secret"}, {"PING"}}},
+		{Config{Username: "relay", Password: "secret", DB: 2}, [][]string{{"AUTH", "relay", "secret"}, {"SELECT", "2"}, {"PING"}}},
+	}
+	for _, tt := range tests {
+		srv := redistest.NewServer(t, func(args []string) any { return "OK" })
+		tt.cfg.Addr = srv.Addr
+		c, err := New(tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := c.Do("PING"); err != nil {
+			t.Fatal(err)
+		}
+		c.Close()
+		if got := srv.Commands(); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%+v: commands = %v, want %v", tt.cfg, got, tt.want)
+		}
+	}
+}
+
+func TestDo_AuthFailure(t *testing.T) {
+	srv := redistest.NewServer(t, func(args []string) any {
+		return redistest.Error("WRONGPASS invalid username-password pair")
+	})
+	c, err := New(Config{Addr: srv.Addr, Password: "wrong"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("PING"); err == nil || !strings.Contains(err.Error(), "AUTH: redis: WRONGPASS") {
+		t.Errorf("error = %v, want AUTH failure", err)
+	}
+}
+
+func TestDo_ServerGone(t *testing.T) {
+	srv := redistest.NewServer(t, func(args []string) any { return "PONG" })
+	c, err := New(Config{Addr: srv.Addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.Do("PING"); err != nil {
+		t.Fatal(err)
+	}
+	srv.Close()
+	if _, err := c.Do("PING"); err == nil {
+		t.Fatal("command succeeded after the server closed")
+	}
+	if n := len(c.idle); n != 0 {
+		t.Errorf("%d idle connections after failure, want 0", n)
+	}
+	c.Close()
+	if _, err := c.Do("PING"); !errors.Is(err, ErrClosed) {
+		t.Errorf("error after Close = %v, want ErrClosed", err)
+	}
+}
+
+func TestDo_Concurrent(t *testing.T) {
+	srv := redistest.NewServer(t, func(args []string) any { return args[1] })
+	c, err := New(Config{Addr: srv.Addr, PoolSize: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	var wg sync.WaitGroup
+	for i := range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			want := strings.Repeat("x", i)
+			if got, err := c.Do("ECHO", want); err != nil || got != want {
+				t.Errorf("ECHO %q = %v, %v", want, got, err)
+			}
+		}()
+	}
+	wg.Wait()
+	if n := len(c.idle); n > 2 {
+		t.Errorf("%d idle connections, want at most pool_size 2", n)
+	}
+}
+
+func TestNew_Invalid(t *testing.T) {
+	for _, cfg := range []Config{
+		{},
+		{Addr: "localhost"},
+		{Addr: "localhost:6379", TimeoutMs: -1},
+		{Addr: "localhost:6379", DB: -1},
+	} {
+		if _, err := New(cfg); err == nil {
+			t.Errorf("New(%+v) succeeded, want error", cfg)
+		}
+	}
+}
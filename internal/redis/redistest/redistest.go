@@ -0,0 +1,152 @@
+// Package redistest provides a fake Redis server for tests. It speaks RESP2
+// and answers every command with a reply chosen by the test.
+package redistest
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// Error is an error reply.
+type Error string
+
+// Handler returns the reply to a command: a string (sent as a bulk string),
+// an int or int64, nil, an Error, or a []any of those.
+type Handler func(args []string) any
+
+// Server is a Redis server on localhost. It is closed when the test ends.
+type Server struct {
+	Addr string
+
+	ln      net.Listener
+	handler Handler
+
+	mu       sync.Mutex
+	conns    map[net.Conn]struct{}
+	commands [][]string
+	closed   bool
+}
+
+// NewServer starts a server answering commands with handler.
+func NewServer(t testing.TB, handler Handler) *Server {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &Server{Addr: ln.Addr().String(), ln: ln, handler: handler, conns: make(map[net.Conn]struct{})}
+	t.Cleanup(s.Close)
+	go s.serve()
+	return s
+}
+
+// Commands returns the commands received so far, in order.
+func (s *Server) Commands() [][]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([][]string(nil), s.commands...)
+}
+
+// Close stops the server and closes its connections.
+func (s *Server) Close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.closed {
+		return
+	}
+	s.closed = true
+	s.ln.Close()
+	for c := range s.conns {
+		c.Close()
+	}
+}
+
+func (s *Server) serve() {
+	for {
+		c, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			c.Close()
+			return
+		}
+		s.conns[c] = struct{}{}
+		s.mu.Unlock()
+		go s.handle(c)
+	}
+}
+
+func (s *Server) handle(c net.Conn) {
+	defer func() {
+		s.mu.Lock()
+		delete(s.conns, c)
+		s.mu.Unlock()
+		c.Close()
+	}()
+	r := bufio.NewReader(c)
+	w := bufio.NewWriter(c)
+	for {
+		args, err := readCommand(r)
+		if err != nil {
+			return
+		}
+		s.mu.Lock()
+		s.commands = append(s.commands, args)
+		s.mu.Unlock()
+		writeReply(w, s.handler(args))
+		if err := w.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// readCommand reads a command sent as an array of bulk strings.
+func readCommand(r *bufio.Reader) ([]string, error) {
+	var n int
+	if _, err := fmt.Fscanf(r, "*%d\r\n", &n); err != nil {
+		return nil, err
+	}
+	args := make([]string, n)
+	for i := range args {
+		var size int
+		if _, err := fmt.Fscanf(r, "$%d\r\n", &size); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, size+2)
+		if _, err := io.ReadFull(r, buf); err != nil {
+			return nil, err
+		}
+		args[i] = string(buf[:size])
+	}
+	return args, nil
+}
+
+func writeReply(w *bufio.Writer, reply any) {
+	switch v := reply.(type) {
+	case nil:
+		w.WriteString("$-1\r\n")
+	case Error:
+		w.WriteString("-" + string(v) + "\r\n")
+	case int:
+		w.WriteString(":" + strconv.Itoa(v) + "\r\n")
+	case int64:
+		w.WriteString(":" + strconv.FormatInt(v, 10) + "\r\n")
+	case string:
+		w.WriteString("$" + strconv.Itoa(len(v)) + "\r\n" + v + "\r\n")
+	case []any:
+		w.WriteString("*" + strconv.Itoa(len(v)) + "\r\n")
+		for _, item := range v {
+			writeReply(w, item)
+		}
+	default:
+		panic(fmt.Sprintf("redistest: unsupported reply %T", reply))
+	}
+}
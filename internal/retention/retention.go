@@ -0,0 +1,268 @@
+// Package retention deletes old files the relay's observability features
+// wrote: rotated log files and capture files. Writers register the
+// directories they write to as stores and hold the files they still write;
+// Sweep deletes what a policy no longer allows.
+//
+// Files are grouped by partition: the directory named tenant=<name> or
+// sni=<name> they are in, or the store itself. Stores are only searched
+// through partition directories, never other subdirectories. Each
+// partition gets the policy of its tenant or SNI, or the default one, and
+// its size limit applies to the partition's files of one store.
+package retention
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Partition directory name prefixes.
+const (
+	TenantPrefix = "tenant="
+	SNIPrefix    = "sni="
+)
+
+// Policy limits the files of a partition.
+type Policy struct {
+	MaxAge    int `json:"max_age,omitempty"`     // Seconds a file is kept after its last write (0 = no limit)
+	MaxSizeMB int `json:"max_size_mb,omitempty"` // Size of a partition's files, oldest deleted first (0 = no limit)
+}
+
+// Config is the retention configuration.
+type Config struct {
+	Interval int               `json:"interval,omitempty"` // Seconds between sweeps (default: 300)
+	Default  Policy            `json:"default"`            // Files of no tenant or SNI, and of those without a policy
+	Tenants  map[string]Policy `json:"tenants,omitempty"`  // By tenant name
+	SNIs     map[string]Policy `json:"snis,omitempty"`     // By exact name, or "*.example.com" for all subdomains
+}
+
+// Validate checks cfg.
+func (c *Config) Validate() error {
+	if c.Interval < 0 {
+		return errors.New("'interval' must be >= 0")
+	}
+	check := func(what string, p Policy) error {
+		if p.MaxAge < 0 || p.MaxSizeMB < 0 {
+			return fmt.Errorf("%s: max_age and max_size_mb must be >= 0", what)
+		}
+		return nil
+	}
+	errs := []error{check("default", c.Default)}
+	for name, p := range c.Tenants {
+		errs = append(errs, check("tenant "+name, p))
+	}
+	for name, p := range c.SNIs {
+		if strings.Contains(strings.TrimPrefix(name, "*."), "*") {
+			errs = append(errs, fmt.Errorf("sni %s: only a leading \"*.\" wildcard is allowed", name))
+		}
+		errs = append(errs, check("sni "+name, p))
+	}
+	return errors.Join(errs...)
+}
+
+// policy returns the policy of the partition directory named partition.
+func (c *Config) policy(partition string) Policy {
+	if name, ok := strings.CutPrefix(partition, TenantPrefix); ok {
+		if p, ok := c.Tenants[name]; ok {
+			return p
+		}
+	}
+	if name, ok := strings.CutPrefix(partition, SNIPrefix); ok {
+		if p, ok := c.SNIs[name]; ok {
+			return p
+		}
+		// The longest matching wildcard wins
+		best := -1
+		var found Policy
+		for pattern, p := range c.SNIs {
+			suffix, ok := strings.CutPrefix(pattern, "*")
+			if ok && len(suffix) > best && strings.HasSuffix(name, suffix) {
+				best, found = len(suffix), p
+			}
+		}
+		if best >= 0 {
+			return found
+		}
+	}
+	return c.Default
+}
+
+// PartitionDir returns the directory name of a tenant's or SNI's partition,
+// made safe for a file name. prefix is TenantPrefix or SNIPrefix.
+func PartitionDir(prefix, name string) string {
+	b := []byte(name)
+	for i, c := range b {
+		switch {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '-', c == '_', c == '.' && i > 0:
+		default:
+			b[i] = '_'
+		}
+	}
+	return prefix + string(b)
+}
+
+// store is a directory writers register; prefix limits the files of it
+// retention may delete.
+type store struct {
+	dir, prefix string
+}
+
+var registry = struct {
+	sync.Mutex
+	stores map[store]int  // Registrations
+	held   map[string]int // Files still written, by path
+}{stores: make(map[store]int), held: make(map[string]int)}
+
+// AddStore registers a directory of files retention may delete, those whose
+// names start with prefix. It returns the function that removes it again.
+func AddStore(dir, prefix string) (remove func()) {
+	s := store{filepath.Clean(dir), prefix}
+	registry.Lock()
+	registry.stores[s]++
+	registry.Unlock()
+	return sync.OnceFunc(func() {
+		registry.Lock()
+		defer registry.Unlock()
+		if registry.stores[s]--; registry.stores[s] <= 0 {
+			delete(registry.stores, s)
+		}
+	})
+}
+
+// Hold keeps a file being written from being deleted until release is called.
+func Hold(path string) (release func()) {
+	path = filepath.Clean(path)
+	registry.Lock()
+	registry.held[path]++
+	registry.Unlock()
+	return sync.OnceFunc(func() {
+		registry.Lock()
+		defer registry.Unlock()
+		if registry.held[path]--; registry.held[path] <= 0 {
+			delete(registry.held, path)
+		}
+	})
+}
+
+// Stores returns the registered store directories.
+func Stores() []string {
+	registry.Lock()
+	defer registry.Unlock()
+	var dirs []string
+	for s := range registry.stores {
+		dirs = append(dirs, s.dir)
+	}
+	slices.Sort(dirs)
+	return slices.Compact(dirs)
+}
+
+// Result counts what a sweep deleted.
+type Result struct {
+	Files  int
+	Bytes  int64
+	Errors []error // Files that could not be read or deleted
+}
+
+// file is a candidate for deletion.
+type file struct {
+	path    string
+	size    int64
+	modTime time.Time
+	held    bool // Still written: counted, never deleted
+}
+
+// Sweep deletes the files of the registered stores that cfg no longer allows
+// at now: first those older than their max_age, then the oldest ones of
+// partitions above max_size_mb.
+func Sweep(cfg *Config, now time.Time) Result {
+	registry.Lock()
+	stores := make([]store, 0, len(registry.stores))
+	for s := range registry.stores {
+		stores = append(stores, s)
+	}
+	registry.Unlock()
+	slices.SortFunc(stores, func(a, b store) int {
+		return strings.Compare(a.dir+"\x00"+a.prefix, b.dir+"\x00"+b.prefix)
+	})
+
+	var res Result
+	for _, s := range stores {
+		for partition, files := range s.scan(&res) {
+			sweepPartition(cfg.policy(filepath.Base(partition)), files, now, &res)
+		}
+	}
+	return res
+}
+
+// scan lists the deletable files of s by partition directory.
+func (s store) scan(res *Result) map[string][]file {
+	partitions := make(map[string][]file)
+	err := filepath.WalkDir(s.dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				res.Errors = append(res.Errors, err)
+			}
+			return nil
+		}
+		if d.IsDir() && path != s.dir && !isPartition(d.Name()) {
+			return fs.SkipDir
+		}
+		if !d.Type().IsRegular() || !strings.HasPrefix(d.Name(), s.prefix) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				res.Errors = append(res.Errors, err)
+			}
+			return nil
+		}
+		partition := filepath.Dir(path)
+		partitions[partition] = append(partitions[partition], file{path, info.Size(), info.ModTime(), held(path)})
+		return nil
+	})
+	if err != nil {
+		res.Errors = append(res.Errors, err)
+	}
+	return partitions
+}
+
+func isPartition(name string) bool {
+	return strings.HasPrefix(name, TenantPrefix) || strings.HasPrefix(name, SNIPrefix)
+}
+
+func held(path string) bool {
+	registry.Lock()
+	defer registry.Unlock()
+	return registry.held[path] > 0
+}
+
+func sweepPartition(p Policy, files []file, now time.Time, res *Result) {
+	slices.SortFunc(files, func(a, b file) int { return a.modTime.Compare(b.modTime) })
+	var total int64
+	for _, f := range files {
+		total += f.size
+	}
+	maxSize := int64(p.MaxSizeMB) << 20
+	for _, f := range files {
+		expired := p.MaxAge > 0 && now.Sub(f.modTime) > time.Duration(p.MaxAge)*time.Second
+		if f.held || !expired && (maxSize == 0 || total <= maxSize) {
+			continue
+		}
+		if err := os.Remove(f.path); err != nil {
+			if !errors.Is(err, fs.ErrNotExist) {
+				res.Errors = append(res.Errors, err)
+			}
+			continue
+		}
+		total -= f.size
+		res.Files++
+		res.Bytes += f.size
+	}
+}
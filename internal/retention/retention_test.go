@@ -0,0 +1,155 @@
+package retention
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeFile creates a file of size bytes last written age before now.
+func writeFile(t *testing.T, path string, size int, age time.Duration, now time.Time) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, make([]byte, size), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(path, now.Add(-age), now.Add(-age)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func TestSweep(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	const mb = 1 << 20
+	files := map[string]struct {
+		size int
+		age  time.Duration
+	}{
+		"old.pcap":                           {10, 48 * time.Hour},
+		"new.pcap":                           {10, time.Hour},
+		"tenant=acme/a1.pcap":                {mb, 3 * time.Hour},
+		"tenant=acme/a2.pcap":                {mb, 2 * time.Hour},
+		"tenant=acme/a3.pcap":                {mb, time.Hour},
+		"sni=play.example.com/p.pcap":        {10, 10 * 24 * time.Hour},
+		"sni=other.example.org/o.pcap":       {10, 10 * 24 * time.Hour},
+		"tenant=acme/sni=x.example.com/x.pc": {10, 10 * 24 * time.Hour},
+		"unrelated/old.pcap":                 {10, 48 * time.Hour},
+	}
+	for name, f := range files {
+		writeFile(t, filepath.Join(dir, name), f.size, f.age, now)
+	}
+	release := Hold(filepath.Join(dir, "tenant=acme", "a1.pcap"))
+	defer release()
+	remove := AddStore(dir, "")
+	defer remove()
+
+	cfg := &Config{
+		Default: Policy{MaxAge: 86400},
+		Tenants: map[string]Policy{"acme": {MaxSizeMB: 2}},
+		SNIs:    map[string]Policy{"*.example.com": {MaxAge: 30 * 86400}},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatal(err)
+	}
+	res := Sweep(cfg, now)
+	if len(res.Errors) != 0 {
+		t.Fatal(res.Errors)
+	}
+
+	// acme is above 2 MB: a1 is held, so a2 goes
+	kept := []string{"new.pcap", "tenant=acme/a1.pcap", "tenant=acme/a3.pcap", "sni=play.example.com/p.pcap", "tenant=acme/sni=x.example.com/x.pc", "unrelated/old.pcap"}
+	deleted := []string{"old.pcap", "tenant=acme/a2.pcap", "sni=other.example.org/o.pcap"}
+	for _, name := range kept {
+		if !exists(filepath.Join(dir, name)) {
+			t.Errorf("%s deleted", name)
+		}
+	}
+	for _, name := range deleted {
+		if exists(filepath.Join(dir, name)) {
+			t.Errorf("%s kept", name)
+		}
+	}
+	if res.Files != len(deleted) || res.Bytes != mb+20 {
+		t.Errorf("result %d files, %d bytes", res.Files, res.Bytes)
+	}
+
+	// Without the store nothing is swept
+	remove()
+	writeFile(t, filepath.Join(dir, "old.pcap"), 10, 48*time.Hour, now)
+	if res := Sweep(cfg, now); res.Files != 0 || !exists(filepath.Join(dir, "old.pcap")) {
+		t.Errorf("swept an unregistered store: %+v", res)
+	}
+}
+
+func TestSweep_Prefix(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	for _, name := range []string{"relay.log", "relay.log.20260101-000000.000", "other.log.20260101-000000.000"} {
+		writeFile(t, filepath.Join(dir, name), 10, 48*time.Hour, now)
+	}
+	defer AddStore(dir, "relay.log.")()
+	Sweep(&Config{Default: Policy{MaxAge: 3600}}, now)
+	if !exists(filepath.Join(dir, "relay.log")) || !exists(filepath.Join(dir, "other.log.20260101-000000.000")) {
+		t.Error("deleted a file outside the prefix")
+	}
+	if exists(filepath.Join(dir, "relay.log.20260101-000000.000")) {
+		t.Error("kept an expired rotated file")
+	}
+}
+
+func TestPartitionDir(t *testing.T) {
+	for name, want := range map[string]string{
+		"acme":             "tenant=acme",
+		"../etc":           "tenant=_._etc",
+		"a/b c":            "tenant=a_b_c",
+		".hidden":          "tenant=_hidden",
+		"play.example.com": "tenant=play.example.com",
+	} {
+		if got := PartitionDir(TenantPrefix, name); got != want {
+			t.Errorf("PartitionDir(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	for _, cfg := range []Config{
+		{Interval: -1},
+		{Default: Policy{MaxAge: -1}},
+		{Tenants: map[string]Policy{"acme": {MaxSizeMB: -1}}},
+		{SNIs: map[string]Policy{"play.*.com": {}}},
+	} {
+		if err := cfg.Validate(); err == nil {
+			t.Errorf("%+v accepted", cfg)
+		}
+	}
+	cfg := Config{Default: Policy{MaxAge: 1}, SNIs: map[string]Policy{
+		"*.example.com":      {MaxAge: 2},
+		"*.play.example.com": {MaxAge: 3},
+		"play.example.com":   {MaxAge: 4},
+	}}
+	for partition, want := range map[string]int{
+		"sni=a.play.example.com": 3,
+		"sni=play.example.com":   4,
+		"sni=www.example.com":    2,
+		"sni=example.org":        1,
+		"tenant=acme":            1,
+		"store":                  1,
+	} {
+		if got := cfg.policy(partition).MaxAge; got != want {
+			t.Errorf("policy(%s) = %d, want %d", partition, got, want)
+		}
+	}
+	if strings.Contains(PartitionDir(SNIPrefix, "x/y"), "/") {
+		t.Error("separator in a partition name")
+	}
+}
@@ -0,0 +1,127 @@
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// resolveAWSSecret reads an AWS Secrets Manager secret: ${aws-sm:name#field}.
+// Without #field the whole SecretString is used. Credentials come from
+// AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_SESSION_TOKEN, the region
+// from AWS_REGION (or AWS_DEFAULT_REGION).
+func resolveAWSSecret(ref string) (string, error) {
+	name, field := splitField(ref)
+	keyID := os.Getenv("AWS_ACCESS_KEY_ID")
+	secret := os.Getenv("AWS_SECRET_ACCESS_KEY")
+	region := os.Getenv("AWS_REGION")
+	if region == "" {
+		region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if keyID == "" || secret == "" || region == "" {
+		return "", errors.New("AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and AWS_REGION must be set")
+	}
+
+	endpoint := os.Getenv("AWS_ENDPOINT_URL_SECRETS_MANAGER")
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	payload, _ := json.Marshal(map[string]string{"SecretId": name})
+	req, err := http.NewRequest(http.MethodPost, endpoint+"/", bytes.NewReader(payload))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if token := os.Getenv("AWS_SESSION_TOKEN"); token != "" {
+		req.Header.Set("X-Amz-Security-Token", token)
+	}
+	signV4(req, payload, keyID, secret, region, "secretsmanager", time.Now().UTC())
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("secrets manager returned %s", resp.Status)
+	}
+	var out struct {
+		SecretString *string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return "", fmt.Errorf("invalid secrets manager response: %w", err)
+	}
+	if out.SecretString == nil {
+		return "", errors.New("secret has no SecretString (binary secrets are not supported)")
+	}
+	if field == "" {
+		return *out.SecretString, nil
+	}
+	var doc map[string]any
+	if err := json.Unmarshal([]byte(*out.SecretString), &doc); err != nil {
+		return "", fmt.Errorf("secret is not a JSON object: %w", err)
+	}
+	return jsonField(doc, field)
+}
+
+// signV4 adds an AWS Signature Version 4 Authorization header to req.
+func signV4(req *http.Request, payload []byte, keyID, secret, region, service string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("Host", req.URL.Host)
+
+	// Canonical request over all set headers, lowercased and sorted
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, strings.ToLower(name))
+	}
+	sort.Strings(names)
+	var canonHeaders strings.Builder
+	for _, name := range names {
+		canonHeaders.WriteString(name + ":" + strings.TrimSpace(req.Header.Get(name)) + "\n")
+	}
+	signed := strings.Join(names, ";")
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	payloadHash := sha256.Sum256(payload)
+	canonical := strings.Join([]string{
+		req.Method, path, req.URL.RawQuery, canonHeaders.String(), signed, hex.EncodeToString(payloadHash[:]),
+	}, "\n")
+
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	canonicalHash := sha256.Sum256([]byte(canonical))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonicalHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secret), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		keyID, scope, signed, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,184 @@
+// Package secrets expands references to environment variables and external
+// secret stores in configuration values, so secrets stay out of config files.
+//
+// A reference is written ${NAME} for an environment variable (${NAME:-default}
+// supplies a fallback) or ${scheme:ref} for a registered resolver, for example
+// ${file:/run/secrets/admin-token} or ${vault:secret/data/relay#token}.
+// $${ produces a literal ${.
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// Resolver looks up a secret by reference. The reference is everything after
+// "scheme:" in ${scheme:ref}.
+type Resolver interface {
+	Resolve(ref string) (string, error)
+}
+
+// ResolverFunc adapts a function to Resolver.
+type ResolverFunc func(ref string) (string, error)
+
+// Resolve calls f(ref).
+func (f ResolverFunc) Resolve(ref string) (string, error) {
+	return f(ref)
+}
+
+var (
+	resolversMu sync.RWMutex
+	resolvers   = make(map[string]Resolver)
+)
+
+// Register makes a resolver available under scheme.
+// Schemes are lowercase and must not be valid environment variable names.
+func Register(scheme string, r Resolver) {
+	resolversMu.Lock()
+	defer resolversMu.Unlock()
+	resolvers[scheme] = r
+}
+
+func lookupResolver(scheme string) (Resolver, bool) {
+	resolversMu.RLock()
+	defer resolversMu.RUnlock()
+	r, ok := resolvers[scheme]
+	return r, ok
+}
+
+// Expand replaces all references in s.
+func Expand(s string) (string, error) {
+	if !strings.Contains(s, "${") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.Index(s, "${")
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		// $${ is an escaped literal
+		if i > 0 && s[i-1] == '$' {
+			b.WriteString(s[:i-1])
+			b.WriteString("${")
+			s = s[i+2:]
+			continue
+		}
+		end := strings.IndexByte(s[i:], '}')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated reference in %q", s)
+		}
+		value, err := resolve(s[i+2 : i+end])
+		if err != nil {
+			return "", err
+		}
+		b.WriteString(s[:i])
+		b.WriteString(value)
+		s = s[i+end+1:]
+	}
+}
+
+// resolve looks up one reference (the text between ${ and }).
+func resolve(ref string) (string, error) {
+	if scheme, rest, ok := strings.Cut(ref, ":"); ok && !strings.HasPrefix(rest, "-") {
+		r, found := lookupResolver(scheme)
+		if !found {
+			return "", fmt.Errorf("${%s}: unknown secret source %q", ref, scheme)
+		}
+		v, err := r.Resolve(rest)
+		if err != nil {
+			return "", fmt.Errorf("${%s}: %w", ref, err)
+		}
+		return v, nil
+	}
+
+	name, def, hasDefault := strings.Cut(ref, ":-")
+	if v, ok := os.LookupEnv(name); ok && v != "" {
+		return v, nil
+	}
+	if hasDefault {
+		return def, nil
+	}
+	return "", fmt.Errorf("${%s}: environment variable not set", name)
+}
+
+// ExpandJSON replaces references in all string values of a JSON document.
+// Object keys are left unchanged. Documents without references are returned as is.
+func ExpandJSON(data []byte) ([]byte, error) {
+	if !strings.Contains(string(data), "${") {
+		return data, nil
+	}
+	var doc any
+	if err := json.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	doc, err := expandValue(doc)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+func expandValue(v any) (any, error) {
+	switch t := v.(type) {
+	case string:
+		return Expand(t)
+	case map[string]any:
+		for k, val := range t {
+			expanded, err := expandValue(val)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			t[k] = expanded
+		}
+	case []any:
+		for i, val := range t {
+			expanded, err := expandValue(val)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			t[i] = expanded
+		}
+	}
+	return v, nil
+}
+
+func init() {
+	Register("file", ResolverFunc(resolveFile))
+	Register("vault", ResolverFunc(resolveVault))
+	Register("aws-sm", ResolverFunc(resolveAWSSecret))
+}
+
+// resolveFile reads a secret file, without its trailing newline.
+func resolveFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// splitField splits "ref#field" into the reference and an optional JSON field.
+func splitField(ref string) (string, string) {
+	ref, field, _ := strings.Cut(ref, "#")
+	return ref, field
+}
+
+// jsonField extracts a string field from a JSON object.
+func jsonField(doc map[string]any, field string) (string, error) {
+	v, ok := doc[field]
+	if !ok {
+		return "", fmt.Errorf("no field %q", field)
+	}
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	default:
+		data, err := json.Marshal(t)
+		return string(data), err
+	}
+}
@@ -0,0 +1,145 @@
+package secrets
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpand(t *testing.T) {
+	t.Setenv("RELAY_TEST_TOKEN", "s3cret")
+	t.Setenv("RELAY_TEST_EMPTY", "")
+	file := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(file, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		in      string
+		want    string
+		wantErr string
+	}{
+		{"plain", "plain", ""},
+		{"${RELAY_TEST_TOKEN}", "s3cret", ""},
+		{"Bearer ${RELAY_TEST_TOKEN}!", "Bearer s3cret!", ""},
+		{"${RELAY_TEST_MISSING:-:5520}", ":5520", ""},
+		{"${RELAY_TEST_EMPTY:-fallback}", "fallback", ""},
+		{"${file:" + file + "}", "from-file", ""},
+		{"$${RELAY_TEST_TOKEN}", "${RELAY_TEST_TOKEN}", ""},
+		{"${RELAY_TEST_MISSING}", "", "not set"},
+		{"${nope:x}", "", "unknown secret source"},
+		{"${RELAY_TEST_TOKEN", "", "unterminated"},
+	}
+	for _, tt := range tests {
+		got, err := Expand(tt.in)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expand(%q) error = %v, want %q", tt.in, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("Expand(%q) = %q, %v; want %q", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestExpandJSON(t *testing.T) {
+	t.Setenv("RELAY_TEST_TOKEN", `quo"te`)
+	in := `{"admin": {"tokens": [{"token": "${RELAY_TEST_TOKEN}", "role": "read"}]}, "${KEY}": 1}`
+	out, err := ExpandJSON([]byte(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(out, &doc); err != nil {
+		t.Fatal(err)
+	}
+	token := doc["admin"].(map[string]any)["tokens"].([]any)[0].(map[string]any)["token"]
+	if token != `quo"te` {
+		t.Errorf("token = %v", token)
+	}
+	if _, ok := doc["${KEY}"]; !ok {
+		t.Error("object key was expanded")
+	}
+
+	if _, err := ExpandJSON([]byte(`{"a": ["${RELAY_TEST_MISSING}"]}`)); err == nil || !strings.Contains(err.Error(), "a: [0]") {
+		t.Errorf("error = %v, want path a: [0]", err)
+	}
+}
+
+func TestResolveVault(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "root" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/relay":
+			w.Write([]byte(`{"data": {"data": {"token": "kv2", "other": "x"}, "metadata": {"version": 3}}}`))
+		case "/v1/kv/relay":
+			w.Write([]byte(`{"data": {"token": "kv1"}}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	t.Setenv("VAULT_ADDR", srv.URL)
+	t.Setenv("VAULT_TOKEN", "root")
+
+	if got, err := Expand("${vault:secret/data/relay#token}"); err != nil || got != "kv2" {
+		t.Errorf("kv2 = %q, %v", got, err)
+	}
+	if got, err := Expand("${vault:kv/relay}"); err != nil || got != "kv1" {
+		t.Errorf("kv1 single field = %q, %v", got, err)
+	}
+	if _, err := Expand("${vault:secret/data/relay}"); err == nil {
+		t.Error("ambiguous field accepted")
+	}
+	if _, err := Expand("${vault:secret/data/missing#token}"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("error = %v, want 404", err)
+	}
+}
+
+func TestResolveAWSSecret(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" ||
+			!strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var req struct{ SecretId string }
+		json.NewDecoder(r.Body).Decode(&req)
+		json.NewEncoder(w).Encode(map[string]string{"SecretString": `{"token": "aws-` + req.SecretId + `"}`})
+	}))
+	defer srv.Close()
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "eu-central-1")
+	t.Setenv("AWS_ENDPOINT_URL_SECRETS_MANAGER", srv.URL)
+
+	if got, err := Expand("${aws-sm:relay#token}"); err != nil || got != "aws-relay" {
+		t.Errorf("field = %q, %v", got, err)
+	}
+	if got, err := Expand("${aws-sm:relay}"); err != nil || got != `{"token": "aws-relay"}` {
+		t.Errorf("whole secret = %q, %v", got, err)
+	}
+}
+
+func TestSignV4(t *testing.T) {
+	// get-vanilla from the AWS Signature Version 4 test suite
+	req, _ := http.NewRequest(http.MethodGet, "https://example.amazonaws.com/", nil)
+	now := time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC)
+	signV4(req, nil, "AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service", now)
+
+	want := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if got := req.Header.Get("Authorization"); got != want {
+		t.Errorf("Authorization =\n%s\nwant\n%s", got, want)
+	}
+}
@@ -0,0 +1,70 @@
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var httpClient = &http.Client{Timeout: 10 * time.Second}
+
+// resolveVault reads a field of a HashiCorp Vault secret: ${vault:path#field}.
+// The server and token come from VAULT_ADDR and VAULT_TOKEN (VAULT_NAMESPACE is
+// honoured). KV version 2 paths include "data/", e.g. secret/data/relay#token.
+func resolveVault(ref string) (string, error) {
+	path, field := splitField(ref)
+	addr := os.Getenv("VAULT_ADDR")
+	token := os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return "", errors.New("VAULT_ADDR and VAULT_TOKEN must be set")
+	}
+
+	req, err := http.NewRequest(http.MethodGet, strings.TrimRight(addr, "/")+"/v1/"+strings.TrimLeft(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", token)
+	if ns := os.Getenv("VAULT_NAMESPACE"); ns != "" {
+		req.Header.Set("X-Vault-Namespace", ns)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("vault returned %s", resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("invalid vault response: %w", err)
+	}
+	data := secret.Data
+	// KV version 2 nests the secret under data.data
+	if inner, ok := data["data"].(map[string]any); ok {
+		if _, hasMeta := data["metadata"]; hasMeta {
+			data = inner
+		}
+	}
+	if field == "" {
+		if len(data) != 1 {
+			return "", errors.New("secret has several fields, select one with #field")
+		}
+		for k := range data {
+			field = k
+		}
+	}
+	return jsonField(data, field)
+}
@@ -0,0 +1,135 @@
+// Package sflow encodes sFlow version 5 datagrams carrying packet flow
+// samples, as described in https://sflow.org/sflow_version_5.txt.
+package sflow
+
+import (
+	"encoding/binary"
+	"net/netip"
+	"time"
+)
+
+// MaxDatagram is the largest datagram Encode builds, so datagrams fit in one
+// packet on common paths.
+const MaxDatagram = 1400
+
+// Header protocols of raw packet header records.
+const (
+	HeaderIPv4 = 11
+	HeaderIPv6 = 12
+)
+
+const (
+	version         = 5
+	formatFlow      = 1 // Flow sample, enterprise 0
+	formatRawHeader = 1 // Raw packet header record, enterprise 0
+	sampleOverhead  = 4 + 4 + 8*4 + 4 + 4 + 4*4
+)
+
+// FlowSample is one sampled packet.
+type FlowSample struct {
+	Source      uint32 // Index of the data source the packet was sampled on
+	Rate        uint32 // One in Rate packets is sampled
+	Pool        uint32 // Packets the source has seen so far
+	Drops       uint32 // Samples lost for lack of resources
+	FrameLength uint32 // Length of the whole packet
+	Protocol    uint32 // HeaderIPv4 or HeaderIPv6
+	Header      []byte // First bytes of the packet, starting with the IP header
+}
+
+// Encoder builds sFlow datagrams. It keeps sequence numbers, so one Encoder
+// must be used per collector. It is not safe for concurrent use.
+type Encoder struct {
+	agent    netip.Addr
+	subAgent uint32
+	seq      uint32
+	samples  map[uint32]uint32 // Sample sequence numbers by source
+	boot     time.Time
+}
+
+// NewEncoder returns an encoder for an agent with the given address.
+func NewEncoder(agent netip.Addr, subAgent uint32) *Encoder {
+	return &Encoder{agent: agent.Unmap(), subAgent: subAgent, samples: make(map[uint32]uint32), boot: time.Now()}
+}
+
+// Encode returns the datagrams carrying samples, each at most MaxDatagram
+// bytes. Headers are cut to fit.
+func (e *Encoder) Encode(samples []FlowSample, now time.Time) [][]byte {
+	var out [][]byte
+	var dgram []byte
+	count := 0
+	flush := func() {
+		if dgram == nil {
+			return
+		}
+		binary.BigEndian.PutUint32(dgram[e.headerLen()-4:], uint32(count))
+		out = append(out, dgram)
+		dgram, count = nil, 0
+	}
+	for _, s := range samples {
+		header := s.Header[:min(len(s.Header), MaxDatagram-e.headerLen()-sampleOverhead)]
+		size := sampleOverhead + (len(header)+3)&^3
+		if dgram != nil && len(dgram)+size > MaxDatagram {
+			flush()
+		}
+		if dgram == nil {
+			dgram = e.appendHeader(make([]byte, 0, MaxDatagram), now)
+		}
+		e.samples[s.Source]++
+		dgram = e.appendSample(dgram, s, header)
+		count++
+	}
+	flush()
+	return out
+}
+
+func (e *Encoder) headerLen() int {
+	if e.agent.Is4() {
+		return 7 * 4
+	}
+	return 7*4 + 12
+}
+
+// appendHeader appends the datagram header; the sample count is set by Encode.
+func (e *Encoder) appendHeader(b []byte, now time.Time) []byte {
+	e.seq++
+	b = binary.BigEndian.AppendUint32(b, version)
+	if e.agent.Is4() {
+		a := e.agent.As4()
+		b = binary.BigEndian.AppendUint32(b, 1)
+		b = append(b, a[:]...)
+	} else {
+		a := e.agent.As16()
+		b = binary.BigEndian.AppendUint32(b, 2)
+		b = append(b, a[:]...)
+	}
+	b = binary.BigEndian.AppendUint32(b, e.subAgent)
+	b = binary.BigEndian.AppendUint32(b, e.seq)
+	b = binary.BigEndian.AppendUint32(b, uint32(now.Sub(e.boot).Milliseconds()))
+	return binary.BigEndian.AppendUint32(b, 0) // Number of samples
+}
+
+// appendSample appends a flow sample with one raw packet header record.
+func (e *Encoder) appendSample(b []byte, s FlowSample, header []byte) []byte {
+	padded := (len(header) + 3) &^ 3
+	record := 4*4 + padded
+
+	b = binary.BigEndian.AppendUint32(b, formatFlow)
+	b = binary.BigEndian.AppendUint32(b, uint32(8*4+4+4+record))
+	b = binary.BigEndian.AppendUint32(b, e.samples[s.Source])
+	b = binary.BigEndian.AppendUint32(b, s.Source) // Source ID type 0 (ifIndex)
+	b = binary.BigEndian.AppendUint32(b, s.Rate)
+	b = binary.BigEndian.AppendUint32(b, s.Pool)
+	b = binary.BigEndian.AppendUint32(b, s.Drops)
+	b = binary.BigEndian.AppendUint32(b, s.Source) // Input interface
+	b = binary.BigEndian.AppendUint32(b, 0)        // Output interface unknown
+	b = binary.BigEndian.AppendUint32(b, 1)        // Number of records
+
+	b = binary.BigEndian.AppendUint32(b, formatRawHeader)
+	b = binary.BigEndian.AppendUint32(b, uint32(record))
+	b = binary.BigEndian.AppendUint32(b, s.Protocol)
+	b = binary.BigEndian.AppendUint32(b, s.FrameLength)
+	b = binary.BigEndian.AppendUint32(b, 0) // Bytes stripped
+	b = binary.BigEndian.AppendUint32(b, uint32(len(header)))
+	b = append(b, header...)
+	return append(b, make([]byte, padded-len(header))...)
+}
@@ -0,0 +1,77 @@
+package sflow
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestEncode(t *testing.T) {
+	enc := NewEncoder(netip.MustParseAddr("192.0.2.10"), 3)
+	header := []byte{0x45, 0, 0, 33, 1, 2, 3, 4, 5}
+	dgrams := enc.Encode([]FlowSample{
+		{Source: 1, Rate: 100, Pool: 250, FrameLength: 1228, Protocol: HeaderIPv4, Header: header},
+		{Source: 1, Rate: 100, Pool: 300, FrameLength: 80, Protocol: HeaderIPv4, Header: header},
+	}, time.Now())
+	if len(dgrams) != 1 {
+		t.Fatalf("%d datagrams", len(dgrams))
+	}
+	d := dgrams[0]
+	u32 := func(off int) uint32 { return binary.BigEndian.Uint32(d[off:]) }
+	if u32(0) != 5 || u32(4) != 1 || netip.AddrFrom4([4]byte(d[8:12])).String() != "192.0.2.10" {
+		t.Errorf("header %x", d[:12])
+	}
+	if u32(12) != 3 || u32(16) != 1 || u32(24) != 2 {
+		t.Errorf("sub-agent %d, sequence %d, samples %d", u32(12), u32(16), u32(24))
+	}
+
+	// Flow sample: sequence, source, rate, pool, then the raw header record
+	s := 28
+	if u32(s) != 1 || int(u32(s+4)) != sampleOverhead-8+12 {
+		t.Errorf("sample format %d, length %d", u32(s), u32(s+4))
+	}
+	if u32(s+8) != 1 || u32(s+12) != 1 || u32(s+16) != 100 || u32(s+20) != 250 {
+		t.Errorf("sequence %d, source %d, rate %d, pool %d", u32(s+8), u32(s+12), u32(s+16), u32(s+20))
+	}
+	r := s + 8 + 8*4
+	if u32(r) != 1 || u32(r+8) != HeaderIPv4 || u32(r+12) != 1228 || u32(r+20) != 9 {
+		t.Errorf("record format %d, protocol %d, frame %d, header %d", u32(r), u32(r+8), u32(r+12), u32(r+20))
+	}
+	if !bytes.Equal(d[r+24:r+33], header) || !bytes.Equal(d[r+33:r+36], []byte{0, 0, 0}) {
+		t.Errorf("header bytes %x", d[r+24:r+36])
+	}
+	if next := 28 + 8 + int(u32(32)); u32(next+8) != 2 {
+		t.Errorf("second sample sequence %d", u32(next+8))
+	}
+}
+
+func TestEncode_Split(t *testing.T) {
+	enc := NewEncoder(netip.MustParseAddr("2001:db8::10"), 0)
+	samples := make([]FlowSample, 20)
+	for i := range samples {
+		samples[i] = FlowSample{Source: 2, Rate: 1, Protocol: HeaderIPv6, Header: make([]byte, 128)}
+	}
+	samples[0].Header = make([]byte, 4000)
+	dgrams := enc.Encode(samples, time.Now())
+	if len(dgrams) < 2 {
+		t.Fatalf("%d datagrams for 20 samples", len(dgrams))
+	}
+	total := uint32(0)
+	for i, d := range dgrams {
+		if len(d) > MaxDatagram {
+			t.Errorf("datagram of %d bytes", len(d))
+		}
+		if seq := binary.BigEndian.Uint32(d[28:]); seq != uint32(i+1) {
+			t.Errorf("datagram %d: sequence %d", i, seq)
+		}
+		total += binary.BigEndian.Uint32(d[36:])
+	}
+	if total != 20 {
+		t.Errorf("%d samples sent", total)
+	}
+	if len(enc.Encode(nil, time.Now())) != 0 {
+		t.Error("datagram without samples")
+	}
+}
@@ -0,0 +1,133 @@
+// Package shardmap is a concurrent map split into shards by key hash, so
+// goroutines storing and deleting different keys rarely wait for each other.
+// sync.Map suits keys written once and read often; under connection churn,
+// where every session adds and removes keys, its single dirty map becomes
+// the bottleneck.
+package shardmap
+
+import (
+	"hash/maphash"
+	"sync"
+)
+
+const shards = 64
+
+var seed = maphash.MakeSeed()
+
+// Map is a map from keys of type K to values of type V, safe for concurrent
+// use. The zero Map is empty and ready to use.
+type Map[K comparable, V any] struct {
+	shards [shards]shard[K, V]
+}
+
+type shard[K comparable, V any] struct {
+	mu sync.RWMutex
+	m  map[K]V
+	_  [32]byte // Keeps shards on separate cache lines
+}
+
+func (m *Map[K, V]) shard(key K) *shard[K, V] {
+	return &m.shards[maphash.Comparable(seed, key)%shards]
+}
+
+// Load returns the value stored for key.
+func (m *Map[K, V]) Load(key K) (V, bool) {
+	s := m.shard(key)
+	s.mu.RLock()
+	v, ok := s.m[key]
+	s.mu.RUnlock()
+	return v, ok
+}
+
+// Store sets the value for key.
+func (m *Map[K, V]) Store(key K, v V) {
+	s := m.shard(key)
+	s.mu.Lock()
+	if s.m == nil {
+		s.m = make(map[K]V)
+	}
+	s.m[key] = v
+	s.mu.Unlock()
+}
+
+// LoadOrStore returns the value stored for key, or stores and returns v if
+// there is none. loaded reports whether the value was already stored.
+func (m *Map[K, V]) LoadOrStore(key K, v V) (actual V, loaded bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if actual, loaded = s.m[key]; loaded {
+		return actual, true
+	}
+	if s.m == nil {
+		s.m = make(map[K]V)
+	}
+	s.m[key] = v
+	return v, false
+}
+
+// LoadAndDelete deletes the value for key, returning it if there was one.
+func (m *Map[K, V]) LoadAndDelete(key K) (V, bool) {
+	s := m.shard(key)
+	s.mu.Lock()
+	v, ok := s.m[key]
+	delete(s.m, key)
+	s.mu.Unlock()
+	return v, ok
+}
+
+// Delete deletes the value for key.
+func (m *Map[K, V]) Delete(key K) {
+	m.LoadAndDelete(key)
+}
+
+// DeleteFunc deletes the value for key if del returns true for it. del runs
+// with the key's shard locked and must not use the map.
+func (m *Map[K, V]) DeleteFunc(key K, del func(V) bool) bool {
+	s := m.shard(key)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	v, ok := s.m[key]
+	if !ok || !del(v) {
+		return false
+	}
+	delete(s.m, key)
+	return true
+}
+
+// Range calls fn for each key and value until fn returns false. It copies a
+// shard before calling fn for its entries, so fn may use the map; entries
+// stored or deleted meanwhile may or may not be seen.
+func (m *Map[K, V]) Range(fn func(key K, v V) bool) {
+	type entry struct {
+		key K
+		v   V
+	}
+	var entries []entry
+	for i := range m.shards {
+		s := &m.shards[i]
+		entries = entries[:0]
+		s.mu.RLock()
+		for k, v := range s.m {
+			entries = append(entries, entry{k, v})
+		}
+		s.mu.RUnlock()
+		for _, e := range entries {
+			if !fn(e.key, e.v) {
+				return
+			}
+		}
+	}
+}
+
+// Len returns the number of keys.
+func (m *Map[K, V]) Len() int {
+	n := 0
+	for i := range m.shards {
+		s := &m.shards[i]
+		s.mu.RLock()
+		n += len(s.m)
+		s.mu.RUnlock()
+	}
+	return n
+}
@@ -0,0 +1,146 @@
+package shardmap
+
+import (
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestMap(t *testing.T) {
+	var m Map[string, int]
+	if _, ok := m.Load("a"); ok {
+		t.Error("zero map not empty")
+	}
+	m.Store("a", 1)
+	if v, loaded := m.LoadOrStore("a", 2); !loaded || v != 1 {
+		t.Errorf("LoadOrStore(a) = %d, %v", v, loaded)
+	}
+	if v, loaded := m.LoadOrStore("b", 2); loaded || v != 2 {
+		t.Errorf("LoadOrStore(b) = %d, %v", v, loaded)
+	}
+	if m.DeleteFunc("b", func(v int) bool { return v == 3 }) || !m.DeleteFunc("b", func(v int) bool { return v == 2 }) {
+		t.Error("DeleteFunc")
+	}
+	if v, ok := m.LoadAndDelete("a"); !ok || v != 1 {
+		t.Errorf("LoadAndDelete(a) = %d, %v", v, ok)
+	}
+	if m.Len() != 0 {
+		t.Errorf("Len = %d", m.Len())
+	}
+
+	// Range sees every key and may delete while it runs
+	for i := range 1000 {
+		m.Store(strconv.Itoa(i), i)
+	}
+	sum := 0
+	m.Range(func(key string, v int) bool {
+		sum += v
+		m.Delete(key)
+		return true
+	})
+	if sum != 999*1000/2 || m.Len() != 0 {
+		t.Errorf("Range: sum %d, %d left", sum, m.Len())
+	}
+	for i := range 10 {
+		m.Store(strconv.Itoa(i), i)
+	}
+	n := 0
+	m.Range(func(string, int) bool {
+		n++
+		return n < 3
+	})
+	if n != 3 {
+		t.Errorf("Range went on after false: %d calls", n)
+	}
+}
+
+func TestMap_Concurrent(t *testing.T) {
+	var m Map[string, int]
+	var wg sync.WaitGroup
+	for g := range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range 1000 {
+				key := strconv.Itoa(g*1000 + i)
+				m.Store(key, i)
+				if v, ok := m.Load(key); !ok || v != i {
+					t.Errorf("Load(%s) = %d, %v", key, v, ok)
+				}
+				if i%2 == 0 {
+					m.Delete(key)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	if m.Len() != 8*500 {
+		t.Errorf("Len = %d, want %d", m.Len(), 8*500)
+	}
+}
+
+// The benchmarks model session churn: each operation opens a session (a
+// store), looks it up a few times as packets arrive and closes it.
+
+func BenchmarkChurn_ShardMap(b *testing.B) {
+	var m Map[string, *int]
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		v := new(int)
+		for pb.Next() {
+			key := strconv.FormatInt(next.Add(1), 36)
+			m.Store(key, v)
+			for range 4 {
+				m.Load(key)
+			}
+			m.Delete(key)
+		}
+	})
+}
+
+func BenchmarkChurn_SyncMap(b *testing.B) {
+	var m sync.Map
+	var next atomic.Int64
+	b.RunParallel(func(pb *testing.PB) {
+		v := new(int)
+		for pb.Next() {
+			key := strconv.FormatInt(next.Add(1), 36)
+			m.Store(key, v)
+			for range 4 {
+				m.Load(key)
+			}
+			m.Delete(key)
+		}
+	})
+}
+
+func BenchmarkLoad_ShardMap(b *testing.B) {
+	var m Map[string, *int]
+	for i := range 100000 {
+		m.Store(strconv.Itoa(i), new(int))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m.Load(strconv.Itoa(i % 100000))
+			i++
+		}
+	})
+}
+
+func BenchmarkLoad_SyncMap(b *testing.B) {
+	var m sync.Map
+	for i := range 100000 {
+		m.Store(strconv.Itoa(i), new(int))
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			m.Load(strconv.Itoa(i % 100000))
+			i++
+		}
+	})
+}
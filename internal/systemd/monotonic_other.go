@@ -0,0 +1,8 @@
+//go:build !linux
+
+package systemd
+
+// monotonicUsec returns 0; systemd only runs on Linux.
+func monotonicUsec() int64 {
+	return 0
+}
@@ -0,0 +1,144 @@
+// Package systemd implements the parts of the systemd service protocol the relay
+// uses: socket activation (LISTEN_FDS) and readiness notification (sd_notify),
+// including watchdog pings. Everything is a no-op when not started by systemd.
+package systemd
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// listenFDsStart is the first file descriptor passed by socket activation.
+const listenFDsStart = 3
+
+// Notification states understood by systemd.
+const (
+	StateReady     = "READY=1"
+	StateReloading = "RELOADING=1"
+	StateStopping  = "STOPPING=1"
+	StateWatchdog  = "WATCHDOG=1"
+)
+
+// Listeners returns the UDP sockets passed by systemd socket activation, in the
+// order of the ListenDatagram= lines of the socket unit. It returns nil when the
+// process was not socket activated. The environment variables are cleared so
+// child processes do not inherit them.
+func Listeners() ([]*net.UDPConn, error) {
+	defer func() {
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		os.Unsetenv("LISTEN_FDNAMES")
+	}()
+
+	pid, err := strconv.Atoi(os.Getenv("LISTEN_PID"))
+	if err != nil || pid != os.Getpid() {
+		return nil, nil
+	}
+	count, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || count <= 0 {
+		return nil, nil
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+
+	var conns []*net.UDPConn
+	for i := range count {
+		name := fmt.Sprintf("fd%d", listenFDsStart+i)
+		if i < len(names) && names[i] != "" {
+			name = names[i]
+		}
+		f := os.NewFile(uintptr(listenFDsStart+i), name)
+		pc, err := net.FilePacketConn(f)
+		f.Close() // FilePacketConn dups the descriptor
+		if err != nil {
+			closeAll(conns)
+			return nil, fmt.Errorf("socket %s: %w", name, err)
+		}
+		conn, ok := pc.(*net.UDPConn)
+		if !ok {
+			pc.Close()
+			closeAll(conns)
+			return nil, fmt.Errorf("socket %s is not a UDP socket", name)
+		}
+		conns = append(conns, conn)
+	}
+	return conns, nil
+}
+
+func closeAll(conns []*net.UDPConn) {
+	for _, c := range conns {
+		c.Close()
+	}
+}
+
+// Notify sends state to the service manager. It returns false without error
+// when NOTIFY_SOCKET is not set. RELOADING=1 is sent with the MONOTONIC_USEC
+// timestamp that Type=notify-reload requires.
+func Notify(state string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // Abstract namespace
+	}
+	if state == StateReloading {
+		if usec := monotonicUsec(); usec > 0 {
+			state += "\nMONOTONIC_USEC=" + strconv.FormatInt(usec, 10)
+		}
+	}
+
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	if _, err := conn.Write([]byte(state)); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// WatchdogInterval returns the watchdog timeout configured with WatchdogSec=,
+// or 0 when the watchdog is disabled or meant for another process.
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// RunWatchdog pings the watchdog at half the configured interval until ctx is
+// done. A ping is skipped when healthy returns an error, so systemd restarts a
+// relay that has stopped processing packets. It returns immediately when the
+// watchdog is disabled.
+func RunWatchdog(ctx context.Context, healthy func() error, onError func(error)) {
+	interval := WatchdogInterval()
+	if interval == 0 {
+		return
+	}
+	ticker := time.NewTicker(interval / 2)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := healthy(); err != nil {
+			onError(err)
+			continue
+		}
+		if _, err := Notify(StateWatchdog); err != nil {
+			onError(err)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package systemd
+
+import (
+	"context"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// listenNotify opens a unixgram socket and points NOTIFY_SOCKET at it.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Skipf("unixgram not supported: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+func readNotify(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 256)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("read notification: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	if sent, err := Notify(StateReady); sent || err != nil {
+		t.Errorf("Notify without socket = %v, %v", sent, err)
+	}
+
+	conn := listenNotify(t)
+	if sent, err := Notify(StateReady); !sent || err != nil {
+		t.Fatalf("Notify = %v, %v", sent, err)
+	}
+	if got := readNotify(t, conn); got != StateReady {
+		t.Errorf("got %q, want %q", got, StateReady)
+	}
+
+	if _, err := Notify(StateReloading); err != nil {
+		t.Fatal(err)
+	}
+	got := readNotify(t, conn)
+	if !strings.HasPrefix(got, StateReloading) {
+		t.Errorf("got %q, want %q", got, StateReloading)
+	}
+	if runtime.GOOS == "linux" && !strings.Contains(got, "\nMONOTONIC_USEC=") {
+		t.Errorf("reloading notification %q has no MONOTONIC_USEC", got)
+	}
+}
+
+func TestListeners_NotActivated(t *testing.T) {
+	t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+	t.Setenv("LISTEN_FDS", "1")
+	conns, err := Listeners()
+	if err != nil || conns != nil {
+		t.Errorf("Listeners = %v, %v; want nothing for another pid", conns, err)
+	}
+	if os.Getenv("LISTEN_FDS") != "" {
+		t.Error("LISTEN_FDS not cleared")
+	}
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		usec, pid string
+		want      time.Duration
+	}{
+		{"", "", 0},
+		{"junk", "", 0},
+		{"2000000", "", 2 * time.Second},
+		{"2000000", strconv.Itoa(os.Getpid()), 2 * time.Second},
+		{"2000000", strconv.Itoa(os.Getpid() + 1), 0},
+	}
+	for _, tt := range tests {
+		t.Setenv("WATCHDOG_USEC", tt.usec)
+		t.Setenv("WATCHDOG_PID", tt.pid)
+		if got := WatchdogInterval(); got != tt.want {
+			t.Errorf("WatchdogInterval(%q, %q) = %v, want %v", tt.usec, tt.pid, got, tt.want)
+		}
+	}
+}
+
+func TestRunWatchdog(t *testing.T) {
+	conn := listenNotify(t)
+	t.Setenv("WATCHDOG_USEC", "40000")
+	t.Setenv("WATCHDOG_PID", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	healthy := make(chan error, 1)
+	healthy <- errors.New("stalled")
+	failures := make(chan error, 1)
+	go RunWatchdog(ctx, func() error {
+		select {
+		case err := <-healthy:
+			return err
+		default:
+			return nil
+		}
+	}, func(err error) { failures <- err })
+
+	select {
+	case err := <-failures:
+		if err.Error() != "stalled" {
+			t.Errorf("failure = %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("unhealthy check not reported")
+	}
+	if got := readNotify(t, conn); got != StateWatchdog {
+		t.Errorf("got %q, want %q", got, StateWatchdog)
+	}
+}
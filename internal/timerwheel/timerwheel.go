@@ -0,0 +1,231 @@
+// Package timerwheel runs many timers on one goroutine. A hierarchical
+// timing wheel keeps starting, stopping and resetting a timer O(1), so the
+// per-session timers of the relay (idle timeouts, keep-alives, probation
+// windows, delayed packets) cost the same with 100 sessions as with 100k.
+//
+// Timers fire on the tick after they are due. Callbacks run on the wheel's
+// goroutine one after another and must not block; slow work belongs in a
+// goroutine of its own.
+package timerwheel
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultTick is the resolution of the shared wheel.
+const DefaultTick = time.Millisecond
+
+const (
+	slotBits = 6
+	slots    = 1 << slotBits
+	levels   = 6 // 64^6 ticks: over two years at DefaultTick
+)
+
+// maxTicks is the longest delay a wheel holds; longer ones are shortened.
+const maxTicks = 1<<(slotBits*levels) - 1
+
+// Timer calls its function once its delay has passed, unless stopped.
+type Timer struct {
+	w          *Wheel
+	fn         func()
+	when       uint64 // Tick the timer is due at
+	level      int    // -1 when not pending
+	slot       int
+	prev, next *Timer
+}
+
+// Wheel holds timers in levels of 64 slots. Level 0 has a slot per tick;
+// each slot of level n spans all of level n-1. Timers move down a level each
+// time the wheel reaches their slot, until they fire from level 0.
+type Wheel struct {
+	tick  time.Duration
+	start time.Time
+
+	mu      sync.Mutex
+	now     uint64 // Ticks since start the wheel has processed
+	pending int
+	wheel   [levels][slots]*Timer
+
+	done    chan struct{}
+	stopped chan struct{}
+}
+
+// New starts a wheel with the given resolution.
+func New(tick time.Duration) *Wheel {
+	w := &Wheel{
+		tick:    tick,
+		start:   time.Now(),
+		done:    make(chan struct{}),
+		stopped: make(chan struct{}),
+	}
+	go w.run()
+	return w
+}
+
+// Stop stops the wheel. Pending timers never fire.
+func (w *Wheel) Stop() {
+	close(w.done)
+	<-w.stopped
+}
+
+// Len returns the number of pending timers.
+func (w *Wheel) Len() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.pending
+}
+
+// AfterFunc calls fn on the wheel's goroutine once d has passed.
+func (w *Wheel) AfterFunc(d time.Duration, fn func()) *Timer {
+	t := w.NewTimer(fn)
+	w.mu.Lock()
+	w.add(t, d)
+	w.mu.Unlock()
+	return t
+}
+
+// NewTimer returns a timer that calls fn on the wheel's goroutine once Reset
+// starts it. A callback that resets its own timer should start it this way.
+func (w *Wheel) NewTimer(fn func()) *Timer {
+	return &Timer{w: w, fn: fn, level: -1}
+}
+
+// Stop prevents the timer from firing. Returns false if it already fired or
+// was stopped.
+func (t *Timer) Stop() bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	if t.level < 0 {
+		return false
+	}
+	t.w.remove(t)
+	return true
+}
+
+// Reset makes the timer fire once d has passed from now, whether or not it
+// is pending. Returns whether it was pending.
+func (t *Timer) Reset(d time.Duration) bool {
+	t.w.mu.Lock()
+	defer t.w.mu.Unlock()
+	pending := t.level >= 0
+	if pending {
+		t.w.remove(t)
+	}
+	t.w.add(t, d)
+	return pending
+}
+
+// add schedules t d from now. w.mu must be held.
+func (w *Wheel) add(t *Timer, d time.Duration) {
+	// The first tick at or after the current time plus d, so timers never
+	// fire early; counted from the clock, as w.now may lag behind
+	when := uint64((time.Since(w.start) + max(d, 0) + w.tick - 1) / w.tick)
+	t.when = min(max(when, w.now+1), w.now+maxTicks)
+	w.insert(t)
+	w.pending++
+}
+
+// insert puts t into the slot its due tick falls in. w.mu must be held.
+func (w *Wheel) insert(t *Timer) {
+	level := 0
+	if t.when > w.now {
+		for delta := t.when - w.now; delta >= slots; delta >>= slotBits {
+			level++
+		}
+	}
+	when := max(t.when, w.now)
+	t.level, t.slot = level, int(when>>(slotBits*level))&(slots-1)
+	head := &w.wheel[t.level][t.slot]
+	t.prev, t.next = nil, *head
+	if *head != nil {
+		(*head).prev = t
+	}
+	*head = t
+}
+
+// remove takes a pending t off its slot. w.mu must be held.
+func (w *Wheel) remove(t *Timer) {
+	if t.prev != nil {
+		t.prev.next = t.next
+	} else {
+		w.wheel[t.level][t.slot] = t.next
+	}
+	if t.next != nil {
+		t.next.prev = t.prev
+	}
+	t.prev, t.next, t.level = nil, nil, -1
+	w.pending--
+}
+
+func (w *Wheel) run() {
+	defer close(w.stopped)
+	ticker := time.NewTicker(w.tick)
+	defer ticker.Stop()
+	var due []*Timer
+	for {
+		select {
+		case <-ticker.C:
+		case <-w.done:
+			return
+		}
+		due = w.advance(uint64(time.Since(w.start)/w.tick), due[:0])
+		for i, t := range due {
+			t.fn()
+			due[i] = nil
+		}
+	}
+}
+
+// advance processes the ticks up to target and appends the timers that are
+// due to due.
+func (w *Wheel) advance(target uint64, due []*Timer) []*Timer {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.pending == 0 {
+		w.now = max(w.now, target)
+		return due
+	}
+	for w.now < target {
+		w.now++
+		// Higher levels first: their timers may land in a lower slot that
+		// is due now
+		for level := levels - 1; level > 0; level-- {
+			if w.now&(1<<(slotBits*level)-1) != 0 {
+				continue
+			}
+			slot := int(w.now>>(slotBits*level)) & (slots - 1)
+			t := w.wheel[level][slot]
+			w.wheel[level][slot] = nil
+			for t != nil {
+				next := t.next
+				t.prev, t.next = nil, nil
+				w.insert(t)
+				t = next
+			}
+		}
+		slot := int(w.now) & (slots - 1)
+		for t := w.wheel[0][slot]; t != nil; t = w.wheel[0][slot] {
+			w.remove(t)
+			due = append(due, t)
+		}
+	}
+	return due
+}
+
+var shared = sync.OnceValue(func() *Wheel { return New(DefaultTick) })
+
+// AfterFunc calls fn on the shared wheel's goroutine once d has passed.
+func AfterFunc(d time.Duration, fn func()) *Timer {
+	return shared().AfterFunc(d, fn)
+}
+
+// NewTimer returns a timer of the shared wheel that Reset starts.
+func NewTimer(fn func()) *Timer {
+	return shared().NewTimer(fn)
+}
+
+// Len returns the number of pending timers of the shared wheel.
+func Len() int {
+	return shared().Len()
+}
@@ -0,0 +1,135 @@
+package timerwheel
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestWheel_Fire(t *testing.T) {
+	w := New(100 * time.Microsecond)
+	defer w.Stop()
+
+	// Delays on the first three levels fire in order, none early
+	delays := []time.Duration{0, 300 * time.Microsecond, 7 * time.Millisecond, 15 * time.Millisecond, 420 * time.Millisecond}
+	var mu sync.Mutex
+	var fired []time.Duration
+	done := make(chan struct{})
+	start := time.Now()
+	for _, d := range delays {
+		w.AfterFunc(d, func() {
+			if elapsed := time.Since(start); elapsed < d {
+				t.Errorf("%s timer fired after %s", d, elapsed)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if fired = append(fired, d); len(fired) == len(delays) {
+				close(done)
+			}
+		})
+	}
+	if w.Len() != len(delays) {
+		t.Errorf("Len = %d", w.Len())
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatalf("fired %v", fired)
+	}
+	for i, d := range fired {
+		if d != delays[i] {
+			t.Fatalf("fired in order %v", fired)
+		}
+	}
+	if w.Len() != 0 {
+		t.Errorf("Len = %d after firing", w.Len())
+	}
+}
+
+func TestTimer_StopReset(t *testing.T) {
+	w := New(time.Millisecond)
+	defer w.Stop()
+
+	fired := make(chan string, 3)
+	stopped := w.AfterFunc(20*time.Millisecond, func() { fired <- "stopped" })
+	if !stopped.Stop() || stopped.Stop() {
+		t.Error("Stop of a pending timer")
+	}
+	reset := w.AfterFunc(time.Hour, func() { fired <- "reset" })
+	if !reset.Reset(10 * time.Millisecond) {
+		t.Error("Reset of a pending timer returned false")
+	}
+	select {
+	case name := <-fired:
+		if name != "reset" {
+			t.Fatalf("%s timer fired", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("reset timer did not fire")
+	}
+
+	// A fired timer can be reset, also from its own callback
+	var n int
+	var again *Timer
+	again = w.NewTimer(func() {
+		if n++; n < 3 {
+			again.Reset(time.Millisecond)
+		}
+		fired <- "again"
+	})
+	again.Reset(time.Millisecond)
+	for range 3 {
+		select {
+		case <-fired:
+		case <-time.After(5 * time.Second):
+			t.Fatal("rearmed timer did not fire")
+		}
+	}
+	if again.Stop() {
+		t.Error("Stop of a fired timer returned true")
+	}
+}
+
+func TestWheel_Cascade(t *testing.T) {
+	// Drive the wheel by hand through several level boundaries
+	w := &Wheel{tick: time.Hour, start: time.Now()}
+	want := []uint64{1, 63, 64, 65, 4095, 4096, 4097, 300000}
+	for _, when := range want {
+		w.insert(&Timer{w: w, when: when, level: -1})
+		w.pending++
+	}
+	const step = 997
+	var got []uint64
+	for target := uint64(0); target < 300000+step; target += step {
+		for _, timer := range w.advance(target, nil) {
+			if timer.when > target || target-timer.when >= step {
+				t.Errorf("timer due at %d fired at %d", timer.when, target)
+			}
+			got = append(got, timer.when)
+		}
+	}
+	if len(got) != len(want) {
+		t.Fatalf("fired %v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("fired %v, want %v", got, want)
+		}
+	}
+	if w.pending != 0 {
+		t.Errorf("pending = %d", w.pending)
+	}
+}
+
+func BenchmarkTimer_Reset(b *testing.B) {
+	w := New(DefaultTick)
+	defer w.Stop()
+	timers := make([]*Timer, 100000)
+	for i := range timers {
+		timers[i] = w.AfterFunc(time.Duration(i)*time.Millisecond+time.Minute, func() {})
+	}
+	b.ResetTimer()
+	for i := range b.N {
+		timers[i%len(timers)].Reset(time.Minute)
+	}
+}